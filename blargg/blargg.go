@@ -0,0 +1,117 @@
+// Package blargg runs blargg's NES test ROMs headlessly and collects
+// their results. The newer ROMs report through cartridge RAM: $6000
+// holds the status, $6001-$6003 the signature DE B0 61, and a NUL
+// terminated message starts at $6004.
+// https://github.com/christopherpow/nes-test-roms
+package blargg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/mappers"
+)
+
+const (
+	STATUS_RUNNING     = 0x80 // The test is still going
+	STATUS_NEEDS_RESET = 0x81 // Press reset after at least 100ms
+
+	STATUS_ADDR = 0x6000
+	TEXT_ADDR   = 0x6004
+)
+
+var signature = []uint8{0xDE, 0xB0, 0x61}
+
+// FRAME_TICKS is the number of PPU ticks in an NTSC frame.
+const FRAME_TICKS = 341 * 262
+
+// MaxFrames is how long Run lets a ROM go before giving up on it: a
+// minute of emulated time.
+var MaxFrames = 60 * 60
+
+// resetFrames is how long Run waits before pressing reset when a ROM
+// asks for it.
+const resetFrames = 10
+
+// ErrNoResult is returned by Run for ROMs that never reported a
+// result at $6000, either because they're older ROMs that only report
+// on screen or because they hung.
+var ErrNoResult = errors.New("test ROM didn't report a result")
+
+// Result is what a test ROM reported when it finished.
+type Result struct {
+	Status uint8  // 0 for a pass, otherwise an error code
+	Text   string // The message the ROM printed
+}
+
+// Passed reports whether the ROM passed.
+func (r Result) Passed() bool {
+	return r.Status == 0
+}
+
+func (r Result) String() string {
+	if r.Passed() {
+		return fmt.Sprintf("passed: %s", r.Text)
+	}
+	return fmt.Sprintf("failed with code %d: %s", r.Status, r.Text)
+}
+
+// Run loads the test ROM in romFile and runs it until it reports a
+// result, pressing reset when it asks.
+func Run(romFile string) (Result, error) {
+	m, err := mappers.Load(romFile)
+	if err != nil {
+		return Result{}, err
+	}
+	b := core.New(m)
+
+	resetAt := -1
+	for f := 0; f < MaxFrames; f++ {
+		b.RunTicks(FRAME_TICKS)
+
+		if !hasSignature(b) {
+			continue
+		}
+
+		switch s := b.Read(STATUS_ADDR); {
+		case s == STATUS_RUNNING:
+			resetAt = -1
+		case s == STATUS_NEEDS_RESET:
+			if resetAt < 0 {
+				resetAt = f + resetFrames
+			}
+			if f == resetAt {
+				b.Reset()
+			}
+		case s < STATUS_RUNNING:
+			return Result{Status: s, Text: readText(b)}, nil
+		}
+	}
+
+	return Result{}, fmt.Errorf("%w after %d frames", ErrNoResult, MaxFrames)
+}
+
+func hasSignature(b *core.Console) bool {
+	for i, v := range signature {
+		if b.Read(STATUS_ADDR+1+uint16(i)) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// readText returns the ROM's message, which is at most what's left of
+// the 8KB of cartridge RAM.
+func readText(b *core.Console) string {
+	var buf bytes.Buffer
+	for a := uint16(TEXT_ADDR); a < 0x8000; a++ {
+		v := b.Read(a)
+		if v == 0 {
+			break
+		}
+		buf.WriteByte(v)
+	}
+	return buf.String()
+}
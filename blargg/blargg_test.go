@@ -0,0 +1,112 @@
+package blargg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/bdwalton/gintendo/mappers"
+	"github.com/bdwalton/gintendo/mos6502/asm"
+	"github.com/bdwalton/gintendo/nesrom"
+)
+
+// The suites, each a directory of ROMs under testdata/blargg. They
+// aren't distributed with gintendo; copy them from nes-test-roms to
+// run them.
+var suites = []string{
+	"cpu_timing",
+	"instr_test",
+	"ppu_vbl_nmi",
+	"sprite_hit",
+	"apu_test",
+}
+
+func TestBlargg(t *testing.T) {
+	for _, s := range suites {
+		t.Run(s, func(t *testing.T) {
+			roms, _ := filepath.Glob(filepath.Join("../testdata/blargg", s, "*.nes"))
+			if len(roms) == 0 {
+				t.Skipf("no ROMs in testdata/blargg/%s", s)
+			}
+			sort.Strings(roms)
+
+			for _, rom := range roms {
+				rom := rom
+				t.Run(filepath.Base(rom), func(t *testing.T) {
+					r, err := Run(rom)
+					switch {
+					case errors.Is(err, mappers.ErrUnknownMapper):
+						t.Skip(err)
+					case err != nil:
+						t.Fatal(err)
+					case !r.Passed():
+						t.Error(r)
+					}
+				})
+			}
+		})
+	}
+}
+
+// TestRunPassed runs ram_after_reset.nes, from blargg's cpu_reset
+// suite, which is distributed with gintendo. It asks for a reset
+// partway through, so this covers that too.
+func TestRunPassed(t *testing.T) {
+	r, err := Run("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if !r.Passed() {
+		t.Errorf("Run() = %v, wanted a pass", r)
+	}
+}
+
+// failingROM is an NROM image that reports failure code 3 with the
+// message "FAIL", the way blargg's ROMs do.
+const failingROM = `
+reset:	LDA #$80
+	STA $6000
+	LDA #$DE
+	STA $6001
+	LDA #$B0
+	STA $6002
+	LDA #$61
+	STA $6003
+	LDX #0
+copy:	LDA msg,X
+	STA $6004,X
+	BEQ done
+	INX
+	BNE copy
+done:	LDA #$03
+	STA $6000
+loop:	JMP loop
+msg:	.byte $46, $41, $49, $4C, 0
+`
+
+func TestRunFailed(t *testing.T) {
+	code, err := asm.Assemble(failingROM, 0xC000)
+	if err != nil {
+		t.Fatalf("couldn't assemble the ROM: %v", err)
+	}
+	prg := make([]byte, nesrom.PRG_BLOCK_SIZE)
+	copy(prg, code)
+	prg[0x3FFC], prg[0x3FFD] = 0x00, 0xC0 // Reset vector
+
+	rom := append(nesrom.HeaderBytes(1, 1, nesrom.CartInfo{}), prg...)
+	rom = append(rom, make([]byte, nesrom.CHR_BLOCK_SIZE)...)
+	f := filepath.Join(t.TempDir(), "fail.nes")
+	if err := os.WriteFile(f, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Run(f)
+	if err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if want := (Result{Status: 3, Text: "FAIL"}); r != want {
+		t.Errorf("Run() = %+v, wanted %+v", r, want)
+	}
+}
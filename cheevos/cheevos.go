@@ -0,0 +1,152 @@
+// Package cheevos awards RetroAchievements
+// (https://retroachievements.org) as a game is played. It identifies
+// games by the RetroAchievements hash, fetches their achievements
+// from the server and tests each one's memory conditions after every
+// frame, as the rcheevos library used by other emulators does.
+//
+// Unlocks are only reported to the player; they aren't submitted to
+// the server.
+package cheevos
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/frontend"
+)
+
+// Achievement flags, saying which set an achievement belongs to.
+const (
+	FLAG_CORE       = 3
+	FLAG_UNOFFICIAL = 5
+)
+
+// Hash returns the RetroAchievements hash of a ROM or Disk System
+// image: the MD5 of the file without its 16 byte iNES or fwNES
+// header.
+func Hash(data []byte) string {
+	if bytes.HasPrefix(data, []byte("NES\x1a")) || bytes.HasPrefix(data, []byte("FDS\x1a")) {
+		data = data[16:]
+	}
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Achievement is one of a game's achievements, as the server
+// describes it.
+type Achievement struct {
+	ID          int
+	Title       string
+	Description string
+	Points      int
+	MemAddr     string // the conditions for unlocking it
+	Flags       int    // FLAG_CORE or FLAG_UNOFFICIAL
+
+	Unlocked bool `json:"-"`
+
+	trigger *trigger
+	primed  bool // its conditions have been false, so it can unlock
+}
+
+// Set is the achievements for a game.
+type Set struct {
+	ID           int
+	Title        string
+	Achievements []*Achievement
+
+	mem memory
+}
+
+// ReadSet reads a game's achievements from r, which holds the
+// server's reply to a patch request. Achievements with conditions
+// that can't be understood are logged and left out, as are
+// unofficial ones.
+func ReadSet(r io.Reader) (*Set, error) {
+	var reply struct {
+		response
+		PatchData *Set
+	}
+	if err := json.NewDecoder(r).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("couldn't read achievements: %w", err)
+	}
+	if err := reply.err(); err != nil {
+		return nil, err
+	}
+	if reply.PatchData == nil {
+		return nil, fmt.Errorf("no achievements in reply")
+	}
+
+	s := reply.PatchData
+	s.mem = newMemory()
+	var keep []*Achievement
+	for _, a := range s.Achievements {
+		if a.Flags != FLAG_CORE {
+			continue
+		}
+		t, err := parseTrigger(a.MemAddr)
+		if err != nil {
+			log.Printf("Skipping achievement %d (%s): %v", a.ID, a.Title, err)
+			continue
+		}
+		a.trigger = t
+		keep = append(keep, a)
+	}
+	s.Achievements = keep
+
+	return s, nil
+}
+
+// Frame tests the achievements still locked against memory as peek
+// reads it, which should be once a frame, returning any that unlock.
+func (s *Set) Frame(peek func(uint16) uint8) []*Achievement {
+	s.mem.peek = peek
+
+	var unlocked []*Achievement
+	for _, a := range s.Achievements {
+		if a.Unlocked {
+			continue
+		}
+		// Achievements must be seen false first, so that
+		// they don't unlock just because a game was loaded
+		// or a state restored.
+		switch ok := a.trigger.test(&s.mem); {
+		case !ok:
+			a.primed = true
+		case a.primed:
+			a.Unlocked = true
+			unlocked = append(unlocked, a)
+		}
+	}
+	s.mem.endFrame()
+
+	return unlocked
+}
+
+// Runner tests a Set's achievements after every frame run by Next.
+type Runner struct {
+	Next     frontend.Runner
+	Console  *core.Console
+	Set      *Set
+	Unlocked func(*Achievement) // Told about each achievement unlocked
+}
+
+func (r *Runner) RunFrame(in core.Inputs) ([]byte, []int16, error) {
+	video, audio, err := r.Next.RunFrame(in)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, a := range r.Set.Frame(r.Console.Peek) {
+		if r.Unlocked != nil {
+			r.Unlocked(a)
+		}
+	}
+
+	return video, audio, nil
+}
@@ -0,0 +1,225 @@
+package cheevos
+
+import (
+	"strings"
+	"testing"
+)
+
+// fakeRAM is memory for conditions to read.
+type fakeRAM [0x10000]uint8
+
+func (r *fakeRAM) peek(addr uint16) uint8 {
+	return r[addr]
+}
+
+func TestHash(t *testing.T) {
+	body := []byte("some PRG")
+	want := Hash(body)
+	if len(want) != 32 {
+		t.Fatalf("Hash() = %q, want 32 hex digits", want)
+	}
+
+	for _, magic := range []string{"NES\x1a", "FDS\x1a"} {
+		rom := append([]byte(magic+"headerheader"), body...)
+		if got := Hash(rom); got != want {
+			t.Errorf("Hash(%q header) = %q, want %q (the hash without it)", magic, got, want)
+		}
+	}
+}
+
+func TestParseTrigger(t *testing.T) {
+	cases := []struct {
+		s       string
+		wantErr bool
+	}{
+		{"0xH0010=1", false},
+		{"0xH0010=1_0x 0020>h100.5.", false},
+		{"R:d0xH0010>0xH0010_P:0xS0030=1", false},
+		{"A:0xH0001*2_0xH0002=10", false},
+		{"0xH0010=1S0xH0011=1S0xH0012=1", false},
+		{"N:0xH0001=1", true}, // ends with a modifier
+		{"Z:0xH0001=1", true},
+		{"0xH0010=1.5", true},
+		{"0xY0010=1", true},
+		{"d5=5", true},
+	}
+
+	for i, tc := range cases {
+		_, err := parseTrigger(tc.s)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%d: parseTrigger(%q) = %v, want error: %t", i, tc.s, err, tc.wantErr)
+		}
+	}
+
+	tr, err := parseTrigger("0xH0010=1S0xS0011=1S0xH0012=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.alts) != 2 {
+		t.Errorf("got %d alternative groups, want 2 (0xS is a bit, not a separator)", len(tr.alts))
+	}
+}
+
+// frames runs a trigger over frames, setting the RAM for each with
+// set, and returns whether it was true on each.
+func frames(t *testing.T, s string, n int, set func(f int, r *fakeRAM)) []bool {
+	t.Helper()
+
+	tr, err := parseTrigger(s)
+	if err != nil {
+		t.Fatalf("parseTrigger(%q) = %v", s, err)
+	}
+
+	var ram fakeRAM
+	m := newMemory()
+	m.peek = ram.peek
+	var got []bool
+	for f := 0; f < n; f++ {
+		set(f, &ram)
+		got = append(got, tr.test(&m))
+		m.endFrame()
+	}
+
+	return got
+}
+
+func TestTrigger(t *testing.T) {
+	cases := []struct {
+		name string
+		s    string
+		set  func(f int, r *fakeRAM)
+		want string // . for false and T for true, a frame each
+	}{
+		{
+			"compare", "0xH0010=2",
+			func(f int, r *fakeRAM) { r[0x10] = uint8(f) },
+			"..T..",
+		},
+		{
+			"16 bit and mirrors", "0x 0810=h0201",
+			func(f int, r *fakeRAM) { r[0x810], r[0x811] = 1, uint8(f) },
+			"..T..",
+		},
+		{
+			"registers read as 0", "0xH2002=0",
+			func(f int, r *fakeRAM) { r[0x2002] = 0x80 },
+			"TTT",
+		},
+		{
+			"delta", "0xH0010>d0xH0010",
+			func(f int, r *fakeRAM) { r[0x10] = []uint8{0, 1, 1, 3, 2}[f] },
+			".T.T.",
+		},
+		{
+			"prior", "p0xH0010=7",
+			func(f int, r *fakeRAM) { r[0x10] = []uint8{7, 7, 1, 1, 2}[f] },
+			"..TT.",
+		},
+		{
+			"bits and bcd", "0xM0010=1_b0xH0011=12",
+			func(f int, r *fakeRAM) { r[0x10], r[0x11] = uint8(f), 0x12 },
+			".T.T",
+		},
+		{
+			"hits", "0xH0010=1.3.",
+			func(f int, r *fakeRAM) { r[0x10] = []uint8{1, 0, 1, 0, 1, 0}[f] },
+			"....TT",
+		},
+		{
+			"reset if", "0xH0010=1.2._R:0xH0011=1",
+			func(f int, r *fakeRAM) {
+				r[0x10] = 1
+				r[0x11] = []uint8{0, 1, 0, 0, 0}[f]
+			},
+			"...TT",
+		},
+		{
+			"pause if keeps hits", "0xH0010=1.2._P:0xH0011=1",
+			func(f int, r *fakeRAM) {
+				r[0x10] = 1
+				r[0x11] = []uint8{0, 1, 1, 0}[f]
+			},
+			"...T",
+		},
+		{
+			"add source", "A:0xH0010_B:0xH0011_0xH0012=5",
+			func(f int, r *fakeRAM) { r[0x10], r[0x11], r[0x12] = uint8(f)+5, 1, 0 },
+			".T.",
+		},
+		{
+			"and next", "N:0xH0010=1_0xH0011=1",
+			func(f int, r *fakeRAM) { r[0x10], r[0x11] = uint8(f&1), uint8(f>>1) },
+			"...T",
+		},
+		{
+			"or next", "O:0xH0010=1_0xH0011=1",
+			func(f int, r *fakeRAM) { r[0x10], r[0x11] = uint8(f&1), uint8(f>>1) },
+			".TTT",
+		},
+		{
+			"add hits", "C:0xH0010=1_0xH0011=1.2.",
+			func(f int, r *fakeRAM) { r[0x10] = []uint8{1, 1, 0}[f] },
+			".TT",
+		},
+		{
+			"add address", "I:0xH0010_0xH0020=9",
+			func(f int, r *fakeRAM) { r[0x10], r[0x21] = uint8(f), 9 },
+			".T.",
+		},
+		{
+			"alternatives", "0xH0010=1S0xH0011=1S0xH0012=1",
+			func(f int, r *fakeRAM) { r[0x10], r[0x11], r[0x12] = 1, uint8(f&1), uint8(f>>1) },
+			".TTT",
+		},
+	}
+
+	for _, tc := range cases {
+		var sb strings.Builder
+		for _, ok := range frames(t, tc.s, len(tc.want), tc.set) {
+			if ok {
+				sb.WriteByte('T')
+			} else {
+				sb.WriteByte('.')
+			}
+		}
+		if got := sb.String(); got != tc.want {
+			t.Errorf("%s: %q was %s, want %s", tc.name, tc.s, got, tc.want)
+		}
+	}
+}
+
+const patch = `{"Success":true,"PatchData":{"ID":1,"Title":"Test","Achievements":[
+	{"ID":10,"Title":"Ten","Points":5,"MemAddr":"0xH0010=10","Flags":3},
+	{"ID":11,"Title":"Unofficial","MemAddr":"0xH0010=10","Flags":5},
+	{"ID":12,"Title":"Broken","MemAddr":"0xY0010=10","Flags":3}
+]}}`
+
+func TestSet(t *testing.T) {
+	s, err := ReadSet(strings.NewReader(patch))
+	if err != nil {
+		t.Fatalf("ReadSet() = %v", err)
+	}
+	if len(s.Achievements) != 1 || s.Achievements[0].ID != 10 {
+		t.Fatalf("ReadSet() kept %+v, want only achievement 10", s.Achievements)
+	}
+
+	// Already true when the game starts, so it needs to be false
+	// before it can unlock.
+	var ram fakeRAM
+	ram[0x10] = 10
+	for i, want := range []int{0, 0, 1, 0} {
+		if i == 1 {
+			ram[0x10] = 0
+		}
+		if i >= 2 {
+			ram[0x10] = 10
+		}
+		if got := len(s.Frame(ram.peek)); got != want {
+			t.Errorf("frame %d: %d unlocked, want %d", i, got, want)
+		}
+	}
+
+	if _, err := ReadSet(strings.NewReader(`{"Success":false,"Error":"nope"}`)); err == nil {
+		t.Errorf("ReadSet() of a failure = nil, want an error")
+	}
+}
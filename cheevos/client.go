@@ -0,0 +1,119 @@
+package cheevos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// SERVER is the RetroAchievements API endpoint.
+const SERVER = "https://retroachievements.org/dorequest.php"
+
+// ErrUnknownGame is returned by GameID for hashes the server doesn't
+// know.
+var ErrUnknownGame = errors.New("game not known to RetroAchievements")
+
+// Client talks to the RetroAchievements server for a user.
+type Client struct {
+	URL   string // SERVER unless testing
+	User  string
+	Token string       // Set by Login
+	HTTP  *http.Client // http.DefaultClient if nil
+}
+
+// response is the part common to all the server's replies.
+type response struct {
+	Success bool
+	Error   string
+}
+
+func (r response) err() error {
+	if r.Success {
+		return nil
+	}
+	if r.Error == "" {
+		return fmt.Errorf("RetroAchievements request failed")
+	}
+	return fmt.Errorf("RetroAchievements request failed: %s", r.Error)
+}
+
+// post makes a request of kind r with vals, returning the reply.
+func (cl *Client) post(r string, vals url.Values) (*http.Response, error) {
+	hc := cl.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	u := cl.URL
+	if u == "" {
+		u = SERVER
+	}
+
+	vals.Set("r", r)
+	resp, err := hc.PostForm(u, vals)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't reach RetroAchievements: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("RetroAchievements replied %s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+func (cl *Client) request(r string, vals url.Values, reply interface{ err() error }) error {
+	resp, err := cl.post(r, vals)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+		return fmt.Errorf("couldn't read RetroAchievements reply: %w", err)
+	}
+	return reply.err()
+}
+
+// Login signs in with the user's password, setting Token.
+func (cl *Client) Login(password string) error {
+	var reply struct {
+		response
+		Token string
+	}
+	if err := cl.request("login", url.Values{"u": {cl.User}, "p": {password}}, &reply); err != nil {
+		return err
+	}
+	cl.Token = reply.Token
+
+	return nil
+}
+
+// GameID returns the server's id for the game with hash, from Hash.
+func (cl *Client) GameID(hash string) (int, error) {
+	var reply struct {
+		response
+		GameID int
+	}
+	if err := cl.request("gameid", url.Values{"m": {hash}}, &reply); err != nil {
+		return 0, err
+	}
+	if reply.GameID == 0 {
+		return 0, ErrUnknownGame
+	}
+
+	return reply.GameID, nil
+}
+
+// Set fetches the achievements for game id.
+func (cl *Client) Set(id int) (*Set, error) {
+	resp, err := cl.post("patch", url.Values{"u": {cl.User}, "t": {cl.Token}, "g": {strconv.Itoa(id)}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ReadSet(resp.Body)
+}
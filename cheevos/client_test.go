@@ -0,0 +1,60 @@
+package cheevos
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("r") {
+		case "login":
+			if r.FormValue("p") != "secret" {
+				fmt.Fprint(w, `{"Success":false,"Error":"Invalid user/password combination."}`)
+				return
+			}
+			fmt.Fprint(w, `{"Success":true,"User":"player","Token":"tok"}`)
+		case "gameid":
+			id := 0
+			if r.FormValue("m") == "abc" {
+				id = 1
+			}
+			fmt.Fprintf(w, `{"Success":true,"GameID":%d}`, id)
+		case "patch":
+			if r.FormValue("t") != "tok" || r.FormValue("g") != "1" {
+				fmt.Fprint(w, `{"Success":false,"Error":"denied"}`)
+				return
+			}
+			fmt.Fprint(w, patch)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cl := &Client{URL: srv.URL, User: "player"}
+	if err := cl.Login("wrong"); err == nil {
+		t.Errorf("Login(wrong) = nil, want an error")
+	}
+	if err := cl.Login("secret"); err != nil || cl.Token != "tok" {
+		t.Fatalf("Login() = %v, token %q; want nil, %q", err, cl.Token, "tok")
+	}
+
+	if _, err := cl.GameID("nope"); err != ErrUnknownGame {
+		t.Errorf("GameID(nope) = %v, want ErrUnknownGame", err)
+	}
+	id, err := cl.GameID("abc")
+	if err != nil || id != 1 {
+		t.Fatalf("GameID(abc) = %d, %v; want 1, nil", id, err)
+	}
+
+	s, err := cl.Set(id)
+	if err != nil {
+		t.Fatalf("Set() = %v", err)
+	}
+	if s.Title != "Test" || len(s.Achievements) != 1 {
+		t.Errorf("Set() = %q with %d achievements, want %q with 1", s.Title, len(s.Achievements), "Test")
+	}
+}
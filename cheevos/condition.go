@@ -0,0 +1,571 @@
+package cheevos
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Sizes of memory reads, after the 0x in a memory reference.
+const (
+	size8 = iota
+	size16
+	size24
+	size32
+	size16BE
+	size24BE
+	size32BE
+	sizeBit // one bit, given by operand.bit
+	sizeLow
+	sizeHigh
+	sizeBitCount
+)
+
+var sizeChars = map[byte]uint8{
+	'H': size8, ' ': size16, 'W': size24, 'X': size32,
+	'I': size16BE, 'J': size24BE, 'G': size32BE,
+	'L': sizeLow, 'U': sizeHigh, 'K': sizeBitCount,
+}
+
+// How a memory reference's value is used.
+const (
+	useValue  = iota
+	useDelta  // the value last frame
+	usePrior  // the value before it last changed
+	useBCD    // the value as binary coded decimal
+	useInvert // the value with its bits flipped
+)
+
+var useChars = map[byte]uint8{'d': useDelta, 'p': usePrior, 'b': useBCD, '~': useInvert}
+
+// operand is one side of a condition: a memory reference or a
+// constant.
+type operand struct {
+	mem   bool
+	size  uint8
+	bit   uint8
+	use   uint8
+	value uint32 // the address, or the constant
+}
+
+// condition is one of the conditions, separated by _, that make up
+// a group.
+type condition struct {
+	flag   byte // R, P, A, B and so on, or 0
+	left   operand
+	op     string
+	right  operand
+	target uint32 // hits needed, or 0
+	hits   uint32
+}
+
+// Flags that modify the next condition rather than standing alone.
+const modifiers = "ABICNO"
+
+// Flags we know how to evaluate. M (Measured), Q (MeasuredIf) and T
+// (Trigger) only matter for progress displays, so they're treated as
+// plain conditions.
+const knownFlags = modifiers + "RPMQT"
+
+var comparisons = []string{"!=", "<=", ">=", "==", "=", "<", ">"}
+
+// arithmetic are the operators allowed in A:, B: and I: conditions.
+var arithmetic = []string{"*", "/", "&"}
+
+// chain is a condition along with the modifiers before it.
+type chain []*condition
+
+// group is a core or alternative group of conditions.
+type group []chain
+
+// trigger is an achievement's conditions: a core group that must be
+// true, and alternative groups of which one must be.
+type trigger struct {
+	core group
+	alts []group
+}
+
+// parseTrigger parses the conditions of an achievement, its MemAddr,
+// in the format used by RetroAchievements' rcheevos library.
+func parseTrigger(s string) (*trigger, error) {
+	parts := splitGroups(s)
+	core, err := parseGroup(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	t := &trigger{core: core}
+	for _, p := range parts[1:] {
+		g, err := parseGroup(p)
+		if err != nil {
+			return nil, err
+		}
+		t.alts = append(t.alts, g)
+	}
+
+	return t, nil
+}
+
+// splitGroups splits s at the Ss that separate groups, leaving those
+// in memory references like 0xS1234 (bit 6 of $1234) alone.
+func splitGroups(s string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == 'S' && !(i >= 2 && s[i-2] == '0' && lower(s[i-1]) == 'x') {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func parseGroup(s string) (group, error) {
+	var g group
+	var ch chain
+	if s == "" {
+		return g, nil
+	}
+	for _, cs := range strings.Split(s, "_") {
+		c, err := parseCondition(cs)
+		if err != nil {
+			return nil, fmt.Errorf("bad condition %q: %w", cs, err)
+		}
+		ch = append(ch, c)
+		if c.flag == 0 || !strings.ContainsRune(modifiers, rune(c.flag)) {
+			g = append(g, ch)
+			ch = nil
+		}
+	}
+	if ch != nil {
+		return nil, fmt.Errorf("group %q ends with a modifier", s)
+	}
+
+	return g, nil
+}
+
+func parseCondition(s string) (*condition, error) {
+	c := &condition{}
+	if len(s) >= 2 && s[1] == ':' {
+		c.flag = upper(s[0])
+		if !strings.ContainsRune(knownFlags, rune(c.flag)) {
+			return nil, fmt.Errorf("unsupported flag %c", c.flag)
+		}
+		s = s[2:]
+	}
+
+	var err error
+	if c.left, s, err = parseOperand(s); err != nil {
+		return nil, err
+	}
+
+	ops := comparisons
+	if c.flag == 'A' || c.flag == 'B' || c.flag == 'I' {
+		ops = arithmetic
+	}
+	for _, op := range ops {
+		if strings.HasPrefix(s, op) {
+			c.op = op
+			if c.right, s, err = parseOperand(s[len(op):]); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	if strings.HasPrefix(s, ".") {
+		end := strings.IndexByte(s[1:], '.')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated hit count")
+		}
+		n, err := strconv.ParseUint(s[1:end+1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad hit count: %w", err)
+		}
+		c.target = uint32(n)
+		s = s[end+2:]
+	}
+
+	if s != "" {
+		return nil, fmt.Errorf("unexpected %q", s)
+	}
+	return c, nil
+}
+
+// parseOperand parses the operand at the start of s, returning the
+// rest of s.
+func parseOperand(s string) (operand, string, error) {
+	var o operand
+	if s == "" {
+		return o, s, fmt.Errorf("missing operand")
+	}
+
+	if u, ok := useChars[lower(s[0])]; ok {
+		o.use = u
+		s = s[1:]
+	}
+
+	if len(s) >= 2 && s[0] == '0' && lower(s[1]) == 'x' {
+		o.mem = true
+		s = s[2:]
+		if s == "" {
+			return o, s, fmt.Errorf("missing address")
+		}
+		switch sc := upper(s[0]); {
+		case sc >= 'M' && sc <= 'T':
+			o.size, o.bit = sizeBit, sc-'M'
+			s = s[1:]
+		case isHex(sc):
+			o.size = size16
+		default:
+			size, ok := sizeChars[sc]
+			if !ok {
+				return o, s, fmt.Errorf("unknown memory size %q", sc)
+			}
+			o.size = size
+			s = s[1:]
+		}
+		return parseNumber(o, s, 16)
+	}
+
+	if o.use != useValue {
+		return o, s, fmt.Errorf("%q only applies to memory", s)
+	}
+	if lower(s[0]) == 'h' {
+		return parseNumber(o, s[1:], 16)
+	}
+	return parseNumber(o, s, 10)
+}
+
+// parseNumber parses the number at the start of s into o.value.
+func parseNumber(o operand, s string, base int) (operand, string, error) {
+	n := 0
+	for n < len(s) && (base == 16 && isHex(upper(s[n])) || base == 10 && s[n] >= '0' && s[n] <= '9') {
+		n++
+	}
+	v, err := strconv.ParseUint(s[:n], base, 32)
+	if err != nil {
+		return o, s, fmt.Errorf("bad number %q", s)
+	}
+	o.value = uint32(v)
+	return o, s[n:], nil
+}
+
+func isHex(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'A' && b <= 'F'
+}
+
+func upper(b byte) byte {
+	if b >= 'a' && b <= 'z' {
+		return b - 'a' + 'A'
+	}
+	return b
+}
+
+func lower(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b - 'A' + 'a'
+	}
+	return b
+}
+
+// test evaluates t against this frame's memory. Each alternative
+// group is tested, even once one is true, so that their hit counts
+// stay up to date.
+func (t *trigger) test(m *memory) bool {
+	ok, reset := t.core.test(m)
+	if len(t.alts) > 0 {
+		alt := false
+		for _, g := range t.alts {
+			aok, areset := g.test(m)
+			alt = alt || aok
+			reset = reset || areset
+		}
+		ok = ok && alt
+	}
+
+	if reset {
+		t.reset()
+		return false
+	}
+	return ok
+}
+
+// reset clears the hit counts, as a ResetIf condition does.
+func (t *trigger) reset() {
+	for _, g := range append([]group{t.core}, t.alts...) {
+		for _, ch := range g {
+			for _, c := range ch {
+				c.hits = 0
+			}
+		}
+	}
+}
+
+// test reports whether all the conditions of g are true and whether
+// one of its ResetIf conditions is. PauseIf conditions are tested
+// first, and while one is true the rest of g is left alone.
+func (g group) test(m *memory) (ok, reset bool) {
+	for _, ch := range g {
+		if ch.flag() == 'P' && ch.test(m) {
+			return false, false
+		}
+	}
+
+	ok = true
+	for _, ch := range g {
+		switch ch.flag() {
+		case 'P':
+		case 'R':
+			if ch.test(m) {
+				reset = true
+			}
+		default:
+			if !ch.test(m) {
+				ok = false
+			}
+		}
+	}
+
+	return ok, reset
+}
+
+// flag returns the flag of the condition the chain ends with.
+func (ch chain) flag() byte {
+	return ch[len(ch)-1].flag
+}
+
+// test evaluates the chain, updating hit counts, and reports whether
+// its final condition is met.
+func (ch chain) test(m *memory) bool {
+	var add int64     // from AddSource and SubSource
+	var offset uint32 // from AddAddress
+	var extra uint32  // from AddHits
+	var prev bool     // from AndNext and OrNext
+	var join byte     // N or O when prev is to be combined
+	for _, c := range ch {
+		l := int64(m.operand(c.left, offset))
+		if c.flag == 'A' || c.flag == 'B' || c.flag == 'I' {
+			v := l
+			if c.op != "" {
+				r := int64(m.operand(c.right, offset))
+				switch c.op {
+				case "*":
+					v = l * r
+				case "/":
+					if r == 0 {
+						v = 0
+					} else {
+						v = l / r
+					}
+				case "&":
+					v = l & r
+				}
+			}
+			offset = 0
+			switch c.flag {
+			case 'A':
+				add += v
+			case 'B':
+				add -= v
+			case 'I':
+				offset = uint32(v)
+			}
+			continue
+		}
+
+		l += add
+		add = 0
+		r := int64(m.operand(c.right, offset))
+		offset = 0
+
+		truth := compare(c.op, l, r)
+		switch join {
+		case 'N':
+			truth = truth && prev
+		case 'O':
+			truth = truth || prev
+		}
+		join = 0
+
+		if truth && (c.target == 0 && c.flag == 'C' || c.hits < c.target) {
+			c.hits++
+		}
+		met := truth
+		if c.target > 0 {
+			met = c.hits+extra >= c.target
+		}
+
+		switch c.flag {
+		case 'N', 'O':
+			prev, join = met, c.flag
+		case 'C':
+			extra += c.hits
+		default:
+			return met
+		}
+	}
+
+	return true
+}
+
+func compare(op string, l, r int64) bool {
+	switch op {
+	case "=", "==":
+		return l == r
+	case "!=":
+		return l != r
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	}
+	// A condition without a comparison is true when it's non zero.
+	return l != 0
+}
+
+// memKey identifies a memory read.
+type memKey struct {
+	addr uint32
+	size uint8
+	bit  uint8
+}
+
+// memory reads the console's memory for conditions, remembering
+// values across frames for delta and prior references.
+type memory struct {
+	peek  func(uint16) uint8
+	cur   map[memKey]uint32 // read this frame
+	last  map[memKey]uint32 // as of the last frame each was read
+	prior map[memKey]uint32 // before each last changed, as of this frame
+}
+
+func newMemory() memory {
+	return memory{
+		cur:   map[memKey]uint32{},
+		last:  map[memKey]uint32{},
+		prior: map[memKey]uint32{},
+	}
+}
+
+// endFrame moves the values read this frame into the past.
+func (m *memory) endFrame() {
+	for k, v := range m.cur {
+		m.last[k] = v
+		delete(m.cur, k)
+	}
+}
+
+func (m *memory) operand(o operand, offset uint32) uint32 {
+	if !o.mem {
+		return o.value
+	}
+
+	k := memKey{o.value + offset, o.size, o.bit}
+	v := m.value(k)
+	switch o.use {
+	case useDelta:
+		return m.last[k]
+	case usePrior:
+		return m.prior[k]
+	case useBCD:
+		return bcd(v)
+	case useInvert:
+		return invert(v, o.size)
+	}
+	return v
+}
+
+func (m *memory) value(k memKey) uint32 {
+	if v, ok := m.cur[k]; ok {
+		return v
+	}
+
+	var v uint32
+	switch k.size {
+	case size16:
+		v = m.le(k.addr, 2)
+	case size24:
+		v = m.le(k.addr, 3)
+	case size32:
+		v = m.le(k.addr, 4)
+	case size16BE:
+		v = m.be(k.addr, 2)
+	case size24BE:
+		v = m.be(k.addr, 3)
+	case size32BE:
+		v = m.be(k.addr, 4)
+	case sizeBit:
+		v = uint32(m.byte(k.addr)>>k.bit) & 1
+	case sizeLow:
+		v = uint32(m.byte(k.addr)) & 0xF
+	case sizeHigh:
+		v = uint32(m.byte(k.addr)) >> 4
+	case sizeBitCount:
+		v = uint32(bits.OnesCount8(m.byte(k.addr)))
+	default:
+		v = uint32(m.byte(k.addr))
+	}
+
+	if l := m.last[k]; l != v {
+		m.prior[k] = l
+	}
+	m.cur[k] = v
+	return v
+}
+
+func (m *memory) le(addr uint32, n int) uint32 {
+	var v uint32
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint32(m.byte(addr+uint32(i)))
+	}
+	return v
+}
+
+func (m *memory) be(addr uint32, n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		v = v<<8 | uint32(m.byte(addr+uint32(i)))
+	}
+	return v
+}
+
+// byte reads addr, which RetroAchievements numbers as the CPU does.
+// Only RAM and cartridge space are read, as the registers between
+// them change state when read; the rest reads as 0.
+func (m *memory) byte(addr uint32) uint8 {
+	if addr < 0x2000 || addr >= 0x6000 && addr <= 0xFFFF {
+		return m.peek(uint16(addr))
+	}
+	return 0
+}
+
+func bcd(v uint32) uint32 {
+	var r uint32
+	for mult := uint32(1); v > 0; mult *= 10 {
+		r += (v & 0xF) * mult
+		v >>= 4
+	}
+	return r
+}
+
+func invert(v uint32, size uint8) uint32 {
+	switch size {
+	case sizeBit:
+		return v ^ 1
+	case sizeLow, sizeHigh:
+		return v ^ 0xF
+	case size8:
+		return v ^ 0xFF
+	case size16, size16BE:
+		return v ^ 0xFFFF
+	case size24, size24BE:
+		return v ^ 0xFFFFFF
+	}
+	return ^v
+}
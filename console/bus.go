@@ -6,47 +6,56 @@ import (
 	"math"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/frontend"
 	"github.com/bdwalton/gintendo/mappers"
+	"github.com/bdwalton/gintendo/metrics"
 	"github.com/bdwalton/gintendo/mos6502"
 	"github.com/bdwalton/gintendo/ppu"
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
 )
 
-const (
-	NES_BASE_MEMORY = 0x800 // 2KB built in RAM
-
-	MAX_ADDRESS          = math.MaxUint16
-	MEM_SIZE             = MAX_ADDRESS + 1
-	MAX_NES_BASE_RAM     = 0x1FFF
-	MAX_PPU_REG_MIRRORED = 0x3FFF
-	MAX_IO_REG           = 0x4020
-	MAX_SRAM             = 0x6000
-)
-
-const (
-	OAMDMA = 0x4014 // Triggers DMA from CPU memory to DMA
-	CONT1  = 0x4016 // Player 1 controller
-	CONT2  = 0x4017 // Player 2 controller
-)
-
+// Bus is the ebiten frontend to a core.Console. It's an ebiten.Game
+// that shows the console's picture in a window and feeds it the
+// keyboard and touch screen, and it adds the conveniences of a
+// desktop emulator: save files, ROM reloading and a debugger.
 type Bus struct {
-	cpu         *mos6502.CPU
-	ppu         *ppu.PPU
-	mapper      mappers.Mapper
-	ram         []uint8
-	ticks       uint64
-	controllers [2]controller
+	*core.Console
+	mu       sync.Mutex // guards sramFile
+	osd      osd
+	viewers  viewers
+	sramFile string
+	rom      string // the ROM that patch applies to
+	patch    string
+	fallback bool   // load unsupported mappers as NROM
+	frame    []byte // the picture being drawn
+	metrics  *metrics.Metrics
+	sync     *metrics.Sync    // shown over the picture when set
+	profile  *metrics.Profile // shown over the picture when set
+	lastDraw time.Time
+	done     <-chan struct{} // closes the window when closed
+	runner   frontend.Runner // runs frames from Update when set
+
+	// Input state, only used on ebiten's goroutine
+	touchIDs  []ebiten.TouchID
+	touchSeen bool
 }
 
 func New(m mappers.Mapper) *Bus {
-	bus := &Bus{mapper: m, ram: make([]uint8, NES_BASE_MEMORY)}
+	return Wrap(core.New(m))
+}
 
-	bus.cpu = mos6502.New(bus)
-	bus.ppu = ppu.New(bus)
+// Wrap returns a Bus showing c, which may already be running.
+func Wrap(c *core.Console) *Bus {
+	bus := &Bus{Console: c}
 
-	w, h := bus.ppu.GetResolution()
+	w, h := bus.Resolution()
 	ebiten.SetWindowSize(w*2, h*2) // Start with 2x the screen size
 	ebiten.SetWindowTitle("Gintendo")
 	ebiten.SetWindowResizingMode(ebiten.WindowResizingModeEnabled)
@@ -54,109 +63,121 @@ func New(m mappers.Mapper) *Bus {
 	return bus
 }
 
-func (b *Bus) MirrorMode() uint8 {
-	return b.mapper.MirroringMode()
-}
-
-// Layout returns the constant resolution of the NES and is part of
-// the ebiten.Game interface. By returning constants here, we will
-// force ebiten to scale the display when the window size changes.
+// Layout returns the constant resolution of the NES, or room for the
+// viewers as well when they're showing, and is part of the
+// ebiten.Game interface. By returning constants here, we will force
+// ebiten to scale the display when the window size changes.
 func (b *Bus) Layout(w, h int) (int, int) {
-	return b.ppu.GetResolution()
+	if b.viewers.on {
+		return viewersWidth, viewersHeight
+	}
+	return b.Resolution()
 }
 
 // Draw updates the displayed ebiten window with the current state of
 // the PPU.
 func (b *Bus) Draw(screen *ebiten.Image) {
-	px := b.ppu.GetPixels()
-	rect := px.Bounds()
-	dx, dy := rect.Dx(), rect.Dy()
+	start := time.Now()
+
+	// Layout makes screen the same size as the console's picture,
+	// so its pixels can be copied in one go.
+	b.frame = b.VideoFrame(b.frame[:0])
+	if b.viewers.on {
+		b.viewers.draw(screen, b.frame, b.Console)
+	} else {
+		screen.WritePixels(b.frame)
+	}
 
-	for x := 0; x < dx; x++ {
-		for y := 0; y < dy; y++ {
-			screen.Set(x, y, px.At(x, y))
+	now := time.Now()
+	if b.metrics != nil {
+		if !b.lastDraw.IsZero() {
+			b.metrics.ObserveDraw(now.Sub(b.lastDraw))
 		}
+		b.lastDraw = now
+	}
+	if b.sync != nil {
+		b.sync.ObserveFrame(now)
+		ebitenutil.DebugPrintAt(screen, b.sync.Report().String(), 4, ppu.NES_RES_HEIGHT-36)
+	}
+
+	if info := b.PlayerInfo(); info != "" {
+		ebitenutil.DebugPrintAt(screen, info, 8, 32)
+	}
+
+	b.drawTouch(screen)
+	b.osd.draw(screen)
+
+	if b.profile != nil {
+		ebitenutil.DebugPrintAt(screen, b.profile.FrameTimes().String(), 4, ppu.NES_RES_HEIGHT-52)
+		b.profile.ObserveFrame(time.Since(start))
 	}
 }
 
 // Update is called by ebiten roughly every 1/60s and will be our
 // driver for the emulation.
 func (b *Bus) Update() error {
-	for _, c := range b.controllers {
-		c.poll()
+	select {
+	case <-b.done:
+		return ebiten.Termination
+	default:
 	}
 
-	return nil
-}
-
-// TriggerNMI is used by the PPU to signal the CPU that it is in vblank.
-func (b *Bus) TriggerNMI() {
-	b.cpu.TriggerNMI()
-}
+	buttons := pollKeys() | b.pollTouch()
+	zapper := pollZapper()
+	if b.runner == nil {
+		b.SetButtons(0, buttons)
+		b.SetZapper(zapper)
+		b.SetVsInputs(pollVs())
+	} else if !b.Paused() {
+		if _, _, err := b.runner.RunFrame(core.Inputs{Buttons: [core.PLAYERS]uint8{buttons}, Zapper: zapper}); err != nil {
+			return err
+		}
+	}
 
-// ChrRead is used by the PPU to access CHR-ROM in the loaded Mapper
-func (b *Bus) ChrRead(addr uint16) uint8 {
-	return b.mapper.ChrRead(addr)
-}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF2) {
+		b.switchDiskSide()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF3) {
+		b.toggleLayer(ppu.LAYER_BACKGROUND, "Background")
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF4) {
+		b.toggleLayer(ppu.LAYER_SPRITES, "Sprites")
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF5) {
+		b.toggleViewers()
+	}
+	if b.viewers.on && inpututil.IsKeyJustPressed(ebiten.KeyF6) {
+		b.nextViewerPalette()
+	}
+	if b.viewers.on && inpututil.IsKeyJustPressed(ebiten.KeyF7) {
+		b.nextViewerOverlays()
+	}
 
-func (b *Bus) Read(addr uint16) uint8 {
-	// https://www.nesdev.org/wiki/CPU_memory_map
 	switch {
-	case addr <= MAX_NES_BASE_RAM:
-		// 0x800-0x1FFF mirrors 0x0000-0x07FF
-		return b.ram[addr&0x7FF]
-	case addr <= MAX_PPU_REG_MIRRORED:
-		// PPU registers are mirrored between 0x2000 and 0x4000
-		return b.ppu.ReadReg(addr & 0x2007)
-	case addr < MAX_IO_REG:
-		switch addr {
-		case CONT1:
-			return b.controllers[0].read()
-			// case CONT2:
-			// 	return b.controllers[1].read(addr)
-		}
-		return 0
-	case addr <= MAX_SRAM:
-		return 0
-	case addr <= MAX_ADDRESS:
-		return b.mapper.PrgRead(addr)
+	case inpututil.IsKeyJustPressed(ebiten.KeyLeft):
+		b.ChangeTrack(-1)
+	case inpututil.IsKeyJustPressed(ebiten.KeyRight):
+		b.ChangeTrack(1)
 	}
 
-	panic("should never happen") // hah, prod crashes await!
+	return nil
 }
 
-func (b *Bus) ClearMem() {
-	b.ram = make([]uint8, len(b.ram))
+// switchDiskSide flips or swaps the disk for Disk System games.
+func (b *Bus) switchDiskSide() {
+	if side, ok := b.SwitchDiskSide(); ok {
+		b.ShowMessage(fmt.Sprintf("Inserting disk side %d", side+1), 2*time.Second)
+	}
 }
 
-func (b *Bus) Write(addr uint16, val uint8) {
-	// https://www.nesdev.org/wiki/CPU_memory_map
-	switch {
-	case addr <= MAX_NES_BASE_RAM:
-		// 0x800-0x1FFF mirrors 0x0000-0x07FF
-		b.ram[addr&0x07FF] = val
-	case addr <= MAX_PPU_REG_MIRRORED:
-		// PPU registers are mirrored between 0x2000 and 0x4000
-		b.ppu.WriteReg(addr&0x2007, val)
-	case addr < MAX_IO_REG:
-		// Handle Joysticks, APU and PPU DMA
-		switch addr {
-		case OAMDMA:
-			// TODO: Smooth this out across PPU cycles
-			base := uint16(val) << 8
-			for addr := base; addr < base+256; addr++ {
-				b.ppu.WriteReg(ppu.OAMDATA, b.Read(addr))
-			}
-			b.cpu.AddDMACycles()
-		case CONT1:
-			b.controllers[0].write(val)
-			// case CONT2:
-			// 	b.controllers[1].write(val)
-		}
-	case addr <= MAX_SRAM:
-		// nothing for now
-	case addr <= MAX_ADDRESS:
-		b.mapper.PrgWrite(addr, val)
+// toggleLayer hides or shows one of the picture's layers.
+func (b *Bus) toggleLayer(layer uint8, name string) {
+	hidden := b.HiddenLayers() ^ layer
+	b.HideLayers(hidden)
+	if hidden&layer != 0 {
+		b.ShowMessage(name+" hidden", 2*time.Second)
+	} else {
+		b.ShowMessage(name+" shown", 2*time.Second)
 	}
 }
 
@@ -167,30 +188,37 @@ func readAddress(prompt string) uint16 {
 	return a
 }
 
-func (b *Bus) Run(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			b.ppu.Tick()
-			if b.ticks%3 == 0 {
-				b.cpu.Tick()
-			}
-			b.ticks += 1
-		}
-	}
+// peek reads addr for the BIOS while the console is held, without
+// disturbing registers that change when they're read.
+func (b *Bus) peek(addr uint16) uint8 {
+	var v uint8
+	b.Inspect(func(cpu *mos6502.CPU, _ *ppu.PPU) { v = cpu.Peek(addr) })
+	return v
 }
 
 func (b *Bus) BIOS(ctx context.Context) {
 	sigQuit := make(chan os.Signal, 1)
 	signal.Notify(sigQuit, syscall.SIGINT, syscall.SIGTERM)
 
-	breaks := make(map[uint16]struct{})
+	// Run calls stop from this goroutine when a breakpoint is hit.
+	stop := func() {}
+	b.SetBreakHandler(func(br mos6502.Break) {
+		switch {
+		case !br.Watch:
+			fmt.Printf("\nBreakpoint at 0x%04x\n", br.PC)
+		case br.Write:
+			fmt.Printf("\nWrite to 0x%04x by 0x%04x\n", br.Addr, br.PC)
+		default:
+			fmt.Printf("\nRead from 0x%04x by 0x%04x\n", br.Addr, br.PC)
+		}
+		stop()
+	})
+	defer b.SetBreakHandler(nil)
 
 	for {
-		fmt.Printf("%s\n\n", b.cpu)
+		b.Inspect(func(cpu *mos6502.CPU, _ *ppu.PPU) { fmt.Printf("%s\n\n", cpu) })
 		fmt.Println("(B)reak - add breakpoint")
+		fmt.Println("(W)atch - add watchpoint on writes")
 		fmt.Println("(C)lear - cleear breakpoints")
 		fmt.Println("(R)un - run to completion")
 		fmt.Println("(S)step - step the cpu one instruction")
@@ -200,6 +228,7 @@ func (b *Bus) BIOS(ctx context.Context) {
 		fmt.Println("(I)instruction - show instruction memory locations")
 		fmt.Println("(P)C - set program counter")
 		fmt.Println("PP(U) - show PPU status")
+		fmt.Println("(L)oad - load a different ROM")
 		fmt.Println("(O)AM - Dump OAM data")
 		fmt.Println("(Q)uit - shutdown the gintentdo")
 		fmt.Printf("Choice: ")
@@ -209,15 +238,19 @@ func (b *Bus) BIOS(ctx context.Context) {
 
 		switch in {
 		case 'b', 'B':
-			breaks[readAddress("Breakpoint (eg: ff15): ")] = struct{}{}
+			b.AddBreakpoint(readAddress("Breakpoint (eg: ff15): "))
+		case 'w', 'W':
+			b.AddWatchpoint(readAddress("Watchpoint (eg: 0300): "), false, true)
 		case 'c', 'C':
-			breaks = make(map[uint16]struct{})
+			b.ClearBreakpoints()
 		case 'p', 'P':
-			b.cpu.SetPC(readAddress("Set PC to what address (eg: 0400)?: "))
+			pc := readAddress("Set PC to what address (eg: 0400)?: ")
+			b.Inspect(func(cpu *mos6502.CPU, _ *ppu.PPU) { cpu.SetPC(pc) })
 		case 'q', 'Q':
 			return
 		case 'r', 'R':
 			cctx, cancel := context.WithCancel(ctx)
+			stop = cancel
 			go func(ctx context.Context) {
 				for {
 					select {
@@ -229,18 +262,17 @@ func (b *Bus) BIOS(ctx context.Context) {
 				}
 			}(cctx)
 
+			b.Resume()
 			b.Run(cctx)
+			b.Pause()
 		case 's', 'S':
-			c := b.cpu.Step() * 3
-			for i := 0; i < c; i++ {
-				b.ppu.Tick()
-			}
+			b.Step()
 		case 't', 'T':
 			fmt.Println()
 			i := 0
 			for {
-				m := b.cpu.StackAddr() + uint16(i)
-				fmt.Printf("0x%04x: 0x%02x ", m, b.Read(m))
+				m := mos6502.STACK_PAGE + uint16(b.CPUSnapshot().SP) + uint16(i)
+				fmt.Printf("0x%04x: 0x%02x ", m, b.peek(m))
 				if m == 0x01ff || i == 2 {
 					break
 				}
@@ -248,14 +280,21 @@ func (b *Bus) BIOS(ctx context.Context) {
 			}
 			fmt.Printf("\n\n")
 		case 'i', 'I':
-			fmt.Printf("\n%s\n\n", b.cpu.Inst())
+			fmt.Printf("\n%s\n\n", b.CPUSnapshot().Inst)
 		case 'u', 'U':
-			fmt.Println(b.ppu)
+			b.Inspect(func(_ *mos6502.CPU, p *ppu.PPU) { fmt.Println(p) })
 		case 'e', 'E':
-			b.cpu.Reset()
+			b.Reset()
+		case 'l', 'L':
+			var path string
+			fmt.Printf("ROM file: ")
+			fmt.Scanln(&path)
+			if err := b.LoadROM(path); err != nil {
+				fmt.Printf("Couldn't load %q: %v\n", path, err)
+			}
 		case 'o', 'O':
-			for i, o := range b.ppu.GetOAM() {
-				fmt.Printf("%d: %v\n", i, o.String())
+			for i, s := range b.Sprites() {
+				fmt.Printf("%2d: %+v\n", i, s)
 			}
 		case 'm', 'M':
 			fmt.Println()
@@ -266,7 +305,7 @@ func (b *Bus) BIOS(ctx context.Context) {
 			x := 1
 			i := low
 			for {
-				fmt.Printf("0x%04x: 0x%02x ", i, b.Read(i))
+				fmt.Printf("0x%04x: 0x%02x ", i, b.peek(i))
 				if x%5 == 0 {
 					fmt.Println()
 				}
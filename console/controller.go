@@ -1,18 +1,11 @@
 package console
 
 import (
+	"github.com/bdwalton/gintendo/core"
 	"github.com/hajimehoshi/ebiten/v2"
 )
 
-// Buttons, as bits:
-// 0 - A
-// 1 - B
-// 2 - Select
-// 3 - Start
-// 4 - Up
-// 5 - Down
-// 6 - Left
-// 7 - Right
+// The keys for each button, in bit order.
 var keys []ebiten.Key = []ebiten.Key{
 	ebiten.KeyA,     // A
 	ebiten.KeyB,     // B
@@ -24,41 +17,21 @@ var keys []ebiten.Key = []ebiten.Key{
 	ebiten.KeyRight, // Right
 }
 
-type controller struct {
-	strobe  bool
-	buttons uint8
-	idx     uint8
+// pollZapper returns the Zapper, which is aimed with the mouse and
+// fired with its left button.
+func pollZapper() core.Zapper {
+	x, y := ebiten.CursorPosition()
+	return core.Zapper{X: x, Y: y, Trigger: ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft)}
 }
 
-func (c *controller) write(val uint8) {
-	switch val & 0x01 {
-	case 0:
-		c.strobe = false
-		c.buttons = 0
-		c.poll()
-
-	case 1:
-		c.strobe = true
-		c.idx = 0
-	}
-}
-
-func (c *controller) read() uint8 {
-	if c.idx > 7 {
-		return 1
-	}
-
-	ret := c.buttons & (1 << c.idx) >> c.idx
-	c.idx++
-	return ret
-}
-
-func (c *controller) poll() {
+// pollKeys returns the buttons held on the keyboard, as
+// core.BUTTON_XXX bits.
+func pollKeys() uint8 {
+	var buttons uint8
 	for i, key := range keys {
-		var pressed uint8
 		if ebiten.IsKeyPressed(key) {
-			pressed = 1
+			buttons |= 1 << i
 		}
-		c.buttons |= (pressed << i)
 	}
+	return buttons
 }
@@ -0,0 +1,40 @@
+package console
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// osd holds a message to be drawn over the emulated display until it
+// expires.
+type osd struct {
+	mu    sync.Mutex
+	msg   string
+	until time.Time
+}
+
+// ShowMessage displays msg on top of the game for duration d,
+// replacing any message already showing.
+func (b *Bus) ShowMessage(msg string, d time.Duration) {
+	b.osd.mu.Lock()
+	defer b.osd.mu.Unlock()
+
+	b.osd.msg = msg
+	b.osd.until = time.Now().Add(d)
+}
+
+// draw renders the current message, if it hasn't expired, onto
+// screen.
+func (o *osd) draw(screen *ebiten.Image) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.msg == "" || time.Now().After(o.until) {
+		return
+	}
+
+	ebitenutil.DebugPrint(screen, o.msg)
+}
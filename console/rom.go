@@ -0,0 +1,103 @@
+package console
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/mappers"
+)
+
+// LoadROM swaps the cartridge for the ROM in path and power cycles
+// the console, keeping the existing window. Battery backed RAM of the
+// outgoing cartridge is saved, and that of the incoming one is
+// restored from its usual save file. The ROM is loaded as it was when
+// gintendo started, with the same patch and mapper fallback.
+func (b *Bus) LoadROM(path string) error {
+	patch := ""
+	if path == b.rom {
+		patch = b.patch
+	}
+	var m mappers.Mapper
+	var err error
+	var fellBack bool
+	if b.fallback {
+		m, fellBack, err = mappers.LoadWithFallback(path, patch)
+	} else {
+		m, err = mappers.LoadPatched(path, patch)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't load mapper: %w", err)
+	}
+
+	// The new cartridge's save goes in before it starts running,
+	// and the old one's comes out once it has stopped.
+	sav := core.SaveFile(path)
+	if err := core.ReadSaveFile(m, sav); err != nil {
+		log.Printf("Couldn't load save RAM: %v", err)
+	}
+	old := b.LoadGame(m)
+
+	b.mu.Lock()
+	oldSav := b.sramFile
+	b.sramFile = sav
+	b.mu.Unlock()
+
+	if oldSav != "" {
+		if err := core.WriteSaveFile(old, oldSav); err != nil {
+			log.Printf("Couldn't write save RAM: %v", err)
+		}
+	}
+	if c, ok := old.(io.Closer); ok {
+		c.Close()
+	}
+
+	if fellBack {
+		msg := fmt.Sprintf("WARNING: mapper %d is not supported.\nUsing NROM; the game may not work.", m.ID())
+		log.Print(msg)
+		b.ShowMessage(msg, 10*time.Second)
+	} else {
+		b.ShowMessage(fmt.Sprintf("Loaded %s", filepath.Base(path)), 3*time.Second)
+	}
+
+	return nil
+}
+
+// WatchROM polls path every interval and calls LoadROM whenever its
+// modification time changes, so that a freshly built homebrew ROM
+// starts running without restarting gintendo. It returns when ctx is
+// cancelled.
+func (b *Bus) WatchROM(ctx context.Context, path string, interval time.Duration) {
+	var last time.Time
+	if fi, err := os.Stat(path); err == nil {
+		last = fi.ModTime()
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			fi, err := os.Stat(path)
+			if err != nil || fi.ModTime().Equal(last) {
+				continue
+			}
+			last = fi.ModTime()
+
+			if err := b.LoadROM(path); err != nil {
+				// Builds often write the file in several
+				// steps, so the final write will bring us
+				// back here.
+				log.Printf("Couldn't reload %q: %v", path, err)
+			}
+		}
+	}
+}
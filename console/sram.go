@@ -0,0 +1,58 @@
+package console
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// LoadSRAM restores battery backed PRG RAM from path, as
+// core.ReadSaveFile does, but under the console's lock so that it's
+// safe while the console runs. The path is remembered so that the RAM
+// can be written back before a different ROM is loaded.
+func (b *Bus) LoadSRAM(path string) error {
+	b.mu.Lock()
+	b.sramFile = path
+	b.mu.Unlock()
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't open save file: %w", err)
+	}
+	defer f.Close()
+
+	return b.ReadSRAM(f)
+}
+
+// SaveSRAM writes battery backed PRG RAM to path, under the console's
+// lock so that a running game can't change it halfway through.
+// Cartridges without a battery don't produce a save file.
+func (b *Bus) SaveSRAM(path string) error {
+	if !b.Mapper().HasSaveRAM() {
+		return nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("couldn't create save file: %w", err)
+	}
+
+	if err := b.WriteSRAM(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// SRAMFile returns the save file most recently passed to LoadSRAM.
+func (b *Bus) SRAMFile() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.sramFile
+}
@@ -0,0 +1,99 @@
+package console
+
+import (
+	"image/color"
+	"math"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// touchButton is a round on-screen button, in NES pixels.
+type touchButton struct {
+	x, y, r float32
+	button  uint8
+}
+
+// The on-screen controls for player 1, drawn over the bottom of the
+// picture once the screen has been touched.
+var (
+	touchDPad    = touchButton{x: 36, y: 196, r: 30}
+	touchButtons = []touchButton{
+		{x: 228, y: 196, r: 14, button: core.BUTTON_A},
+		{x: 196, y: 208, r: 14, button: core.BUTTON_B},
+		{x: 108, y: 228, r: 8, button: core.BUTTON_SELECT},
+		{x: 148, y: 228, r: 8, button: core.BUTTON_START},
+	}
+)
+
+// touchDeadZone is how far from the middle of the d-pad a touch has
+// to be to press a direction.
+const touchDeadZone = 8
+
+var touchColor = color.RGBA{0x80, 0x80, 0x80, 0x80}
+
+// touched returns the buttons held by the touch at x, y.
+func touched(x, y int) uint8 {
+	fx, fy := float32(x), float32(y)
+
+	// Touches are imprecise, so allow a little slop around buttons.
+	for _, tb := range touchButtons {
+		if hypot(fx-tb.x, fy-tb.y) <= tb.r*1.3 {
+			return tb.button
+		}
+	}
+
+	dx, dy := fx-touchDPad.x, fy-touchDPad.y
+	d := hypot(dx, dy)
+	if d < touchDeadZone || d > touchDPad.r*1.5 {
+		return 0
+	}
+
+	// Diagonals press two directions.
+	var b uint8
+	switch {
+	case dx > 0.4*d:
+		b |= core.BUTTON_RIGHT
+	case dx < -0.4*d:
+		b |= core.BUTTON_LEFT
+	}
+	switch {
+	case dy > 0.4*d:
+		b |= core.BUTTON_DOWN
+	case dy < -0.4*d:
+		b |= core.BUTTON_UP
+	}
+	return b
+}
+
+func hypot(x, y float32) float32 {
+	return float32(math.Hypot(float64(x), float64(y)))
+}
+
+// pollTouch returns the buttons held on the touch screen, for player
+// 1. It's called by Update, on ebiten's goroutine.
+func (b *Bus) pollTouch() uint8 {
+	b.touchIDs = ebiten.AppendTouchIDs(b.touchIDs[:0])
+	var held uint8
+	for _, id := range b.touchIDs {
+		held |= touched(ebiten.TouchPosition(id))
+	}
+	if len(b.touchIDs) > 0 {
+		b.touchSeen = true
+	}
+	return held
+}
+
+// drawTouch outlines the on-screen controls, but only once the player
+// has shown they have a touch screen.
+func (b *Bus) drawTouch(screen *ebiten.Image) {
+	if !b.touchSeen {
+		return
+	}
+
+	vector.StrokeCircle(screen, touchDPad.x, touchDPad.y, touchDPad.r, 1, touchColor, true)
+	for _, tb := range touchButtons {
+		vector.StrokeCircle(screen, tb.x, tb.y, tb.r, 1, touchColor, true)
+	}
+}
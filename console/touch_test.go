@@ -0,0 +1,29 @@
+package console
+
+import (
+	"testing"
+
+	"github.com/bdwalton/gintendo/core"
+)
+
+func TestTouched(t *testing.T) {
+	cases := []struct {
+		x, y int
+		want uint8
+	}{
+		{0, 0, 0},
+		{228, 196, core.BUTTON_A},
+		{196, 208, core.BUTTON_B},
+		{148, 228, core.BUTTON_START},
+		{36, 196, 0}, // The middle of the d-pad
+		{36, 170, core.BUTTON_UP},
+		{60, 196, core.BUTTON_RIGHT},
+		{16, 216, core.BUTTON_LEFT | core.BUTTON_DOWN},
+	}
+
+	for i, tc := range cases {
+		if got := touched(tc.x, tc.y); got != tc.want {
+			t.Errorf("%d: touched(%d, %d) = %08b, wanted %08b", i, tc.x, tc.y, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package console
+
+import (
+	"fmt"
+	"image"
+	"time"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/ppu"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// The size of the screen with the viewers showing: the game and the
+// nametables at half size side by side, with the pattern tables and
+// palettes below.
+const (
+	viewersWidth  = 2 * ppu.NES_RES_WIDTH
+	viewersHeight = ppu.NES_RES_HEIGHT + ppu.PATTERN_TABLE_SIZE
+)
+
+// viewers shows the PPU's nametables, pattern tables and palettes
+// live beside the game, for debugging graphics. It's only used on
+// ebiten's goroutine.
+type viewers struct {
+	on       bool
+	pal      uint8 // the palette the pattern tables are drawn in
+	overlays uint8 // ppu.OVERLAY_XXX bits drawn on the nametables
+
+	game, nt, pt, pals *ebiten.Image
+	ntPix, pPix        *image.RGBA
+	ptPix              [2]*image.RGBA
+}
+
+// toggleViewers shows or hides the viewers, resizing the window to
+// fit.
+func (b *Bus) toggleViewers() {
+	b.viewers.on = !b.viewers.on
+	if b.viewers.on {
+		ebiten.SetWindowSize(viewersWidth*2, viewersHeight*2)
+	} else {
+		w, h := b.Resolution()
+		ebiten.SetWindowSize(w*2, h*2)
+	}
+}
+
+// nextViewerPalette draws the pattern tables in the next palette.
+func (b *Bus) nextViewerPalette() {
+	b.viewers.pal = (b.viewers.pal + 1) % 8
+	b.ShowMessage(fmt.Sprintf("Pattern tables in palette %d", b.viewers.pal), 2*time.Second)
+}
+
+// nextViewerOverlays cycles through the nametable overlays: none, the
+// scroll position, and the scroll position with the attribute grid.
+func (b *Bus) nextViewerOverlays() {
+	switch b.viewers.overlays {
+	case 0:
+		b.viewers.overlays = ppu.OVERLAY_SCROLL
+		b.ShowMessage("Showing the scroll position", 2*time.Second)
+	case ppu.OVERLAY_SCROLL:
+		b.viewers.overlays = ppu.OVERLAY_SCROLL | ppu.OVERLAY_ATTRIBUTES
+		b.ShowMessage("Showing the scroll position and attribute grid", 2*time.Second)
+	default:
+		b.viewers.overlays = 0
+		b.ShowMessage("Nametable overlays hidden", 2*time.Second)
+	}
+}
+
+// draw draws frame, the game's picture, and the viewers onto screen.
+func (v *viewers) draw(screen *ebiten.Image, frame []byte, c *core.Console) {
+	if v.game == nil {
+		v.game = ebiten.NewImage(ppu.NES_RES_WIDTH, ppu.NES_RES_HEIGHT)
+		v.nt = ebiten.NewImage(ppu.NAMETABLES_WIDTH, ppu.NAMETABLES_HEIGHT)
+		v.pt = ebiten.NewImage(ppu.PATTERN_TABLE_SIZE, ppu.PATTERN_TABLE_SIZE)
+		v.pals = ebiten.NewImage(ppu.PALETTES_WIDTH, ppu.PALETTES_HEIGHT)
+	}
+
+	v.game.WritePixels(frame)
+	screen.DrawImage(v.game, nil)
+
+	v.ntPix = c.Nametables(v.ntPix, v.overlays)
+	v.nt.WritePixels(v.ntPix.Pix)
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Scale(0.5, 0.5)
+	op.GeoM.Translate(ppu.NES_RES_WIDTH, 0)
+	screen.DrawImage(v.nt, op)
+
+	v.ptPix = c.PatternTables(v.ptPix, v.pal)
+	for t, pix := range v.ptPix {
+		v.pt.WritePixels(pix.Pix)
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Translate(float64(t*ppu.PATTERN_TABLE_SIZE), ppu.NES_RES_HEIGHT)
+		screen.DrawImage(v.pt, op)
+	}
+
+	v.pPix = c.Palettes(v.pPix)
+	v.pals.WritePixels(v.pPix.Pix)
+	op = &ebiten.DrawImageOptions{}
+	op.GeoM.Scale(2, 2)
+	op.GeoM.Translate(2*ppu.PATTERN_TABLE_SIZE, ppu.NES_RES_HEIGHT)
+	screen.DrawImage(v.pals, op)
+
+	help := fmt.Sprintf("Pattern tables: palette %d\nF6: next palette\nF7: nametable overlays\nF5: hide viewers", v.pal)
+	ebitenutil.DebugPrintAt(screen, help, 2*ppu.PATTERN_TABLE_SIZE+4, ppu.NES_RES_HEIGHT+2*ppu.PALETTES_HEIGHT+4)
+}
@@ -0,0 +1,24 @@
+package console
+
+import (
+	"github.com/bdwalton/gintendo/core"
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+var vsKeys = map[ebiten.Key]uint8{
+	ebiten.KeyDigit5: core.VS_COIN1,
+	ebiten.KeyDigit6: core.VS_COIN2,
+	ebiten.KeyDigit9: core.VS_SERVICE,
+}
+
+// pollVs returns which of the Vs. System coin and service keys are
+// held down.
+func pollVs() uint8 {
+	var v uint8
+	for k, bit := range vsKeys {
+		if ebiten.IsKeyPressed(k) {
+			v |= bit
+		}
+	}
+	return v
+}
@@ -0,0 +1,79 @@
+package console
+
+import (
+	"context"
+	"time"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/frontend"
+	"github.com/bdwalton/gintendo/metrics"
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Window shows a console in an ebiten window, with a Bus. It's the
+// console package's frontend.Frontend.
+type Window struct {
+	SaveFile string           // Where LoadROM saves the battery RAM of the cartridge it replaces
+	WatchROM string           // A ROM to reload whenever it changes (see Bus.WatchROM)
+	ROM      string           // The ROM being played, which Patch applies to
+	Patch    string           // Applied when LoadROM reloads ROM; empty to look for one beside it
+	Fallback bool             // LoadROM gives ROMs with unsupported mappers NROM mapping
+	Message  string           // Shown for a while when the window opens
+	Metrics  *metrics.Metrics // Told about every frame drawn
+	Sync     *metrics.Sync    // Told about every frame drawn, and shown over the picture
+	Profile  *metrics.Profile // Told about every frame drawn, and shown over the picture
+	Runner   frontend.Runner  // Runs a frame every tick, such as for netplay
+	Messages <-chan string    // Shown for a while as they arrive
+}
+
+// Run opens the window and runs c in the background until the window
+// is closed or ctx is cancelled, returning once c has stopped. With a
+// Runner, frames are run by it in step with the window's 60 ticks a
+// second instead.
+func (w *Window) Run(ctx context.Context, c *core.Console) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	b := Wrap(c)
+	b.sramFile = w.SaveFile
+	b.rom, b.patch, b.fallback = w.ROM, w.Patch, w.Fallback
+	b.metrics = w.Metrics
+	b.sync = w.Sync
+	b.profile = w.Profile
+	b.done = ctx.Done()
+	b.runner = w.Runner
+	if w.Message != "" {
+		b.ShowMessage(w.Message, 10*time.Second)
+	}
+
+	if b.runner == nil {
+		// Wait for Run to stop before returning, so that the
+		// caller can save battery RAM without racing it.
+		stopped := make(chan struct{})
+		go func() {
+			c.Run(ctx)
+			close(stopped)
+		}()
+		defer func() {
+			cancel()
+			<-stopped
+		}()
+	}
+	if w.Messages != nil {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case m := <-w.Messages:
+					b.ShowMessage(m, 5*time.Second)
+				}
+			}
+		}()
+	}
+	if w.WatchROM != "" {
+		go b.WatchROM(ctx, w.WatchROM, 500*time.Millisecond)
+	}
+
+	return ebiten.RunGame(b)
+}
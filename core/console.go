@@ -0,0 +1,499 @@
+// Package core emulates the NES without tying it to any particular
+// display, input or audio library. A frontend loads a game, feeds in
+// the controls and collects the picture a frame at a time with
+// RunFrame, which makes the emulator easy to embed in other Go
+// programs or to wrap as a libretro core. The console package is the
+// ebiten frontend built on it.
+package core
+
+import (
+	"context"
+	"image"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/bdwalton/gintendo/mappers"
+	"github.com/bdwalton/gintendo/mos6502"
+	"github.com/bdwalton/gintendo/ppu"
+)
+
+const (
+	NES_BASE_MEMORY = 0x800 // 2KB built in RAM
+
+	MAX_ADDRESS          = math.MaxUint16
+	MEM_SIZE             = MAX_ADDRESS + 1
+	MAX_NES_BASE_RAM     = 0x1FFF
+	MAX_PPU_REG_MIRRORED = 0x3FFF
+	MAX_IO_REG           = 0x4020
+	MAX_EXPANSION_ROM    = 0x5FFF
+	MAX_SRAM             = 0x7FFF
+)
+
+const (
+	OAMDMA = 0x4014 // Triggers DMA from CPU memory to DMA
+	CONT1  = 0x4016 // Player 1 controller
+	CONT2  = 0x4017 // Player 2 controller
+)
+
+// Console is the NES itself: the CPU, PPU and memory map, with a
+// cartridge plugged in. Its exported methods are safe to call from
+// any goroutine.
+type Console struct {
+	mu          sync.Mutex // held while emulating; LoadGame takes it to swap cartridges
+	cpu         *mos6502.CPU
+	ppu         *ppu.PPU
+	mapper      mappers.Mapper
+	clocked     mappers.CPUClocked // mapper, if it wants every CPU cycle
+	watcher     mappers.PPUWatcher // mapper, if it watches the PPU's address bus
+	outLatch    mappers.OutputLatch
+	expansion   mappers.ExpansionDecoder
+	peeker      mappers.PrgPeeker
+	ram         []uint8
+	ticks       uint64
+	controllers [PLAYERS]controller
+	input       uint8  // what's plugged into the controller ports, an nesrom.INPUT_XXX value
+	zapper      Zapper // see SetZapper
+	video       []byte // the last frame returned by RunFrame
+	paused      bool
+
+	// Counters for Stats
+	frames, cycles   uint64
+	lastFrame        uint64 // the PPU's frame number when frames was last counted
+	profiling        bool
+	cpuTime, ppuTime time.Duration
+
+	// Region timing. The CPU runs cpuPerPPU times every ppuPerCPU
+	// PPU ticks.
+	ppuPerCPU, cpuPerPPU uint64
+	forcedRegion         int // AUTO_REGION or the region to run as
+
+	hiddenLayers uint8             // kept across power cycles; see HideLayers
+	powerOnState PowerOn           // see SetPowerOn
+	cycleMode    bool              // see SetCycleMode
+	cycling      bool              // cycleMode, or the game's quirks asking for it
+	onFrame      func(*image.RGBA) // see SetFrameHandler
+
+	// Debugging; see SetBreakHandler
+	breakHandler func(mos6502.Break)
+	symbols      mos6502.Symbols // kept across power cycles; see SetSymbols
+	broke        bool            // a break was hit since RunFrame or RunTicks started
+
+	// Vs. System state
+	vs       bool
+	dips     uint8
+	vsInputs uint8
+}
+
+// New returns a console with m plugged in, switched on and ready to
+// run.
+func New(m mappers.Mapper) *Console {
+	c := &Console{mapper: m, ram: make([]uint8, NES_BASE_MEMORY), forcedRegion: AUTO_REGION}
+	c.powerOn()
+
+	return c
+}
+
+// LoadGame swaps the cartridge for m and power cycles the console. It
+// returns the cartridge that was removed so that the caller can save
+// its battery backed RAM and close it; it isn't touched again.
+func (c *Console) LoadGame(m mappers.Mapper) mappers.Mapper {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old := c.mapper
+	c.mapper = m
+	c.powerOn()
+
+	return old
+}
+
+// Mapper returns the cartridge that's plugged in.
+func (c *Console) Mapper() mappers.Mapper {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.mapper
+}
+
+// CPU returns the console's CPU, for debuggers. Only use it while
+// the console isn't running; otherwise, see Inspect and CPUSnapshot.
+func (c *Console) CPU() *mos6502.CPU {
+	return c.cpu
+}
+
+// PPU returns the console's PPU, for debuggers. Only use it while
+// the console isn't running; otherwise, see Inspect.
+func (c *Console) PPU() *ppu.PPU {
+	return c.ppu
+}
+
+// MirrorMode is consulted by the PPU on each nametable access so
+// that mapper driven changes take effect immediately.
+func (c *Console) MirrorMode() uint8 {
+	return c.mapper.MirroringMode()
+}
+
+// NametablePage is used by the PPU when the mapper controls the
+// nametable layout itself.
+func (c *Console) NametablePage(nt uint8) uint8 {
+	if nm, ok := c.mapper.(mappers.NametableMapper); ok {
+		return nm.NametablePage(nt)
+	}
+	return 0
+}
+
+// PPUAddress is used by the PPU to show mappers that watch its
+// address bus what it's fetching.
+func (c *Console) PPUAddress(addr uint16) {
+	if c.watcher != nil {
+		c.watcher.PPUAddress(addr)
+	}
+}
+
+// TriggerNMI is used by the PPU to signal the CPU that it is in vblank.
+func (c *Console) TriggerNMI() {
+	c.cpu.TriggerNMI()
+}
+
+// SetMapperIRQ is used by the mapper to drive its share of the CPU's
+// IRQ line.
+func (c *Console) SetMapperIRQ(asserted bool) {
+	c.cpu.SetIRQ(mos6502.IRQ_SOURCE_MAPPER, asserted)
+}
+
+// ChrRead is used by the PPU to access CHR-ROM in the loaded Mapper
+func (c *Console) ChrRead(addr uint16) uint8 {
+	return c.mapper.ChrRead(addr)
+}
+
+// ChrWrite is used by the PPU to store pattern data on CHR-RAM carts.
+func (c *Console) ChrWrite(addr uint16, val uint8) {
+	c.mapper.ChrWrite(addr, val)
+}
+
+func (c *Console) Read(addr uint16) uint8 {
+	// https://www.nesdev.org/wiki/CPU_memory_map
+	switch {
+	case addr <= MAX_NES_BASE_RAM:
+		// 0x800-0x1FFF mirrors 0x0000-0x07FF
+		return c.ram[addr&0x7FF]
+	case addr <= MAX_PPU_REG_MIRRORED:
+		// PPU registers are mirrored between 0x2000 and 0x4000
+		return c.ppu.ReadReg(addr & 0x2007)
+	case addr < MAX_IO_REG:
+		switch addr {
+		case CONT1, CONT2:
+			return c.controllerBits(addr, c.readPort(addr, false))
+		}
+		// The APU and I/O registers are write only.
+		return c.cpu.DataBus()
+	case addr < 0x6000 && !c.decodes(addr):
+		return c.cpu.DataBus()
+	case addr <= MAX_ADDRESS:
+		// Expansion ROM, SRAM and PRG ROM are all up to the
+		// cartridge to decode.
+		return c.mapper.PrgRead(addr)
+	}
+
+	panic("should never happen") // hah, prod crashes await!
+}
+
+// controllerBits returns what the CPU reads from $4016 or $4017, given
+// the controller's bit. Only the low bits are driven by the
+// controllers; the rest are open bus, except on the Vs. System, which
+// uses them for its coin slots and DIP switches.
+func (c *Console) controllerBits(addr uint16, v uint8) uint8 {
+	if c.vs {
+		return v | c.vsRead(addr)
+	}
+	return v | c.cpu.DataBus()&0xE0
+}
+
+// decodes reports whether the cartridge answers reads of addr in the
+// expansion area, $4020-$5FFF.
+func (c *Console) decodes(addr uint16) bool {
+	return c.expansion != nil && c.expansion.DecodesExpansion(addr)
+}
+
+// ClearMem zeroes the console's RAM. It's cleared in place, so
+// slices returned by Memory stay valid.
+func (c *Console) ClearMem() {
+	for i := range c.ram {
+		c.ram[i] = 0
+	}
+}
+
+func (c *Console) Write(addr uint16, val uint8) {
+	// https://www.nesdev.org/wiki/CPU_memory_map
+	switch {
+	case addr <= MAX_NES_BASE_RAM:
+		// 0x800-0x1FFF mirrors 0x0000-0x07FF
+		c.ram[addr&0x07FF] = val
+	case addr <= MAX_PPU_REG_MIRRORED:
+		// PPU registers are mirrored between 0x2000 and 0x4000
+		c.ppu.WriteReg(addr&0x2007, val)
+	case addr < MAX_IO_REG:
+		// Handle Joysticks, APU and PPU DMA
+		switch addr {
+		case OAMDMA:
+			// TODO: Smooth this out across PPU cycles
+			base := uint16(val) << 8
+			for addr := base; addr < base+256; addr++ {
+				c.ppu.WriteReg(ppu.OAMDATA, c.Read(addr))
+			}
+			c.cpu.DMA(mos6502.DMA_OAM)
+		case CONT1:
+			c.strobe(val)
+			if c.outLatch != nil {
+				c.outLatch.WriteOut(val)
+			}
+		}
+	case addr <= MAX_ADDRESS:
+		c.mapper.PrgWrite(addr, val)
+	}
+}
+
+// powerOn wires the current mapper to fresh CPU and PPU instances,
+// as if the console had just been switched on.
+func (c *Console) powerOn() {
+	c.cpu = mos6502.New2A03(cpuBus{c})
+	c.cpu.SetBreakHandler(c.onBreak)
+	c.cpu.SetSymbols(c.symbols)
+	c.ppu = ppu.New(c)
+	c.mapper.ConnectIRQ(c)
+	c.clocked, _ = c.mapper.(mappers.CPUClocked)
+	c.watcher, _ = c.mapper.(mappers.PPUWatcher)
+	c.outLatch, _ = c.mapper.(mappers.OutputLatch)
+	c.expansion, _ = c.mapper.(mappers.ExpansionDecoder)
+	c.peeker, _ = c.mapper.(mappers.PrgPeeker)
+	c.ticks = uint64(c.powerOnState.Alignment)
+	c.fillRAM()
+
+	c.applyRegion()
+	c.ppu.HideLayers(c.hiddenLayers)
+	c.ppu.OnFrame(c.onFrame)
+	c.applyCycleMode()
+
+	caps := c.mapper.Capabilities()
+	c.input = caps.Input
+	c.vs = caps.VsSystem
+	if c.vs {
+		c.ppu.SetRGB(rgbPPU(caps.VsPPU))
+	}
+	if id, ok := rc2c05IDs[caps.VsPPU]; c.vs && ok {
+		c.ppu.SetRC2C05(id)
+	}
+}
+
+// ticksPerLock is how many PPU ticks Run emulates each time it takes
+// c.mu. One scanline keeps the locking cheap while still letting
+// LoadGame in promptly.
+const ticksPerLock = 341
+
+// pausePoll is how often Run checks whether it has been resumed.
+const pausePoll = 10 * time.Millisecond
+
+// Run emulates the console as fast as it can until ctx is cancelled,
+// idling while it's paused. Frontends that pace the emulation
+// themselves should use RunFrame.
+func (c *Console) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			if c.Paused() {
+				time.Sleep(pausePoll)
+				continue
+			}
+			c.RunTicks(ticksPerLock)
+		}
+	}
+}
+
+// Pause stops Run emulating until Resume is called. RunFrame, RunTicks
+// and Step still work, for stepping through a paused game.
+func (c *Console) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = true
+}
+
+// Resume lets Run carry on after Pause.
+func (c *Console) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = false
+}
+
+// Paused reports whether the console is paused.
+func (c *Console) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.paused
+}
+
+// RunTicks emulates at least n PPU ticks and returns. It stops at
+// the end of a CPU instruction, so it may overshoot by a few, or
+// early for a breakpoint. It lets the console be driven by test
+// harnesses and the like.
+func (c *Console) RunTicks(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.broke = false
+	for end := c.ticks + uint64(n); c.ticks < end && !c.broke; {
+		c.step()
+	}
+}
+
+// Step runs a single CPU instruction, for debuggers. It returns the
+// number of CPU cycles it took.
+func (c *Console) Step() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.step()
+}
+
+// step runs one CPU instruction and then catches the PPU and mapper
+// up on the cycles it took, rather than interleaving them a cycle at
+// a time. It returns the number of CPU cycles.
+func (c *Console) step() int {
+	if c.cycling {
+		// The CPU has already caught everything up as it went.
+		n := c.cpu.Step()
+		c.cycles += uint64(n)
+		return n
+	}
+	if c.profiling {
+		return c.profileStep()
+	}
+
+	n := c.cpu.Step()
+	c.cycles += uint64(n)
+	c.catchUp(n)
+	return n
+}
+
+// profileStep is step, timing the CPU and the rest separately.
+func (c *Console) profileStep() int {
+	start := time.Now()
+	n := c.cpu.Step()
+	c.cycles += uint64(n)
+	mid := time.Now()
+	c.catchUp(n)
+	c.cpuTime += mid.Sub(start)
+	c.ppuTime += time.Since(mid)
+	return n
+}
+
+// catchUp runs the PPU and mapper for n CPU cycles.
+func (c *Console) catchUp(n int) {
+	for i := 0; i < n; i++ {
+		if c.clocked != nil {
+			c.clocked.ClockCPU()
+		}
+		for {
+			c.ppu.Tick()
+			c.ticks += 1
+			if c.cpuCycle() {
+				break
+			}
+		}
+	}
+	if f := c.ppu.Frame(); f != c.lastFrame {
+		c.frames++
+		c.lastFrame = f
+	}
+}
+
+// Stats are running totals for monitoring the emulator. They count
+// from when the console was created, carrying on through resets and
+// game changes.
+type Stats struct {
+	Frames    uint64 // Frames emulated
+	CPUCycles uint64 // CPU cycles emulated
+
+	// Time spent emulating, while profiling (see SetProfiling).
+	// The PPU's share includes mappers that count CPU cycles.
+	// There's no APU yet, so it never takes any.
+	CPUTime, PPUTime, APUTime time.Duration
+}
+
+// Stats returns the console's running totals.
+func (c *Console) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{Frames: c.frames, CPUCycles: c.cycles, CPUTime: c.cpuTime, PPUTime: c.ppuTime}
+}
+
+// SetProfiling turns on or off timing of the CPU and PPU for Stats.
+// It's off to start with, as reading the clock around every
+// instruction slows emulation noticeably.
+func (c *Console) SetProfiling(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.profiling = on
+}
+
+// SetCycleMode turns on or off running the PPU and mapper during each
+// CPU instruction, a cycle at a time, instead of after it. It's slower
+// but gets timing right for games that race the PPU or a mapper IRQ
+// within an instruction. The setting is kept across power cycles.
+// Games whose quirks ask for cycle mode get it even when it's off.
+// Profiling can't separate the CPU from the PPU in cycle mode, so
+// Stats' times stay still while it's on.
+func (c *Console) SetCycleMode(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cycleMode = on
+	c.applyCycleMode()
+}
+
+// applyCycleMode hands the CPU a clock for cycle mode, or takes it
+// away.
+func (c *Console) applyCycleMode() {
+	c.cycling = c.cycleMode || c.mapper.Capabilities().CycleMode
+	if !c.cycling {
+		c.cpu.SetCycleClock(nil)
+		return
+	}
+	c.cpu.SetCycleClock(func() { c.catchUp(1) })
+}
+
+// HideLayers leaves the ppu.LAYER_XXX layers in mask out of the
+// picture, and shows the rest, until it's called again. It's for
+// debugging and doesn't change how games run.
+func (c *Console) HideLayers(mask uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hiddenLayers = mask
+	c.ppu.HideLayers(mask)
+}
+
+// HiddenLayers returns the ppu.LAYER_XXX layers hidden by HideLayers.
+func (c *Console) HiddenLayers() uint8 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hiddenLayers
+}
+
+// Reset presses the reset button, which restarts the CPU and PPU
+// without clearing RAM.
+func (c *Console) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cpu.Reset()
+	c.ppu.Reset()
+}
@@ -0,0 +1,563 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/bdwalton/gintendo/mappers"
+	"github.com/bdwalton/gintendo/mos6502"
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/ppu"
+)
+
+func TestBaseNESMapping(t *testing.T) {
+	c := New(mappers.Dummy)
+
+	for i := 0; i < 10; i++ {
+		c.Write(uint16(i), uint8(i+1))
+	}
+
+	for _, a := range []uint16{0, 0x800, 0x1000, 0x1800} {
+		for i := 0; i < 10; i++ {
+			if got := c.Read(a + uint16(i)); got != uint8(i+1) {
+				t.Errorf("mem[%04x] = %02x, wanted %02x", a, got, i+1)
+			}
+
+		}
+	}
+
+	if got := c.Memory(MEMORY_SYSTEM_RAM)[5]; got != 6 {
+		t.Errorf("Memory(MEMORY_SYSTEM_RAM)[5] = %02x, wanted 06", got)
+	}
+}
+
+func TestCPUCycle(t *testing.T) {
+	cases := []struct {
+		region   int
+		ppuTicks uint64
+		want     int
+	}{
+		{nesrom.NTSC, 3000, 1000},
+		{nesrom.DENDY, 3000, 1000},
+		{nesrom.PAL, 3200, 1000},
+	}
+
+	for i, tc := range cases {
+		c := New(mappers.Dummy)
+		c.SetRegion(tc.region)
+		if got := c.Region(); got != uint8(tc.region) {
+			t.Errorf("%d: Region() = %d, wanted %d", i, got, tc.region)
+		}
+
+		got := 0
+		for c.ticks = 0; c.ticks < tc.ppuTicks; c.ticks++ {
+			if c.cpuCycle() {
+				got++
+			}
+		}
+		if got != tc.want {
+			t.Errorf("%d: Got %d CPU cycles in %d PPU ticks, wanted %d", i, got, tc.ppuTicks, tc.want)
+		}
+	}
+}
+
+func TestControllers(t *testing.T) {
+	c := New(mappers.Dummy)
+	c.SetButtons(0, BUTTON_A|BUTTON_START)
+	c.SetButtons(1, BUTTON_RIGHT)
+
+	c.Write(CONT1, 1)
+	c.Write(CONT1, 0)
+	var p1, p2 uint8
+	for i := 0; i < 8; i++ {
+		p1 |= c.Read(CONT1) << i
+		p2 |= c.Read(CONT2) << i
+	}
+	if p1 != BUTTON_A|BUTTON_START || p2 != BUTTON_RIGHT {
+		t.Errorf("Read %08b and %08b, wanted %08b and %08b", p1, p2, BUTTON_A|BUTTON_START, BUTTON_RIGHT)
+	}
+}
+
+// quirked gives a mapper the input device and cycle mode that a
+// quirks table entry would.
+type quirked struct {
+	mappers.Mapper
+	input     uint8
+	cycleMode bool
+}
+
+func (m quirked) Capabilities() mappers.Capabilities {
+	caps := m.Mapper.Capabilities()
+	caps.Input, caps.CycleMode = m.input, m.cycleMode
+	return caps
+}
+
+func TestFourScore(t *testing.T) {
+	buttons := [PLAYERS]uint8{BUTTON_A, BUTTON_B, BUTTON_START, BUTTON_RIGHT}
+	read := func(c *Console, addr uint16) uint32 {
+		c.Write(CONT1, 1)
+		c.Write(CONT1, 0)
+		var v uint32
+		for i := 0; i < 32; i++ {
+			v |= uint32(c.Read(addr)&1) << i
+		}
+		return v
+	}
+
+	// Players 3 and 4 follow 1 and 2, then the signatures.
+	c := New(quirked{Mapper: mappers.Dummy, input: nesrom.INPUT_FOUR_SCORE})
+	for i, b := range buttons {
+		c.SetButtons(i, b)
+	}
+	if got, want := read(c, CONT1), uint32(0xFF08<<16|BUTTON_START<<8|BUTTON_A); got != want {
+		t.Errorf("$4016 sent %032b, wanted %032b", got, want)
+	}
+	if got, want := read(c, CONT2), uint32(0xFF04<<16|BUTTON_RIGHT<<8|BUTTON_B); got != want {
+		t.Errorf("$4017 sent %032b, wanted %032b", got, want)
+	}
+
+	// Without a Four Score, they aren't connected.
+	c = New(mappers.Dummy)
+	for i, b := range buttons {
+		c.SetButtons(i, b)
+	}
+	if got, want := read(c, CONT1), uint32(0xFFFFFF00|BUTTON_A); got != want {
+		t.Errorf("$4016 sent %032b without a Four Score, wanted %032b", got, want)
+	}
+}
+
+func TestZapper(t *testing.T) {
+	c := New(quirked{Mapper: mappers.Dummy, input: nesrom.INPUT_ZAPPER})
+
+	// A white backdrop, which Dummy's blank CHR shows everywhere.
+	c.Write(0x2006, 0x3F)
+	c.Write(0x2006, 0x00)
+	c.Write(0x2007, 0x30)
+	c.Write(0x2001, 0x0A)
+	for line, _ := c.PPU().Position(); line != 100; line, _ = c.PPU().Position() {
+		c.Step()
+	}
+
+	cases := []struct {
+		z    Zapper
+		want uint8
+	}{
+		{Zapper{X: 10, Y: 90}, 0x00},                // Just drawn
+		{Zapper{X: 10, Y: 90, Trigger: true}, 0x10}, // and firing
+		{Zapper{X: 10, Y: 60}, 0x08},                // Faded
+		{Zapper{X: 10, Y: 150}, 0x08},               // Not drawn yet
+		{Zapper{X: -1, Y: 90, Trigger: true}, 0x18}, // Off the screen
+	}
+	for _, tc := range cases {
+		c.SetZapper(tc.z)
+		if got := c.Read(CONT2) & 0x19; got != tc.want {
+			t.Errorf("%+v: $4017 = %02x, wanted %02x", tc.z, got, tc.want)
+		}
+	}
+}
+
+func TestFourScreen(t *testing.T) {
+	// JMP $C000, from the reset vector.
+	prg := make([]byte, 0x4000)
+	copy(prg, []byte{0x4C, 0x00, 0xC0})
+	prg[0x3FFC], prg[0x3FFD] = 0x00, 0xC0
+	rom, err := nesrom.NewFromRaw(prg, nil, 0, nesrom.MIRROR_FOUR_SCREEN)
+	if err != nil {
+		t.Fatalf("NewFromRaw() = %v", err)
+	}
+	mp, err := mappers.FromROM(rom)
+	if err != nil {
+		t.Fatalf("FromROM() = %v", err)
+	}
+	c := New(mp)
+
+	// Each nametable is its own memory.
+	for nt := uint8(0); nt < 4; nt++ {
+		c.Write(0x2006, 0x20+nt*4)
+		c.Write(0x2006, 0x00)
+		c.Write(0x2007, nt+1)
+	}
+	for nt := uint8(0); nt < 4; nt++ {
+		c.Write(0x2006, 0x20+nt*4)
+		c.Write(0x2006, 0x00)
+		c.Read(0x2007) // The read buffer
+		if got := c.Read(0x2007); got != nt+1 {
+			t.Errorf("Nametable %d holds %d, wanted %d", nt, got, nt+1)
+		}
+	}
+
+	c.Write(0x2001, 0x08) // Show the background
+	c.RunFrame(Inputs{})
+}
+
+func TestVsPPU(t *testing.T) {
+	cases := []struct {
+		ppu     uint8
+		swapped bool
+	}{
+		{nesrom.VS_PPU_RP2C03B, false},
+		{nesrom.VS_PPU_RC2C05_02, true},
+		{nesrom.VS_PPU_RC2C05_05, true},
+	}
+
+	for _, tc := range cases {
+		// JMP $8000, from the reset vector.
+		prg := make([]byte, 0x8000)
+		copy(prg, []byte{0x4C, 0x00, 0x80})
+		prg[0x7FFC], prg[0x7FFD] = 0x00, 0x80
+		hdr := nesrom.HeaderBytes(2, 1, nesrom.CartInfo{Mapper: 99, HasRegion: true})
+		hdr[7] |= nesrom.VS_UNISYSTEM
+		hdr[13] = tc.ppu
+		mp, err := mappers.LoadBytes(append(append(hdr, prg...), make([]byte, 0x2000)...), "vs.nes")
+		if err != nil {
+			t.Fatalf("PPU %d: LoadBytes() = %v", tc.ppu, err)
+		}
+		c := New(mp)
+
+		c.Write(0x2006, 0x3F)
+		c.Write(0x2006, 0x00)
+		c.Write(0x2007, 0x16) // The backdrop, a red
+		mask := uint16(0x2001)
+		if tc.swapped {
+			mask = 0x2000
+		}
+		c.Write(mask, 0x0A) // Show the background
+		c.RunFrame(Inputs{})
+		c.RunFrame(Inputs{})
+
+		if got := c.PPU().Registers().Mask; got != 0x0A {
+			t.Errorf("PPU %d: PPUMASK = 0x%02x after writing $%04X, wanted 0x0A", tc.ppu, got, mask)
+		}
+		px := c.VideoFrame(nil)
+		if got, want := (color.RGBA{px[0], px[1], px[2], px[3]}), ppu.RGB_PALETTE[0x16]; got != want {
+			t.Errorf("PPU %d: backdrop = %v, wanted %v from the RGB palette", tc.ppu, got, want)
+		}
+	}
+}
+
+func TestOpenBus(t *testing.T) {
+	c := New(mappers.Dummy)
+	c.SetButtons(0, BUTTON_A)
+	c.Write(CONT1, 1)
+	c.Write(CONT1, 0)
+
+	cases := []struct {
+		prog []uint8
+		want uint8
+	}{
+		{[]uint8{0xAD, 0x00, 0x40}, 0x40}, // LDA $4000: the address's high byte
+		{[]uint8{0xAD, 0x00, 0x50}, 0x50}, // LDA $5000: nothing in the expansion area
+		{[]uint8{0xAD, 0x16, 0x40}, 0x41}, // LDA $4016: A with open bus above it
+	}
+	for _, tc := range cases {
+		for i, b := range tc.prog {
+			c.Write(0x0300+uint16(i), b)
+		}
+		c.CPU().SetPC(0x0300)
+		c.Step()
+		if got := c.CPU().Registers().A; got != tc.want {
+			t.Errorf("% X: A = $%02X, wanted $%02X", tc.prog, got, tc.want)
+		}
+	}
+}
+
+func TestCPUPeek(t *testing.T) {
+	c := New(mappers.Dummy)
+	c.SetButtons(0, BUTTON_A)
+	c.Write(CONT1, 1)
+	c.Write(CONT1, 0)
+
+	// The CPU's debugging reads leave the controller where it was.
+	for i := 0; i < 3; i++ {
+		if got := c.CPU().Peek(CONT1); got != 1 {
+			t.Errorf("CPU().Peek(CONT1) = %d, wanted 1", got)
+		}
+	}
+	c.CPU().Disassemble(CONT1)
+	if got := c.Read(CONT1); got != 1 {
+		t.Errorf("Read(CONT1) = %d after peeking, wanted 1 for A", got)
+	}
+}
+
+func testConsole(t *testing.T) *Console {
+	t.Helper()
+
+	m, err := mappers.Load("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+	return New(m)
+}
+
+func TestSaveState(t *testing.T) {
+	c := testConsole(t)
+	for i := 0; i < 10; i++ {
+		c.RunFrame(Inputs{})
+	}
+
+	var saved bytes.Buffer
+	if err := c.SaveState(&saved); err != nil {
+		t.Fatalf("SaveState() = %v", err)
+	}
+
+	// The same frames must follow the state when it's loaded.
+	var want [][]byte
+	for i := 0; i < 5; i++ {
+		v, _ := c.RunFrame(Inputs{})
+		want = append(want, bytes.Clone(v))
+	}
+	wantRAM := bytes.Clone(c.Memory(MEMORY_SYSTEM_RAM))
+
+	if err := c.LoadState(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatalf("LoadState() = %v", err)
+	}
+	for i := range want {
+		if v, _ := c.RunFrame(Inputs{}); !bytes.Equal(v, want[i]) {
+			t.Errorf("Frame %d differs after loading the state", i)
+		}
+	}
+	if !bytes.Equal(c.Memory(MEMORY_SYSTEM_RAM), wantRAM) {
+		t.Errorf("RAM differs after loading the state")
+	}
+}
+
+func TestLoadStateMismatch(t *testing.T) {
+	c := testConsole(t)
+	c.RunFrame(Inputs{})
+
+	var saved bytes.Buffer
+	if err := c.SaveState(&saved); err != nil {
+		t.Fatalf("SaveState() = %v", err)
+	}
+	before := c.CPU().Trace()
+
+	// A truncated state is rejected and leaves the console alone.
+	if err := c.LoadState(bytes.NewReader(saved.Bytes()[:saved.Len()/2])); err == nil {
+		t.Errorf("LoadState() succeeded with a truncated state")
+	}
+	if got := c.CPU().Trace(); got != before {
+		t.Errorf("CPU is %q after a failed load, wanted %q", got, before)
+	}
+
+	// States can't be used with a cartridge without state support.
+	d := New(mappers.Dummy)
+	d.mapper = noState{mappers.Dummy}
+	if err := d.SaveState(&saved); !errors.Is(err, ErrNoSaveStates) {
+		t.Errorf("SaveState() = %v, wanted ErrNoSaveStates", err)
+	}
+}
+
+// noState hides the Dummy mapper's State method.
+type noState struct {
+	mappers.Mapper
+}
+
+func TestHashes(t *testing.T) {
+	c := testConsole(t)
+	video, _ := c.RunFrame(Inputs{})
+
+	if got, want := c.FrameHash(), crc32.ChecksumIEEE(video); got != want {
+		t.Errorf("FrameHash() = %08x, want %08x", got, want)
+	}
+	if got, want := c.RAMHash(), crc32.ChecksumIEEE(c.Memory(MEMORY_SYSTEM_RAM)); got != want {
+		t.Errorf("RAMHash() = %08x, want %08x", got, want)
+	}
+	if got, want := c.RAMHash(Range{0, 0x7FF}), c.RAMHash(); got != want {
+		t.Errorf("RAMHash($0000-$07FF) = %08x, want %08x", got, want)
+	}
+
+	// Registers hash as zeros.
+	if got, want := c.RAMHash(Range{0x2000, 0x2007}), crc32.ChecksumIEEE(make([]byte, 8)); got != want {
+		t.Errorf("RAMHash($2000-$2007) = %08x, want %08x", got, want)
+	}
+	before := c.RAMHash()
+	c.Poke(0x10, c.Peek(0x10)+1)
+	if c.RAMHash() == before {
+		t.Errorf("RAMHash() didn't change when RAM did")
+	}
+
+	// Cartridge space is peeked, for mappers that can.
+	m := &peekMapper{Mapper: mappers.Dummy}
+	c = New(m)
+	m.reads = 0 // The reset vector
+	c.RAMHash(Range{0x6000, 0x60FF})
+	if m.reads != 0 || m.peeks != 0x100 {
+		t.Errorf("RAMHash($6000-$60FF) made %d reads and %d peeks, want 0 and 256", m.reads, m.peeks)
+	}
+}
+
+// peekMapper counts the PRG reads and peeks made of a mapper.
+type peekMapper struct {
+	mappers.Mapper
+	reads, peeks int
+}
+
+func (m *peekMapper) PrgRead(addr uint16) uint8 {
+	m.reads++
+	return m.Mapper.PrgRead(addr)
+}
+
+func (m *peekMapper) PrgPeek(addr uint16) uint8 {
+	m.peeks++
+	return m.Mapper.PrgRead(addr)
+}
+
+func TestScript(t *testing.T) {
+	s, err := ReadScript(strings.NewReader(`# frame player buttons
+10 0 start
+12 1 A,right # two at once
+20 0 -
+`))
+	if err != nil {
+		t.Fatalf("ReadScript() = %v", err)
+	}
+
+	cases := []struct {
+		frame int
+		want  Inputs
+	}{
+		{0, Inputs{}},
+		{10, Inputs{Buttons: [PLAYERS]uint8{BUTTON_START, 0}}},
+		{15, Inputs{Buttons: [PLAYERS]uint8{BUTTON_START, BUTTON_A | BUTTON_RIGHT}}},
+		{20, Inputs{Buttons: [PLAYERS]uint8{0, BUTTON_A | BUTTON_RIGHT}}},
+	}
+	for _, tc := range cases {
+		if got := s.Inputs(tc.frame); got != tc.want {
+			t.Errorf("Inputs(%d) = %+v, want %+v", tc.frame, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"10 0", "x 0 a", "10 4 a", "10 0 turbo", "10 0 a\n5 0 b"} {
+		if _, err := ReadScript(strings.NewReader(bad)); err == nil {
+			t.Errorf("ReadScript(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestPowerOn(t *testing.T) {
+	c := testConsole(t)
+	ram := c.Memory(MEMORY_SYSTEM_RAM)
+	if !bytes.Equal(ram, make([]byte, NES_BASE_MEMORY)) {
+		t.Errorf("RAM isn't all zeros at power on")
+	}
+
+	c.SetPowerOn(PowerOn{RAM: RAM_ONES})
+	if !bytes.Equal(ram, bytes.Repeat([]byte{0xFF}, NES_BASE_MEMORY)) {
+		t.Errorf("RAM isn't all $FF with RAM_ONES")
+	}
+
+	c.SetPowerOn(PowerOn{RAM: RAM_RANDOM, Seed: 1, Alignment: 2})
+	first := append([]byte(nil), ram...)
+	if c.ticks != 2 || c.cpuCycle() {
+		t.Errorf("ticks = %d, cpuCycle() = %t; want 2, false", c.ticks, c.cpuCycle())
+	}
+	c.RunFrame(Inputs{})
+	c.SetPowerOn(PowerOn{RAM: RAM_RANDOM, Seed: 1})
+	if !bytes.Equal(ram, first) {
+		t.Errorf("RAM differs between power ons with the same seed")
+	}
+	c.SetPowerOn(PowerOn{RAM: RAM_RANDOM, Seed: 2})
+	if bytes.Equal(ram, first) {
+		t.Errorf("RAM is the same with a different seed")
+	}
+}
+
+func TestCycleMode(t *testing.T) {
+	c := testConsole(t)
+	c.SetCycleMode(true)
+	m, err := mappers.Load("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+	quirky := New(quirked{Mapper: m, cycleMode: true})
+
+	// Every CPU cycle should be clocked exactly once, including
+	// after a power cycle replaces the CPU, and when a game's quirks
+	// turn cycle mode on.
+	for i, power := range []bool{false, true, false} {
+		if i == 2 {
+			c = quirky
+		}
+		if power {
+			c.SetPowerOn(PowerOn{})
+		}
+		c.RunFrame(Inputs{})
+		start, cycles := c.ticks, c.cycles
+		for j := 0; j < 100; j++ {
+			c.Step()
+		}
+		if got, want := c.ticks-start, 3*(c.cycles-cycles); got != want {
+			t.Errorf("%d: Ran %d PPU ticks for %d CPU cycles, wanted %d", i, got, c.cycles-cycles, want)
+		}
+	}
+}
+
+func TestFrameHandler(t *testing.T) {
+	c := testConsole(t)
+	var frames int
+	c.SetFrameHandler(func(*image.RGBA) { frames++ })
+
+	// It survives loading a game.
+	c.LoadGame(c.Mapper())
+	for i := 0; i < 3; i++ {
+		c.RunFrame(Inputs{})
+	}
+	if frames != 3 {
+		t.Errorf("Frame handler called %d times in 3 frames, wanted 3", frames)
+	}
+}
+
+func TestBreakpoint(t *testing.T) {
+	c := testConsole(t)
+	c.RunFrame(Inputs{})
+
+	var hit []mos6502.Break
+	c.SetBreakHandler(func(b mos6502.Break) { hit = append(hit, b) })
+	pc := c.CPU().PC()
+	c.AddBreakpoint(pc)
+
+	// It's hit straight away, before the instruction at pc runs.
+	start := c.ppu.Frame()
+	c.RunFrame(Inputs{})
+	if len(hit) != 1 || hit[0].PC != pc || !c.Paused() || c.ppu.Frame() != start {
+		t.Errorf("Got breaks %+v, paused %t, frame %d; wanted a break at 0x%04x, paused, still frame %d", hit, c.Paused(), c.ppu.Frame(), pc, start)
+	}
+}
+
+// TestInspectWhileRunning samples the CPU from another goroutine while
+// Run is going; run it with -race to check the locking.
+func TestInspectWhileRunning(t *testing.T) {
+	c := testConsole(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	var last uint64
+	for i := 0; i < 50 || last == 0; i++ {
+		s := c.CPUSnapshot()
+		if s.Cycles < last {
+			t.Errorf("Cycles went from %d to %d", last, s.Cycles)
+		}
+		last = s.Cycles
+		c.Inspect(func(cpu *mos6502.CPU, _ *ppu.PPU) {
+			if cpu.PC() != cpu.Registers().PC {
+				t.Errorf("PC changed while inspecting")
+			}
+		})
+	}
+	cancel()
+	<-done
+
+	if s := c.CPUSnapshot(); s.Cycles == 0 || s.Registers != c.CPU().Registers() {
+		t.Errorf("CPUSnapshot() = %+v, wanted the running CPU's state", s)
+	}
+}
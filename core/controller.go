@@ -0,0 +1,124 @@
+package core
+
+import "github.com/bdwalton/gintendo/nesrom"
+
+// Buttons, as the bits the controller reports them in.
+const (
+	BUTTON_A = 1 << iota
+	BUTTON_B
+	BUTTON_SELECT
+	BUTTON_START
+	BUTTON_UP
+	BUTTON_DOWN
+	BUTTON_LEFT
+	BUTTON_RIGHT
+)
+
+// ButtonNames are the buttons by name, as input scripts and the
+// remote control API know them.
+var ButtonNames = map[string]uint8{
+	"a":      BUTTON_A,
+	"b":      BUTTON_B,
+	"select": BUTTON_SELECT,
+	"start":  BUTTON_START,
+	"up":     BUTTON_UP,
+	"down":   BUTTON_DOWN,
+	"left":   BUTTON_LEFT,
+	"right":  BUTTON_RIGHT,
+}
+
+// PLAYERS is the number of controllers, counting the two a Four Score
+// adds.
+const PLAYERS = 4
+
+// fourScoreSignatures are what a Four Score sends on each port after
+// the buttons of its two controllers.
+var fourScoreSignatures = [2]uint8{0x08, 0x04}
+
+type controller struct {
+	strobe  bool
+	buttons uint32 // the bits latched for the CPU to read, bit 0 first
+	idx     uint8
+	held    uint8 // BUTTON_XXX bits set by the frontend
+	inject  uint8 // and those set by InjectButtons
+}
+
+// write sets the strobe line. Its buttons are latched as it falls,
+// followed by the 16 bits in more: all 1s for a standard controller,
+// or what a Four Score sends after the first controller on its port,
+// the second's buttons and its signature. Reads past those return 1.
+func (c *controller) write(val uint8, more uint16) {
+	switch val & 0x01 {
+	case 0:
+		c.strobe = false
+		c.buttons = 0xFF000000 | uint32(more)<<8 | uint32(c.held|c.inject)
+
+	case 1:
+		c.strobe = true
+		c.idx = 0
+	}
+}
+
+func (c *controller) read() uint8 {
+	ret := c.peek()
+	if c.idx < 32 {
+		c.idx++
+	}
+	return ret
+}
+
+// peek returns what read would, without moving on to the next
+// button.
+func (c *controller) peek() uint8 {
+	if c.idx > 31 {
+		return 1
+	}
+	return uint8(c.buttons >> c.idx & 1)
+}
+
+// strobe sets the strobe line the two controller ports share.
+func (c *Console) strobe(val uint8) {
+	for i := 0; i < 2; i++ {
+		more := uint16(0xFFFF)
+		if c.input == nesrom.INPUT_FOUR_SCORE {
+			ct := &c.controllers[i+2]
+			more = uint16(fourScoreSignatures[i])<<8 | uint16(ct.held|ct.inject)
+		}
+		c.controllers[i].write(val, more)
+	}
+}
+
+// readPort returns the low bits of $4016 or $4017 from whatever's
+// plugged into that port. Controllers move on to their next button,
+// unless peek is set.
+func (c *Console) readPort(addr uint16, peek bool) uint8 {
+	if addr == CONT2 && c.input == nesrom.INPUT_ZAPPER {
+		return c.zapperBits()
+	}
+	ct := &c.controllers[addr-CONT1]
+	if peek {
+		return ct.peek()
+	}
+	return ct.read()
+}
+
+// SetButtons sets the buttons (BUTTON_XXX bits) held on controller
+// player, 0 to 3. Games see them the next time they read the
+// controller. Players 2 and 3 are only connected for games that use a
+// Four Score.
+func (c *Console) SetButtons(player int, buttons uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.controllers[player].held = buttons
+}
+
+// InjectButtons holds buttons on controller player on top of those
+// set by the frontend, until they're changed by another call. It lets
+// scripts and remote control play alongside the local controls.
+func (c *Console) InjectButtons(player int, buttons uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.controllers[player].inject = buttons
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"image"
+
+	"github.com/bdwalton/gintendo/mos6502"
+	"github.com/bdwalton/gintendo/ppu"
+)
+
+// AddBreakpoint stops the console before it runs the instruction at
+// addr. Breakpoints and watchpoints last until they're cleared or the
+// console is power cycled, which includes loading a game.
+func (c *Console) AddBreakpoint(addr uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cpu.AddBreakpoint(addr)
+}
+
+// RemoveBreakpoint removes the breakpoint at addr.
+func (c *Console) RemoveBreakpoint(addr uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cpu.RemoveBreakpoint(addr)
+}
+
+// AddWatchpoint stops the console after a CPU instruction reads from
+// or writes to addr, as chosen. Accesses by the PPU and DMA aren't
+// seen.
+func (c *Console) AddWatchpoint(addr uint16, onRead, onWrite bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cpu.AddWatchpoint(addr, onRead, onWrite)
+}
+
+// ClearBreakpoints removes all breakpoints and watchpoints.
+func (c *Console) ClearBreakpoints() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cpu.ClearBreakpoints()
+}
+
+// SetBreakHandler sets f to be called when a breakpoint or watchpoint
+// is hit. The console pauses itself, and RunFrame and RunTicks return
+// early, so a debugger can take over. f is called with the console
+// locked, so it mustn't call the console's methods itself.
+func (c *Console) SetBreakHandler(f func(mos6502.Break)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.breakHandler = f
+}
+
+// SetFrameHandler sets f to be called with each frame's picture as
+// soon as the PPU finishes drawing it, for recorders and headless
+// tests; see ppu.PPU.OnFrame. f is called with the console locked, so
+// it mustn't call the console's methods, and it should copy the
+// picture to keep it. A nil f removes the handler.
+func (c *Console) SetFrameHandler(f func(*image.RGBA)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onFrame = f
+	c.ppu.OnFrame(f)
+}
+
+// SetSymbols names addresses in the CPU's disassembly, such as the
+// debugger's. They're kept across power cycles, as they usually come
+// with the game.
+func (c *Console) SetSymbols(s mos6502.Symbols) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.symbols = s
+	c.cpu.SetSymbols(s)
+}
+
+// CPUSnapshot returns a copy of the CPU's state. Unlike going through
+// CPU, it's safe while the console is running: it waits for Run to
+// finish its current slice of ticks, so the state is always from an
+// instruction boundary.
+func (c *Console) CPUSnapshot() mos6502.Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.cpu.Snapshot()
+}
+
+// Inspect calls f with the console's CPU and PPU while it's held
+// between instructions, so a debugger running in another goroutine
+// can look at or change anything without racing Run. f mustn't keep
+// them or call the console's methods.
+func (c *Console) Inspect(f func(cpu *mos6502.CPU, p *ppu.PPU)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f(c.cpu, c.ppu)
+}
+
+// onBreak is the CPU's break handler.
+func (c *Console) onBreak(b mos6502.Break) {
+	c.paused = true
+	c.broke = true
+	if c.breakHandler != nil {
+		c.breakHandler(b)
+	}
+}
@@ -0,0 +1,52 @@
+package core
+
+import "github.com/bdwalton/gintendo/ppu"
+
+// Inputs is the state of the controls for a frame.
+type Inputs struct {
+	Buttons [PLAYERS]uint8 // BUTTON_XXX bits held on each controller
+	Zapper  Zapper         // For games that use one
+	Vs      uint8          // VS_XXX bits, for Vs. System games
+}
+
+// RunFrame sets the controls to in and emulates until the PPU
+// finishes a frame. It returns the picture as RGBA pixels, four bytes
+// each, a row at a time (see Resolution), and the audio generated
+// during the frame. The video slice is reused by the next call to
+// RunFrame; copy it to keep it. A breakpoint (see SetBreakHandler)
+// ends the frame early.
+//
+// There's no APU yet, so audio is always empty.
+func (c *Console) RunFrame(in Inputs) (video []byte, audio []int16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, b := range in.Buttons {
+		c.controllers[i].held = b
+	}
+	c.zapper = in.Zapper
+	c.vsInputs = in.Vs
+
+	c.broke = false
+	for f := c.ppu.Frame(); c.ppu.Frame() == f && !c.broke; {
+		c.step()
+	}
+
+	c.video = append(c.video[:0], c.ppu.Pixels()...)
+	return c.video, nil
+}
+
+// VideoFrame appends the PPU's current picture to dst, in the same
+// form as RunFrame returns it, for frontends that run the console
+// with Run and draw at their own pace.
+func (c *Console) VideoFrame(dst []byte) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append(dst, c.ppu.Pixels()...)
+}
+
+// Resolution returns the width and height of the picture in pixels.
+func (c *Console) Resolution() (int, int) {
+	return ppu.NES_RES_WIDTH, ppu.NES_RES_HEIGHT
+}
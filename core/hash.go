@@ -0,0 +1,48 @@
+package core
+
+import "hash/crc32"
+
+// Range is a span of the CPU's address space, Start to End inclusive.
+type Range struct {
+	Start, End uint16
+}
+
+// FrameHash returns the CRC-32 (IEEE) of the PPU's current picture, as
+// VideoFrame returns it, so test drivers can compare frames without
+// fetching them.
+func (c *Console) FrameHash() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return crc32.ChecksumIEEE(c.ppu.Pixels())
+}
+
+// RAMHash returns the CRC-32 (IEEE) of the memory in ranges, one
+// after the other, as the CPU sees it. Without ranges it's the hash of
+// system RAM. The registers at $2000-$401F count as 0, and the rest is
+// peeked rather than read, so that hashing doesn't change the console's
+// state.
+func (c *Console) RAMHash(ranges ...Range) uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(ranges) == 0 {
+		return crc32.ChecksumIEEE(c.ram)
+	}
+
+	var buf []byte
+	h := crc32.NewIEEE()
+	for _, r := range ranges {
+		buf = buf[:0]
+		for a := int(r.Start); a <= int(r.End); a++ {
+			if a >= 0x2000 && a < 0x4020 {
+				buf = append(buf, 0)
+			} else {
+				buf = append(buf, c.peek(uint16(a)))
+			}
+		}
+		h.Write(buf)
+	}
+
+	return h.Sum32()
+}
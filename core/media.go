@@ -0,0 +1,46 @@
+package core
+
+import "github.com/bdwalton/gintendo/mappers"
+
+// SwitchDiskSide flips or swaps the disk for Disk System games. It
+// returns the side being inserted, counting from 0, and false if the
+// cartridge isn't a Disk System.
+func (c *Console) SwitchDiskSide() (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ds, ok := c.mapper.(mappers.DiskSystem)
+	if !ok {
+		return 0, false
+	}
+	return ds.SwitchSide(), true
+}
+
+// ChangeTrack moves delta tracks through an NSF and restarts the CPU
+// so that the driver calls INIT for the new track. It does nothing
+// for games.
+func (c *Console) ChangeTrack(delta int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.mapper.(mappers.Player)
+	if !ok || p.Tracks() == 0 {
+		return
+	}
+
+	t := (p.Track() + delta + p.Tracks()) % p.Tracks()
+	p.SelectTrack(t)
+	c.cpu.Reset()
+}
+
+// PlayerInfo describes what an NSF is playing, or returns "" for
+// games.
+func (c *Console) PlayerInfo() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if p, ok := c.mapper.(mappers.Player); ok {
+		return p.Info()
+	}
+	return ""
+}
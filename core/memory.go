@@ -0,0 +1,81 @@
+package core
+
+// Memory regions, for Memory.
+const (
+	MEMORY_SYSTEM_RAM = iota // The 2KB of RAM at $0000-$07FF
+	MEMORY_SAVE_RAM          // The cartridge's PRG RAM, if it has any
+	MEMORY_VIDEO_RAM         // The 2KB of nametable RAM
+	MEMORY_OAM               // The 256 bytes of sprite memory
+	MEMORY_PALETTE           // The 32 bytes of palette RAM
+)
+
+// Memory returns the memory in region, a MEMORY_XXX value, or nil
+// for unknown regions. It's the console's own memory rather than a
+// copy, for cheat finders, achievement systems and the like, so it
+// should only be used between calls to RunFrame. Loading a different
+// game invalidates it.
+func (c *Console) Memory(region int) []uint8 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch region {
+	case MEMORY_SYSTEM_RAM:
+		return c.ram
+	case MEMORY_SAVE_RAM:
+		return c.mapper.PrgRAM()
+	case MEMORY_VIDEO_RAM:
+		return c.ppu.VRAM()
+	case MEMORY_OAM:
+		return c.ppu.OAMData()
+	case MEMORY_PALETTE:
+		return c.ppu.PaletteTable()
+	}
+	return nil
+}
+
+// Peek returns the byte at addr as the CPU sees it, for debugging
+// tools. Some addresses, like the PPU and controller registers,
+// change state when they're read, just as they do for the CPU. The
+// CPU's own debugging methods, like Disassemble, don't.
+func (c *Console) Peek(addr uint16) uint8 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.Read(addr)
+}
+
+// peek returns the byte at addr as the CPU would read it, but without
+// disturbing registers that change when they're read.
+func (c *Console) peek(addr uint16) uint8 {
+	switch {
+	case addr <= MAX_NES_BASE_RAM:
+		return c.ram[addr&0x7FF]
+	case addr <= MAX_PPU_REG_MIRRORED:
+		return c.ppu.PeekReg(addr & 0x2007)
+	case addr == CONT1 || addr == CONT2:
+		return c.controllerBits(addr, c.readPort(addr, true))
+	case addr < MAX_IO_REG, addr < 0x6000 && !c.decodes(addr):
+		return c.cpu.DataBus()
+	case c.peeker != nil:
+		return c.peeker.PrgPeek(addr)
+	}
+	return c.mapper.PrgRead(addr)
+}
+
+// cpuBus is the console as the CPU sees it. Its Peek doesn't take
+// c.mu, as the CPU's debugging methods can be called with it held.
+type cpuBus struct {
+	*Console
+}
+
+func (b cpuBus) Peek(addr uint16) uint8 {
+	return b.peek(addr)
+}
+
+// Poke writes val to addr as the CPU would.
+func (c *Console) Poke(addr uint16, val uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Write(addr, val)
+}
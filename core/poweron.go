@@ -0,0 +1,53 @@
+package core
+
+import "math/rand"
+
+// Patterns for RAM at power on, for PowerOn.
+const (
+	RAM_ZEROS  = iota // Every byte $00
+	RAM_ONES          // Every byte $FF
+	RAM_RANDOM        // Pseudo-random bytes from PowerOn.Seed
+)
+
+// PowerOn is how the console comes up when it's switched on. Real
+// consoles start with whatever their RAM chips held and with the CPU
+// at any point in its cycle relative to the PPU, and games that depend
+// on either by mistake behave differently from one power on to the
+// next. gintendo starts the same way every time, so that replays,
+// tests and netplay match exactly across machines; PowerOn varies it
+// reproducibly. Nothing the console does depends on the wall clock.
+type PowerOn struct {
+	RAM  int   // A RAM_XXX pattern
+	Seed int64 // For RAM_RANDOM; the same seed always gives the same RAM
+
+	// Alignment is how many PPU ticks into the CPU's clock cycle
+	// the console starts: 0-2, or 0-15 for PAL.
+	Alignment int
+}
+
+// SetPowerOn sets how the console comes up and power cycles it to
+// use p. Unlike LoadGame, the cartridge isn't reset.
+func (c *Console) SetPowerOn(p PowerOn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.powerOnState = p
+	c.powerOn()
+}
+
+// fillRAM sets RAM to its power on pattern, in place so slices
+// returned by Memory stay valid.
+func (c *Console) fillRAM() {
+	switch c.powerOnState.RAM {
+	case RAM_ONES:
+		for i := range c.ram {
+			c.ram[i] = 0xFF
+		}
+	case RAM_RANDOM:
+		// The Source's sequence is fixed by the Go 1
+		// compatibility promise, so it's the same everywhere.
+		rand.New(rand.NewSource(c.powerOnState.Seed)).Read(c.ram)
+	default:
+		c.ClearMem()
+	}
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bdwalton/gintendo/nesrom"
+)
+
+// AUTO_REGION tells SetRegion to use the region detected for each
+// ROM.
+const AUTO_REGION = -1
+
+// regionNames are the names ParseRegion accepts.
+var regionNames = map[string]int{
+	"auto":  AUTO_REGION,
+	"ntsc":  nesrom.NTSC,
+	"pal":   nesrom.PAL,
+	"dendy": nesrom.DENDY,
+}
+
+// ParseRegion converts a region name (auto, ntsc, pal or dendy) into
+// a value for SetRegion.
+func ParseRegion(name string) (int, error) {
+	r, ok := regionNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown region %q; use auto, ntsc, pal or dendy", name)
+	}
+	return r, nil
+}
+
+// SetRegion forces the console to run with the timing of region
+// (nesrom.NTSC, PAL or DENDY) whatever the ROM asks for. AUTO_REGION
+// goes back to using the region detected when the ROM was loaded.
+func (c *Console) SetRegion(region int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.forcedRegion = region
+	c.applyRegion()
+}
+
+// applyRegion sets up the PPU and the CPU clock divider for the
+// current region. The NTSC and Dendy CPUs run once every 3 PPU
+// ticks, while the PAL CPU runs 5 times every 16.
+func (c *Console) applyRegion() {
+	r := c.region()
+	c.ppu.SetOverclock(c.mapper.Capabilities().Overclock)
+	c.ppu.SetRegion(r)
+	switch r {
+	case nesrom.PAL:
+		c.ppuPerCPU, c.cpuPerPPU = 16, 5
+	default:
+		c.ppuPerCPU, c.cpuPerPPU = 3, 1
+	}
+}
+
+// Region returns the region (nesrom.NTSC, PAL or DENDY) whose timing
+// the console is running with, whether forced by SetRegion or taken
+// from the ROM. Multi-region ROMs run as NTSC.
+func (c *Console) Region() uint8 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r := c.region(); r != nesrom.MULTI_REGION {
+		return r
+	}
+	return nesrom.NTSC
+}
+
+// region returns the region the console is running as.
+func (c *Console) region() uint8 {
+	if c.forcedRegion != AUTO_REGION {
+		return uint8(c.forcedRegion)
+	}
+	return c.mapper.Capabilities().Region
+}
+
+// FrameRate returns how many frames a second the console shows in the
+// region it's running as, to pace frontends and measure them against.
+func (c *Console) FrameRate() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.region() {
+	case nesrom.PAL, nesrom.DENDY:
+		return 50.0070
+	}
+	return 60.0988
+}
+
+// cpuCycle reports whether the CPU runs on the current PPU tick.
+func (c *Console) cpuCycle() bool {
+	return c.ticks*c.cpuPerPPU%c.ppuPerCPU < c.cpuPerPPU
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/bdwalton/gintendo/mappers"
+	"github.com/bdwalton/gintendo/state"
+)
+
+// STATE_VERSION is bumped whenever the save state layout changes, as
+// older states can't be loaded after that.
+const STATE_VERSION = 11
+
+// ErrNoSaveStates is returned when the cartridge's mapper can't be
+// saved.
+var ErrNoSaveStates = errors.New("mapper doesn't support save states")
+
+// SaveState writes a snapshot of the console and cartridge to w. It
+// can be restored with LoadState while the same game is loaded.
+func (c *Console) SaveState(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := state.NewWriter(w)
+	c.state(s)
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("couldn't save state: %w", err)
+	}
+	return nil
+}
+
+// LoadState restores a snapshot written by SaveState. States made by
+// other games or versions are rejected, and the console carries on
+// as it was if anything goes wrong.
+func (c *Console) LoadState(r io.Reader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A state that fails part way through would leave the console
+	// in a mess, so keep a copy of where we were.
+	var undo bytes.Buffer
+	c.state(state.NewWriter(&undo))
+
+	s := state.NewReader(r)
+	c.state(s)
+	if err := s.Err(); err != nil {
+		c.state(state.NewReader(&undo))
+		return fmt.Errorf("couldn't load state: %w", err)
+	}
+	return nil
+}
+
+// state saves or loads everything, starting with a header that
+// identifies the version and mapper.
+func (c *Console) state(s *state.Codec) {
+	m, ok := c.mapper.(mappers.Stateful)
+	if !ok {
+		s.Fail(fmt.Errorf("%w: %s", ErrNoSaveStates, c.mapper.Name()))
+		return
+	}
+
+	s.Tag("GINTENDO")
+	v := uint8(STATE_VERSION)
+	s.Uint8(&v)
+	if v != STATE_VERSION {
+		s.Fail(fmt.Errorf("%w: version %d, wanted %d", state.ErrMismatch, v, STATE_VERSION))
+	}
+	id := c.mapper.ID()
+	s.Uint16(&id)
+	if id != c.mapper.ID() {
+		s.Fail(fmt.Errorf("%w: mapper %d, wanted %d", state.ErrMismatch, id, c.mapper.ID()))
+	}
+
+	s.Tag("BUS ")
+	s.Bytes(c.ram)
+	s.Uint64(&c.ticks)
+	for i := range c.controllers {
+		ct := &c.controllers[i]
+		s.Bool(&ct.strobe)
+		s.Uint32(&ct.buttons)
+		s.Uint8(&ct.idx)
+	}
+
+	c.cpu.State(s)
+	c.ppu.State(s)
+	m.State(s)
+}
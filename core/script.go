@@ -0,0 +1,91 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Script is a list of inputs for replaying a game the same way each
+// time, such as in tests. It's written as text, a change of buttons a
+// line:
+//
+//	# frame player buttons
+//	60 0 start
+//	70 0 -
+//	120 0 right,a
+//
+// Players are 0 to 3, though 2 and 3 only play in games that use a
+// Four Score. Buttons are named as in ButtonNames and joined with
+// commas, and - releases them all. Buttons stay held until the
+// player's next line. Lines must be in frame order.
+type Script struct {
+	changes [PLAYERS][]scriptChange
+}
+
+type scriptChange struct {
+	frame   int
+	buttons uint8
+}
+
+// ReadScript reads a Script from r.
+func ReadScript(r io.Reader) (*Script, error) {
+	s := &Script{}
+	last := 0
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: want frame, player and buttons", n)
+		}
+
+		frame, err := strconv.Atoi(fields[0])
+		if err != nil || frame < last {
+			return nil, fmt.Errorf("line %d: bad frame %q", n, fields[0])
+		}
+		last = frame
+		player, err := strconv.Atoi(fields[1])
+		if err != nil || player < 0 || player >= PLAYERS {
+			return nil, fmt.Errorf("line %d: bad player %q", n, fields[1])
+		}
+
+		var buttons uint8
+		if fields[2] != "-" {
+			for _, name := range strings.Split(fields[2], ",") {
+				b, ok := ButtonNames[strings.ToLower(name)]
+				if !ok {
+					return nil, fmt.Errorf("line %d: unknown button %q", n, name)
+				}
+				buttons |= b
+			}
+		}
+		s.changes[player] = append(s.changes[player], scriptChange{frame, buttons})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read script: %w", err)
+	}
+
+	return s, nil
+}
+
+// Inputs returns the controls for frame, counting from 0.
+func (s *Script) Inputs(frame int) Inputs {
+	var in Inputs
+	for p, changes := range s.changes {
+		i := sort.Search(len(changes), func(i int) bool { return changes[i].frame > frame })
+		if i > 0 {
+			in.Buttons[p] = changes[i-1].buttons
+		}
+	}
+	return in
+}
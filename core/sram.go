@@ -0,0 +1,85 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bdwalton/gintendo/mappers"
+)
+
+// ReadSRAM restores battery backed PRG RAM from r, for frontends that
+// keep saves somewhere other than files. It does nothing for
+// cartridges without a battery.
+func (c *Console) ReadSRAM(r io.Reader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.mapper.HasSaveRAM() {
+		return nil
+	}
+	return c.mapper.LoadPrgRAM(r)
+}
+
+// WriteSRAM writes battery backed PRG RAM to w, writing nothing for
+// cartridges without a battery.
+func (c *Console) WriteSRAM(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.mapper.HasSaveRAM() {
+		return nil
+	}
+	return c.mapper.SavePrgRAM(w)
+}
+
+// SaveFile returns the conventional battery save path for romFile,
+// which is the ROM path with its extension replaced by .sav.
+func SaveFile(romFile string) string {
+	return strings.TrimSuffix(romFile, filepath.Ext(romFile)) + ".sav"
+}
+
+// ReadSaveFile fills m's battery backed RAM from path. Cartridges
+// without a battery are left alone, as is the case where path doesn't
+// exist yet. Only use it while m isn't running.
+func ReadSaveFile(m mappers.Mapper, path string) error {
+	if !m.HasSaveRAM() {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't open save file: %w", err)
+	}
+	defer f.Close()
+
+	return m.LoadPrgRAM(f)
+}
+
+// WriteSaveFile writes m's battery backed RAM to path. Cartridges
+// without a battery don't produce a save file. Only use it while m
+// isn't running.
+func WriteSaveFile(m mappers.Mapper, path string) error {
+	if !m.HasSaveRAM() {
+		return nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("couldn't create save file: %w", err)
+	}
+
+	if err := m.SavePrgRAM(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
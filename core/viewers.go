@@ -0,0 +1,60 @@
+package core
+
+import (
+	"image"
+
+	"github.com/bdwalton/gintendo/ppu"
+)
+
+// PatternTable draws one of the PPU's pattern tables; see
+// ppu.PPU.PatternTable. Unlike going through PPU, it's safe while the
+// console is running.
+func (c *Console) PatternTable(dst *image.RGBA, table int, pal uint8) *image.RGBA {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ppu.PatternTable(dst, table, pal)
+}
+
+// PatternTables draws both of the PPU's pattern tables at once; see
+// ppu.PPU.PatternTables.
+func (c *Console) PatternTables(dst [2]*image.RGBA, pal uint8) [2]*image.RGBA {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ppu.PatternTables(dst, pal)
+}
+
+// Nametables draws the PPU's nametables with the ppu.OVERLAY_XXX
+// overlays in overlays; see ppu.PPU.Nametables and
+// ppu.PPU.NametableOverlays.
+func (c *Console) Nametables(dst *image.RGBA, overlays uint8) *image.RGBA {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ppu.NametableOverlays(c.ppu.Nametables(dst), overlays)
+}
+
+// Palettes draws the PPU's palette RAM; see ppu.PPU.Palettes.
+func (c *Console) Palettes(dst *image.RGBA) *image.RGBA {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ppu.Palettes(dst)
+}
+
+// Sprites decodes the PPU's OAM; see ppu.PPU.Sprites.
+func (c *Console) Sprites() [64]ppu.Sprite {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ppu.Sprites()
+}
+
+// SpritePreview draws the sprites in OAM; see ppu.PPU.SpritePreview.
+func (c *Console) SpritePreview(dst *image.RGBA) *image.RGBA {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ppu.SpritePreview(dst)
+}
@@ -0,0 +1,61 @@
+package core
+
+import "github.com/bdwalton/gintendo/nesrom"
+
+// Vs. System inputs, read through $4016 alongside controller 1.
+// https://www.nesdev.org/wiki/Vs._System#Registers
+const (
+	VS_SERVICE = 1 << 2 // Service credit
+	VS_COIN1   = 1 << 5
+	VS_COIN2   = 1 << 6
+)
+
+// rc2c05IDs are the values the RC2C05 PPUs return in PPUSTATUS. The
+// RC2C05-05 returns none, leaving the low bits clear.
+var rc2c05IDs = map[uint8]uint8{
+	nesrom.VS_PPU_RC2C05_01: 0x1B,
+	nesrom.VS_PPU_RC2C05_02: 0x3D,
+	nesrom.VS_PPU_RC2C05_03: 0x1C,
+	nesrom.VS_PPU_RC2C05_04: 0x1B,
+	nesrom.VS_PPU_RC2C05_05: 0x00,
+}
+
+// rgbPPU reports whether the Vs. System PPU t (a VS_PPU_XXX value) is
+// one of the 2C03 or 2C05 RGB PPUs. The 2C04s have palettes of their
+// own, which Load rejects.
+func rgbPPU(t uint8) bool {
+	switch t {
+	case nesrom.VS_PPU_RP2C04_0001, nesrom.VS_PPU_RP2C04_0002, nesrom.VS_PPU_RP2C04_0003, nesrom.VS_PPU_RP2C04_0004:
+		return false
+	}
+	return t <= nesrom.VS_PPU_RC2C05_05
+}
+
+// SetDIPSwitches sets the Vs. System's eight DIP switches, with bit 0
+// being switch 1. Games use them for settings like difficulty and
+// coins per credit.
+func (c *Console) SetDIPSwitches(dips uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dips = dips
+}
+
+// SetVsInputs sets which of the Vs. System coin and service inputs
+// (VS_XXX bits) are active.
+func (c *Console) SetVsInputs(v uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.vsInputs = v
+}
+
+// vsRead returns the Vs. System bits of $4016 or $4017: coins,
+// service and DIP switches 1-2 at $4016, and DIP switches 3-8 at
+// $4017.
+func (c *Console) vsRead(addr uint16) uint8 {
+	if addr == CONT1 {
+		return c.vsInputs | (c.dips&0x03)<<3
+	}
+	return c.dips & 0xFC
+}
@@ -0,0 +1,42 @@
+package core
+
+// Zapper is the state of a Zapper light gun, for games that expect
+// one in the second controller port.
+// https://www.nesdev.org/wiki/Zapper
+type Zapper struct {
+	X, Y    int  // Where it's aimed on the picture; off it if either is outside
+	Trigger bool // Whether the trigger is pulled
+}
+
+const (
+	// ZAPPER_GLOW_LINES is how many scanlines the Zapper sees a
+	// pixel for after the PPU draws it.
+	ZAPPER_GLOW_LINES = 20
+
+	// ZAPPER_BRIGHTNESS is the average of a pixel's red, green and
+	// blue that the Zapper sees as light.
+	ZAPPER_BRIGHTNESS = 0xC0
+)
+
+// SetZapper sets where the Zapper is aimed and whether its trigger is
+// pulled, for frontends that use Run rather than RunFrame.
+func (c *Console) SetZapper(z Zapper) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.zapper = z
+}
+
+// zapperBits returns what the Zapper puts on $4017: bit 3 clear while
+// it sees light and bit 4 set while its trigger is pulled.
+func (c *Console) zapperBits() uint8 {
+	v := uint8(0x08)
+	px, ok := c.ppu.Drawn(c.zapper.X, c.zapper.Y, ZAPPER_GLOW_LINES)
+	if ok && int(px.R)+int(px.G)+int(px.B) >= 3*ZAPPER_BRIGHTNESS {
+		v = 0
+	}
+	if c.zapper.Trigger {
+		v |= 0x10
+	}
+	return v
+}
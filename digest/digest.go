@@ -0,0 +1,204 @@
+// Package digest logs a compact summary of a console's state every
+// frame, and compares two such logs to find where the runs they came
+// from diverged, such as netplay peers or a game before and after a
+// change to the emulator.
+//
+// A log has a line per frame of space separated name=value fields,
+// the names prefixed by the part of the console they describe:
+//
+//	frame=120 cpu.a=00 cpu.x=1f ... ram.zp=1a2b3c4d ... video.crc=5e6f7a8b
+//
+// Registers are given in full and memory as CRC-32s.
+package digest
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/frontend"
+)
+
+// Line returns the digest line for c. It reads the CPU and PPU
+// directly, so c mustn't be running. The frame and cycle counts are
+// the PPU's and CPU's own, which save states keep, rather than c's
+// Stats, which they don't, so that logs still line up after a state is
+// loaded or netplay rolls back.
+func Line(c *core.Console) string {
+	cpu := c.CPU().Registers()
+	p := c.PPU()
+	ppu := p.Registers()
+	line, dot := p.Position()
+	ram := c.Memory(core.MEMORY_SYSTEM_RAM)
+
+	return fmt.Sprintf("frame=%d cycles=%d "+
+		"cpu.a=%02x cpu.x=%02x cpu.y=%02x cpu.p=%02x cpu.sp=%02x cpu.pc=%04x "+
+		"ppu.ctrl=%02x ppu.mask=%02x ppu.status=%02x ppu.oamaddr=%02x ppu.v=%04x ppu.t=%04x ppu.x=%d ppu.w=%d ppu.line=%d ppu.dot=%d "+
+		"ram.zp=%08x ram.stack=%08x ram.main=%08x ram.save=%08x "+
+		"vram.nt=%08x vram.oam=%08x vram.palette=%08x video.crc=%08x",
+		p.Frame(), c.CPU().Cycles(),
+		cpu.A, cpu.X, cpu.Y, cpu.P, cpu.SP, cpu.PC,
+		ppu.Ctrl, ppu.Mask, ppu.Status, ppu.OAMAddr, ppu.V, ppu.T, ppu.X, ppu.W, line, dot,
+		crc32.ChecksumIEEE(ram[:0x100]), crc32.ChecksumIEEE(ram[0x100:0x200]), crc32.ChecksumIEEE(ram[0x200:]),
+		crc32.ChecksumIEEE(c.Memory(core.MEMORY_SAVE_RAM)),
+		crc32.ChecksumIEEE(c.Memory(core.MEMORY_VIDEO_RAM)), crc32.ChecksumIEEE(c.Memory(core.MEMORY_OAM)),
+		crc32.ChecksumIEEE(c.Memory(core.MEMORY_PALETTE)), c.FrameHash())
+}
+
+// Runner is a frontend.Runner that writes the digest line for each
+// frame Next runs to W.
+type Runner struct {
+	Next    frontend.Runner
+	Console *core.Console
+	W       io.Writer
+}
+
+func (r *Runner) RunFrame(in core.Inputs) ([]byte, []int16, error) {
+	video, audio, err := r.Next.RunFrame(in)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.WriteString(r.W, Line(r.Console)+"\n"); err != nil {
+		return nil, nil, fmt.Errorf("couldn't write digest: %w", err)
+	}
+	return video, audio, nil
+}
+
+// Field is a field that differs between two logs.
+type Field struct {
+	Name string
+	A, B string // The values in each log, "" if it's missing
+}
+
+// Divergence is the first frame two logs differ on.
+type Divergence struct {
+	Frame  uint64
+	Fields []Field // Sorted by name, so grouped by part
+}
+
+func (d *Divergence) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "runs diverged on frame %d:", d.Frame)
+	for _, f := range d.Fields {
+		fmt.Fprintf(&b, "\n  %-14s %s != %s", f.Name, f.A, f.B)
+	}
+	return b.String()
+}
+
+// Parts returns the parts of the console that differ, such as cpu and
+// ram.
+func (d *Divergence) Parts() []string {
+	var parts []string
+	for _, f := range d.Fields {
+		p, _, _ := strings.Cut(f.Name, ".")
+		if len(parts) == 0 || parts[len(parts)-1] != p {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// Diff compares the logs in a and b, frame by frame, and returns the
+// first Divergence, or nil if they match for as long as both go on.
+// Frames only one of them has, such as when one started later, are
+// skipped.
+func Diff(a, b io.Reader) (*Divergence, error) {
+	la, lb := newLog(a, "first"), newLog(b, "second")
+	fa, err := la.next()
+	if err != nil {
+		return nil, err
+	}
+	fb, err := lb.next()
+	if err != nil {
+		return nil, err
+	}
+
+	for fa != nil && fb != nil {
+		switch {
+		case fa.frame < fb.frame:
+			fa, err = la.next()
+		case fa.frame > fb.frame:
+			fb, err = lb.next()
+		default:
+			if d := compare(fa, fb); d != nil {
+				return d, nil
+			}
+			if fa, err = la.next(); err == nil {
+				fb, err = lb.next()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+func compare(a, b *frameFields) *Divergence {
+	d := &Divergence{Frame: a.frame}
+	for n, va := range a.fields {
+		if vb := b.fields[n]; va != vb {
+			d.Fields = append(d.Fields, Field{n, va, vb})
+		}
+	}
+	for n, vb := range b.fields {
+		if _, ok := a.fields[n]; !ok {
+			d.Fields = append(d.Fields, Field{n, "", vb})
+		}
+	}
+	if len(d.Fields) == 0 {
+		return nil
+	}
+	sort.Slice(d.Fields, func(i, j int) bool { return d.Fields[i].Name < d.Fields[j].Name })
+	return d
+}
+
+// frameFields is a parsed log line.
+type frameFields struct {
+	frame  uint64
+	fields map[string]string
+}
+
+type logReader struct {
+	name string
+	sc   *bufio.Scanner
+	n    int
+}
+
+func newLog(r io.Reader, name string) *logReader {
+	return &logReader{name: name, sc: bufio.NewScanner(r)}
+}
+
+// next returns the next frame in the log, or nil at the end.
+func (l *logReader) next() (*frameFields, error) {
+	for l.sc.Scan() {
+		l.n++
+		fields := strings.Fields(l.sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		f := &frameFields{fields: make(map[string]string, len(fields))}
+		for _, kv := range fields {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s log, line %d: bad field %q", l.name, l.n, kv)
+			}
+			f.fields[k] = v
+		}
+		frame, err := strconv.ParseUint(f.fields["frame"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s log, line %d: bad frame number", l.name, l.n)
+		}
+		f.frame = frame
+		delete(f.fields, "frame")
+		return f, nil
+	}
+	return nil, l.sc.Err()
+}
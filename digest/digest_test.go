@@ -0,0 +1,114 @@
+package digest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/frontend"
+	"github.com/bdwalton/gintendo/mappers"
+)
+
+// run returns the log of frames frames of the testdata ROM, calling
+// poke before each.
+func run(t *testing.T, frames int, poke func(f int, c *core.Console)) string {
+	t.Helper()
+
+	m, err := mappers.Load("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+	c := core.New(m)
+	var buf bytes.Buffer
+	r := &Runner{Next: frontend.Local(c), Console: c, W: &buf}
+	for f := 0; f < frames; f++ {
+		poke(f, c)
+		if _, _, err := r.RunFrame(core.Inputs{}); err != nil {
+			t.Fatalf("RunFrame() = %v", err)
+		}
+	}
+	return buf.String()
+}
+
+func TestDiff(t *testing.T) {
+	same := func(int, *core.Console) {}
+	a := run(t, 10, same)
+	if n := strings.Count(a, "\n"); n != 10 {
+		t.Fatalf("Log has %d lines, want 10:\n%s", n, a)
+	}
+
+	if d, err := Diff(strings.NewReader(a), strings.NewReader(run(t, 10, same))); d != nil || err != nil {
+		t.Errorf("Diff() of identical runs = %v, %v", d, err)
+	}
+
+	// $0221 is left alone by the test ROM.
+	b := run(t, 10, func(f int, c *core.Console) {
+		if f == 6 {
+			c.Poke(0x221, c.Peek(0x221)+1)
+		}
+	})
+	d, err := Diff(strings.NewReader(a), strings.NewReader(b))
+	if err != nil {
+		t.Fatalf("Diff() = %v", err)
+	}
+	if d == nil || d.Frame != 7 || len(d.Fields) != 1 || d.Fields[0].Name != "ram.main" {
+		t.Errorf("Diff() = %v, want ram.main on frame 7", d)
+	}
+
+	// A log starting later is compared where they overlap.
+	lines := strings.SplitAfter(b, "\n")
+	if d, _ := Diff(strings.NewReader(a), strings.NewReader(strings.Join(lines[8:], ""))); d == nil || d.Frame != 9 {
+		t.Errorf("Diff() of a later log = %v, want frame 9", d)
+	}
+}
+
+func TestLoadState(t *testing.T) {
+	var st bytes.Buffer
+	a := run(t, 10, func(f int, c *core.Console) {
+		if f == 5 {
+			if err := c.SaveState(&st); err != nil {
+				t.Fatalf("SaveState() = %v", err)
+			}
+		}
+	})
+
+	// A console that starts from the state saved before frame 6
+	// logs the same frames.
+	b := run(t, 4, func(f int, c *core.Console) {
+		if f == 0 {
+			if err := c.LoadState(&st); err != nil {
+				t.Fatalf("LoadState() = %v", err)
+			}
+		}
+	})
+	if !strings.HasPrefix(b, "frame=6 ") {
+		t.Errorf("Log after LoadState() starts %q, want frame 6", b[:strings.Index(b, " ")])
+	}
+	// The picture isn't saved, and some of frame 6's was drawn
+	// before the state was, so the logs are compared from frame 7.
+	b = b[strings.Index(b, "\n")+1:]
+	if d, err := Diff(strings.NewReader(a), strings.NewReader(b)); d != nil || err != nil {
+		t.Errorf("Diff() after LoadState() = %v, %v", d, err)
+	}
+}
+
+func TestDivergence(t *testing.T) {
+	d, err := Diff(strings.NewReader("frame=1 cpu.a=00 ppu.v=0000\nframe=2 cpu.a=01 cpu.x=02 ppu.v=0001\n"),
+		strings.NewReader("frame=1 cpu.a=00 ppu.v=0000\nframe=2 cpu.a=01 cpu.x=03 ppu.v=0002 ram.zp=1\n"))
+	if err != nil || d == nil {
+		t.Fatalf("Diff() = %v, %v", d, err)
+	}
+	if got := strings.Join(d.Parts(), ","); got != "cpu,ppu,ram" {
+		t.Errorf("Parts() = %s, want cpu,ppu,ram", got)
+	}
+	if want := []Field{{"cpu.x", "02", "03"}, {"ppu.v", "0001", "0002"}, {"ram.zp", "", "1"}}; len(d.Fields) != len(want) || d.Fields[2] != want[2] {
+		t.Errorf("Fields = %v, want %v", d.Fields, want)
+	}
+
+	for _, bad := range []string{"cpu.a=00\n", "frame=1 junk\n"} {
+		if _, err := Diff(strings.NewReader(bad), strings.NewReader("")); err == nil {
+			t.Errorf("Diff(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// package fds implements support for Famicom Disk System disk images
+// (.fds files). https://www.nesdev.org/wiki/FDS_file_format
+package fds
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	SIDE_SIZE   = 65500 // bytes of block data in each side of a .fds image
+	HEADER_SIZE = 16    // the optional fwNES header
+
+	// Block types
+	BLOCK_DISK_INFO   = 1
+	BLOCK_FILE_AMOUNT = 2
+	BLOCK_FILE_HEADER = 3
+	BLOCK_FILE_DATA   = 4
+
+	// The drive sees this many bytes of gap before the first
+	// block, and between each subsequent block.
+	LEAD_IN_GAP = 28300 / 8
+	BLOCK_GAP   = 976 / 8
+
+	// Each block starts with a mark bit after the gap and ends
+	// with a CRC.
+	BLOCK_START = 0x80
+
+	// STREAM_SIZE is the length of a side as seen by the drive,
+	// with headroom for the gaps and CRCs that the image format
+	// leaves out.
+	STREAM_SIZE = LEAD_IN_GAP + SIDE_SIZE + 8192
+)
+
+var magic = []byte{'F', 'D', 'S', 0x1A}
+
+// Disk is a set of disk sides, each held as the stream of bytes the
+// drive head passes over, gaps and CRCs included.
+type Disk struct {
+	sides [][]uint8
+}
+
+// New loads the disk image at path.
+func New(path string) (*Disk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open disk image %q: %w", path, err)
+	}
+	defer f.Close()
+
+	return Read(f)
+}
+
+// Read loads a disk image, with or without the fwNES header, from r.
+func Read(r io.Reader) (*Disk, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read disk image: %w", err)
+	}
+
+	if bytes.HasPrefix(data, magic) {
+		data = data[HEADER_SIZE:]
+	}
+
+	if len(data) == 0 || len(data)%SIDE_SIZE != 0 {
+		return nil, fmt.Errorf("disk image is %d bytes, not a multiple of %d", len(data), SIDE_SIZE)
+	}
+
+	d := &Disk{}
+	for i := 0; i < len(data); i += SIDE_SIZE {
+		d.sides = append(d.sides, toStream(data[i:i+SIDE_SIZE]))
+	}
+
+	return d, nil
+}
+
+// Write stores the disk, with an fwNES header, to w.
+func (d *Disk) Write(w io.Writer) error {
+	hdr := make([]byte, HEADER_SIZE)
+	copy(hdr, magic)
+	hdr[4] = uint8(len(d.sides))
+	if _, err := w.Write(hdr); err != nil {
+		return fmt.Errorf("couldn't write disk header: %w", err)
+	}
+
+	for i, s := range d.sides {
+		if _, err := w.Write(fromStream(s)); err != nil {
+			return fmt.Errorf("couldn't write side %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// NumSides returns the number of disk sides in the image.
+func (d *Disk) NumSides() int {
+	return len(d.sides)
+}
+
+// Side returns the drive's view of side n. The drive writes to it
+// directly.
+func (d *Disk) Side(n int) []uint8 {
+	return d.sides[n]
+}
+
+// blockLength returns the length of the block starting at data[i],
+// including its type byte, or 0 if there isn't a valid block there.
+// File data blocks take their length from the file header block that
+// precedes them, whose last byte is at index hdr.
+func blockLength(data []uint8, i int, hdr int) int {
+	switch data[i] {
+	case BLOCK_DISK_INFO:
+		return 56
+	case BLOCK_FILE_AMOUNT:
+		return 2
+	case BLOCK_FILE_HEADER:
+		return 16
+	case BLOCK_FILE_DATA:
+		if hdr < 0 {
+			return 0
+		}
+		return 1 + (int(data[hdr-2]) | int(data[hdr-1])<<8)
+	}
+
+	return 0
+}
+
+// toStream converts a side from the image format into what the drive
+// head sees, adding gaps, start marks and CRCs around each block.
+func toStream(side []uint8) []uint8 {
+	s := make([]uint8, LEAD_IN_GAP, STREAM_SIZE)
+
+	hdr := -1
+	for i := 0; i < len(side); {
+		n := blockLength(side, i, hdr)
+		if n == 0 || i+n > len(side) {
+			break
+		}
+
+		block := side[i : i+n]
+		s = append(s, BLOCK_START)
+		s = append(s, block...)
+		crc := CRC(block)
+		s = append(s, uint8(crc), uint8(crc>>8))
+		s = append(s, make([]uint8, BLOCK_GAP)...)
+
+		if side[i] == BLOCK_FILE_HEADER {
+			hdr = i + n - 1
+		} else {
+			hdr = -1
+		}
+		i += n
+	}
+
+	if len(s) < STREAM_SIZE {
+		s = append(s, make([]uint8, STREAM_SIZE-len(s))...)
+	}
+
+	return s
+}
+
+// fromStream reverses toStream, recovering the blocks (including any
+// written by the game) from the drive's view of a side.
+func fromStream(s []uint8) []uint8 {
+	side := make([]uint8, 0, SIDE_SIZE)
+
+	hdr := -1
+	for i := 0; i < len(s); i++ {
+		if s[i] != BLOCK_START {
+			continue
+		}
+		if i+1 >= len(s) {
+			break
+		}
+
+		n := blockLength(s, i+1, hdr)
+		if n == 0 || i+1+n > len(s) || len(side)+n > SIDE_SIZE {
+			break
+		}
+
+		block := s[i+1 : i+1+n]
+		side = append(side, block...)
+		if block[0] == BLOCK_FILE_HEADER {
+			hdr = i + n // the block's last byte
+		} else {
+			hdr = -1
+		}
+		i += n + 2 // skip the CRC
+	}
+
+	return append(side, make([]uint8, SIDE_SIZE-len(side))...)
+}
+
+// CRC returns the CRC the drive expects to follow data, which is the
+// CRC-16/KERMIT of the data preceded by the start mark.
+func CRC(data []uint8) uint16 {
+	var crc uint16
+	update := func(v uint8) {
+		for n := 0; n < 8; n++ {
+			carry := crc&1 == 1
+			crc >>= 1
+			if carry {
+				crc ^= 0x8408
+			}
+			if v&(1<<n) != 0 {
+				crc ^= 0x8000
+			}
+		}
+	}
+
+	update(BLOCK_START)
+	for _, v := range data {
+		update(v)
+	}
+	update(0)
+	update(0)
+
+	return crc
+}
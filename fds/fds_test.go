@@ -0,0 +1,98 @@
+package fds
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testSide returns a side holding a disk info block, a file amount
+// block and a single file of n bytes.
+func testSide(n int) []uint8 {
+	side := make([]uint8, SIDE_SIZE)
+	i := 0
+	add := func(b ...uint8) {
+		copy(side[i:], b)
+		i += len(b)
+	}
+
+	info := make([]uint8, 56)
+	info[0] = BLOCK_DISK_INFO
+	copy(info[1:], "*NINTENDO-HVC*")
+	add(info...)
+	add(BLOCK_FILE_AMOUNT, 1)
+	hdr := make([]uint8, 16)
+	hdr[0] = BLOCK_FILE_HEADER
+	hdr[13], hdr[14] = uint8(n), uint8(n>>8)
+	add(hdr...)
+	add(BLOCK_FILE_DATA)
+	for j := 0; j < n; j++ {
+		add(uint8(j))
+	}
+
+	return side
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 300, 0x1234} {
+		side := testSide(n)
+		s := toStream(side)
+
+		if len(s) != STREAM_SIZE {
+			t.Errorf("%d: stream is %d bytes, wanted %d", n, len(s), STREAM_SIZE)
+		}
+		if s[LEAD_IN_GAP] != BLOCK_START || s[LEAD_IN_GAP+1] != BLOCK_DISK_INFO {
+			t.Errorf("%d: stream doesn't start with a disk info block after the gap", n)
+		}
+		if got := fromStream(s); !bytes.Equal(got, side) {
+			t.Errorf("%d: side didn't survive a round trip through the stream", n)
+		}
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	img := append(testSide(10), testSide(20)...)
+
+	d, err := Read(bytes.NewReader(img))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	if d.NumSides() != 2 {
+		t.Errorf("NumSides() = %d, wanted 2", d.NumSides())
+	}
+
+	var b bytes.Buffer
+	if err := d.Write(&b); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if got := b.Bytes(); !bytes.Equal(got[:4], magic) || !bytes.Equal(got[HEADER_SIZE:], img) {
+		t.Errorf("Write() didn't reproduce the image")
+	}
+
+	if _, err := Read(bytes.NewReader(img[:100])); err == nil {
+		t.Errorf("Read() accepted a truncated image")
+	}
+}
+
+func TestCRC(t *testing.T) {
+	// A stream of a block followed by its CRC leaves the drive's
+	// running CRC at zero.
+	block := []uint8{BLOCK_FILE_AMOUNT, 7}
+	crc := CRC(block)
+
+	var acc uint16
+	for _, v := range append([]uint8{BLOCK_START, block[0], block[1]}, uint8(crc), uint8(crc>>8)) {
+		for n := 0; n < 8; n++ {
+			carry := acc&1 == 1
+			acc >>= 1
+			if carry {
+				acc ^= 0x8408
+			}
+			if v&(1<<n) != 0 {
+				acc ^= 0x8000
+			}
+		}
+	}
+	if acc != 0 {
+		t.Errorf("CRC(%v) = 0x%04x didn't zero the running CRC (0x%04x)", block, crc, acc)
+	}
+}
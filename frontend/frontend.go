@@ -0,0 +1,79 @@
+// Package frontend defines how a console is shown to the player, so
+// that the display, sound and input backend can be chosen when
+// gintendo is built or run. The console package provides an ebiten
+// window, the terminal package a text terminal, and Null runs without
+// any.
+package frontend
+
+import (
+	"context"
+	"time"
+
+	"github.com/bdwalton/gintendo/core"
+)
+
+// Frontend shows a console and feeds it the player's input.
+type Frontend interface {
+	// Run drives and shows c until the player quits or ctx is
+	// cancelled.
+	Run(ctx context.Context, c *core.Console) error
+}
+
+// Runner runs a console a frame at a time. Frontends given one use
+// it in place of the console's own RunFrame; a netplay.Session is one,
+// and mixes in the other player's input.
+type Runner interface {
+	RunFrame(in core.Inputs) (video []byte, audio []int16, err error)
+}
+
+// Local returns a Runner for c on its own.
+func Local(c *core.Console) Runner {
+	return local{c}
+}
+
+type local struct {
+	c *core.Console
+}
+
+func (l local) RunFrame(in core.Inputs) ([]byte, []int16, error) {
+	video, audio := l.c.RunFrame(in)
+	return video, audio, nil
+}
+
+// Null is a Frontend with no display or input, for headless machines
+// where the console is watched through metrics or driven by the
+// remote control API.
+type Null struct {
+	Unthrottled bool   // Run as fast as possible rather than 60 frames a second, without a Runner
+	Runner      Runner // Runs the frames; the console itself when nil
+}
+
+// frameTime is how often Null runs a frame.
+const frameTime = time.Second / 60
+
+func (n Null) Run(ctx context.Context, c *core.Console) error {
+	r := n.Runner
+	if r == nil {
+		if n.Unthrottled {
+			c.Run(ctx)
+			return nil
+		}
+		r = Local(c)
+	}
+
+	t := time.NewTicker(frameTime)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			if c.Paused() {
+				continue
+			}
+			if _, _, err := r.RunFrame(core.Inputs{}); err != nil {
+				return err
+			}
+		}
+	}
+}
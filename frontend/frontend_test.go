@@ -0,0 +1,30 @@
+package frontend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/mappers"
+)
+
+func TestNull(t *testing.T) {
+	m, err := mappers.Load("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+
+	for _, n := range []Null{{}, {Unthrottled: true}} {
+		c := core.New(m)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		if err := n.Run(ctx, c); err != nil {
+			t.Errorf("%+v: Run() = %v", n, err)
+		}
+		cancel()
+
+		if c.Stats().Frames == 0 {
+			t.Errorf("%+v: no frames were run", n)
+		}
+	}
+}
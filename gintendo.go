@@ -1,38 +1,655 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"flag"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
 	"os"
+	"strconv"
 
-	"github.com/bdwalton/gintendo/console"
+	"github.com/bdwalton/gintendo/cheevos"
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/digest"
+	"github.com/bdwalton/gintendo/frontend"
+	"github.com/bdwalton/gintendo/importstate"
 	"github.com/bdwalton/gintendo/mappers"
-	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/bdwalton/gintendo/metrics"
+	"github.com/bdwalton/gintendo/mos6502"
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/netplay"
+	"github.com/bdwalton/gintendo/remote"
+	"github.com/bdwalton/gintendo/selfcheck"
+	"github.com/bdwalton/gintendo/terminal"
+	"github.com/bdwalton/gintendo/testroms"
 )
 
-var romFile = flag.String("nes_rom", "", "Path to NES ROM to run.")
+var (
+	romFile         = flag.String("nes_rom", "", "Path to NES ROM to run.")
+	watchROM        = flag.Bool("watch", false, "Reload the ROM whenever the file changes. Handy when developing homebrew.")
+	fdsBIOS         = flag.String("fds_bios", mappers.FDSBIOS, "Path to the Famicom Disk System BIOS, used for .fds disk images.")
+	cartDB          = flag.String("cartdb", "", "Path to a NesCartDB XML file used to correct bad ROM headers.")
+	quirksFile      = flag.String("quirks", "", "Path to extra per-game quirks (forced mirroring, input devices, overclocking) in the format of nesrom/quirks.txt.")
+	checkROM        = flag.Bool("check_rom", false, "Report problems with the ROM's header and exit.")
+	repairROM       = flag.String("repair_rom", "", "Write a copy of the ROM with a repaired header to this path and exit.")
+	fallbackMapper  = flag.Bool("fallback_mapper", false, "Use NROM mapping for ROMs with an unsupported mapper instead of refusing to run them.")
+	patchFile       = flag.String("patch", "", "Path to an IPS or BPS patch to apply to the ROM. Without one, a patch named after the ROM (game.ips for game.nes) is used if present.")
+	lazyROM         = flag.Bool("lazy_rom", false, "Read PRG and CHR ROM from the file as they're used instead of all at start up. Useful for very large multicart ROMs.")
+	region          = flag.String("region", "auto", "Console region to emulate: auto, ntsc, pal or dendy. Auto uses the ROM header, the cartridge database and hints in the file name.")
+	vsDIPs          = flag.Uint("vs_dips", 0, "DIP switch settings for Vs. System games, with bit 0 as switch 1.")
+	prgFile         = flag.String("prg", "", "Path to a bare PRG ROM image to run instead of -nes_rom.")
+	chrFile         = flag.String("chr", "", "Path to a bare CHR ROM image to use with -prg. Without one, the cartridge has CHR RAM.")
+	mapperID        = flag.Uint("mapper", 0, "Mapper id for the -prg image.")
+	mirroring       = flag.String("mirroring", "h", "Mirroring for the -prg image: h(orizontal), v(ertical) or 4 (four screen).")
+	metricsAddr     = flag.String("metrics_addr", "", "Serve Prometheus metrics at /metrics on this address (eg localhost:9086).")
+	frontendName    = flag.String("frontend", "", "How to show the game: window, terminal or null (no display, for use with -control_addr or -metrics_addr). The default is window, or terminal when built without ebiten (-tags noebiten).")
+	terminalSixel   = flag.Bool("terminal_sixel", false, "Draw with sixel graphics in the terminal frontend rather than half block characters.")
+	terminalSkip    = flag.Int("terminal_skip", 1, "Frames to skip between those drawn in the terminal, to save bandwidth over slow connections.")
+	controlAddr     = flag.String("control_addr", "", "Serve the remote control API on this address (eg localhost:8086). It allows anyone who can reach it to control the emulator.")
+	netplayHost     = flag.String("netplay_host", "", "Host a two player netplay game on this address (eg :7845), waiting for the other player to join before starting.")
+	netplayJoin     = flag.String("netplay_join", "", "Join the netplay game hosted at this address (eg example.com:7845) as player 2. Both players need the same ROM.")
+	netplayDelay    = flag.Int("netplay_delay", 2, "Frames of input delay in a hosted netplay game. More hides more network latency.")
+	raUser          = flag.String("ra_user", "", "RetroAchievements user to play as, showing achievements as they unlock. The password is taken from $GINTENDO_RA_PASSWORD.")
+	raSet           = flag.String("ra_set", "", "Path to a game's RetroAchievements set, as the server sends it, to use instead of fetching it with -ra_user.")
+	avDiag          = flag.Bool("av_diag", false, "Measure frame pacing: how steadily frames are shown and how far emulation drifts from real time. The window shows it as it runs, and it's logged on exit.")
+	importState     = flag.String("import_state", "", "Start from this FCEUX (.fcs) or Mesen 2 (.mss) save state of the game.")
+	convertState    = flag.String("convert_state", "", "Write the -import_state save state to this path in gintendo's format and exit.")
+	selfCheck       = flag.Int("selfcheck", 0, "Run the game this many frames on two consoles at once, headless, checking they stay identical, and exit. Non-zero exit status means they diverged.")
+	selfCheckEvery  = flag.Int("selfcheck_every", 60, "Compare the -selfcheck consoles' picture and RAM every this many frames.")
+	selfCheckSaves  = flag.Bool("selfcheck_roundtrip", false, "Move the second -selfcheck console's state to a newly made console at each comparison, to catch state missing from save states.")
+	pprofAddr       = flag.String("pprof", "", "Serve Go's profiler (net/http/pprof) on this address (eg localhost:6060), and break down the time taken by each frame in the window and the log.")
+	powerOnRAM      = flag.String("power_on_ram", "zeros", "What RAM holds at power on: zeros, ones or random (from -seed). Real consoles vary, and some games depend on it by mistake.")
+	seed            = flag.Int64("seed", 0, "Seed for -power_on_ram random. The same seed gives the same RAM on any machine.")
+	cycleCPU        = flag.Bool("cycle_cpu", false, "Run the PPU and mapper during each CPU instruction, a cycle at a time. Slower, but more accurate for games with tight timing.")
+	cpuAlignment    = flag.Int("cpu_alignment", 0, "PPU ticks into the CPU's clock cycle to start at power on: 0-2, or 0-15 for PAL. Real consoles vary.")
+	digestLog       = flag.String("digest_log", "", "Write a summary of the console's state every frame to this file, for comparing runs with gintendo diff-digests.")
+	inputScript     = flag.String("input_script", "", "Path to a script of controller inputs for -selfcheck (see core.Script).")
+	symbolsFile     = flag.String("symbols", "", "Path to symbols for the game's code, an FCEUX name list (.nl) or a ca65 debug file (.dbg), to name addresses in the debugger's disassembly.")
+	netplayRollback = flag.Bool("netplay_rollback", false, "Use rollback rather than lockstep in a hosted netplay game, so the game doesn't wait for the other player's input.")
+)
+
+// symbols are loaded from -symbols, once for every console.
+var symbols mos6502.Symbols
+
+// mirroringModes maps the values accepted by -mirroring to header
+// mirroring modes.
+var mirroringModes = map[string]uint8{
+	"h": nesrom.MIRROR_HORIZONTAL,
+	"v": nesrom.MIRROR_VERTICAL,
+	"4": nesrom.MIRROR_FOUR_SCREEN,
+}
+
+// powerOnRAMs maps the values accepted by -power_on_ram to RAM
+// patterns.
+var powerOnRAMs = map[string]int{
+	"zeros":  core.RAM_ZEROS,
+	"ones":   core.RAM_ONES,
+	"random": core.RAM_RANDOM,
+}
+
+// checkHeader reports on the header of romFile and, if out is set,
+// writes a repaired copy there. It returns the process exit status.
+func checkHeader(romFile, out string) int {
+	data, _, err := nesrom.ReadFile(romFile)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	if r, err := nesrom.NewFromBytes(data); err == nil {
+		fmt.Printf("%s: CRC32 %08X, SHA1 %s\n", romFile, r.CRC32(), r.SHA1())
+		if ci, ok := nesrom.KnownBadHeader(r); ok {
+			fmt.Printf("%s: known bad header, corrected to mapper %d when run\n", romFile, ci.Mapper)
+		}
+	}
+
+	issues := nesrom.CheckHeader(data)
+	for _, i := range issues {
+		fmt.Printf("%s: %s\n", romFile, i)
+	}
+	if len(issues) == 0 {
+		fmt.Printf("%s: header looks fine\n", romFile)
+	}
+
+	if out == "" {
+		return 0
+	}
+
+	fixed, err := nesrom.RepairHeader(data)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	if err := os.WriteFile(out, fixed, 0644); err != nil {
+		log.Printf("Couldn't write repaired ROM: %v", err)
+		return 1
+	}
+	fmt.Printf("Wrote repaired ROM to %s\n", out)
+
+	return 0
+}
+
+// frontendConfig holds what main knows that frontends may want.
+type frontendConfig struct {
+	saveFile string           // the ROM's battery save
+	watchROM string           // the ROM to reload when it changes, with -watch
+	rom      string           // the ROM being played
+	patch    string           // -patch, for reloading rom
+	fallback bool             // -fallback_mapper
+	message  string           // a warning to show the player
+	metrics  *metrics.Metrics // nil without -metrics_addr
+	sync     *metrics.Sync    // nil without -av_diag
+	profile  *metrics.Profile // nil without -pprof
+	runner   frontend.Runner  // the netplay session or achievements, if there are any
+	messages chan string      // for the player's attention, such as achievements unlocked
+}
+
+// frontends build the frontends that -frontend chooses from, by
+// name. window.go adds the ebiten window, unless gintendo is built
+// with the noebiten tag.
+var frontends = map[string]func(frontendConfig) frontend.Frontend{
+	"terminal": func(cfg frontendConfig) frontend.Frontend {
+		return &terminal.Frontend{
+			In:  os.Stdin,
+			Out: os.Stdout,
+			Options: terminal.Options{
+				Sixel:     *terminalSixel,
+				FrameSkip: *terminalSkip,
+				Runner:    cfg.runner,
+				Messages:  cfg.messages,
+				Sync:      cfg.sync,
+				Profile:   cfg.profile,
+			},
+		}
+	},
+	"null": func(cfg frontendConfig) frontend.Frontend {
+		return frontend.Null{Runner: cfg.runner}
+	},
+}
+
+// defaultFrontend is used when -frontend isn't given.
+var defaultFrontend = "terminal"
+
+// netplayAddr adds the default netplay port to addr if it has none.
+func netplayAddr(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(addr, strconv.Itoa(netplay.PORT))
+	}
+	return addr
+}
+
+// startNetplay hosts or joins a netplay game on c for -netplay_host
+// or -netplay_join. It returns nil if neither was given.
+func startNetplay(c *core.Console) (*netplay.Session, error) {
+	var conn net.Conn
+	var err error
+	switch {
+	case *netplayHost != "":
+		l, err := net.Listen("tcp", netplayAddr(*netplayHost))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't host netplay: %w", err)
+		}
+		log.Printf("Waiting for player 2 to join on %s", l.Addr())
+		conn, err = l.Accept()
+		l.Close()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't host netplay: %w", err)
+		}
+		log.Printf("Player 2 joined from %s", conn.RemoteAddr())
+
+		s, err := netplay.Host(conn, c, netplay.Options{Delay: *netplayDelay, Rollback: *netplayRollback})
+		if err != nil {
+			conn.Close()
+		}
+		return s, err
+	case *netplayJoin != "":
+		if conn, err = net.Dial("tcp", netplayAddr(*netplayJoin)); err != nil {
+			return nil, fmt.Errorf("couldn't join netplay: %w", err)
+		}
+
+		s, err := netplay.Join(conn, c, 0)
+		if err != nil {
+			conn.Close()
+		}
+		return s, err
+	}
+
+	return nil, nil
+}
+
+// testROMs is the test-roms command, which runs a directory of test
+// ROMs and reports on them. It returns the process exit status: 1 if
+// any ROM failed.
+func testROMs(args []string) int {
+	fs := flag.NewFlagSet("test-roms", flag.ExitOnError)
+	format := fs.String("format", "json", "Report format: json or junit.")
+	out := fs.String("out", "", "Write the report to this file instead of standard output.")
+	jobs := fs.Int("jobs", 0, "How many ROMs to run at once. The default is one per CPU.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s test-roms [flags] <dir>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || (*format != "json" && *format != "junit") {
+		fs.Usage()
+		return 2
+	}
+
+	rep, err := testroms.Run(fs.Arg(0), *jobs)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		if w, err = os.Create(*out); err != nil {
+			log.Print(err)
+			return 2
+		}
+	}
+	if *format == "junit" {
+		err = rep.WriteJUnit(w)
+	} else {
+		err = rep.WriteJSON(w)
+	}
+	if err == nil && w != os.Stdout {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Printf("Couldn't write report: %v", err)
+		return 2
+	}
+
+	log.Printf("%d passed, %d failed, %d errors, %d skipped", rep.Passed, rep.Failed, rep.Errors, rep.Skipped)
+	if !rep.OK() {
+		return 1
+	}
+	return 0
+}
+
+// newConsole returns a console running m, set up as the flags say.
+func newConsole(m mappers.Mapper, reg int, p core.PowerOn) *core.Console {
+	c := core.New(m)
+	c.SetRegion(reg)
+	c.SetDIPSwitches(uint8(*vsDIPs))
+	c.SetPowerOn(p)
+	c.SetCycleMode(*cycleCPU)
+	c.SetSymbols(symbols)
+	return c
+}
+
+// diffDigests is the diff-digests command, which compares two
+// -digest_log files. It returns the process exit status: 1 if they
+// diverge.
+func diffDigests(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s diff-digests <log> <log>\n", os.Args[0])
+		return 2
+	}
+
+	var logs [2]*os.File
+	for i, path := range args {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Print(err)
+			return 2
+		}
+		defer f.Close()
+		logs[i] = f
+	}
+
+	d, err := digest.Diff(logs[0], logs[1])
+	switch {
+	case err != nil:
+		log.Print(err)
+		return 2
+	case d != nil:
+		fmt.Println(d)
+		return 1
+	}
+
+	fmt.Println("The runs match")
+	return 0
+}
+
+// loadMapper loads the cartridge given by the flags. fellBack is true
+// if -fallback_mapper had to replace its mapper with NROM.
+func loadMapper() (m mappers.Mapper, fellBack bool, err error) {
+	switch {
+	case *prgFile != "":
+		mm, ok := mirroringModes[*mirroring]
+		if !ok {
+			return nil, false, fmt.Errorf("unknown -mirroring %q; use h, v or 4", *mirroring)
+		}
+		// Save RAM lives alongside the PRG image. WatchROM
+		// only knows how to reload complete ROM files.
+		*romFile = *prgFile
+		*watchROM = false
+		m, err = mappers.LoadRaw(*prgFile, *chrFile, uint16(*mapperID), mm)
+	case *fallbackMapper:
+		m, fellBack, err = mappers.LoadWithFallback(*romFile, *patchFile)
+	default:
+		m, err = mappers.LoadPatched(*romFile, *patchFile)
+	}
+	return m, fellBack, err
+}
+
+// runSelfCheck runs the -selfcheck and returns the process exit
+// status.
+func runSelfCheck(reg int, p core.PowerOn) int {
+	opts := selfcheck.Options{
+		Frames:    *selfCheck,
+		Every:     *selfCheckEvery,
+		RoundTrip: *selfCheckSaves,
+	}
+	if *inputScript != "" {
+		f, err := os.Open(*inputScript)
+		if err != nil {
+			log.Print(err)
+			return 2
+		}
+		opts.Script, err = core.ReadScript(f)
+		f.Close()
+		if err != nil {
+			log.Printf("Couldn't read %s: %v", *inputScript, err)
+			return 2
+		}
+	}
+
+	d, err := selfcheck.Run(func() (*core.Console, error) {
+		m, _, err := loadMapper()
+		if err != nil {
+			return nil, err
+		}
+		return newConsole(m, reg, p), nil
+	}, opts)
+	switch {
+	case err != nil:
+		log.Printf("Self check failed: %v", err)
+		return 2
+	case d != nil:
+		log.Print(d)
+		return 1
+	}
+
+	log.Printf("Consoles matched for %d frames", opts.Frames)
+	return 0
+}
+
+// loadForeignState loads the -import_state save state into c,
+// writing it to -convert_state if that's set.
+func loadForeignState(c *core.Console) error {
+	s, err := importstate.ReadFile(*importState)
+	if err != nil {
+		return err
+	}
+	if err := s.Apply(c); err != nil {
+		return err
+	}
+	if *convertState == "" {
+		return nil
+	}
+
+	f, err := os.Create(*convertState)
+	if err != nil {
+		return err
+	}
+	if err := c.SaveState(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadAchievements returns the achievements for romFile, with -ra_set
+// or -ra_user. It returns nil if neither was given.
+func loadAchievements(romFile string) (*cheevos.Set, error) {
+	if *raSet != "" {
+		f, err := os.Open(*raSet)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return cheevos.ReadSet(f)
+	}
+	if *raUser == "" {
+		return nil, nil
+	}
+
+	data, _, err := nesrom.ReadFile(romFile)
+	if err != nil {
+		return nil, err
+	}
+	cl := &cheevos.Client{User: *raUser}
+	if err := cl.Login(os.Getenv("GINTENDO_RA_PASSWORD")); err != nil {
+		return nil, err
+	}
+	id, err := cl.GameID(cheevos.Hash(data))
+	if err != nil {
+		return nil, err
+	}
+	return cl.Set(id)
+}
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "test-roms":
+			os.Exit(testROMs(os.Args[2:]))
+		case "diff-digests":
+			os.Exit(diffDigests(os.Args[2:]))
+		}
+	}
 	flag.Parse()
 
-	m, err := mappers.Load(*romFile)
+	if *checkROM || *repairROM != "" {
+		os.Exit(checkHeader(*romFile, *repairROM))
+	}
+	mappers.FDSBIOS = *fdsBIOS
+	mappers.LazyROMs = *lazyROM
+
+	if *cartDB != "" {
+		if err := nesrom.DefaultCartDB.Load(*cartDB); err != nil {
+			log.Fatalf("Couldn't load cartridge database: %v", err)
+		}
+	}
+	if *quirksFile != "" {
+		if err := nesrom.DefaultQuirks.Load(*quirksFile); err != nil {
+			log.Fatalf("Couldn't load quirks: %v", err)
+		}
+	}
+	if *symbolsFile != "" {
+		s, err := mos6502.LoadSymbols(*symbolsFile)
+		if err != nil {
+			log.Fatalf("Couldn't load symbols: %v", err)
+		}
+		symbols = s
+	}
+
+	m, fellBack, err := loadMapper()
 	if err != nil {
 		log.Fatalf("Couldn't Get() mapper: %v", err)
 	}
 
-	gintendo := console.New(m)
+	reg, err := core.ParseRegion(*region)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	go func(ctx context.Context) {
-		gintendo.Run(ctx)
-	}(ctx)
+	ram, ok := powerOnRAMs[*powerOnRAM]
+	if !ok {
+		log.Fatalf("Unknown -power_on_ram %q; use zeros, ones or random", *powerOnRAM)
+	}
+	if *cpuAlignment < 0 || *cpuAlignment > 15 {
+		log.Fatalf("-cpu_alignment must be 0-15")
+	}
+	power := core.PowerOn{RAM: ram, Seed: *seed, Alignment: *cpuAlignment}
 
-	if err := ebiten.RunGame(gintendo); err != nil {
+	if *selfCheck > 0 {
+		os.Exit(runSelfCheck(reg, power))
+	}
+
+	name := *frontendName
+	if name == "" {
+		name = defaultFrontend
+	}
+	newFrontend, ok := frontends[name]
+	if !ok {
+		log.Fatalf("Unknown -frontend %q", name)
+	}
+
+	gintendo := newConsole(m, reg, power)
+
+	cfg := frontendConfig{
+		saveFile: core.SaveFile(*romFile),
+		rom:      *romFile,
+		patch:    *patchFile,
+		fallback: *fallbackMapper,
+	}
+	if *watchROM {
+		cfg.watchROM = *romFile
+	}
+	if fellBack {
+		cfg.message = fmt.Sprintf("WARNING: mapper %d is not supported.\nUsing NROM; the game may not work.", m.ID())
+		log.Print(cfg.message)
+	}
+	if in := m.Capabilities().Input; in != nesrom.INPUT_STANDARD {
+		log.Printf("This game expects %s, so it's plugged in.", nesrom.InputName(in))
+	}
+
+	if err := core.ReadSaveFile(m, cfg.saveFile); err != nil {
+		log.Printf("Couldn't load save RAM: %v", err)
+	}
+
+	if *importState != "" {
+		if err := loadForeignState(gintendo); err != nil {
+			log.Fatalf("Couldn't import save state: %v", err)
+		}
+		if *convertState != "" {
+			os.Exit(0)
+		}
+	}
+
+	session, err := startNetplay(gintendo)
+	if err != nil {
 		log.Fatal(err)
+	}
+	if session != nil {
+		defer session.Close()
+		cfg.runner = session
+		if session.Player() == 1 {
+			// The battery RAM came from the host's save.
+			cfg.saveFile = ""
+		}
+	}
+
+	set, err := loadAchievements(*romFile)
+	if err != nil {
+		log.Printf("Couldn't load achievements: %v", err)
+	}
+	if set != nil {
+		log.Printf("Loaded %d achievements for %s", len(set.Achievements), set.Title)
+		next := cfg.runner
+		if next == nil {
+			next = frontend.Local(gintendo)
+		}
+		cfg.messages = make(chan string, 16)
+		cfg.runner = &cheevos.Runner{
+			Next:    next,
+			Console: gintendo,
+			Set:     set,
+			Unlocked: func(a *cheevos.Achievement) {
+				msg := fmt.Sprintf("Achievement unlocked: %s (%d points)", a.Title, a.Points)
+				log.Print(msg)
+				select {
+				case cfg.messages <- msg:
+				default:
+				}
+			},
+		}
+	}
 
+	// main exits without running deferred calls, so this is
+	// flushed by hand.
+	var digests *bufio.Writer
+	if *digestLog != "" {
+		f, err := os.Create(*digestLog)
+		if err != nil {
+			log.Fatal(err)
+		}
+		next := cfg.runner
+		if next == nil {
+			next = frontend.Local(gintendo)
+		}
+		digests = bufio.NewWriter(f)
+		cfg.runner = &digest.Runner{Next: next, Console: gintendo, W: digests}
 	}
 
-	cancel()
+	if *metricsAddr != "" {
+		cfg.metrics = metrics.New(gintendo)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", cfg.metrics)
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				log.Printf("Metrics server stopped: %v", err)
+			}
+		}()
+	}
+
+	if *controlAddr != "" {
+		srv := remote.New(gintendo)
+		srv.SetSaveFile(cfg.saveFile)
+		go func() {
+			log.Printf("Remote control API listening on %s", *controlAddr)
+			if err := http.ListenAndServe(*controlAddr, srv); err != nil {
+				log.Printf("Remote control API stopped: %v", err)
+			}
+		}()
+	}
+
+	if *avDiag {
+		cfg.sync = metrics.NewSync(gintendo)
+	}
+
+	if *pprofAddr != "" {
+		cfg.profile = metrics.NewProfile(gintendo)
+		go func() {
+			// The profiler's handlers are on the default mux.
+			log.Printf("Profiler listening on %s", *pprofAddr)
+			if err := http.ListenAndServe(*pprofAddr, nil); err != nil {
+				log.Printf("Profiler stopped: %v", err)
+			}
+		}()
+	}
+
+	err = newFrontend(cfg).Run(context.Background(), gintendo)
+	if cfg.sync != nil {
+		log.Printf("Frame pacing:\n%s", cfg.sync.Report())
+	}
+	if cfg.profile != nil {
+		log.Printf("Frame time: %s", cfg.profile.FrameTimes())
+	}
+	if digests != nil {
+		if err := digests.Flush(); err != nil {
+			log.Printf("Couldn't write digest log: %v", err)
+		}
+	}
+
+	// The window or the remote control API may have loaded a new
+	// cartridge, and saved this one's RAM as they did.
+	if cfg.saveFile != "" && gintendo.Mapper() == m {
+		if err := core.WriteSaveFile(gintendo.Mapper(), cfg.saveFile); err != nil {
+			log.Printf("Couldn't write save RAM: %v", err)
+		}
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
 	os.Exit(0)
 }
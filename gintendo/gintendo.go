@@ -0,0 +1,143 @@
+// Package gintendo is the quickest way to run NES games from another
+// Go program, such as a trainer, a machine learning environment or a
+// tool. It wraps a core.Console behind a handful of methods:
+//
+//	emu, err := gintendo.Open("game.nes")
+//	if err != nil {
+//		...
+//	}
+//	for {
+//		f := emu.RunFrame(gintendo.Input{P1: gintendo.BUTTON_RIGHT})
+//		// f.Image() is the picture
+//	}
+//
+// Programs that need more, like debuggers, can reach the console
+// itself with Console.
+package gintendo
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/mappers"
+)
+
+// Buttons are the buttons held on a controller, as BUTTON_XXX bits.
+type Buttons uint8
+
+// The buttons on a controller.
+const (
+	BUTTON_A      Buttons = core.BUTTON_A
+	BUTTON_B      Buttons = core.BUTTON_B
+	BUTTON_SELECT Buttons = core.BUTTON_SELECT
+	BUTTON_START  Buttons = core.BUTTON_START
+	BUTTON_UP     Buttons = core.BUTTON_UP
+	BUTTON_DOWN   Buttons = core.BUTTON_DOWN
+	BUTTON_LEFT   Buttons = core.BUTTON_LEFT
+	BUTTON_RIGHT  Buttons = core.BUTTON_RIGHT
+)
+
+// Input is what's held on both controllers for a frame.
+type Input struct {
+	P1, P2 Buttons
+}
+
+// Frame is a picture produced by RunFrame.
+type Frame struct {
+	Number        uint64 // Counting from 1 for the first frame run
+	Width, Height int
+	Pixels        []byte // RGBA, four bytes a pixel, a row at a time
+}
+
+// Image returns the frame as an image sharing its pixels.
+func (f Frame) Image() *image.RGBA {
+	return &image.RGBA{
+		Pix:    f.Pixels,
+		Stride: f.Width * 4,
+		Rect:   image.Rect(0, 0, f.Width, f.Height),
+	}
+}
+
+// AudioSink is given the audio for each frame RunFrame runs, as
+// signed 16 bit mono samples.
+type AudioSink func(samples []int16)
+
+// Emulator is a console with a game in it.
+type Emulator struct {
+	c      *core.Console
+	sink   AudioSink
+	frames uint64
+}
+
+// New returns an Emulator running rom, the contents of an iNES or NES
+// 2.0 file.
+func New(rom []byte) (*Emulator, error) {
+	m, err := mappers.LoadBytes(rom, "game.nes")
+	if err != nil {
+		return nil, err
+	}
+	return &Emulator{c: core.New(m)}, nil
+}
+
+// Open returns an Emulator running the game in path, which may be
+// any format the gintendo command accepts, like a Disk System image
+// or a zipped ROM.
+func Open(path string) (*Emulator, error) {
+	m, err := mappers.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Emulator{c: core.New(m)}, nil
+}
+
+// RunFrame emulates one frame with in held and returns its picture.
+// The pixels are reused by the next call; copy them to keep them.
+func (e *Emulator) RunFrame(in Input) Frame {
+	video, audio := e.c.RunFrame(core.Inputs{Buttons: [core.PLAYERS]uint8{uint8(in.P1), uint8(in.P2)}})
+	if e.sink != nil {
+		e.sink(audio)
+	}
+	e.frames++
+
+	w, h := e.c.Resolution()
+	return Frame{Number: e.frames, Width: w, Height: h, Pixels: video}
+}
+
+// SetAudioSink has RunFrame pass each frame's audio to sink, or stop
+// if it's nil. There's no APU yet, so the samples are always empty.
+func (e *Emulator) SetAudioSink(sink AudioSink) {
+	e.sink = sink
+}
+
+// SaveState returns a snapshot of the game that LoadState can go
+// back to.
+func (e *Emulator) SaveState() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := e.c.SaveState(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// LoadState goes back to a snapshot from SaveState.
+func (e *Emulator) LoadState(state []byte) error {
+	return e.c.LoadState(bytes.NewReader(state))
+}
+
+// Reset presses the console's reset button.
+func (e *Emulator) Reset() {
+	e.c.Reset()
+}
+
+// RAM returns the console's 2KB of RAM, where games keep most of
+// their state, such as scores and positions. It's the console's own
+// memory, so it changes with each frame.
+func (e *Emulator) RAM() []byte {
+	return e.c.Memory(core.MEMORY_SYSTEM_RAM)
+}
+
+// Console returns the console being run, for everything else.
+func (e *Emulator) Console() *core.Console {
+	return e.c
+}
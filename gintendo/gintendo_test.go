@@ -0,0 +1,64 @@
+package gintendo_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/bdwalton/gintendo/gintendo"
+)
+
+const testROM = "../testdata/ram_after_reset.nes"
+
+func TestEmulator(t *testing.T) {
+	rom, err := os.ReadFile(testROM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emu, err := gintendo.New(rom)
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+
+	var sunk int
+	emu.SetAudioSink(func([]int16) { sunk++ })
+	for i := 0; i < 5; i++ {
+		emu.RunFrame(gintendo.Input{P1: gintendo.BUTTON_START})
+	}
+	if sunk != 5 {
+		t.Errorf("Audio sink called %d times, want 5", sunk)
+	}
+
+	st, err := emu.SaveState()
+	if err != nil {
+		t.Fatalf("SaveState() = %v", err)
+	}
+	a := append([]byte(nil), emu.RunFrame(gintendo.Input{}).Pixels...)
+	ram := append([]byte(nil), emu.RAM()...)
+	if err := emu.LoadState(st); err != nil {
+		t.Fatalf("LoadState() = %v", err)
+	}
+	if b := emu.RunFrame(gintendo.Input{}).Pixels; !bytes.Equal(a, b) || !bytes.Equal(ram, emu.RAM()) {
+		t.Errorf("Frame after LoadState() differs from the original")
+	}
+
+	if _, err := gintendo.New([]byte("not a ROM")); err == nil {
+		t.Errorf("New() of junk succeeded")
+	}
+}
+
+func Example() {
+	emu, err := gintendo.Open(testROM)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var f gintendo.Frame
+	for i := 0; i < 60; i++ {
+		f = emu.RunFrame(gintendo.Input{P1: gintendo.BUTTON_RIGHT | gintendo.BUTTON_A})
+	}
+	fmt.Println(f.Number, f.Image().Bounds())
+	// Output: 60 (0,0)-(256,240)
+}
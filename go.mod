@@ -2,7 +2,10 @@ module github.com/bdwalton/gintendo
 
 go 1.20
 
-require github.com/hajimehoshi/ebiten/v2 v2.6.3
+require (
+	github.com/hajimehoshi/ebiten/v2 v2.6.3
+	golang.org/x/sys v0.12.0
+)
 
 require (
 	github.com/ebitengine/purego v0.5.0 // indirect
@@ -11,5 +14,4 @@ require (
 	golang.org/x/image v0.12.0 // indirect
 	golang.org/x/mobile v0.0.0-20230922142353-e2f452493d57 // indirect
 	golang.org/x/sync v0.3.0 // indirect
-	golang.org/x/sys v0.12.0 // indirect
 )
@@ -0,0 +1,179 @@
+package importstate
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+var (
+	fceuxMagic    = []byte("FCSX")
+	fceuxOldMagic = []byte("FCS\xff")
+)
+
+// FCEUX save state sections.
+const (
+	fceuxCPU    = 1
+	fceuxPPU    = 3
+	fceuxMapper = 0x10
+)
+
+// fceuxState is an FCEUX save state's chunks, by section and name.
+type fceuxState map[uint8]map[string][]byte
+
+// chunk returns the named chunk of section, if it's at least n bytes.
+func (st fceuxState) chunk(section uint8, name string, n int) ([]byte, bool) {
+	b, ok := st[section][name]
+	if !ok || len(b) < n {
+		return nil, false
+	}
+	return b, true
+}
+
+// ReadFCEUX reads an FCEUX save state from r. They start with a 16
+// byte header: "FCSX", the size of the state, FCEUX's version and the
+// compressed size of the state, or -1 if it isn't compressed. The
+// state follows in sections of named chunks.
+func ReadFCEUX(r io.Reader) (*Snapshot, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("couldn't read FCEUX header: %w", err)
+	}
+	if !bytes.Equal(hdr[:4], fceuxMagic) {
+		return nil, fmt.Errorf("not an FCEUX save state")
+	}
+	size := binary.LittleEndian.Uint32(hdr[4:])
+	compressed := binary.LittleEndian.Uint32(hdr[12:])
+
+	var data []byte
+	var err error
+	if compressed == 0xFFFFFFFF {
+		data, err = readN(r, size)
+	} else {
+		var zr io.ReadCloser
+		if zr, err = zlib.NewReader(io.LimitReader(r, int64(compressed))); err == nil {
+			data, err = readN(zr, size)
+			zr.Close()
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read FCEUX state: %w", err)
+	}
+
+	st, err := parseFCEUX(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return st.snapshot()
+}
+
+// readN reads exactly n bytes from r, without trusting n enough to
+// allocate it up front.
+func readN(r io.Reader, n uint32) ([]byte, error) {
+	var b bytes.Buffer
+	if _, err := io.CopyN(&b, r, int64(n)); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func parseFCEUX(data []byte) (fceuxState, error) {
+	st := fceuxState{}
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return nil, fmt.Errorf("FCEUX state is truncated")
+		}
+		section, size := data[0], binary.LittleEndian.Uint32(data[1:])
+		data = data[5:]
+		if uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("FCEUX section %d is truncated", section)
+		}
+		body := data[:size]
+		data = data[size:]
+
+		chunks := st[section]
+		if chunks == nil {
+			chunks = map[string][]byte{}
+			st[section] = chunks
+		}
+		for len(body) > 0 {
+			if len(body) < 8 {
+				return nil, fmt.Errorf("FCEUX section %d has a truncated chunk", section)
+			}
+			name := string(bytes.TrimRight(body[:4], "\x00"))
+			n := binary.LittleEndian.Uint32(body[4:])
+			body = body[8:]
+			if uint64(n) > uint64(len(body)) {
+				return nil, fmt.Errorf("FCEUX chunk %q is truncated", name)
+			}
+			chunks[name] = body[:n]
+			body = body[n:]
+		}
+	}
+
+	return st, nil
+}
+
+func (st fceuxState) snapshot() (*Snapshot, error) {
+	s := &Snapshot{Emulator: "FCEUX"}
+
+	cpu := map[string]*uint8{"A": &s.CPU.A, "X": &s.CPU.X, "Y": &s.CPU.Y, "P": &s.CPU.P, "S": &s.CPU.SP}
+	for name, reg := range cpu {
+		b, ok := st.chunk(fceuxCPU, name, 1)
+		if !ok {
+			return nil, fmt.Errorf("FCEUX state has no %s register", name)
+		}
+		*reg = b[0]
+	}
+	pc, ok := st.chunk(fceuxCPU, "PC", 2)
+	if !ok {
+		return nil, fmt.Errorf("FCEUX state has no PC")
+	}
+	s.CPU.PC = binary.LittleEndian.Uint16(pc)
+	s.RAM, _ = st.chunk(fceuxCPU, "RAM", 0x800)
+
+	s.VRAM, _ = st.chunk(fceuxPPU, "NTAR", 0x800)
+	s.Palette, _ = st.chunk(fceuxPPU, "PRAM", 0x20)
+	s.OAM, _ = st.chunk(fceuxPPU, "SPRA", 0x100)
+	if b, ok := st.chunk(fceuxPPU, "PPUR", 4); ok {
+		s.PPU.Ctrl, s.PPU.Mask, s.PPU.Status, s.PPU.OAMAddr = b[0], b[1], b[2], b[3]
+	}
+	if b, ok := st.chunk(fceuxPPU, "XOFF", 1); ok {
+		s.PPU.X = b[0]
+	}
+	if b, ok := st.chunk(fceuxPPU, "VTGL", 1); ok {
+		s.PPU.W = b[0]
+	}
+	if b, ok := st.chunk(fceuxPPU, "RADD", 2); ok {
+		s.PPU.V = binary.LittleEndian.Uint16(b)
+	}
+	if b, ok := st.chunk(fceuxPPU, "TADD", 2); ok {
+		s.PPU.T = binary.LittleEndian.Uint16(b)
+	}
+	if b, ok := st.chunk(fceuxPPU, "VBUF", 1); ok {
+		s.PPU.ReadBuffer = b[0]
+	}
+
+	s.PrgRAM, _ = st.chunk(fceuxMapper, "WRAM", 1)
+	if b, ok := st.chunk(fceuxMapper, "LATC", 1); ok {
+		s.Latch = &b[0]
+	}
+	if regs, ok := st.chunk(fceuxMapper, "REGS", 8); ok {
+		m := &MMC3{}
+		copy(m.Banks[:], regs)
+		for name, reg := range map[string]*uint8{"CMD": &m.BankSelect, "A000": &m.Mirroring, "A001": &m.RAMProtect, "IRQL": &m.IRQLatch} {
+			if b, ok := st.chunk(fceuxMapper, name, 1); ok {
+				*reg = b[0]
+			}
+		}
+		if b, ok := st.chunk(fceuxMapper, "IRQA", 1); ok {
+			m.IRQEnabled = b[0] != 0
+		}
+		s.MMC3 = m
+	}
+
+	return s, nil
+}
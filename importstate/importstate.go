@@ -0,0 +1,179 @@
+// Package importstate reads other emulators' save states, so players
+// moving to gintendo can carry on from where they were. FCEUX (.fcs)
+// and Mesen 2 (.mss) states are understood.
+//
+// Save states hold everything about the emulator that made them, and
+// only part of that has a counterpart in gintendo: the CPU and PPU
+// registers, the console's memory and the cartridge's RAM come
+// across, as do the registers of the common mappers where the state
+// records them. Timing (how far through the frame the console was)
+// and the APU don't, so the game carries on from the start of a frame
+// with silent channels until it next writes them.
+package importstate
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/mos6502"
+	"github.com/bdwalton/gintendo/ppu"
+)
+
+// Snapshot is what could be read from another emulator's save state.
+// Memory the state didn't hold is nil.
+type Snapshot struct {
+	Emulator string // "FCEUX" or "Mesen"
+	CPU      mos6502.Registers
+	PPU      ppu.Registers
+	RAM      []byte // the console's 2KB of RAM
+	VRAM     []byte // the nametables
+	OAM      []byte
+	Palette  []byte
+	PrgRAM   []byte
+
+	// The mapper's registers, when the state holds them in a way
+	// that's understood. Others are left as the game loaded.
+	Latch *uint8 // the register of latch mappers like UxROM and CNROM
+	MMC3  *MMC3
+}
+
+// MMC3 is the state of an MMC3's registers: the last values written to
+// $8000, $A000, $A001 and $C000, the bank registers and whether IRQs
+// are enabled.
+type MMC3 struct {
+	BankSelect, Mirroring, RAMProtect, IRQLatch uint8
+	Banks                                       [8]uint8
+	IRQEnabled                                  bool
+}
+
+// Mapper ids whose registers Apply can restore.
+var (
+	latchMappers = map[uint16]bool{2: true, 3: true, 11: true}
+	mmc3Mappers  = map[uint16]bool{4: true, 118: true, 119: true}
+)
+
+// Read reads a save state from r, working out which emulator made it.
+func Read(r io.Reader) (*Snapshot, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read save state: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, fceuxMagic):
+		return ReadFCEUX(br)
+	case bytes.HasPrefix(magic, fceuxOldMagic):
+		return nil, fmt.Errorf("save states from FCE Ultra and FCEUX before 2.0 aren't supported")
+	case bytes.HasPrefix(magic, mesenMagic):
+		return ReadMesen(br)
+	}
+	return nil, fmt.Errorf("not an FCEUX or Mesen save state")
+}
+
+// ReadFile reads the save state in the file at path.
+func ReadFile(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open save state: %w", err)
+	}
+	defer f.Close()
+
+	return Read(f)
+}
+
+// Apply puts s into c, which must have the state's game loaded and
+// shouldn't be running.
+func (s *Snapshot) Apply(c *core.Console) error {
+	if s.RAM == nil {
+		return fmt.Errorf("%s save state has no RAM", s.Emulator)
+	}
+
+	for _, m := range []struct {
+		region int
+		data   []byte
+	}{
+		{core.MEMORY_SYSTEM_RAM, s.RAM},
+		{core.MEMORY_VIDEO_RAM, s.VRAM},
+		{core.MEMORY_OAM, s.OAM},
+		{core.MEMORY_PALETTE, s.Palette},
+		{core.MEMORY_SAVE_RAM, s.PrgRAM},
+	} {
+		if m.data != nil {
+			copy(c.Memory(m.region), m.data)
+		}
+	}
+
+	for _, w := range s.mapperWrites(c) {
+		c.Poke(w.addr, w.value)
+	}
+
+	c.CPU().SetRegisters(s.CPU)
+	c.PPU().SetRegisters(s.PPU)
+
+	return nil
+}
+
+// Convert reads another emulator's save state from r and writes it to
+// w as a gintendo one, using c, which must have the state's game
+// loaded and shouldn't be running.
+func Convert(c *core.Console, r io.Reader, w io.Writer) error {
+	s, err := Read(r)
+	if err != nil {
+		return err
+	}
+	if err := s.Apply(c); err != nil {
+		return err
+	}
+	return c.SaveState(w)
+}
+
+// mapperWrite is a write of value to the mapper at addr.
+type mapperWrite struct {
+	addr  uint16
+	value uint8
+}
+
+// mapperWrites returns the writes that put the registers in s into
+// c's mapper.
+func (s *Snapshot) mapperWrites(c *core.Console) []mapperWrite {
+	id := c.Mapper().ID()
+	switch {
+	case s.Latch != nil && latchMappers[id]:
+		return []mapperWrite{latchWrite(c.Peek, *s.Latch)}
+	case s.MMC3 != nil && mmc3Mappers[id]:
+		m := s.MMC3
+		var w []mapperWrite
+		for i, b := range m.Banks {
+			w = append(w, mapperWrite{0x8000, m.BankSelect&0xC0 | uint8(i)}, mapperWrite{0x8001, b})
+		}
+		w = append(w,
+			mapperWrite{0x8000, m.BankSelect},
+			mapperWrite{0xA000, m.Mirroring},
+			mapperWrite{0xA001, m.RAMProtect},
+			mapperWrite{0xC000, m.IRQLatch},
+		)
+		if m.IRQEnabled {
+			return append(w, mapperWrite{0xE001, 0})
+		}
+		return append(w, mapperWrite{0xE000, 0})
+	}
+	return nil
+}
+
+// latchWrite returns a write that sets a latch mapper's register to v.
+// Boards with bus conflicts AND the value with the ROM byte at the
+// address, so the write goes to a ROM byte that has all of v's bits
+// set.
+func latchWrite(peek func(uint16) uint8, v uint8) mapperWrite {
+	for a := 0x8000; a <= 0xFFFF; a++ {
+		if peek(uint16(a))&v == v {
+			return mapperWrite{uint16(a), v}
+		}
+	}
+	return mapperWrite{0x8000, v}
+}
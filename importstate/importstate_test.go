@@ -0,0 +1,235 @@
+package importstate
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"testing"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/mappers"
+	"github.com/bdwalton/gintendo/mos6502"
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/ppu"
+)
+
+// testConsole returns a console running a cartridge with mapper id
+// and 8 16KB PRG banks. The ROM is filled with 0xFF, so bus conflicts
+// don't get in the way, apart from the first byte of each 8KB bank,
+// which holds the bank's number.
+func testConsole(t *testing.T, id uint8) *core.Console {
+	t.Helper()
+
+	prg := fill(8*16384, 0xFF)
+	for i := 0; i < len(prg)/8192; i++ {
+		prg[i*8192] = uint8(i)
+	}
+	hdr := nesrom.HeaderBytes(8, 0, nesrom.CartInfo{Mapper: uint16(id)})
+	m, err := mappers.LoadBytes(append(hdr, prg...), "test.nes")
+	if err != nil {
+		t.Fatalf("LoadBytes() = %v", err)
+	}
+	return core.New(m)
+}
+
+type chunk struct {
+	name string
+	data []byte
+}
+
+// fceux builds an FCEUX save state from sections of chunks.
+func fceux(compress bool, sections map[uint8][]chunk) []byte {
+	var data bytes.Buffer
+	for _, id := range []uint8{fceuxCPU, fceuxPPU, fceuxMapper} {
+		var body bytes.Buffer
+		for _, c := range sections[id] {
+			name := make([]byte, 4)
+			copy(name, c.name)
+			body.Write(name)
+			binary.Write(&body, binary.LittleEndian, uint32(len(c.data)))
+			body.Write(c.data)
+		}
+		data.WriteByte(id)
+		binary.Write(&data, binary.LittleEndian, uint32(body.Len()))
+		data.Write(body.Bytes())
+	}
+
+	payload, size := data.Bytes(), uint32(0xFFFFFFFF)
+	if compress {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(payload)
+		zw.Close()
+		payload, size = z.Bytes(), uint32(z.Len())
+	}
+
+	var f bytes.Buffer
+	f.Write(fceuxMagic)
+	binary.Write(&f, binary.LittleEndian, uint32(data.Len()))
+	binary.Write(&f, binary.LittleEndian, uint32(22020))
+	binary.Write(&f, binary.LittleEndian, size)
+	f.Write(payload)
+	return f.Bytes()
+}
+
+func fill(n int, v uint8) []byte {
+	return bytes.Repeat([]byte{v}, n)
+}
+
+var testSections = map[uint8][]chunk{
+	fceuxCPU: {
+		{"PC", []byte{0x34, 0x92}}, {"A", []byte{1}}, {"P", []byte{0x24}},
+		{"X", []byte{2}}, {"Y", []byte{3}}, {"S", []byte{0xF0}}, {"RAM", fill(0x800, 0x11)},
+	},
+	fceuxPPU: {
+		{"NTAR", fill(0x800, 0x22)}, {"PRAM", fill(0x20, 0x0F)}, {"SPRA", fill(0x100, 0x44)},
+		{"PPUR", []byte{0x80, 0x1E, 0x00, 0x10}}, {"XOFF", []byte{5}}, {"VTGL", []byte{1}},
+		{"RADD", []byte{0x00, 0x24}}, {"TADD", []byte{0x20, 0x04}}, {"VBUF", []byte{0x99}},
+	},
+	fceuxMapper: {{"LATC", []byte{3}}},
+}
+
+func TestFCEUX(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		s, err := Read(bytes.NewReader(fceux(compress, testSections)))
+		if err != nil {
+			t.Fatalf("compressed %t: Read() = %v", compress, err)
+		}
+
+		c := testConsole(t, 2)
+		if err := s.Apply(c); err != nil {
+			t.Fatalf("compressed %t: Apply() = %v", compress, err)
+		}
+
+		wantCPU := mos6502.Registers{A: 1, X: 2, Y: 3, P: 0x24, SP: 0xF0, PC: 0x9234}
+		if got := c.CPU().Registers(); got != wantCPU {
+			t.Errorf("compressed %t: CPU = %+v, want %+v", compress, got, wantCPU)
+		}
+		wantPPU := ppu.Registers{Ctrl: 0x80, Mask: 0x1E, OAMAddr: 0x10, V: 0x2400, T: 0x0420, X: 5, W: 1, ReadBuffer: 0x99}
+		if got := c.PPU().Registers(); got != wantPPU {
+			t.Errorf("compressed %t: PPU = %+v, want %+v", compress, got, wantPPU)
+		}
+		for _, m := range []struct {
+			region int
+			want   uint8
+		}{
+			{core.MEMORY_SYSTEM_RAM, 0x11},
+			{core.MEMORY_VIDEO_RAM, 0x22},
+			{core.MEMORY_OAM, 0x44},
+			{core.MEMORY_PALETTE, 0x0F},
+		} {
+			if got := c.Memory(m.region); !bytes.Equal(got, fill(len(got), m.want)) {
+				t.Errorf("compressed %t: memory region %d wasn't restored", compress, m.region)
+			}
+		}
+
+		// UxROM bank 3 is 8KB banks 6 and 7.
+		if got := c.Peek(0x8000); got != 6 {
+			t.Errorf("compressed %t: bank at $8000 = %d, want 6", compress, got)
+		}
+	}
+}
+
+func TestFCEUXMMC3(t *testing.T) {
+	sections := map[uint8][]chunk{
+		fceuxCPU: testSections[fceuxCPU],
+		fceuxMapper: {
+			{"REGS", []byte{0, 2, 4, 5, 6, 7, 9, 3}},
+			{"CMD", []byte{0x40}},
+			{"A000", []byte{1}},
+			{"A001", []byte{0x80}},
+			{"IRQL", []byte{20}},
+			{"IRQA", []byte{1}},
+		},
+	}
+	s, err := ReadFCEUX(bytes.NewReader(fceux(true, sections)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uint8{4, 118} {
+		c := testConsole(t, id)
+		if err := s.Apply(c); err != nil {
+			t.Fatalf("mapper %d: %v", id, err)
+		}
+
+		// PRG mode 1 puts R6 at $C000 and R7 at $A000.
+		for addr, want := range map[uint16]uint8{0xA000: 3, 0xC000: 9} {
+			if got := c.Peek(addr); got != want {
+				t.Errorf("mapper %d: bank at $%04X = %d, want %d", id, addr, got, want)
+			}
+		}
+	}
+}
+
+func TestMesen(t *testing.T) {
+	var raw bytes.Buffer
+	for _, v := range []struct {
+		key  string
+		data []byte
+	}{
+		{"cpu.state.pc", []byte{0x00, 0xC0}},
+		{"cpu.state.a", []byte{7}},
+		{"cpu.state.x", []byte{8}},
+		{"cpu.state.y", []byte{9}},
+		{"cpu.state.ps", []byte{0x24}},
+		{"cpu.state.sp", []byte{0xFD}},
+		{"memoryManager.internalRam", fill(0x800, 0x33)},
+		{"ppu.state.control", []byte{0x90}},
+		{"ppu.paletteRam", fill(0x20, 0x01)},
+		{"mapper.nametableRam", fill(0x1000, 0x55)},
+	} {
+		raw.WriteString(v.key)
+		raw.WriteByte(0)
+		binary.Write(&raw, binary.LittleEndian, uint32(len(v.data)))
+		raw.Write(v.data)
+	}
+
+	var f bytes.Buffer
+	f.Write(mesenMagic)
+	f.Write(make([]byte, 20)) // versions and a screenshot
+	zw := zlib.NewWriter(&f)
+	zw.Write(raw.Bytes())
+	zw.Close()
+
+	s, err := Read(&f)
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	c := testConsole(t, 0)
+	if err := s.Apply(c); err != nil {
+		t.Fatal(err)
+	}
+
+	want := mos6502.Registers{A: 7, X: 8, Y: 9, P: 0x24, SP: 0xFD, PC: 0xC000}
+	if got := c.CPU().Registers(); got != want {
+		t.Errorf("CPU = %+v, want %+v", got, want)
+	}
+	if got := c.PPU().Registers().Ctrl; got != 0x90 {
+		t.Errorf("PPUCTRL = 0x%02x, want 0x90", got)
+	}
+	if got := c.Peek(0x0123); got != 0x33 {
+		t.Errorf("RAM = 0x%02x, want 0x33", got)
+	}
+	if got := c.Memory(core.MEMORY_VIDEO_RAM)[0x7FF]; got != 0x55 {
+		t.Errorf("VRAM = 0x%02x, want 0x55", got)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	var st bytes.Buffer
+	if err := Convert(testConsole(t, 2), bytes.NewReader(fceux(true, testSections)), &st); err != nil {
+		t.Fatalf("Convert() = %v", err)
+	}
+
+	c := testConsole(t, 2)
+	if err := c.LoadState(&st); err != nil {
+		t.Fatalf("LoadState() of converted state = %v", err)
+	}
+	if got := c.CPU().PC(); got != 0x9234 {
+		t.Errorf("PC = 0x%04x, want 0x9234", got)
+	}
+
+	if _, err := Read(bytes.NewReader([]byte("nope, not a state"))); err == nil {
+		t.Errorf("Read() of junk = nil, want an error")
+	}
+}
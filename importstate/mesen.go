@@ -0,0 +1,135 @@
+package importstate
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var mesenMagic = []byte("MSS")
+
+// mesenState is a Mesen 2 save state's values, by key. Keys name the
+// emulator's fields, like "cpu.state.pc", and are kept in lower case.
+type mesenState map[string][]byte
+
+// find returns the value of at least n bytes whose key has a segment
+// part and ends with one of names, such as ("cpu", "pc") for
+// "cpu.state.pc".
+func (st mesenState) find(part string, n int, names ...string) ([]byte, bool) {
+	for k, v := range st {
+		if len(v) < n {
+			continue
+		}
+		segs := strings.Split(k, ".")
+		last := segs[len(segs)-1]
+		for _, name := range names {
+			if last != name {
+				continue
+			}
+			for _, s := range segs[:len(segs)-1] {
+				if s == part {
+					return v, true
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// ReadMesen reads a Mesen 2 save state from r. After a header with
+// Mesen's version, a screenshot and the ROM's name comes the
+// emulator's state, compressed with zlib, as a list of values: a nul
+// terminated key, the value's size and the value. The header has
+// changed between versions, so rather than decode it the state is
+// found as the compressed stream that holds the CPU's registers.
+//
+// Mesen keeps mapper registers in its own terms, which aren't read,
+// so games that switch banks may not carry on properly.
+func ReadMesen(r io.Reader) (*Snapshot, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read Mesen save state: %w", err)
+	}
+	if !bytes.HasPrefix(data, mesenMagic) {
+		return nil, fmt.Errorf("not a Mesen save state")
+	}
+
+	for i := len(mesenMagic); i+2 <= len(data); i++ {
+		// zlib streams start with 0x78 and a check byte.
+		if data[i] != 0x78 || (uint16(data[i])<<8|uint16(data[i+1]))%31 != 0 {
+			continue
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data[i:]))
+		if err != nil {
+			continue
+		}
+		raw, err := io.ReadAll(zr)
+		if err != nil {
+			continue
+		}
+		st, ok := parseMesen(raw)
+		if !ok {
+			continue
+		}
+		if _, ok := st.find("cpu", 2, "pc"); ok {
+			return st.snapshot()
+		}
+	}
+
+	return nil, fmt.Errorf("couldn't find the console's state in the Mesen save state; only Mesen 2 states are supported")
+}
+
+func parseMesen(b []byte) (mesenState, bool) {
+	st := mesenState{}
+	for len(b) > 0 {
+		end := bytes.IndexByte(b, 0)
+		if end <= 0 || end+5 > len(b) {
+			return nil, false
+		}
+		key := strings.ToLower(string(b[:end]))
+		size := binary.LittleEndian.Uint32(b[end+1:])
+		b = b[end+5:]
+		if uint64(size) > uint64(len(b)) {
+			return nil, false
+		}
+		st[key] = b[:size]
+		b = b[size:]
+	}
+	return st, true
+}
+
+func (st mesenState) snapshot() (*Snapshot, error) {
+	s := &Snapshot{Emulator: "Mesen"}
+
+	pc, _ := st.find("cpu", 2, "pc")
+	s.CPU.PC = binary.LittleEndian.Uint16(pc)
+	for names, reg := range map[string]*uint8{"a": &s.CPU.A, "x": &s.CPU.X, "y": &s.CPU.Y, "ps": &s.CPU.P, "sp": &s.CPU.SP} {
+		b, ok := st.find("cpu", 1, names)
+		if !ok {
+			return nil, fmt.Errorf("Mesen state has no %s register", strings.ToUpper(names))
+		}
+		*reg = b[0]
+	}
+
+	s.RAM, _ = st.find("memorymanager", 0x800, "internalram")
+	s.Palette, _ = st.find("ppu", 0x20, "paletteram")
+	s.OAM, _ = st.find("ppu", 0x100, "spriteram")
+	s.VRAM, _ = st.find("mapper", 0x800, "nametableram")
+	s.PrgRAM, _ = st.find("mapper", 1, "saveram", "workram")
+
+	for name, reg := range map[string]*uint8{"control": &s.PPU.Ctrl, "mask": &s.PPU.Mask, "status": &s.PPU.Status, "xscroll": &s.PPU.X, "writetoggle": &s.PPU.W} {
+		if b, ok := st.find("ppu", 1, name); ok {
+			*reg = b[0]
+		}
+	}
+	for name, reg := range map[string]*uint16{"videoramaddr": &s.PPU.V, "tmpvideoramaddr": &s.PPU.T} {
+		if b, ok := st.find("ppu", 2, name); ok {
+			*reg = binary.LittleEndian.Uint16(b)
+		}
+	}
+
+	return s, nil
+}
@@ -0,0 +1,79 @@
+package mappers
+
+import "github.com/bdwalton/gintendo/nesrom"
+
+// Base provides everything a Mapper needs except PRG and CHR
+// decoding, for mappers implemented in other modules. Embed a *Base
+// returned by NewBase and implement PrgRead, PrgWrite, ChrRead and
+// ChrWrite using its bank helpers. It also covers PRG RAM and CHR RAM
+// allocation, battery saves and IRQ wiring.
+type Base struct {
+	*baseMapper
+}
+
+// NewBase returns a Base for mapper id, sizing PRG and CHR RAM from
+// the ROM's header.
+func NewBase(id uint16, name string, r *nesrom.ROM) *Base {
+	return &Base{newBaseMapper(id, name, r)}
+}
+
+// ROM returns the ROM the mapper was built for.
+func (b *Base) ROM() *nesrom.ROM {
+	return b.rom
+}
+
+// Submapper returns the ROM's NES 2.0 submapper number, or 0.
+func (b *Base) Submapper() uint8 {
+	return b.sub
+}
+
+// SetBusConflicts enables ANDing of register writes with the PRG ROM
+// contents, as seen on boards without bus conflict prevention. See
+// BusConflict.
+func (b *Base) SetBusConflicts(on bool) {
+	b.busConflicts = on
+}
+
+// BusConflict returns the value a register actually receives when val
+// is written to an address where the PRG ROM holds romVal.
+func (b *Base) BusConflict(val, romVal uint8) uint8 {
+	return b.busConflict(val, romVal)
+}
+
+// NumPrgBanks returns the number of size byte PRG ROM banks.
+func (b *Base) NumPrgBanks(size uint32) uint32 {
+	return b.numPrgBanks(size)
+}
+
+// PrgBankRead returns the byte at offset within PRG ROM bank number
+// bank of size bytes. Bank numbers wrap around the ROM size.
+func (b *Base) PrgBankRead(bank, size uint32, offset uint16) uint8 {
+	return b.prgBankRead(bank, size, offset)
+}
+
+// ChrBankRead returns the byte at offset within CHR bank number bank
+// of size bytes, using CHR RAM when the ROM has no CHR ROM.
+func (b *Base) ChrBankRead(bank, size uint32, offset uint16) uint8 {
+	return b.chrBankRead(bank, size, offset)
+}
+
+// ChrBankWrite stores val in CHR RAM. It does nothing for CHR ROM.
+func (b *Base) ChrBankWrite(bank, size uint32, offset uint16, val uint8) {
+	b.chrBankWrite(bank, size, offset, val)
+}
+
+// PrgRAMRead returns the byte of PRG RAM visible at addr
+// ($6000-$7FFF).
+func (b *Base) PrgRAMRead(addr uint16) uint8 {
+	return b.prgRAMRead(addr)
+}
+
+// PrgRAMWrite stores val in the PRG RAM visible at addr.
+func (b *Base) PrgRAMWrite(addr uint16, val uint8) {
+	b.prgRAMWrite(addr, val)
+}
+
+// SetIRQ asserts or releases the mapper's IRQ output.
+func (b *Base) SetIRQ(asserted bool) {
+	b.setIRQ(asserted)
+}
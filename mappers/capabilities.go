@@ -0,0 +1,117 @@
+package mappers
+
+import (
+	"fmt"
+
+	"github.com/bdwalton/gintendo/nesrom"
+)
+
+// Capabilities describes the cartridge hardware behind a mapper and
+// its current bank selections, for debuggers and other tools.
+type Capabilities struct {
+	PrgROMSize uint32 // bytes
+	ChrROMSize uint32 // bytes
+	PrgRAMSize uint32 // bytes
+	ChrRAMSize uint32 // bytes
+
+	// The granularity of PRG and CHR banking in bytes, or 0 when
+	// the mapper can't switch banks of that kind.
+	PrgBankSize uint32
+	ChrBankSize uint32
+
+	IRQ            bool // The mapper can interrupt the CPU
+	ExpansionAudio bool // The cartridge has sound hardware of its own, which isn't emulated
+
+	Region uint8 // The console region the ROM is for, an nesrom region
+
+	VsSystem bool  // The ROM is for the Vs. System arcade hardware
+	VsPPU    uint8 // The Vs. System's PPU, an nesrom.VS_PPU_XXX value
+
+	Input     uint8  // The controllers the game expects, an nesrom.INPUT_XXX value
+	Overclock uint16 // Idle scanlines to add to vblank (see nesrom.Quirks)
+	CycleMode bool   // The game needs the CPU run a cycle at a time (see nesrom.Quirks)
+
+	Banks []Bank // Current bank selections, in address order
+}
+
+// PrgBanks returns the number of switchable PRG ROM banks.
+func (c Capabilities) PrgBanks() uint32 {
+	if c.PrgBankSize == 0 {
+		return 0
+	}
+	return c.PrgROMSize / c.PrgBankSize
+}
+
+// ChrBanks returns the number of switchable CHR banks, which come
+// from CHR RAM when there is no CHR ROM.
+func (c Capabilities) ChrBanks() uint32 {
+	if c.ChrBankSize == 0 {
+		return 0
+	}
+	if c.ChrROMSize == 0 {
+		return c.ChrRAMSize / c.ChrBankSize
+	}
+	return c.ChrROMSize / c.ChrBankSize
+}
+
+// Bank is a single bank mapped into the CPU (PRG) or PPU (CHR)
+// address space.
+type Bank struct {
+	Kind string // "PRG" or "CHR"
+	Addr uint16 // Where the bank starts
+	Size uint32 // bytes
+	Num  uint32 // The bank number, in units of Size
+}
+
+func (b Bank) String() string {
+	return fmt.Sprintf("%s bank %d @ $%04X", b.Kind, b.Num, b.Addr)
+}
+
+// Capabilities reports the ROM and RAM sizes. Mappers that switch
+// banks override it to fill in their bank details.
+func (bm *baseMapper) Capabilities() Capabilities {
+	return bm.caps(0, 0)
+}
+
+// caps returns the Capabilities common to all mappers, along with
+// the given bank sizes and current banks.
+func (bm *baseMapper) caps(prgBankSize, chrBankSize uint32, banks ...Bank) Capabilities {
+	return Capabilities{
+		PrgROMSize:  uint32(bm.rom.NumPrgBlocks()) * nesrom.PRG_BLOCK_SIZE,
+		ChrROMSize:  uint32(bm.rom.NumChrBlocks()) * nesrom.CHR_BLOCK_SIZE,
+		PrgRAMSize:  uint32(len(bm.prgRAM)),
+		ChrRAMSize:  uint32(len(bm.chrRAM)),
+		PrgBankSize: prgBankSize,
+		ChrBankSize: chrBankSize,
+		Region:      bm.rom.Region(),
+		VsSystem:    bm.rom.IsVsSystem(),
+		VsPPU:       bm.rom.VsPPUType(),
+		Input:       bm.rom.Quirks().Input,
+		Overclock:   bm.rom.Quirks().Overclock,
+		CycleMode:   bm.rom.Quirks().CycleMode,
+		Banks:       banks,
+	}
+}
+
+// prgBankInfo describes PRG bank number bank, of size bytes, mapped
+// at addr. The bank number is wrapped the same way prgBankRead wraps
+// it.
+func (bm *baseMapper) prgBankInfo(addr uint16, bank, size uint32) Bank {
+	if n := bm.numPrgBanks(size); n > 0 {
+		bank %= n
+	}
+	return Bank{Kind: "PRG", Addr: addr, Size: size, Num: bank}
+}
+
+// chrBankInfo describes CHR bank number bank, of size bytes, mapped
+// at addr.
+func (bm *baseMapper) chrBankInfo(addr uint16, bank, size uint32) Bank {
+	n := uint32(bm.rom.NumChrBlocks()) * nesrom.CHR_BLOCK_SIZE / size
+	if bm.chrRAM != nil {
+		n = uint32(len(bm.chrRAM)) / size
+	}
+	if n > 0 {
+		bank %= n
+	}
+	return Bank{Kind: "CHR", Addr: addr, Size: size, Num: bank}
+}
@@ -0,0 +1,33 @@
+package mappers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bdwalton/gintendo/nesrom"
+)
+
+func TestCapabilities(t *testing.T) {
+	m := newMapper2(testROM(t, nesrom.HeaderBytes(8, 0, nesrom.CartInfo{Mapper: 2})))
+	m.PrgWrite(0x8000, 0x03)
+
+	c := m.Capabilities()
+	if c.PrgROMSize != 0x20000 || c.ChrROMSize != 0 || c.ChrRAMSize != 0x2000 {
+		t.Errorf("Got sizes prg=%d, chr=%d, chr ram=%d", c.PrgROMSize, c.ChrROMSize, c.ChrRAMSize)
+	}
+	if got := c.PrgBanks(); got != 8 {
+		t.Errorf("PrgBanks() = %d, wanted 8", got)
+	}
+	if c.IRQ {
+		t.Errorf("UxROM shouldn't report IRQ support")
+	}
+
+	var got []string
+	for _, b := range c.Banks {
+		got = append(got, b.String())
+	}
+	want := []string{"PRG bank 3 @ $8000", "PRG bank 7 @ $C000", "CHR bank 0 @ $0000"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got banks %q, wanted %q", got, want)
+	}
+}
@@ -1,9 +1,10 @@
 package mappers
 
 import (
+	"io"
 	"math"
 
-	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
 )
 
 type dummyMapper struct {
@@ -15,10 +16,6 @@ func (dm *dummyMapper) ID() uint16 {
 	return 0
 }
 
-func (dm *dummyMapper) Init(r *nesrom.ROM) {
-	return
-}
-
 func (dm *dummyMapper) Name() string {
 	return "dummy mapper"
 }
@@ -47,5 +44,28 @@ func (dm *dummyMapper) HasSaveRAM() bool {
 	return true
 }
 
+func (dm *dummyMapper) ConnectIRQ(l IRQLine) {
+}
+
+func (dm *dummyMapper) PrgRAM() []uint8 {
+	return nil
+}
+
+func (dm *dummyMapper) LoadPrgRAM(r io.Reader) error {
+	return nil
+}
+
+func (dm *dummyMapper) SavePrgRAM(w io.Writer) error {
+	return nil
+}
+
 // For testing
 var Dummy *dummyMapper = &dummyMapper{memory: make([]uint8, math.MaxUint16+1)}
+
+func (dm *dummyMapper) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+func (dm *dummyMapper) State(s *state.Codec) {
+	s.Bytes(dm.memory)
+}
@@ -0,0 +1,52 @@
+package mappers_test
+
+import (
+	"fmt"
+
+	"github.com/bdwalton/gintendo/mappers"
+	"github.com/bdwalton/gintendo/nesrom"
+)
+
+// gxrom is a GxROM-like mapper implemented outside of the mappers
+// package, registered under an otherwise unused id. A
+// single register selects a 32KB PRG bank and an 8KB CHR bank.
+type gxrom struct {
+	*mappers.Base
+	reg uint8
+}
+
+func (m *gxrom) PrgRead(addr uint16) uint8 {
+	if addr < 0x8000 {
+		return 0
+	}
+	return m.PrgBankRead(uint32(m.reg>>4), 0x8000, addr)
+}
+
+func (m *gxrom) PrgWrite(addr uint16, val uint8) {
+	if addr >= 0x8000 {
+		m.reg = m.BusConflict(val, m.PrgRead(addr))
+	}
+}
+
+func (m *gxrom) ChrRead(addr uint16) uint8 {
+	return m.ChrBankRead(uint32(m.reg&0x03), 0x2000, addr)
+}
+
+func (m *gxrom) ChrWrite(addr uint16, val uint8) {
+	m.ChrBankWrite(uint32(m.reg&0x03), 0x2000, addr, val)
+}
+
+func ExampleRegister() {
+	err := mappers.Register(4095, func(r *nesrom.ROM) mappers.Mapper {
+		m := &gxrom{Base: mappers.NewBase(4095, "GxROM-like", r)}
+		m.SetBusConflicts(true)
+		return m
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(mappers.Registered(4095))
+	// Output: true
+}
@@ -0,0 +1,539 @@
+package mappers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bdwalton/gintendo/fds"
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
+)
+
+// FDSBIOS is the path of the 8KB Famicom Disk System BIOS
+// (disksys.rom) that Load uses for .fds disk images. It isn't
+// distributed with gintendo.
+var FDSBIOS = "disksys.rom"
+
+// The mapper number iNES reserves for the Famicom Disk System
+const FDS_MAPPER_ID = 20
+
+const (
+	FDS_BIOS_SIZE = 0x2000
+	FDS_RAM_SIZE  = 0x8000
+
+	// The drive moves a byte past the head roughly every 150 CPU
+	// cycles and takes a while to get back to the start of the
+	// disk after reaching the end.
+	FDS_BYTE_CYCLES   = 150
+	FDS_REWIND_CYCLES = 50000
+
+	// Swapping disks takes about a second, which is long enough
+	// for the BIOS to notice the disk was removed.
+	FDS_SWAP_CYCLES = 1789773
+
+	FDS_NO_DISK = -1
+)
+
+// $4025 control register bits
+const (
+	FDS_MOTOR_ON       = 1 << 0
+	FDS_RESET_TRANSFER = 1 << 1
+	FDS_READ_MODE      = 1 << 2
+	FDS_HORIZONTAL     = 1 << 3
+	FDS_CRC_CONTROL    = 1 << 4
+	FDS_DISK_READY     = 1 << 6
+	FDS_DISK_IRQ       = 1 << 7
+)
+
+// CPUClocked is implemented by mappers that need to see every CPU
+// cycle, such as those with cycle based IRQ timers.
+type CPUClocked interface {
+	ClockCPU()
+}
+
+// DiskSystem is implemented by mappers holding removable disks.
+type DiskSystem interface {
+	// SwitchSide ejects the current disk side and inserts the
+	// next one (wrapping around) a moment later. It returns the
+	// side that will be inserted.
+	SwitchSide() int
+}
+
+// fdsMapper implements the Famicom Disk System RAM adapter and the
+// disk drive behind it. The adapter provides 32KB of PRG RAM at
+// $6000-$DFFF, the BIOS at $E000-$FFFF, 8KB of CHR RAM, a CPU cycle
+// IRQ timer and registers at $4020-$4033 for talking to the drive.
+// The sound registers at $4040-$4097 are stored, and the wavetable
+// can be read back, but the sound channel isn't synthesized: with no
+// APU to mix it into, Disk System games run silently.
+// https://www.nesdev.org/wiki/Family_Computer_Disk_System
+type fdsMapper struct {
+	disk   *fds.Disk
+	bios   []uint8
+	prgRAM []uint8
+	chrRAM []uint8
+	irq    IRQLine
+
+	ioEnabled   uint8 // $4023
+	control     uint8 // $4025
+	mirroring   uint8
+	extOut      uint8 // $4026
+	writeData   uint8 // $4024
+	readData    uint8
+	transferred bool // a byte has been read or written
+
+	irqReload  uint16
+	irqCounter uint16
+	irqRepeat  bool
+	irqEnabled bool
+	timerIRQ   bool
+	diskIRQ    bool
+
+	side       int // FDS_NO_DISK when ejected
+	nextSide   int // inserted when swapDelay runs out
+	swapDelay  int
+	pos        int // position of the head within the side
+	delay      int // cycles until the next byte reaches the head
+	scanning   bool
+	endOfHead  bool
+	gapEnded   bool
+	crc        uint16
+	crcWasCtrl bool
+
+	wave     [64]uint8 // $4040-$407F
+	soundReg [0x18]uint8
+}
+
+// loadFDS returns a Disk System mapper for the disk image in data,
+// running the BIOS from FDSBIOS.
+func loadFDS(data []byte) (Mapper, error) {
+	d, err := fds.Read(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bios, err := os.ReadFile(FDSBIOS)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load FDS BIOS: %w", err)
+	}
+
+	return newFDS(d, bios)
+}
+
+func newFDS(d *fds.Disk, bios []uint8) (*fdsMapper, error) {
+	if len(bios) != FDS_BIOS_SIZE {
+		return nil, fmt.Errorf("FDS BIOS is %d bytes, wanted %d", len(bios), FDS_BIOS_SIZE)
+	}
+
+	return &fdsMapper{
+		disk:      d,
+		bios:      bios,
+		prgRAM:    make([]uint8, FDS_RAM_SIZE),
+		chrRAM:    make([]uint8, nesrom.CHR_BLOCK_SIZE),
+		mirroring: nesrom.MIRROR_HORIZONTAL,
+		side:      0,
+		nextSide:  FDS_NO_DISK,
+		endOfHead: true,
+	}, nil
+}
+
+func (m *fdsMapper) ID() uint16 {
+	return FDS_MAPPER_ID
+}
+
+func (m *fdsMapper) Name() string {
+	return "Famicom Disk System"
+}
+
+func (m *fdsMapper) String() string {
+	return m.Name()
+}
+
+func (m *fdsMapper) diskRegsEnabled() bool {
+	return m.ioEnabled&0x01 != 0
+}
+
+func (m *fdsMapper) soundRegsEnabled() bool {
+	return m.ioEnabled&0x02 != 0
+}
+
+func (m *fdsMapper) inserted() bool {
+	return m.side != FDS_NO_DISK
+}
+
+func (m *fdsMapper) updateIRQ() {
+	if m.irq != nil {
+		m.irq.SetMapperIRQ(m.timerIRQ || m.diskIRQ)
+	}
+}
+
+// DecodesExpansion implements ExpansionDecoder.
+func (m *fdsMapper) DecodesExpansion(addr uint16) bool {
+	switch {
+	case addr >= 0x4030 && addr <= 0x4033:
+		return m.diskRegsEnabled()
+	case addr >= 0x4040 && addr < 0x4080:
+		return m.soundRegsEnabled()
+	}
+	return false
+}
+
+func (m *fdsMapper) PrgRead(addr uint16) uint8 {
+	v := m.PrgPeek(addr)
+	switch {
+	case addr == 0x4030 && m.diskRegsEnabled():
+		m.transferred = false
+		m.timerIRQ = false
+		m.diskIRQ = false
+		m.updateIRQ()
+	case addr == 0x4031 && m.diskRegsEnabled():
+		m.transferred = false
+		m.diskIRQ = false
+		m.updateIRQ()
+	}
+	return v
+}
+
+// PrgPeek returns what PrgRead would, without acknowledging the IRQs
+// that reading $4030 and $4031 does.
+func (m *fdsMapper) PrgPeek(addr uint16) uint8 {
+	switch {
+	case addr == 0x4030 && m.diskRegsEnabled():
+		var v uint8
+		if m.timerIRQ {
+			v |= 0x01
+		}
+		if m.transferred {
+			v |= 0x02
+		}
+		if m.endOfHead {
+			v |= 0x40
+		}
+		return v | 0x80 // disk read/write enabled
+	case addr == 0x4031 && m.diskRegsEnabled():
+		return m.readData
+	case addr == 0x4032 && m.diskRegsEnabled():
+		var v uint8
+		if !m.inserted() {
+			v |= 0x05 // not inserted, write protected
+		}
+		if !m.inserted() || !m.scanning {
+			v |= 0x02
+		}
+		return v | 0x40
+	case addr == 0x4033 && m.diskRegsEnabled():
+		return 0x80 // battery good
+	case addr >= 0x4040 && addr < 0x4080 && m.soundRegsEnabled():
+		return m.wave[addr-0x4040] | 0x40
+	case addr < 0x6000:
+		return 0
+	case addr < 0xE000:
+		return m.prgRAM[addr-0x6000]
+	}
+
+	return m.bios[addr-0xE000]
+}
+
+func (m *fdsMapper) PrgWrite(addr uint16, val uint8) {
+	switch {
+	case addr >= 0x6000 && addr < 0xE000:
+		m.prgRAM[addr-0x6000] = val
+	case addr == 0x4023:
+		m.ioEnabled = val
+		if !m.diskRegsEnabled() {
+			m.irqEnabled = false
+			m.timerIRQ = false
+			m.diskIRQ = false
+			m.updateIRQ()
+		}
+	case addr >= 0x4040 && addr < 0x4098:
+		if !m.soundRegsEnabled() {
+			return
+		}
+		if addr < 0x4080 {
+			m.wave[addr-0x4040] = val & 0x3F
+		} else {
+			m.soundReg[addr-0x4080] = val
+		}
+	case !m.diskRegsEnabled():
+		return
+	case addr == 0x4020:
+		m.irqReload = m.irqReload&0xFF00 | uint16(val)
+	case addr == 0x4021:
+		m.irqReload = m.irqReload&0x00FF | uint16(val)<<8
+	case addr == 0x4022:
+		m.irqRepeat = val&0x01 != 0
+		m.irqEnabled = val&0x02 != 0
+		if m.irqEnabled {
+			m.irqCounter = m.irqReload
+		} else {
+			m.timerIRQ = false
+			m.updateIRQ()
+		}
+	case addr == 0x4024:
+		m.writeData = val
+		m.transferred = false
+		m.diskIRQ = false
+		m.updateIRQ()
+	case addr == 0x4025:
+		m.control = val
+		m.mirroring = nesrom.MIRROR_VERTICAL
+		if val&FDS_HORIZONTAL != 0 {
+			m.mirroring = nesrom.MIRROR_HORIZONTAL
+		}
+		m.diskIRQ = false
+		m.updateIRQ()
+	case addr == 0x4026:
+		m.extOut = val
+	}
+}
+
+// updateCRC folds v into the running CRC, as the drive's CRC circuit
+// does for each byte passing the head.
+func (m *fdsMapper) updateCRC(v uint8) {
+	for n := 0; n < 8; n++ {
+		carry := m.crc&1 == 1
+		m.crc >>= 1
+		if carry {
+			m.crc ^= 0x8408
+		}
+		if v&(1<<n) != 0 {
+			m.crc ^= 0x8000
+		}
+	}
+}
+
+// ClockCPU advances the IRQ timer and the disk drive by a single CPU
+// cycle.
+func (m *fdsMapper) ClockCPU() {
+	if m.irqEnabled {
+		if m.irqCounter == 0 {
+			m.timerIRQ = true
+			m.updateIRQ()
+			m.irqCounter = m.irqReload
+			if !m.irqRepeat {
+				m.irqEnabled = false
+			}
+		} else {
+			m.irqCounter--
+		}
+	}
+
+	if m.nextSide != FDS_NO_DISK {
+		if m.swapDelay--; m.swapDelay <= 0 {
+			m.side = m.nextSide
+			m.nextSide = FDS_NO_DISK
+		}
+	}
+
+	if !m.inserted() || m.control&FDS_MOTOR_ON == 0 {
+		m.endOfHead = true
+		m.scanning = false
+		return
+	}
+
+	if m.control&FDS_RESET_TRANSFER != 0 && !m.scanning {
+		return
+	}
+
+	if m.endOfHead {
+		m.delay = FDS_REWIND_CYCLES
+		m.endOfHead = false
+		m.pos = 0
+		m.gapEnded = false
+		return
+	}
+
+	if m.delay > 0 {
+		m.delay--
+		return
+	}
+
+	m.scanning = true
+	m.transferByte()
+
+	m.pos++
+	if m.pos >= len(m.disk.Side(m.side)) {
+		m.control &^= FDS_MOTOR_ON
+	} else {
+		m.delay = FDS_BYTE_CYCLES
+	}
+}
+
+// transferByte moves the byte under the head to or from the adapter.
+func (m *fdsMapper) transferByte() {
+	side := m.disk.Side(m.side)
+	ready := m.control&FDS_DISK_READY != 0
+	crcCtrl := m.control&FDS_CRC_CONTROL != 0
+	needIRQ := m.control&FDS_DISK_IRQ != 0
+	defer func() { m.crcWasCtrl = crcCtrl }()
+
+	if m.control&FDS_READ_MODE != 0 {
+		v := side[m.pos]
+		if !m.crcWasCtrl {
+			m.updateCRC(v)
+		}
+
+		switch {
+		case !ready:
+			m.gapEnded = false
+			m.crc = 0
+		case v != 0 && !m.gapEnded:
+			// The start mark ends the gap. It's latched
+			// like any other byte, but doesn't raise an
+			// IRQ.
+			m.gapEnded = true
+			needIRQ = false
+		}
+
+		if m.gapEnded {
+			m.transferred = true
+			m.readData = v
+			if needIRQ {
+				m.diskIRQ = true
+				m.updateIRQ()
+			}
+		}
+		return
+	}
+
+	var v uint8
+	if !crcCtrl {
+		m.transferred = true
+		v = m.writeData
+		if needIRQ {
+			m.diskIRQ = true
+			m.updateIRQ()
+		}
+	}
+	if !ready {
+		v = 0
+	}
+
+	if !crcCtrl {
+		m.updateCRC(v)
+	} else {
+		if !m.crcWasCtrl {
+			m.updateCRC(0)
+			m.updateCRC(0)
+		}
+		v = uint8(m.crc)
+		m.crc >>= 8
+	}
+
+	side[m.pos] = v
+	m.gapEnded = false
+}
+
+func (m *fdsMapper) SwitchSide() int {
+	next := 0
+	if m.inserted() {
+		next = (m.side + 1) % m.disk.NumSides()
+	} else if m.nextSide != FDS_NO_DISK {
+		next = (m.nextSide + 1) % m.disk.NumSides()
+	}
+
+	m.side = FDS_NO_DISK
+	m.nextSide = next
+	m.swapDelay = FDS_SWAP_CYCLES
+
+	return next
+}
+
+func (m *fdsMapper) ChrRead(addr uint16) uint8 {
+	return m.chrRAM[addr&0x1FFF]
+}
+
+func (m *fdsMapper) ChrWrite(addr uint16, val uint8) {
+	m.chrRAM[addr&0x1FFF] = val
+}
+
+func (m *fdsMapper) MirroringMode() uint8 {
+	return m.mirroring
+}
+
+// HasSaveRAM is always true as games write to the disk. The whole
+// disk image is the "save RAM".
+func (m *fdsMapper) HasSaveRAM() bool {
+	return true
+}
+
+func (m *fdsMapper) ConnectIRQ(l IRQLine) {
+	m.irq = l
+}
+
+func (m *fdsMapper) PrgRAM() []uint8 {
+	return m.prgRAM
+}
+
+// LoadPrgRAM replaces the disk with a previously saved copy,
+// restoring whatever the game wrote to it.
+func (m *fdsMapper) LoadPrgRAM(r io.Reader) error {
+	d, err := fds.Read(r)
+	if err != nil {
+		return fmt.Errorf("couldn't load saved disk: %w", err)
+	}
+	if d.NumSides() != m.disk.NumSides() {
+		return fmt.Errorf("saved disk has %d sides, wanted %d", d.NumSides(), m.disk.NumSides())
+	}
+
+	m.disk = d
+	return nil
+}
+
+// SavePrgRAM writes out the disk, including anything the game wrote
+// to it.
+func (m *fdsMapper) SavePrgRAM(w io.Writer) error {
+	return m.disk.Write(w)
+}
+
+func (m *fdsMapper) Capabilities() Capabilities {
+	return Capabilities{
+		PrgRAMSize:     FDS_RAM_SIZE,
+		ChrRAMSize:     nesrom.CHR_BLOCK_SIZE,
+		IRQ:            true,
+		ExpansionAudio: true,
+	}
+}
+
+// State saves or loads the RAM adapter and drive, including the
+// disk, which games write to as they go.
+func (m *fdsMapper) State(s *state.Codec) {
+	s.Tag("FDS ")
+	s.Bytes(m.prgRAM)
+	s.Bytes(m.chrRAM)
+	for i := 0; i < m.disk.NumSides(); i++ {
+		s.Bytes(m.disk.Side(i))
+	}
+
+	s.Uint8(&m.ioEnabled)
+	s.Uint8(&m.control)
+	s.Uint8(&m.mirroring)
+	s.Uint8(&m.extOut)
+	s.Uint8(&m.writeData)
+	s.Uint8(&m.readData)
+	s.Bool(&m.transferred)
+
+	s.Uint16(&m.irqReload)
+	s.Uint16(&m.irqCounter)
+	s.Bool(&m.irqRepeat)
+	s.Bool(&m.irqEnabled)
+	s.Bool(&m.timerIRQ)
+	s.Bool(&m.diskIRQ)
+
+	s.Int(&m.side)
+	s.Int(&m.nextSide)
+	s.Int(&m.swapDelay)
+	s.Int(&m.pos)
+	s.Int(&m.delay)
+	s.Bool(&m.scanning)
+	s.Bool(&m.endOfHead)
+	s.Bool(&m.gapEnded)
+	s.Uint16(&m.crc)
+	s.Bool(&m.crcWasCtrl)
+
+	s.Bytes(m.wave[:])
+	s.Bytes(m.soundReg[:])
+}
@@ -0,0 +1,116 @@
+package mappers
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bdwalton/gintendo/fds"
+)
+
+type testIRQLine struct {
+	asserted bool
+}
+
+func (l *testIRQLine) SetMapperIRQ(asserted bool) {
+	l.asserted = asserted
+}
+
+// testFDS returns a Disk System mapper with a blank BIOS and a disk
+// of the given number of sides, each holding only a disk info block
+// and a file amount block.
+func testFDS(t *testing.T, sides int) *fdsMapper {
+	t.Helper()
+
+	side := make([]uint8, fds.SIDE_SIZE)
+	side[0] = fds.BLOCK_DISK_INFO
+	copy(side[1:], "*NINTENDO-HVC*")
+	side[56] = fds.BLOCK_FILE_AMOUNT
+
+	d, err := fds.Read(bytes.NewReader(bytes.Repeat(side, sides)))
+	if err != nil {
+		t.Fatalf("couldn't build test disk: %v", err)
+	}
+
+	m, err := newFDS(d, make([]uint8, FDS_BIOS_SIZE))
+	if err != nil {
+		t.Fatalf("couldn't build FDS mapper: %v", err)
+	}
+
+	return m
+}
+
+func TestFDSTimerIRQ(t *testing.T) {
+	m := testFDS(t, 1)
+	l := &testIRQLine{}
+	m.ConnectIRQ(l)
+
+	m.PrgWrite(0x4023, 0x01)
+	m.PrgWrite(0x4020, 0x03)
+	m.PrgWrite(0x4021, 0x00)
+	m.PrgWrite(0x4022, 0x02) // enabled, no repeat
+
+	for i := 0; i < 3; i++ {
+		m.ClockCPU()
+	}
+	if l.asserted {
+		t.Errorf("IRQ asserted after 3 cycles")
+	}
+	m.ClockCPU()
+	if !l.asserted {
+		t.Errorf("IRQ not asserted after 4 cycles")
+	}
+
+	if got := m.PrgPeek(0x4030); got&0x01 == 0 {
+		t.Errorf("Peeking $4030 = 0x%02x, wanted the timer IRQ bit set", got)
+	}
+	if !l.asserted {
+		t.Errorf("Peeking $4030 acknowledged the IRQ")
+	}
+	if got := m.PrgRead(0x4030); got&0x01 == 0 {
+		t.Errorf("$4030 = 0x%02x, wanted the timer IRQ bit set", got)
+	}
+	if l.asserted {
+		t.Errorf("Reading $4030 didn't acknowledge the IRQ")
+	}
+}
+
+func TestFDSReadDisk(t *testing.T) {
+	m := testFDS(t, 1)
+	m.PrgWrite(0x4023, 0x01)
+	m.PrgWrite(0x4025, FDS_MOTOR_ON|FDS_READ_MODE|FDS_DISK_READY)
+
+	var got []uint8
+	for i := 0; i < 2*FDS_REWIND_CYCLES+fds.LEAD_IN_GAP*(FDS_BYTE_CYCLES+1) && len(got) < 16; i++ {
+		m.ClockCPU()
+		if m.PrgRead(0x4030)&0x02 != 0 {
+			got = append(got, m.PrgRead(0x4031))
+		}
+	}
+
+	if want := append([]uint8{fds.BLOCK_START, fds.BLOCK_DISK_INFO}, "*NINTENDO-HVC*"...); !bytes.Equal(got, want) {
+		t.Errorf("Read %q from the disk, wanted %q", got, want)
+	}
+}
+
+func TestFDSSwitchSide(t *testing.T) {
+	m := testFDS(t, 2)
+	m.PrgWrite(0x4023, 0x01)
+
+	if got := m.SwitchSide(); got != 1 {
+		t.Errorf("SwitchSide() = %d, wanted 1", got)
+	}
+	if got := m.PrgRead(0x4032); got&0x01 == 0 {
+		t.Errorf("$4032 = 0x%02x, wanted the disk to be ejected", got)
+	}
+
+	for i := 0; i < FDS_SWAP_CYCLES; i++ {
+		m.ClockCPU()
+	}
+	if m.side != 1 || m.PrgRead(0x4032)&0x01 != 0 {
+		t.Errorf("side %d inserted, wanted 1", m.side)
+	}
+
+	if got := m.SwitchSide(); got != 0 {
+		t.Errorf("SwitchSide() = %d, wanted 0", got)
+	}
+}
@@ -0,0 +1,55 @@
+package mappers
+
+import (
+	"testing"
+
+	"github.com/bdwalton/gintendo/nesrom"
+)
+
+// FuzzFromROM builds a ROM around a fuzzed header, loads whichever
+// mapper it asks for and then drives the mapper with the rest of the
+// input, a series of (address, value) writes each followed by reads.
+func FuzzFromROM(f *testing.F) {
+	f.Add(nesrom.HeaderBytes(2, 1, nesrom.CartInfo{}), []byte{0x80, 0x00, 0x01, 0xFF, 0xFF, 0x07})
+	for _, id := range []uint16{1, 2, 3, 4, 7, 11, 14} {
+		f.Add(nesrom.HeaderBytes(2, 1, nesrom.CartInfo{Mapper: id}), []byte{0x80, 0x00, 0x06, 0x80, 0x01, 0x03, 0xA0, 0x00, 0x01})
+	}
+
+	f.Fuzz(func(t *testing.T, hdr, ops []byte) {
+		if len(hdr) != 16 {
+			return
+		}
+		r, err := nesrom.NewFromBytes(padROM(hdr))
+		if err != nil {
+			return
+		}
+		m, err := FromROM(r)
+		if err != nil {
+			return
+		}
+
+		for ; len(ops) >= 3; ops = ops[3:] {
+			addr := uint16(ops[0])<<8 | uint16(ops[1])
+			m.PrgWrite(addr, ops[2])
+			m.PrgRead(addr)
+			m.PrgRead(0xFFFC)
+			m.ChrRead(addr & 0x1FFF)
+			m.MirroringMode()
+		}
+		m.Capabilities()
+	})
+}
+
+// padROM returns a ROM file with the header hdr and zeroed sections
+// of the sizes it gives. The header's sizes are cut down first so
+// that the fuzzer isn't allocating megabytes per run.
+func padROM(hdr []byte) []byte {
+	h := append([]byte(nil), hdr...)
+	h[4] %= 4
+	h[5] %= 4
+	size := int(h[4])*nesrom.PRG_BLOCK_SIZE + int(h[5])*nesrom.CHR_BLOCK_SIZE
+	if h[6]&nesrom.TRAINER != 0 {
+		size += nesrom.TRAINER_SIZE
+	}
+	return append(h, make([]byte, size)...)
+}
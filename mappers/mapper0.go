@@ -1,49 +1,70 @@
 package mappers
 
+import (
+	"fmt"
+
+	"github.com/bdwalton/gintendo/nesrom"
+)
+
 func init() {
-	m := newMapper0()
-	RegisterMapper(m.ID(), m)
+	RegisterMapper(0, newMapper0)
 }
 
+// mapper0 implements NROM, which has no banking at all. PRG ROM is
+// 16 or 32KB, with 16KB ROMs mirrored into $C000-$FFFF, and CHR is a
+// fixed 8KB of ROM or RAM. Family BASIC adds battery backed PRG RAM
+// at $6000-$7FFF, so we expose whatever PRG RAM the header asks for
+// there. https://www.nesdev.org/wiki/NROM
 type mapper0 struct {
 	*baseMapper
-	prgRAM []uint8
 }
 
-func newMapper0() *mapper0 {
+func newMapper0(r *nesrom.ROM) Mapper {
 	return &mapper0{
-		baseMapper: newBaseMapper(0, "NROM"),
-		prgRAM:     make([]uint8, 0x7FFF-0x6000),
+		baseMapper: newBaseMapper(0, "NROM", r),
 	}
 }
 
-func (m *mapper0) PrgWrite(addr uint16, val uint8) {
-	panic("mapper0: Writing PRG Data.")
-}
-
 func (m *mapper0) PrgRead(addr uint16) uint8 {
-	// If we have two blocks of PRG, we can read higher
-	// within the block, up to 32k. Otherwise, we map the
-	// second 16k address range into the first so there is
-	// mirroring.
-	a := addr - 0x8000
-	switch m.rom.NumPrgBlocks() {
-	case 1:
-		return m.rom.PrgRead(a % 0x4000)
-	case 2:
-		return m.rom.PrgRead(a)
-	default:
-		panic("mapper0: Reading above 32k of PRG Data.")
+	switch {
+	case addr < 0x6000:
+		return 0
+	case addr < 0x8000:
+		return m.prgRAMRead(addr)
 	}
 
-	// Never reached
-	panic("mapper0: PrgRead() doing bad things.")
+	return m.prgBankRead(uint32(addr-0x8000)>>14, 0x4000, addr)
+}
+
+// PrgWrite stores val in PRG RAM. Writes to ROM are ignored as there
+// are no registers to receive them.
+func (m *mapper0) PrgWrite(addr uint16, val uint8) {
+	if addr >= 0x6000 && addr < 0x8000 {
+		m.prgRAMWrite(addr, val)
+	}
 }
 
 func (m *mapper0) ChrRead(addr uint16) uint8 {
-	return m.rom.ChrRead(addr)
+	return m.chrBankRead(0, 0x2000, addr)
 }
 
 func (m *mapper0) ChrWrite(addr uint16, val uint8) {
-	panic("mapper0: These ROMs don't support ChrWrite().")
+	m.chrBankWrite(0, 0x2000, addr, val)
+}
+
+// newFallbackMapper returns NROM mapping for a ROM whose real mapper
+// isn't implemented. It keeps the ROM's mapper id so that it's clear
+// what the cartridge actually needs.
+func newFallbackMapper(r *nesrom.ROM) Mapper {
+	id := r.MapperNum()
+	return &mapper0{
+		baseMapper: newBaseMapper(id, fmt.Sprintf("NROM (fallback for mapper %d)", id), r),
+	}
+}
+
+func (m *mapper0) Capabilities() Capabilities {
+	return m.caps(0, 0,
+		m.prgBankInfo(0x8000, 0, 0x4000),
+		m.prgBankInfo(0xC000, 1, 0x4000),
+		m.chrBankInfo(0x0000, 0, 0x2000))
 }
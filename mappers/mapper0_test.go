@@ -0,0 +1,86 @@
+package mappers
+
+import (
+	"testing"
+
+	"github.com/bdwalton/gintendo/nesrom"
+)
+
+func TestMapper0PrgRead(t *testing.T) {
+	cases := []struct {
+		prgBlocks uint8
+		addr      uint16
+		want      uint8
+	}{
+		{1, 0x8000, 0x00},
+		{1, 0xA000, 0x01},
+		{1, 0xC000, 0x00}, // mirrored
+		{1, 0xFFFF, 0x01},
+		{2, 0x8000, 0x00},
+		{2, 0xA000, 0x01},
+		{2, 0xC000, 0x02},
+		{2, 0xFFFF, 0x03},
+	}
+
+	for i, tc := range cases {
+		m := newMapper0(testROM(t, nesrom.HeaderBytes(tc.prgBlocks, 1, nesrom.CartInfo{})))
+		if got := m.PrgRead(tc.addr); got != tc.want {
+			t.Errorf("%d: PrgRead(0x%04x) = 0x%02x, wanted 0x%02x", i, tc.addr, got, tc.want)
+		}
+	}
+}
+
+func TestMapper0PrgRAM(t *testing.T) {
+	m := newMapper0(testROM(t, nesrom.HeaderBytes(2, 1, nesrom.CartInfo{Battery: true})))
+
+	m.PrgWrite(0x6000, 0x12)
+	m.PrgWrite(0x7FFF, 0x34)
+	m.PrgWrite(0x8000, 0x56) // ROM, ignored
+
+	if got := m.PrgRead(0x6000); got != 0x12 {
+		t.Errorf("PrgRead(0x6000) = 0x%02x, wanted 0x12", got)
+	}
+	if got := m.PrgRead(0x7FFF); got != 0x34 {
+		t.Errorf("PrgRead(0x7FFF) = 0x%02x, wanted 0x34", got)
+	}
+	if got := m.PrgRead(0x8000); got != 0x00 {
+		t.Errorf("PrgRead(0x8000) = 0x%02x, wanted 0x00", got)
+	}
+	if !m.HasSaveRAM() {
+		t.Errorf("HasSaveRAM() = false, wanted true")
+	}
+}
+
+func TestMapper0Chr(t *testing.T) {
+	cases := []struct {
+		chrBlocks uint8
+		want      uint8 // value read back at 0x1400 after writing 0xAA
+	}{
+		{1, 0x05}, // CHR ROM, write ignored
+		{0, 0xAA}, // CHR RAM
+	}
+
+	for i, tc := range cases {
+		m := newMapper0(testROM(t, nesrom.HeaderBytes(1, tc.chrBlocks, nesrom.CartInfo{})))
+		m.ChrWrite(0x1400, 0xAA)
+		if got := m.ChrRead(0x1400); got != tc.want {
+			t.Errorf("%d: ChrRead(0x1400) = 0x%02x, wanted 0x%02x", i, got, tc.want)
+		}
+	}
+}
+
+func TestMapper0Trainer(t *testing.T) {
+	hdr := nesrom.HeaderBytes(1, 1, nesrom.CartInfo{})
+	hdr[6] |= nesrom.TRAINER
+	m := newMapper0(testROM(t, hdr))
+
+	for _, addr := range []uint16{0x7000, 0x7001, 0x71FF} {
+		if got, want := m.PrgRead(addr), uint8(0xFF-(addr-0x7000)); got != want {
+			t.Errorf("PrgRead(0x%04x) = 0x%02x, wanted 0x%02x", addr, got, want)
+		}
+	}
+
+	if got := m.PrgRead(0x7200); got != 0 {
+		t.Errorf("PrgRead(0x7200) = 0x%02x, wanted 0x00", got)
+	}
+}
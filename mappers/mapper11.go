@@ -0,0 +1,60 @@
+package mappers
+
+import (
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
+)
+
+func init() {
+	RegisterMapper(11, newMapper11)
+}
+
+// mapper11 implements the Color Dreams boards. A single register at
+// $8000-$FFFF selects a 32KB PRG bank (bits 0-1) and an 8KB CHR bank
+// (bits 4-7). The boards have no bus conflict prevention.
+// https://www.nesdev.org/wiki/Color_Dreams
+type mapper11 struct {
+	*baseMapper
+	reg uint8
+}
+
+func newMapper11(r *nesrom.ROM) Mapper {
+	m := &mapper11{
+		baseMapper: newBaseMapper(11, "Color Dreams", r),
+	}
+	m.busConflicts = true
+	return m
+}
+
+func (m *mapper11) PrgRead(addr uint16) uint8 {
+	if addr < 0x8000 {
+		return 0
+	}
+
+	return m.prgBankRead(uint32(m.reg&0x03), 0x8000, addr)
+}
+
+func (m *mapper11) PrgWrite(addr uint16, val uint8) {
+	if addr >= 0x8000 {
+		m.reg = m.busConflict(val, m.PrgRead(addr))
+	}
+}
+
+func (m *mapper11) ChrRead(addr uint16) uint8 {
+	return m.chrBankRead(uint32(m.reg>>4), 0x2000, addr)
+}
+
+func (m *mapper11) ChrWrite(addr uint16, val uint8) {
+	m.chrBankWrite(uint32(m.reg>>4), 0x2000, addr, val)
+}
+
+func (m *mapper11) Capabilities() Capabilities {
+	return m.caps(0x8000, 0x2000,
+		m.prgBankInfo(0x8000, uint32(m.reg&0x03), 0x8000),
+		m.chrBankInfo(0x0000, uint32(m.reg>>4), 0x2000))
+}
+
+func (m *mapper11) State(s *state.Codec) {
+	m.baseMapper.State(s)
+	s.Uint8(&m.reg)
+}
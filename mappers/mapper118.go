@@ -0,0 +1,44 @@
+package mappers
+
+import "github.com/bdwalton/gintendo/nesrom"
+
+func init() {
+	RegisterMapper(118, newMapper118)
+}
+
+// mapper118 implements the TxSROM (TKSROM and TLSROM) MMC3 boards. On
+// these, CHR A17 isn't connected to the CHR ROM. Instead, it drives
+// CIRAM A10, so the high bit of the CHR bank mapped into each 1KB slot
+// of $0000-$0FFF selects the physical nametable used for the
+// corresponding logical nametable. The MMC3 mirroring register is
+// ignored.
+// https://www.nesdev.org/wiki/INES_Mapper_118
+type mapper118 struct {
+	*mmc3
+}
+
+func newMapper118(r *nesrom.ROM) Mapper {
+	return &mapper118{newMMC3(118, "TxSROM", r)}
+}
+
+func (m *mapper118) PrgWrite(addr uint16, val uint8) {
+	if addr >= 0xA000 && addr < 0xC000 && addr&0x01 == 0 {
+		return // mirroring is wired to the CHR banks instead
+	}
+
+	m.mmc3.PrgWrite(addr, val)
+}
+
+func (m *mapper118) ChrRead(addr uint16) uint8 {
+	return m.chrBankRead(uint32(m.chrBank(addr)&0x7F), 0x0400, addr)
+}
+
+func (m *mapper118) MirroringMode() uint8 {
+	return nesrom.MIRROR_MAPPER_CONTROLLED
+}
+
+// NametablePage returns the physical nametable (0 or 1) that backs
+// logical nametable nt (0-3; $2000, $2400, $2800, $2C00).
+func (m *mapper118) NametablePage(nt uint8) uint8 {
+	return m.chrBank(uint16(nt&0x03)<<10) >> 7
+}
@@ -0,0 +1,48 @@
+package mappers
+
+import (
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
+)
+
+func init() {
+	RegisterMapper(119, newMapper119)
+}
+
+// mapper119 implements the TQROM MMC3 board, which carries both CHR
+// ROM and 8KB of CHR RAM. Bit 6 of each CHR bank number selects
+// between them, so a game can mix ROM and RAM tiles freely.
+// https://www.nesdev.org/wiki/INES_Mapper_119
+type mapper119 struct {
+	*mmc3
+	chrRAM []uint8
+}
+
+const TQROM_CHR_RAM = 1 << 6
+
+func newMapper119(r *nesrom.ROM) Mapper {
+	return &mapper119{
+		mmc3:   newMMC3(119, "TQROM", r),
+		chrRAM: make([]uint8, 0x2000),
+	}
+}
+
+func (m *mapper119) ChrRead(addr uint16) uint8 {
+	b := m.chrBank(addr)
+	if b&TQROM_CHR_RAM > 0 {
+		return m.chrRAM[uint16(b&0x07)<<10|addr&0x03FF]
+	}
+
+	return m.chrBankRead(uint32(b&0x3F), 0x0400, addr)
+}
+
+func (m *mapper119) ChrWrite(addr uint16, val uint8) {
+	if b := m.chrBank(addr); b&TQROM_CHR_RAM > 0 {
+		m.chrRAM[uint16(b&0x07)<<10|addr&0x03FF] = val
+	}
+}
+
+func (m *mapper119) State(s *state.Codec) {
+	m.mmc3.State(s)
+	s.Bytes(m.chrRAM)
+}
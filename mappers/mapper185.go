@@ -0,0 +1,85 @@
+package mappers
+
+import (
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
+)
+
+func init() {
+	RegisterMapper(185, newMapper185)
+}
+
+// mapper185 implements CNROM boards with a copy protection diode
+// setup. There is only a single 8KB CHR bank, but the value written
+// to the latch controls whether the CHR ROM is enabled at all. Games
+// check that pattern reads return garbage with the "wrong" value
+// latched and refuse to run if they don't.
+// https://www.nesdev.org/wiki/INES_Mapper_185
+type mapper185 struct {
+	*baseMapper
+	latch uint8
+}
+
+func newMapper185(r *nesrom.ROM) Mapper {
+	m := &mapper185{
+		baseMapper: newBaseMapper(185, "CNROM (protected)", r),
+	}
+	m.busConflicts = true
+	return m
+}
+
+// chrEnabled reports whether the currently latched value enables the
+// CHR ROM. Submappers 4-7 name the exact value of the low two bits
+// that enables it. Without submapper information, we use the widely
+// used heuristic that any value with either of the low two bits set,
+// except 0x13, enables it.
+func (m *mapper185) chrEnabled() bool {
+	if m.sub >= 4 && m.sub <= 7 {
+		return m.latch&0x03 == m.sub-4
+	}
+
+	return m.latch&0x03 != 0 && m.latch != 0x13
+}
+
+func (m *mapper185) PrgRead(addr uint16) uint8 {
+	if addr < 0x8000 {
+		return 0
+	}
+
+	// 16KB ROMs are mirrored into $C000-$FFFF by the bank wrap
+	return m.prgBankRead(uint32(addr-0x8000)>>14, 0x4000, addr)
+}
+
+func (m *mapper185) PrgWrite(addr uint16, val uint8) {
+	if addr >= 0x8000 {
+		m.latch = m.busConflict(val, m.PrgRead(addr))
+	}
+}
+
+func (m *mapper185) ChrRead(addr uint16) uint8 {
+	if !m.chrEnabled() {
+		// The data lines float high when the CHR ROM is
+		// disabled.
+		return 0xFF
+	}
+
+	return m.chrBankRead(0, 0x2000, addr)
+}
+
+func (m *mapper185) ChrWrite(addr uint16, val uint8) {
+	if m.chrEnabled() {
+		m.chrBankWrite(0, 0x2000, addr, val)
+	}
+}
+
+func (m *mapper185) Capabilities() Capabilities {
+	return m.caps(0, 0,
+		m.prgBankInfo(0x8000, 0, 0x4000),
+		m.prgBankInfo(0xC000, 1, 0x4000),
+		m.chrBankInfo(0x0000, 0, 0x2000))
+}
+
+func (m *mapper185) State(s *state.Codec) {
+	m.baseMapper.State(s)
+	s.Uint8(&m.latch)
+}
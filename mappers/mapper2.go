@@ -0,0 +1,74 @@
+package mappers
+
+import (
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
+)
+
+func init() {
+	RegisterMapper(2, newMapper2)
+}
+
+// mapper2 implements the UxROM boards. $8000-$BFFF is a switchable
+// 16KB PRG bank and $C000-$FFFF is fixed to the last bank. CHR is
+// almost always 8KB of RAM.
+// https://www.nesdev.org/wiki/UxROM
+type mapper2 struct {
+	*baseMapper
+	bank uint8
+}
+
+func newMapper2(r *nesrom.ROM) Mapper {
+	m := &mapper2{
+		baseMapper: newBaseMapper(2, "UxROM", r),
+	}
+	// Submapper 2 marks boards with bus conflicts and 1 marks
+	// those without. Unspecified ROMs are assumed not to rely on
+	// them.
+	m.busConflicts = m.sub == 2
+	return m
+}
+
+func (m *mapper2) PrgRead(addr uint16) uint8 {
+	switch {
+	case addr < 0x6000:
+		return 0
+	case addr < 0x8000:
+		return m.prgRAMRead(addr)
+	case addr < 0xC000:
+		return m.prgBankRead(uint32(m.bank), 0x4000, addr)
+	}
+
+	return m.prgBankRead(m.numPrgBanks(0x4000)-1, 0x4000, addr)
+}
+
+func (m *mapper2) PrgWrite(addr uint16, val uint8) {
+	switch {
+	case addr < 0x6000:
+		return
+	case addr < 0x8000:
+		m.prgRAMWrite(addr, val)
+	default:
+		m.bank = m.busConflict(val, m.PrgRead(addr))
+	}
+}
+
+func (m *mapper2) ChrRead(addr uint16) uint8 {
+	return m.chrBankRead(0, 0x2000, addr)
+}
+
+func (m *mapper2) ChrWrite(addr uint16, val uint8) {
+	m.chrBankWrite(0, 0x2000, addr, val)
+}
+
+func (m *mapper2) Capabilities() Capabilities {
+	return m.caps(0x4000, 0,
+		m.prgBankInfo(0x8000, uint32(m.bank), 0x4000),
+		m.prgBankInfo(0xC000, m.numPrgBanks(0x4000)-1, 0x4000),
+		m.chrBankInfo(0x0000, 0, 0x2000))
+}
+
+func (m *mapper2) State(s *state.Codec) {
+	m.baseMapper.State(s)
+	s.Uint8(&m.bank)
+}
@@ -0,0 +1,117 @@
+package mappers
+
+import (
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
+)
+
+func init() {
+	RegisterMapper(206, newMapper206)
+}
+
+// mapper206 implements the Namco 118 (aka Namco 108 or DxROM)
+// board. It's the predecessor of the MMC3 and shares its bank
+// select/bank data register pair, but it has no IRQ counter, no PRG
+// or CHR mode bits and its mirroring is hardwired on the board.
+// https://www.nesdev.org/wiki/INES_Mapper_206
+type mapper206 struct {
+	*baseMapper
+	bankSelect uint8    // which of regs the next bank data write updates
+	regs       [8]uint8 // R0-R5 are CHR banks, R6 and R7 are PRG banks
+}
+
+func newMapper206(r *nesrom.ROM) Mapper {
+	return &mapper206{
+		baseMapper: newBaseMapper(206, "Namco 118", r),
+		regs:       [8]uint8{0, 2, 4, 5, 6, 7, 0, 1},
+	}
+}
+
+func (m *mapper206) PrgRead(addr uint16) uint8 {
+	if addr < 0x8000 {
+		return 0 // No PRG RAM on these boards
+	}
+
+	// $8000 and $A000 are switchable 8KB banks. $C000 and $E000
+	// are fixed to the second last and last banks.
+	var bank uint32
+	switch {
+	case addr < 0xA000:
+		bank = uint32(m.regs[6] & 0x0F)
+	case addr < 0xC000:
+		bank = uint32(m.regs[7] & 0x0F)
+	case addr < 0xE000:
+		bank = m.numPrgBanks(0x2000) - 2
+	default:
+		bank = m.numPrgBanks(0x2000) - 1
+	}
+
+	return m.prgBankRead(bank, 0x2000, addr)
+}
+
+func (m *mapper206) PrgWrite(addr uint16, val uint8) {
+	// Only $8000-$9FFF is decoded. Even addresses select the bank
+	// register, odd addresses load it.
+	if addr < 0x8000 || addr > 0x9FFF {
+		return
+	}
+
+	switch addr & 0x01 {
+	case 0:
+		m.bankSelect = val & 0x07
+	case 1:
+		m.regs[m.bankSelect] = val & 0x3F
+	}
+}
+
+// chrBank returns the bank number and bank size for the CHR bank
+// mapped at addr. $0000-$0FFF is two 2KB banks (with the low bit of
+// the bank number ignored) and $1000-$1FFF is four 1KB banks.
+func (m *mapper206) chrBank(addr uint16) (uint32, uint32) {
+	switch {
+	case addr < 0x0800:
+		return uint32(m.regs[0] >> 1), 0x0800
+	case addr < 0x1000:
+		return uint32(m.regs[1] >> 1), 0x0800
+	}
+
+	return uint32(m.regs[2+(addr-0x1000)/0x0400]), 0x0400
+}
+
+func (m *mapper206) ChrRead(addr uint16) uint8 {
+	bank, size := m.chrBank(addr)
+	return m.chrBankRead(bank, size, addr)
+}
+
+func (m *mapper206) ChrWrite(addr uint16, val uint8) {
+	bank, size := m.chrBank(addr)
+	m.chrBankWrite(bank, size, addr, val)
+}
+
+func (m *mapper206) Capabilities() Capabilities {
+	c := m.caps(0x2000, 0x0400)
+	for addr := uint32(0x8000); addr <= 0xE000; addr += 0x2000 {
+		var bank uint32
+		switch addr {
+		case 0x8000:
+			bank = uint32(m.regs[6] & 0x0F)
+		case 0xA000:
+			bank = uint32(m.regs[7] & 0x0F)
+		default:
+			bank = m.numPrgBanks(0x2000) - 2 + (addr-0xC000)/0x2000
+		}
+		c.Banks = append(c.Banks, m.prgBankInfo(uint16(addr), bank, 0x2000))
+	}
+	for addr := uint16(0); addr < 0x2000; {
+		bank, size := m.chrBank(addr)
+		c.Banks = append(c.Banks, m.chrBankInfo(addr, bank, size))
+		addr += uint16(size)
+	}
+	return c
+}
+
+func (m *mapper206) State(s *state.Codec) {
+	m.baseMapper.State(s)
+	s.Uint8(&m.bankSelect)
+	s.Bytes(m.regs[:])
+}
@@ -0,0 +1,79 @@
+package mappers
+
+import (
+	"testing"
+
+	"github.com/bdwalton/gintendo/nesrom"
+)
+
+func TestMapper206Banking(t *testing.T) {
+	// 128KB of PRG and 64KB of CHR. Each byte of the test ROM holds
+	// its 8KB PRG or 1KB CHR bank number.
+	m, err := Load(testROMFile(t, nesrom.HeaderBytes(8, 8, nesrom.CartInfo{Mapper: 206})))
+	if err != nil {
+		t.Fatalf("couldn't load test ROM: %v", err)
+	}
+	if m.ID() != 206 {
+		t.Fatalf("Loaded mapper %d, wanted 206", m.ID())
+	}
+
+	// R0, R1: 2KB CHR at $0000 and $0800, ignoring the low bit.
+	// R2-R5: 1KB CHR at $1000-$1FFF. R6, R7: 8KB PRG at $8000
+	// and $A000.
+	for r, v := range []uint8{7, 10, 20, 21, 22, 63, 3, 0x15} {
+		m.PrgWrite(0x8000, uint8(r))
+		m.PrgWrite(0x8001, v)
+	}
+	m.PrgWrite(0xA000, 0) // Not decoded
+	m.PrgWrite(0xA001, 9)
+
+	prg := []struct {
+		addr uint16
+		want uint8
+	}{
+		{0x8000, 3},
+		{0xA000, 5}, // Only 4 bits of PRG bank
+		{0xC000, 14},
+		{0xE000, 15},
+	}
+	for _, tc := range prg {
+		if got := m.PrgRead(tc.addr); got != tc.want {
+			t.Errorf("PrgRead(0x%04x) = %d, wanted bank %d", tc.addr, got, tc.want)
+		}
+	}
+
+	chr := []struct {
+		addr uint16
+		want uint8
+	}{
+		{0x0000, 6}, // R0 = 7, so the 2KB bank at 6KB
+		{0x0400, 7},
+		{0x0800, 10},
+		{0x0C00, 11},
+		{0x1000, 20},
+		{0x1400, 21},
+		{0x1800, 22},
+		{0x1C00, 63},
+	}
+	for _, tc := range chr {
+		if got := m.ChrRead(tc.addr); got != tc.want {
+			t.Errorf("ChrRead(0x%04x) = %d, wanted bank %d", tc.addr, got, tc.want)
+		}
+	}
+}
+
+func TestMapper206Mirroring(t *testing.T) {
+	// Gauntlet is a four screen board.
+	for i, want := range []uint8{nesrom.MIRROR_HORIZONTAL, nesrom.MIRROR_VERTICAL, nesrom.MIRROR_FOUR_SCREEN} {
+		m, err := FromROM(testROM(t, nesrom.HeaderBytes(2, 1, nesrom.CartInfo{Mapper: 206, Mirroring: want})))
+		if err != nil {
+			t.Fatalf("%d: FromROM() = %v", i, err)
+		}
+		// Mirroring is hardwired, whatever's written.
+		m.PrgWrite(0xA000, 0)
+		m.PrgWrite(0xA000, 1)
+		if got := m.MirroringMode(); got != want {
+			t.Errorf("%d: MirroringMode() = %d, wanted %d", i, got, want)
+		}
+	}
+}
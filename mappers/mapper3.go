@@ -0,0 +1,63 @@
+package mappers
+
+import (
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
+)
+
+func init() {
+	RegisterMapper(3, newMapper3)
+}
+
+// mapper3 implements the CNROM boards. PRG is a fixed 16 or 32KB and
+// writes to $8000-$FFFF select an 8KB CHR bank.
+// https://www.nesdev.org/wiki/CNROM
+type mapper3 struct {
+	*baseMapper
+	bank uint8
+}
+
+func newMapper3(r *nesrom.ROM) Mapper {
+	m := &mapper3{
+		baseMapper: newBaseMapper(3, "CNROM", r),
+	}
+	// Unlike UxROM, CNROM boards generally lack bus conflict
+	// prevention, so only submapper 1 goes without them.
+	m.busConflicts = m.sub != 1
+	return m
+}
+
+func (m *mapper3) PrgRead(addr uint16) uint8 {
+	if addr < 0x8000 {
+		return 0
+	}
+
+	// 16KB ROMs are mirrored into $C000-$FFFF by the bank wrap
+	return m.prgBankRead(uint32(addr-0x8000)>>14, 0x4000, addr)
+}
+
+func (m *mapper3) PrgWrite(addr uint16, val uint8) {
+	if addr >= 0x8000 {
+		m.bank = m.busConflict(val, m.PrgRead(addr))
+	}
+}
+
+func (m *mapper3) ChrRead(addr uint16) uint8 {
+	return m.chrBankRead(uint32(m.bank), 0x2000, addr)
+}
+
+func (m *mapper3) ChrWrite(addr uint16, val uint8) {
+	m.chrBankWrite(uint32(m.bank), 0x2000, addr, val)
+}
+
+func (m *mapper3) Capabilities() Capabilities {
+	return m.caps(0, 0x2000,
+		m.prgBankInfo(0x8000, 0, 0x4000),
+		m.prgBankInfo(0xC000, 1, 0x4000),
+		m.chrBankInfo(0x0000, uint32(m.bank), 0x2000))
+}
+
+func (m *mapper3) State(s *state.Codec) {
+	m.baseMapper.State(s)
+	s.Uint8(&m.bank)
+}
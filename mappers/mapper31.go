@@ -0,0 +1,65 @@
+package mappers
+
+import (
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
+)
+
+func init() {
+	RegisterMapper(31, newMapper31)
+}
+
+// mapper31 implements the NSF-style banking used by homebrew
+// multicarts and NSF-derived ROMs. $8000-$FFFF is split into eight
+// 4KB windows, each selected by writing a bank number to
+// $5FF8-$5FFF (mirrored throughout $5000-$5FFF).
+// https://www.nesdev.org/wiki/INES_Mapper_031
+type mapper31 struct {
+	*baseMapper
+	banks [8]uint8
+}
+
+func newMapper31(r *nesrom.ROM) Mapper {
+	return &mapper31{
+		baseMapper: newBaseMapper(31, "NSF-style", r),
+		// The last window powers on pointing at the last bank
+		// so that the vectors are reachable.
+		banks: [8]uint8{0, 0, 0, 0, 0, 0, 0, 0xFF},
+	}
+}
+
+func (m *mapper31) PrgRead(addr uint16) uint8 {
+	if addr < 0x8000 {
+		return 0
+	}
+
+	return m.prgBankRead(uint32(m.banks[(addr>>12)&0x07]), 0x1000, addr)
+}
+
+func (m *mapper31) PrgWrite(addr uint16, val uint8) {
+	if addr >= 0x5000 && addr < 0x6000 {
+		m.banks[addr&0x07] = val
+	}
+}
+
+func (m *mapper31) ChrRead(addr uint16) uint8 {
+	return m.chrBankRead(0, 0x2000, addr)
+}
+
+func (m *mapper31) ChrWrite(addr uint16, val uint8) {
+	m.chrBankWrite(0, 0x2000, addr, val)
+}
+
+func (m *mapper31) Capabilities() Capabilities {
+	c := m.caps(0x1000, 0)
+	for i, b := range m.banks {
+		c.Banks = append(c.Banks, m.prgBankInfo(0x8000+uint16(i)*0x1000, uint32(b), 0x1000))
+	}
+	c.Banks = append(c.Banks, m.chrBankInfo(0x0000, 0, 0x2000))
+	return c
+}
+
+func (m *mapper31) State(s *state.Codec) {
+	m.baseMapper.State(s)
+	s.Bytes(m.banks[:])
+}
@@ -0,0 +1,123 @@
+package mappers
+
+import (
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
+)
+
+func init() {
+	RegisterMapper(34, newMapper34)
+}
+
+// Submappers distinguishing the two unrelated boards sharing mapper 34
+const (
+	MAPPER34_NINA001 = 1
+	MAPPER34_BNROM   = 2
+)
+
+// mapper34 implements both BNROM and AVE NINA-001, which were
+// assigned the same mapper number. BNROM has a single 32KB PRG bank
+// register at $8000-$FFFF (with bus conflicts) and 8KB of CHR RAM.
+// NINA-001 has registers at $7FFD-$7FFF selecting a 32KB PRG bank and
+// two 4KB CHR banks, overlaid on 8KB of PRG RAM.
+// https://www.nesdev.org/wiki/INES_Mapper_034
+type mapper34 struct {
+	*baseMapper
+	nina    bool
+	prgBank uint8
+	chrBank [2]uint8
+}
+
+func newMapper34(r *nesrom.ROM) Mapper {
+	nina := r.SubmapperNum() == MAPPER34_NINA001
+	if r.SubmapperNum() == 0 {
+		// Only NINA-001 has CHR ROM larger than 8KB.
+		nina = r.NumChrBlocks() > 1
+	}
+
+	name := "BNROM"
+	if nina {
+		name = "NINA-001"
+	}
+
+	m := &mapper34{
+		baseMapper: newBaseMapper(34, name, r),
+		nina:       nina,
+		chrBank:    [2]uint8{0, 1},
+	}
+	m.busConflicts = !nina
+	return m
+}
+
+func (m *mapper34) PrgRead(addr uint16) uint8 {
+	switch {
+	case addr < 0x6000:
+		return 0
+	case addr < 0x8000:
+		if m.nina {
+			return m.prgRAMRead(addr)
+		}
+		return 0
+	}
+
+	return m.prgBankRead(uint32(m.prgBank), 0x8000, addr)
+}
+
+func (m *mapper34) PrgWrite(addr uint16, val uint8) {
+	switch {
+	case addr < 0x6000:
+		return
+	case addr < 0x8000:
+		if !m.nina {
+			return
+		}
+		// The registers don't prevent the RAM underneath them
+		// from being written.
+		m.prgRAMWrite(addr, val)
+		switch addr {
+		case 0x7FFD:
+			m.prgBank = val & 0x01
+		case 0x7FFE:
+			m.chrBank[0] = val & 0x0F
+		case 0x7FFF:
+			m.chrBank[1] = val & 0x0F
+		}
+	default:
+		if !m.nina {
+			m.prgBank = m.busConflict(val, m.PrgRead(addr))
+		}
+	}
+}
+
+func (m *mapper34) ChrRead(addr uint16) uint8 {
+	if m.nina {
+		return m.chrBankRead(uint32(m.chrBank[addr>>12&1]), 0x1000, addr)
+	}
+	return m.chrBankRead(0, 0x2000, addr)
+}
+
+func (m *mapper34) ChrWrite(addr uint16, val uint8) {
+	if m.nina {
+		m.chrBankWrite(uint32(m.chrBank[addr>>12&1]), 0x1000, addr, val)
+		return
+	}
+	m.chrBankWrite(0, 0x2000, addr, val)
+}
+
+func (m *mapper34) Capabilities() Capabilities {
+	if m.nina {
+		return m.caps(0x8000, 0x1000,
+			m.prgBankInfo(0x8000, uint32(m.prgBank), 0x8000),
+			m.chrBankInfo(0x0000, uint32(m.chrBank[0]), 0x1000),
+			m.chrBankInfo(0x1000, uint32(m.chrBank[1]), 0x1000))
+	}
+	return m.caps(0x8000, 0,
+		m.prgBankInfo(0x8000, uint32(m.prgBank), 0x8000),
+		m.chrBankInfo(0x0000, 0, 0x2000))
+}
+
+func (m *mapper34) State(s *state.Codec) {
+	m.baseMapper.State(s)
+	s.Uint8(&m.prgBank)
+	s.Bytes(m.chrBank[:])
+}
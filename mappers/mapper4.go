@@ -0,0 +1,14 @@
+package mappers
+
+import "github.com/bdwalton/gintendo/nesrom"
+
+func init() {
+	RegisterMapper(4, newMapper4)
+}
+
+// newMapper4 returns the plain MMC3 (TxROM) boards, which wire it up
+// as designed, so the shared core is all they need.
+// https://www.nesdev.org/wiki/MMC3
+func newMapper4(r *nesrom.ROM) Mapper {
+	return newMMC3(4, "MMC3", r)
+}
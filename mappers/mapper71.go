@@ -0,0 +1,90 @@
+package mappers
+
+import (
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
+)
+
+// Submapper for the BF9097 board used by Fire Hawk
+const MAPPER71_BF9097 = 1
+
+func init() {
+	RegisterMapper(71, newMapper71)
+}
+
+// mapper71 implements the Camerica/Codemasters BF909x boards. Writes
+// to $C000-$FFFF select the 16KB PRG bank at $8000 and $C000-$FFFF is
+// fixed to the last bank. The BF9097 additionally selects
+// single-screen mirroring with bit 4 of writes to $8000-$9FFF.
+// https://www.nesdev.org/wiki/INES_Mapper_071
+type mapper71 struct {
+	*baseMapper
+	bank      uint8
+	mirroring uint8
+}
+
+func newMapper71(r *nesrom.ROM) Mapper {
+	return &mapper71{
+		baseMapper: newBaseMapper(71, "Camerica BF909x", r),
+		mirroring:  r.MirroringMode(),
+	}
+}
+
+// mirrorRegister reports whether a write to addr controls mirroring.
+// Without a submapper, we follow the common practice of only
+// honouring writes to $9000-$9FFF, which Fire Hawk uses and other
+// BF909x games avoid.
+func (m *mapper71) mirrorRegister(addr uint16) bool {
+	if m.sub == MAPPER71_BF9097 {
+		return addr >= 0x8000 && addr < 0xA000
+	}
+	return m.sub == 0 && addr >= 0x9000 && addr < 0xA000
+}
+
+func (m *mapper71) MirroringMode() uint8 {
+	return m.mirroring
+}
+
+func (m *mapper71) PrgRead(addr uint16) uint8 {
+	switch {
+	case addr < 0x8000:
+		return 0
+	case addr < 0xC000:
+		return m.prgBankRead(uint32(m.bank), 0x4000, addr)
+	}
+
+	return m.prgBankRead(m.numPrgBanks(0x4000)-1, 0x4000, addr)
+}
+
+func (m *mapper71) PrgWrite(addr uint16, val uint8) {
+	switch {
+	case m.mirrorRegister(addr):
+		m.mirroring = nesrom.MIRROR_SINGLE_LOWER
+		if val&0x10 != 0 {
+			m.mirroring = nesrom.MIRROR_SINGLE_UPPER
+		}
+	case addr >= 0xC000:
+		m.bank = val
+	}
+}
+
+func (m *mapper71) ChrRead(addr uint16) uint8 {
+	return m.chrBankRead(0, 0x2000, addr)
+}
+
+func (m *mapper71) ChrWrite(addr uint16, val uint8) {
+	m.chrBankWrite(0, 0x2000, addr, val)
+}
+
+func (m *mapper71) Capabilities() Capabilities {
+	return m.caps(0x4000, 0,
+		m.prgBankInfo(0x8000, uint32(m.bank), 0x4000),
+		m.prgBankInfo(0xC000, m.numPrgBanks(0x4000)-1, 0x4000),
+		m.chrBankInfo(0x0000, 0, 0x2000))
+}
+
+func (m *mapper71) State(s *state.Codec) {
+	m.baseMapper.State(s)
+	s.Uint8(&m.bank)
+	s.Uint8(&m.mirroring)
+}
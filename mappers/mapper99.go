@@ -0,0 +1,80 @@
+package mappers
+
+import (
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
+)
+
+func init() {
+	RegisterMapper(99, newMapper99)
+}
+
+// mapper99 implements the Vs. UniSystem's own banking, which is
+// driven by bit 2 of writes to $4016 (the OUT2 pin) rather than by
+// writes to the cartridge. The bit selects the 8KB CHR bank and, on
+// boards with more than 32KB of PRG (Gumshoe), whether $8000-$9FFF
+// holds PRG bank 0 or 4. The 2KB of RAM at $6000 is mirrored through
+// $7FFF.
+// https://www.nesdev.org/wiki/INES_Mapper_099
+type mapper99 struct {
+	*baseMapper
+	out uint8 // bit 2 of the last $4016 write
+}
+
+func newMapper99(r *nesrom.ROM) Mapper {
+	return &mapper99{
+		baseMapper: newBaseMapper(99, "Vs. UniSystem", r),
+	}
+}
+
+// WriteOut implements OutputLatch.
+func (m *mapper99) WriteOut(val uint8) {
+	m.out = (val >> 2) & 0x01
+}
+
+func (m *mapper99) prgBank(addr uint16) uint32 {
+	bank := uint32(addr-0x8000) >> 13
+	if bank == 0 && m.numPrgBanks(0x2000) > 4 {
+		bank = uint32(m.out) << 2
+	}
+	return bank
+}
+
+func (m *mapper99) PrgRead(addr uint16) uint8 {
+	switch {
+	case addr < 0x6000:
+		return 0
+	case addr < 0x8000:
+		return m.prgRAMRead(addr)
+	}
+
+	return m.prgBankRead(m.prgBank(addr), 0x2000, addr)
+}
+
+func (m *mapper99) PrgWrite(addr uint16, val uint8) {
+	if addr >= 0x6000 && addr < 0x8000 {
+		m.prgRAMWrite(addr, val)
+	}
+}
+
+func (m *mapper99) ChrRead(addr uint16) uint8 {
+	return m.chrBankRead(uint32(m.out), 0x2000, addr)
+}
+
+func (m *mapper99) ChrWrite(addr uint16, val uint8) {
+	m.chrBankWrite(uint32(m.out), 0x2000, addr, val)
+}
+
+func (m *mapper99) Capabilities() Capabilities {
+	return m.caps(0x2000, 0x2000,
+		m.prgBankInfo(0x8000, m.prgBank(0x8000), 0x2000),
+		m.prgBankInfo(0xA000, 1, 0x2000),
+		m.prgBankInfo(0xC000, 2, 0x2000),
+		m.prgBankInfo(0xE000, 3, 0x2000),
+		m.chrBankInfo(0x0000, uint32(m.out), 0x2000))
+}
+
+func (m *mapper99) State(s *state.Codec) {
+	m.baseMapper.State(s)
+	s.Uint8(&m.out)
+}
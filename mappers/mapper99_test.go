@@ -0,0 +1,77 @@
+package mappers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bdwalton/gintendo/nesrom"
+)
+
+func TestMapper99Banking(t *testing.T) {
+	cases := []struct {
+		prgBlocks uint8
+		out       uint8
+		addr      uint16
+		want      uint8
+	}{
+		{2, 0x00, 0x8000, 0x00},
+		{2, 0x04, 0x8000, 0x00}, // 32KB PRG never switches
+		{2, 0x04, 0xE000, 0x03},
+		{3, 0x00, 0x8000, 0x00},
+		{3, 0x04, 0x8000, 0x04},
+		{3, 0x07, 0x8000, 0x04},
+		{3, 0x04, 0xA000, 0x01},
+	}
+
+	for i, tc := range cases {
+		hdr := nesrom.HeaderBytes(tc.prgBlocks, 2, nesrom.CartInfo{Mapper: 99, Mirroring: nesrom.MIRROR_FOUR_SCREEN})
+		hdr[7] |= nesrom.VS_UNISYSTEM
+		m := newMapper99(testROM(t, hdr)).(*mapper99)
+		m.WriteOut(tc.out)
+		if got := m.PrgRead(tc.addr); got != tc.want {
+			t.Errorf("%d: PrgRead(0x%04x) = 0x%02x, wanted 0x%02x", i, tc.addr, got, tc.want)
+		}
+		// CHR bytes hold their 1KB bank number
+		if got, want := m.ChrRead(0x0000), (tc.out>>2&1)*8; got != want {
+			t.Errorf("%d: ChrRead(0x0000) = 0x%02x, wanted 0x%02x", i, got, want)
+		}
+	}
+}
+
+func TestCheckVsSystem(t *testing.T) {
+	cases := []struct {
+		vs, nes2 bool
+		flags13  uint8
+		wantErr  bool
+	}{
+		{false, false, 0x00, false},
+		{true, false, 0x00, false}, // iNES, assumed to be a RP2C03B
+		{true, true, nesrom.VS_PPU_RC2C05_02, false},
+		{true, true, nesrom.VS_PPU_RP2C04_0003, true},
+		{true, true, nesrom.VS_UNISYSTEM_TKO_BOXING << 4, true},
+		{true, true, nesrom.VS_DUAL_SYSTEM_NORMAL << 4, true},
+	}
+
+	for i, tc := range cases {
+		ci := nesrom.CartInfo{Mapper: 3}
+		if tc.vs {
+			ci.Mapper = 99
+		}
+		// A region needs a NES 2.0 header, which can name the PPU.
+		ci.HasRegion = tc.nes2
+		hdr := nesrom.HeaderBytes(2, 2, ci)
+		if tc.vs {
+			hdr[7] |= nesrom.VS_UNISYSTEM
+		}
+		hdr[13] = tc.flags13
+		r := testROM(t, hdr)
+
+		err := checkVsSystem(r)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%d: checkVsSystem() = %v, wanted error %t", i, err, tc.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrVsSystemUnsupported) {
+			t.Errorf("%d: checkVsSystem() = %v, wanted ErrVsSystemUnsupported", i, err)
+		}
+	}
+}
@@ -3,63 +3,378 @@
 package mappers
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
 )
 
-// A global registry of mappers, keyed by mapper id
-var allMappers map[uint16]Mapper = map[uint16]Mapper{}
+// Constructor builds a mapper for a loaded ROM. It's called once per
+// Load so that every ROM gets its own mapper state.
+type Constructor func(*nesrom.ROM) Mapper
 
-func RegisterMapper(id uint16, m Mapper) {
-	if om, ok := allMappers[id]; ok {
-		panic(fmt.Sprintf("Can't re-register mapper id %d. It's used by %q.", id, om.Name()))
+// A global registry of mapper constructors, keyed by mapper id
+var allMappers map[uint16]Constructor = map[uint16]Constructor{}
+
+// Register makes a mapper constructor available to Load for ROMs
+// using mapper id. It's the entry point for mappers implemented
+// outside of this package, which will usually embed a *Base. It
+// fails if id already has a constructor.
+func Register(id uint16, f Constructor) error {
+	if _, ok := allMappers[id]; ok {
+		return fmt.Errorf("mapper id %d is already registered", id)
 	}
-	allMappers[id] = m
+	allMappers[id] = f
+	return nil
+}
+
+// RegisterMapper is Register for use in init functions, where a
+// duplicate id is a programming error.
+func RegisterMapper(id uint16, f Constructor) {
+	if err := Register(id, f); err != nil {
+		panic(fmt.Sprintf("Can't re-register mapper id %d.", id))
+	}
+}
+
+// Registered reports whether Load has a constructor for mapper id.
+func Registered(id uint16) bool {
+	_, ok := allMappers[id]
+	return ok
 }
 
 // Load will instantiate an nesrom.Rom from romFile and return a
 // mapper with the specified id or an error if we can't load the ROM
-// or don't have a mapper for that id yet.
+// or don't have a mapper for that id yet. Constructors receive the
+// ROM, so boards that share a mapper id can be told apart by
+// consulting its submapper number. A patch found next to the ROM by
+// nesrom.FindPatch is applied first.
 func Load(romFile string) (Mapper, error) {
-	rom, err := nesrom.New(romFile)
+	return LoadPatched(romFile, "")
+}
+
+// LoadPatched is Load with the IPS or BPS patch in patchFile applied
+// to the ROM before it's parsed, so that translations and hacks can
+// run without a patched copy of the ROM. An empty patchFile means
+// look for one next to the ROM.
+func LoadPatched(romFile, patchFile string) (Mapper, error) {
+	m, _, err := load(romFile, patchFile, false)
+	return m, err
+}
+
+// LazyROMs makes Load open plain iNES files with nesrom.Open, so
+// that PRG and CHR ROM are only read as they're used. Patched ROMs,
+// archives and other formats are still read in full.
+var LazyROMs = false
+
+// load implements LoadPatched and LoadWithFallback.
+func load(romFile, patchFile string, fallback bool) (Mapper, bool, error) {
+	if LazyROMs && patchFile == "" && nesrom.FindPatch(romFile) == "" {
+		if rom, err := nesrom.Open(romFile); err == nil {
+			rom.HintRegion(filepath.Base(romFile))
+			return fromROM(rom, fallback)
+		}
+	}
+
+	data, name, err := readROM(romFile, patchFile)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't load ROM: %w", err)
+	}
+
+	return fromBytes(data, name, fallback)
+}
+
+// LoadBytes is Load for a ROM that's already in memory, such as one
+// handed over by a web page. The name, normally the ROM's file name,
+// picks the format by its extension and may carry a region hint.
+// Archives and patches aren't handled.
+func LoadBytes(data []byte, name string) (Mapper, error) {
+	m, _, err := fromBytes(data, name, false)
+	return m, err
+}
+
+// fromBytes builds a mapper for the ROM file contents in data.
+func fromBytes(data []byte, name string, fallback bool) (Mapper, bool, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".fds":
+		m, err := loadFDS(data)
+		return m, false, err
+	case ".nsf", ".nsfe":
+		m, err := loadNSF(data)
+		return m, false, err
+	}
+
+	rom, err := nesrom.NewFromBytes(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't load ROM: %w", err)
+	}
+	rom.HintRegion(name)
+
+	return fromROM(rom, fallback)
+}
+
+// fromROM is FromROM, optionally falling back to NROM for unknown
+// mappers. The ROM is closed if no mapper takes it.
+func fromROM(rom *nesrom.ROM, fallback bool) (Mapper, bool, error) {
+	m, err := FromROM(rom)
+	if fallback && errors.Is(err, ErrUnknownMapper) {
+		return newFallbackMapper(rom), true, nil
+	}
+	if err != nil {
+		rom.Close()
+	}
+
+	return m, false, err
+}
+
+// readROM returns the contents and file name of the ROM in romFile,
+// with the patch in patchFile (or found next to romFile) applied.
+func readROM(romFile, patchFile string) ([]byte, string, error) {
+	data, name, err := nesrom.ReadFile(romFile)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if patchFile == "" {
+		if patchFile = nesrom.FindPatch(romFile); patchFile == "" {
+			return data, name, nil
+		}
+	}
+
+	patch, err := os.ReadFile(patchFile)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't load ROM: %v", err)
+		return nil, "", fmt.Errorf("couldn't read patch: %w", err)
+	}
+	if data, err = nesrom.ApplyPatch(data, patch); err != nil {
+		return nil, "", fmt.Errorf("couldn't apply %s: %w", filepath.Base(patchFile), err)
+	}
+
+	return data, name, nil
+}
+
+// LoadRaw builds a ROM from separate PRG and (optional) CHR binaries,
+// as produced by assemblers like ca65, and returns a mapper for
+// it. The mapper id and mirroring mode would normally come from the
+// ROM header, so the caller supplies them.
+func LoadRaw(prgFile, chrFile string, id uint16, mirroring uint8) (Mapper, error) {
+	prg, err := os.ReadFile(prgFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read PRG: %w", err)
+	}
+
+	var chr []byte
+	if chrFile != "" {
+		if chr, err = os.ReadFile(chrFile); err != nil {
+			return nil, fmt.Errorf("couldn't read CHR: %w", err)
+		}
+	}
+
+	rom, err := nesrom.NewFromRaw(prg, chr, id, mirroring)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't build ROM: %w", err)
+	}
+
+	return FromROM(rom)
+}
+
+// FromROM returns a mapper for an already loaded ROM.
+func FromROM(rom *nesrom.ROM) (Mapper, error) {
+	// Plenty of dumps have bad headers, so the known offenders and
+	// then the cartridge database get the final say.
+	if ci, ok := nesrom.KnownBadHeader(rom); ok {
+		rom.Override(ci)
+	} else if ci, ok := nesrom.DefaultCartDB.Lookup(rom); ok {
+		rom.Override(ci)
+	}
+	if q, ok := nesrom.DefaultQuirks.Lookup(rom); ok {
+		rom.ApplyQuirks(q)
+	}
+
+	if err := checkVsSystem(rom); err != nil {
+		return nil, err
 	}
 
 	id := rom.MapperNum()
-	m, ok := allMappers[id]
+	f, ok := allMappers[id]
 	if !ok {
-		return nil, fmt.Errorf("uknown mapper id %d", id)
+		return nil, fmt.Errorf("%w: id %d", ErrUnknownMapper, id)
 	}
 
-	m.Init(rom)
+	m := f(rom)
+	if err := checkTrainer(m, rom); err != nil {
+		return nil, err
+	}
 	return m, nil
 }
 
+// ErrTrainerUnsupported is returned by Load for ROMs with a trainer
+// on boards that have no RAM at $7000-$71FF to hold it.
+var ErrTrainerUnsupported = errors.New("board can't hold a trainer")
+
+// checkTrainer makes sure that m reads rom's trainer back from
+// $7000-$71FF. Boards without PRG RAM don't decode $6000-$7FFF at
+// all, so a game relying on its trainer would crash.
+func checkTrainer(m Mapper, rom *nesrom.ROM) error {
+	for i, v := range rom.Trainer() {
+		if m.PrgRead(0x7000+uint16(i)) != v {
+			return fmt.Errorf("%w: mapper %d", ErrTrainerUnsupported, rom.MapperNum())
+		}
+	}
+	return nil
+}
+
+// ErrVsSystemUnsupported is returned by Load for Vs. System ROMs
+// needing hardware we don't emulate.
+var ErrVsSystemUnsupported = errors.New("Vs. System not supported")
+
+// checkVsSystem rejects Vs. System ROMs that need PPU palettes or
+// extra hardware we don't have, so they fail clearly instead of
+// running as garbage.
+func checkVsSystem(rom *nesrom.ROM) error {
+	if !rom.IsVsSystem() {
+		return nil
+	}
+
+	switch t := rom.VsPPUType(); {
+	case t >= nesrom.VS_PPU_RP2C04_0001 && t <= nesrom.VS_PPU_RP2C04_0004:
+		return fmt.Errorf("%w: RP2C04-000%d PPU palettes aren't implemented", ErrVsSystemUnsupported, t-nesrom.VS_PPU_RP2C04_0001+1)
+	case t > nesrom.VS_PPU_RC2C05_05:
+		return fmt.Errorf("%w: unknown PPU type %d", ErrVsSystemUnsupported, t)
+	}
+
+	if hw := rom.VsHardwareType(); hw != nesrom.VS_UNISYSTEM_NORMAL {
+		return fmt.Errorf("%w: hardware type %d needs protection or dual system hardware that isn't implemented", ErrVsSystemUnsupported, hw)
+	}
+
+	return nil
+}
+
+// ErrUnknownMapper is returned by Load for ROMs whose mapper id has
+// no registered implementation.
+var ErrUnknownMapper = errors.New("unknown mapper")
+
+// LoadWithFallback behaves like LoadPatched, except that ROMs using
+// an unknown mapper are given NROM mapping instead of being
+// rejected. Plenty of simple homebrew will still boot this way. The
+// returned bool is true when the fallback was used so that callers
+// can warn the user.
+func LoadWithFallback(romFile, patchFile string) (Mapper, bool, error) {
+	return load(romFile, patchFile, true)
+}
+
+// IRQLine is implemented by whatever a mapper's IRQ output is wired
+// to (normally the console bus) so that mappers can assert and
+// release the CPU's IRQ line.
+type IRQLine interface {
+	SetMapperIRQ(asserted bool)
+}
+
+// NametableMapper is implemented by mappers reporting
+// nesrom.MIRROR_MAPPER_CONTROLLED. It returns the physical nametable
+// (0 or 1) that backs logical nametable nt (0-3).
+type NametableMapper interface {
+	NametablePage(nt uint8) uint8
+}
+
+// PPUWatcher is implemented by mappers that watch the PPU's address
+// bus, such as the MMC3, which clocks its scanline counter when A12
+// rises. PPUAddress is called with each address the PPU fetches from.
+type PPUWatcher interface {
+	PPUAddress(addr uint16)
+}
+
+// ExpansionDecoder is implemented by mappers with registers or memory
+// in the expansion area, $4020-$5FFF. Reads there from other mappers,
+// and of addresses DecodesExpansion turns down, see the CPU's open
+// bus.
+type ExpansionDecoder interface {
+	DecodesExpansion(addr uint16) bool
+}
+
+// PrgPeeker is implemented by mappers with registers that change when
+// they're read, like the FDS's disk status. PrgPeek returns what
+// PrgRead would without changing anything, for debuggers and hashing.
+type PrgPeeker interface {
+	PrgPeek(addr uint16) uint8
+}
+
+// OutputLatch is implemented by mappers wired to the CPU's OUT pins,
+// which latch bits 0-2 of every write to $4016. The Vs. System uses
+// them for bank switching.
+type OutputLatch interface {
+	WriteOut(val uint8)
+}
+
+// Stateful is implemented by mappers that can be included in save
+// states. State saves or loads everything a running game can change,
+// such as RAM and bank registers, but not the ROM.
+type Stateful interface {
+	State(*state.Codec)
+}
+
 type Mapper interface {
 	ID() uint16
-	Init(*nesrom.ROM)
 	Name() string
 	PrgRead(uint16) uint8   // Read PRG data
 	PrgWrite(uint16, uint8) // Write PRG data
 	ChrRead(uint16) uint8   // Read CHR data
 	ChrWrite(uint16, uint8) // Write CHR data
 	MirroringMode() uint8   // Which mirroring mode is tilemap data stored in
-	HasSaveRAM() bool       // Whether or not the cartridge exposes Save RAM at 0x6000-0x7999
+	HasSaveRAM() bool       // Whether or not the cartridge's PRG RAM is battery backed
+	ConnectIRQ(IRQLine)     // Wire the mapper's IRQ output to the CPU
+	PrgRAM() []uint8        // The cartridge's PRG RAM, if any
+	LoadPrgRAM(io.Reader) error
+	SavePrgRAM(io.Writer) error
+	Capabilities() Capabilities // Hardware details and current banks
 }
 
 type baseMapper struct {
-	id   uint16
-	rom  *nesrom.ROM
-	name string
+	id     uint16
+	rom    *nesrom.ROM
+	name   string
+	sub    uint8 // NES 2.0 submapper, 0 when unspecified
+	irq    IRQLine
+	chrRAM []uint8 // used in place of CHR ROM when the ROM has none
+	prgRAM []uint8 // sized from the header
+
+	// Set by boards lacking bus conflict prevention
+	busConflicts bool
 }
 
-func newBaseMapper(id uint16, name string) *baseMapper {
-	return &baseMapper{
-		id:   id,
-		name: name,
+func newBaseMapper(id uint16, name string, r *nesrom.ROM) *baseMapper {
+	bm := &baseMapper{
+		id:     id,
+		name:   name,
+		rom:    r,
+		sub:    r.SubmapperNum(),
+		prgRAM: make([]uint8, r.PrgRAMSize()),
+	}
+
+	// A trainer needs RAM at $7000 to live in, even when the
+	// header doesn't ask for any.
+	if r.Trainer() != nil && len(bm.prgRAM) < 0x2000 {
+		bm.prgRAM = make([]uint8, 0x2000)
 	}
+	bm.installTrainer()
+
+	if r.NumChrBlocks() == 0 {
+		s := r.ChrRAMSize()
+		if s < nesrom.CHR_BLOCK_SIZE {
+			s = nesrom.CHR_BLOCK_SIZE
+		}
+		bm.chrRAM = make([]uint8, s)
+	}
+
+	return bm
+}
+
+// Close releases the ROM file when the ROM was opened lazily.
+func (bm *baseMapper) Close() error {
+	return bm.rom.Close()
 }
 
 func (bm *baseMapper) ID() uint16 {
@@ -74,8 +389,76 @@ func (bm *baseMapper) Name() string {
 	return bm.name
 }
 
-func (bm *baseMapper) Init(r *nesrom.ROM) {
-	bm.rom = r
+func (bm *baseMapper) PrgRAM() []uint8 {
+	return bm.prgRAM
+}
+
+// LoadPrgRAM fills PRG RAM from r, typically a .sav file written by
+// SavePrgRAM.
+func (bm *baseMapper) LoadPrgRAM(r io.Reader) error {
+	if _, err := io.ReadFull(r, bm.prgRAM); err != nil {
+		return fmt.Errorf("couldn't load %d bytes of PRG RAM: %w", len(bm.prgRAM), err)
+	}
+	// The trainer is in place at power on, whatever was saved.
+	bm.installTrainer()
+	return nil
+}
+
+// installTrainer copies the ROM's trainer, if any, into PRG RAM at
+// $7000-$71FF.
+func (bm *baseMapper) installTrainer() {
+	for i, v := range bm.rom.Trainer() {
+		bm.prgRAMWrite(0x7000+uint16(i), v)
+	}
+}
+
+// SavePrgRAM writes the contents of PRG RAM to w.
+func (bm *baseMapper) SavePrgRAM(w io.Writer) error {
+	if _, err := w.Write(bm.prgRAM); err != nil {
+		return fmt.Errorf("couldn't save PRG RAM: %w", err)
+	}
+	return nil
+}
+
+// busConflict returns the value that actually reaches a mapper
+// register when val is written to an address where the PRG ROM holds
+// romVal. On boards without bus conflict prevention, the ROM drives
+// the data bus at the same time as the CPU, so the result is the AND
+// of the two.
+func (bm *baseMapper) busConflict(val, romVal uint8) uint8 {
+	if bm.busConflicts {
+		return val & romVal
+	}
+	return val
+}
+
+// prgRAMRead returns the byte of PRG RAM visible at addr
+// ($6000-$7FFF). Smaller RAMs are mirrored through the window.
+func (bm *baseMapper) prgRAMRead(addr uint16) uint8 {
+	if len(bm.prgRAM) == 0 {
+		return 0
+	}
+	return bm.prgRAM[int(addr&0x1FFF)%len(bm.prgRAM)]
+}
+
+// prgRAMWrite stores val in the byte of PRG RAM visible at addr
+// ($6000-$7FFF).
+func (bm *baseMapper) prgRAMWrite(addr uint16, val uint8) {
+	if len(bm.prgRAM) > 0 {
+		bm.prgRAM[int(addr&0x1FFF)%len(bm.prgRAM)] = val
+	}
+}
+
+func (bm *baseMapper) ConnectIRQ(l IRQLine) {
+	bm.irq = l
+}
+
+// setIRQ asserts or releases the mapper's IRQ output. It's safe to
+// call before the mapper has been connected to anything.
+func (bm *baseMapper) setIRQ(asserted bool) {
+	if bm.irq != nil {
+		bm.irq.SetMapperIRQ(asserted)
+	}
 }
 
 func (bm *baseMapper) MirroringMode() uint8 {
@@ -85,3 +468,56 @@ func (bm *baseMapper) MirroringMode() uint8 {
 func (bm *baseMapper) HasSaveRAM() bool {
 	return bm.rom.HasSaveRAM()
 }
+
+// prgBankRead returns the byte at offset within the PRG ROM bank
+// numbered bank, where banks are size bytes long. Bank numbers wrap
+// around the amount of PRG ROM actually present, which mirrors how
+// unconnected high bank bits behave on real boards.
+func (bm *baseMapper) prgBankRead(bank, size uint32, offset uint16) uint8 {
+	n := uint32(bm.rom.NumPrgBlocks()) * nesrom.PRG_BLOCK_SIZE / size
+	if n == 0 {
+		return 0
+	}
+	return bm.rom.PrgRead((bank%n)*size + uint32(offset)%size)
+}
+
+// chrBankRead returns the byte at offset within the CHR bank numbered
+// bank, where banks are size bytes long. When the cartridge has no
+// CHR ROM, the banks are carved out of CHR RAM instead.
+func (bm *baseMapper) chrBankRead(bank, size uint32, offset uint16) uint8 {
+	if bm.chrRAM != nil {
+		return bm.chrRAM[bm.chrRAMOffset(bank, size, offset)]
+	}
+
+	n := uint32(bm.rom.NumChrBlocks()) * nesrom.CHR_BLOCK_SIZE / size
+	if n == 0 {
+		return 0
+	}
+	return bm.rom.ChrRead((bank%n)*size + uint32(offset)%size)
+}
+
+// chrBankWrite stores val at offset within the CHR bank numbered
+// bank. Writes are dropped unless the cartridge uses CHR RAM.
+func (bm *baseMapper) chrBankWrite(bank, size uint32, offset uint16, val uint8) {
+	if bm.chrRAM != nil {
+		bm.chrRAM[bm.chrRAMOffset(bank, size, offset)] = val
+	}
+}
+
+func (bm *baseMapper) chrRAMOffset(bank, size uint32, offset uint16) uint32 {
+	n := uint32(len(bm.chrRAM)) / size
+	return (bank%n)*size + uint32(offset)%size
+}
+
+// numPrgBanks returns the number of size byte banks of PRG ROM.
+func (bm *baseMapper) numPrgBanks(size uint32) uint32 {
+	return uint32(bm.rom.NumPrgBlocks()) * nesrom.PRG_BLOCK_SIZE / size
+}
+
+// State saves or loads the cartridge's RAM. Mappers with registers
+// of their own add them after it.
+func (bm *baseMapper) State(s *state.Codec) {
+	s.Tag("CART")
+	s.Bytes(bm.prgRAM)
+	s.Bytes(bm.chrRAM)
+}
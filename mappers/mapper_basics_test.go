@@ -0,0 +1,329 @@
+package mappers
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
+)
+
+// testROMFile builds a ROM with the header hdr, from
+// nesrom.HeaderBytes, and writes it to a temporary file, returning the
+// path. Every byte of PRG and CHR holds the number of the 8KB (PRG) or
+// 1KB (CHR) bank it lives in, which makes it easy to check banking. If
+// hdr asks for a trainer, its bytes count down from 0xFF.
+func testROMFile(t *testing.T, hdr []byte) string {
+	t.Helper()
+
+	prgBlocks, chrBlocks := hdr[4], hdr[5]
+	data := append([]byte(nil), hdr...)
+	if hdr[6]&nesrom.TRAINER != 0 {
+		for i := 0; i < nesrom.TRAINER_SIZE; i++ {
+			data = append(data, uint8(0xFF-i))
+		}
+	}
+	for i := 0; i < int(prgBlocks)*nesrom.PRG_BLOCK_SIZE; i++ {
+		data = append(data, uint8(i>>13))
+	}
+	for i := 0; i < int(chrBlocks)*nesrom.CHR_BLOCK_SIZE; i++ {
+		data = append(data, uint8(i>>10))
+	}
+
+	f := filepath.Join(t.TempDir(), "test.nes")
+	if err := os.WriteFile(f, data, 0644); err != nil {
+		t.Fatalf("couldn't write test ROM: %v", err)
+	}
+
+	return f
+}
+
+// testROM loads a ROM built by testROMFile.
+func testROM(t *testing.T, hdr []byte) *nesrom.ROM {
+	t.Helper()
+
+	r, err := nesrom.New(testROMFile(t, hdr))
+	if err != nil {
+		t.Fatalf("couldn't load test ROM: %v", err)
+	}
+
+	return r
+}
+
+func TestLoadFreshInstances(t *testing.T) {
+	m1, err := Load("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+
+	m2, err := Load("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+
+	if m1 == m2 {
+		t.Errorf("Load returned the same mapper instance twice")
+	}
+}
+
+func TestLoadRaw(t *testing.T) {
+	dir := t.TempDir()
+	prg, chr := filepath.Join(dir, "game.prg"), filepath.Join(dir, "game.chr")
+	prgData := make([]byte, nesrom.PRG_BLOCK_SIZE)
+	prgData[0x3FFD] = 0xC0
+	if err := os.WriteFile(prg, prgData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	chrData := make([]byte, nesrom.CHR_BLOCK_SIZE)
+	chrData[0x10] = 0x99
+	if err := os.WriteFile(chr, chrData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := LoadRaw(prg, chr, 0, nesrom.MIRROR_VERTICAL)
+	if err != nil {
+		t.Fatalf("LoadRaw() = %v", err)
+	}
+	if m.ID() != 0 || m.MirroringMode() != nesrom.MIRROR_VERTICAL {
+		t.Errorf("Got mapper %d, mirroring %d, wanted 0, %d", m.ID(), m.MirroringMode(), nesrom.MIRROR_VERTICAL)
+	}
+	if got := m.PrgRead(0xFFFD); got != 0xC0 {
+		t.Errorf("PrgRead(0xFFFD) = 0x%02x, wanted 0xC0", got)
+	}
+	if got := m.ChrRead(0x10); got != 0x99 {
+		t.Errorf("ChrRead(0x10) = 0x%02x, wanted 0x99", got)
+	}
+
+	// Without CHR, the cartridge gets CHR RAM.
+	m, err = LoadRaw(prg, "", 2, nesrom.MIRROR_HORIZONTAL)
+	if err != nil {
+		t.Fatalf("LoadRaw() = %v", err)
+	}
+	m.ChrWrite(0x1234, 0x56)
+	if got := m.ChrRead(0x1234); got != 0x56 {
+		t.Errorf("ChrRead(0x1234) = 0x%02x after writing 0x56", got)
+	}
+
+	if _, err := LoadRaw(filepath.Join(dir, "missing.prg"), "", 0, nesrom.MIRROR_VERTICAL); err == nil {
+		t.Errorf("LoadRaw() succeeded with a missing PRG file")
+	}
+}
+
+func TestLoadPatched(t *testing.T) {
+	f := testROMFile(t, nesrom.HeaderBytes(2, 1, nesrom.CartInfo{}))
+
+	// Turn the PRG byte at $8000 (file offset 16) from 0x00 into 0xEA.
+	ips := []byte("PATCH\x00\x00\x10\x00\x01\xEAEOF")
+	explicit := filepath.Join(t.TempDir(), "hack.ips")
+	if err := os.WriteFile(explicit, ips, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := LoadPatched(f, explicit)
+	if err != nil {
+		t.Fatalf("LoadPatched() = %v", err)
+	}
+	if got := m.PrgRead(0x8000); got != 0xEA {
+		t.Errorf("PrgRead(0x8000) = 0x%02x, wanted 0xEA", got)
+	}
+
+	m, _ = Load(f)
+	if got := m.PrgRead(0x8000); got != 0x00 {
+		t.Errorf("Load() applied a patch that isn't next to the ROM")
+	}
+
+	// Load applies patches named after the ROM automatically.
+	if err := os.WriteFile(strings.TrimSuffix(f, ".nes")+".ips", ips, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err = Load(f)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if got := m.PrgRead(0x8000); got != 0xEA {
+		t.Errorf("PrgRead(0x8000) = 0x%02x after Load(), wanted 0xEA", got)
+	}
+
+	if err := os.WriteFile(explicit, []byte("junk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPatched(f, explicit); err == nil {
+		t.Errorf("LoadPatched() succeeded with a bad patch")
+	}
+}
+
+func TestLoadTrainer(t *testing.T) {
+	hdr := nesrom.HeaderBytes(2, 1, nesrom.CartInfo{})
+	hdr[6] |= nesrom.TRAINER
+	if _, err := Load(testROMFile(t, hdr)); err != nil {
+		t.Errorf("Load() of an NROM with a trainer = %v", err)
+	}
+	// CNROM has no PRG RAM for the trainer to live in.
+	hdr = nesrom.HeaderBytes(2, 1, nesrom.CartInfo{Mapper: 3})
+	hdr[6] |= nesrom.TRAINER
+	if _, err := Load(testROMFile(t, hdr)); !errors.Is(err, ErrTrainerUnsupported) {
+		t.Errorf("Load() of a CNROM with a trainer = %v, wanted ErrTrainerUnsupported", err)
+	}
+}
+
+func TestLoadWithFallback(t *testing.T) {
+	f := testROMFile(t, nesrom.HeaderBytes(2, 1, nesrom.CartInfo{Mapper: 255}))
+
+	if _, err := Load(f); !errors.Is(err, ErrUnknownMapper) {
+		t.Errorf("Load() error = %v, wanted ErrUnknownMapper", err)
+	}
+
+	m, fellBack, err := LoadWithFallback(f, "")
+	if err != nil {
+		t.Fatalf("LoadWithFallback() error = %v", err)
+	}
+	if !fellBack {
+		t.Errorf("LoadWithFallback() didn't report using the fallback")
+	}
+	if m.ID() != 255 {
+		t.Errorf("ID() = %d, wanted 255", m.ID())
+	}
+	if got := m.PrgRead(0xC000); got != 0x02 {
+		t.Errorf("PrgRead(0xC000) = 0x%02x, wanted 0x02", got)
+	}
+
+	if _, fellBack, _ := LoadWithFallback(testROMFile(t, nesrom.HeaderBytes(2, 1, nesrom.CartInfo{})), ""); fellBack {
+		t.Errorf("LoadWithFallback() used the fallback for a known mapper")
+	}
+
+	// Errors from the ROM itself are wrapped, not flattened.
+	short := filepath.Join(t.TempDir(), "short.nes")
+	data, err := os.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(short, data[:len(data)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := LoadWithFallback(short, ""); !errors.Is(err, nesrom.ErrTruncated) {
+		t.Errorf("LoadWithFallback() error = %v, wanted nesrom.ErrTruncated", err)
+	}
+}
+
+func TestBusConflicts(t *testing.T) {
+	r := testROM(t, nesrom.HeaderBytes(8, 2, nesrom.CartInfo{Mapper: 11})) // 4 32KB PRG banks
+
+	cases := []struct {
+		conflicts bool
+		addr      uint16
+		val       uint8
+		want      uint8
+	}{
+		{true, 0x8000, 0x13, 0x00},  // ROM holds 0x00
+		{true, 0xE000, 0x12, 0x02},  // ROM holds 0x03
+		{true, 0xE000, 0x1F, 0x03},  // ROM holds 0x03
+		{false, 0x8000, 0x13, 0x13}, // ROM holds 0x00
+		{false, 0xE000, 0x12, 0x12}, // ROM holds 0x03
+	}
+
+	for i, tc := range cases {
+		m := newMapper11(r).(*mapper11)
+		m.busConflicts = tc.conflicts
+		m.PrgWrite(tc.addr, tc.val)
+		if m.reg != tc.want {
+			t.Errorf("%d: Got 0x%02x, wanted 0x%02x", i, m.reg, tc.want)
+		}
+	}
+}
+
+func TestCNROMBusConflicts(t *testing.T) {
+	r := testROM(t, nesrom.HeaderBytes(2, 4, nesrom.CartInfo{Mapper: 3})) // 32KB PRG, 4 8KB CHR banks
+
+	cases := []struct {
+		addr uint16
+		val  uint8
+		want uint8 // the 8KB CHR bank selected
+	}{
+		{0x8000, 0x03, 0x00}, // ROM holds 0x00
+		{0xC000, 0x03, 0x02}, // ROM holds 0x02
+		{0xE000, 0x03, 0x03}, // ROM holds 0x03
+	}
+
+	for i, tc := range cases {
+		m := newMapper3(r).(*mapper3)
+		m.PrgWrite(tc.addr, tc.val)
+		// CHR bytes hold their 1KB bank number
+		if got := m.ChrRead(0x0000); got != tc.want*8 {
+			t.Errorf("%d: ChrRead(0x0000) = 0x%02x, wanted 0x%02x", i, got, tc.want*8)
+		}
+	}
+}
+
+func TestSubmapperSelection(t *testing.T) {
+	cases := []struct {
+		chr           uint8
+		mapper        uint16
+		submapper     uint8 // NES 2.0 if not 0
+		wantName      string
+		wantConflicts bool
+	}{
+		{0, 2, 0, "UxROM", false},
+		{0, 2, 1, "UxROM", false},
+		{0, 2, 2, "UxROM", true},
+		{0, 34, 0, "BNROM", true},
+		{2, 34, 0, "NINA-001", false},
+		{0, 34, 1, "NINA-001", false},
+		{2, 34, 2, "BNROM", true},
+		{2, 3, 0, "CNROM", true},
+		{2, 3, 1, "CNROM", false},
+		{2, 3, 2, "CNROM", true},
+	}
+
+	for i, tc := range cases {
+		r := testROM(t, nesrom.HeaderBytes(2, tc.chr, nesrom.CartInfo{Mapper: tc.mapper, Submapper: tc.submapper}))
+		f, ok := allMappers[r.MapperNum()]
+		if !ok {
+			t.Fatalf("%d: no mapper %d registered", i, r.MapperNum())
+		}
+		m := f(r)
+		if got := m.Name(); got != tc.wantName {
+			t.Errorf("%d: Got name %q, wanted %q", i, got, tc.wantName)
+		}
+		var got bool
+		switch mm := m.(type) {
+		case *mapper2:
+			got = mm.busConflicts
+		case *mapper3:
+			got = mm.busConflicts
+		case *mapper34:
+			got = mm.busConflicts
+		}
+		if got != tc.wantConflicts {
+			t.Errorf("%d: Got conflicts %t, wanted %t", i, got, tc.wantConflicts)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	r := testROM(t, nesrom.HeaderBytes(8, 0, nesrom.CartInfo{Mapper: 2, Battery: true})) // CHR RAM
+	m := newMapper2(r).(*mapper2)
+	m.PrgWrite(0x8000, 5)
+	m.PrgWrite(0x6123, 0xAB)
+	m.ChrWrite(0x0456, 0xCD)
+
+	var buf bytes.Buffer
+	w := state.NewWriter(&buf)
+	m.State(w)
+	if err := w.Err(); err != nil {
+		t.Fatalf("Saving: %v", err)
+	}
+
+	m2 := newMapper2(r).(*mapper2)
+	rd := state.NewReader(&buf)
+	m2.State(rd)
+	if err := rd.Err(); err != nil {
+		t.Fatalf("Loading: %v", err)
+	}
+	if m2.bank != 5 || m2.PrgRead(0x6123) != 0xAB || m2.ChrRead(0x0456) != 0xCD {
+		t.Errorf("Loaded bank %d, PRG RAM 0x%02x, CHR RAM 0x%02x; wanted 5, 0xab, 0xcd", m2.bank, m2.PrgRead(0x6123), m2.ChrRead(0x0456))
+	}
+}
@@ -0,0 +1,230 @@
+package mappers
+
+import (
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/state"
+)
+
+// mmc3 implements the banking logic shared by the Nintendo MMC3 and
+// the boards built around it (TxSROM, TQROM, etc). Boards embed it
+// and override the pieces they wire differently.
+// https://www.nesdev.org/wiki/MMC3
+type mmc3 struct {
+	*baseMapper
+	bankSelect uint8    // register index, PRG mode and CHR inversion
+	regs       [8]uint8 // R0-R5 are CHR banks, R6 and R7 are PRG banks
+	mirroring  uint8
+
+	prgRAMEnabled   bool
+	prgRAMProtected bool
+
+	irqLatch   uint8
+	irqCounter uint8
+	irqReload  bool
+	irqEnabled bool
+
+	a12    bool  // the PPU's A12 line, as of its last fetch
+	a12Low uint8 // CPU cycles since A12 fell, up to mmc3A12Filter
+}
+
+// mmc3A12Filter is how many CPU cycles A12 must have been low for its
+// next rise to clock the scanline counter. That hides the brief rises
+// between fetches, so the counter is clocked once per line.
+const mmc3A12Filter = 3
+
+// MMC3 bank select flags
+const (
+	MMC3_PRG_MODE      = 1 << 6 // 0: $8000 swappable, 1: $C000 swappable
+	MMC3_CHR_INVERSION = 1 << 7 // 0: 2KB banks at $0000, 1: 2KB banks at $1000
+)
+
+func newMMC3(id uint16, name string, r *nesrom.ROM) *mmc3 {
+	return &mmc3{
+		baseMapper:    newBaseMapper(id, name, r),
+		regs:          [8]uint8{0, 2, 4, 5, 6, 7, 0, 1},
+		mirroring:     r.MirroringMode(),
+		prgRAMEnabled: true,
+	}
+}
+
+func (m *mmc3) MirroringMode() uint8 {
+	return m.mirroring
+}
+
+// prgBank returns the 8KB PRG bank mapped at addr.
+func (m *mmc3) prgBank(addr uint16) uint32 {
+	secondLast := m.numPrgBanks(0x2000) - 2
+	swapped := m.bankSelect&MMC3_PRG_MODE > 0
+
+	switch {
+	case addr < 0xA000:
+		if swapped {
+			return secondLast
+		}
+		return uint32(m.regs[6] & 0x3F)
+	case addr < 0xC000:
+		return uint32(m.regs[7] & 0x3F)
+	case addr < 0xE000:
+		if swapped {
+			return uint32(m.regs[6] & 0x3F)
+		}
+		return secondLast
+	}
+
+	return secondLast + 1
+}
+
+// chrBank returns the full value of the 1KB CHR bank mapped at
+// addr. Boards use the high bits for different purposes so callers
+// must mask off what they need.
+func (m *mmc3) chrBank(addr uint16) uint8 {
+	slot := (addr >> 10) & 0x07
+	if m.bankSelect&MMC3_CHR_INVERSION > 0 {
+		slot ^= 0x04
+	}
+
+	switch slot {
+	case 0:
+		return m.regs[0] & 0xFE
+	case 1:
+		return m.regs[0] | 0x01
+	case 2:
+		return m.regs[1] & 0xFE
+	case 3:
+		return m.regs[1] | 0x01
+	}
+
+	return m.regs[slot-2]
+}
+
+func (m *mmc3) PrgRead(addr uint16) uint8 {
+	switch {
+	case addr < 0x6000:
+		return 0
+	case addr < 0x8000:
+		if !m.prgRAMEnabled {
+			return 0
+		}
+		return m.prgRAMRead(addr)
+	}
+
+	return m.prgBankRead(m.prgBank(addr), 0x2000, addr)
+}
+
+func (m *mmc3) PrgWrite(addr uint16, val uint8) {
+	switch {
+	case addr < 0x6000:
+		return
+	case addr < 0x8000:
+		if m.prgRAMEnabled && !m.prgRAMProtected {
+			m.prgRAMWrite(addr, val)
+		}
+		return
+	}
+
+	// Registers are selected by the address range and whether
+	// the address is even or odd.
+	even := addr&0x01 == 0
+	switch {
+	case addr < 0xA000:
+		if even {
+			m.bankSelect = val
+		} else {
+			m.regs[m.bankSelect&0x07] = val
+		}
+	case addr < 0xC000:
+		if even {
+			// Ignored when the board provides four screen VRAM
+			if m.mirroring != nesrom.MIRROR_FOUR_SCREEN {
+				m.mirroring = nesrom.MIRROR_VERTICAL
+				if val&0x01 == 1 {
+					m.mirroring = nesrom.MIRROR_HORIZONTAL
+				}
+			}
+		} else {
+			m.prgRAMEnabled = val&0x80 > 0
+			m.prgRAMProtected = val&0x40 > 0
+		}
+	case addr < 0xE000:
+		if even {
+			m.irqLatch = val
+		} else {
+			m.irqReload = true
+		}
+	default:
+		// Writing $E000 also acknowledges any pending IRQ.
+		m.irqEnabled = !even
+		if even {
+			m.setIRQ(false)
+		}
+	}
+}
+
+// ClockCPU times how long A12 has been low.
+func (m *mmc3) ClockCPU() {
+	if !m.a12 && m.a12Low < mmc3A12Filter {
+		m.a12Low++
+	}
+}
+
+// PPUAddress watches A12, clocking the scanline counter when it
+// rises after being low for long enough.
+func (m *mmc3) PPUAddress(addr uint16) {
+	a12 := addr&0x1000 != 0
+	switch {
+	case a12 && !m.a12 && m.a12Low >= mmc3A12Filter:
+		m.clockScanline()
+	case !a12 && m.a12:
+		m.a12Low = 0
+	}
+	m.a12 = a12
+}
+
+// clockScanline reloads the IRQ counter when it's 0 or a reload was
+// requested, and decrements it otherwise. Reaching 0 raises an IRQ
+// if they're enabled.
+func (m *mmc3) clockScanline() {
+	if m.irqCounter == 0 || m.irqReload {
+		m.irqCounter, m.irqReload = m.irqLatch, false
+	} else {
+		m.irqCounter--
+	}
+	if m.irqCounter == 0 && m.irqEnabled {
+		m.setIRQ(true)
+	}
+}
+
+func (m *mmc3) ChrRead(addr uint16) uint8 {
+	return m.chrBankRead(uint32(m.chrBank(addr)), 0x0400, addr)
+}
+
+func (m *mmc3) ChrWrite(addr uint16, val uint8) {
+	m.chrBankWrite(uint32(m.chrBank(addr)), 0x0400, addr, val)
+}
+
+func (m *mmc3) Capabilities() Capabilities {
+	c := m.caps(0x2000, 0x0400)
+	c.IRQ = true
+	for addr := uint32(0x8000); addr <= 0xE000; addr += 0x2000 {
+		c.Banks = append(c.Banks, m.prgBankInfo(uint16(addr), m.prgBank(uint16(addr)), 0x2000))
+	}
+	for addr := uint16(0); addr < 0x2000; addr += 0x0400 {
+		c.Banks = append(c.Banks, m.chrBankInfo(addr, uint32(m.chrBank(addr)), 0x0400))
+	}
+	return c
+}
+
+func (m *mmc3) State(s *state.Codec) {
+	m.baseMapper.State(s)
+	s.Uint8(&m.bankSelect)
+	s.Bytes(m.regs[:])
+	s.Uint8(&m.mirroring)
+	s.Bool(&m.prgRAMEnabled)
+	s.Bool(&m.prgRAMProtected)
+	s.Uint8(&m.irqLatch)
+	s.Uint8(&m.irqCounter)
+	s.Bool(&m.irqReload)
+	s.Bool(&m.irqEnabled)
+	s.Bool(&m.a12)
+	s.Uint8(&m.a12Low)
+}
@@ -0,0 +1,103 @@
+package mappers
+
+import (
+	"testing"
+
+	"github.com/bdwalton/gintendo/nesrom"
+)
+
+type testIRQ struct {
+	asserted bool
+}
+
+func (l *testIRQ) SetMapperIRQ(asserted bool) {
+	l.asserted = asserted
+}
+
+func TestMMC3ScanlineIRQ(t *testing.T) {
+	m := newMMC3(4, "MMC3", testROM(t, nesrom.HeaderBytes(2, 1, nesrom.CartInfo{Mapper: 4})))
+	irq := &testIRQ{}
+	m.ConnectIRQ(irq)
+	m.PrgWrite(0xC000, 2) // latch
+	m.PrgWrite(0xC001, 0) // reload
+	m.PrgWrite(0xE001, 0) // enable
+
+	// line runs a scanline's worth of fetches with the background at
+	// $0000 and sprites at $1000: A12 is low for most of the line and
+	// then rises for each of the 8 sprite fetches, only briefly
+	// falling in between.
+	line := func() {
+		for i := 0; i < 100; i++ {
+			m.PPUAddress(0x0000)
+			m.ClockCPU()
+		}
+		for i := 0; i < 8; i++ {
+			m.PPUAddress(0x1000)
+			m.PPUAddress(0x2000)
+			m.ClockCPU()
+		}
+	}
+
+	for i, want := range []uint8{2, 1, 0, 2, 1} {
+		line()
+		if m.irqCounter != want {
+			t.Errorf("line %d: counter = %d, wanted %d", i, m.irqCounter, want)
+		}
+		if got := irq.asserted; got != (want == 0) {
+			t.Errorf("line %d: IRQ asserted = %t, wanted %t", i, got, want == 0)
+		}
+		if irq.asserted {
+			m.PrgWrite(0xE000, 0) // acknowledge and disable
+			m.PrgWrite(0xE001, 0) // then enable again
+		}
+	}
+}
+
+func TestMapper4(t *testing.T) {
+	// 128KB of PRG and 64KB of CHR. Each byte of the test ROM holds
+	// its 8KB PRG or 1KB CHR bank number.
+	m, err := Load(testROMFile(t, nesrom.HeaderBytes(8, 8, nesrom.CartInfo{Mapper: 4, Mirroring: nesrom.MIRROR_VERTICAL})))
+	if err != nil {
+		t.Fatalf("couldn't load test ROM: %v", err)
+	}
+	if m.ID() != 4 {
+		t.Fatalf("Loaded mapper %d, wanted 4", m.ID())
+	}
+
+	for r, v := range []uint8{8, 12, 20, 21, 22, 23, 3, 5} {
+		m.PrgWrite(0x8000, uint8(r))
+		m.PrgWrite(0x8001, v)
+	}
+
+	cases := []struct {
+		bankSelect uint8
+		prg        [4]uint8 // the banks at $8000, $A000, $C000 and $E000
+		chr        [8]uint8 // the banks in each 1KB of CHR
+	}{
+		{0x00, [4]uint8{3, 5, 14, 15}, [8]uint8{8, 9, 12, 13, 20, 21, 22, 23}},
+		{MMC3_PRG_MODE, [4]uint8{14, 5, 3, 15}, [8]uint8{8, 9, 12, 13, 20, 21, 22, 23}},
+		{MMC3_CHR_INVERSION, [4]uint8{3, 5, 14, 15}, [8]uint8{20, 21, 22, 23, 8, 9, 12, 13}},
+	}
+	for i, tc := range cases {
+		m.PrgWrite(0x8000, tc.bankSelect)
+		for j, want := range tc.prg {
+			addr := 0x8000 + uint16(j)*0x2000
+			if got := m.PrgRead(addr); got != want {
+				t.Errorf("%d: PrgRead(0x%04x) = %d, wanted bank %d", i, addr, got, want)
+			}
+		}
+		for j, want := range tc.chr {
+			addr := uint16(j) * 0x0400
+			if got := m.ChrRead(addr); got != want {
+				t.Errorf("%d: ChrRead(0x%04x) = %d, wanted bank %d", i, addr, got, want)
+			}
+		}
+	}
+
+	if got := m.MirroringMode(); got != nesrom.MIRROR_VERTICAL {
+		t.Errorf("MirroringMode() = %d from the header, wanted %d", got, nesrom.MIRROR_VERTICAL)
+	}
+	if m.PrgWrite(0xA000, 1); m.MirroringMode() != nesrom.MIRROR_HORIZONTAL {
+		t.Errorf("MirroringMode() = %d after $A000 = 1, wanted %d", m.MirroringMode(), nesrom.MIRROR_HORIZONTAL)
+	}
+}
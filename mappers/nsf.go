@@ -0,0 +1,385 @@
+package mappers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/nsf"
+	"github.com/bdwalton/gintendo/state"
+)
+
+// Player is implemented by mappers that run a tune's music driver
+// rather than a game, letting the console offer track selection.
+// There's no APU yet, so the tunes run without being heard.
+type Player interface {
+	Tracks() int // The number of tracks
+	Track() int  // The current track, counting from 0
+	// SelectTrack makes track the current one. The CPU must be
+	// reset afterwards to start it.
+	SelectTrack(track int)
+	Info() string // A description of what's playing
+}
+
+const (
+	// The player's driver code and registers live in otherwise
+	// unused address space.
+	NSF_DRIVER_ADDR = 0x4100
+	NSF_REG_TRACK   = 0x4180 // R: the track to INIT
+	NSF_REG_REGION  = 0x4181 // R: 0 for NTSC
+	NSF_REG_START   = 0x4182 // W: start calling PLAY
+	NSF_REG_ACK     = 0x4183 // R: acknowledge the PLAY IRQ
+
+	NSF_BANK_SIZE = 0x1000
+	NTSC_CPU_HZ   = 1789773
+)
+
+// nsfMapper lays out an NSF as the tune expects to see memory and
+// supplies a small driver that calls the tune's INIT routine and then
+// its PLAY routine at the requested rate. The rate is kept by an IRQ
+// timer counting CPU cycles. $6000-$7FFF is RAM and the 4KB windows
+// at $8000-$FFFF can be banked through $5FF8-$5FFF. Tunes for the FDS
+// sound chip get RAM throughout $6000-$FFFF instead, with the
+// windows at $6000 and $7000 banked through $5FF6 and $5FF7, and MMC5
+// tunes get its 1KB ExRAM at $5C00. Beyond that memory layout, the
+// header's expansion sound chips are ignored: writes to their
+// registers go nowhere, as they and the 2A03's own channels await an
+// APU.
+type nsfMapper struct {
+	n      *nsf.NSF
+	prg    []uint8 // the tune's data, padded to whole banks
+	ram    []uint8 // $6000-$7FFF, or $6000-$FFFF for FDS tunes
+	exRAM  []uint8 // MMC5 only
+	driver []uint8
+	irq    IRQLine
+
+	banks [10]uint8 // 4KB banks for $6000-$FFFF
+	first int       // the window an unbanked tune starts in
+
+	track   int
+	period  int // CPU cycles between PLAY calls
+	counter int
+	playing bool
+}
+
+func loadNSF(data []byte) (Mapper, error) {
+	n, err := nsf.Read(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return newNSF(n)
+}
+
+func newNSF(n *nsf.NSF) (*nsfMapper, error) {
+	m := &nsfMapper{
+		n:      n,
+		track:  int(n.StartSong) - 1,
+		period: int(n.NTSCSpeed) * NTSC_CPU_HZ / 1000000,
+	}
+	if m.track < 0 || m.track >= int(n.Songs) {
+		m.track = 0
+	}
+
+	fdsChip := n.Chips&nsf.CHIP_FDS != 0
+	base := uint16(0x8000)
+	if fdsChip && !n.Banked() && n.LoadAddr < 0x8000 {
+		base = 0x6000
+	}
+	if n.LoadAddr < base {
+		return nil, fmt.Errorf("NSF load address 0x%04x is below 0x%04x", n.LoadAddr, base)
+	}
+
+	m.first = int(base-0x6000) / NSF_BANK_SIZE
+	pad := int(n.LoadAddr - base)
+	if n.Banked() {
+		pad = int(n.LoadAddr & 0x0FFF)
+	}
+	size := (pad + len(n.Data) + NSF_BANK_SIZE - 1) / NSF_BANK_SIZE * NSF_BANK_SIZE
+	m.prg = make([]uint8, size)
+	copy(m.prg[pad:], n.Data)
+
+	if fdsChip {
+		m.ram = make([]uint8, 0xA000)
+	} else {
+		m.ram = make([]uint8, 0x2000)
+	}
+	if n.Chips&nsf.CHIP_MMC5 != 0 {
+		m.exRAM = make([]uint8, 0x400)
+	}
+
+	m.driver = nsfDriver(n.InitAddr, n.PlayAddr)
+	m.reset()
+
+	return m, nil
+}
+
+// nsfDriver assembles the code the CPU runs at reset, INIT with the
+// track and region in A and X, and on each timer IRQ, PLAY.
+func nsfDriver(initAddr, playAddr uint16) []uint8 {
+	lo := func(a uint16) uint8 { return uint8(a) }
+	hi := func(a uint16) uint8 { return uint8(a >> 8) }
+
+	d := []uint8{
+		0x78,       // SEI
+		0xD8,       // CLD
+		0xA2, 0xFF, // LDX #$FF
+		0x9A,       // TXS
+		0xA9, 0x00, // LDA #$00
+		0xAA, // TAX
+	}
+	// Clear the 2KB of internal RAM
+	clear := NSF_DRIVER_ADDR + uint16(len(d))
+	for page := uint8(0); page < 8; page++ {
+		d = append(d, 0x9D, 0x00, page) // STA $xx00,X
+	}
+	d = append(d, 0xE8) // INX
+	d = append(d,
+		0xD0, uint8(int(clear)-int(NSF_DRIVER_ADDR)-len(d)-2), // BNE clear
+		0xA9, 0x0F, 0x8D, 0x15, 0x40, // LDA #$0F, STA $4015
+		0xA9, 0x40, 0x8D, 0x17, 0x40, // LDA #$40, STA $4017
+		0xAD, lo(NSF_REG_TRACK), hi(NSF_REG_TRACK), // LDA track
+		0xAE, lo(NSF_REG_REGION), hi(NSF_REG_REGION), // LDX region
+		0x20, lo(initAddr), hi(initAddr), // JSR INIT
+		0x8D, lo(NSF_REG_START), hi(NSF_REG_START), // STA start
+		0x58, // CLI
+	)
+	loop := NSF_DRIVER_ADDR + uint16(len(d))
+	d = append(d, 0x4C, lo(loop), hi(loop)) // JMP loop
+
+	irq := NSF_DRIVER_ADDR + uint16(len(d))
+	d = append(d,
+		0xAD, lo(NSF_REG_ACK), hi(NSF_REG_ACK), // LDA ack
+		0x20, lo(playAddr), hi(playAddr), // JSR PLAY
+		0x40, // RTI
+	)
+
+	nmi := NSF_DRIVER_ADDR + uint16(len(d))
+	d = append(d, 0x40) // RTI
+
+	// The vectors are served from the end of the driver
+	return append(d, lo(nmi), hi(nmi), lo(NSF_DRIVER_ADDR), hi(NSF_DRIVER_ADDR), lo(irq), hi(irq))
+}
+
+// reset puts the memory map back into its initial state ahead of
+// INIT being called for a track.
+func (m *nsfMapper) reset() {
+	m.playing = false
+	m.counter = m.period
+	m.setIRQ(false)
+
+	for i := range m.ram {
+		m.ram[i] = 0
+	}
+
+	// Unbanked tunes are laid out linearly from the first window.
+	for i := range m.banks {
+		m.banks[i] = 0
+		if i >= m.first {
+			m.banks[i] = uint8(i - m.first)
+		}
+	}
+	if m.n.Banked() {
+		copy(m.banks[2:], m.n.Banks[:])
+		if m.fdsChip() {
+			m.banks[0], m.banks[1] = m.n.Banks[6], m.n.Banks[7]
+		}
+	}
+
+	if m.fdsChip() {
+		// The FDS sound chip's tunes run from RAM, so the
+		// banks are copied in rather than mapped.
+		for i := range m.banks {
+			if i >= m.first || m.n.Banked() {
+				m.loadBank(i)
+			}
+		}
+	}
+}
+
+func (m *nsfMapper) fdsChip() bool {
+	return m.n.Chips&nsf.CHIP_FDS != 0
+}
+
+// loadBank copies the bank selected for window i into RAM.
+func (m *nsfMapper) loadBank(i int) {
+	copy(m.ram[i*NSF_BANK_SIZE:(i+1)*NSF_BANK_SIZE], m.bank(m.banks[i]))
+}
+
+// bank returns the contents of 4KB bank b, which are zero beyond the
+// end of the tune.
+func (m *nsfMapper) bank(b uint8) []uint8 {
+	start := int(b) * NSF_BANK_SIZE
+	if start >= len(m.prg) {
+		return make([]uint8, NSF_BANK_SIZE)
+	}
+	return m.prg[start : start+NSF_BANK_SIZE]
+}
+
+func (m *nsfMapper) setIRQ(asserted bool) {
+	if m.irq != nil {
+		m.irq.SetMapperIRQ(asserted)
+	}
+}
+
+// DecodesExpansion implements ExpansionDecoder.
+func (m *nsfMapper) DecodesExpansion(addr uint16) bool {
+	switch {
+	case addr == NSF_REG_TRACK, addr == NSF_REG_REGION, addr == NSF_REG_ACK:
+		return true
+	case addr >= NSF_DRIVER_ADDR && addr < NSF_DRIVER_ADDR+uint16(len(m.driver)):
+		return true
+	}
+	return addr >= 0x5C00 && m.exRAM != nil
+}
+
+func (m *nsfMapper) PrgRead(addr uint16) uint8 {
+	switch {
+	case addr >= 0xFFFA:
+		// Vectors always lead to the driver.
+		return m.driver[len(m.driver)-6+int(addr-0xFFFA)]
+	case addr == NSF_REG_TRACK:
+		return uint8(m.track)
+	case addr == NSF_REG_REGION:
+		return 0
+	case addr == NSF_REG_ACK:
+		m.setIRQ(false)
+		return 0
+	case addr >= NSF_DRIVER_ADDR && addr < NSF_DRIVER_ADDR+uint16(len(m.driver)):
+		return m.driver[addr-NSF_DRIVER_ADDR]
+	case addr >= 0x5C00 && addr < 0x6000 && m.exRAM != nil:
+		return m.exRAM[addr-0x5C00]
+	case addr < 0x6000:
+		return 0
+	case int(addr-0x6000) < len(m.ram):
+		return m.ram[addr-0x6000]
+	}
+
+	w := (addr - 0x6000) / NSF_BANK_SIZE
+	return m.bank(m.banks[w])[addr%NSF_BANK_SIZE]
+}
+
+func (m *nsfMapper) PrgWrite(addr uint16, val uint8) {
+	switch {
+	case addr == NSF_REG_START:
+		m.playing = true
+		m.counter = m.period
+	case addr >= 0x5FF6 && addr < 0x6000:
+		w := int(addr - 0x5FF6)
+		if w < 2 && !m.fdsChip() {
+			return
+		}
+		m.banks[w] = val
+		if m.fdsChip() {
+			m.loadBank(w)
+		}
+	case addr >= 0x5C00 && addr < 0x5FF6 && m.exRAM != nil:
+		m.exRAM[addr-0x5C00] = val
+	case addr >= 0x6000 && int(addr-0x6000) < len(m.ram):
+		m.ram[addr-0x6000] = val
+	}
+}
+
+// ClockCPU raises an IRQ, which the driver answers by calling PLAY,
+// once every period.
+func (m *nsfMapper) ClockCPU() {
+	if !m.playing {
+		return
+	}
+
+	if m.counter--; m.counter <= 0 {
+		m.counter = m.period
+		m.setIRQ(true)
+	}
+}
+
+func (m *nsfMapper) Tracks() int {
+	return int(m.n.Songs)
+}
+
+func (m *nsfMapper) Track() int {
+	return m.track
+}
+
+func (m *nsfMapper) SelectTrack(track int) {
+	if track < 0 || track >= m.Tracks() {
+		return
+	}
+	m.track = track
+	m.reset()
+}
+
+func (m *nsfMapper) Info() string {
+	return fmt.Sprintf("%s\n%s\n%s\n\nTrack %d/%d: %s\n\n<- -> change track\n(no sound: there's no APU yet)", m.n.Name, m.n.Artist, m.n.Copyright, m.track+1, m.Tracks(), m.n.Title(m.track))
+}
+
+func (m *nsfMapper) ID() uint16 {
+	return 0
+}
+
+func (m *nsfMapper) Name() string {
+	return "NSF player"
+}
+
+func (m *nsfMapper) String() string {
+	return m.Name()
+}
+
+// The PPU has nothing to draw, so CHR is left empty.
+func (m *nsfMapper) ChrRead(addr uint16) uint8 {
+	return 0
+}
+
+func (m *nsfMapper) ChrWrite(addr uint16, val uint8) {}
+
+func (m *nsfMapper) MirroringMode() uint8 {
+	return nesrom.MIRROR_HORIZONTAL
+}
+
+func (m *nsfMapper) HasSaveRAM() bool {
+	return false
+}
+
+func (m *nsfMapper) ConnectIRQ(l IRQLine) {
+	m.irq = l
+}
+
+func (m *nsfMapper) PrgRAM() []uint8 {
+	return m.ram
+}
+
+func (m *nsfMapper) LoadPrgRAM(r io.Reader) error {
+	return nil
+}
+
+func (m *nsfMapper) SavePrgRAM(w io.Writer) error {
+	return nil
+}
+
+func (m *nsfMapper) Capabilities() Capabilities {
+	c := Capabilities{
+		PrgROMSize:     uint32(len(m.prg)),
+		PrgRAMSize:     uint32(len(m.ram)),
+		PrgBankSize:    NSF_BANK_SIZE,
+		IRQ:            true,
+		ExpansionAudio: m.n.Chips != 0,
+	}
+	for i, b := range m.banks {
+		c.Banks = append(c.Banks, Bank{Kind: "PRG", Addr: 0x6000 + uint16(i)*NSF_BANK_SIZE, Size: NSF_BANK_SIZE, Num: uint32(b)})
+	}
+	return c
+}
+
+// State saves or loads the player's RAM, banks and progress through
+// the current track.
+func (m *nsfMapper) State(s *state.Codec) {
+	s.Tag("NSF ")
+	s.Bytes(m.ram)
+	s.Bytes(m.exRAM)
+	s.Bytes(m.banks[:])
+	s.Int(&m.track)
+	s.Int(&m.period)
+	s.Int(&m.counter)
+	s.Bool(&m.playing)
+}
@@ -0,0 +1,111 @@
+package mappers
+
+import (
+	"testing"
+
+	"github.com/bdwalton/gintendo/mos6502"
+	"github.com/bdwalton/gintendo/nsf"
+)
+
+// nsfTestBus is just enough of a console to run the NSF driver.
+type nsfTestBus struct {
+	ram [0x800]uint8
+	m   Mapper
+	cpu *mos6502.CPU
+}
+
+func (b *nsfTestBus) Read(addr uint16) uint8 {
+	if addr < 0x2000 {
+		return b.ram[addr&0x7FF]
+	}
+	return b.m.PrgRead(addr)
+}
+
+func (b *nsfTestBus) Write(addr uint16, val uint8) {
+	if addr < 0x2000 {
+		b.ram[addr&0x7FF] = val
+		return
+	}
+	b.m.PrgWrite(addr, val)
+}
+
+func (b *nsfTestBus) SetMapperIRQ(asserted bool) {
+	b.cpu.SetIRQ(mos6502.IRQ_SOURCE_MAPPER, asserted)
+}
+
+func TestNSFPlayer(t *testing.T) {
+	n := &nsf.NSF{
+		Songs:     3,
+		StartSong: 2,
+		LoadAddr:  0x8000,
+		InitAddr:  0x8000,
+		PlayAddr:  0x8004,
+		NTSCSpeed: 1000, // ~1789 cycles
+		Data: []uint8{
+			0x8D, 0x00, 0x60, 0x60, // INIT: STA $6000, RTS
+			0xEE, 0x01, 0x60, 0x60, // PLAY: INC $6001, RTS
+		},
+	}
+
+	m, err := newNSF(n)
+	if err != nil {
+		t.Fatalf("newNSF() = %v", err)
+	}
+
+	b := &nsfTestBus{m: m}
+	m.ConnectIRQ(b)
+	b.cpu = mos6502.New2A03(b)
+
+	run := func(cycles int) {
+		for i := 0; i < cycles; i++ {
+			b.cpu.Tick()
+			m.ClockCPU()
+		}
+	}
+	untilPlaying := func() {
+		for i := 0; i < 100000 && !m.playing; i++ {
+			run(1)
+		}
+	}
+
+	untilPlaying()
+	run(10*m.period + 100)
+
+	if got := m.PrgRead(0x6000); got != 1 {
+		t.Errorf("INIT got track %d, wanted 1", got)
+	}
+	if got := m.PrgRead(0x6001); got != 10 {
+		t.Errorf("PLAY called %d times, wanted 10", got)
+	}
+
+	m.SelectTrack(2)
+	b.cpu.Reset()
+	untilPlaying()
+	if got := m.PrgRead(0x6000); got != 2 {
+		t.Errorf("INIT got track %d after SelectTrack(2), wanted 2", got)
+	}
+}
+
+func TestNSFBanking(t *testing.T) {
+	data := make([]uint8, 3*NSF_BANK_SIZE)
+	for i := range data {
+		data[i] = uint8(i / NSF_BANK_SIZE)
+	}
+	n := &nsf.NSF{Songs: 1, LoadAddr: 0x8000, Banks: [8]uint8{0, 1, 2, 0, 0, 0, 0, 1}, Data: data}
+
+	m, err := newNSF(n)
+	if err != nil {
+		t.Fatalf("newNSF() = %v", err)
+	}
+
+	if got := m.PrgRead(0x9000); got != 1 {
+		t.Errorf("PrgRead(0x9000) = %d, wanted 1", got)
+	}
+	m.PrgWrite(0x5FF9, 2)
+	if got := m.PrgRead(0x9000); got != 2 {
+		t.Errorf("PrgRead(0x9000) = %d after banking, wanted 2", got)
+	}
+	if got := m.PrgRead(0xF000); got != 1 {
+		t.Errorf("PrgRead(0xF000) = %d, wanted 1", got)
+	}
+}
@@ -0,0 +1,109 @@
+// Package metrics serves runtime metrics for a console in the
+// Prometheus text format, so that long running instances like
+// compatibility test farms and streaming rigs can be monitored.
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bdwalton/gintendo/core"
+)
+
+// Metrics collects the console's own counters along with those only
+// the frontend knows, such as how long it takes to draw. It's an
+// http.Handler serving them all.
+type Metrics struct {
+	c *core.Console
+
+	mu         sync.Mutex
+	frameTime  time.Duration // between the last two frames drawn
+	drawn      uint64
+	dropped    uint64
+	underruns  uint64
+	lastFrames uint64 // emulated frames at the last draw
+
+	// The frame and CPU cycle rates, worked out from draws rather
+	// than scrapes so that every scraper sees the same ones
+	fps          float64
+	cps          float64
+	window       time.Duration // drawing time since windowFrames
+	windowFrames uint64
+	windowCycles uint64
+}
+
+// FPS_WINDOW is how much drawing time the emulated frame rate is
+// measured over.
+const FPS_WINDOW = time.Second
+
+// New returns Metrics for c.
+func New(c *core.Console) *Metrics {
+	return &Metrics{c: c}
+}
+
+// ObserveDraw records that the frontend drew a frame, frameTime after
+// the one before. Frames emulated since the last draw, other than the
+// one being drawn, count as dropped.
+func (m *Metrics) ObserveDraw(frameTime time.Duration) {
+	st := m.c.Stats()
+	frames := st.Frames
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.frameTime = frameTime
+	if m.drawn == 0 {
+		m.windowFrames, m.windowCycles = frames, st.CPUCycles
+	} else if m.window += frameTime; m.window >= FPS_WINDOW {
+		m.fps = float64(frames-m.windowFrames) / m.window.Seconds()
+		m.cps = float64(st.CPUCycles-m.windowCycles) / m.window.Seconds()
+		m.window, m.windowFrames, m.windowCycles = 0, frames, st.CPUCycles
+	}
+	m.drawn++
+	if n := frames - m.lastFrames; m.lastFrames != 0 && n > 1 {
+		m.dropped += n - 1
+	}
+	m.lastFrames = frames
+}
+
+// ObserveUnderrun records that the frontend's audio output ran out of
+// samples to play. None of the frontends play audio yet, as there's no
+// APU, so for now it's only counted when a caller reports one.
+func (m *Metrics) ObserveUnderrun() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.underruns++
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	st := m.c.Stats()
+
+	m.mu.Lock()
+	fps, cps, frameTime, drawn, dropped, underruns := m.fps, m.cps, m.frameTime, m.drawn, m.dropped, m.underruns
+	m.mu.Unlock()
+
+	var buf bytes.Buffer
+	write(&buf, "gintendo_frames_total", "counter", "Frames emulated.", float64(st.Frames))
+	write(&buf, "gintendo_cpu_cycles_total", "counter", "CPU cycles emulated.", float64(st.CPUCycles))
+	write(&buf, "gintendo_emulated_fps", "gauge", "Frames emulated per second over the last second of drawing.", fps)
+	write(&buf, "gintendo_cpu_cycles_per_second", "gauge", "CPU cycles emulated per second over the last second of drawing.", cps)
+	write(&buf, "gintendo_frames_drawn_total", "counter", "Frames drawn by the frontend.", float64(drawn))
+	write(&buf, "gintendo_dropped_frames_total", "counter", "Frames emulated but never drawn.", float64(dropped))
+	write(&buf, "gintendo_host_frame_seconds", "gauge", "Time between the last two frames drawn.", frameTime.Seconds())
+	write(&buf, "gintendo_audio_underruns_total", "counter", "Times the frontend's audio output ran out of samples.", float64(underruns))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// write adds a metric with a single value to buf.
+func write(buf *bytes.Buffer, name, typ, help string, v float64) {
+	// Counters quickly get too big for %g to print exactly.
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, typ, name, strconv.FormatFloat(v, 'f', -1, 64))
+}
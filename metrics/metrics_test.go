@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/mappers"
+)
+
+func TestMetrics(t *testing.T) {
+	mp, err := mappers.Load("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+	c := core.New(mp)
+	m := New(c)
+
+	c.RunFrame(core.Inputs{})
+	m.ObserveDraw(16 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		c.RunFrame(core.Inputs{})
+	}
+	m.ObserveDraw(20 * time.Millisecond)
+	m.ObserveUnderrun()
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		"gintendo_frames_total 4\n",
+		"gintendo_frames_drawn_total 2\n",
+		"gintendo_cpu_cycles_total " + strconv.FormatUint(c.Stats().CPUCycles, 10) + "\n",
+		"gintendo_dropped_frames_total 2\n",
+		"gintendo_host_frame_seconds 0.02\n",
+		"gintendo_audio_underruns_total 1\n",
+		"# TYPE gintendo_cpu_cycles_total counter\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Metrics don't include %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsFPS(t *testing.T) {
+	mp, err := mappers.Load("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+	c := core.New(mp)
+	m := New(c)
+
+	metric := func(name string) string {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		for _, l := range strings.Split(rec.Body.String(), "\n") {
+			if strings.HasPrefix(l, name+" ") {
+				return strings.TrimPrefix(l, name+" ")
+			}
+		}
+		t.Fatalf("no %s in the metrics", name)
+		return ""
+	}
+	fps := func() string { return metric("gintendo_emulated_fps") }
+
+	// Two frames emulated for each draw, 100ms apart. The rate only
+	// changes once a whole window has been drawn, however often it's
+	// scraped.
+	m.ObserveDraw(0)
+	start := c.Stats().CPUCycles
+	for i := 0; i < 10; i++ {
+		if got := fps(); got != "0" {
+			t.Errorf("after %d draws, fps = %s, want 0", i+1, got)
+		}
+		c.RunFrame(core.Inputs{})
+		c.RunFrame(core.Inputs{})
+		m.ObserveDraw(100 * time.Millisecond)
+	}
+	for i := 0; i < 2; i++ {
+		if got := fps(); got != "20" {
+			t.Errorf("scrape %d: fps = %s, want 20", i+1, got)
+		}
+	}
+	// The window was a second long.
+	want := strconv.FormatUint(c.Stats().CPUCycles-start, 10)
+	if got := metric("gintendo_cpu_cycles_per_second"); got != want {
+		t.Errorf("cycles/s = %s, want %s", got, want)
+	}
+}
+
+func TestSync(t *testing.T) {
+	mp, err := mappers.Load("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+	c := core.New(mp)
+	s := NewSync(c)
+
+	if r := s.Report(); r.Frames != 0 {
+		t.Errorf("Report() before any frames = %+v, want nothing", r)
+	}
+
+	// Frames delivered 15ms, 17ms and 19ms apart, emulating two
+	// for each.
+	now := time.Unix(1000, 0)
+	s.ObserveFrame(now)
+	for _, d := range []time.Duration{15, 17, 19} {
+		c.RunFrame(core.Inputs{})
+		c.RunFrame(core.Inputs{})
+		now = now.Add(d * time.Millisecond)
+		s.ObserveFrame(now)
+	}
+
+	r := s.Report()
+	if r.Frames != 3 || r.Interval.Round(time.Microsecond) != 17*time.Millisecond || r.Jitter.Round(time.Microsecond) != 2*time.Millisecond {
+		t.Errorf("Report() = %+v, want 3 frames 17ms apart with 2ms jitter", r)
+	}
+	ideal := time.Duration(float64(time.Second) / c.FrameRate())
+	if want := 19*time.Millisecond - ideal; r.WorstJitter != want {
+		t.Errorf("WorstJitter = %v, want %v", r.WorstJitter, want)
+	}
+	if r.Wall != 51*time.Millisecond {
+		t.Errorf("Wall = %v, want 51ms", r.Wall)
+	}
+	if want := seconds(6 / c.FrameRate()); r.Emulated != want || r.Drift() != want-r.Wall {
+		t.Errorf("Emulated = %v, drift %v; want %v, %v", r.Emulated, r.Drift(), want, want-r.Wall)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	mp, err := mappers.Load("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+	c := core.New(mp)
+	p := NewProfile(c)
+
+	for i := 0; i < PROFILE_FRAMES-1; i++ {
+		c.RunFrame(core.Inputs{})
+		p.ObserveFrame(time.Millisecond)
+	}
+	if ft := p.FrameTimes(); ft != (FrameTimes{}) {
+		t.Errorf("FrameTimes() before a full batch = %v, want zeros", ft)
+	}
+
+	c.RunFrame(core.Inputs{})
+	p.ObserveFrame(3 * time.Millisecond)
+	ft := p.FrameTimes()
+	if ft.CPU <= 0 || ft.PPU <= 0 || ft.APU != 0 {
+		t.Errorf("FrameTimes() = %v, want CPU and PPU time", ft)
+	}
+	if want := time.Duration(PROFILE_FRAMES+2) * time.Millisecond / PROFILE_FRAMES; ft.Frontend != want {
+		t.Errorf("Frontend = %v, want %v", ft.Frontend, want)
+	}
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bdwalton/gintendo/core"
+)
+
+// PROFILE_FRAMES is how many frames a Profile averages over.
+const PROFILE_FRAMES = 60
+
+// Profile breaks down where the time for a frame goes, between
+// emulating each part of the console and the frontend showing it, so
+// that a slow game or host can be diagnosed without a debugger.
+type Profile struct {
+	c *core.Console
+
+	mu       sync.Mutex
+	start    core.Stats    // when the current batch of frames started
+	frontend time.Duration // taken by the frontend in this batch
+	n        int           // frames shown in this batch
+	last     FrameTimes
+}
+
+// NewProfile returns a Profile for c, turning on c's profiling.
+func NewProfile(c *core.Console) *Profile {
+	c.SetProfiling(true)
+	return &Profile{c: c, start: c.Stats()}
+}
+
+// FrameTimes are the average time a frame took in each part of the
+// emulator.
+type FrameTimes struct {
+	CPU, PPU, APU time.Duration
+	Frontend      time.Duration // Drawing and presenting the frame
+}
+
+// Total is the time taken by all the parts.
+func (t FrameTimes) Total() time.Duration {
+	return t.CPU + t.PPU + t.APU + t.Frontend
+}
+
+func (t FrameTimes) String() string {
+	return fmt.Sprintf("cpu %.2fms  ppu %.2fms  apu %.2fms  frontend %.2fms",
+		ms(t.CPU), ms(t.PPU), ms(t.APU), ms(t.Frontend))
+}
+
+// ObserveFrame records that the frontend showed a frame, taking
+// frontend to do it. Every PROFILE_FRAMES frames, the averages
+// returned by FrameTimes move on to the latest batch.
+func (p *Profile) ObserveFrame(frontend time.Duration) {
+	st := p.c.Stats()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.frontend += frontend
+	if p.n++; p.n < PROFILE_FRAMES {
+		return
+	}
+
+	// The frontend may show more or fewer frames than are
+	// emulated, so each is averaged over its own.
+	if frames := st.Frames - p.start.Frames; frames > 0 {
+		p.last.CPU = (st.CPUTime - p.start.CPUTime) / time.Duration(frames)
+		p.last.PPU = (st.PPUTime - p.start.PPUTime) / time.Duration(frames)
+		p.last.APU = (st.APUTime - p.start.APUTime) / time.Duration(frames)
+	}
+	p.last.Frontend = p.frontend / time.Duration(p.n)
+	p.start, p.frontend, p.n = st, 0, 0
+}
+
+// FrameTimes returns the averages over the last complete batch of
+// frames, which are zero until there's been one.
+func (p *Profile) FrameTimes() FrameTimes {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.last
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/bdwalton/gintendo/core"
+)
+
+// Sync measures how steadily a frontend delivers frames and how far
+// the emulation drifts from the wall clock, for tuning frame pacing on
+// a host. There's no APU yet, so there's no audio to measure.
+type Sync struct {
+	c *core.Console
+
+	mu                      sync.Mutex
+	start, last             time.Time
+	startFrames, lastFrames uint64 // emulated by start and last
+
+	// Running mean and variance of the time between frames, in
+	// seconds, by Welford's method
+	n        int
+	mean, m2 float64
+	worst    time.Duration // the largest distance from the ideal interval
+}
+
+// NewSync returns a Sync for c.
+func NewSync(c *core.Console) *Sync {
+	return &Sync{c: c}
+}
+
+// ObserveFrame records that the frontend delivered a frame at now.
+func (s *Sync) ObserveFrame(now time.Time) {
+	frames := s.c.Stats().Frames
+	ideal := time.Duration(float64(time.Second) / s.c.FrameRate())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.start.IsZero() {
+		s.start, s.startFrames = now, frames
+		s.last, s.lastFrames = now, frames
+		return
+	}
+
+	d := now.Sub(s.last)
+	s.last, s.lastFrames = now, frames
+	s.n++
+	delta := d.Seconds() - s.mean
+	s.mean += delta / float64(s.n)
+	s.m2 += delta * (d.Seconds() - s.mean)
+
+	off := d - ideal
+	if off < 0 {
+		off = -off
+	}
+	if off > s.worst {
+		s.worst = off
+	}
+}
+
+// SyncReport is a summary of what a Sync has seen.
+type SyncReport struct {
+	Frames      int           // Frames delivered
+	Interval    time.Duration // The mean time between them
+	Jitter      time.Duration // The standard deviation of the time between them
+	WorstJitter time.Duration // The furthest one was from the ideal interval
+	Wall        time.Duration // Time since the first frame
+	Emulated    time.Duration // Time the console emulated in that time
+}
+
+// Drift returns how far emulation is ahead of the wall clock, or
+// behind it if negative.
+func (r SyncReport) Drift() time.Duration {
+	return r.Emulated - r.Wall
+}
+
+func (r SyncReport) String() string {
+	return fmt.Sprintf("frames %d  interval %.2fms  jitter %.2fms (worst %.2fms)\nwall %.1fs  emulated %.1fs  drift %+.3fs",
+		r.Frames, ms(r.Interval), ms(r.Jitter), ms(r.WorstJitter),
+		r.Wall.Seconds(), r.Emulated.Seconds(), r.Drift().Seconds())
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Report summarises the frames observed so far.
+func (s *Sync) Report() SyncReport {
+	rate := s.c.FrameRate()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := SyncReport{Frames: s.n}
+	if s.n == 0 {
+		return r
+	}
+	r.Interval = seconds(s.mean)
+	if s.n > 1 {
+		r.Jitter = seconds(math.Sqrt(s.m2 / float64(s.n-1)))
+	}
+	r.WorstJitter = s.worst
+	r.Wall = s.last.Sub(s.start)
+	r.Emulated = seconds(float64(s.lastFrames-s.startFrames) / rate)
+
+	return r
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
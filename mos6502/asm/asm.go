@@ -0,0 +1,311 @@
+// Package asm is a small 6502 assembler, for writing CPU tests and
+// homebrew snippets as source rather than hex. Its output can be
+// loaded with (*mos6502.CPU).LoadMem.
+//
+// Each line is an optional label, ending in a colon, then an optional
+// instruction or directive, then an optional comment starting with a
+// semicolon:
+//
+//	loop:	LDA data,X	; comment
+//		BNE loop
+//	data:	.byte $01, %10, 3, <loop, >loop
+//		.word loop
+//
+// Operands use the usual syntax: #imm, addr, addr,X, addr,Y, (addr),
+// (addr,X), (addr),Y and A. Numbers are decimal, $hex or %binary, and
+// can be labels, with + and - between them. A leading < or > takes the
+// low or high byte. An address that's known to be below $100 when it's
+// first seen uses zero page addressing if the instruction has it.
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bdwalton/gintendo/mos6502"
+)
+
+// modeBytes is the length of an instruction in each addressing mode.
+var modeBytes = map[uint8]uint16{
+	mos6502.IMPLICIT:    1,
+	mos6502.ACCUMULATOR: 1,
+	mos6502.IMMEDIATE:   2,
+	mos6502.ZERO_PAGE:   2,
+	mos6502.ZERO_PAGE_X: 2,
+	mos6502.ZERO_PAGE_Y: 2,
+	mos6502.RELATIVE:    2,
+	mos6502.INDIRECT_X:  2,
+	mos6502.INDIRECT_Y:  2,
+	mos6502.ABSOLUTE:    3,
+	mos6502.ABSOLUTE_X:  3,
+	mos6502.ABSOLUTE_Y:  3,
+	mos6502.INDIRECT:    3,
+}
+
+// stmt is an instruction or directive, with its address.
+type stmt struct {
+	line int
+	addr uint16
+	name string   // upper case mnemonic or directive
+	mode uint8    // for instructions
+	args []string // expressions: one operand, or directive arguments
+}
+
+// Assemble assembles src for loading at origin and returns the
+// machine code.
+func Assemble(src string, origin uint16) ([]uint8, error) {
+	labels := map[string]uint16{}
+	var stmts []stmt
+
+	// The first pass settles every statement's size, and so every
+	// label's address.
+	pc := origin
+	for n, line := range strings.Split(src, "\n") {
+		line, _, _ = strings.Cut(line, ";")
+		line = strings.TrimSpace(line)
+		if label, rest, ok := strings.Cut(line, ":"); ok && isName(label) {
+			if _, dup := labels[label]; dup {
+				return nil, fmt.Errorf("line %d: label %q redefined", n+1, label)
+			}
+			labels[label] = pc
+			line = strings.TrimSpace(rest)
+		}
+		if line == "" {
+			continue
+		}
+
+		name := strings.Fields(line)[0]
+		operand := strings.TrimSpace(line[len(name):])
+		s := stmt{line: n + 1, addr: pc, name: strings.ToUpper(name)}
+
+		var size uint16
+		switch s.name {
+		case ".BYTE", ".WORD":
+			for _, a := range strings.Split(operand, ",") {
+				if a = strings.TrimSpace(a); a == "" {
+					return nil, fmt.Errorf("line %d: missing %s value", s.line, s.name)
+				}
+				s.args = append(s.args, a)
+			}
+			size = uint16(len(s.args))
+			if s.name == ".WORD" {
+				size *= 2
+			}
+		default:
+			var err error
+			if s.mode, s.args, err = addressing(s.name, operand, labels); err != nil {
+				return nil, fmt.Errorf("line %d: %w", s.line, err)
+			}
+			size = modeBytes[s.mode]
+		}
+		stmts = append(stmts, s)
+		pc += size
+	}
+
+	var code []uint8
+	for _, s := range stmts {
+		b, err := s.encode(labels)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", s.line, err)
+		}
+		code = append(code, b...)
+	}
+	return code, nil
+}
+
+// addressing works out the addressing mode of instruction name with
+// operand, and returns the operand's expression.
+func addressing(name, operand string, labels map[string]uint16) (uint8, []string, error) {
+	var modes []uint8
+	op := strings.ReplaceAll(operand, " ", "")
+	expr := op
+	switch {
+	case op == "":
+		modes = []uint8{mos6502.IMPLICIT, mos6502.ACCUMULATOR}
+	case strings.EqualFold(op, "A"):
+		modes = []uint8{mos6502.ACCUMULATOR}
+	case op[0] == '#':
+		modes, expr = []uint8{mos6502.IMMEDIATE}, op[1:]
+	case op[0] == '(' && hasSuffix(op, ",X)"):
+		modes, expr = []uint8{mos6502.INDIRECT_X}, op[1:len(op)-3]
+	case op[0] == '(' && hasSuffix(op, "),Y"):
+		modes, expr = []uint8{mos6502.INDIRECT_Y}, op[1:len(op)-3]
+	case op[0] == '(' && hasSuffix(op, ")"):
+		modes, expr = []uint8{mos6502.INDIRECT}, op[1:len(op)-1]
+	case hasSuffix(op, ",X"):
+		expr = op[:len(op)-2]
+		modes = sized(expr, labels, mos6502.ZERO_PAGE_X, mos6502.ABSOLUTE_X)
+	case hasSuffix(op, ",Y"):
+		expr = op[:len(op)-2]
+		modes = sized(expr, labels, mos6502.ZERO_PAGE_Y, mos6502.ABSOLUTE_Y)
+	default:
+		modes = append([]uint8{mos6502.RELATIVE}, sized(expr, labels, mos6502.ZERO_PAGE, mos6502.ABSOLUTE)...)
+	}
+
+	for _, m := range modes {
+		if _, ok := mos6502.Opcode(name, m); ok {
+			if m == mos6502.IMPLICIT || m == mos6502.ACCUMULATOR {
+				return m, nil, nil
+			}
+			return m, []string{expr}, nil
+		}
+	}
+	for m := range modeBytes {
+		if _, ok := mos6502.Opcode(name, m); ok {
+			return 0, nil, fmt.Errorf("%s doesn't take operand %q", name, operand)
+		}
+	}
+	return 0, nil, fmt.Errorf("unknown instruction %q", name)
+}
+
+// hasSuffix is strings.HasSuffix, ignoring case.
+func hasSuffix(s, suffix string) bool {
+	return len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix)
+}
+
+// sized returns the zero page mode zp ahead of the absolute mode abs
+// if expr is already known to be a zero page address, and behind it
+// otherwise.
+func sized(expr string, labels map[string]uint16, zp, abs uint8) []uint8 {
+	if v, err := eval(expr, labels); err == nil && v < 0x100 {
+		return []uint8{zp, abs}
+	}
+	return []uint8{abs, zp}
+}
+
+// encode returns the bytes for s.
+func (s stmt) encode(labels map[string]uint16) ([]uint8, error) {
+	var vals []uint16
+	for _, a := range s.args {
+		v, err := eval(a, labels)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+
+	switch s.name {
+	case ".BYTE":
+		var b []uint8
+		for i, v := range vals {
+			if v > 0xFF {
+				return nil, fmt.Errorf("%q doesn't fit in a byte", s.args[i])
+			}
+			b = append(b, uint8(v))
+		}
+		return b, nil
+	case ".WORD":
+		var b []uint8
+		for _, v := range vals {
+			b = append(b, uint8(v), uint8(v>>8))
+		}
+		return b, nil
+	}
+
+	op, _ := mos6502.Opcode(s.name, s.mode)
+	b := []uint8{op}
+	switch modeBytes[s.mode] {
+	case 2:
+		v := vals[0]
+		if s.mode == mos6502.RELATIVE {
+			off := int(v) - int(s.addr+2)
+			if off < -128 || off > 127 {
+				return nil, fmt.Errorf("branch to $%04X is out of range", v)
+			}
+			v = uint16(uint8(off))
+		}
+		if v > 0xFF {
+			return nil, fmt.Errorf("%q doesn't fit in a byte", s.args[0])
+		}
+		b = append(b, uint8(v))
+	case 3:
+		b = append(b, uint8(vals[0]), uint8(vals[0]>>8))
+	}
+	return b, nil
+}
+
+// eval evaluates expr, numbers and labels added and subtracted, with
+// an optional leading < or > for the low or high byte.
+func eval(expr string, labels map[string]uint16) (uint16, error) {
+	expr = strings.ReplaceAll(expr, " ", "")
+	var part byte
+	if expr != "" && (expr[0] == '<' || expr[0] == '>') {
+		part, expr = expr[0], expr[1:]
+	}
+	if expr == "" {
+		return 0, fmt.Errorf("missing value")
+	}
+
+	var sum int
+	sign := 1
+	for expr != "" {
+		i := strings.IndexAny(expr[1:], "+-") + 1
+		if i == 0 {
+			i = len(expr)
+		}
+		term := expr[:i]
+		switch term[0] {
+		case '+':
+			sign, term = 1, term[1:]
+		case '-':
+			sign, term = -1, term[1:]
+		}
+		v, err := value(term, labels)
+		if err != nil {
+			return 0, err
+		}
+		sum += sign * int(v)
+		expr = expr[i:]
+	}
+
+	switch part {
+	case '<':
+		return uint16(uint8(sum)), nil
+	case '>':
+		return uint16(uint8(sum >> 8)), nil
+	}
+	if sum < 0 || sum > 0xFFFF {
+		return 0, fmt.Errorf("value %d is out of range", sum)
+	}
+	return uint16(sum), nil
+}
+
+// value returns the value of a single number or label.
+func value(term string, labels map[string]uint16) (uint16, error) {
+	var v uint64
+	var err error
+	switch {
+	case term == "":
+		return 0, fmt.Errorf("missing value")
+	case term[0] == '$':
+		v, err = strconv.ParseUint(term[1:], 16, 16)
+	case term[0] == '%':
+		v, err = strconv.ParseUint(term[1:], 2, 16)
+	case term[0] >= '0' && term[0] <= '9':
+		v, err = strconv.ParseUint(term, 10, 16)
+	default:
+		a, ok := labels[term]
+		if !ok {
+			return 0, fmt.Errorf("undefined label %q", term)
+		}
+		return a, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("bad number %q", term)
+	}
+	return uint16(v), nil
+}
+
+// isName reports whether s can be a label.
+func isName(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for _, r := range s {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package asm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/bdwalton/gintendo/mos6502"
+)
+
+func TestAssemble(t *testing.T) {
+	cases := []struct {
+		src  string
+		want []uint8
+	}{
+		{"NOP", []uint8{0xEA}},
+		{"asl\nASL A", []uint8{0x0A, 0x0A}},
+		{"LDA #$10", []uint8{0xA9, 0x10}},
+		{"LDA #%1010 ; ten", []uint8{0xA9, 0x0A}},
+		{"LDA $10", []uint8{0xA5, 0x10}},
+		{"LDA $0010", []uint8{0xA5, 0x10}},
+		{"LDA $1234", []uint8{0xAD, 0x34, 0x12}},
+		{"LDA $10,X", []uint8{0xB5, 0x10}},
+		{"LDA $1234, x", []uint8{0xBD, 0x34, 0x12}},
+		{"LDA $1234,Y", []uint8{0xB9, 0x34, 0x12}},
+		{"LDX $10,Y", []uint8{0xB6, 0x10}},
+		{"LDA ($10,X)", []uint8{0xA1, 0x10}},
+		{"LDA ($10),Y", []uint8{0xB1, 0x10}},
+		{"JMP ($1234)", []uint8{0x6C, 0x34, 0x12}},
+		{"here: BNE here", []uint8{0xD0, 0xFE}},
+		{"BEQ there\nNOP\nthere: RTS", []uint8{0xF0, 0x01, 0xEA, 0x60}},
+		{"JSR sub\nsub:\tRTS", []uint8{0x20, 0x03, 0x06, 0x60}},
+		{"LDA data+1\ndata: .byte 1, 2", []uint8{0xAD, 0x04, 0x06, 0x01, 0x02}},
+		{"LDA $FF+1-2", []uint8{0xA5, 0xFE}},
+		{"LDA #<data\nLDX #>data\ndata: .word data, $BEEF", []uint8{0xA9, 0x04, 0xA2, 0x06, 0x04, 0x06, 0xEF, 0xBE}},
+		{"LDA fwd\nfwd: .byte 3", []uint8{0xAD, 0x03, 0x06, 0x03}},
+		{"SAX ($10,X)\nSAX $1234", []uint8{0x83, 0x10, 0x8F, 0x34, 0x12}},
+		{"LAX $10\nSBX #1", []uint8{0xA7, 0x10, 0xCB, 0x01}},
+	}
+
+	for _, tc := range cases {
+		got, err := Assemble(tc.src, 0x0600)
+		if err != nil || !bytes.Equal(got, tc.want) {
+			t.Errorf("%q: Assemble() = % X, %v; wanted % X", tc.src, got, err, tc.want)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	cases := []struct {
+		src, want string
+	}{
+		{"NOP\nLDA missing", "line 2: undefined label"},
+		{"a: NOP\na: NOP", "line 2: label \"a\" redefined"},
+		{"LDA ($1234),Y", "doesn't fit in a byte"},
+		{"STY 300,X", "doesn't fit in a byte"}, // STY has no abs,X
+		{"STA #1", "STA doesn't take operand"},
+		{"BNE far\n.byte " + strings.Repeat("0,", 200) + "0\nfar: NOP", "out of range"},
+		{".byte 256", "doesn't fit in a byte"},
+		{"LDA #$1G", "bad number"},
+		{"XYZ", "unknown instruction \"XYZ\""},
+	}
+
+	for _, tc := range cases {
+		if _, err := Assemble(tc.src, 0x0600); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: Assemble() = %v, wanted an error with %q", tc.src, err, tc.want)
+		}
+	}
+}
+
+type ram [0x10000]uint8
+
+func (r *ram) Read(addr uint16) uint8       { return r[addr] }
+func (r *ram) Write(addr uint16, val uint8) { r[addr] = val }
+
+// TestRun runs an assembled loop that sums 1 to 10.
+func TestRun(t *testing.T) {
+	code, err := Assemble(`
+		LDA #0
+		LDX #10
+	loop:	STX $10
+		CLC
+		ADC $10
+		DEX
+		BNE loop
+		STA result
+		KIL
+	result:	.byte 0
+	`, 0x0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := mos6502.New(&ram{})
+	c.LoadMem(0x0600, code)
+	c.SetPC(0x0600)
+	for i := 0; i < 100 && !c.Jammed(); i++ {
+		c.Step()
+	}
+	if got := c.Registers().A; got != 55 {
+		t.Errorf("A = %d, wanted 55", got)
+	}
+}
@@ -0,0 +1,284 @@
+package mos6502
+
+import "fmt"
+
+// Break describes a breakpoint or watchpoint being hit.
+type Break struct {
+	PC    uint16 // The instruction that hit it
+	Addr  uint16 // The breakpoint's address, or the watched address accessed
+	Watch bool   // A watchpoint, rather than a breakpoint
+	Write bool   // For watchpoints, whether it was a write or a read
+}
+
+// watch is which accesses to an address trigger a watchpoint.
+type watch struct {
+	read, write bool
+}
+
+// SetBreakHandler sets f to be called when a breakpoint or watchpoint
+// is hit. Breakpoints are reported before their instruction runs:
+// Step returns 0 without running it, and runs it as normal when called
+// again. Watchpoints are reported as the access happens, partway
+// through the instruction, which Step then finishes.
+func (c *CPU) SetBreakHandler(f func(Break)) {
+	c.onBreak = f
+}
+
+// AddBreakpoint adds a breakpoint on the instruction at addr.
+func (c *CPU) AddBreakpoint(addr uint16) {
+	if c.breakpoints == nil {
+		c.breakpoints = make(map[uint16]struct{})
+	}
+	c.breakpoints[addr] = struct{}{}
+}
+
+// RemoveBreakpoint removes the breakpoint at addr, if there is one.
+func (c *CPU) RemoveBreakpoint(addr uint16) {
+	delete(c.breakpoints, addr)
+}
+
+// AddWatchpoint watches the CPU's reads from addr, writes to it, or
+// both. Watching neither removes the watchpoint.
+func (c *CPU) AddWatchpoint(addr uint16, onRead, onWrite bool) {
+	if !onRead && !onWrite {
+		delete(c.watchpoints, addr)
+		return
+	}
+	if c.watchpoints == nil {
+		c.watchpoints = make(map[uint16]watch)
+	}
+	c.watchpoints[addr] = watch{read: onRead, write: onWrite}
+}
+
+// ClearBreakpoints removes all breakpoints and watchpoints.
+func (c *CPU) ClearBreakpoints() {
+	c.breakpoints = nil
+	c.watchpoints = nil
+}
+
+// hitBreakpoint reports the breakpoint at pc, if there is one and it
+// wasn't just reported.
+func (c *CPU) hitBreakpoint() bool {
+	if c.resumed || len(c.breakpoints) == 0 || c.jammed {
+		c.resumed = false
+		return false
+	}
+	if _, ok := c.breakpoints[c.pc]; !ok {
+		return false
+	}
+
+	c.resumed = true
+	c.broke = true
+	if c.onBreak != nil {
+		c.onBreak(Break{PC: c.pc, Addr: c.pc})
+	}
+	return true
+}
+
+// checkWatch reports an access to addr if it's watched.
+func (c *CPU) checkWatch(addr uint16, write bool) {
+	w, ok := c.watchpoints[addr]
+	if !ok || (write && !w.write) || (!write && !w.read) {
+		return
+	}
+	c.broke = true
+	if c.onBreak != nil {
+		c.onBreak(Break{PC: c.instPC, Addr: addr, Watch: true, Write: write})
+	}
+}
+
+// StepInfo describes an instruction for OnBeforeStep and
+// OnAfterStep.
+type StepInfo struct {
+	PC    uint16 // Where the instruction is
+	Name  string // Its mnemonic, like "LDA"
+	Mode  uint8  // Its addressing mode
+	Bytes uint8  // Its length, including operands
+
+	// The registers before the instruction for OnBeforeStep, and
+	// after it for OnAfterStep, along with the cycles it took.
+	Regs   Registers
+	Cycles int
+}
+
+// stepInfo returns the StepInfo for op, the instruction at instPC.
+func (c *CPU) stepInfo(op opcode) StepInfo {
+	return StepInfo{PC: c.instPC, Name: op.name, Mode: op.mode, Bytes: op.bytes, Regs: c.Registers(), Cycles: c.cycles}
+}
+
+// OnBeforeStep sets f to be called before each instruction runs, for
+// tracers and the like. Servicing an interrupt isn't an instruction,
+// so it isn't reported. A nil f removes the hook.
+func (c *CPU) OnBeforeStep(f func(StepInfo)) {
+	c.beforeStep = f
+}
+
+// OnAfterStep sets f to be called after each instruction runs, as
+// for OnBeforeStep.
+func (c *CPU) OnAfterStep(f func(StepInfo)) {
+	c.afterStep = f
+}
+
+// StackWrap describes the stack pointer wrapping around the stack
+// page, which the CPU does silently.
+type StackWrap struct {
+	PC       uint16 // The instruction that wrapped it
+	Overflow bool   // A push wrapped SP from $00 to $FF, rather than a pull from $FF to $00
+}
+
+// OnStackWrap sets f to be called whenever a push or pull wraps the
+// stack pointer, before the access is made, so homebrew developers
+// can catch runaway recursion or an unbalanced PHA and PLA. Setting
+// SP with TXS is never reported. A nil f turns the check off.
+func (c *CPU) OnStackWrap(f func(StackWrap)) {
+	c.onStackWrap = f
+}
+
+// A BusObserver sees every read and write the CPU makes, including
+// dummy accesses, for debuggers, code/data loggers and cheat searches.
+// Accesses by other devices, like the PPU or DMA, aren't seen.
+type BusObserver interface {
+	OnRead(addr uint16, val uint8)
+	OnWrite(addr uint16, val uint8)
+}
+
+// SetBusObserver sets o to see the CPU's bus traffic, or stops
+// reporting it if o is nil.
+func (c *CPU) SetBusObserver(o BusObserver) {
+	c.observer = o
+}
+
+// SetSymbols sets the names Disassemble shows for addresses. A nil s
+// shows them all as numbers.
+func (c *CPU) SetSymbols(s Symbols) {
+	c.symbols = s
+}
+
+// Disassemble returns the instruction at addr in assembler syntax,
+// like "JSR init_ppu", and its length. It reads memory with Peek.
+func (c *CPU) Disassemble(addr uint16) (string, int) {
+	op := opcodes[c.Peek(addr)]
+
+	v := uint16(c.Peek(addr + 1))
+	if op.bytes == 3 {
+		v |= uint16(c.Peek(addr+2)) << 8
+	}
+	target := func() string {
+		if name, ok := c.symbols[v]; ok {
+			return name
+		}
+		if op.bytes == 2 && op.mode != RELATIVE {
+			return fmt.Sprintf("$%02X", v)
+		}
+		return fmt.Sprintf("$%04X", v)
+	}
+
+	var arg string
+	switch op.mode {
+	case ACCUMULATOR:
+		arg = "A"
+	case IMMEDIATE:
+		arg = fmt.Sprintf("#$%02X", v)
+	case RELATIVE:
+		v = addr + 2 + uint16(int8(v))
+		arg = target()
+	case ZERO_PAGE, ABSOLUTE:
+		arg = target()
+	case ZERO_PAGE_X, ABSOLUTE_X:
+		arg = target() + ",X"
+	case ZERO_PAGE_Y, ZERO_PAGE_X_BUT_Y, ABSOLUTE_Y:
+		arg = target() + ",Y"
+	case INDIRECT:
+		arg = "(" + target() + ")"
+	case INDIRECT_X:
+		arg = "(" + target() + ",X)"
+	case INDIRECT_Y:
+		arg = "(" + target() + "),Y"
+	}
+	if arg == "" {
+		return op.name, int(op.bytes)
+	}
+	return op.name + " " + arg, int(op.bytes)
+}
+
+// OperandInfo describes what an instruction would work on, for
+// debuggers annotating disassembly like "LDA $2002 = #$80".
+type OperandInfo struct {
+	HasAddr     bool   // The instruction uses memory, not just registers or an immediate
+	Addr        uint16 // Its effective address: for branches and jumps, the target
+	Value       uint8  // The byte at Addr, the immediate, or A for accumulator mode
+	PageCrossed bool   // Indexing or a branch crossed a page, costing a cycle
+}
+
+// OperandInfo works out the operand of the instruction at addr from
+// the current registers and memory, without running it or disturbing
+// anything. It's only right for the instruction at PC, as the
+// registers can change before any other runs.
+func (c *CPU) OperandInfo(addr uint16) OperandInfo {
+	op := opcodes[c.Peek(addr)]
+	b := c.Peek(addr + 1)
+	w := uint16(b) | uint16(c.Peek(addr+2))<<8
+	peek16 := func(a uint16, zp bool) uint16 {
+		next := a + 1
+		if zp {
+			next &= 0x00FF
+		}
+		return uint16(c.Peek(a)) | uint16(c.Peek(next))<<8
+	}
+
+	oi := OperandInfo{HasAddr: true}
+	var base uint16
+	switch op.mode {
+	case IMPLICIT:
+		return OperandInfo{}
+	case ACCUMULATOR:
+		return OperandInfo{Value: c.acc}
+	case IMMEDIATE:
+		return OperandInfo{Value: b}
+	case ZERO_PAGE:
+		oi.Addr = uint16(b)
+	case ZERO_PAGE_X:
+		oi.Addr = uint16(b + c.x)
+	case ZERO_PAGE_Y, ZERO_PAGE_X_BUT_Y:
+		oi.Addr = uint16(b + c.y)
+	case ABSOLUTE:
+		oi.Addr = w
+	case ABSOLUTE_X:
+		base, oi.Addr = w, w+uint16(c.x)
+	case ABSOLUTE_Y:
+		base, oi.Addr = w, w+uint16(c.y)
+	case INDIRECT:
+		oi.Addr = peek16(w, false)
+	case INDIRECT_X:
+		oi.Addr = peek16(uint16(b+c.x), true)
+	case INDIRECT_Y:
+		base = peek16(uint16(b), true)
+		oi.Addr = base + uint16(c.y)
+	case RELATIVE:
+		// The page is compared with the branch's own, as branch
+		// does.
+		base, oi.Addr = addr, addr+2+uint16(int8(b))
+	}
+	switch op.mode {
+	case ABSOLUTE_X, ABSOLUTE_Y, INDIRECT_Y, RELATIVE:
+		oi.PageCrossed = extraCycles(base, oi.Addr) == 1
+	}
+	oi.Value = c.Peek(oi.Addr)
+	return oi
+}
+
+// Snapshot is a copy of the CPU's state, for debuggers to keep or
+// hand to another goroutine.
+type Snapshot struct {
+	Registers
+	Cycles uint64 // see Cycles
+	Inst   string // the instruction at PC; see Inst
+	Jammed bool
+}
+
+// Snapshot copies the CPU's state. Like everything else about the
+// CPU, it mustn't be called while another goroutine is running it;
+// core.Console.CPUSnapshot takes care of that.
+func (c *CPU) Snapshot() Snapshot {
+	return Snapshot{Registers: c.Registers(), Cycles: c.total, Inst: c.Inst(), Jammed: c.jammed}
+}
@@ -0,0 +1,241 @@
+package mos6502
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBreakpoints(t *testing.T) {
+	c := New(NewMem())
+	c.LoadMem(0x0600, []uint8{
+		0xA9, 0x10, // LDA #$10
+		0x8D, 0x00, 0x02, // STA $0200
+		0xAD, 0x00, 0x02, // LDA $0200
+	})
+	c.SetPC(0x0600)
+
+	var got []Break
+	c.SetBreakHandler(func(b Break) { got = append(got, b) })
+	c.AddBreakpoint(0x0602)
+	c.AddWatchpoint(0x0200, true, false)
+
+	c.Step()
+	if n := c.Step(); n != 0 || c.PC() != 0x0602 {
+		t.Fatalf("At breakpoint Step() = %d, PC = 0x%04x; wanted 0, 0x0602", n, c.PC())
+	}
+	if c.Step(); c.PC() != 0x0605 {
+		t.Fatalf("Step() after breakpoint left PC = 0x%04x, wanted 0x0605", c.PC())
+	}
+	c.Step()
+
+	want := []Break{
+		{PC: 0x0602, Addr: 0x0602},
+		{PC: 0x0605, Addr: 0x0200, Watch: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Got breaks %+v, wanted %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%d: Got %+v, wanted %+v", i, got[i], want[i])
+		}
+	}
+
+	got = nil
+	c.ClearBreakpoints()
+	c.SetPC(0x0600)
+	for i := 0; i < 3; i++ {
+		c.Step()
+	}
+	if len(got) != 0 {
+		t.Errorf("Got breaks %+v after ClearBreakpoints()", got)
+	}
+}
+
+func TestStepHooks(t *testing.T) {
+	c := New(NewMem())
+	c.LoadMem(0x0600, []uint8{
+		0xA9, 0x10, // LDA #$10
+		0x8D, 0x00, 0x02, // STA $0200
+	})
+	c.SetPC(0x0600)
+
+	var before, after []StepInfo
+	c.OnBeforeStep(func(s StepInfo) { before = append(before, s) })
+	c.OnAfterStep(func(s StepInfo) { after = append(after, s) })
+	c.Step()
+	c.Step()
+
+	if len(before) != 2 || len(after) != 2 {
+		t.Fatalf("Got %d before and %d after hooks, wanted 2 each", len(before), len(after))
+	}
+	if b := before[0]; b.PC != 0x0600 || b.Name != "LDA" || b.Mode != IMMEDIATE || b.Bytes != 2 || b.Regs.A != 0 {
+		t.Errorf("Before LDA got %+v", b)
+	}
+	if a := after[0]; a.PC != 0x0600 || a.Regs.A != 0x10 || a.Regs.PC != 0x0602 || a.Cycles != 2 {
+		t.Errorf("After LDA got %+v", a)
+	}
+	if a := after[1]; a.PC != 0x0602 || a.Name != "STA" || a.Mode != ABSOLUTE || a.Cycles != 4 {
+		t.Errorf("After STA got %+v", a)
+	}
+
+	c.OnBeforeStep(nil)
+	c.OnAfterStep(nil)
+	c.SetPC(0x0600)
+	if c.Step(); len(before) != 2 || len(after) != 2 {
+		t.Errorf("Hooks still called after they were removed")
+	}
+}
+
+// busLog is a BusObserver that records what it sees.
+type busLog []string
+
+func (l *busLog) OnRead(addr uint16, val uint8) {
+	*l = append(*l, fmt.Sprintf("R %04X %02X", addr, val))
+}
+
+func (l *busLog) OnWrite(addr uint16, val uint8) {
+	*l = append(*l, fmt.Sprintf("W %04X %02X", addr, val))
+}
+
+func TestBusObserver(t *testing.T) {
+	c := New(NewMem())
+	c.LoadMem(0x0600, []uint8{0xE6, 0x10}) // INC $10
+	c.SetPC(0x0600)
+
+	var l busLog
+	c.SetBusObserver(&l)
+	c.Step()
+
+	want := []string{"R 0600 E6", "R 0601 10", "R 0010 00", "W 0010 00", "W 0010 01"}
+	if fmt.Sprint(l) != fmt.Sprint(want) {
+		t.Errorf("Saw %q, wanted %q", l, want)
+	}
+
+	c.SetBusObserver(nil)
+	c.SetPC(0x0600)
+	if c.Step(); len(l) != len(want) {
+		t.Errorf("Observer still called after it was removed")
+	}
+}
+
+func TestStackWrap(t *testing.T) {
+	c := New(NewMem())
+	c.LoadMem(0x0600, []uint8{
+		0xA2, 0x00, // LDX #$00
+		0x9A, // TXS
+		0x48, // PHA
+		0x68, // PLA
+		0x68, // PLA
+	})
+	c.SetPC(0x0600)
+
+	var wraps []StackWrap
+	c.OnStackWrap(func(w StackWrap) { wraps = append(wraps, w) })
+	for i := 0; i < 5; i++ {
+		c.Step()
+	}
+
+	want := []StackWrap{{PC: 0x0603, Overflow: true}, {PC: 0x0604}}
+	if fmt.Sprint(wraps) != fmt.Sprint(want) {
+		t.Errorf("Got wraps %v, wanted %v", wraps, want)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	c := New(NewMem())
+	c.LoadMem(0x0600, []uint8{
+		0x20, 0x00, 0x80, // JSR $8000
+		0xA9, 0x10, // LDA #$10
+		0x0A,       // ASL A
+		0xB5, 0x20, // LDA $20,X
+		0xD0, 0xF6, // BNE $0600
+		0xB1, 0x30, // LDA ($30),Y
+		0x8D, 0x00, 0x20, // STA $2000
+		0x18, // CLC
+	})
+	c.SetSymbols(Symbols{0x8000: "init_ppu", 0x0600: "start", 0x2000: "PPUCTRL"})
+
+	want := []string{"JSR init_ppu", "LDA #$10", "ASL A", "LDA $20,X", "BNE start", "LDA ($30),Y", "STA PPUCTRL", "CLC"}
+	addr := uint16(0x0600)
+	for _, w := range want {
+		got, n := c.Disassemble(addr)
+		if got != w {
+			t.Errorf("Disassemble(0x%04x) = %q, wanted %q", addr, got, w)
+		}
+		addr += uint16(n)
+	}
+
+	c.SetSymbols(nil)
+	if got, _ := c.Disassemble(0x0600); got != "JSR $8000" {
+		t.Errorf("Without symbols, Disassemble() = %q, wanted %q", got, "JSR $8000")
+	}
+}
+
+// peekMem is memory that counts reads, and can be peeked.
+type peekMem struct {
+	mem
+	reads int
+}
+
+func (m *peekMem) Read(addr uint16) uint8 {
+	m.reads++
+	return m.mem.Read(addr)
+}
+
+func (m *peekMem) Peek(addr uint16) uint8 {
+	return m.mem.Read(addr)
+}
+
+func TestPeek(t *testing.T) {
+	m := &peekMem{mem: *NewMem()}
+	c := New(m)
+	c.LoadMem(0x0600, []uint8{0xAD, 0x02, 0x20}) // LDA $2002
+	c.SetPC(0x0600)
+
+	m.reads = 0
+	c.Disassemble(0x0600)
+	c.Inst()
+	c.Trace()
+	if c.Peek(0x0601) != 0x02 || m.reads != 0 {
+		t.Errorf("Debugging made %d reads, wanted none", m.reads)
+	}
+
+	if c.Step(); m.reads != 4 {
+		t.Errorf("LDA made %d reads, wanted 4", m.reads)
+	}
+}
+
+func TestOperandInfo(t *testing.T) {
+	c := New(NewMem())
+	c.x, c.y, c.acc = 0x01, 0x10, 0x33
+	c.LoadMem(0x0010, []uint8{0xF8, 0x02}) // ($10) = $02F8
+	c.LoadMem(0x0300, []uint8{0x42, 0x43}) // data
+	c.LoadMem(0x0208, []uint8{0x99})
+	c.LoadMem(0x0012, []uint8{0x00, 0x03}) // ($11+1) = $0300
+
+	cases := []struct {
+		prog []uint8
+		want OperandInfo
+	}{
+		{[]uint8{0xEA}, OperandInfo{}},                                      // NOP
+		{[]uint8{0x0A}, OperandInfo{Value: 0x33}},                           // ASL A
+		{[]uint8{0xA9, 0x80}, OperandInfo{Value: 0x80}},                     // LDA #$80
+		{[]uint8{0xAD, 0x00, 0x03}, OperandInfo{true, 0x0300, 0x42, false}}, // LDA $0300
+		{[]uint8{0xBD, 0x00, 0x03}, OperandInfo{true, 0x0301, 0x43, false}}, // LDA $0300,X
+		{[]uint8{0xB9, 0xF8, 0x02}, OperandInfo{true, 0x0308, 0x00, true}},  // LDA $02F8,Y
+		{[]uint8{0xB5, 0xFF}, OperandInfo{true, 0x0000, 0x00, false}},       // LDA $FF,X wraps
+		{[]uint8{0xA1, 0x11}, OperandInfo{true, 0x0300, 0x42, false}},       // LDA ($11,X)
+		{[]uint8{0xB1, 0x10}, OperandInfo{true, 0x0308, 0x00, true}},        // LDA ($10),Y
+		{[]uint8{0x6C, 0x10, 0x00}, OperandInfo{true, 0x02F8, 0x00, false}}, // JMP ($0010)
+		{[]uint8{0xD0, 0x04}, OperandInfo{true, 0x0606, 0x00, false}},       // BNE +4
+		{[]uint8{0xD0, 0x80}, OperandInfo{true, 0x0582, 0x00, true}},        // BNE -128
+	}
+
+	for _, tc := range cases {
+		c.LoadMem(0x0600, tc.prog)
+		if got := c.OperandInfo(0x0600); got != tc.want {
+			t.Errorf("% X: OperandInfo() = %+v, wanted %+v", tc.prog, got, tc.want)
+		}
+	}
+}
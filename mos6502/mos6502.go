@@ -5,8 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"reflect"
 	"strings"
+
+	"github.com/bdwalton/gintendo/state"
 )
 
 const (
@@ -24,6 +25,16 @@ const (
 	INT_NMI   = 0xFFFA
 )
 
+// IRQ line sources. The 6502 IRQ input is level triggered and shared
+// (wired-OR) by every device that can raise it, so the line stays
+// asserted until all sources have released it.
+const (
+	IRQ_SOURCE_MAPPER = 1 << iota
+	IRQ_SOURCE_APU_FRAME
+	IRQ_SOURCE_APU_DMC
+	IRQ_SOURCE_FEED // See SetInterruptFeed
+)
+
 // 6502 Processor Status Flags
 // https://www.nesdev.org/obelisk-6502-guide/registers.html
 const (
@@ -79,6 +90,23 @@ type Bus interface {
 	Write(uint16, uint8)
 }
 
+// PeekableBus is a Bus that can be read without side effects, like
+// clearing vblank when PPUSTATUS is read. The CPU's debugging methods,
+// such as Disassemble and Inst, Peek rather than Read when they can.
+type PeekableBus interface {
+	Bus
+	Peek(uint16) uint8
+}
+
+// Peek returns the byte at addr, without side effects if the CPU's bus
+// is a PeekableBus. It isn't seen by watchpoints or a BusObserver.
+func (c *CPU) Peek(addr uint16) uint8 {
+	if pb, ok := c.mem.(PeekableBus); ok {
+		return pb.Peek(addr)
+	}
+	return c.mem.Read(addr)
+}
+
 // Type CPU implements all of the machine state for the 6502
 type CPU struct {
 	acc              uint8  // main register
@@ -88,12 +116,91 @@ type CPU struct {
 	pc               uint16 // the program counter
 	mem              Bus    // 64k addressable memory, often backed by a mapper.
 	cycles           int    // how many cycles an instruction consumes
+	total            uint64 // cycles run since power on; see Cycles
 	pendingInterrupt int    // 0/INTERRUPT_NONE, INTERRUPT_NMI or INTERRUPT_IRQ
 	nmiTriggered     bool   // Set when NMI was triggered so we know to account for cycles
+	irqLine          uint8  // IRQ_SOURCE_XXX bits for devices holding IRQ asserted
+	jammed           bool   // A KIL instruction halted the CPU until reset
+	iDelayed         bool   // The last instruction changed I too late for IRQ polling to see
+	noDecimal        bool   // The 2A03, which ignores the D flag
+	dataBus          uint8  // The last value on the data bus; see DataBus
+
+	// RDY line; see Stall and DMA.
+	stall          int  // cycles to hold the CPU after this instruction
+	oamDMA, dmcDMA bool // DMA units waiting to halt the CPU
+	lastWrite      bool // the last bus access was a write
+
+	// In cycle mode (see SetCycleClock), clock is run for every
+	// cycle of an instruction while clocking, and spent counts
+	// those cycles.
+	clock    func()
+	clocking bool
+	spent    int
+
+	// Debugging; see SetBreakHandler.
+	breakpoints map[uint16]struct{}
+	watchpoints map[uint16]watch
+	onBreak     func(Break)
+	instPC      uint16 // where the running instruction started
+	resumed     bool   // a breakpoint was reported at pc and should be run through
+	broke       bool   // a breakpoint or watchpoint was hit; see RunUntil
+
+	// Interrupt timing; see SetInterruptTiming. In cycle mode the
+	// interrupt lines are polled before every cycle, and the polls
+	// before the last two cycles of an instruction are kept.
+	interruptTiming  uint8 // an INTERRUPTS_XXX value
+	polled, polledBy uint8 // POLL_XXX bits before the last cycle, and the one before
+	pollValid        bool  // polled is from the last instruction, in cycle mode
+	branchQuirk      bool  // the instruction was a taken branch that stayed on its page
+	afterInterrupt   bool  // an interrupt was just serviced; its handler's first instruction runs next
+
+	invalidPolicy uint8          // an INVALID_XXX value
+	halts         map[uint8]bool // see SetHaltOpcodes
+
+	beforeStep, afterStep func(StepInfo) // see OnBeforeStep and OnAfterStep
+	observer              BusObserver
+	onStackWrap           func(StackWrap)
+	symbols               Symbols
+	profile               *Profile // see SetProfiling
+
+	// See SetInterruptFeed.
+	feeding  bool
+	feedAddr uint16
+	feedLast uint8
 }
 
 func (c *CPU) String() string {
-	return fmt.Sprintf("A,X,Y: 0x%02x, 0x%02x, 0x%02x; PC: 0x%04x, SP: 0x%02x, P: %s; OP: %s", c.acc, c.x, c.y, c.pc, c.sp, statusString(c.status), opcodes[c.mem.Read(c.pc)])
+	b := make([]byte, 0, 96)
+	b = append(b, "A,X,Y: 0x"...)
+	b = appendHex(b, uint16(c.acc), 2, hexLower)
+	b = append(b, ", 0x"...)
+	b = appendHex(b, uint16(c.x), 2, hexLower)
+	b = append(b, ", 0x"...)
+	b = appendHex(b, uint16(c.y), 2, hexLower)
+	b = append(b, "; PC: 0x"...)
+	b = appendHex(b, c.pc, 4, hexLower)
+	b = append(b, ", SP: 0x"...)
+	b = appendHex(b, uint16(c.sp), 2, hexLower)
+	b = append(b, ", P: "...)
+	b = append(b, statusString(c.status)...)
+	b = append(b, "; OP: "...)
+	b = append(b, opcodes[c.Peek(c.pc)].String()...)
+	return string(b)
+}
+
+const (
+	hexLower = "0123456789abcdef"
+	hexUpper = "0123456789ABCDEF"
+)
+
+// appendHex appends v to b as digits hex digits, taken from the
+// hexLower or hexUpper set. It's cheaper than fmt for the trace and
+// debug output, which can be produced for every instruction.
+func appendHex(b []byte, v uint16, digits int, set string) []byte {
+	for i := digits - 1; i >= 0; i-- {
+		b = append(b, set[v>>(4*i)&0xF])
+	}
+	return b
 }
 
 func New(b Bus) *CPU {
@@ -110,24 +217,99 @@ func New(b Bus) *CPU {
 	return c
 }
 
-var invalidInstruction = errors.New("invalid instruction")
+// New2A03 returns the NES's CPU, the Ricoh 2A03. It's a 6502 with
+// decimal mode cut out: the D flag can still be set and cleared, and
+// is pushed with the status, but ADC and SBC always work in binary.
+func New2A03(b Bus) *CPU {
+	c := New(b)
+	c.noDecimal = true
+	return c
+}
+
+// ErrInvalidInstruction is returned by StepE for an instruction the
+// CPU won't run (see SetInvalidPolicy).
+var ErrInvalidInstruction = errors.New("invalid instruction")
+
+// ErrJammed is returned by StepE once the CPU has jammed.
+var ErrJammed = errors.New("CPU jammed")
+
+// ErrHalted is returned by StepE for an opcode set with SetHaltOpcodes.
+var ErrHalted = errors.New("CPU halted")
 
+// What to do with the KIL instructions, which lock up the real CPU
+// until it's reset. Every other opcode does something.
+const (
+	INVALID_JAM   = iota // Jam, like the real CPU
+	INVALID_NOP          // Skip them, as a one byte NOP
+	INVALID_ERROR        // Don't run them: StepE returns ErrInvalidInstruction
+)
+
+// SetInvalidPolicy sets what the CPU does when it meets a KIL
+// instruction, one of the INVALID_XXX constants. A game that runs
+// one has usually crashed, so an embedder may rather hear about it
+// than sit on a frozen picture.
+func (c *CPU) SetInvalidPolicy(policy uint8) {
+	c.invalidPolicy = policy
+}
+
+// SetHaltOpcodes makes the CPU stop before running any of ops, for
+// test ROM runners that signal they're done with BRK (0x00) or some
+// other instruction. StepE returns ErrHalted, leaving PC on the
+// instruction, without running it, and does so again each time it's
+// called until PC moves. Step doesn't panic on it. With no ops,
+// everything runs again.
+func (c *CPU) SetHaltOpcodes(ops ...uint8) {
+	c.halts = nil
+	for _, op := range ops {
+		if c.halts == nil {
+			c.halts = make(map[uint8]bool)
+		}
+		c.halts[op] = true
+	}
+}
+
+// getInst fetches the instruction at PC. When it might not run it,
+// it decodes from a peek first, so that a refused instruction costs
+// no bus cycle, in cycle mode or not.
 func (c *CPU) getInst() (opcode, error) {
-	m := c.mem.Read(c.pc)
+	refusable := c.halts != nil || c.invalidPolicy == INVALID_ERROR
+	var m uint8
+	if refusable {
+		m = c.Peek(c.pc)
+	} else {
+		m = c.read(c.pc)
+	}
+	if c.halts != nil && c.halts[m] {
+		return opcode{}, fmt.Errorf("pc: 0x%04x, inst: 0x%02x - %w", c.pc, m, ErrHalted)
+	}
 	op, ok := opcodes[m]
+	if ok && op.inst == KIL {
+		switch c.invalidPolicy {
+		case INVALID_NOP:
+			op = opcode{NOP, "NOP", IMPLICIT, 1, 2}
+		case INVALID_ERROR:
+			ok = false
+		}
+	}
 	if !ok {
-		return opcode{}, fmt.Errorf("pc: 0x%04x, inst: 0x%02x - %w", c.pc, m, invalidInstruction)
+		return opcode{}, fmt.Errorf("pc: 0x%04x, inst: 0x%02x - %w", c.pc, m, ErrInvalidInstruction)
+	}
+	if refusable {
+		c.read(c.pc)
 	}
 
+	if c.profile != nil {
+		c.profile.count(m, c.pc)
+	}
 	return op, nil
 }
 
 // memRange returns a slice of memory addresses from low to
 // high. Mostly useful for debugging.
 func (c *CPU) memRange(low, high uint16) []uint8 {
-	ret := make([]uint8, high-low)
-	for i := low; i <= high; i += 1 {
-		ret = append(ret, c.mem.Read(uint16(i)))
+	ret := make([]uint8, 0, int(high)-int(low)+1)
+	for i := int(low); i <= int(high); i++ {
+		ret = append(ret, c.Peek(uint16(i)))
 	}
 
 	return ret
@@ -137,7 +319,7 @@ func (c *CPU) memRange(low, high uint16) []uint8 {
 // first). The mode parameter helps handle wrapping cases in certain
 // usecases.
 func (c *CPU) Read16(addr uint16, mode uint8) uint16 {
-	lsb := uint16(c.mem.Read(addr))
+	lsb := uint16(c.read(addr))
 
 	addr++
 
@@ -145,7 +327,7 @@ func (c *CPU) Read16(addr uint16, mode uint8) uint16 {
 		addr &= 0x00FF
 	}
 
-	msb := uint16(c.mem.Read(addr))
+	msb := uint16(c.read(addr))
 
 	return (msb << 8) | lsb
 }
@@ -154,6 +336,47 @@ func (c *CPU) Read16(addr uint16, mode uint8) uint16 {
 // referenced by the program counter. It assumes that the counter was
 // incremented past the actual instruction itself.
 func (c *CPU) getOperandAddr(mode uint8) uint16 {
+	return c.operandAddr(mode, false)
+}
+
+// storeAddr is getOperandAddr for instructions that write to the
+// operand, including read-modify-write ones. They always take their
+// worst case time, so never pay extra for indexing across a page, but
+// always make the dummy read that goes with it.
+func (c *CPU) storeAddr(mode uint8) uint16 {
+	return c.operandAddr(mode, true)
+}
+
+// rmwRead returns the operand address and value for a
+// read-modify-write instruction, having written the value straight
+// back unchanged, as the real CPU does while it works out the new
+// one.
+func (c *CPU) rmwRead(mode uint8) (uint16, uint8) {
+	addr := c.storeAddr(mode)
+	v := c.read(addr)
+	c.write(addr, v)
+	return addr, v
+}
+
+// indexed returns base plus index. Like the real CPU, it first reads
+// from base with only the low byte indexed, which is the right address
+// unless the page was crossed. Reads only make that first access, and
+// pay a cycle for it, when it was wrong; stores always do.
+func (c *CPU) indexed(base uint16, index uint8, store bool) uint16 {
+	addr := base + uint16(index)
+	crossed := extraCycles(base, addr) != 0
+	if crossed || store {
+		c.read(base&0xFF00 | addr&0x00FF)
+	}
+	if crossed && !store {
+		c.cycles++
+	}
+	return addr
+}
+
+// operandAddr is getOperandAddr, with the timing and dummy reads of
+// stores if store is set.
+func (c *CPU) operandAddr(mode uint8, store bool) uint16 {
 	var addr uint16
 	switch mode {
 	case ACCUMULATOR:
@@ -163,36 +386,36 @@ func (c *CPU) getOperandAddr(mode uint8) uint16 {
 	case IMMEDIATE:
 		addr = c.pc
 	case ZERO_PAGE:
-		addr = uint16(c.mem.Read(c.pc))
+		addr = uint16(c.read(c.pc))
 	case ZERO_PAGE_X:
-		return uint16(c.mem.Read(c.pc) + c.x)
+		zp := c.read(c.pc)
+		c.read(uint16(zp)) // while X is added
+		return uint16(zp + c.x)
 	case ZERO_PAGE_Y, ZERO_PAGE_X_BUT_Y:
-		return uint16(c.mem.Read(c.pc) + c.y)
+		zp := c.read(c.pc)
+		c.read(uint16(zp)) // while Y is added
+		return uint16(zp + c.y)
 	case ABSOLUTE:
 		return c.Read16(c.pc, mode)
 	case ABSOLUTE_X:
-		a := c.Read16(c.pc, mode)
-		addr = a + uint16(c.x)
-		c.cycles += extraCycles(a, addr)
+		addr = c.indexed(c.Read16(c.pc, mode), c.x, store)
 	case ABSOLUTE_Y:
-		a := c.Read16(c.pc, mode)
-		addr = a + uint16(c.y)
-		c.cycles += extraCycles(a, addr)
+		addr = c.indexed(c.Read16(c.pc, mode), c.y, store)
 	case INDIRECT:
 		return c.Read16(c.Read16(c.pc, mode), mode)
 	case INDIRECT_X:
-		return c.Read16(uint16(c.mem.Read(c.pc)+c.x), mode)
+		zp := c.read(c.pc)
+		c.read(uint16(zp)) // while X is added
+		return c.Read16(uint16(zp+c.x), mode)
 	case INDIRECT_Y:
-		a := c.Read16(uint16(c.mem.Read(c.pc)), mode)
-		addr = a + uint16(c.y)
-		c.cycles += extraCycles(a, addr)
+		addr = c.indexed(c.Read16(uint16(c.read(c.pc)), mode), c.y, store)
 	case RELATIVE:
 		// Relative from PC at time of instruction
 		// execution. We advance pc as soon as we eat the byte
 		// from memory to decode the instruction, so we need
 		// to account for that here and step over the relative
 		// argument while calculating the new target address.
-		addr = (c.pc + 1) + uint16(int8(c.mem.Read(c.pc)))
+		addr = (c.pc + 1) + uint16(int8(c.read(c.pc)))
 	default:
 		panic("Invalid addressing mode")
 	}
@@ -202,8 +425,8 @@ func (c *CPU) getOperandAddr(mode uint8) uint16 {
 
 // Write16 stores val at addr (lower byte is first).
 func (c *CPU) Write16(addr, val uint16) {
-	c.mem.Write(addr, uint8(val&0x00FF))
-	c.mem.Write(addr+1, uint8(val>>8))
+	c.write(addr, uint8(val&0x00FF))
+	c.write(addr+1, uint8(val>>8))
 }
 
 func (c *CPU) TriggerNMI() {
@@ -211,15 +434,190 @@ func (c *CPU) TriggerNMI() {
 }
 
 func (c *CPU) TriggerIRQ() {
-	if c.status&STATUS_FLAG_INTERRUPT_DISABLE == 0 {
+	if !c.irqsDisabled() {
 		c.pendingInterrupt = INT_IRQ
 	}
 }
 
-func (c *CPU) AddDMACycles() {
-	// TODO: Handle the extra cycle that might occur depending on
-	// timing of when the DMA call is triggered.
-	c.cycles += 513
+// SetIRQ asserts or releases the IRQ line on behalf of source (an
+// IRQ_SOURCE_XXX value). The CPU services the IRQ at the next
+// instruction boundary where the line is asserted and interrupts
+// aren't disabled.
+func (c *CPU) SetIRQ(source uint8, asserted bool) {
+	if asserted {
+		c.irqLine |= source
+	} else {
+		c.irqLine &^= source
+	}
+}
+
+// SetInterruptFeed makes writes to addr drive the interrupt lines, as
+// the feedback port of Klaus Dormann's 6502_interrupt_test expects:
+// bit 0 holds IRQ asserted while it's set, and setting bit 1 triggers
+// an NMI. Writes still reach the bus, so the port reads back. If on is
+// false, the port is removed and any IRQ it held is released.
+func (c *CPU) SetInterruptFeed(addr uint16, on bool) {
+	c.feeding, c.feedAddr, c.feedLast = on, addr, 0
+	c.SetIRQ(IRQ_SOURCE_FEED, false)
+}
+
+// feed drives the interrupt lines from val, written to the feed port.
+func (c *CPU) feed(val uint8) {
+	c.SetIRQ(IRQ_SOURCE_FEED, val&0x01 != 0)
+	if val&0x02 != 0 && c.feedLast&0x02 == 0 {
+		c.TriggerNMI()
+	}
+	c.feedLast = val
+}
+
+// Interrupt timing models, for SetInterruptTiming.
+const (
+	// As the NMOS 6502 and the 2A03 do it, the default. Servicing
+	// an IRQ or NMI takes 7 cycles, and the first instruction of the
+	// handler always runs before another interrupt is taken. In
+	// cycle mode (see SetCycleClock) the lines are polled before the
+	// last cycle of each instruction, so an interrupt raised during
+	// that cycle waits for the next instruction, and a taken branch
+	// that doesn't cross a page doesn't poll again on its extra
+	// cycle. Without cycle mode, the lines are checked between
+	// instructions.
+	INTERRUPTS_NMOS = iota
+
+	// As gintendo used to: the lines are checked between
+	// instructions, an IRQ takes 8 cycles and an NMI 7. For
+	// recordings made with older versions.
+	INTERRUPTS_SIMPLE
+)
+
+// What polling the interrupt lines saw.
+const (
+	POLL_IRQ = 1 << iota // The IRQ line was asserted
+	POLL_NMI             // An NMI was pending
+)
+
+// SetInterruptTiming sets how interrupts are recognised and how long
+// they take to service, one of the INTERRUPTS_XXX models.
+func (c *CPU) SetInterruptTiming(model uint8) {
+	c.interruptTiming = model
+	c.pollValid = false
+	c.afterInterrupt = false
+}
+
+// poll samples the interrupt lines, as the CPU does before each
+// cycle in cycle mode.
+func (c *CPU) poll() {
+	var p uint8
+	if c.irqLine != 0 {
+		p |= POLL_IRQ
+	}
+	if c.pendingInterrupt == INT_NMI {
+		p |= POLL_NMI
+	}
+	c.polledBy, c.polled = c.polled, p
+}
+
+// interruptLines returns the POLL_XXX bits that decide whether an
+// interrupt is taken before the next instruction.
+func (c *CPU) interruptLines() uint8 {
+	if c.interruptTiming == INTERRUPTS_NMOS && c.pollValid {
+		if c.branchQuirk {
+			return c.polledBy
+		}
+		return c.polled
+	}
+	var p uint8
+	if c.IRQAsserted() {
+		p |= POLL_IRQ
+	}
+	if c.pendingInterrupt == INT_NMI {
+		p |= POLL_NMI
+	}
+	return p
+}
+
+// irqsDisabled returns true if the I flag, as IRQ polling sees it,
+// is set. The real CPU polls for interrupts before the last cycle of
+// each instruction, which is before CLI, SEI and PLP change the flag,
+// so their change only counts from the end of the next instruction.
+func (c *CPU) irqsDisabled() bool {
+	return (c.status&STATUS_FLAG_INTERRUPT_DISABLE != 0) != c.iDelayed
+}
+
+// IRQAsserted returns true if any source is holding the IRQ line.
+func (c *CPU) IRQAsserted() bool {
+	return c.irqLine != 0
+}
+
+// The DMA units that can halt the CPU (see DMA).
+const (
+	DMA_OAM = iota // OAM DMA, copying a page to the PPU's sprite memory
+	DMA_DMC        // The APU's DMC fetching a sample byte
+)
+
+// Stall holds the CPU's RDY line low for cycles CPU cycles, once the
+// current instruction finishes, or before the next one if it's called
+// between instructions. Step's count includes them, and in cycle mode
+// they're clocked like any other.
+func (c *CPU) Stall(cycles int) {
+	c.stall += cycles
+}
+
+// DMA halts the CPU for a transfer by unit, a DMA_XXX value, with
+// the length the 2A03 gives it. OAM DMA takes 513 cycles, or 514
+// when it starts on an odd cycle and has to wait to line up with a
+// read. A DMC fetch takes 4 cycles, 3 if it lands on a write, which
+// holds off the halt, or 2 while OAM DMA already has the bus.
+//
+// The CPU only halts between instructions, so the halt is late by
+// whatever is left of the current one.
+func (c *CPU) DMA(unit uint8) {
+	switch unit {
+	case DMA_OAM:
+		c.oamDMA = true
+	case DMA_DMC:
+		c.dmcDMA = true
+	}
+}
+
+// stalled returns the cycles the CPU is halted for after an
+// instruction of n cycles, and clears the requests.
+func (c *CPU) stalled(n int) int {
+	s := c.stall
+	if c.oamDMA {
+		if (c.total+uint64(n+s))%2 == 1 { // The halt cycle is odd
+			s++
+		}
+		s += 513
+	}
+	if c.dmcDMA {
+		switch {
+		case c.oamDMA:
+			s += 2
+		case c.lastWrite:
+			s += 3
+		default:
+			s += 4
+		}
+	}
+	c.stall, c.oamDMA, c.dmcDMA = 0, false, false
+	return s
+}
+
+// endStep adds any stall to the n cycles an instruction took, and
+// counts them.
+func (c *CPU) endStep(n int) int {
+	n += c.stalled(n)
+	c.cycles = n
+	c.total += uint64(n)
+	return n
+}
+
+// Cycles returns the number of cycles the CPU has run since it was
+// made, including stalls. It's counted a whole instruction at a time,
+// when Step runs it, so with Tick it runs ahead by the cycles still
+// owed for the last instruction. It isn't reset by Reset.
+func (c *CPU) Cycles() uint64 {
+	return c.total
 }
 
 func (c *CPU) Reset() {
@@ -227,6 +625,54 @@ func (c *CPU) Reset() {
 	c.flagsOn(STATUS_FLAG_INTERRUPT_DISABLE | UNUSED_STATUS_FLAG)
 	c.pc = c.Read16(INT_RESET, ABSOLUTE)
 	c.cycles = 0
+	c.jammed = false
+	c.iDelayed = false
+	c.pollValid = false
+	c.afterInterrupt = false
+}
+
+// Jammed returns true if the CPU ran a KIL instruction and is halted
+// until the next reset.
+func (c *CPU) Jammed() bool {
+	return c.jammed
+}
+
+// State saves or loads the CPU's registers and interrupt lines.
+func (c *CPU) State(s *state.Codec) {
+	s.Tag("CPU ")
+	s.Uint8(&c.acc)
+	s.Uint8(&c.x)
+	s.Uint8(&c.y)
+	s.Uint8(&c.status)
+	s.Uint8(&c.sp)
+	s.Uint16(&c.pc)
+	s.Int(&c.cycles)
+	s.Int(&c.pendingInterrupt)
+	s.Bool(&c.nmiTriggered)
+	s.Uint8(&c.irqLine)
+	s.Bool(&c.jammed)
+	s.Bool(&c.iDelayed)
+	s.Int(&c.stall)
+	s.Bool(&c.oamDMA)
+	s.Bool(&c.dmcDMA)
+	s.Uint64(&c.total)
+	s.Bool(&c.lastWrite)
+	s.Uint8(&c.polled)
+	s.Uint8(&c.polledBy)
+	s.Bool(&c.pollValid)
+	s.Bool(&c.branchQuirk)
+	s.Bool(&c.afterInterrupt)
+	s.Uint8(&c.dataBus)
+}
+
+// DataBus returns the last value read or written on the data bus.
+// Nothing drives the bus when the CPU reads an address that isn't
+// wired to anything, so it still holds this "open bus" value, which
+// is often the high byte of the address. While the bus is reading,
+// this is the value from before the read, which is what it should
+// return for open bus.
+func (c *CPU) DataBus() uint8 {
+	return c.dataBus
 }
 
 // PC returns the current value of the program counter
@@ -239,16 +685,69 @@ func (c *CPU) SetPC(addr uint16) {
 	c.pc = addr
 }
 
+// Registers are the CPU's programmer visible registers.
+type Registers struct {
+	A, X, Y uint8
+	P       uint8 // status
+	SP      uint8
+	PC      uint16
+}
+
+// Registers returns the CPU's registers.
+func (c *CPU) Registers() Registers {
+	return Registers{A: c.acc, X: c.x, Y: c.y, P: c.status, SP: c.sp, PC: c.pc}
+}
+
+// SetRegisters sets the CPU's registers, such as when restoring
+// another emulator's save state.
+func (c *CPU) SetRegisters(r Registers) {
+	c.acc, c.x, c.y, c.status, c.sp, c.pc = r.A, r.X, r.Y, r.P, r.SP, r.PC
+	c.iDelayed = false
+	c.pollValid = false
+}
+
 // Inst returns a string version of the current instruction. Useful
 // for debugging utilities or (eg) a BIOS loop.
 func (c *CPU) Inst() string {
-	var sb strings.Builder
-	op := opcodes[c.mem.Read(c.pc)]
+	op := opcodes[c.Peek(c.pc)]
+	b := make([]byte, 0, 11*int(op.bytes))
 	for i := 0; i < int(op.bytes); i++ {
 		m := c.pc + uint16(i)
-		sb.WriteString(fmt.Sprintf("%04x: 0x%02x ", m, c.mem.Read(m)))
+		b = appendHex(b, m, 4, hexLower)
+		b = append(b, ": 0x"...)
+		b = appendHex(b, uint16(c.Peek(m)), 2, hexLower)
+		b = append(b, ' ')
 	}
-	return sb.String()
+	s, _ := c.Disassemble(c.pc)
+	return string(b) + s
+}
+
+// Trace returns the current instruction and register state in the
+// column layout of nestest.log, less the disassembly and PPU/cycle
+// columns:
+//
+//	C000  4C F5 C5  A:00 X:00 Y:00 P:24 SP:FD
+func (c *CPU) Trace() string {
+	b := make([]byte, 0, 41)
+	b = appendHex(b, c.pc, 4, hexUpper)
+	b = append(b, ' ')
+	op := opcodes[c.Peek(c.pc)]
+	for i := 0; i < 3; i++ {
+		if i < int(op.bytes) {
+			b = append(b, ' ')
+			b = appendHex(b, uint16(c.Peek(c.pc+uint16(i))), 2, hexUpper)
+		} else {
+			b = append(b, "   "...)
+		}
+	}
+	for _, r := range []struct {
+		name string
+		val  uint8
+	}{{"  A:", c.acc}, {" X:", c.x}, {" Y:", c.y}, {" P:", c.status}, {" SP:", c.sp}} {
+		b = append(b, r.name...)
+		b = appendHex(b, uint16(r.val), 2, hexUpper)
+	}
+	return string(b)
 }
 
 // LoadMem will write out mem to the CPU's memory, starting at address
@@ -259,6 +758,20 @@ func (c *CPU) LoadMem(start uint16, mem []uint8) {
 	}
 }
 
+// LoadProgram loads code at org and gets ready to run it. With
+// setReset, the reset vector is pointed at org and the CPU is reset,
+// as a program in ROM would start; otherwise PC is just set to org,
+// for programs in RAM that leave the vectors alone.
+func (c *CPU) LoadProgram(org uint16, code []uint8, setReset bool) {
+	c.LoadMem(org, code)
+	if !setReset {
+		c.SetPC(org)
+		return
+	}
+	c.LoadMem(INT_RESET, []uint8{uint8(org), uint8(org >> 8)})
+	c.Reset()
+}
+
 // Tick should be called by the system bus at machine frequency. It
 // will only execute a CPU instruction when we've paid down the cycle
 // debt from the last one.
@@ -271,38 +784,219 @@ func (c *CPU) Tick() {
 	c.Step()
 }
 
+// SetCycleClock puts the CPU in cycle mode, where Step runs clock
+// once for every cycle of the instruction as it executes, just before
+// any bus access made in that cycle, rather than leaving the caller to
+// catch the rest of the machine up afterwards. A device that reacts to
+// a write, like OAM DMA, or raises an interrupt, like a mapper IRQ,
+// then sees it on the cycle it really happens. Cycles without a bus
+// access are run at the end of the instruction. A nil clock turns
+// cycle mode off.
+//
+// Step returns the same count in both modes; in cycle mode those
+// cycles have already been clocked, and Tick shouldn't be used.
+func (c *CPU) SetCycleClock(clock func()) {
+	c.clock = clock
+}
+
+// tick clocks a cycle in cycle mode, polling the interrupt lines
+// first.
+func (c *CPU) tick() {
+	c.poll()
+	c.clock()
+	c.spent++
+}
+
+// read reads from the bus at addr, after clocking the cycle in cycle
+// mode.
+func (c *CPU) read(addr uint16) uint8 {
+	if c.clocking {
+		c.tick()
+	}
+	if len(c.watchpoints) != 0 {
+		c.checkWatch(addr, false)
+	}
+	v := c.mem.Read(addr)
+	c.lastWrite = false
+	c.dataBus = v
+	if c.observer != nil {
+		c.observer.OnRead(addr, v)
+	}
+	return v
+}
+
+// write writes val to the bus at addr, after clocking the cycle in
+// cycle mode.
+func (c *CPU) write(addr uint16, val uint8) {
+	if c.clocking {
+		c.tick()
+	}
+	if len(c.watchpoints) != 0 {
+		c.checkWatch(addr, true)
+	}
+	c.dataBus = val
+	c.mem.Write(addr, val)
+	c.lastWrite = true
+	if c.feeding && addr == c.feedAddr {
+		c.feed(val)
+	}
+	if c.observer != nil {
+		c.observer.OnWrite(addr, val)
+	}
+}
+
 // Step will single step the CPU forward, returning the number of
 // cycles consumed to complete the execution of the instruction. It
 // executes the current instruction (at PC) and advances PC when
-// finished.
+// finished. It panics on an invalid instruction; StepE doesn't.
 func (c *CPU) Step() int {
-	if c.pendingInterrupt != INT_NONE {
+	n, err := c.StepE()
+	if err != nil && !errors.Is(err, ErrJammed) && !errors.Is(err, ErrHalted) {
+		panic(err)
+	}
+	return n
+}
+
+// StepE is Step, but returns an error instead of panicking when it
+// meets an instruction it won't run, in which case PC is left on it.
+// It also returns ErrJammed from the step that jams the CPU, and from
+// every step after, each of which idles for a cycle.
+func (c *CPU) StepE() (int, error) {
+	if c.hitBreakpoint() {
+		return 0, nil
+	}
+
+	if c.clock == nil {
+		n, err := c.step()
+		return c.endStep(n), err
+	}
+
+	c.spent = 0
+	c.clocking = true
+	n, err := c.step()
+	c.clocking = false
+
+	if c.spent > n { // More bus accesses than the instruction's cycles
+		n = c.spent
+	}
+	n = c.endStep(n)
+	for c.spent < n {
+		c.tick()
+	}
+	c.pollValid = true
+	return n, err
+}
+
+// RunFor runs instructions until at least cycles cycles have passed,
+// for schedulers, and returns the number that did, which can be a few
+// more. It stops early, like RunUntil, on an error or a break.
+func (c *CPU) RunFor(cycles int) (int, error) {
+	n := 0
+	for n < cycles {
+		m, err := c.runOne()
+		n += m
+		if err != nil || c.broke {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// RunUntil runs instructions until done, checked before each, returns
+// true, for test harnesses, and returns the number of cycles they
+// took. It also stops after an instruction StepE returns an error for,
+// or that hits a breakpoint or watchpoint, which can otherwise only be
+// seen with SetBreakHandler. AtPC and AtBRK make common predicates.
+func (c *CPU) RunUntil(done func(*CPU) bool) (int, error) {
+	n := 0
+	for !done(c) {
+		m, err := c.runOne()
+		n += m
+		if err != nil || c.broke {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// runOne is StepE for RunFor and RunUntil, noting breaks.
+func (c *CPU) runOne() (int, error) {
+	c.broke = false
+	return c.StepE()
+}
+
+// AtPC returns a RunUntil predicate that's true when the CPU is about
+// to run the instruction at addr.
+func AtPC(addr uint16) func(*CPU) bool {
+	return func(c *CPU) bool { return c.pc == addr }
+}
+
+// AtBRK is a RunUntil predicate that's true when the CPU is about to
+// run a BRK instruction.
+func AtBRK(c *CPU) bool {
+	return c.Peek(c.pc) == 0x00
+}
+
+// step executes the next instruction, or services a pending interrupt.
+func (c *CPU) step() (int, error) {
+	// A jammed CPU doesn't even answer interrupts, but time still
+	// passes for the rest of the machine.
+	if c.jammed {
+		c.cycles = 1
+		return c.cycles, fmt.Errorf("pc: 0x%04x - %w", c.pc, ErrJammed)
+	}
+
+	lines := c.interruptLines()
+	if c.afterInterrupt {
+		lines = 0
+	}
+	c.afterInterrupt = false
+	c.branchQuirk = false
+	if c.pendingInterrupt == INT_NONE && lines&POLL_IRQ != 0 && !c.irqsDisabled() {
+		c.pendingInterrupt = INT_IRQ
+	}
+	c.iDelayed = false
+
+	c.instPC = c.pc
+	// A pending NMI that polling hasn't seen yet waits for the
+	// next instruction.
+	if c.pendingInterrupt != INT_NONE && (c.pendingInterrupt != INT_NMI || lines&POLL_NMI != 0) {
 		c.pushAddress(c.pc)
 		c.pushStack(c.status)
 		c.pc = c.Read16(uint16(c.pendingInterrupt), ABSOLUTE)
 		c.flagsOn(STATUS_FLAG_INTERRUPT_DISABLE)
-		switch c.pendingInterrupt {
-		case INT_NMI:
-			c.cycles = 7
-		case INT_IRQ:
+		c.cycles = 7
+		if c.pendingInterrupt == INT_IRQ && c.interruptTiming == INTERRUPTS_SIMPLE {
 			c.cycles = 8
 		}
 
 		c.pendingInterrupt = INT_NONE
-		return c.cycles
+		c.afterInterrupt = c.interruptTiming == INTERRUPTS_NMOS
+		return c.cycles, nil
 	}
 
 	op, err := c.getInst()
 	if err != nil {
-		panic(err)
+		c.cycles = 0
+		return 0, err
+	}
+
+	if c.beforeStep != nil {
+		c.beforeStep(c.stepInfo(op))
 	}
 
-	c.cycles += int(op.cycles)
+	// Any debt left from the last instruction is forgotten, so
+	// that Step can be used without Tick.
+	c.cycles = int(op.cycles)
 	c.pc += 1
 	opc := c.pc
 
-	v := reflect.ValueOf(c)
-	v.MethodByName(op.name).Call([]reflect.Value{reflect.ValueOf(op.mode)})
+	oldI := c.status & STATUS_FLAG_INTERRUPT_DISABLE
+	instructions[op.inst](c, op.mode)
+	switch op.inst {
+	case CLI, SEI, PLP:
+		c.iDelayed = c.status&STATUS_FLAG_INTERRUPT_DISABLE != oldI
+	}
 
 	// If we didn't branch, move the PC beyond the full width of
 	// the instruction. We consumed the first byte for the
@@ -312,7 +1006,13 @@ func (c *CPU) Step() int {
 		c.pc += uint16(op.bytes) - 1
 	}
 
-	return c.cycles
+	if c.afterStep != nil {
+		c.afterStep(c.stepInfo(op))
+	}
+	if c.jammed {
+		return c.cycles, fmt.Errorf("pc: 0x%04x - %w", c.pc, ErrJammed)
+	}
+	return c.cycles, nil
 }
 
 // setNegativeAndZeroFlags sets the STATUS_FLAG_NEGATIVE and
@@ -337,13 +1037,19 @@ func (c *CPU) StackAddr() uint16 {
 }
 
 func (c *CPU) pushStack(val uint8) {
-	c.mem.Write(c.StackAddr(), val)
+	if c.sp == 0x00 && c.onStackWrap != nil {
+		c.onStackWrap(StackWrap{PC: c.instPC, Overflow: true})
+	}
+	c.write(c.StackAddr(), val)
 	c.sp -= 1
 }
 
 func (c *CPU) popStack() uint8 {
+	if c.sp == 0xFF && c.onStackWrap != nil {
+		c.onStackWrap(StackWrap{PC: c.instPC})
+	}
 	c.sp += 1
-	return c.mem.Read(c.StackAddr())
+	return c.read(c.StackAddr())
 }
 
 func (c *CPU) pushAddress(addr uint16) {
@@ -391,8 +1097,10 @@ func (c *CPU) branch(mask uint8, predicate bool) {
 		// increment it right after reading the op, but that's
 		// where we branch from so that's the address we
 		// compare to see if we've jumped to a new page.
-		c.cycles += extraCycles(a, c.pc-1)
+		extra := extraCycles(a, c.pc-1)
+		c.cycles += extra
 		c.cycles += 1 // successful branches take an extra cycle
+		c.branchQuirk = extra == 0
 		c.pc = a
 	}
 }
@@ -405,16 +1113,38 @@ func decodeBCD(val uint8) uint8 {
 	return uint8((val>>4)*10) + (val & 0x0F)
 }
 
+// addBCD is ADC in decimal mode, as the NMOS 6502 does it. Only C
+// and the result are decimal: Z comes from the binary sum, and N and
+// V from the sum with only the low digit corrected. Invalid BCD
+// digits give the same garbage the real CPU does. See
+// http://www.6502.org/tutorials/decimal_mode.html#A
 func (c *CPU) addBCD(val uint8) {
-	var res int16
-	res = int16(decodeBCD(c.acc)) + int16(decodeBCD(val)) + int16(c.status&STATUS_FLAG_CARRY)
-	c.flagsOff(STATUS_FLAG_CARRY)
-	if res > 99 {
-		res -= 100
+	a, b := int(c.acc), int(val)
+	carry := int(c.status & STATUS_FLAG_CARRY)
+
+	lo := a&0x0F + b&0x0F + carry
+	if lo >= 0x0A {
+		lo = (lo+0x06)&0x0F + 0x10
+	}
+	res := a&0xF0 + b&0xF0 + lo
+
+	c.flagsOff(STATUS_FLAG_CARRY | STATUS_FLAG_OVERFLOW | STATUS_FLAG_NEGATIVE | STATUS_FLAG_ZERO)
+	if uint8(a+b+carry) == 0 {
+		c.flagsOn(STATUS_FLAG_ZERO)
+	}
+	if res&0x80 != 0 {
+		c.flagsOn(STATUS_FLAG_NEGATIVE)
+	}
+	if (a^res)&(b^res)&0x80 != 0 {
+		c.flagsOn(STATUS_FLAG_OVERFLOW)
+	}
+	if res >= 0xA0 {
+		res += 0x60
+	}
+	if res >= 0x100 {
 		c.flagsOn(STATUS_FLAG_CARRY)
 	}
-	c.acc = encodeBCD(uint8(res))
-	c.setNegativeAndZeroFlags(c.acc)
+	c.acc = uint8(res)
 }
 
 // addWithOverflow adds b to c.acc handling overflow, carry and ZN
@@ -438,21 +1168,24 @@ func (c *CPU) addWithOverflow(b uint8) {
 	c.setNegativeAndZeroFlags(c.acc)
 }
 
+// subBCD is SBC in decimal mode, as the NMOS 6502 does it. The
+// flags all come from the binary subtraction; only the result is
+// decimal.
 func (c *CPU) subBCD(val uint8) {
-	var res int16
-	res = int16(decodeBCD(c.acc)) - int16(decodeBCD(val))
-	if c.status&STATUS_FLAG_CARRY == 0 {
-		res -= 1
-	}
+	a, b := int(c.acc), int(val)
+	borrow := 1 - int(c.status&STATUS_FLAG_CARRY)
 
-	c.flagsOn(STATUS_FLAG_CARRY)
+	lo := a&0x0F - b&0x0F - borrow
+	if lo < 0 {
+		lo = (lo-0x06)&0x0F - 0x10
+	}
+	res := a&0xF0 - b&0xF0 + lo
 	if res < 0 {
-		res = 100 - (-1 * res)
-		c.flagsOff(STATUS_FLAG_CARRY)
+		res -= 0x60
 	}
 
-	c.acc = encodeBCD(uint8(res))
-	c.setNegativeAndZeroFlags(c.acc)
+	c.addWithOverflow(^val)
+	c.acc = uint8(res)
 }
 
 // baseCMP does comparison operations on a and b, setting flags
@@ -466,11 +1199,11 @@ func (c *CPU) baseCMP(a, b uint8) {
 }
 
 func (c *CPU) useDecimalMode() bool {
-	return c.status&STATUS_FLAG_DECIMAL != 0
+	return c.status&STATUS_FLAG_DECIMAL != 0 && !c.noDecimal
 }
 
 func (c *CPU) ADC(mode uint8) {
-	v := c.mem.Read(c.getOperandAddr(mode))
+	v := c.read(c.getOperandAddr(mode))
 	switch c.useDecimalMode() {
 	case false:
 		c.addWithOverflow(v)
@@ -481,7 +1214,7 @@ func (c *CPU) ADC(mode uint8) {
 }
 
 func (c *CPU) AND(mode uint8) {
-	c.acc = c.acc & c.mem.Read(c.getOperandAddr(mode))
+	c.acc = c.acc & c.read(c.getOperandAddr(mode))
 	c.setNegativeAndZeroFlags(c.acc)
 }
 
@@ -493,10 +1226,10 @@ func (c *CPU) ASL(mode uint8) {
 		c.acc = c.acc << 1
 		nv = c.acc
 	default:
-		addr := c.getOperandAddr(mode)
-		ov = c.mem.Read(addr)
+		var addr uint16
+		addr, ov = c.rmwRead(mode)
 		nv = ov << 1
-		c.mem.Write(addr, nv)
+		c.write(addr, nv)
 	}
 
 	c.flagsOff(STATUS_FLAG_CARRY | STATUS_FLAG_NEGATIVE | STATUS_FLAG_ZERO)
@@ -519,7 +1252,7 @@ func (c *CPU) BEQ(mode uint8) {
 }
 
 func (c *CPU) BIT(mode uint8) {
-	o := c.mem.Read(c.getOperandAddr(mode))
+	o := c.read(c.getOperandAddr(mode))
 
 	c.flagsOff(STATUS_FLAG_NEGATIVE | STATUS_FLAG_OVERFLOW | STATUS_FLAG_ZERO)
 	var flags uint8
@@ -576,21 +1309,22 @@ func (c *CPU) CLV(mode uint8) {
 }
 
 func (c *CPU) CMP(mode uint8) {
-	c.baseCMP(c.acc, c.mem.Read(c.getOperandAddr(mode)))
+	c.baseCMP(c.acc, c.read(c.getOperandAddr(mode)))
 }
 
 func (c *CPU) CPX(mode uint8) {
-	c.baseCMP(c.x, c.mem.Read(c.getOperandAddr(mode)))
+	c.baseCMP(c.x, c.read(c.getOperandAddr(mode)))
 }
 
 func (c *CPU) CPY(mode uint8) {
-	c.baseCMP(c.y, c.mem.Read(c.getOperandAddr(mode)))
+	c.baseCMP(c.y, c.read(c.getOperandAddr(mode)))
 }
 
 func (c *CPU) DEC(mode uint8) {
-	a := c.getOperandAddr(mode)
-	c.mem.Write(a, c.mem.Read(a)-1)
-	c.setNegativeAndZeroFlags(c.mem.Read(a))
+	a, v := c.rmwRead(mode)
+	v--
+	c.write(a, v)
+	c.setNegativeAndZeroFlags(v)
 }
 
 func (c *CPU) DEX(mode uint8) {
@@ -604,14 +1338,15 @@ func (c *CPU) DEY(mode uint8) {
 }
 
 func (c *CPU) EOR(mode uint8) {
-	c.acc = c.acc ^ c.mem.Read(c.getOperandAddr(mode))
+	c.acc = c.acc ^ c.read(c.getOperandAddr(mode))
 	c.setNegativeAndZeroFlags(c.acc)
 }
 
 func (c *CPU) INC(mode uint8) {
-	a := c.getOperandAddr(mode)
-	c.mem.Write(a, c.mem.Read(a)+1)
-	c.setNegativeAndZeroFlags(c.mem.Read(a))
+	a, v := c.rmwRead(mode)
+	v++
+	c.write(a, v)
+	c.setNegativeAndZeroFlags(v)
 }
 
 func (c *CPU) INX(mode uint8) {
@@ -634,17 +1369,17 @@ func (c *CPU) JSR(mode uint8) {
 }
 
 func (c *CPU) LDA(mode uint8) {
-	c.acc = c.mem.Read(c.getOperandAddr(mode))
+	c.acc = c.read(c.getOperandAddr(mode))
 	c.setNegativeAndZeroFlags(c.acc)
 }
 
 func (c *CPU) LDX(mode uint8) {
-	c.x = c.mem.Read(c.getOperandAddr(mode))
+	c.x = c.read(c.getOperandAddr(mode))
 	c.setNegativeAndZeroFlags(c.x)
 }
 
 func (c *CPU) LDY(mode uint8) {
-	c.y = c.mem.Read(c.getOperandAddr(mode))
+	c.y = c.read(c.getOperandAddr(mode))
 	c.setNegativeAndZeroFlags(c.y)
 }
 
@@ -656,10 +1391,10 @@ func (c *CPU) LSR(mode uint8) {
 		c.acc = c.acc >> 1
 		nv = c.acc
 	default:
-		addr := c.getOperandAddr(mode)
-		ov = c.mem.Read(addr)
+		var addr uint16
+		addr, ov = c.rmwRead(mode)
 		nv = ov >> 1
-		c.mem.Write(addr, nv)
+		c.write(addr, nv)
 	}
 
 	c.flagsOff(STATUS_FLAG_CARRY | STATUS_FLAG_NEGATIVE | STATUS_FLAG_ZERO)
@@ -671,11 +1406,14 @@ func (c *CPU) LSR(mode uint8) {
 }
 
 func (c *CPU) NOP(mode uint8) {
-	return
+	// The undocumented NOPs with operands still read them.
+	if mode != IMPLICIT && mode != IMMEDIATE {
+		c.read(c.getOperandAddr(mode))
+	}
 }
 
 func (c *CPU) ORA(mode uint8) {
-	c.acc = c.acc | c.mem.Read(c.getOperandAddr(mode))
+	c.acc = c.acc | c.read(c.getOperandAddr(mode))
 	c.setNegativeAndZeroFlags(c.acc)
 }
 
@@ -707,10 +1445,10 @@ func (c *CPU) ROL(mode uint8) {
 		c.acc = (c.acc << 1) | (c.status & STATUS_FLAG_CARRY)
 		nv = c.acc
 	default:
-		addr := c.getOperandAddr(mode)
-		ov = c.mem.Read(addr)
-		c.mem.Write(addr, ((ov << 1) | (c.status & STATUS_FLAG_CARRY)))
-		nv = c.mem.Read(addr)
+		var addr uint16
+		addr, ov = c.rmwRead(mode)
+		nv = (ov << 1) | (c.status & STATUS_FLAG_CARRY)
+		c.write(addr, nv)
 	}
 
 	c.flagsOff(STATUS_FLAG_CARRY | STATUS_FLAG_NEGATIVE | STATUS_FLAG_ZERO)
@@ -728,10 +1466,10 @@ func (c *CPU) ROR(mode uint8) {
 		c.acc = ov>>1 | ((c.status & STATUS_FLAG_CARRY) << 7)
 		nv = c.acc
 	default:
-		addr := c.getOperandAddr(mode)
-		ov = c.mem.Read(addr)
-		c.mem.Write(addr, ((ov >> 1) | ((c.status & STATUS_FLAG_CARRY) << 7)))
-		nv = c.mem.Read(addr)
+		var addr uint16
+		addr, ov = c.rmwRead(mode)
+		nv = (ov >> 1) | ((c.status & STATUS_FLAG_CARRY) << 7)
+		c.write(addr, nv)
 	}
 
 	c.flagsOff(STATUS_FLAG_CARRY | STATUS_FLAG_NEGATIVE | STATUS_FLAG_ZERO)
@@ -742,7 +1480,8 @@ func (c *CPU) ROR(mode uint8) {
 }
 
 func (c *CPU) RTI(mode uint8) {
-	c.status = c.popStack()
+	c.status = c.popStack() & ^uint8(STATUS_FLAG_BREAK)
+	c.flagsOn(UNUSED_STATUS_FLAG)
 	c.pc = c.popAddress()
 }
 
@@ -751,7 +1490,11 @@ func (c *CPU) RTS(mode uint8) {
 }
 
 func (c *CPU) SBC(mode uint8) {
-	v := c.mem.Read(c.getOperandAddr(mode))
+	c.subtract(c.read(c.getOperandAddr(mode)))
+}
+
+// subtract subtracts v from the accumulator, with borrow, as SBC does.
+func (c *CPU) subtract(v uint8) {
 	if c.useDecimalMode() {
 		c.subBCD(v)
 	} else {
@@ -772,15 +1515,15 @@ func (c *CPU) SEI(mode uint8) {
 }
 
 func (c *CPU) STA(mode uint8) {
-	c.mem.Write(c.getOperandAddr(mode), c.acc)
+	c.write(c.storeAddr(mode), c.acc)
 }
 
 func (c *CPU) STX(mode uint8) {
-	c.mem.Write(c.getOperandAddr(mode), c.x)
+	c.write(c.storeAddr(mode), c.x)
 }
 
 func (c *CPU) STY(mode uint8) {
-	c.mem.Write(c.getOperandAddr(mode), c.y)
+	c.write(c.storeAddr(mode), c.y)
 }
 
 func (c *CPU) TAX(mode uint8) {
@@ -814,27 +1557,184 @@ func (c *CPU) TYA(mode uint8) {
 
 // Undocumented op codes below
 
+// UNSTABLE_MAGIC is the constant that XAA and LXA OR into the
+// accumulator. It varies between chips, and with temperature; $EE is
+// the value most commonly measured.
+const UNSTABLE_MAGIC = 0xEE
+
+// shiftLeft returns v shifted left with in as the new bit 0, moving
+// the old bit 7 to the carry flag.
+func (c *CPU) shiftLeft(v, in uint8) uint8 {
+	c.flagsOff(STATUS_FLAG_CARRY)
+	if v&0x80 != 0 {
+		c.flagsOn(STATUS_FLAG_CARRY)
+	}
+	return v<<1 | in
+}
+
+// shiftRight returns v shifted right with in as the new bit 7, moving
+// the old bit 0 to the carry flag.
+func (c *CPU) shiftRight(v, in uint8) uint8 {
+	c.flagsOff(STATUS_FLAG_CARRY)
+	if v&0x01 != 0 {
+		c.flagsOn(STATUS_FLAG_CARRY)
+	}
+	return v>>1 | in<<7
+}
+
+// unstableStore writes v ANDed with the high byte of the base address
+// plus one, for AHX, TAS, SHX and SHY. When indexing crosses a page,
+// the value also replaces the high byte of the address written to.
+func (c *CPU) unstableStore(mode, index, v uint8) {
+	var base uint16
+	switch mode {
+	case INDIRECT_Y:
+		base = c.Read16(uint16(c.read(c.pc)), mode)
+	default:
+		base = c.Read16(c.pc, mode)
+	}
+	addr := c.indexed(base, index, true)
+
+	v &= uint8(base>>8) + 1
+	if extraCycles(base, addr) != 0 {
+		addr = uint16(v)<<8 | addr&0x00FF
+	}
+	c.write(addr, v)
+}
+
 func (c *CPU) LAX(mode uint8) {
-	m := c.mem.Read(c.getOperandAddr(mode))
+	m := c.read(c.getOperandAddr(mode))
 	c.acc = m
 	c.x = m
+	c.setNegativeAndZeroFlags(m)
 }
 
 func (c *CPU) SAX(mode uint8) {
-	// TODO: Handle carry flag here. Overflow ignored. Carry not used during subtraction.
-	c.x = (c.acc & c.x) - c.mem.Read(c.getOperandAddr(mode))
+	c.write(c.storeAddr(mode), c.acc&c.x)
+}
+
+func (c *CPU) SBX(mode uint8) {
+	// Carry is set as for CMP; the incoming carry isn't used.
+	m := c.read(c.getOperandAddr(mode))
+	ax := c.acc & c.x
+	c.baseCMP(ax, m)
+	c.x = ax - m
 }
 
 func (c *CPU) DCM(mode uint8) {
-	addr := c.getOperandAddr(mode)
-	v := c.mem.Read(addr)
+	addr, v := c.rmwRead(mode)
 	v--
-	c.mem.Write(addr, v)
+	c.write(addr, v)
 	c.baseCMP(c.acc, v)
 }
 
 func (c *CPU) ISB(mode uint8) {
-	addr := c.getOperandAddr(mode)
-	c.mem.Write(addr, c.mem.Read(addr)+1)
-	c.SBC(mode)
+	addr, v := c.rmwRead(mode)
+	v++
+	c.write(addr, v)
+	c.subtract(v)
+}
+
+func (c *CPU) SLO(mode uint8) {
+	addr, m := c.rmwRead(mode)
+	m = c.shiftLeft(m, 0)
+	c.write(addr, m)
+	c.acc |= m
+	c.setNegativeAndZeroFlags(c.acc)
+}
+
+func (c *CPU) RLA(mode uint8) {
+	addr, m := c.rmwRead(mode)
+	m = c.shiftLeft(m, c.status&STATUS_FLAG_CARRY)
+	c.write(addr, m)
+	c.acc &= m
+	c.setNegativeAndZeroFlags(c.acc)
+}
+
+func (c *CPU) SRE(mode uint8) {
+	addr, m := c.rmwRead(mode)
+	m = c.shiftRight(m, 0)
+	c.write(addr, m)
+	c.acc ^= m
+	c.setNegativeAndZeroFlags(c.acc)
+}
+
+func (c *CPU) RRA(mode uint8) {
+	addr, m := c.rmwRead(mode)
+	m = c.shiftRight(m, c.status&STATUS_FLAG_CARRY)
+	c.write(addr, m)
+	if c.useDecimalMode() {
+		c.addBCD(m)
+	} else {
+		c.addWithOverflow(m)
+	}
+}
+
+func (c *CPU) ANC(mode uint8) {
+	c.AND(mode)
+	c.flagsOff(STATUS_FLAG_CARRY)
+	if c.acc&0x80 != 0 {
+		c.flagsOn(STATUS_FLAG_CARRY)
+	}
+}
+
+func (c *CPU) ALR(mode uint8) {
+	c.acc = c.shiftRight(c.acc&c.read(c.getOperandAddr(mode)), 0)
+	c.setNegativeAndZeroFlags(c.acc)
+}
+
+// ARR rotates like ROR, but takes carry from bit 6 of the result and
+// overflow from bit 6 XOR bit 5.
+func (c *CPU) ARR(mode uint8) {
+	v := c.acc & c.read(c.getOperandAddr(mode))
+	c.acc = v>>1 | (c.status&STATUS_FLAG_CARRY)<<7
+	c.setNegativeAndZeroFlags(c.acc)
+
+	c.flagsOff(STATUS_FLAG_CARRY | STATUS_FLAG_OVERFLOW)
+	if c.acc&0x40 != 0 {
+		c.flagsOn(STATUS_FLAG_CARRY)
+	}
+	if (c.acc>>6^c.acc>>5)&1 != 0 {
+		c.flagsOn(STATUS_FLAG_OVERFLOW)
+	}
+}
+
+func (c *CPU) XAA(mode uint8) {
+	c.acc = (c.acc | UNSTABLE_MAGIC) & c.x & c.read(c.getOperandAddr(mode))
+	c.setNegativeAndZeroFlags(c.acc)
+}
+
+func (c *CPU) LXA(mode uint8) {
+	c.acc = (c.acc | UNSTABLE_MAGIC) & c.read(c.getOperandAddr(mode))
+	c.x = c.acc
+	c.setNegativeAndZeroFlags(c.acc)
+}
+
+func (c *CPU) AHX(mode uint8) {
+	c.unstableStore(mode, c.y, c.acc&c.x)
+}
+
+func (c *CPU) TAS(mode uint8) {
+	c.sp = c.acc & c.x
+	c.unstableStore(mode, c.y, c.sp)
+}
+
+func (c *CPU) LAS(mode uint8) {
+	v := c.read(c.getOperandAddr(mode)) & c.sp
+	c.acc, c.x, c.sp = v, v, v
+	c.setNegativeAndZeroFlags(v)
+}
+
+func (c *CPU) SHX(mode uint8) {
+	c.unstableStore(mode, c.y, c.x)
+}
+
+func (c *CPU) SHY(mode uint8) {
+	c.unstableStore(mode, c.x, c.y)
+}
+
+// KIL locks up the CPU. Only a reset recovers it.
+func (c *CPU) KIL(mode uint8) {
+	c.jammed = true
+	c.pc-- // Stay on the KIL, as the real CPU does
 }
@@ -1,8 +1,12 @@
 package mos6502
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -49,6 +53,12 @@ func TestCycles(t *testing.T) {
 		{0xFF, 0, 1, 1, 0, 0x79 /* ADC ABS_Y */, 0xFF, 0x01, 0x0102, 4 /* no page crossed*/},
 		{0, 0 /* CARRY CLEAR */, 1, 1, 0, 0x90 /* BCC REL */, 0x20, 0x01, 0x22, 3 /* branch succeed, no page crossed*/},
 		{0xFF, 0 /* CARRY CLEAR */, 1, 1, 0, 0x90 /* BCC REL */, 10, 0x01, 0x010b, 4 /* branch succeed, page crossed*/},
+		{0xFF, 0, 1, 1, 0, 0x1F /* SLO ABS_X */, 0xFF, 0x01, 0x0102, 7 /* page crossed, but RMW is fixed*/},
+		{0xFF, 0, 1, 1, 0, 0x1C /* NOP ABS_X */, 0xFF, 0x01, 0x0102, 5 /* page crossed*/},
+		{0, 0, 0, 0, 0, 0x1A /* NOP IMPLICIT */, 0, 0, 0x01, 2},
+		{0, 0, 0, 0, 0, 0x80 /* NOP IMM */, 0, 0, 0x02, 2},
+		{0xFF, 0, 1, 1, 0, 0x9D /* STA ABS_X */, 0xFF, 0x01, 0x0102, 5 /* page crossed, but stores are fixed*/},
+		{0xFF, 0, 1, 1, 0, 0xFE /* INC ABS_X */, 0xFF, 0x01, 0x0102, 7 /* page crossed, but RMW is fixed*/},
 	}
 
 	for i, tc := range cases {
@@ -125,6 +135,23 @@ func TestMemRead(t *testing.T) {
 	}
 }
 
+func TestDataBus(t *testing.T) {
+	c := New(NewMem())
+	c.LoadMem(0x0600, []uint8{0xAD, 0x34, 0x12, 0x8D, 0x00, 0x02}) // LDA $1234; STA $0200
+	c.LoadMem(0x1234, []uint8{0x99})
+	c.SetPC(0x0600)
+
+	c.Step()
+	if got := c.DataBus(); got != 0x99 {
+		t.Errorf("After a read, DataBus() = $%02X, wanted $99", got)
+	}
+	c.acc = 0x55
+	c.Step()
+	if got := c.DataBus(); got != 0x55 {
+		t.Errorf("After a write, DataBus() = $%02X, wanted $55", got)
+	}
+}
+
 func TestMemWrite(t *testing.T) {
 	c := cpu
 	cases := []struct {
@@ -292,7 +319,7 @@ func TestGetInst(t *testing.T) {
 	}{
 		{0x00, opcode{BRK, "BRK", IMPLICIT, 2, 7}, nil},
 		{0x24, opcode{BIT, "BIT", ZERO_PAGE, 2, 3}, nil},
-		{0x02, opcode{}, invalidInstruction},
+		{0x02, opcode{KIL, "KIL", IMPLICIT, 1, 2}, nil},
 	}
 
 	for i, tc := range cases {
@@ -329,6 +356,43 @@ func TestReset(t *testing.T) {
 	}
 }
 
+func TestIRQLine(t *testing.T) {
+	c := cpu
+	memInit(c, 0xEA) // NOP
+	c.Write16(INT_IRQ, 0x8000)
+
+	cases := []struct {
+		status   uint8
+		sources  []uint8 // asserted sources
+		released uint8   // a source to release again before stepping
+		wantPC   uint16
+	}{
+		{0, []uint8{IRQ_SOURCE_MAPPER}, 0, 0x8000},
+		{STATUS_FLAG_INTERRUPT_DISABLE, []uint8{IRQ_SOURCE_MAPPER}, 0, 0x0401},
+		{0, []uint8{IRQ_SOURCE_MAPPER}, IRQ_SOURCE_MAPPER, 0x0401},
+		{0, []uint8{IRQ_SOURCE_MAPPER, IRQ_SOURCE_APU_FRAME}, IRQ_SOURCE_MAPPER, 0x8000},
+		{0, []uint8{}, 0, 0x0401},
+	}
+
+	for i, tc := range cases {
+		c.pc = 0x0400
+		c.status = tc.status
+		c.irqLine = 0
+		c.pendingInterrupt = INT_NONE
+		for _, s := range tc.sources {
+			c.SetIRQ(s, true)
+		}
+		if tc.released != 0 {
+			c.SetIRQ(tc.released, false)
+		}
+
+		c.Step()
+		if c.pc != tc.wantPC {
+			t.Errorf("%d: PC = 0x%04x, wanted 0x%04x", i, c.pc, tc.wantPC)
+		}
+	}
+}
+
 func TestOpADC(t *testing.T) {
 	c := cpu
 	cases := []struct {
@@ -342,13 +406,17 @@ func TestOpADC(t *testing.T) {
 		{0xF0, 0x0F, 0x00, 0xFF, 0x80 /* NEGATIVE */},
 		{0xFF, 0xF0, 0x01 /* CARRY */, 0xF0, 0x81 /* NEGATIVE, CARRY */},
 		{0xEF, 0xE1, 0x00, 0xD0, 0x81 /* NEGATIVE, CARRY */},
-		// BCD addition
-		{0x54, 0x99, 0x09 /* DECIMAL, CARRY */, 0x54, 0x09 /* DECIMAL, CARRY */},
-		{0x54, 0x99, 0x08 /* DECIMAL */, 0x53, 0x09 /* DECIMAL, CARRY */},
+		// BCD addition. Z comes from the binary sum, and N and V
+		// from the sum before the high digit is corrected.
+		{0x54, 0x99, 0x09 /* DECIMAL, CARRY */, 0x54, 0x89 /* NEGATIVE, DECIMAL, CARRY */},
+		{0x54, 0x99, 0x08 /* DECIMAL */, 0x53, 0x89 /* NEGATIVE, DECIMAL, CARRY */},
 		{0x00, 0x99, 0x08 /* DECIMAL */, 0x99, 0x88 /* NEGATIVE, DECIMAL */},
-		{0x99, 0x01, 0x08 /* DECIMAL */, 0x00, 0x0b /* DECIMAL, ZERO, CARRY */},
-		{0x99, 0x00, 0x09 /* DECIMAL, CARRY */, 0x00, 0x0b /* DECIMAL, ZERO, CARRY */},
-		{0x99, 0x01, 0x09 /* DECIMAL, CARRY */, 0x01, 0x09 /* DECIMAL, CARRY */},
+		{0x99, 0x01, 0x08 /* DECIMAL */, 0x00, 0x89 /* NEGATIVE, DECIMAL, CARRY */},
+		{0x99, 0x00, 0x09 /* DECIMAL, CARRY */, 0x00, 0x89 /* NEGATIVE, DECIMAL, CARRY */},
+		{0x99, 0x01, 0x09 /* DECIMAL, CARRY */, 0x01, 0x89 /* NEGATIVE, DECIMAL, CARRY */},
+		{0x79, 0x00, 0x09 /* DECIMAL, CARRY */, 0x80, 0xc8 /* NEGATIVE, OVERFLOW, DECIMAL */},
+		{0x80, 0x80, 0x08 /* DECIMAL */, 0x60, 0x4b /* OVERFLOW, DECIMAL, ZERO, CARRY */},
+		{0x0F, 0x01, 0x08 /* DECIMAL */, 0x16, 0x08 /* DECIMAL */},
 	}
 
 	for i, tc := range cases {
@@ -1479,8 +1547,9 @@ func TestOpRTI(t *testing.T) {
 		wantPC     uint16
 		wantStatus uint8
 	}{
-		{[]uint8{0xFF, 0x15, 0x81}, 0xFF15, 0x81},
-		{[]uint8{0xAC, 0x77, 0x02}, 0xAC77, 0x02},
+		{[]uint8{0xFF, 0x15, 0x81}, 0xFF15, 0xA1},
+		{[]uint8{0xAC, 0x77, 0x02}, 0xAC77, 0x22},
+		{[]uint8{0xC0, 0x00, 0x30}, 0xC000, 0x20}, // Break is dropped
 	}
 
 	for i, tc := range cases {
@@ -1533,10 +1602,12 @@ func TestOpSBC(t *testing.T) {
 		{0x42, 0x01, 0x01, 0x41, 0x01},
 		{0x42, 0x42, 0x01, 0x00, 0x03 /* ZERO, CARRY */},
 		{0xD0, 0x70, 0x01, 0x60, 0x41 /* OVERFLOW, CARRY */},
-		// BCD subtraction
-		{0x54, 0x99, 0x09 /* DECIMAL, CARRY */, 0x55, 0x08 /* DECIMAL */},
-		{0x54, 0x99, 0x08 /* DECIMAL */, 0x54, 0x08 /* DECIMAL */},
-		{0x00, 0x99, 0x08 /* DECIMAL */, 0x00, 0x0a /* DECIMAL, ZERO */},
+		// BCD subtraction. The flags come from the binary
+		// subtraction.
+		{0x54, 0x99, 0x09 /* DECIMAL, CARRY */, 0x55, 0xc8 /* NEGATIVE, OVERFLOW, DECIMAL */},
+		{0x54, 0x99, 0x08 /* DECIMAL */, 0x54, 0xc8 /* NEGATIVE, OVERFLOW, DECIMAL */},
+		{0x00, 0x99, 0x08 /* DECIMAL */, 0x00, 0x08 /* DECIMAL */},
+		{0x00, 0x00, 0x08 /* DECIMAL */, 0x99, 0x88 /* NEGATIVE, DECIMAL */},
 		{0x99, 0x01, 0x08 /* DECIMAL */, 0x97, 0x89 /* NEGATIVE, DECIMAL, CARRY */},
 		{0x99, 0x00, 0x09 /* DECIMAL, CARRY */, 0x99, 0x89 /* NEGATIVE, DECIMAL, CARRY */},
 		{0x99, 0x01, 0x09 /* DECIMAL, CARRY */, 0x98, 0x89 /* NEGATIVE, DECIMAL, CARRY */},
@@ -1853,7 +1924,7 @@ func TestOpLAX(t *testing.T) {
 	}
 }
 
-func TestOpSAX(t *testing.T) {
+func TestOpSBX(t *testing.T) {
 	c := cpu
 	cases := []struct {
 		acc, x, op1 uint8
@@ -1869,12 +1940,77 @@ func TestOpSAX(t *testing.T) {
 		c.x = tc.x
 		c.mem.Write(c.pc, tc.op1)
 
-		if c.SAX(IMMEDIATE); c.x != tc.want {
+		if c.SBX(IMMEDIATE); c.x != tc.want {
 			t.Errorf("%d: Got 0x%02x, wanted 0x%02x", i, c.x, tc.want)
 		}
 	}
 }
 
+// TestSAXLAXOpcodes runs each SAX and LAX opcode through Step, checking
+// where it reads or writes, what it changes and how long it takes.
+func TestSAXLAXOpcodes(t *testing.T) {
+	cases := []struct {
+		prog           []uint8
+		addr           uint16 // Where the operand is
+		wantA, wantX   uint8
+		wantM          uint8 // The operand afterwards
+		wantP          uint8
+		wantPC, cycles int
+	}{
+		// SAX stores A & X without touching the flags.
+		{[]uint8{0x87, 0x10}, 0x0010, 0xF3, 0x3C, 0x30, 0x24, 0x0302, 3},
+		{[]uint8{0x97, 0x10}, 0x0012, 0xF3, 0x3C, 0x30, 0x24, 0x0302, 4}, // zp,Y
+		{[]uint8{0x8F, 0x00, 0x04}, 0x0400, 0xF3, 0x3C, 0x30, 0x24, 0x0303, 4},
+		{[]uint8{0x83, 0x1E}, 0x0500, 0xF3, 0x3C, 0x30, 0x24, 0x0302, 6}, // ($1E,X) is ($5A)
+		// LAX loads A and X.
+		{[]uint8{0xA7, 0x10}, 0x0010, 0x99, 0x99, 0x99, 0xA4, 0x0302, 3},
+		{[]uint8{0xB7, 0x10}, 0x0012, 0x99, 0x99, 0x99, 0xA4, 0x0302, 4},
+		// SBX leaves the operand alone.
+		{[]uint8{0xCB, 0x30}, 0x0301, 0xF3, 0x00, 0x30, 0x27, 0x0302, 2},
+	}
+
+	for _, tc := range cases {
+		c := New2A03(NewMem())
+		c.acc, c.x, c.y, c.status = 0xF3, 0x3C, 0x02, 0x24
+		c.mem.Write(0x005A, 0x00)
+		c.mem.Write(0x005B, 0x05)
+		c.LoadMem(0x0300, tc.prog)
+		if tc.prog[0] != 0xCB {
+			c.mem.Write(tc.addr, 0x99)
+		}
+		c.SetPC(0x0300)
+
+		n := c.Step()
+		if c.acc != tc.wantA || c.x != tc.wantX || c.status != tc.wantP {
+			t.Errorf("% X: A=%02X X=%02X P=%02X, wanted A=%02X X=%02X P=%02X", tc.prog, c.acc, c.x, c.status, tc.wantA, tc.wantX, tc.wantP)
+		}
+		if got := c.mem.Read(tc.addr); got != tc.wantM {
+			t.Errorf("% X: $%04X = %02X, wanted %02X", tc.prog, tc.addr, got, tc.wantM)
+		}
+		if int(c.pc) != tc.wantPC || n != tc.cycles {
+			t.Errorf("% X: PC=%04X after %d cycles, wanted %04X after %d", tc.prog, c.pc, n, tc.wantPC, tc.cycles)
+		}
+	}
+}
+
+// TestOpcodeSizes checks that every opcode's size fits its addressing
+// mode, and that the mode has a name.
+func TestOpcodeSizes(t *testing.T) {
+	sizes := map[uint8]uint8{
+		IMPLICIT: 1, ACCUMULATOR: 1,
+		IMMEDIATE: 2, ZERO_PAGE: 2, ZERO_PAGE_X: 2, ZERO_PAGE_Y: 2, ZERO_PAGE_X_BUT_Y: 2, RELATIVE: 2, INDIRECT_X: 2, INDIRECT_Y: 2,
+		ABSOLUTE: 3, ABSOLUTE_X: 3, ABSOLUTE_Y: 3, INDIRECT: 3,
+	}
+	for code, op := range opcodes {
+		if want := sizes[op.mode]; op.bytes != want && op.inst != BRK {
+			t.Errorf("$%02X %s: %d bytes, wanted %d for %s", code, op.name, op.bytes, want, modenames[op.mode])
+		}
+		if modenames[op.mode] == "" {
+			t.Errorf("$%02X %s: mode %d has no name", code, op.name, op.mode)
+		}
+	}
+}
+
 func TestOpDCM(t *testing.T) {
 	c := cpu
 	cases := []struct {
@@ -1911,7 +2047,7 @@ func TestOpISB(t *testing.T) {
 		{0x42, 0x00, 0x01, 0x41, 0x01, 0x01},
 		{0x42, 0x41, 0x01, 0x00, 0x42, 0x03 /* ZERO, CARRY */},
 		// BCD subtraction
-		{0x54, 0x98, 0x09 /* DECIMAL, CARRY */, 0x55, 0x99, 0x08 /* DECIMAL */},
+		{0x54, 0x98, 0x09 /* DECIMAL, CARRY */, 0x55, 0x99, 0xc8 /* NEGATIVE, OVERFLOW, DECIMAL */},
 	}
 
 	var addr uint16 = 0x6600
@@ -1957,6 +2093,567 @@ func TestOpSLO(t *testing.T) {
 	}
 }
 
+func TestOpRLA(t *testing.T) {
+	c := cpu
+	cases := []struct {
+		val, acc, status        uint8
+		want, wantM, wantStatus uint8
+	}{
+		{0x01, 0xFF, 0x00, 0x02, 0x02, 0x00},
+		{0x81, 0xFF, 0x01, 0x03, 0x03, 0x01},
+		{0x40, 0x0F, 0x00, 0x00, 0x80, 0x02},
+	}
+
+	for i, tc := range cases {
+		c.pc = 0x000F
+		c.acc = tc.acc
+		c.status = tc.status
+		c.mem.Write(c.getOperandAddr(ZERO_PAGE), tc.val)
+
+		c.RLA(ZERO_PAGE)
+
+		gotM := c.mem.Read(c.getOperandAddr(ZERO_PAGE))
+		if c.acc != tc.want || gotM != tc.wantM || c.status != tc.wantStatus {
+			t.Errorf("%d: Got 0x%02x, status 0x%02x (m=0x%02x); Want 0x%02x, status 0x%02x (m=0x%02x)", i, c.acc, c.status, gotM, tc.want, tc.wantStatus, tc.wantM)
+		}
+	}
+}
+
+func TestOpSRE(t *testing.T) {
+	c := cpu
+	cases := []struct {
+		val, acc                uint8
+		want, wantM, wantStatus uint8
+	}{
+		{0x02, 0x00, 0x01, 0x01, 0x00},
+		{0x03, 0x01, 0x00, 0x01, 0x03},
+		{0x01, 0x80, 0x80, 0x00, 0x81},
+	}
+
+	for i, tc := range cases {
+		c.pc = 0x000F
+		c.acc = tc.acc
+		c.status = 0
+		c.mem.Write(c.getOperandAddr(ZERO_PAGE), tc.val)
+
+		c.SRE(ZERO_PAGE)
+
+		gotM := c.mem.Read(c.getOperandAddr(ZERO_PAGE))
+		if c.acc != tc.want || gotM != tc.wantM || c.status != tc.wantStatus {
+			t.Errorf("%d: Got 0x%02x, status 0x%02x (m=0x%02x); Want 0x%02x, status 0x%02x (m=0x%02x)", i, c.acc, c.status, gotM, tc.want, tc.wantStatus, tc.wantM)
+		}
+	}
+}
+
+func TestOpRRA(t *testing.T) {
+	c := cpu
+	cases := []struct {
+		val, acc, status        uint8
+		want, wantM, wantStatus uint8
+	}{
+		{0x02, 0x01, 0x00, 0x02, 0x01, 0x00},
+		{0x03, 0x01, 0x00, 0x03, 0x01, 0x00},                // carry from ROR is added
+		{0x00, 0x7F, 0x01, 0xFF, 0x80, 0x80},                // carry rotated in
+		{0x02, 0x7F, 0x00, 0x80, 0x01, 0xC0},                // overflow
+		{0x10, 0x09, STATUS_FLAG_DECIMAL, 0x17, 0x08, 0x08}, // BCD
+	}
+
+	for i, tc := range cases {
+		c.pc = 0x000F
+		c.acc = tc.acc
+		c.status = tc.status
+		c.mem.Write(c.getOperandAddr(ZERO_PAGE), tc.val)
+
+		c.RRA(ZERO_PAGE)
+
+		gotM := c.mem.Read(c.getOperandAddr(ZERO_PAGE))
+		if c.acc != tc.want || gotM != tc.wantM || c.status != tc.wantStatus {
+			t.Errorf("%d: Got 0x%02x, status 0x%02x (m=0x%02x); Want 0x%02x, status 0x%02x (m=0x%02x)", i, c.acc, c.status, gotM, tc.want, tc.wantStatus, tc.wantM)
+		}
+	}
+}
+
+func TestOpImmediateUndocumented(t *testing.T) {
+	c := cpu
+	cases := []struct {
+		inst                    uint8
+		acc, x, op1, status     uint8
+		want, wantX, wantStatus uint8
+	}{
+		{ANC, 0xF0, 0x00, 0x80, 0x00, 0x80, 0x00, 0x81},
+		{ANC, 0xF0, 0x00, 0x0F, 0x01, 0x00, 0x00, 0x02},
+		{ALR, 0xFF, 0x00, 0x03, 0x00, 0x01, 0x00, 0x01},
+		{ALR, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x03},
+		{ARR, 0xFF, 0x00, 0xFF, 0x01, 0xFF, 0x00, 0x81}, // bits 6 and 5 both set: C, no V
+		{ARR, 0xFF, 0x00, 0x80, 0x00, 0x40, 0x00, 0x41}, // bit 6 only: C and V
+		{ARR, 0xFF, 0x00, 0x40, 0x00, 0x20, 0x00, 0x40}, // bit 5 only: V
+		{XAA, 0x00, 0x0F, 0xFF, 0x00, 0x0E, 0x0F, 0x00},
+		{XAA, 0x11, 0xF0, 0xFF, 0x00, 0xF0, 0xF0, 0x80},
+		{LXA, 0x00, 0x00, 0x0F, 0x00, 0x0E, 0x0E, 0x00},
+		{LXA, 0x00, 0x55, 0x01, 0x00, 0x00, 0x00, 0x02},
+		{SBX, 0x0F, 0xFC, 0x02, 0x00, 0x0F, 0x0A, 0x01},
+		{SBX, 0x0F, 0xFC, 0x0D, 0x00, 0x0F, 0xFF, 0x80},
+	}
+
+	for i, tc := range cases {
+		c.pc = 0x7780
+		c.acc = tc.acc
+		c.x = tc.x
+		c.status = tc.status
+		c.mem.Write(c.pc, tc.op1)
+
+		instructions[tc.inst](c, IMMEDIATE)
+
+		if c.acc != tc.want || c.x != tc.wantX || c.status != tc.wantStatus {
+			t.Errorf("%d: Got acc=0x%02x, x=0x%02x, status 0x%02x; wanted acc=0x%02x, x=0x%02x, status 0x%02x", i, c.acc, c.x, c.status, tc.want, tc.wantX, tc.wantStatus)
+		}
+	}
+}
+
+func TestOpUnstableStores(t *testing.T) {
+	c := cpu
+	cases := []struct {
+		op           uint8
+		acc, x, y    uint8
+		addr         uint16
+		wantAddr     uint16
+		want, wantSP uint8
+	}{
+		{0x9F /* AHX ABS_Y */, 0xFF, 0xFF, 0x01, 0x1200, 0x1201, 0x13, 0xFD},
+		{0x9E /* SHX ABS_Y */, 0x00, 0xFF, 0x01, 0x1200, 0x1201, 0x13, 0xFD},
+		{0x9C /* SHY ABS_X */, 0x00, 0x01, 0xFF, 0x1200, 0x1201, 0x13, 0xFD},
+		{0x9B /* TAS ABS_Y */, 0xF0, 0x3F, 0x01, 0x1200, 0x1201, 0x10, 0x30},
+		{0x9E /* SHX ABS_Y, page crossed */, 0x00, 0x07, 0x01, 0x12FF, 0x0300, 0x03, 0xFD},
+	}
+
+	for i, tc := range cases {
+		c.pc = 0x0600
+		c.acc, c.x, c.y, c.sp = tc.acc, tc.x, tc.y, 0xFD
+		c.mem.Write(c.pc, tc.op)
+		c.Write16(c.pc+1, tc.addr)
+		c.mem.Write(tc.wantAddr, 0)
+
+		c.cycles = 0
+		if n := c.Step(); n != int(opcodes[tc.op].cycles) {
+			t.Errorf("%d: Step() took %d cycles, wanted %d", i, n, opcodes[tc.op].cycles)
+		}
+		if got := c.mem.Read(tc.wantAddr); got != tc.want || c.sp != tc.wantSP {
+			t.Errorf("%d: Got m[0x%04x]=0x%02x, sp=0x%02x; wanted 0x%02x, sp=0x%02x", i, tc.wantAddr, got, c.sp, tc.want, tc.wantSP)
+		}
+	}
+}
+
+func TestOpLAS(t *testing.T) {
+	c := cpu
+	c.pc = 0x0600
+	c.sp = 0xF3
+	c.y = 0
+	c.status = 0
+	c.Write16(c.pc, 0x1200)
+	c.mem.Write(0x1200, 0x9E)
+
+	c.LAS(ABSOLUTE_Y)
+	if c.acc != 0x92 || c.x != 0x92 || c.sp != 0x92 || c.status != STATUS_FLAG_NEGATIVE {
+		t.Errorf("Got acc=0x%02x, x=0x%02x, sp=0x%02x, status 0x%02x; wanted 0x92 in each and status 0x80", c.acc, c.x, c.sp, c.status)
+	}
+}
+
+func TestOpKIL(t *testing.T) {
+	c := New(NewMem())
+	c.LoadProgram(0x0600, []uint8{0x02, 0xEA}, true)
+
+	c.Step()
+	if !c.Jammed() || c.PC() != 0x0600 {
+		t.Fatalf("After KIL, Jammed() = %t, PC = 0x%04x; wanted true, 0x0600", c.Jammed(), c.PC())
+	}
+
+	c.TriggerNMI()
+	if n := c.Step(); n != 1 || c.PC() != 0x0600 {
+		t.Errorf("Jammed Step() = %d, PC = 0x%04x; wanted 1, 0x0600", n, c.PC())
+	}
+
+	c.Reset()
+	if c.Jammed() {
+		t.Errorf("Jammed() after Reset() = true, wanted false")
+	}
+}
+
+// clockedMem records the cycle of each write, as counted by a cycle
+// mode clock.
+type clockedMem struct {
+	mem
+	cycle  int
+	writes []int
+}
+
+func (m *clockedMem) Write(addr uint16, val uint8) {
+	m.writes = append(m.writes, m.cycle)
+	m.mem.Write(addr, val)
+}
+
+func TestCycleClock(t *testing.T) {
+	m := &clockedMem{mem: *NewMem()}
+	c := New(m)
+	c.LoadMem(0x0600, []uint8{
+		0x8D, 0x00, 0x02, // STA $0200
+		0x20, 0x00, 0x07, // JSR $0700
+	})
+	c.SetPC(0x0600)
+	c.SetCycleClock(func() { m.cycle++ })
+
+	m.writes = nil
+	if n := c.Step(); n != 4 || m.cycle != 4 || len(m.writes) != 1 || m.writes[0] != 4 {
+		t.Errorf("STA: Step() = %d after %d clocks, writes on cycles %v; wanted 4, 4, [4]", n, m.cycle, m.writes)
+	}
+
+	m.cycle, m.writes = 0, nil
+	if n := c.Step(); n != 6 || m.cycle != 6 || len(m.writes) != 2 {
+		t.Errorf("JSR: Step() = %d after %d clocks, %d writes; wanted 6, 6, 2", n, m.cycle, len(m.writes))
+	}
+
+	c.SetCycleClock(nil)
+	m.cycle = 0
+	if c.Step(); m.cycle != 0 {
+		t.Errorf("Clock ran %d times after cycle mode was turned off", m.cycle)
+	}
+}
+
+func TestDMA(t *testing.T) {
+	m := NewMem()
+	c := New(m)
+	prog := []uint8{0xEA, 0xEA, 0x85, 0x10, 0xEA, 0xEA} // NOP, NOP, STA $10, NOP, NOP
+	c.LoadMem(0x0600, prog)
+	c.SetPC(0x0600)
+
+	// Each instruction is run with the DMA requested while it runs.
+	cases := []struct {
+		name  string
+		start func()
+		want  int
+	}{
+		{"OAM, even", func() { c.DMA(DMA_OAM) }, 2 + 513},
+		{"OAM, odd", func() { c.DMA(DMA_OAM) }, 2 + 514},
+		{"DMC after a write", func() { c.DMA(DMA_DMC) }, 3 + 3},
+		{"DMC after a read", func() { c.DMA(DMA_DMC) }, 2 + 4},
+		{"DMC during OAM", func() { c.DMA(DMA_OAM); c.DMA(DMA_DMC) }, 2 + 514 + 2},
+	}
+	for _, tc := range cases {
+		tc.start()
+		if n := c.Step(); n != tc.want {
+			t.Errorf("%s: Step() = %d, wanted %d", tc.name, n, tc.want)
+		}
+	}
+
+	// A stall is clocked in cycle mode.
+	clocks := 0
+	c.SetCycleClock(func() { clocks++ })
+	c.SetPC(0x0600)
+	c.Stall(5)
+	if n := c.Step(); n != 7 || clocks != 7 {
+		t.Errorf("Stall: Step() = %d after %d clocks, wanted 7, 7", n, clocks)
+	}
+}
+
+func TestCyclesCount(t *testing.T) {
+	c := New(NewMem())
+	c.LoadMem(0x0600, []uint8{0xEA, 0x85, 0x10, 0xEA}) // NOP, STA $10, NOP
+	c.SetPC(0x0600)
+
+	c.Step()
+	c.Stall(4)
+	c.Step()
+	if got := c.Cycles(); got != 2+3+4 {
+		t.Errorf("Cycles() = %d after Step, wanted %d", got, 2+3+4)
+	}
+
+	// Tick pays off the 7 cycles still owed, then counts the next
+	// instruction when it runs it.
+	for i := 0; i < 8; i++ {
+		c.Tick()
+	}
+	if got := c.Cycles(); got != 2+3+4+2 {
+		t.Errorf("Cycles() = %d after Tick, wanted %d", got, 2+3+4+2)
+	}
+
+	if c.Reset(); c.Cycles() == 0 {
+		t.Errorf("Cycles() was reset by Reset")
+	}
+}
+
+func TestRunForAndUntil(t *testing.T) {
+	c := New(NewMem())
+	c.LoadMem(0x0600, []uint8{
+		0xA2, 0x03, // LDX #$03
+		0xCA,       // loop: DEX
+		0xD0, 0xFD, // BNE loop
+		0xEA, // NOP
+		0x00, // BRK
+	})
+	c.SetPC(0x0600)
+
+	// LDX, then DEX and a taken BNE, 2 + 2 + 3 cycles.
+	if n, err := c.RunFor(6); n != 7 || err != nil || c.PC() != 0x0602 {
+		t.Errorf("RunFor(6) = %d, %v with PC 0x%04x; wanted 7, nil, 0x0602", n, err, c.PC())
+	}
+
+	if n, err := c.RunUntil(AtPC(0x0605)); n != 9 || err != nil {
+		t.Errorf("RunUntil(AtPC) = %d, %v; wanted 9, nil", n, err)
+	}
+	if n, err := c.RunUntil(AtBRK); n != 2 || err != nil || c.PC() != 0x0606 {
+		t.Errorf("RunUntil(AtBRK) = %d, %v with PC 0x%04x; wanted 2, nil, 0x0606", n, err, c.PC())
+	}
+
+	// A breakpoint stops it short, and it carries on from there.
+	c.SetPC(0x0600)
+	c.AddBreakpoint(0x0605)
+	if _, err := c.RunUntil(AtBRK); err != nil || c.PC() != 0x0605 {
+		t.Errorf("RunUntil(AtBRK) stopped at 0x%04x, %v; wanted the breakpoint at 0x0605", c.PC(), err)
+	}
+	if c.RunUntil(AtBRK); c.PC() != 0x0606 {
+		t.Errorf("RunUntil(AtBRK) after the breakpoint stopped at 0x%04x, wanted 0x0606", c.PC())
+	}
+
+	c.LoadMem(0x0606, []uint8{0x02}) // KIL
+	if _, err := c.RunFor(100); !errors.Is(err, ErrJammed) {
+		t.Errorf("RunFor() = %v, wanted ErrJammed", err)
+	}
+}
+
+func TestLoadProgram(t *testing.T) {
+	c := New(NewMem())
+	c.status = 0
+	c.LoadProgram(0x8000, []uint8{0xA9, 0x42}, true) // LDA #$42
+	if c.PC() != 0x8000 || c.Read16(INT_RESET, ABSOLUTE) != 0x8000 || c.status&STATUS_FLAG_INTERRUPT_DISABLE == 0 {
+		t.Errorf("After LoadProgram with reset, PC = 0x%04x, reset vector 0x%04x, status 0x%02x; wanted 0x8000, 0x8000 and I set", c.PC(), c.Read16(INT_RESET, ABSOLUTE), c.status)
+	}
+	if c.Step(); c.acc != 0x42 {
+		t.Errorf("A = 0x%02x, wanted 0x42", c.acc)
+	}
+
+	c.LoadProgram(0x0300, []uint8{0xA9, 0x24}, false)
+	if c.PC() != 0x0300 || c.Read16(INT_RESET, ABSOLUTE) != 0x8000 {
+		t.Errorf("After LoadProgram without reset, PC = 0x%04x, reset vector 0x%04x; wanted 0x0300, 0x8000", c.PC(), c.Read16(INT_RESET, ABSOLUTE))
+	}
+}
+
+func TestHaltOpcodes(t *testing.T) {
+	c := New(NewMem())
+	c.LoadMem(INT_BRK, []uint8{0x00, 0x07})
+	c.LoadProgram(0x0600, []uint8{0xEA, 0x00, 0xEA}, false) // NOP, BRK, NOP
+	c.SetHaltOpcodes(0x00)
+
+	if n, err := c.RunUntil(AtPC(0xFFFF)); n != 2 || !errors.Is(err, ErrHalted) || c.PC() != 0x0601 {
+		t.Errorf("RunUntil() = %d, %v with PC 0x%04x; wanted 2, ErrHalted, 0x0601", n, err, c.PC())
+	}
+	if n := c.Step(); n != 0 || c.PC() != 0x0601 {
+		t.Errorf("Step() on the halt = %d with PC 0x%04x; wanted 0, 0x0601", n, c.PC())
+	}
+
+	c.SetHaltOpcodes()
+	if c.Step(); c.PC() != 0x0700 {
+		t.Errorf("PC = 0x%04x after BRK, wanted 0x0700", c.PC())
+	}
+}
+
+func TestStepE(t *testing.T) {
+	cases := []struct {
+		policy     uint8
+		wantCycles []int
+		wantErr    []error
+		wantPC     uint16
+	}{
+		{INVALID_JAM, []int{2, 1}, []error{ErrJammed, ErrJammed}, 0x0600},
+		{INVALID_NOP, []int{2, 2}, []error{nil, nil}, 0x0602},
+		{INVALID_ERROR, []int{0, 0}, []error{ErrInvalidInstruction, ErrInvalidInstruction}, 0x0600},
+	}
+
+	for i, tc := range cases {
+		// The same counts in cycle mode, with every cycle clocked.
+		for _, cycling := range []bool{false, true} {
+			c := New(NewMem())
+			c.LoadMem(0x0600, []uint8{0x02, 0xEA}) // KIL; NOP
+			c.SetPC(0x0600)
+			c.SetInvalidPolicy(tc.policy)
+			clocks := 0
+			if cycling {
+				c.SetCycleClock(func() { clocks++ })
+			}
+
+			for j := range tc.wantCycles {
+				clocks = 0
+				n, err := c.StepE()
+				if n != tc.wantCycles[j] || !errors.Is(err, tc.wantErr[j]) || (err != nil) != (tc.wantErr[j] != nil) {
+					t.Errorf("%d/%d (cycling %t): StepE() = %d, %v; wanted %d, %v", i, j, cycling, n, err, tc.wantCycles[j], tc.wantErr[j])
+				}
+				if cycling && clocks != n {
+					t.Errorf("%d/%d: StepE() clocked %d cycles, but returned %d", i, j, clocks, n)
+				}
+			}
+			if c.PC() != tc.wantPC {
+				t.Errorf("%d (cycling %t): PC = 0x%04x, wanted 0x%04x", i, cycling, c.PC(), tc.wantPC)
+			}
+		}
+	}
+}
+
+// access is a bus access seen by tracedMem.
+type access struct {
+	write bool
+	addr  uint16
+	val   uint8
+}
+
+// tracedMem records every access made through it.
+type tracedMem struct {
+	mem
+	log []access
+}
+
+func (m *tracedMem) Read(addr uint16) uint8 {
+	v := m.mem.Read(addr)
+	m.log = append(m.log, access{false, addr, v})
+	return v
+}
+
+func (m *tracedMem) Write(addr uint16, val uint8) {
+	m.log = append(m.log, access{true, addr, val})
+	m.mem.Write(addr, val)
+}
+
+func TestDummyAccesses(t *testing.T) {
+	cases := []struct {
+		name string
+		prog []uint8
+		want []access
+	}{
+		{
+			"LDA abs,X, same page",
+			[]uint8{0xBD, 0x00, 0x03}, // LDA $0300,X
+			[]access{{false, 0x0600, 0xBD}, {false, 0x0601, 0x00}, {false, 0x0602, 0x03}, {false, 0x0301, 0x11}},
+		},
+		{
+			"LDA abs,X, page crossed",
+			[]uint8{0xBD, 0xFF, 0x02}, // LDA $02FF,X
+			[]access{{false, 0x0600, 0xBD}, {false, 0x0601, 0xFF}, {false, 0x0602, 0x02}, {false, 0x0200, 0x00}, {false, 0x0300, 0x10}},
+		},
+		{
+			"STA abs,X",
+			[]uint8{0x9D, 0x00, 0x03}, // STA $0300,X
+			[]access{{false, 0x0600, 0x9D}, {false, 0x0601, 0x00}, {false, 0x0602, 0x03}, {false, 0x0301, 0x11}, {true, 0x0301, 0x42}},
+		},
+		{
+			"INC abs",
+			[]uint8{0xEE, 0x00, 0x03}, // INC $0300
+			[]access{{false, 0x0600, 0xEE}, {false, 0x0601, 0x00}, {false, 0x0602, 0x03}, {false, 0x0300, 0x10}, {true, 0x0300, 0x10}, {true, 0x0300, 0x11}},
+		},
+		{
+			"LDA zp,X",
+			[]uint8{0xB5, 0x10}, // LDA $10,X
+			[]access{{false, 0x0600, 0xB5}, {false, 0x0601, 0x10}, {false, 0x0010, 0x00}, {false, 0x0011, 0x00}},
+		},
+	}
+
+	for _, tc := range cases {
+		m := &tracedMem{mem: *NewMem()}
+		c := New(m)
+		c.LoadMem(0x0600, tc.prog)
+		m.mem.Write(0x0300, 0x10)
+		m.mem.Write(0x0301, 0x11)
+		c.SetPC(0x0600)
+		c.acc, c.x = 0x42, 1
+
+		m.log = nil
+		c.Step()
+		if fmt.Sprint(m.log) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: Got accesses %v, wanted %v", tc.name, m.log, tc.want)
+		}
+	}
+}
+
+func TestDelayedIFlag(t *testing.T) {
+	cases := []struct {
+		name   string
+		prog   []uint8
+		status uint8
+		steps  int // after the first instruction, before the IRQ is taken
+	}{
+		{"CLI", []uint8{0x58, 0xEA, 0xEA}, STATUS_FLAG_INTERRUPT_DISABLE, 1},
+		{"PLP", []uint8{0x28, 0xEA, 0xEA}, STATUS_FLAG_INTERRUPT_DISABLE, 1},
+		{"SEI", []uint8{0x78, 0xEA, 0xEA}, 0, 0},
+		{"CLI then SEI", []uint8{0x58, 0x78, 0xEA}, STATUS_FLAG_INTERRUPT_DISABLE, 1},
+	}
+
+	for _, tc := range cases {
+		c := New(NewMem())
+		c.LoadMem(0x0600, tc.prog)
+		c.Write16(INT_IRQ, 0x0700)
+		c.SetPC(0x0600)
+		c.status = tc.status
+		c.sp = 0xFD
+		c.mem.Write(0x01FE, 0) // for PLP, with I clear
+
+		c.Step()
+		c.SetIRQ(IRQ_SOURCE_MAPPER, true)
+		for i := 0; i < tc.steps; i++ {
+			if c.Step(); c.PC() == 0x0700 {
+				t.Errorf("%s: IRQ taken after %d more instructions, wanted %d", tc.name, i, tc.steps)
+			}
+		}
+		if c.Step(); c.PC() != 0x0700 {
+			t.Errorf("%s: IRQ not taken after %d more instructions; PC = 0x%04x", tc.name, tc.steps, c.PC())
+		}
+	}
+}
+
+func Test2A03(t *testing.T) {
+	cases := []struct {
+		op          uint8 // with an immediate operand of $01
+		acc, status uint8
+		want        uint8
+	}{
+		{0x69 /* ADC */, 0x09, STATUS_FLAG_DECIMAL, 0x0A},
+		{0xE9 /* SBC */, 0x10, STATUS_FLAG_DECIMAL | STATUS_FLAG_CARRY, 0x0F},
+	}
+
+	for i, tc := range cases {
+		c := New2A03(NewMem())
+		c.LoadMem(0x0600, []uint8{tc.op, 0x01})
+		c.SetPC(0x0600)
+		c.acc, c.status = tc.acc, tc.status
+
+		if c.Step(); c.acc != tc.want || c.status&STATUS_FLAG_DECIMAL == 0 {
+			t.Errorf("%d: Got acc 0x%02x, status %s; wanted 0x%02x with D still set", i, c.acc, statusString(c.status), tc.want)
+		}
+	}
+}
+
+func TestStepAllocs(t *testing.T) {
+	c := New(NewMem())
+	c.LoadMem(0x0600, []uint8{
+		0xA9, 0x10, // LDA #$10
+		0x69, 0x01, // ADC #$01
+		0x8D, 0x00, 0x02, // STA $0200
+		0x4C, 0x00, 0x06, // JMP $0600
+	})
+	c.SetPC(0x0600)
+
+	if n := testing.AllocsPerRun(100, func() { c.Step() }); n != 0 {
+		t.Errorf("Step() made %.0f allocations, wanted 0", n)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	c := New(NewMem())
+	c.LoadMem(0xC000, []uint8{0x4C, 0xF5, 0xC5})
+	c.SetPC(0xC000)
+	c.status = 0x24
+
+	want := "C000  4C F5 C5  A:00 X:00 Y:00 P:24 SP:FD"
+	if got := c.Trace(); got != want {
+		t.Errorf("Trace() = %q, wanted %q", got, want)
+	}
+}
+
 // Functional tests
 
 func TestFunctionsBin(t *testing.T) {
@@ -1984,3 +2681,230 @@ func TestFunctionsBin(t *testing.T) {
 		t.Errorf("PC = 0x%04x, wanted 0x%04x", got, want)
 	}
 }
+
+func TestInterruptTiming(t *testing.T) {
+	newCPU := func(prog ...uint8) *CPU {
+		c := New(NewMem())
+		c.LoadMem(INT_NMI, []uint8{0x00, 0x07, 0x00, 0x06, 0x00, 0x08}) // NMI $0700, reset $0600, IRQ $0800
+		c.LoadMem(0x0700, []uint8{0xEA, 0xEA})
+		c.LoadMem(0x0800, []uint8{0xEA, 0xEA})
+		c.LoadProgram(0x0600, prog, false)
+		c.status = 0
+		return c
+	}
+
+	for _, tc := range []struct {
+		model uint8
+		want  int
+	}{{INTERRUPTS_NMOS, 7}, {INTERRUPTS_SIMPLE, 8}} {
+		c := newCPU(0xEA)
+		c.SetInterruptTiming(tc.model)
+		c.SetIRQ(IRQ_SOURCE_MAPPER, true)
+		if n := c.Step(); n != tc.want || c.PC() != 0x0800 {
+			t.Errorf("Model %d: IRQ took %d cycles to PC 0x%04x, wanted %d to 0x0800", tc.model, n, c.PC(), tc.want)
+		}
+	}
+
+	// The handler's first instruction runs before the next interrupt.
+	c := newCPU(0xEA)
+	c.SetIRQ(IRQ_SOURCE_MAPPER, true)
+	c.Step()
+	c.TriggerNMI()
+	if c.Step(); c.PC() != 0x0801 {
+		t.Errorf("PC = 0x%04x after an NMI straight after an IRQ, wanted 0x0801", c.PC())
+	}
+	if c.Step(); c.PC() != 0x0700 {
+		t.Errorf("PC = 0x%04x, wanted the NMI handler at 0x0700", c.PC())
+	}
+
+	// In cycle mode, an interrupt raised on an instruction's last
+	// cycle waits for the next instruction, and a taken branch that
+	// stays on its page doesn't look on its last two cycles.
+	cases := []struct {
+		name   string
+		prog   []uint8
+		raise  func(c *CPU)
+		cycle  int
+		wantPC []uint16 // after each step
+	}{
+		{"NMI on NOP's first cycle", []uint8{0xEA, 0xEA, 0xEA}, (*CPU).TriggerNMI, 1, []uint16{0x0601, 0x0700}},
+		{"NMI on NOP's last cycle", []uint8{0xEA, 0xEA, 0xEA}, (*CPU).TriggerNMI, 2, []uint16{0x0601, 0x0602, 0x0700}},
+		{"IRQ on BNE's second cycle", []uint8{0xD0, 0x00, 0xEA, 0xEA}, func(c *CPU) { c.SetIRQ(IRQ_SOURCE_MAPPER, true) }, 2, []uint16{0x0602, 0x0603, 0x0800}},
+		{"IRQ on untaken BEQ's first cycle", []uint8{0xF0, 0x00, 0xEA, 0xEA}, func(c *CPU) { c.SetIRQ(IRQ_SOURCE_MAPPER, true) }, 1, []uint16{0x0602, 0x0800}},
+	}
+	for _, tc := range cases {
+		c := newCPU(tc.prog...)
+		clocks := 0
+		c.SetCycleClock(func() {
+			if clocks++; clocks == tc.cycle {
+				tc.raise(c)
+			}
+		})
+		for i, want := range tc.wantPC {
+			if c.Step(); c.PC() != want {
+				t.Errorf("%s: PC = 0x%04x after step %d, wanted 0x%04x", tc.name, c.PC(), i+1, want)
+				break
+			}
+		}
+	}
+}
+
+func TestInterruptFeed(t *testing.T) {
+	c := New(NewMem())
+	c.LoadMem(INT_NMI, []uint8{0x00, 0x07, 0x00, 0x06, 0x00, 0x08}) // NMI $0700, reset $0600, IRQ $0800
+	c.LoadProgram(0x0600, []uint8{
+		0x58,       // CLI
+		0xA9, 0x01, // LDA #$01
+		0x8D, 0xFC, 0xBF, // STA $BFFC
+	}, false)
+	c.LoadMem(0x0800, []uint8{
+		0xA9, 0x02, // LDA #$02
+		0x8D, 0xFC, 0xBF, // STA $BFFC
+	})
+	c.SetInterruptFeed(0xBFFC, true)
+
+	for i := 0; i < 3; i++ {
+		c.Step()
+	}
+	if !c.IRQAsserted() || c.Peek(0xBFFC) != 0x01 {
+		t.Errorf("After writing bit 0, IRQAsserted() = %t and the port reads 0x%02x; wanted true, 0x01", c.IRQAsserted(), c.Peek(0xBFFC))
+	}
+	if c.Step(); c.PC() != 0x0800 {
+		t.Errorf("PC = 0x%04x after IRQ, wanted 0x0800", c.PC())
+	}
+
+	// The NMI is edge triggered, so only the first write of bit 1 counts.
+	c.Step()
+	c.Step()
+	if c.IRQAsserted() || c.Step() == 0 || c.PC() != 0x0700 {
+		t.Errorf("After writing bit 1, IRQAsserted() = %t, PC = 0x%04x; wanted false, 0x0700", c.IRQAsserted(), c.PC())
+	}
+
+	c.SetInterruptFeed(0xBFFC, false)
+	c.LoadProgram(0x0600, []uint8{0xA9, 0x01, 0x8D, 0xFC, 0xBF}, false)
+	c.Step()
+	if c.Step(); c.IRQAsserted() {
+		t.Errorf("IRQ asserted after the feed was removed")
+	}
+}
+
+// TestInterruptsBin runs Klaus Dormann's 6502_interrupt_test. It and
+// its listing, which gives the address of the success trap, aren't
+// distributed with gintendo; drop the default build's .bin and .lst
+// into testdata to run it.
+func TestInterruptsBin(t *testing.T) {
+	bin, err := os.ReadFile("../testdata/6502_interrupt_test.bin")
+	if err != nil {
+		t.Skipf("6502_interrupt_test.bin not available: %v", err)
+	}
+	lst, err := os.ReadFile("../testdata/6502_interrupt_test.lst")
+	if err != nil {
+		t.Skipf("6502_interrupt_test.lst not available: %v", err)
+	}
+
+	// The success macro is used on a line of its own, and expands
+	// to a jmp * on the next line with an address.
+	var want uint16
+	lines := strings.Split(string(lst), "\n")
+	for i := 0; i < len(lines) && want == 0; i++ {
+		if f := strings.Fields(lines[i]); len(f) == 0 || f[0] != "success" {
+			continue
+		}
+		for _, l := range lines[i+1:] {
+			if addr, rest, ok := strings.Cut(l, " : "); ok && strings.Contains(rest, "jmp *") {
+				fmt.Sscanf(strings.TrimSpace(addr), "%x", &want)
+				break
+			}
+		}
+	}
+	if want == 0 {
+		t.Fatalf("Couldn't find the success trap in 6502_interrupt_test.lst")
+	}
+
+	c := New(NewMem())
+	c.LoadMem(uint16(MEM_SIZE-len(bin)), bin) // The image ends at $FFFF
+	c.SetInterruptFeed(0xBFFC, true)
+	c.SetPC(0x0400)
+	for {
+		prev_pc := c.PC()
+		if c.Step(); c.PC() == prev_pc {
+			break
+		}
+	}
+
+	if got := c.PC(); got != want {
+		t.Errorf("PC = 0x%04x, wanted 0x%04x", got, want)
+	}
+}
+
+// traceMem is flat memory whose APU and IO registers read as $FF,
+// which is what nestest.log and the traces in its format were recorded
+// with.
+type traceMem struct {
+	mem
+}
+
+func (m *traceMem) Read(addr uint16) uint8 {
+	if addr >= 0x4000 && addr <= 0x401F {
+		return 0xFF
+	}
+	return m.mem.Read(addr)
+}
+
+// TestTraceLog runs cputrace.bin, a short run through the official
+// opcodes assembled from cputrace.s, against its trace in cputrace.log,
+// which is in nestest.log's format.
+func TestTraceLog(t *testing.T) {
+	bin, err := os.ReadFile("../testdata/cputrace.bin")
+	if err != nil {
+		t.Fatalf("couldn't read cputrace.bin: %v", err)
+	}
+	f, err := os.Open("../testdata/cputrace.log")
+	if err != nil {
+		t.Fatalf("couldn't open cputrace.log: %v", err)
+	}
+	defer f.Close()
+
+	m := &traceMem{*NewMem()}
+	for i, b := range bin {
+		m.Write(0xC000+uint16(i), b)
+	}
+
+	runTraceLog(t, New2A03(m), "cputrace.log", f)
+}
+
+// runTraceLog starts c at $C000, as nestest's automated mode does, and
+// compares our trace with the nestest.log formatted log in r. Cycle
+// counts are compared when the log has them, but not in the older logs
+// that count PPU dots with SL:.
+func runTraceLog(t *testing.T, c *CPU, name string, r io.Reader) {
+	t.Helper()
+
+	c.pc = 0xC000
+	c.status = 0x24
+	cycles := 7 // Spent by the reset sequence
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if len(line) < 73 {
+			t.Fatalf("%s:%d: malformed line %q", name, n, line)
+		}
+
+		want := line[:14] + "  " + line[48:73]
+		got := c.Trace()
+		if i := strings.Index(line, "CYC:"); i >= 0 && !strings.Contains(line, "SL:") {
+			want += " CYC:" + strings.TrimSpace(line[i+4:])
+			got += fmt.Sprintf(" CYC:%d", cycles)
+		}
+		if got != want {
+			t.Fatalf("%s:%d diverges:\n got: %s\nwant: %s", name, n, got, want)
+		}
+
+		c.cycles = 0
+		cycles += c.Step()
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("couldn't read %s: %v", name, err)
+	}
+}
@@ -1,9 +1,5 @@
 package mos6502
 
-import (
-	"fmt"
-)
-
 // 6502 Addressing Modes
 // https://www.nesdev.org/obelisk-6502-guide/addressing.html
 const (
@@ -25,7 +21,7 @@ const (
 
 const STACK_PAGE = 0x0100
 
-var modenames map[uint8]string = map[uint8]string{IMPLICIT: "IMPLICIT", ACCUMULATOR: "ACCUMULATOR", IMMEDIATE: "IMMEDIATE", ZERO_PAGE: "ZERO_PAGE", ZERO_PAGE_X: "ZERO_PAGE_X", ZERO_PAGE_Y: "ZERO_PAGE_Y", RELATIVE: "RELATIVE", ABSOLUTE: "ABSOLUTE", ABSOLUTE_X: "ABSOLUTE_X", ABSOLUTE_Y: "ABSOLUTE_Y", INDIRECT: "INDIRECT", INDIRECT_X: "INDIRECT_X", INDIRECT_Y: "INDIRECT_Y"}
+var modenames map[uint8]string = map[uint8]string{IMPLICIT: "IMPLICIT", ACCUMULATOR: "ACCUMULATOR", IMMEDIATE: "IMMEDIATE", ZERO_PAGE: "ZERO_PAGE", ZERO_PAGE_X: "ZERO_PAGE_X", ZERO_PAGE_Y: "ZERO_PAGE_Y", ZERO_PAGE_X_BUT_Y: "ZERO_PAGE_X_BUT_Y", RELATIVE: "RELATIVE", ABSOLUTE: "ABSOLUTE", ABSOLUTE_X: "ABSOLUTE_X", ABSOLUTE_Y: "ABSOLUTE_Y", INDIRECT: "INDIRECT", INDIRECT_X: "INDIRECT_X", INDIRECT_Y: "INDIRECT_Y"}
 
 // 6502 Instructions
 // https://www.nesdev.org/obelisk-6502-guide/instructions.html
@@ -88,10 +84,25 @@ const (
 	TXS        // Transfer X to Stack Pointer
 	TYA        // Transfer Y to Accumulator
 	LAX        // Load ACC and X from memory, undocumented
-	SAX        // And X = (ACC & X) - immediate value, undocumented
+	SAX        // m = ACC & X - undocumented
+	SBX        // X = (ACC & X) - imm, C as for CMP - undocumented
 	DCM        // m--; cmp acc w/m - undocumented
 	ISB        // m++; acc - m - undocumented
 	SLO        // ASL(m); ACC || m - undocumented
+	RLA        // ROL(m); ACC & m - undocumented
+	SRE        // LSR(m); ACC ^ m - undocumented
+	RRA        // ROR(m); ACC + m - undocumented
+	ANC        // ACC & imm; C = N - undocumented
+	ALR        // LSR(ACC & imm) - undocumented
+	ARR        // ROR(ACC & imm), odd C and V - undocumented
+	XAA        // ACC = (ACC | magic) & X & imm, unstable - undocumented
+	LXA        // ACC = X = (ACC | magic) & imm, unstable - undocumented
+	AHX        // m = ACC & X & (H+1), unstable - undocumented
+	TAS        // SP = ACC & X; m = SP & (H+1), unstable - undocumented
+	LAS        // ACC = X = SP = m & SP - undocumented
+	SHX        // m = X & (H+1), unstable - undocumented
+	SHY        // m = Y & (H+1), unstable - undocumented
+	KIL        // Halt the CPU until reset - undocumented
 )
 
 type opcode struct {
@@ -102,8 +113,122 @@ type opcode struct {
 	cycles uint8 // The number of cycles consumed by the instruction
 }
 
+// instructions holds the implementation of each instruction, indexed
+// by id. Step calls through it rather than looking methods up by name.
+var instructions = [...]func(*CPU, uint8){
+	ADC: (*CPU).ADC,
+	AND: (*CPU).AND,
+	ASL: (*CPU).ASL,
+	BCC: (*CPU).BCC,
+	BCS: (*CPU).BCS,
+	BEQ: (*CPU).BEQ,
+	BIT: (*CPU).BIT,
+	BMI: (*CPU).BMI,
+	BNE: (*CPU).BNE,
+	BPL: (*CPU).BPL,
+	BRK: (*CPU).BRK,
+	BVC: (*CPU).BVC,
+	BVS: (*CPU).BVS,
+	CLC: (*CPU).CLC,
+	CLD: (*CPU).CLD,
+	CLI: (*CPU).CLI,
+	CLV: (*CPU).CLV,
+	CMP: (*CPU).CMP,
+	CPX: (*CPU).CPX,
+	CPY: (*CPU).CPY,
+	DEC: (*CPU).DEC,
+	DEX: (*CPU).DEX,
+	DEY: (*CPU).DEY,
+	EOR: (*CPU).EOR,
+	INC: (*CPU).INC,
+	INX: (*CPU).INX,
+	INY: (*CPU).INY,
+	JMP: (*CPU).JMP,
+	JSR: (*CPU).JSR,
+	LDA: (*CPU).LDA,
+	LDX: (*CPU).LDX,
+	LDY: (*CPU).LDY,
+	LSR: (*CPU).LSR,
+	NOP: (*CPU).NOP,
+	ORA: (*CPU).ORA,
+	PHA: (*CPU).PHA,
+	PHP: (*CPU).PHP,
+	PLA: (*CPU).PLA,
+	PLP: (*CPU).PLP,
+	ROL: (*CPU).ROL,
+	ROR: (*CPU).ROR,
+	RTI: (*CPU).RTI,
+	RTS: (*CPU).RTS,
+	SBC: (*CPU).SBC,
+	SEC: (*CPU).SEC,
+	SED: (*CPU).SED,
+	SEI: (*CPU).SEI,
+	STA: (*CPU).STA,
+	STX: (*CPU).STX,
+	STY: (*CPU).STY,
+	TAX: (*CPU).TAX,
+	TAY: (*CPU).TAY,
+	TSX: (*CPU).TSX,
+	TXA: (*CPU).TXA,
+	TXS: (*CPU).TXS,
+	TYA: (*CPU).TYA,
+	LAX: (*CPU).LAX,
+	SAX: (*CPU).SAX,
+	SBX: (*CPU).SBX,
+	DCM: (*CPU).DCM,
+	ISB: (*CPU).ISB,
+	SLO: (*CPU).SLO,
+	RLA: (*CPU).RLA,
+	SRE: (*CPU).SRE,
+	RRA: (*CPU).RRA,
+	ANC: (*CPU).ANC,
+	ALR: (*CPU).ALR,
+	ARR: (*CPU).ARR,
+	XAA: (*CPU).XAA,
+	LXA: (*CPU).LXA,
+	AHX: (*CPU).AHX,
+	TAS: (*CPU).TAS,
+	LAS: (*CPU).LAS,
+	SHX: (*CPU).SHX,
+	SHY: (*CPU).SHY,
+	KIL: (*CPU).KIL,
+}
+
 func (o opcode) String() string {
-	return fmt.Sprintf("{%s, %s}", o.name, modenames[o.mode])
+	return "{" + o.name + ", " + modenames[o.mode] + "}"
+}
+
+// asmKey identifies an instruction in one addressing mode.
+type asmKey struct {
+	name string
+	mode uint8
+}
+
+// asmOpcodes maps instructions back to their opcodes. See Opcode.
+var asmOpcodes = func() map[asmKey]uint8 {
+	m := make(map[asmKey]uint8)
+	for i := 0xFF; i >= 0; i-- {
+		op, ok := opcodes[uint8(i)]
+		if !ok {
+			continue
+		}
+		mode := op.mode
+		if mode == ZERO_PAGE_X_BUT_Y {
+			mode = ZERO_PAGE_Y
+		}
+		m[asmKey{op.name, mode}] = uint8(i)
+	}
+	m[asmKey{"NOP", IMPLICIT}] = 0xEA
+	return m
+}()
+
+// Opcode returns the opcode for the instruction name, like "LDA", in
+// addressing mode, for assemblers. Where several opcodes do the same
+// thing, as the undocumented NOPs do, it returns the documented one,
+// or else the lowest.
+func Opcode(name string, mode uint8) (uint8, bool) {
+	op, ok := asmOpcodes[asmKey{name, mode}]
+	return op, ok
 }
 
 var opcodes map[uint8]opcode = map[uint8]opcode{
@@ -204,28 +329,34 @@ var opcodes map[uint8]opcode = map[uint8]opcode{
 	0x56: opcode{LSR, "LSR", ZERO_PAGE_X, 2, 6},
 	0x4E: opcode{LSR, "LSR", ABSOLUTE, 3, 6},
 	0x5E: opcode{LSR, "LSR", ABSOLUTE_X, 3, 7},
-	0x04: opcode{NOP, "NOP", ZERO_PAGE, 2, 2},   // undocumented
-	0x44: opcode{NOP, "NOP", ZERO_PAGE, 2, 2},   // undocumented
-	0x64: opcode{NOP, "NOP", ZERO_PAGE, 2, 2},   // undocumented
-	0x0c: opcode{NOP, "NOP", ABSOLUTE, 2, 2},    // undocumented
-	0x14: opcode{NOP, "NOP", ZERO_PAGE_X, 2, 2}, // undocumented
-	0x34: opcode{NOP, "NOP", ZERO_PAGE_X, 2, 2}, // undocumented
-	0x54: opcode{NOP, "NOP", ZERO_PAGE_X, 2, 2}, // undocumented
-	0x74: opcode{NOP, "NOP", ZERO_PAGE_X, 2, 2}, // undocumented
-	0xD4: opcode{NOP, "NOP", ZERO_PAGE_X, 2, 2}, // undocumented
-	0xF4: opcode{NOP, "NOP", ZERO_PAGE_X, 2, 2}, // undocumented
+	0x04: opcode{NOP, "NOP", ZERO_PAGE, 2, 3},   // undocumented
+	0x44: opcode{NOP, "NOP", ZERO_PAGE, 2, 3},   // undocumented
+	0x64: opcode{NOP, "NOP", ZERO_PAGE, 2, 3},   // undocumented
+	0x0c: opcode{NOP, "NOP", ABSOLUTE, 3, 4},    // undocumented
+	0x14: opcode{NOP, "NOP", ZERO_PAGE_X, 2, 4}, // undocumented
+	0x34: opcode{NOP, "NOP", ZERO_PAGE_X, 2, 4}, // undocumented
+	0x54: opcode{NOP, "NOP", ZERO_PAGE_X, 2, 4}, // undocumented
+	0x74: opcode{NOP, "NOP", ZERO_PAGE_X, 2, 4}, // undocumented
+	0xD4: opcode{NOP, "NOP", ZERO_PAGE_X, 2, 4}, // undocumented
+	0xF4: opcode{NOP, "NOP", ZERO_PAGE_X, 2, 4}, // undocumented
 	0xEA: opcode{NOP, "NOP", IMPLICIT, 1, 2},
-	0x1A: opcode{NOP, "NOP", IMPLICIT, 2, 2},   // undocumented
-	0x3A: opcode{NOP, "NOP", IMPLICIT, 2, 2},   // undocumented
-	0x5A: opcode{NOP, "NOP", IMPLICIT, 2, 2},   // undocumented
-	0xDA: opcode{NOP, "NOP", IMPLICIT, 2, 2},   // undocumented
-	0x80: opcode{NOP, "NOP", IMPLICIT, 2, 2},   // undocumented
-	0x1C: opcode{NOP, "NOP", ABSOLUTE_X, 2, 2}, // undocumented
-	0x3C: opcode{NOP, "NOP", ABSOLUTE_X, 2, 2}, // undocumented
-	0x5C: opcode{NOP, "NOP", ABSOLUTE_X, 2, 2}, // undocumented
-	0x7C: opcode{NOP, "NOP", ABSOLUTE_X, 2, 2}, // undocumented
-	0xDC: opcode{NOP, "NOP", ABSOLUTE_X, 2, 2}, // undocumented
-	0xFC: opcode{NOP, "NOP", ABSOLUTE_X, 2, 2}, // undocumented
+	0x1A: opcode{NOP, "NOP", IMPLICIT, 1, 2},                            // undocumented
+	0x3A: opcode{NOP, "NOP", IMPLICIT, 1, 2},                            // undocumented
+	0x5A: opcode{NOP, "NOP", IMPLICIT, 1, 2},                            // undocumented
+	0x7A: opcode{NOP, "NOP", IMPLICIT, 1, 2},                            // undocumented
+	0xDA: opcode{NOP, "NOP", IMPLICIT, 1, 2},                            // undocumented
+	0xFA: opcode{NOP, "NOP", IMPLICIT, 1, 2},                            // undocumented
+	0x80: opcode{NOP, "NOP", IMMEDIATE, 2, 2},                           // undocumented
+	0x82: opcode{NOP, "NOP", IMMEDIATE, 2, 2},                           // undocumented
+	0x89: opcode{NOP, "NOP", IMMEDIATE, 2, 2},                           // undocumented
+	0xC2: opcode{NOP, "NOP", IMMEDIATE, 2, 2},                           // undocumented
+	0xE2: opcode{NOP, "NOP", IMMEDIATE, 2, 2},                           // undocumented
+	0x1C: opcode{NOP, "NOP", ABSOLUTE_X, 3, 4 /* +1 if page crossed */}, // undocumented
+	0x3C: opcode{NOP, "NOP", ABSOLUTE_X, 3, 4 /* +1 if page crossed */}, // undocumented
+	0x5C: opcode{NOP, "NOP", ABSOLUTE_X, 3, 4 /* +1 if page crossed */}, // undocumented
+	0x7C: opcode{NOP, "NOP", ABSOLUTE_X, 3, 4 /* +1 if page crossed */}, // undocumented
+	0xDC: opcode{NOP, "NOP", ABSOLUTE_X, 3, 4 /* +1 if page crossed */}, // undocumented
+	0xFC: opcode{NOP, "NOP", ABSOLUTE_X, 3, 4 /* +1 if page crossed */}, // undocumented
 	0x09: opcode{ORA, "ORA", IMMEDIATE, 2, 2},
 	0x05: opcode{ORA, "ORA", ZERO_PAGE, 2, 3},
 	0x15: opcode{ORA, "ORA", ZERO_PAGE_X, 2, 4},
@@ -286,11 +417,12 @@ var opcodes map[uint8]opcode = map[uint8]opcode{
 	0xBF: opcode{LAX, "LAX", ABSOLUTE_Y, 3, 4},
 	0xAF: opcode{LAX, "LAX", ABSOLUTE, 3, 4},
 	0xB7: opcode{LAX, "LAX", ZERO_PAGE_Y, 2, 4},
-	0xA7: opcode{LAX, "LAX", ZERO_PAGE_Y, 2, 3},
-	0x83: opcode{SAX, "SAX", IMMEDIATE, 2, 2},
+	0xA7: opcode{LAX, "LAX", ZERO_PAGE, 2, 3},
+	0x83: opcode{SAX, "SAX", INDIRECT_X, 2, 6},
 	0x87: opcode{SAX, "SAX", ZERO_PAGE, 2, 3},
-	0x8f: opcode{SAX, "SAX", ABSOLUTE, 2, 4},
+	0x8F: opcode{SAX, "SAX", ABSOLUTE, 3, 4},
 	0x97: opcode{SAX, "SAX", ZERO_PAGE_X_BUT_Y, 2, 4},
+	0xCB: opcode{SBX, "SBX", IMMEDIATE, 2, 2},
 	0xCF: opcode{DCM, "DCM", ABSOLUTE, 3, 6},
 	0xDF: opcode{DCM, "DCM", ABSOLUTE_X, 3, 7},
 	0xDB: opcode{DCM, "DCM", ABSOLUTE_Y, 3, 7},
@@ -312,4 +444,49 @@ var opcodes map[uint8]opcode = map[uint8]opcode{
 	0x17: opcode{SLO, "SLO", ZERO_PAGE_X, 2, 6},
 	0x03: opcode{SLO, "SLO", INDIRECT_X, 2, 8},
 	0x13: opcode{SLO, "SLO", INDIRECT_Y, 2, 8},
+	0x2F: opcode{RLA, "RLA", ABSOLUTE, 3, 6},
+	0x3F: opcode{RLA, "RLA", ABSOLUTE_X, 3, 7},
+	0x3B: opcode{RLA, "RLA", ABSOLUTE_Y, 3, 7},
+	0x27: opcode{RLA, "RLA", ZERO_PAGE, 2, 5},
+	0x37: opcode{RLA, "RLA", ZERO_PAGE_X, 2, 6},
+	0x23: opcode{RLA, "RLA", INDIRECT_X, 2, 8},
+	0x33: opcode{RLA, "RLA", INDIRECT_Y, 2, 8},
+	0x4F: opcode{SRE, "SRE", ABSOLUTE, 3, 6},
+	0x5F: opcode{SRE, "SRE", ABSOLUTE_X, 3, 7},
+	0x5B: opcode{SRE, "SRE", ABSOLUTE_Y, 3, 7},
+	0x47: opcode{SRE, "SRE", ZERO_PAGE, 2, 5},
+	0x57: opcode{SRE, "SRE", ZERO_PAGE_X, 2, 6},
+	0x43: opcode{SRE, "SRE", INDIRECT_X, 2, 8},
+	0x53: opcode{SRE, "SRE", INDIRECT_Y, 2, 8},
+	0x6F: opcode{RRA, "RRA", ABSOLUTE, 3, 6},
+	0x7F: opcode{RRA, "RRA", ABSOLUTE_X, 3, 7},
+	0x7B: opcode{RRA, "RRA", ABSOLUTE_Y, 3, 7},
+	0x67: opcode{RRA, "RRA", ZERO_PAGE, 2, 5},
+	0x77: opcode{RRA, "RRA", ZERO_PAGE_X, 2, 6},
+	0x63: opcode{RRA, "RRA", INDIRECT_X, 2, 8},
+	0x73: opcode{RRA, "RRA", INDIRECT_Y, 2, 8},
+	0x0B: opcode{ANC, "ANC", IMMEDIATE, 2, 2},
+	0x2B: opcode{ANC, "ANC", IMMEDIATE, 2, 2},
+	0x4B: opcode{ALR, "ALR", IMMEDIATE, 2, 2},
+	0x6B: opcode{ARR, "ARR", IMMEDIATE, 2, 2},
+	0x8B: opcode{XAA, "XAA", IMMEDIATE, 2, 2},
+	0xAB: opcode{LXA, "LXA", IMMEDIATE, 2, 2},
+	0x9F: opcode{AHX, "AHX", ABSOLUTE_Y, 3, 5},
+	0x93: opcode{AHX, "AHX", INDIRECT_Y, 2, 6},
+	0x9B: opcode{TAS, "TAS", ABSOLUTE_Y, 3, 5},
+	0xBB: opcode{LAS, "LAS", ABSOLUTE_Y, 3, 4 /* +1 if page crossed */},
+	0x9E: opcode{SHX, "SHX", ABSOLUTE_Y, 3, 5},
+	0x9C: opcode{SHY, "SHY", ABSOLUTE_X, 3, 5},
+	0x02: opcode{KIL, "KIL", IMPLICIT, 1, 2},
+	0x12: opcode{KIL, "KIL", IMPLICIT, 1, 2},
+	0x22: opcode{KIL, "KIL", IMPLICIT, 1, 2},
+	0x32: opcode{KIL, "KIL", IMPLICIT, 1, 2},
+	0x42: opcode{KIL, "KIL", IMPLICIT, 1, 2},
+	0x52: opcode{KIL, "KIL", IMPLICIT, 1, 2},
+	0x62: opcode{KIL, "KIL", IMPLICIT, 1, 2},
+	0x72: opcode{KIL, "KIL", IMPLICIT, 1, 2},
+	0x92: opcode{KIL, "KIL", IMPLICIT, 1, 2},
+	0xB2: opcode{KIL, "KIL", IMPLICIT, 1, 2},
+	0xD2: opcode{KIL, "KIL", IMPLICIT, 1, 2},
+	0xF2: opcode{KIL, "KIL", IMPLICIT, 1, 2},
 }
@@ -0,0 +1,106 @@
+package mos6502
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PROFILE_BUCKET is the size of the address ranges a Profile counts
+// instructions in. A hot loop usually fits in one or two.
+const PROFILE_BUCKET = 16
+
+// Profile counts the instructions the CPU has run, by opcode and by
+// where they are. See SetProfiling.
+type Profile struct {
+	Opcodes [256]uint64                       // Runs of each opcode
+	Buckets [MEM_SIZE / PROFILE_BUCKET]uint64 // Runs in each PROFILE_BUCKET bytes, from $0000
+}
+
+// HotSpot is a count for an opcode or address range in a Profile.
+type HotSpot struct {
+	Key   uint16 // The opcode, or the start of the range
+	Count uint64
+}
+
+// SetProfiling starts counting instructions afresh, or stops if on is
+// false. It slows the CPU a little.
+func (c *CPU) SetProfiling(on bool) {
+	c.profile = nil
+	if on {
+		c.profile = &Profile{}
+	}
+}
+
+// Profile returns a copy of the counts since profiling started, or
+// nil if it's off.
+func (c *CPU) Profile() *Profile {
+	if c.profile == nil {
+		return nil
+	}
+	p := *c.profile
+	return &p
+}
+
+// count counts the instruction with opcode op at instPC.
+func (p *Profile) count(op uint8, pc uint16) {
+	p.Opcodes[op]++
+	p.Buckets[pc/PROFILE_BUCKET]++
+}
+
+// top returns the n biggest non-zero counts, biggest first, with
+// their keys scaled by scale.
+func top(counts []uint64, n int, scale uint16) []HotSpot {
+	var hs []HotSpot
+	for i, c := range counts {
+		if c != 0 {
+			hs = append(hs, HotSpot{uint16(i) * scale, c})
+		}
+	}
+	sort.SliceStable(hs, func(i, j int) bool { return hs[i].Count > hs[j].Count })
+	if len(hs) > n {
+		hs = hs[:n]
+	}
+	return hs
+}
+
+// TopOpcodes returns the n most run opcodes.
+func (p *Profile) TopOpcodes(n int) []HotSpot {
+	return top(p.Opcodes[:], n, 1)
+}
+
+// HotSpots returns the n address ranges where the most instructions
+// ran, each PROFILE_BUCKET bytes long.
+func (p *Profile) HotSpots(n int) []HotSpot {
+	return top(p.Buckets[:], n, PROFILE_BUCKET)
+}
+
+// Total returns the number of instructions counted.
+func (p *Profile) Total() uint64 {
+	var t uint64
+	for _, c := range p.Opcodes {
+		t += c
+	}
+	return t
+}
+
+// String reports the ten most run opcodes and the ten hottest
+// address ranges.
+func (p *Profile) String() string {
+	var sb strings.Builder
+	total := float64(p.Total())
+	if total == 0 {
+		total = 1
+	}
+
+	fmt.Fprintf(&sb, "%d instructions\nTop opcodes:\n", p.Total())
+	for _, h := range p.TopOpcodes(10) {
+		op := opcodes[uint8(h.Key)]
+		fmt.Fprintf(&sb, "  $%02X %s %-11s %10d %5.1f%%\n", h.Key, op.name, modenames[op.mode], h.Count, 100*float64(h.Count)/total)
+	}
+	sb.WriteString("Hot spots:\n")
+	for _, h := range p.HotSpots(10) {
+		fmt.Fprintf(&sb, "  $%04X-$%04X %10d %5.1f%%\n", h.Key, h.Key+PROFILE_BUCKET-1, h.Count, 100*float64(h.Count)/total)
+	}
+	return sb.String()
+}
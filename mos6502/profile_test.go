@@ -0,0 +1,45 @@
+package mos6502
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	c := New(NewMem())
+	c.LoadMem(0x0600, []uint8{
+		0xA2, 0x05, // LDX #$05
+		0xCA,       // loop: DEX
+		0xD0, 0xFD, // BNE loop
+		0xEA, // NOP
+	})
+	c.SetPC(0x0600)
+
+	if c.Profile() != nil {
+		t.Errorf("Profile() isn't nil before profiling starts")
+	}
+	c.SetProfiling(true)
+	for i := 0; i < 12; i++ {
+		c.Step()
+	}
+
+	p := c.Profile()
+	if got := p.Total(); got != 12 {
+		t.Errorf("Total() = %d, wanted 12", got)
+	}
+	want := []HotSpot{{0xCA, 5}, {0xD0, 5}}
+	if got := p.TopOpcodes(2); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("TopOpcodes(2) = %v, wanted %v", got, want)
+	}
+	if got := p.HotSpots(5); len(got) != 1 || got[0] != (HotSpot{0x0600, 12}) {
+		t.Errorf("HotSpots(5) = %v, wanted [{0x0600 12}]", got)
+	}
+	if s := p.String(); !strings.Contains(s, "$CA DEX") || !strings.Contains(s, "$0600-$060F") {
+		t.Errorf("String() = %q, missing the top opcode or hot spot", s)
+	}
+
+	c.SetProfiling(false)
+	if c.Profile() != nil {
+		t.Errorf("Profile() isn't nil after profiling stopped")
+	}
+}
@@ -0,0 +1,133 @@
+package mos6502
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SingleStepTest is one test vector from the SingleStepTests/65x02
+// project (https://github.com/SingleStepTests/65x02), which has
+// 10,000 of them for each opcode, one JSON file per opcode. Each
+// gives the machine state before and after a single instruction, and
+// every bus access the instruction makes.
+type SingleStepTest struct {
+	Name    string
+	Initial SingleStepState
+	Final   SingleStepState
+	Cycles  []SingleStepCycle
+}
+
+// SingleStepState is the CPU's registers and the RAM the test cares
+// about. All other RAM is zero.
+type SingleStepState struct {
+	PC         uint16
+	S, A, X, Y uint8
+	P          uint8
+	RAM        [][2]uint16 // [address, value] pairs
+}
+
+// SingleStepCycle is one bus access, stored as [address, value,
+// "read" or "write"].
+type SingleStepCycle struct {
+	Addr  uint16
+	Val   uint8
+	Write bool
+}
+
+func (sc *SingleStepCycle) UnmarshalJSON(b []byte) error {
+	var v []interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if len(v) != 3 {
+		return fmt.Errorf("bus cycle %s: want [address, value, kind]", b)
+	}
+	addr, aok := v[0].(float64)
+	val, vok := v[1].(float64)
+	kind, kok := v[2].(string)
+	if !aok || !vok || !kok || (kind != "read" && kind != "write") {
+		return fmt.Errorf("bus cycle %s: want [address, value, kind]", b)
+	}
+	*sc = SingleStepCycle{uint16(addr), uint8(val), kind == "write"}
+	return nil
+}
+
+func (sc SingleStepCycle) String() string {
+	kind := "read"
+	if sc.Write {
+		kind = "write"
+	}
+	return fmt.Sprintf("%s $%04X=$%02X", kind, sc.Addr, sc.Val)
+}
+
+// LoadSingleStepTests reads a file of SingleStepTests vectors.
+func LoadSingleStepTests(r io.Reader) ([]SingleStepTest, error) {
+	var tests []SingleStepTest
+	if err := json.NewDecoder(r).Decode(&tests); err != nil {
+		return nil, fmt.Errorf("couldn't read test vectors: %w", err)
+	}
+	return tests, nil
+}
+
+// singleStepBus is flat RAM that records the CPU's accesses.
+type singleStepBus struct {
+	ram    [MEM_SIZE]uint8
+	cycles []SingleStepCycle
+}
+
+func (b *singleStepBus) Read(addr uint16) uint8 {
+	return b.ram[addr]
+}
+
+func (b *singleStepBus) Write(addr uint16, val uint8) {
+	b.ram[addr] = val
+}
+
+func (b *singleStepBus) OnRead(addr uint16, val uint8) {
+	b.cycles = append(b.cycles, SingleStepCycle{addr, val, false})
+}
+
+func (b *singleStepBus) OnWrite(addr uint16, val uint8) {
+	b.cycles = append(b.cycles, SingleStepCycle{addr, val, true})
+}
+
+// Run sets up a CPU made by newCPU (New or New2A03) with the initial
+// state, runs one instruction and compares the result with the final
+// state. If cycles is set, the bus accesses must match too. The error
+// lists every difference.
+func (t SingleStepTest) Run(newCPU func(Bus) *CPU, cycles bool) error {
+	b := &singleStepBus{}
+	for _, r := range t.Initial.RAM {
+		b.ram[r[0]] = uint8(r[1])
+	}
+	c := newCPU(b)
+	i := t.Initial
+	c.SetRegisters(Registers{A: i.A, X: i.X, Y: i.Y, P: i.P, SP: i.S, PC: i.PC})
+	c.SetBusObserver(b)
+
+	var diffs []string
+	if _, err := c.StepE(); err != nil {
+		diffs = append(diffs, err.Error())
+	}
+
+	f := t.Final
+	want := Registers{A: f.A, X: f.X, Y: f.Y, P: f.P, SP: f.S, PC: f.PC}
+	if got := c.Registers(); got != want {
+		diffs = append(diffs, fmt.Sprintf("registers %+v, wanted %+v", got, want))
+	}
+	for _, r := range f.RAM {
+		if got := b.ram[r[0]]; got != uint8(r[1]) {
+			diffs = append(diffs, fmt.Sprintf("$%04X = $%02X, wanted $%02X", r[0], got, r[1]))
+		}
+	}
+	if cycles && fmt.Sprint(b.cycles) != fmt.Sprint(t.Cycles) {
+		diffs = append(diffs, fmt.Sprintf("bus cycles %v, wanted %v", b.cycles, t.Cycles))
+	}
+
+	if len(diffs) > 0 {
+		return fmt.Errorf("%s: %s", t.Name, strings.Join(diffs, "; "))
+	}
+	return nil
+}
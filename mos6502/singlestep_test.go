@@ -0,0 +1,82 @@
+package mos6502
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var singleStepCycles = flag.Bool("singlestep_cycles", false, "Compare bus cycles, as well as state, in TestSingleStepVectors.")
+
+const singleStepSample = `[
+{"name": "a9 7f 00", "initial": {"pc": 512, "s": 253, "a": 0, "x": 0, "y": 0, "p": 38, "ram": [[512, 169], [513, 127]]},
+ "final": {"pc": 514, "s": 253, "a": 127, "x": 0, "y": 0, "p": 36, "ram": [[512, 169], [513, 127]]},
+ "cycles": [[512, 169, "read"], [513, 127, "read"]]},
+{"name": "ee 00 03", "initial": {"pc": 512, "s": 253, "a": 0, "x": 0, "y": 0, "p": 36, "ram": [[512, 238], [513, 0], [514, 3], [768, 255]]},
+ "final": {"pc": 515, "s": 253, "a": 0, "x": 0, "y": 0, "p": 38, "ram": [[768, 0]]},
+ "cycles": [[512, 238, "read"], [513, 0, "read"], [514, 3, "read"], [768, 255, "read"], [768, 255, "write"], [768, 0, "write"]]}
+]`
+
+func TestSingleStep(t *testing.T) {
+	tests, err := LoadSingleStepTests(strings.NewReader(singleStepSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tests) != 2 {
+		t.Fatalf("Loaded %d tests, wanted 2", len(tests))
+	}
+	for _, st := range tests {
+		if err := st.Run(New, true); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// A wrong expectation must be caught.
+	st := tests[1]
+	st.Cycles = st.Cycles[:5]
+	st.Final.A = 1
+	if err := st.Run(New, true); err == nil || !strings.Contains(err.Error(), "registers") || !strings.Contains(err.Error(), "bus cycles") {
+		t.Errorf("Run() = %v, wanted register and bus cycle differences", err)
+	}
+
+	if _, err := LoadSingleStepTests(strings.NewReader(`[{"cycles": [[1, 2]]}]`)); err == nil {
+		t.Errorf("Loaded a malformed bus cycle")
+	}
+}
+
+// TestSingleStepVectors runs the SingleStepTests/65x02 NMOS 6502
+// vectors. They aren't distributed with gintendo; drop the 6502/v1
+// JSON files into testdata/65x02 to run them.
+func TestSingleStepVectors(t *testing.T) {
+	files, _ := filepath.Glob("../testdata/65x02/*.json")
+	if len(files) == 0 {
+		t.Skip("SingleStepTests vectors not available")
+	}
+
+	for _, fn := range files {
+		f, err := os.Open(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests, err := LoadSingleStepTests(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", fn, err)
+		}
+
+		// Report a handful of failures per opcode at most.
+		failed := 0
+		for _, st := range tests {
+			if err := st.Run(New, *singleStepCycles); err != nil {
+				if failed++; failed <= 3 {
+					t.Errorf("%s: %v", filepath.Base(fn), err)
+				}
+			}
+		}
+		if failed > 3 {
+			t.Errorf("%s: %d of %d tests failed", filepath.Base(fn), failed, len(tests))
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package mos6502
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Symbols names addresses, for disassembly (see Disassemble).
+type Symbols map[uint16]string
+
+// LoadSymbols reads the symbols in the file at path, an FCEUX name
+// list (.nl) or a ca65 debug file (.dbg), as told by its extension.
+func LoadSymbols(path string) (Symbols, error) {
+	read := ReadNL
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".nl":
+	case ".dbg":
+		read = ReadCA65Debug
+	default:
+		return nil, fmt.Errorf("%s: unknown symbol file type; wanted .nl or .dbg", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open symbols: %w", err)
+	}
+	defer f.Close()
+
+	s, err := read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// ReadNL reads an FCEUX name list, where each line is an address, a
+// name and a comment, like "$C000#reset#Entry point". An address
+// written as $0300/10 names an array, and only its start is used.
+func ReadNL(r io.Reader) (Symbols, error) {
+	s := Symbols{}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "#")
+		addr, _, _ := strings.Cut(fields[0], "/")
+		a, err := strconv.ParseUint(strings.TrimPrefix(addr, "$"), 16, 16)
+		if err != nil || !strings.HasPrefix(addr, "$") || len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: want $address#name#comment, got %q", n, line)
+		}
+		if name := strings.TrimSpace(fields[1]); name != "" {
+			s[uint16(a)] = name
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read symbols: %w", err)
+	}
+	return s, nil
+}
+
+// ReadCA65Debug reads the labels from a ca65/ld65 debug file (made
+// with ld65 --dbgfile), along with equates for absolute addresses,
+// which are usually hardware registers. Other equates are constants,
+// not addresses, and are skipped.
+func ReadCA65Debug(r io.Reader) (Symbols, error) {
+	s := Symbols{}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		kind, attrs, _ := strings.Cut(sc.Text(), "\t")
+		if kind != "sym" {
+			continue
+		}
+
+		kv := map[string]string{}
+		for _, a := range strings.Split(attrs, ",") {
+			k, v, _ := strings.Cut(a, "=")
+			kv[k] = v
+		}
+		if kv["type"] == "equ" && kv["addrsize"] != "absolute" || kv["val"] == "" {
+			continue
+		}
+		name, err := strconv.Unquote(kv["name"])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad symbol name %q", n, kv["name"])
+		}
+		v, err := strconv.ParseUint(kv["val"], 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad value for %s: %q", n, name, kv["val"])
+		}
+		s[uint16(v)] = name
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read symbols: %w", err)
+	}
+	return s, nil
+}
@@ -0,0 +1,44 @@
+package mos6502
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadNL(t *testing.T) {
+	s, err := ReadNL(strings.NewReader("$C000#reset#Entry point\n\n$0300/10#buffer#\n$C010##no name\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Symbols{0xC000: "reset", 0x0300: "buffer"}
+	if len(s) != len(want) || s[0xC000] != "reset" || s[0x0300] != "buffer" {
+		t.Errorf("ReadNL() = %v, wanted %v", s, want)
+	}
+
+	for _, bad := range []string{"C000#reset#", "$C0G0#reset#", "$C000"} {
+		if _, err := ReadNL(strings.NewReader(bad)); err == nil {
+			t.Errorf("ReadNL(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestReadCA65Debug(t *testing.T) {
+	dbg := `version	major=2,minor=0
+sym	id=0,name="reset",addrsize=absolute,size=1,scope=0,def=1,ref=3,val=0xC000,seg=0,type=lab
+sym	id=1,name="PPUCTRL",addrsize=absolute,scope=0,def=2,val=0x2000,type=equ
+sym	id=2,name="BUTTON_A",addrsize=zeropage,scope=0,def=4,val=0x1,type=equ
+sym	id=3,name="nmi",addrsize=absolute,scope=0,def=5,type=imp
+`
+	s, err := ReadCA65Debug(strings.NewReader(dbg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Symbols{0xC000: "reset", 0x2000: "PPUCTRL"}
+	if len(s) != len(want) || s[0xC000] != "reset" || s[0x2000] != "PPUCTRL" {
+		t.Errorf("ReadCA65Debug() = %v, wanted %v", s, want)
+	}
+
+	if _, err := ReadCA65Debug(strings.NewReader("sym\tname=\"x\",val=0x10000,type=lab")); err == nil {
+		t.Errorf("ReadCA65Debug() accepted an out of range value")
+	}
+}
@@ -0,0 +1,130 @@
+package nesrom
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extensions of the files we know how to run, in the order we prefer
+// them when picking an entry from an archive.
+var romExtensions = []string{".nes", ".fds", ".nsf", ".nsfe"}
+
+// MAX_ARCHIVED_SIZE limits how much we'll decompress from an
+// archive, so that a zip bomb can't exhaust memory. It's far larger
+// than any real ROM.
+const MAX_ARCHIVED_SIZE = 32 << 20
+
+var (
+	zipMagic  = []byte{'P', 'K', 0x03, 0x04}
+	gzipMagic = []byte{0x1F, 0x8B}
+)
+
+// ReadFile returns the contents of the ROM at path and its file
+// name. Zip and gzip archives are opened transparently. From a zip,
+// the first .nes entry (or failing that, another kind of ROM) is
+// used, unless path names one explicitly as "archive.zip#entry".
+func ReadFile(path string) ([]byte, string, error) {
+	entry := ""
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		if i := strings.LastIndex(path, "#"); i >= 0 {
+			path, entry = path[:i], path[i+1:]
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't read ROM file %q: %w", path, err)
+	}
+
+	switch {
+	case bytes.HasPrefix(data, zipMagic):
+		return readZip(data, entry)
+	case bytes.HasPrefix(data, gzipMagic):
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", fmt.Errorf("couldn't open gzip file %q: %w", path, err)
+		}
+		defer zr.Close()
+
+		b, err := readArchived(zr)
+		if err != nil {
+			return nil, "", fmt.Errorf("couldn't decompress %q: %w", path, err)
+		}
+
+		name := zr.Name
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		return b, name, nil
+	}
+
+	return data, filepath.Base(path), nil
+}
+
+// readZip returns the contents of the named entry in the zip archive
+// in data, or the best ROM it holds when entry is empty.
+func readZip(data []byte, entry string) ([]byte, string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't open zip archive: %w", err)
+	}
+
+	var f *zip.File
+	if entry != "" {
+		for _, zf := range zr.File {
+			if zf.Name == entry {
+				f = zf
+				break
+			}
+		}
+		if f == nil {
+			return nil, "", fmt.Errorf("no %q in zip archive", entry)
+		}
+	} else {
+	search:
+		for _, ext := range romExtensions {
+			for _, zf := range zr.File {
+				if strings.EqualFold(filepath.Ext(zf.Name), ext) {
+					f = zf
+					break search
+				}
+			}
+		}
+		if f == nil {
+			return nil, "", fmt.Errorf("no ROMs in zip archive")
+		}
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't open %q in zip archive: %w", f.Name, err)
+	}
+	defer rc.Close()
+
+	b, err := readArchived(rc)
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't read %q from zip archive: %w", f.Name, err)
+	}
+
+	return b, filepath.Base(f.Name), nil
+}
+
+// readArchived reads all of r, up to MAX_ARCHIVED_SIZE bytes.
+func readArchived(r io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, MAX_ARCHIVED_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > MAX_ARCHIVED_SIZE {
+		return nil, fmt.Errorf("more than %d bytes", MAX_ARCHIVED_SIZE)
+	}
+	return b, nil
+}
@@ -0,0 +1,72 @@
+package nesrom
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	rom, err := os.ReadFile("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't read testdata file: %v", err)
+	}
+	dir := t.TempDir()
+
+	var zb bytes.Buffer
+	zw := zip.NewWriter(&zb)
+	for _, e := range []struct {
+		name string
+		data []byte
+	}{
+		{"readme.txt", []byte("hello")},
+		{"other.nes", []byte("other")},
+		{"sub/game.nes", rom},
+	} {
+		w, _ := zw.Create(e.name)
+		w.Write(e.data)
+	}
+	zw.Close()
+	zipFile := filepath.Join(dir, "roms.zip")
+	os.WriteFile(zipFile, zb.Bytes(), 0644)
+
+	var gb bytes.Buffer
+	gw := gzip.NewWriter(&gb)
+	gw.Write(rom)
+	gw.Close()
+	gzFile := filepath.Join(dir, "game.nes.gz")
+	os.WriteFile(gzFile, gb.Bytes(), 0644)
+
+	cases := []struct {
+		path     string
+		want     []byte
+		wantName string
+	}{
+		{"../testdata/ram_after_reset.nes", rom, "ram_after_reset.nes"},
+		{zipFile, []byte("other"), "other.nes"},
+		{zipFile + "#sub/game.nes", rom, "game.nes"},
+		{gzFile, rom, "game.nes"},
+	}
+
+	for i, tc := range cases {
+		got, name, err := ReadFile(tc.path)
+		if err != nil {
+			t.Errorf("%d: ReadFile(%q) = %v", i, tc.path, err)
+			continue
+		}
+		if !bytes.Equal(got, tc.want) || name != tc.wantName {
+			t.Errorf("%d: ReadFile(%q) returned %d bytes from %q, wanted %d bytes from %q", i, tc.path, len(got), name, len(tc.want), tc.wantName)
+		}
+	}
+
+	if _, _, err := ReadFile(zipFile + "#missing.nes"); err == nil {
+		t.Errorf("ReadFile() found a missing zip entry")
+	}
+
+	if _, err := New(zipFile + "#sub/game.nes"); err != nil {
+		t.Errorf("New() couldn't load a ROM from a zip: %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+package nesrom
+
+// knownBadHeaders lists dumps that are notorious for circulating with
+// broken headers, keyed by the CRC32 of their PRG and CHR ROM (see
+// (*ROM).CRC32, which gintendo -check_rom prints). Entries only set
+// the fields that are wrong, with their Has flags, so the rest of the
+// header is left alone. Only add hashes checked against a real dump;
+// a made up hash would never match anything. None have been yet, so
+// the table is empty and only the lookup is in place.
+var knownBadHeaders = map[uint32]CartInfo{}
+
+// KnownBadHeader returns corrected cartridge information for r if it
+// is one of a handful of dumps known to have bad headers. It's meant
+// to be consulted before DefaultCartDB so that the most common
+// problems are fixed even without a full database.
+func KnownBadHeader(r *ROM) (CartInfo, bool) {
+	ci, ok := knownBadHeaders[r.CRC32()]
+	return ci, ok
+}
@@ -0,0 +1,299 @@
+package nesrom
+
+import (
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CRC32 returns the CRC32 of the PRG and CHR ROM, which is how
+// cartridge databases identify a dump regardless of its header.
+func (r *ROM) CRC32() uint32 {
+	r.hash()
+	return r.crc32
+}
+
+// SHA1 returns the hex encoded SHA1 of the PRG and CHR ROM.
+func (r *ROM) SHA1() string {
+	r.hash()
+	return r.sha1
+}
+
+// hash works out both of the ROM's hashes the first time one is
+// asked for, in one pass, so that a lazily opened ROM is only read in
+// full once however many databases look it up. They identify the dump
+// as it was loaded, so later PRG or CHR writes don't change them.
+func (r *ROM) hash() {
+	if r.hashed {
+		return
+	}
+	c, s := crc32.NewIEEE(), sha1.New()
+	w := io.MultiWriter(c, s)
+	r.writePRG(w)
+	r.writeCHR(w)
+	r.crc32, r.sha1, r.hashed = c.Sum32(), strings.ToUpper(hex.EncodeToString(s.Sum(nil))), true
+}
+
+// CartInfo is what a cartridge database knows about a dump.
+type CartInfo struct {
+	Name       string
+	Mapper     uint16
+	Submapper  uint8
+	Mirroring  uint8 // A MIRROR_XXX constant, only used if HasMirroring
+	PrgRAMSize uint32
+	ChrRAMSize uint32
+	Battery    bool
+	Region     uint8 // NTSC, PAL, MULTI_REGION or DENDY, only used if HasRegion
+
+	// Which of the fields above are set. Entries correcting a
+	// single problem leave the rest of the header alone.
+	HasMapper    bool // Mapper and Submapper
+	HasMirroring bool
+	HasRAM       bool // PrgRAMSize, ChrRAMSize and Battery
+	HasRegion    bool
+}
+
+// CartDB maps ROM hashes to cartridge information.
+type CartDB struct {
+	byCRC  map[uint32]CartInfo
+	bySHA1 map[string]CartInfo
+}
+
+// The built in database. It ships empty: fill cartdb.xml with
+// entries in NesCartDB's format to bake them into the binary.
+//
+//go:embed cartdb.xml
+var embeddedCartDB string
+
+// DefaultCartDB holds the built in database plus anything added with
+// (*CartDB).Load. mappers.Load consults it for every ROM.
+var DefaultCartDB = mustParseCartDB(embeddedCartDB)
+
+func mustParseCartDB(s string) *CartDB {
+	db := &CartDB{byCRC: map[uint32]CartInfo{}, bySHA1: map[string]CartInfo{}}
+	if err := db.Read(strings.NewReader(s)); err != nil {
+		panic(fmt.Sprintf("built in cartridge database is broken: %v", err))
+	}
+	return db
+}
+
+// The subset of the NesCartDB XML format we use.
+// https://nescartdb.com
+type xmlDatabase struct {
+	Games []struct {
+		Name       string `xml:"name,attr"`
+		Cartridges []struct {
+			CRC    string `xml:"crc,attr"`
+			System string `xml:"system,attr"`
+			SHA1   string `xml:"sha1,attr"`
+			Board  struct {
+				Mapper    string `xml:"mapper,attr"`
+				Submapper string `xml:"submapper,attr"`
+				WRAM      []struct {
+					Size    string `xml:"size,attr"`
+					Battery string `xml:"battery,attr"`
+				} `xml:"wram"`
+				VRAM []struct {
+					Size string `xml:"size,attr"`
+				} `xml:"vram"`
+				Pad *struct {
+					H string `xml:"h,attr"`
+					V string `xml:"v,attr"`
+				} `xml:"pad"`
+			} `xml:"board"`
+		} `xml:"cartridge"`
+	} `xml:"game"`
+}
+
+// cartDBSystems maps NesCartDB's system names to regions.
+var cartDBSystems = map[string]uint8{
+	"NES-NTSC":  NTSC,
+	"Famicom":   NTSC,
+	"NES-PAL":   PAL,
+	"NES-PAL-A": PAL,
+	"NES-PAL-B": PAL,
+	"Dendy":     DENDY,
+}
+
+// parseSize understands NesCartDB sizes such as "8k".
+func parseSize(s string) (uint32, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mult := uint64(1)
+	if strings.HasSuffix(strings.ToLower(s), "k") {
+		mult = 1024
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	return uint32(n * mult), err
+}
+
+// Load adds the entries in the NesCartDB XML file at path to db.
+func (db *CartDB) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("couldn't open cartridge database: %w", err)
+	}
+	defer f.Close()
+
+	return db.Read(f)
+}
+
+// Read adds the entries in NesCartDB XML format from r to db.
+func (db *CartDB) Read(r io.Reader) error {
+	var x xmlDatabase
+	if err := xml.NewDecoder(r).Decode(&x); err != nil && err != io.EOF {
+		return fmt.Errorf("couldn't parse cartridge database: %w", err)
+	}
+
+	for _, g := range x.Games {
+		for _, c := range g.Cartridges {
+			b := c.Board
+			mapper, err := strconv.ParseUint(b.Mapper, 10, 12)
+			if err != nil {
+				return fmt.Errorf("%s: bad mapper %q", g.Name, b.Mapper)
+			}
+			ci := CartInfo{Name: g.Name, Mapper: uint16(mapper), HasMapper: true, HasRAM: true}
+			if b.Submapper != "" {
+				sm, err := strconv.ParseUint(b.Submapper, 10, 4)
+				if err != nil {
+					return fmt.Errorf("%s: bad submapper %q", g.Name, b.Submapper)
+				}
+				ci.Submapper = uint8(sm)
+			}
+
+			for _, w := range b.WRAM {
+				s, err := parseSize(w.Size)
+				if err != nil {
+					return fmt.Errorf("%s: bad wram size %q", g.Name, w.Size)
+				}
+				ci.PrgRAMSize += s
+				ci.Battery = ci.Battery || w.Battery == "1"
+			}
+			for _, v := range b.VRAM {
+				s, err := parseSize(v.Size)
+				if err != nil {
+					return fmt.Errorf("%s: bad vram size %q", g.Name, v.Size)
+				}
+				ci.ChrRAMSize += s
+			}
+			if b.Pad != nil {
+				// A soldered V pad gives vertical mirroring
+				ci.HasMirroring = true
+				ci.Mirroring = MIRROR_HORIZONTAL
+				if b.Pad.V == "1" {
+					ci.Mirroring = MIRROR_VERTICAL
+				}
+			}
+
+			if reg, ok := cartDBSystems[c.System]; ok {
+				ci.HasRegion = true
+				ci.Region = reg
+			}
+
+			if crc, err := strconv.ParseUint(c.CRC, 16, 32); err == nil {
+				db.byCRC[uint32(crc)] = ci
+			}
+			if c.SHA1 != "" {
+				db.bySHA1[strings.ToUpper(c.SHA1)] = ci
+			}
+		}
+	}
+
+	return nil
+}
+
+// Lookup returns the database entry for r, preferring a SHA1 match.
+func (db *CartDB) Lookup(r *ROM) (CartInfo, bool) {
+	if ci, ok := db.bySHA1[r.SHA1()]; ok {
+		return ci, true
+	}
+	ci, ok := db.byCRC[r.CRC32()]
+	return ci, ok
+}
+
+// ramShift converts a RAM size into an NES 2.0 shift count, where the
+// size is 64 << count and 0 means none.
+func ramShift(size uint32) uint8 {
+	if size < 128 {
+		return 0
+	}
+	return uint8(bits.Len32(size-1)) - 6
+}
+
+// Override corrects r's header with the fields ci sets, leaving the
+// rest as the dump had them. Mapper, RAM and region corrections need
+// NES 2.0, so the header is converted first, keeping what it says.
+func (r *ROM) Override(ci CartInfo) {
+	h := r.h
+	if ci.HasMapper || ci.HasRAM || ci.HasRegion {
+		r.toNES2()
+	}
+
+	if ci.HasMapper {
+		h.flags6 = uint8(ci.Mapper&0x0F)<<4 | h.flags6&0x0F
+		h.flags7 = uint8(ci.Mapper&0xF0) | h.flags7&0x0F
+		h.flags8 = ci.Submapper<<4 | uint8(ci.Mapper>>8)&0x0F
+	}
+	if ci.HasMirroring {
+		h.setMirroring(ci.Mirroring)
+	}
+	if ci.HasRAM {
+		h.setRAM(ci.PrgRAMSize, ci.ChrRAMSize, ci.Battery)
+	}
+	if ci.HasRegion {
+		h.flags12 = h.flags12&^0x03 | ci.Region
+		r.region = ci.Region
+	}
+}
+
+// HeaderBytes returns the 16 byte header of a cartridge with
+// prgBlocks 16KB blocks of PRG ROM and chrBlocks 8KB blocks of CHR
+// ROM, and the mapper, mirroring and battery that ci gives, for tools
+// and tests that build ROM images. It's an iNES header unless ci
+// needs NES 2.0: a mapper over 255, a submapper, or RAM sizes or a
+// region (HasRAM or HasRegion). Other flags, such as TRAINER, can be
+// or'd into the bytes afterwards.
+func HeaderBytes(prgBlocks, chrBlocks uint8, ci CartInfo) []byte {
+	h := &header{constant: "NES\x1A", prgSize: prgBlocks, chrSize: chrBlocks}
+	h.setMirroring(ci.Mirroring)
+	if ci.Battery {
+		h.flags6 |= BATTERY_BACKED_SRAM
+	}
+
+	ci.HasMapper = ci.Mapper > 0xFF || ci.Submapper != 0
+	if !ci.HasMapper {
+		h.flags6 |= uint8(ci.Mapper&0x0F) << 4
+		h.flags7 = uint8(ci.Mapper & 0xF0)
+	}
+	ci.HasMirroring = false
+	(&ROM{h: h}).Override(ci)
+
+	return h.bytes()
+}
+
+// toNES2 rewrites an iNES header as the equivalent NES 2.0 one.
+func (r *ROM) toNES2() {
+	h := r.h
+	if h.isNES2Format() {
+		return
+	}
+
+	mapper, prgRAM, chrRAM := h.mapperNum(), h.prgRAMSize(), h.chrRAMSize()
+	h.flags6 = uint8(mapper&0x0F)<<4 | h.flags6&0x0F
+	h.flags7 = uint8(mapper&0xF0) | 0x08 | h.flags7&0x03
+	h.flags8 = uint8(mapper>>8) & 0x0F
+	h.setRAM(prgRAM, chrRAM, h.flags6&BATTERY_BACKED_SRAM != 0)
+	// iNES headers may have junk in these bytes. The region we
+	// settled on moves into the NES 2.0 timing byte.
+	h.flags9, h.flags12, h.flags13, h.flags14, h.flags15 = 0, r.region&0x03, 0, 0, 0
+}
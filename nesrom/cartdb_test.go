@@ -0,0 +1,171 @@
+package nesrom
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCartDBOverride(t *testing.T) {
+	r, err := New("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+	// Simulate a "DiskDude!" header
+	r.h.flags7 = 0x40
+	r.h.flags12, r.h.flags13, r.h.flags14, r.h.flags15 = 'u', 'd', 'e', '!'
+
+	db := &CartDB{byCRC: map[uint32]CartInfo{}, bySHA1: map[string]CartInfo{}}
+	xml := fmt.Sprintf(`<database>
+  <game name="Test">
+    <cartridge system="NES-PAL" crc="%08X" sha1="">
+      <board mapper="4">
+        <wram size="8k" battery="1"/>
+        <vram size="8k"/>
+        <pad h="0" v="1"/>
+      </board>
+    </cartridge>
+  </game>
+</database>`, r.CRC32())
+	if err := db.Read(strings.NewReader(xml)); err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	ci, ok := db.Lookup(r)
+	if !ok {
+		t.Fatalf("Lookup() didn't find the ROM by CRC")
+	}
+	if ci.Name != "Test" || ci.Mapper != 4 || ci.PrgRAMSize != 8192 || !ci.Battery || ci.ChrRAMSize != 8192 || ci.Mirroring != MIRROR_VERTICAL || !ci.HasRegion || ci.Region != PAL {
+		t.Errorf("Got %+v", ci)
+	}
+
+	r.Override(ci)
+	if r.MapperNum() != 4 || r.MirroringMode() != MIRROR_VERTICAL || !r.HasSaveRAM() || r.PrgRAMSize() != 8192 || r.ChrRAMSize() != 8192 {
+		t.Errorf("After Override() got mapper %d, mirroring %d, battery %t, prg ram %d, chr ram %d", r.MapperNum(), r.MirroringMode(), r.HasSaveRAM(), r.PrgRAMSize(), r.ChrRAMSize())
+	}
+	if r.Region() != PAL || r.h.region() != PAL {
+		t.Errorf("After Override() got region %d (header %d), wanted %d", r.Region(), r.h.region(), PAL)
+	}
+}
+
+func TestRAMShift(t *testing.T) {
+	cases := []struct {
+		size uint32
+		want uint8
+	}{
+		{0, 0},
+		{128, 1},
+		{2048, 5},
+		{8192, 7},
+		{32768, 9},
+	}
+
+	for i, tc := range cases {
+		if got := ramShift(tc.size); got != tc.want {
+			t.Errorf("%d: ramShift(%d) = %d, wanted %d", i, tc.size, got, tc.want)
+		}
+	}
+}
+
+func TestDefaultCartDB(t *testing.T) {
+	if DefaultCartDB == nil {
+		t.Fatalf("built in cartridge database didn't load")
+	}
+}
+
+func TestKnownBadHeader(t *testing.T) {
+	r, err := New("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+
+	if _, ok := KnownBadHeader(r); ok {
+		t.Errorf("KnownBadHeader() matched the testdata ROM")
+	}
+
+	knownBadHeaders[r.CRC32()] = CartInfo{Name: "Test", Mapper: 2, Mirroring: MIRROR_VERTICAL, HasMapper: true, HasMirroring: true}
+	defer delete(knownBadHeaders, r.CRC32())
+
+	ci, ok := KnownBadHeader(r)
+	if !ok {
+		t.Fatalf("KnownBadHeader() didn't match")
+	}
+	r.Override(ci)
+	if r.MapperNum() != 2 || r.MirroringMode() != MIRROR_VERTICAL {
+		t.Errorf("Got mapper %d, mirroring %d after Override, wanted 2, %d", r.MapperNum(), r.MirroringMode(), MIRROR_VERTICAL)
+	}
+}
+
+func TestOverrideFields(t *testing.T) {
+	load := func() *ROM {
+		t.Helper()
+		r, err := New("../testdata/ram_after_reset.nes")
+		if err != nil {
+			t.Fatalf("couldn't load testdata ROM: %v", err)
+		}
+		r.h.flags6 |= BATTERY_BACKED_SRAM
+		r.h.flags8 = 2 // 16KB of PRG RAM
+		return r
+	}
+
+	// Mirroring fits in an iNES header, which is otherwise left as
+	// it was.
+	r := load()
+	r.Override(CartInfo{Mirroring: MIRROR_HORIZONTAL, HasMirroring: true})
+	if r.h.isNES2Format() || r.MirroringMode() != MIRROR_HORIZONTAL || r.PrgRAMSize() != 16384 || !r.HasSaveRAM() {
+		t.Errorf("Mirroring override: NES 2.0 %t, mirroring %d, PRG RAM %d, battery %t", r.h.isNES2Format(), r.MirroringMode(), r.PrgRAMSize(), r.HasSaveRAM())
+	}
+
+	// Mapper fixes need NES 2.0, but keep the RAM the header gave.
+	r = load()
+	r.Override(CartInfo{Mapper: 0x123, Submapper: 2, HasMapper: true})
+	if !r.h.isNES2Format() || r.MapperNum() != 0x123 || r.SubmapperNum() != 2 {
+		t.Errorf("Mapper override: NES 2.0 %t, mapper 0x%x, submapper %d", r.h.isNES2Format(), r.MapperNum(), r.SubmapperNum())
+	}
+	if r.PrgRAMSize() != 16384 || !r.HasSaveRAM() || r.MirroringMode() != MIRROR_VERTICAL || r.ChrRAMSize() != 0 {
+		t.Errorf("Mapper override: PRG RAM %d, battery %t, mirroring %d, CHR RAM %d", r.PrgRAMSize(), r.HasSaveRAM(), r.MirroringMode(), r.ChrRAMSize())
+	}
+
+	// RAM fixes replace all of the RAM information.
+	r = load()
+	r.Override(CartInfo{PrgRAMSize: 2048, HasRAM: true})
+	if r.MapperNum() != 0 || r.PrgRAMSize() != 2048 || r.HasSaveRAM() {
+		t.Errorf("RAM override: mapper %d, PRG RAM %d, battery %t", r.MapperNum(), r.PrgRAMSize(), r.HasSaveRAM())
+	}
+}
+
+func TestHeaderBytesFromCartInfo(t *testing.T) {
+	cases := []struct {
+		ci       CartInfo
+		wantNES2 bool
+		wantRAM  uint32 // PRG RAM
+	}{
+		{CartInfo{}, false, 8192},
+		{CartInfo{Mapper: 4, Mirroring: MIRROR_VERTICAL, Battery: true}, false, 8192},
+		{CartInfo{Mapper: 206, Mirroring: MIRROR_FOUR_SCREEN}, false, 8192},
+		{CartInfo{Mapper: 2, Submapper: 2}, true, 8192},
+		{CartInfo{Mapper: 0x123}, true, 8192},
+		{CartInfo{Mapper: 1, PrgRAMSize: 32768, ChrRAMSize: 8192, Battery: true, HasRAM: true}, true, 32768},
+		{CartInfo{Region: PAL, HasRegion: true}, true, 8192},
+	}
+
+	for i, tc := range cases {
+		b := HeaderBytes(2, 0, tc.ci)
+		r, err := NewFromBytes(append(b, make([]byte, 2*PRG_BLOCK_SIZE)...))
+		if err != nil {
+			t.Fatalf("%d: NewFromBytes() = %v", i, err)
+		}
+		if got := r.h.isNES2Format(); got != tc.wantNES2 {
+			t.Errorf("%d: NES 2.0 = %t, wanted %t", i, got, tc.wantNES2)
+		}
+		if r.MapperNum() != tc.ci.Mapper || r.SubmapperNum() != tc.ci.Submapper || r.MirroringMode() != tc.ci.Mirroring || r.HasSaveRAM() != tc.ci.Battery {
+			t.Errorf("%d: mapper %d.%d, mirroring %d, battery %t; wanted %+v", i, r.MapperNum(), r.SubmapperNum(), r.MirroringMode(), r.HasSaveRAM(), tc.ci)
+		}
+		if r.PrgRAMSize() != tc.wantRAM || r.ChrRAMSize() != 8192 {
+			t.Errorf("%d: PRG RAM %d, CHR RAM %d; wanted %d, 8192", i, r.PrgRAMSize(), r.ChrRAMSize(), tc.wantRAM)
+		}
+		if tc.ci.HasRegion && r.Region() != tc.ci.Region {
+			t.Errorf("%d: region %d, wanted %d", i, r.Region(), tc.ci.Region)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package nesrom
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// fuzzSeeds returns small ROM files for the fuzzers to start from:
+// the test ROM, cut down, with a few header variations.
+func fuzzSeeds(f *testing.F) [][]byte {
+	b, err := os.ReadFile("../testdata/ram_after_reset.nes")
+	if err != nil {
+		f.Fatalf("couldn't read testdata file: %v", err)
+	}
+
+	// One 16KB PRG block and no CHR keeps the inputs small.
+	small := bytes.Clone(b[:16+PRG_BLOCK_SIZE])
+	small[4], small[5] = 1, 0
+
+	nes2 := bytes.Clone(small)
+	nes2[7] |= 0x08
+	nes2[10], nes2[11] = 0x77, 0x07
+
+	trained := bytes.Clone(small[:16])
+	trained[6] |= TRAINER
+	trained = append(trained, make([]byte, TRAINER_SIZE)...)
+	trained = append(trained, small[16:]...)
+
+	pc := bytes.Clone(small)
+	pc[7] |= PLAYCHOICE_10
+	pc = append(pc, make([]byte, 100)...)
+
+	return [][]byte{small, nes2, trained, pc, small[:16], []byte("NES\x1a")}
+}
+
+func FuzzNewFromBytes(f *testing.F) {
+	for _, s := range fuzzSeeds(f) {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r, err := NewFromBytes(data)
+		if err != nil {
+			return
+		}
+
+		// Anything that loads must be usable.
+		var buf bytes.Buffer
+		if err := r.Write(&buf); err != nil {
+			t.Fatalf("Write() = %v", err)
+		}
+		if buf.Len() > len(data) {
+			t.Errorf("Write() produced %d bytes from %d", buf.Len(), len(data))
+		}
+		r.CRC32()
+		r.PrgRAMSize()
+		r.ChrRAMSize()
+	})
+}
+
+func FuzzCheckHeader(f *testing.F) {
+	for _, s := range fuzzSeeds(f) {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		CheckHeader(data)
+
+		fixed, err := RepairHeader(data)
+		if err != nil {
+			return
+		}
+		if len(fixed) != len(data) {
+			t.Errorf("RepairHeader() changed the size from %d to %d", len(data), len(fixed))
+		}
+	})
+}
+
+func FuzzApplyPatch(f *testing.F) {
+	f.Add([]byte("0123456789"), []byte("PATCH\x00\x00\x02\x00\x02hiEOF"))
+	f.Add([]byte("0123456789"), []byte("PATCH\x00\x00\x02\x00\x00\x00\x04zEOF\x00\x00\x05"))
+	f.Add([]byte("0123456789"), []byte("BPS1\x8a\x8a\x80\x83\x9f\x8d\xa4\x97\xc6\x7b\x8c\xa5\x00\x00\x00\x00"))
+
+	f.Fuzz(func(t *testing.T, data, patch []byte) {
+		ApplyPatch(data, patch)
+	})
+}
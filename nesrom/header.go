@@ -1,7 +1,8 @@
-// package nesFormat implements support for the NES (iNES) ROM format
-// https://www.nesdev.org/wiki/INES, https://www.nesdev.org/wiki/NES_2.0
 package nesrom
 
+// Header parsing for both iNES and NES 2.0 ROMs.
+// https://www.nesdev.org/wiki/INES, https://www.nesdev.org/wiki/NES_2.0
+
 import (
 	"fmt"
 )
@@ -75,6 +76,38 @@ const (
 	PLAYCHOICE_10 = 0x02 // PlayChoice-10, 8 KB of Hint Screen data stored after CHR data
 )
 
+// Vs. System PPU types, from the low nibble of NES 2.0 byte 13. They
+// differ in palette and, for the RC2C05s, register layout.
+// https://www.nesdev.org/wiki/NES_2.0#Vs._System_Type
+const (
+	VS_PPU_RP2C03B = iota
+	VS_PPU_RP2C03G
+	VS_PPU_RP2C04_0001
+	VS_PPU_RP2C04_0002
+	VS_PPU_RP2C04_0003
+	VS_PPU_RP2C04_0004
+	VS_PPU_RC2C03B
+	VS_PPU_RC2C03C
+	VS_PPU_RC2C05_01
+	VS_PPU_RC2C05_02
+	VS_PPU_RC2C05_03
+	VS_PPU_RC2C05_04
+	VS_PPU_RC2C05_05
+)
+
+// Vs. System hardware types, from the high nibble of NES 2.0 byte 13.
+// Those other than VS_UNISYSTEM_NORMAL add copy protection or a
+// second console.
+const (
+	VS_UNISYSTEM_NORMAL = iota
+	VS_UNISYSTEM_RBI_BASEBALL
+	VS_UNISYSTEM_TKO_BOXING
+	VS_UNISYSTEM_SUPER_XEVIOUS
+	VS_UNISYSTEM_ICE_CLIMBER_JP
+	VS_DUAL_SYSTEM_NORMAL
+	VS_DUAL_SYSTEM_RAID_ON_BUNGELING_BAY
+)
+
 // flags9 flag identifiers
 const (
 	TV_SYSTEM = 0x01
@@ -84,11 +117,15 @@ func (h *header) String() string {
 	return fmt.Sprintf("%s, prg(%d), chr(%d), flags(%02x, %02x, %02x, %02x, %02x)", h.constant, h.prgSize, h.chrSize, h.flags6, h.flags7, h.flags8, h.flags9, h.flags10)
 }
 
-// Mirroring mode
+// Mirroring mode. Headers only ever specify the first three. The
+// rest can only be selected at runtime by mappers.
 const (
 	MIRROR_HORIZONTAL = iota
 	MIRROR_VERTICAL
 	MIRROR_FOUR_SCREEN
+	MIRROR_SINGLE_LOWER
+	MIRROR_SINGLE_UPPER
+	MIRROR_MAPPER_CONTROLLED // The mapper picks the page for each nametable
 )
 
 // mirroringMode returns an identifier indicating which mirroring mode
@@ -102,6 +139,29 @@ func (h *header) mirroringMode() uint8 {
 	return h.flags6 & MIRRORING // 0 = horizonal, 1 = vertical
 }
 
+// setMirroring sets the mirroring mode, a MIRROR_XXX constant.
+func (h *header) setMirroring(mode uint8) {
+	h.flags6 &^= IGNORE_MIRRORING | MIRRORING
+	switch mode {
+	case MIRROR_VERTICAL:
+		h.flags6 |= MIRRORING
+	case MIRROR_FOUR_SCREEN:
+		h.flags6 |= IGNORE_MIRRORING
+	}
+}
+
+// setRAM sets the RAM sizes and battery flag of an NES 2.0 header.
+// Battery backed PRG RAM is given as non-volatile.
+func (h *header) setRAM(prgRAM, chrRAM uint32, battery bool) {
+	h.flags6 &^= BATTERY_BACKED_SRAM
+	h.flags10 = ramShift(prgRAM)
+	if battery {
+		h.flags6 |= BATTERY_BACKED_SRAM
+		h.flags10 <<= 4
+	}
+	h.flags11 = ramShift(chrRAM)
+}
+
 // hasTrainer indicates whether the NES ROM contains a Trainer
 func (h *header) hasTrainer() bool {
 	return h.flags6&TRAINER == TRAINER
@@ -111,33 +171,97 @@ func (h *header) hasPlayChoice() bool {
 	return h.flags7&PLAYCHOICE_10 == PLAYCHOICE_10
 }
 
+// isVsSystem reports whether the ROM is for the Vs. System arcade
+// hardware. NES 2.0 uses the same bit as iNES, as console type 1.
+func (h *header) isVsSystem() bool {
+	return h.flags7&0x03 == VS_UNISYSTEM
+}
+
+// vsPPUType returns the Vs. System PPU (a VS_PPU_XXX value). iNES
+// headers can't say, so they get the RP2C03B, whose palette matches
+// the NES.
+func (h *header) vsPPUType() uint8 {
+	if h.isNES2Format() {
+		return h.flags13 & 0x0F
+	}
+	return VS_PPU_RP2C03B
+}
+
+// vsHardwareType returns the Vs. System hardware (a VS_XXX value).
+func (h *header) vsHardwareType() uint8 {
+	if h.isNES2Format() {
+		return h.flags13 >> 4
+	}
+	return VS_UNISYSTEM_NORMAL
+}
+
 func (h *header) hasPrgRAM() bool {
 	return h.flags6&BATTERY_BACKED_SRAM > 0
 }
 
-// PrgRAMSize returns the size of PRG RAM in 8KB units with flags8==0
-// indicating that there is a single (1) 8KB unit
-func (h *header) prgRAMSize() uint8 {
-	if h.hasPrgRAM() {
-		if h.flags8 == 0 {
-			return 1
+// prgRAMSize returns the total number of bytes of PRG RAM (volatile
+// and battery backed) on the cartridge. NES 2.0 headers give shift
+// counts for each kind in flags10. iNES headers give a count of 8KB
+// units in flags8, where 0 means 1 for compatibility, so every iNES
+// ROM gets at least 8KB.
+func (h *header) prgRAMSize() uint32 {
+	if h.isNES2Format() {
+		var s uint32
+		if v := h.flags10 & 0x0F; v != 0 {
+			s += 64 << v
+		}
+		if nv := h.flags10 >> 4; nv != 0 {
+			s += 64 << nv
+		}
+		return s
+	}
+
+	if h.flags8 == 0 {
+		return 8192
+	}
+
+	return uint32(h.flags8) * 8192
+}
+
+// chrRAMSize returns the number of bytes of CHR RAM on the
+// cartridge. NES 2.0 headers state this explicitly as a shift count
+// in flags11. iNES headers can't, so we assume the common 8KB when
+// the ROM contains no CHR ROM at all.
+func (h *header) chrRAMSize() uint32 {
+	if h.isNES2Format() {
+		if s := h.flags11 & 0x0F; s != 0 {
+			return 64 << s
 		}
+	}
 
-		return h.flags8
+	if h.chrSize == 0 {
+		return CHR_BLOCK_SIZE
 	}
 
 	return 0
 }
 
+// Regions, numbered as in the NES 2.0 CPU/PPU timing byte.
 const (
 	NTSC = iota
 	PAL
+	MULTI_REGION // Runs on either NTSC or PAL consoles
+	DENDY
 )
 
 func (h *header) tvSystem() uint8 {
 	return h.flags9 & TV_SYSTEM
 }
 
+// region returns the region the header asks for. NES 2.0 has a byte
+// for it, while iNES only has a TV system bit that few ROMs set.
+func (h *header) region() uint8 {
+	if h.isNES2Format() {
+		return h.flags12 & 0x03
+	}
+	return h.tvSystem()
+}
+
 func (h *header) isINesFormat() bool {
 	return h.constant == "NES\x1A"
 }
@@ -187,6 +311,17 @@ func (h *header) mapperNum() uint16 {
 	return uint16(mn)
 }
 
+// submapperNum returns the NES 2.0 submapper number from the upper
+// nibble of flags8. iNES headers have no such field, so they always
+// report submapper 0, which means "unspecified" for most mappers.
+func (h *header) submapperNum() uint8 {
+	if h.isNES2Format() {
+		return h.flags8 >> 4
+	}
+
+	return 0
+}
+
 func parseHeader(hbytes []byte) *header {
 	return &header{
 		constant: string(hbytes[0:4]),
@@ -201,5 +336,27 @@ func parseHeader(hbytes []byte) *header {
 		flags12:  uint8(hbytes[12]),
 		flags13:  uint8(hbytes[13]),
 		flags14:  uint8(hbytes[14]),
+		flags15:  uint8(hbytes[15]),
 	}
 }
+
+// bytes is the inverse of parseHeader, returning the 16 byte header
+// that h was parsed from (or that describes h, if it was built up
+// by hand).
+func (h *header) bytes() []byte {
+	b := make([]byte, 16)
+	copy(b[0:4], h.constant)
+	b[4] = h.prgSize
+	b[5] = h.chrSize
+	b[6] = h.flags6
+	b[7] = h.flags7
+	b[8] = h.flags8
+	b[9] = h.flags9
+	b[10] = h.flags10
+	b[11] = h.flags11
+	b[12] = h.flags12
+	b[13] = h.flags13
+	b[14] = h.flags14
+	b[15] = h.flags15
+	return b
+}
@@ -22,6 +22,19 @@ func TestParseHeader(t *testing.T) {
 	}
 }
 
+func TestHeaderBytes(t *testing.T) {
+	cases := [][]byte{
+		{0x4e, 0x45, 0x53, 0x1a, 0x02, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		{0x4e, 0x45, 0x53, 0x1a, 0x20, 0x00, 0x13, 0x48, 0x21, 0x01, 0x70, 0x07, 0x01, 0x00, 0x00, 0x01},
+	}
+
+	for i, tc := range cases {
+		if got := parseHeader(tc).bytes(); !reflect.DeepEqual(got, tc) {
+			t.Errorf("%d: Got % x, wanted % x", i, got, tc)
+		}
+	}
+}
+
 func TestNES2Format(t *testing.T) {
 	h := &header{}
 	cases := []struct {
@@ -74,6 +87,26 @@ func TestMapperNum(t *testing.T) {
 	}
 }
 
+func TestSubmapperNum(t *testing.T) {
+	cases := []struct {
+		flags7, flags8 uint8
+		want           uint8
+	}{
+		{0x00, 0x00, 0}, // iNES
+		{0x00, 0x20, 0}, // iNES, flags8 is PRG RAM size
+		{0x08, 0x00, 0}, // NES2
+		{0x08, 0x20, 2}, // NES2
+		{0x08, 0xF4, 15},
+	}
+
+	for i, tc := range cases {
+		h := &header{constant: "NES\x1A", flags7: tc.flags7, flags8: tc.flags8}
+		if got := h.submapperNum(); got != tc.want {
+			t.Errorf("%d: Got %d, want %d", i, got, tc.want)
+		}
+	}
+}
+
 func TestHasTrainer(t *testing.T) {
 	h := &header{constant: "NES\x1A"}
 	cases := []struct {
@@ -94,6 +127,32 @@ func TestHasTrainer(t *testing.T) {
 	}
 }
 
+func TestVsSystem(t *testing.T) {
+	cases := []struct {
+		flags7, flags13 uint8
+		want            bool
+		wantPPU, wantHW uint8
+	}{
+		{0x00, 0x00, false, VS_PPU_RP2C03B, VS_UNISYSTEM_NORMAL},
+		{0x01, 0x00, true, VS_PPU_RP2C03B, VS_UNISYSTEM_NORMAL},
+		{0x02, 0x00, false, VS_PPU_RP2C03B, VS_UNISYSTEM_NORMAL},
+		// iNES headers leave byte 13 unused
+		{0x01, 0x19, true, VS_PPU_RP2C03B, VS_UNISYSTEM_NORMAL},
+		{0x09, 0x19, true, VS_PPU_RC2C05_02, VS_UNISYSTEM_RBI_BASEBALL},
+		{0x09, 0x02, true, VS_PPU_RP2C04_0001, VS_UNISYSTEM_NORMAL},
+	}
+
+	for i, tc := range cases {
+		h := &header{constant: "NES\x1A", flags7: tc.flags7, flags13: tc.flags13}
+		if got := h.isVsSystem(); got != tc.want {
+			t.Errorf("%d: isVsSystem() = %t, wanted %t", i, got, tc.want)
+		}
+		if ppu, hw := h.vsPPUType(), h.vsHardwareType(); ppu != tc.wantPPU || hw != tc.wantHW {
+			t.Errorf("%d: Got PPU %d, hardware %d, wanted %d, %d", i, ppu, hw, tc.wantPPU, tc.wantHW)
+		}
+	}
+}
+
 func TestHasPlayChoice10(t *testing.T) {
 	h := &header{constant: "NES\x1A"}
 	cases := []struct {
@@ -140,13 +199,13 @@ func TestBatteryBackedSRAM(t *testing.T) {
 	cases := []struct {
 		flags6, flags8 uint8
 		want           bool
-		wantSize       uint8
+		wantSize       uint32
 	}{
-		{0, 0, false, 0},
-		{0, 16, false, 0},
-		{BATTERY_BACKED_SRAM, 0, true, 1},
-		{BATTERY_BACKED_SRAM, 1, true, 1},
-		{BATTERY_BACKED_SRAM, 16, true, 16},
+		{0, 0, false, 8192},
+		{0, 16, false, 131072},
+		{BATTERY_BACKED_SRAM, 0, true, 8192},
+		{BATTERY_BACKED_SRAM, 1, true, 8192},
+		{BATTERY_BACKED_SRAM, 16, true, 131072},
 	}
 
 	for i, tc := range cases {
@@ -157,3 +216,54 @@ func TestBatteryBackedSRAM(t *testing.T) {
 		}
 	}
 }
+
+func TestChrRAMSize(t *testing.T) {
+	h := &header{constant: "NES\x1A"}
+	cases := []struct {
+		chrSize, flags7, flags11 uint8
+		want                     uint32
+	}{
+		{1, 0x00, 0x00, 0},     // iNES, CHR ROM
+		{0, 0x00, 0x00, 8192},  // iNES, no CHR ROM
+		{0, 0x00, 0x09, 8192},  // iNES ignores flags11
+		{0, 0x08, 0x00, 8192},  // NES2 without a declared size
+		{0, 0x08, 0x09, 32768}, // NES2, 64 << 9
+		{1, 0x08, 0x07, 8192},  // NES2, CHR ROM and RAM
+		{1, 0x08, 0x70, 0},     // NES2, only CHR NVRAM
+	}
+
+	for i, tc := range cases {
+		h.chrSize = tc.chrSize
+		h.flags7 = tc.flags7
+		h.flags11 = tc.flags11
+		if got := h.chrRAMSize(); got != tc.want {
+			t.Errorf("%d: Got %d, want %d", i, got, tc.want)
+		}
+	}
+}
+
+func TestPrgRAMBytes(t *testing.T) {
+	h := &header{constant: "NES\x1A"}
+	cases := []struct {
+		flags7, flags8, flags10 uint8
+		want                    uint32
+	}{
+		{0x00, 0, 0x00, 8192},  // iNES, unspecified
+		{0x00, 1, 0x00, 8192},  // iNES, one unit
+		{0x00, 4, 0x00, 32768}, // iNES, four units
+		{0x00, 0, 0x77, 8192},  // iNES ignores flags10
+		{0x08, 0, 0x00, 0},     // NES2, no PRG RAM
+		{0x08, 0, 0x07, 8192},  // NES2, 64 << 7 volatile
+		{0x08, 0, 0x70, 8192},  // NES2, 64 << 7 battery backed
+		{0x08, 0, 0x77, 16384}, // NES2, both
+	}
+
+	for i, tc := range cases {
+		h.flags7 = tc.flags7
+		h.flags8 = tc.flags8
+		h.flags10 = tc.flags10
+		if got := h.prgRAMSize(); got != tc.want {
+			t.Errorf("%d: Got %d, want %d", i, got, tc.want)
+		}
+	}
+}
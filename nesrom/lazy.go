@@ -0,0 +1,173 @@
+package nesrom
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// LAZY_BLOCK_SIZE is the unit in which lazily opened ROMs are read.
+const LAZY_BLOCK_SIZE = 0x2000
+
+// lazySection is PRG or CHR ROM that's read from the ROM file a block
+// at a time, the first time each block is accessed.
+type lazySection struct {
+	f      io.ReaderAt
+	offset int64
+	size   int
+	blocks [][]byte // nil until read
+}
+
+func newLazySection(f io.ReaderAt, s section) *lazySection {
+	return &lazySection{
+		f:      f,
+		offset: int64(s.offset),
+		size:   s.size,
+		blocks: make([][]byte, (s.size+LAZY_BLOCK_SIZE-1)/LAZY_BLOCK_SIZE),
+	}
+}
+
+// readBlock reads block n from the file. The size of the file was
+// checked when it was opened, so a failure here means it changed or
+// went away underneath us; the block reads as zeros.
+func (l *lazySection) readBlock(n int) []byte {
+	b := make([]byte, min(LAZY_BLOCK_SIZE, l.size-n*LAZY_BLOCK_SIZE))
+	if _, err := l.f.ReadAt(b, l.offset+int64(n*LAZY_BLOCK_SIZE)); err != nil {
+		log.Printf("Couldn't read ROM block at offset %d: %v", l.offset+int64(n*LAZY_BLOCK_SIZE), err)
+	}
+	return b
+}
+
+func (l *lazySection) block(n int) []byte {
+	if l.blocks[n] == nil {
+		l.blocks[n] = l.readBlock(n)
+	}
+	return l.blocks[n]
+}
+
+func (l *lazySection) read(offset uint32) uint8 {
+	return l.block(int(offset / LAZY_BLOCK_SIZE))[offset%LAZY_BLOCK_SIZE]
+}
+
+func (l *lazySection) write(offset uint32, val uint8) {
+	l.block(int(offset / LAZY_BLOCK_SIZE))[offset%LAZY_BLOCK_SIZE] = val
+}
+
+// writeTo writes the whole section to w without keeping the blocks
+// that haven't been read yet.
+func (l *lazySection) writeTo(w io.Writer) error {
+	for n, b := range l.blocks {
+		if b == nil {
+			b = l.readBlock(n)
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Open loads the ROM file at path lazily: PRG and CHR ROM are read
+// from the file in LAZY_BLOCK_SIZE blocks as they're first used,
+// rather than copied into memory up front. That keeps start up quick
+// for multi-megabyte multicarts. The file stays open until Close is
+// called. Archives aren't supported; use New for those.
+func Open(path string) (*ROM, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open ROM file: %w", err)
+	}
+
+	r, err := openFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.path = path
+
+	return r, nil
+}
+
+func openFile(f *os.File) (*ROM, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't stat ROM file: %w", err)
+	}
+	size := int(fi.Size())
+
+	hbytes := make([]byte, 16)
+	if n, _ := f.ReadAt(hbytes, 0); n < 16 {
+		return nil, &SectionError{Section: "header", Want: 16, Have: n}
+	}
+
+	r := &ROM{h: parseHeader(hbytes), file: f}
+	if !r.h.isINesFormat() {
+		return nil, fmt.Errorf("not an iNES ROM (header starts with %q)", r.h.constant)
+	}
+	r.region = r.h.region()
+
+	trainer, prg, chr, err := r.h.layout(size)
+	if err != nil {
+		return nil, err
+	}
+
+	if trainer.size > 0 {
+		r.trainer = make([]byte, trainer.size)
+		if _, err := f.ReadAt(r.trainer, int64(trainer.offset)); err != nil {
+			return nil, fmt.Errorf("couldn't read trainer: %w", err)
+		}
+	}
+	r.lazyPRG = newLazySection(f, prg)
+	r.lazyCHR = newLazySection(f, chr)
+
+	if r.h.hasPlayChoice() {
+		off := int64(chr.offset + chr.size)
+		r.readPlayChoice(readAtMost(f, off, size-int(off), PC_INST_SIZE+PC_PROM_SIZE))
+	}
+
+	return r, nil
+}
+
+// readAtMost returns up to n of the avail bytes at off in f.
+func readAtMost(f io.ReaderAt, off int64, avail, n int) []byte {
+	b := make([]byte, min(avail, n))
+	m, _ := f.ReadAt(b, off)
+	return b[:m]
+}
+
+// Close releases the file behind a ROM loaded with Open. It's a no-op
+// for other ROMs.
+func (r *ROM) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// writePRG writes all of the PRG ROM to w.
+func (r *ROM) writePRG(w io.Writer) error {
+	if r.lazyPRG != nil {
+		return r.lazyPRG.writeTo(w)
+	}
+	_, err := w.Write(r.prg)
+	return err
+}
+
+// writeCHR writes all of the CHR ROM to w.
+func (r *ROM) writeCHR(w io.Writer) error {
+	if r.lazyCHR != nil {
+		return r.lazyCHR.writeTo(w)
+	}
+	_, err := w.Write(r.chr)
+	return err
+}
@@ -0,0 +1,109 @@
+package nesrom
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	const path = "../testdata/ram_after_reset.nes"
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("couldn't read testdata file: %v", err)
+	}
+
+	want, err := New(path)
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	defer r.Close()
+
+	for n, blk := range r.lazyPRG.blocks {
+		if blk != nil {
+			t.Errorf("PRG block %d was read by Open()", n)
+		}
+	}
+
+	for i := uint32(0); i < uint32(want.NumPrgBlocks())*PRG_BLOCK_SIZE; i += 0x1FF {
+		if got, w := r.PrgRead(i), want.PrgRead(i); got != w {
+			t.Fatalf("PrgRead(%#x) = %#x, wanted %#x", i, got, w)
+		}
+	}
+	for i := uint32(0); i < uint32(want.NumChrBlocks())*CHR_BLOCK_SIZE; i += 0xFF {
+		if got, w := r.ChrRead(i), want.ChrRead(i); got != w {
+			t.Fatalf("ChrRead(%#x) = %#x, wanted %#x", i, got, w)
+		}
+	}
+	if r.CRC32() != want.CRC32() || r.SHA1() != want.SHA1() {
+		t.Errorf("Checksums %08x/%s, wanted %08x/%s", r.CRC32(), r.SHA1(), want.CRC32(), want.SHA1())
+	}
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), b) {
+		t.Errorf("Write() produced %d bytes that differ from the %d read", buf.Len(), len(b))
+	}
+
+	r.PrgWrite(0x4001, 0xA5)
+	if got := r.PrgRead(0x4001); got != 0xA5 {
+		t.Errorf("PrgRead() after PrgWrite() = %#x, wanted 0xA5", got)
+	}
+}
+
+func TestOpenTruncated(t *testing.T) {
+	b, err := os.ReadFile("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't read testdata file: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "short.nes")
+	if err := os.WriteFile(path, b[:100], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if r, err := Open(path); err == nil {
+		r.Close()
+		t.Errorf("Open() succeeded on a truncated ROM")
+	}
+}
+
+// countingReaderAt counts the reads made through it.
+type countingReaderAt struct {
+	r     io.ReaderAt
+	reads int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	return c.r.ReadAt(p, off)
+}
+
+func TestOpenHashesOnce(t *testing.T) {
+	r, err := Open("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	defer r.Close()
+	prg, chr := &countingReaderAt{r: r.lazyPRG.f}, &countingReaderAt{r: r.lazyCHR.f}
+	r.lazyPRG.f, r.lazyCHR.f = prg, chr
+
+	// Everything that looks a ROM up when it's loaded.
+	KnownBadHeader(r)
+	DefaultCartDB.Lookup(r)
+	DefaultQuirks.Lookup(r)
+	r.CRC32()
+	r.SHA1()
+
+	if got, want := prg.reads+chr.reads, len(r.lazyPRG.blocks)+len(r.lazyCHR.blocks); got != want {
+		t.Errorf("Hashing read %d blocks, wanted each of the %d once", got, want)
+	}
+}
@@ -3,7 +3,11 @@
 package nesrom
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"strings"
 )
@@ -21,6 +25,17 @@ type ROM struct {
 	chr       []uint8         // 8192 * y bytes; y from header (stored as uint8)
 	pcInstRom []uint8         // if present (stored as uint8)
 	pcPROM    *PlayChoicePROM // if present; often missing - see PC10 ROM-Images
+	region    uint8           // NTSC, PAL, MULTI_REGION or DENDY
+	quirks    Quirks
+
+	// The hashes of PRG and CHR ROM, once hash has run
+	crc32  uint32
+	sha1   string
+	hashed bool
+
+	// Used in place of prg and chr by ROMs loaded with Open
+	file             *os.File
+	lazyPRG, lazyCHR *lazySection
 }
 
 const (
@@ -31,64 +46,211 @@ const (
 	PC_PROM_SIZE   = 32
 )
 
+// New loads the ROM stored in the file at path, which may be inside
+// a zip or gzip archive (see ReadFile).
 func New(path string) (*ROM, error) {
-	rf, err := os.Open(path)
+	data, _, err := ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't open ROM file %q: %w", path, err)
+		return nil, err
 	}
 
-	hbytes := make([]byte, 16)
-	n, err := rf.Read(hbytes)
-	if n != 16 || err != nil {
-		return nil, fmt.Errorf("couldn't read header: %w", err)
+	r, err := NewFromBytes(data)
+	if err != nil {
+		return nil, err
 	}
+	r.path = path
 
-	i, err := &ROM{path: path, h: parseHeader(hbytes)}, nil
-	if err != nil {
-		return nil, fmt.Errorf("error parsing header %w", err)
+	return r, nil
+}
+
+// ErrTruncated is matched (with errors.Is) by the *SectionError
+// returned for ROM files that are cut short.
+var ErrTruncated = errors.New("ROM file is truncated")
+
+// SectionError reports a section of a ROM file that the file is too
+// short to hold.
+type SectionError struct {
+	Section string // "header", "trainer", "PRG ROM" or "CHR ROM"
+	Offset  int    // Where the section starts in the file
+	Want    int    // The section's size according to the header
+	Have    int    // How much of it the file contains
+}
+
+func (e *SectionError) Error() string {
+	return fmt.Sprintf("%s is truncated: wanted %d bytes at offset %d, file only has %d", e.Section, e.Want, e.Offset, e.Have)
+}
+
+func (e *SectionError) Unwrap() error {
+	return ErrTruncated
+}
+
+// section is the location of part of a ROM file.
+type section struct {
+	offset, size int
+}
+
+func (s section) bytes(b []byte) []byte {
+	return b[s.offset : s.offset+s.size]
+}
+
+// layout returns where the trainer, PRG ROM and CHR ROM described by
+// h lie in a file of fileSize bytes, or a *SectionError for the
+// first of them that doesn't fit.
+func (h *header) layout(fileSize int) (trainer, prg, chr section, err error) {
+	trainer.offset = 16
+	if h.hasTrainer() {
+		trainer.size = TRAINER_SIZE
 	}
-	if i.h.hasTrainer() {
-		i.trainer = make([]byte, TRAINER_SIZE)
-		if n, err := rf.Read(i.trainer); n != TRAINER_SIZE || err != nil {
-			return nil, fmt.Errorf("error reading trainer data: %w", err)
+	prg = section{trainer.offset + trainer.size, PRG_BLOCK_SIZE * int(h.prgSize)}
+	chr = section{prg.offset + prg.size, CHR_BLOCK_SIZE * int(h.chrSize)}
+
+	for _, s := range []struct {
+		name string
+		section
+	}{{"trainer", trainer}, {"PRG ROM", prg}, {"CHR ROM", chr}} {
+		if s.offset+s.size > fileSize {
+			return trainer, prg, chr, &SectionError{Section: s.name, Offset: s.offset, Want: s.size, Have: fileSize - s.offset}
 		}
+	}
+
+	return trainer, prg, chr, nil
+}
+
+// NewFromBytes loads a ROM from an in memory copy of a ROM file. The
+// section sizes in the header are checked against the length of b
+// before anything is copied, and a *SectionError names the first
+// section that doesn't fit. PlayChoice-10 data is optional and extra
+// bytes at the end of the file are ignored; CheckHeader reports both.
+func NewFromBytes(b []byte) (*ROM, error) {
+	if len(b) < 16 {
+		return nil, &SectionError{Section: "header", Want: 16, Have: len(b)}
+	}
 
+	i := &ROM{h: parseHeader(b)}
+	if !i.h.isINesFormat() {
+		return nil, fmt.Errorf("not an iNES ROM (header starts with %q)", i.h.constant)
 	}
+	i.region = i.h.region()
 
-	s := PRG_BLOCK_SIZE * int(i.h.prgSize)
-	i.prg = make([]byte, s)
-	if n, err := rf.Read(i.prg); n != s || err != nil {
-		return nil, fmt.Errorf("error reading PRG ROM (read %d, wanted %d): %w", n, s, err)
+	trainer, prg, chr, err := i.h.layout(len(b))
+	if err != nil {
+		return nil, err
 	}
 
-	s = CHR_BLOCK_SIZE * int(i.h.chrSize)
-	i.chr = make([]byte, s)
-	if n, err := rf.Read(i.chr); n != s || err != nil {
-		return nil, fmt.Errorf("error reading CHR ROM (read %d, wanted %d): %w", n, s, err)
+	if trainer.size > 0 {
+		i.trainer = bytes.Clone(trainer.bytes(b))
 	}
+	i.prg = bytes.Clone(prg.bytes(b))
+	i.chr = bytes.Clone(chr.bytes(b))
+	off := chr.offset + chr.size
 
 	if i.h.hasPlayChoice() {
-		i.pcInstRom = make([]byte, PC_INST_SIZE)
-		if n, err := rf.Read(i.pcInstRom); n != PC_INST_SIZE || err != nil {
-			return nil, fmt.Errorf("error reading PlayChoice INSt ROM (n=%d; wanted %d): %w", n, PC_INST_SIZE, err)
-		}
+		i.readPlayChoice(b[off:])
+	}
+
+	return i, nil
+}
+
+// NewFromReader loads a ROM from rf, which must produce the contents
+// of a ROM file. This allows ROMs to come from embedded assets,
+// archives and the like.
+func NewFromReader(rf io.Reader) (*ROM, error) {
+	b, err := io.ReadAll(rf)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read ROM: %w", err)
+	}
+
+	return NewFromBytes(b)
+}
+
+// readPlayChoice reads the PlayChoice-10 hint screen data and PROM
+// from b, the bytes following the CHR ROM. The console never uses
+// them, and many dumps are missing them (the PROM especially), so we
+// only warn when they aren't there.
+func (r *ROM) readPlayChoice(b []byte) {
+	if len(b) < PC_INST_SIZE {
+		log.Printf("ROM has no PlayChoice INST-ROM (found %d bytes, wanted %d); ignoring it", len(b), PC_INST_SIZE)
+		return
+	}
+	r.pcInstRom = bytes.Clone(b[:PC_INST_SIZE])
+
+	b = b[PC_INST_SIZE:]
+	if len(b) < PC_PROM_SIZE {
+		log.Printf("ROM has no PlayChoice PROM (found %d bytes, wanted %d); ignoring it", len(b), PC_PROM_SIZE)
+		return
+	}
+	r.pcPROM = &PlayChoicePROM{}
+	copy(r.pcPROM.Data[:], b[:16])
+	copy(r.pcPROM.CounterOut[:], b[16:PC_PROM_SIZE])
+}
+
+// Header returns the 16 byte iNES or NES 2.0 header describing r,
+// including any changes made by Override.
+func (r *ROM) Header() []byte {
+	return r.h.bytes()
+}
+
+// Write writes r to w as a complete ROM file: the header, followed by
+// the trainer, PRG ROM, CHR ROM and any PlayChoice data.
+func (r *ROM) Write(w io.Writer) error {
+	parts := []func(io.Writer) error{
+		writeBytes(r.h.bytes()),
+		writeBytes(r.trainer),
+		r.writePRG,
+		r.writeCHR,
+		writeBytes(r.pcInstRom),
+	}
+	if r.pcPROM != nil {
+		parts = append(parts, writeBytes(r.pcPROM.Data[:]), writeBytes(r.pcPROM.CounterOut[:]))
+	}
 
-		// Some old ROMs may not have this, so bailing might
-		// be bad. But these should be rare, so we'll do the
-		// technically correct thing for now.
-		pcprom := make([]byte, PC_PROM_SIZE)
-		if n, err := rf.Read(pcprom); n != PC_PROM_SIZE || err != nil {
-			return nil, fmt.Errorf("error reading PlayChoice PROM (n=%d, wanted %d): %w", n, PC_PROM_SIZE, err)
+	for _, p := range parts {
+		if err := p(w); err != nil {
+			return fmt.Errorf("couldn't write ROM: %w", err)
 		}
 	}
 
-	return i, nil
+	return nil
+}
+
+func writeBytes(b []byte) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	}
+}
+
+// WriteFile writes r to a new ROM file at path.
+func (r *ROM) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("couldn't create ROM file: %w", err)
+	}
+
+	if err := r.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
 }
 
+// Trainer returns the 512 byte trainer, or nil if the ROM doesn't
+// have one. It belongs at $7000-$71FF.
+func (r *ROM) Trainer() []byte {
+	return r.trainer
+}
+
+// NumPrgBlocks returns the number of 16KB PRG ROM blocks.
 func (r *ROM) NumPrgBlocks() uint8 {
 	return r.h.prgSize
 }
 
+// NumChrBlocks returns the number of 8KB CHR ROM blocks.
+func (r *ROM) NumChrBlocks() uint8 {
+	return r.h.chrSize
+}
+
 func (r *ROM) String() string {
 	var sb strings.Builder
 
@@ -103,26 +265,62 @@ func (r *ROM) String() string {
 	return sb.String()
 }
 
-func (r *ROM) PrgRead(addr uint16) uint8 {
-	return r.prg[addr]
+// PrgRAMSize returns the number of bytes of PRG RAM the cartridge
+// provides.
+func (r *ROM) PrgRAMSize() uint32 {
+	return r.h.prgRAMSize()
 }
 
-func (r *ROM) PrgWrite(addr uint16, val uint8) {
-	r.prg[addr] = val
+// ChrRAMSize returns the number of bytes of CHR RAM the cartridge
+// provides.
+func (r *ROM) ChrRAMSize() uint32 {
+	return r.h.chrRAMSize()
 }
 
-func (r *ROM) ChrRead(addr uint16) uint8 {
-	return r.chr[addr]
+// PrgRead returns the byte at offset within the full PRG ROM. Offsets
+// are 32 bits wide so that banked ROMs larger than 64KB can be
+// addressed by mappers.
+func (r *ROM) PrgRead(offset uint32) uint8 {
+	if r.lazyPRG != nil {
+		return r.lazyPRG.read(offset)
+	}
+	return r.prg[offset]
 }
 
-func (r *ROM) ChrWrite(addr uint16, val uint8) {
-	r.chr[addr] = val
+func (r *ROM) PrgWrite(offset uint32, val uint8) {
+	if r.lazyPRG != nil {
+		r.lazyPRG.write(offset, val)
+		return
+	}
+	r.prg[offset] = val
+}
+
+// ChrRead returns the byte at offset within the full CHR ROM.
+func (r *ROM) ChrRead(offset uint32) uint8 {
+	if r.lazyCHR != nil {
+		return r.lazyCHR.read(offset)
+	}
+	return r.chr[offset]
+}
+
+func (r *ROM) ChrWrite(offset uint32, val uint8) {
+	if r.lazyCHR != nil {
+		r.lazyCHR.write(offset, val)
+		return
+	}
+	r.chr[offset] = val
 }
 
 func (r *ROM) MapperNum() uint16 {
 	return r.h.mapperNum()
 }
 
+// SubmapperNum returns the NES 2.0 submapper number, or 0 when the
+// ROM doesn't specify one.
+func (r *ROM) SubmapperNum() uint8 {
+	return r.h.submapperNum()
+}
+
 func (r *ROM) MirroringMode() uint8 {
 	return r.h.mirroringMode()
 }
@@ -130,3 +328,69 @@ func (r *ROM) MirroringMode() uint8 {
 func (r *ROM) HasSaveRAM() bool {
 	return r.h.hasPrgRAM()
 }
+
+// IsVsSystem reports whether the ROM is for the Vs. System arcade
+// hardware rather than the NES.
+func (r *ROM) IsVsSystem() bool {
+	return r.h.isVsSystem()
+}
+
+// VsPPUType returns the Vs. System PPU the ROM needs, a VS_PPU_XXX
+// value. It's only meaningful when IsVsSystem is true.
+func (r *ROM) VsPPUType() uint8 {
+	return r.h.vsPPUType()
+}
+
+// VsHardwareType returns the Vs. System variant the ROM needs, a
+// VS_XXX value. It's only meaningful when IsVsSystem is true.
+func (r *ROM) VsHardwareType() uint8 {
+	return r.h.vsHardwareType()
+}
+
+// Region returns the region the ROM was made for: NTSC, PAL,
+// MULTI_REGION or DENDY.
+func (r *ROM) Region() uint8 {
+	return r.region
+}
+
+// SetRegion overrides the region taken from the header.
+func (r *ROM) SetRegion(region uint8) {
+	r.region = region
+}
+
+// Region hints in file names, in the style of GoodNES and No-Intro.
+var regionHints = []struct {
+	hint   string
+	region uint8
+}{
+	{"(e)", PAL},
+	{"(europe)", PAL},
+	{"(pal)", PAL},
+	{"(a)", PAL}, // Australia
+	{"(australia)", PAL},
+	{"(dendy)", DENDY},
+	{"(r)", DENDY}, // Russia
+	{"(russia)", DENDY},
+	{"(u)", NTSC},
+	{"(usa)", NTSC},
+	{"(j)", NTSC},
+	{"(japan)", NTSC},
+}
+
+// HintRegion uses name, normally the ROM's file name, to pick the
+// region when the header can't be trusted to. iNES headers rarely set
+// their TV system bit, so a tag like "(E)" or "(PAL)" in the name
+// wins over it; NES 2.0 headers are left alone.
+func (r *ROM) HintRegion(name string) {
+	if r.h.isNES2Format() {
+		return
+	}
+
+	name = strings.ToLower(name)
+	for _, h := range regionHints {
+		if strings.Contains(name, h.hint) {
+			r.region = h.region
+			return
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package nesrom
 
 import (
+	"bytes"
+	"errors"
+	"os"
 	"testing"
 )
 
@@ -9,3 +12,218 @@ func TestNew(t *testing.T) {
 		t.Errorf("couldn't parse testdata file: %v", err)
 	}
 }
+
+func TestNewFromBytes(t *testing.T) {
+	b, err := os.ReadFile("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't read testdata file: %v", err)
+	}
+
+	r, err := NewFromBytes(b)
+	if err != nil {
+		t.Fatalf("NewFromBytes() = %v", err)
+	}
+	f, _ := New("../testdata/ram_after_reset.nes")
+	if r.NumPrgBlocks() != f.NumPrgBlocks() || r.NumChrBlocks() != f.NumChrBlocks() || r.MapperNum() != f.MapperNum() {
+		t.Errorf("NewFromBytes() and New() disagree: %s vs %s", r.h, f.h)
+	}
+
+	trained := bytes.Clone(b)
+	trained[6] |= TRAINER
+
+	prgEnd := 16 + int(b[4])*PRG_BLOCK_SIZE
+	cases := []struct {
+		name        string
+		data        []byte
+		wantSection string
+		wantOffset  int
+	}{
+		{"truncated header", b[:10], "header", 0},
+		{"truncated PRG", b[:100], "PRG ROM", 16},
+		{"truncated CHR", b[:prgEnd+10], "CHR ROM", prgEnd},
+		{"missing trainer", trained[:200], "trainer", 16},
+		{"not a ROM", append([]byte("BOB\x1A"), b[4:]...), "", 0},
+	}
+	for _, tc := range cases {
+		_, err := NewFromReader(bytes.NewReader(tc.data))
+		if err == nil {
+			t.Errorf("%s: NewFromReader() succeeded", tc.name)
+			continue
+		}
+
+		var se *SectionError
+		if !errors.As(err, &se) {
+			if tc.wantSection != "" {
+				t.Errorf("%s: NewFromReader() = %v, wanted a *SectionError", tc.name, err)
+			}
+			continue
+		}
+		if se.Section != tc.wantSection || se.Offset != tc.wantOffset || se.Have != len(tc.data)-tc.wantOffset || !errors.Is(err, ErrTruncated) {
+			t.Errorf("%s: Got %+v, wanted section %q at %d", tc.name, se, tc.wantSection, tc.wantOffset)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	b, err := os.ReadFile("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't read testdata file: %v", err)
+	}
+
+	trained := bytes.Clone(b[:16])
+	trained[6] |= TRAINER
+	trained = append(trained, bytes.Repeat([]byte{0x5A}, TRAINER_SIZE)...)
+	trained = append(trained, b[16:]...)
+
+	pc := bytes.Clone(b)
+	pc[7] |= PLAYCHOICE_10
+	pc = append(pc, make([]byte, PC_INST_SIZE+PC_PROM_SIZE)...)
+	pc[len(pc)-1] = 0x34
+
+	for i, data := range [][]byte{b, trained, pc} {
+		r, err := NewFromBytes(data)
+		if err != nil {
+			t.Fatalf("%d: NewFromBytes() = %v", i, err)
+		}
+
+		var buf bytes.Buffer
+		if err := r.Write(&buf); err != nil {
+			t.Errorf("%d: Write() = %v", i, err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("%d: Write() produced %d bytes that differ from the %d read", i, buf.Len(), len(data))
+		}
+	}
+}
+
+func TestPlayChoiceOptional(t *testing.T) {
+	b, err := os.ReadFile("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't read testdata file: %v", err)
+	}
+	b = bytes.Clone(b)
+	b[7] |= PLAYCHOICE_10
+
+	inst := bytes.Repeat([]byte{0xAB}, PC_INST_SIZE)
+	prom := make([]byte, PC_PROM_SIZE)
+	prom[16] = 0x12
+
+	cases := []struct {
+		data             []byte
+		wantInst, wantPR bool
+	}{
+		{b, false, false},
+		{append(bytes.Clone(b), inst...), true, false},
+		{append(append(bytes.Clone(b), inst...), prom[:10]...), true, false},
+		{append(append(bytes.Clone(b), inst...), prom...), true, true},
+	}
+
+	for i, tc := range cases {
+		r, err := NewFromBytes(tc.data)
+		if err != nil {
+			t.Errorf("%d: NewFromBytes() = %v", i, err)
+			continue
+		}
+		if (r.pcInstRom != nil) != tc.wantInst || (r.pcPROM != nil) != tc.wantPR {
+			t.Errorf("%d: Got INST-ROM %t, PROM %t, wanted %t, %t", i, r.pcInstRom != nil, r.pcPROM != nil, tc.wantInst, tc.wantPR)
+		}
+		if r.pcPROM != nil && r.pcPROM.CounterOut[0] != 0x12 {
+			t.Errorf("%d: PROM counter out = %v", i, r.pcPROM.CounterOut)
+		}
+	}
+}
+
+func TestNewFromRaw(t *testing.T) {
+	prg := make([]byte, 2*PRG_BLOCK_SIZE)
+	prg[0x7FFC] = 0x42
+	chr := make([]byte, CHR_BLOCK_SIZE)
+	chr[1] = 0x24
+
+	cases := []struct {
+		prg, chr  []byte
+		mapper    uint16
+		mirroring uint8
+		wantErr   bool
+	}{
+		{prg, chr, 0, MIRROR_VERTICAL, false},
+		{prg, nil, 2, MIRROR_HORIZONTAL, false},
+		{prg[:PRG_BLOCK_SIZE], chr, 0xA5, MIRROR_FOUR_SCREEN, false},
+		{prg, chr, 0x123, MIRROR_HORIZONTAL, false},
+		{prg[:0x2000], chr, 0, MIRROR_VERTICAL, true},
+		{nil, chr, 0, MIRROR_VERTICAL, true},
+		{prg, chr[:100], 0, MIRROR_VERTICAL, true},
+		{prg, chr, 0, MIRROR_SINGLE_LOWER, true},
+		{prg, chr, 0x1000, MIRROR_VERTICAL, true},
+	}
+
+	for i, tc := range cases {
+		r, err := NewFromRaw(tc.prg, tc.chr, tc.mapper, tc.mirroring)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%d: NewFromRaw() = %v, wanted error %t", i, err, tc.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		// The synthesized ROM must survive being written out and
+		// read back in as a file.
+		var buf bytes.Buffer
+		if err := r.Write(&buf); err != nil {
+			t.Fatalf("%d: Write() = %v", i, err)
+		}
+		r, err = NewFromBytes(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%d: NewFromBytes() = %v", i, err)
+		}
+
+		if r.MapperNum() != tc.mapper || r.MirroringMode() != tc.mirroring {
+			t.Errorf("%d: Got mapper %d, mirroring %d, wanted %d, %d", i, r.MapperNum(), r.MirroringMode(), tc.mapper, tc.mirroring)
+		}
+		if got := int(r.NumPrgBlocks()) * PRG_BLOCK_SIZE; got != len(tc.prg) || r.PrgRead(0x7FFC%uint32(got)) != tc.prg[0x7FFC%got] {
+			t.Errorf("%d: PRG doesn't match", i)
+		}
+		if got := int(r.NumChrBlocks()) * CHR_BLOCK_SIZE; got != len(tc.chr) {
+			t.Errorf("%d: Got %d bytes of CHR, wanted %d", i, got, len(tc.chr))
+		}
+		if len(tc.chr) == 0 && r.ChrRAMSize() != CHR_BLOCK_SIZE {
+			t.Errorf("%d: Got %d bytes of CHR RAM, wanted %d", i, r.ChrRAMSize(), CHR_BLOCK_SIZE)
+		}
+	}
+}
+
+func TestRegion(t *testing.T) {
+	b, err := os.ReadFile("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't read testdata file: %v", err)
+	}
+
+	cases := []struct {
+		flags7, flags9, flags12 uint8
+		name                    string
+		want                    uint8
+	}{
+		{0x00, 0x00, 0x00, "game.nes", NTSC},
+		{0x00, 0x01, 0x00, "game.nes", PAL},
+		{0x00, 0x00, 0x00, "Game (E) [!].nes", PAL},
+		{0x00, 0x00, 0x00, "Game (Europe).nes", PAL},
+		{0x00, 0x00, 0x00, "Game (Dendy).nes", DENDY},
+		{0x00, 0x01, 0x00, "Game (U).nes", NTSC},
+		// NES 2.0 headers are trusted over the name
+		{0x08, 0x00, 0x03, "Game (E).nes", DENDY},
+		{0x08, 0x00, 0x00, "Game (PAL).nes", NTSC},
+	}
+
+	for i, tc := range cases {
+		data := bytes.Clone(b)
+		data[7], data[9], data[12] = tc.flags7, tc.flags9, tc.flags12
+		r, err := NewFromBytes(data)
+		if err != nil {
+			t.Fatalf("%d: NewFromBytes() = %v", i, err)
+		}
+		r.HintRegion(tc.name)
+		if got := r.Region(); got != tc.want {
+			t.Errorf("%d: Region() = %d, wanted %d", i, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package nesrom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ipsMagic = []byte("PATCH")
+	ipsEOF   = []byte("EOF")
+	bpsMagic = []byte("BPS1")
+)
+
+// patchExtensions are the patch formats ApplyPatch understands, in the
+// order FindPatch looks for them.
+var patchExtensions = []string{".ips", ".bps"}
+
+// FindPatch returns the path of a patch sitting next to romFile with
+// the same base name (game.ips for game.nes), or "" if there isn't
+// one.
+func FindPatch(romFile string) string {
+	base := strings.TrimSuffix(romFile, filepath.Ext(romFile))
+	for _, ext := range patchExtensions {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext
+		}
+	}
+	return ""
+}
+
+// ApplyPatch returns a copy of the ROM file in data with the IPS or
+// BPS patch in patch applied. The format is detected from the patch
+// itself.
+func ApplyPatch(data, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, ipsMagic):
+		return applyIPS(data, patch[len(ipsMagic):])
+	case bytes.HasPrefix(patch, bpsMagic):
+		return applyBPS(data, patch)
+	}
+	return nil, errors.New("unknown patch format")
+}
+
+var errPatchTruncated = errors.New("patch is truncated")
+
+// applyIPS applies the records of an IPS patch, which follow its
+// magic. Each record is a 24 bit offset and 16 bit length followed by
+// the data, or a length of 0 followed by a 16 bit run length and the
+// byte to repeat. An optional 24 bit size after the EOF marker
+// truncates the result.
+// https://zerosoft.zophar.net/ips.php
+func applyIPS(data, patch []byte) ([]byte, error) {
+	out := bytes.Clone(data)
+	write := func(off int, b []byte) {
+		if end := off + len(b); end > len(out) {
+			out = append(out, make([]byte, end-len(out))...)
+		}
+		copy(out[off:], b)
+	}
+
+	for {
+		if len(patch) < 3 {
+			return nil, errPatchTruncated
+		}
+		if bytes.Equal(patch[:3], ipsEOF) && (len(patch) == 3 || len(patch) == 6) {
+			if len(patch) == 6 {
+				if n := int(patch[3])<<16 | int(patch[4])<<8 | int(patch[5]); n < len(out) {
+					out = out[:n]
+				}
+			}
+			return out, nil
+		}
+		if len(patch) < 5 {
+			return nil, errPatchTruncated
+		}
+
+		off := int(patch[0])<<16 | int(patch[1])<<8 | int(patch[2])
+		n := int(binary.BigEndian.Uint16(patch[3:5]))
+		patch = patch[5:]
+
+		if n > 0 {
+			if len(patch) < n {
+				return nil, errPatchTruncated
+			}
+			write(off, patch[:n])
+			patch = patch[n:]
+			continue
+		}
+
+		// Run length encoded record
+		if len(patch) < 3 {
+			return nil, errPatchTruncated
+		}
+		n = int(binary.BigEndian.Uint16(patch[0:2]))
+		write(off, bytes.Repeat(patch[2:3], n))
+		patch = patch[3:]
+	}
+}
+
+// bpsReader decodes the variable length numbers BPS patches are made
+// of.
+type bpsReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *bpsReader) byte() byte {
+	if r.pos >= len(r.b) {
+		r.err = errPatchTruncated
+		return 0
+	}
+	r.pos++
+	return r.b[r.pos-1]
+}
+
+func (r *bpsReader) number() int {
+	n, shift := 0, 1
+	for r.err == nil {
+		x := r.byte()
+		n += int(x&0x7F) * shift
+		if x&0x80 != 0 {
+			break
+		}
+		shift <<= 7
+		n += shift
+	}
+	return n
+}
+
+// signed decodes the relative offsets used by the copy actions.
+func (r *bpsReader) signed() int {
+	n := r.number()
+	if n&1 != 0 {
+		return -(n >> 1)
+	}
+	return n >> 1
+}
+
+// applyBPS applies a BPS patch, which rebuilds the target from
+// actions that copy bytes from the source, the patch or the target
+// produced so far. Checksums of all three are verified.
+// https://www.romhacking.net/documents/746/
+func applyBPS(data, patch []byte) ([]byte, error) {
+	const (
+		sourceRead = iota
+		targetRead
+		sourceCopy
+		targetCopy
+	)
+
+	if len(patch) < len(bpsMagic)+12 {
+		return nil, errPatchTruncated
+	}
+	footer := patch[len(patch)-12:]
+	if got, want := crc32.ChecksumIEEE(patch[:len(patch)-4]), binary.LittleEndian.Uint32(footer[8:]); got != want {
+		return nil, fmt.Errorf("patch is corrupt: checksum is %08x, wanted %08x", got, want)
+	}
+	if got, want := crc32.ChecksumIEEE(data), binary.LittleEndian.Uint32(footer[0:]); got != want {
+		return nil, fmt.Errorf("patch is for a different ROM: checksum is %08x, wanted %08x", got, want)
+	}
+
+	r := &bpsReader{b: patch[:len(patch)-12], pos: len(bpsMagic)}
+	if n := r.number(); n != len(data) {
+		return nil, fmt.Errorf("patch is for a %d byte ROM, this one is %d bytes", n, len(data))
+	}
+	size := r.number()
+	out := make([]byte, 0, len(data))
+	r.pos += r.number() // metadata
+	if r.err != nil || r.pos > len(r.b) {
+		return nil, errPatchTruncated
+	}
+
+	var srcOff, dstOff int
+	for r.pos < len(r.b) {
+		a := r.number()
+		n := a>>2 + 1
+		if n <= 0 || len(out)+n > size {
+			return nil, errors.New("patch writes past the end of its target")
+		}
+
+		switch a & 3 {
+		case sourceRead:
+			if len(out)+n > len(data) {
+				return nil, errors.New("patch reads past the end of the ROM")
+			}
+			out = append(out, data[len(out):len(out)+n]...)
+		case targetRead:
+			if r.pos+n > len(r.b) {
+				return nil, errPatchTruncated
+			}
+			out = append(out, r.b[r.pos:r.pos+n]...)
+			r.pos += n
+		case sourceCopy:
+			srcOff += r.signed()
+			if srcOff < 0 || srcOff+n > len(data) {
+				return nil, errors.New("patch copies from outside the ROM")
+			}
+			out = append(out, data[srcOff:srcOff+n]...)
+			srcOff += n
+		case targetCopy:
+			dstOff += r.signed()
+			if dstOff < 0 || dstOff >= len(out) {
+				return nil, errors.New("patch copies from outside the target")
+			}
+			// The regions may overlap, so this has to go a
+			// byte at a time.
+			for i := 0; i < n; i++ {
+				out = append(out, out[dstOff])
+				dstOff++
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+
+	if len(out) != size {
+		return nil, fmt.Errorf("patch produced %d bytes, wanted %d", len(out), size)
+	}
+	if got, want := crc32.ChecksumIEEE(out), binary.LittleEndian.Uint32(footer[4:]); got != want {
+		return nil, fmt.Errorf("patched ROM checksum is %08x, wanted %08x", got, want)
+	}
+
+	return out, nil
+}
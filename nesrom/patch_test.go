@@ -0,0 +1,120 @@
+package nesrom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyIPS(t *testing.T) {
+	rom := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+
+	cases := []struct {
+		patch   string
+		want    []byte
+		wantErr bool
+	}{
+		{"PATCH\x00\x00\x02\x00\x02\xAA\xBBEOF", []byte{0, 1, 0xAA, 0xBB, 4, 5, 6, 7}, false},
+		// Run length record
+		{"PATCH\x00\x00\x05\x00\x00\x00\x02\xCCEOF", []byte{0, 1, 2, 3, 4, 0xCC, 0xCC, 7}, false},
+		// Records may grow the ROM
+		{"PATCH\x00\x00\x07\x00\x03\x10\x11\x12EOF", []byte{0, 1, 2, 3, 4, 5, 6, 0x10, 0x11, 0x12}, false},
+		// A size after the EOF marker truncates the ROM
+		{"PATCH\x00\x00\x00\x00\x01\xFFEOF\x00\x00\x04", []byte{0xFF, 1, 2, 3}, false},
+		{"PATCH\x00\x00\x00\x00\x05\xFF", nil, true},
+		{"PATCH\x00\x00\x00\x00\x01\xFF", nil, true},
+	}
+
+	for i, tc := range cases {
+		got, err := ApplyPatch(rom, []byte(tc.patch))
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("%d: ApplyPatch() = %v, wanted error %t", i, err, tc.wantErr)
+			continue
+		}
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("%d: Got % x, wanted % x", i, got, tc.want)
+		}
+	}
+
+	if rom[2] != 2 {
+		t.Errorf("ApplyPatch() modified the original ROM")
+	}
+}
+
+// bpsNumber encodes n as a BPS variable length number.
+func bpsNumber(n int) []byte {
+	var b []byte
+	for {
+		x := byte(n & 0x7F)
+		n >>= 7
+		if n == 0 {
+			return append(b, x|0x80)
+		}
+		b = append(b, x)
+		n--
+	}
+}
+
+// bpsPatch assembles a BPS patch turning source into target from the
+// encoded actions.
+func bpsPatch(source, target []byte, actions ...[]byte) []byte {
+	p := append([]byte("BPS1"), bpsNumber(len(source))...)
+	p = append(p, bpsNumber(len(target))...)
+	p = append(p, bpsNumber(0)...)
+	for _, a := range actions {
+		p = append(p, a...)
+	}
+	p = binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(source))
+	p = binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(target))
+	return binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(p))
+}
+
+func TestApplyBPS(t *testing.T) {
+	source := []byte("ABCDEFGH")
+	target := []byte("ABxyzxyzxyCD")
+
+	patch := bpsPatch(source, target,
+		bpsNumber((2-1)<<2|0),                             // SourceRead "AB"
+		append(bpsNumber((3-1)<<2|1), "xyz"...),           // TargetRead "xyz"
+		append(bpsNumber((5-1)<<2|3), bpsNumber(2<<1)...), // TargetCopy "xyzxy" from 2
+		append(bpsNumber((2-1)<<2|2), bpsNumber(2<<1)...), // SourceCopy "CD" from 2
+	)
+
+	got, err := ApplyPatch(source, patch)
+	if err != nil {
+		t.Fatalf("ApplyPatch() = %v", err)
+	}
+	if !bytes.Equal(got, target) {
+		t.Errorf("Got %q, wanted %q", got, target)
+	}
+
+	if _, err := ApplyPatch([]byte("ABCDEFGX"), patch); err == nil {
+		t.Errorf("ApplyPatch() accepted a patch for a different ROM")
+	}
+
+	bad := bytes.Clone(patch)
+	bad[len(bad)-13] ^= 0xFF
+	if _, err := ApplyPatch(source, bad); err == nil {
+		t.Errorf("ApplyPatch() accepted a corrupt patch")
+	}
+}
+
+func TestFindPatch(t *testing.T) {
+	dir := t.TempDir()
+	rom := filepath.Join(dir, "game.nes")
+
+	if got := FindPatch(rom); got != "" {
+		t.Errorf("FindPatch() = %q with no patch present", got)
+	}
+
+	want := filepath.Join(dir, "game.bps")
+	if err := os.WriteFile(want, []byte("BPS1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := FindPatch(rom); got != want {
+		t.Errorf("FindPatch() = %q, wanted %q", got, want)
+	}
+}
@@ -0,0 +1,143 @@
+package nesrom
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Controllers a game can expect, for Quirks.
+const (
+	INPUT_STANDARD = iota
+	INPUT_ZAPPER
+	INPUT_FOUR_SCORE
+)
+
+// MAX_OVERCLOCK is the most scanlines Quirks can add to a frame.
+const MAX_OVERCLOCK = 1000
+
+// Quirks are per-title settings for games that need more than a
+// correct header to run well.
+type Quirks struct {
+	Mirroring    uint8 // A MIRROR_XXX constant, only used if HasMirroring
+	HasMirroring bool
+	Input        uint8  // An INPUT_XXX constant
+	Overclock    uint16 // Idle scanlines to add to vblank
+	CycleMode    bool   // Run the CPU a cycle at a time, for games with tight timing
+}
+
+// QuirksDB maps ROM hashes to Quirks.
+type QuirksDB struct {
+	byCRC map[uint32]Quirks
+}
+
+//go:embed quirks.txt
+var embeddedQuirks string
+
+// DefaultQuirks holds the built in quirks plus anything added with
+// (*QuirksDB).Load. mappers.FromROM consults it for every ROM.
+var DefaultQuirks = mustParseQuirks(embeddedQuirks)
+
+func mustParseQuirks(s string) *QuirksDB {
+	db := &QuirksDB{byCRC: map[uint32]Quirks{}}
+	if err := db.Read(strings.NewReader(s)); err != nil {
+		panic(fmt.Sprintf("built in quirks are broken: %v", err))
+	}
+	return db
+}
+
+// Load adds the quirks in the file at path to db.
+func (db *QuirksDB) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("couldn't open quirks: %w", err)
+	}
+	defer f.Close()
+
+	return db.Read(f)
+}
+
+// quirkMirroring and quirkInputs are the values of the mirroring and
+// input settings.
+var (
+	quirkMirroring = map[string]uint8{"h": MIRROR_HORIZONTAL, "v": MIRROR_VERTICAL, "4": MIRROR_FOUR_SCREEN}
+	quirkInputs    = map[string]uint8{"standard": INPUT_STANDARD, "zapper": INPUT_ZAPPER, "fourscore": INPUT_FOUR_SCORE}
+)
+
+// Read adds the quirks in r, in the format of quirks.txt, to db.
+func (db *QuirksDB) Read(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		crc, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil {
+			return fmt.Errorf("quirks line %d: bad CRC32 %q", n, fields[0])
+		}
+		var q Quirks
+		for _, f := range fields[1:] {
+			k, v, _ := strings.Cut(f, "=")
+			ok := true
+			switch k {
+			case "mirroring":
+				q.Mirroring, ok = quirkMirroring[v]
+				q.HasMirroring = true
+			case "input":
+				q.Input, ok = quirkInputs[v]
+			case "overclock":
+				n, err := strconv.ParseUint(v, 10, 16)
+				ok = err == nil && n <= MAX_OVERCLOCK
+				q.Overclock = uint16(n)
+			case "accuracy":
+				q.CycleMode, ok = true, v == "cycle"
+			default:
+				ok = false
+			}
+			if !ok {
+				return fmt.Errorf("quirks line %d: bad setting %q", n, f)
+			}
+		}
+		db.byCRC[uint32(crc)] = q
+	}
+
+	return sc.Err()
+}
+
+// Lookup returns the quirks for r.
+func (db *QuirksDB) Lookup(r *ROM) (Quirks, bool) {
+	q, ok := db.byCRC[r.CRC32()]
+	return q, ok
+}
+
+// ApplyQuirks gives r the quirks in q. Forced mirroring replaces the
+// header's; the rest is reported by Quirks for the console to act on.
+func (r *ROM) ApplyQuirks(q Quirks) {
+	r.quirks = q
+	if q.HasMirroring {
+		r.h.setMirroring(q.Mirroring)
+	}
+}
+
+// InputName returns a description of the INPUT_XXX constant in.
+func InputName(in uint8) string {
+	switch in {
+	case INPUT_ZAPPER:
+		return "a Zapper"
+	case INPUT_FOUR_SCORE:
+		return "a Four Score"
+	}
+	return "standard controllers"
+}
+
+// Quirks returns the quirks given to r by ApplyQuirks.
+func (r *ROM) Quirks() Quirks {
+	return r.quirks
+}
@@ -0,0 +1,64 @@
+package nesrom
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestQuirks(t *testing.T) {
+	r, err := New("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+
+	db := &QuirksDB{byCRC: map[uint32]Quirks{}}
+	if _, ok := db.Lookup(r); ok {
+		t.Errorf("Lookup() found quirks in an empty database")
+	}
+
+	want := uint8(MIRROR_VERTICAL)
+	if r.MirroringMode() == MIRROR_VERTICAL {
+		want = MIRROR_HORIZONTAL
+	}
+	m := map[uint8]string{MIRROR_HORIZONTAL: "h", MIRROR_VERTICAL: "v"}[want]
+	txt := fmt.Sprintf("# comment\n\n%08x mirroring=%s input=zapper overclock=50 accuracy=cycle # trailing\n", r.CRC32(), m)
+	if err := db.Read(strings.NewReader(txt)); err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	q, ok := db.Lookup(r)
+	if !ok {
+		t.Fatalf("Lookup() didn't find the ROM")
+	}
+	if !q.HasMirroring || q.Mirroring != want || q.Input != INPUT_ZAPPER || q.Overclock != 50 || !q.CycleMode {
+		t.Errorf("Lookup() = %+v", q)
+	}
+
+	r.ApplyQuirks(q)
+	if r.MirroringMode() != want {
+		t.Errorf("After ApplyQuirks() got mirroring %d, wanted %d", r.MirroringMode(), want)
+	}
+	if r.Quirks() != q {
+		t.Errorf("Quirks() = %+v, wanted %+v", r.Quirks(), q)
+	}
+}
+
+func TestQuirksBadLines(t *testing.T) {
+	cases := []string{
+		"nothex mirroring=v",
+		"1a2b3c4d mirroring=x",
+		"1a2b3c4d input=keyboard",
+		"1a2b3c4d overclock=-1",
+		"1a2b3c4d overclock=5000",
+		"1a2b3c4d accuracy=fast",
+		"1a2b3c4d turbo=yes",
+	}
+
+	for i, tc := range cases {
+		db := &QuirksDB{byCRC: map[uint32]Quirks{}}
+		if err := db.Read(strings.NewReader(tc)); err == nil {
+			t.Errorf("%d: Read(%q) didn't fail", i, tc)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package nesrom
+
+import "fmt"
+
+// NewFromRaw builds a ROM from bare PRG and CHR images, such as those
+// emitted by assembler toolchains like ca65, supplying the header
+// information that would normally come from the file. prg must be a
+// whole number of 16KB blocks and chr a whole number of 8KB blocks;
+// an empty chr gives the cartridge 8KB of CHR RAM. mirroring is one
+// of MIRROR_HORIZONTAL, MIRROR_VERTICAL or MIRROR_FOUR_SCREEN.
+func NewFromRaw(prg, chr []byte, mapper uint16, mirroring uint8) (*ROM, error) {
+	if len(prg) == 0 || len(prg)%PRG_BLOCK_SIZE != 0 || len(prg)/PRG_BLOCK_SIZE > 0xFF {
+		return nil, fmt.Errorf("PRG is %d bytes, wanted a multiple of %d up to %d", len(prg), PRG_BLOCK_SIZE, 0xFF*PRG_BLOCK_SIZE)
+	}
+	if len(chr)%CHR_BLOCK_SIZE != 0 || len(chr)/CHR_BLOCK_SIZE > 0xFF {
+		return nil, fmt.Errorf("CHR is %d bytes, wanted a multiple of %d up to %d", len(chr), CHR_BLOCK_SIZE, 0xFF*CHR_BLOCK_SIZE)
+	}
+	if mapper > 0xFFF {
+		return nil, fmt.Errorf("mapper %d is out of range", mapper)
+	}
+
+	switch mirroring {
+	case MIRROR_HORIZONTAL, MIRROR_VERTICAL, MIRROR_FOUR_SCREEN:
+	default:
+		return nil, fmt.Errorf("mirroring mode %d can't be set in a header", mirroring)
+	}
+
+	// Only NES 2.0 headers have room for the top nibble of the
+	// mapper, which HeaderBytes takes care of.
+	ci := CartInfo{Mapper: mapper, Mirroring: mirroring}
+	h := parseHeader(HeaderBytes(uint8(len(prg)/PRG_BLOCK_SIZE), uint8(len(chr)/CHR_BLOCK_SIZE), ci))
+
+	return &ROM{
+		h:   h,
+		prg: append([]byte(nil), prg...),
+		chr: append([]byte(nil), chr...),
+	}, nil
+}
@@ -0,0 +1,103 @@
+package nesrom
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// HeaderIssue is a problem found in a ROM header by CheckHeader.
+type HeaderIssue struct {
+	Desc    string
+	Fixable bool // RepairHeader can correct it
+}
+
+func (hi HeaderIssue) String() string {
+	if hi.Fixable {
+		return hi.Desc + " (fixable)"
+	}
+	return hi.Desc
+}
+
+// expectedSize returns the file size the header describes.
+func (h *header) expectedSize() int {
+	s := 16 + int(h.prgSize)*PRG_BLOCK_SIZE + int(h.chrSize)*CHR_BLOCK_SIZE
+	if h.hasTrainer() {
+		s += TRAINER_SIZE
+	}
+	if h.hasPlayChoice() {
+		s += PC_INST_SIZE + PC_PROM_SIZE
+	}
+	return s
+}
+
+// CheckHeader inspects the ROM file in data and reports anything
+// wrong with its header, such as tool generated junk ("DiskDude!")
+// in the unused bytes or sizes that don't match the file.
+func CheckHeader(data []byte) []HeaderIssue {
+	if len(data) < 16 {
+		return []HeaderIssue{{Desc: fmt.Sprintf("file is only %d bytes, too short for a header", len(data))}}
+	}
+
+	h := parseHeader(data)
+	if !h.isINesFormat() {
+		return []HeaderIssue{{Desc: fmt.Sprintf("missing NES signature, found %q", h.constant)}}
+	}
+
+	var issues []HeaderIssue
+	add := func(fixable bool, format string, args ...any) {
+		issues = append(issues, HeaderIssue{Desc: fmt.Sprintf(format, args...), Fixable: fixable})
+	}
+
+	if !h.isNES2Format() {
+		if h.flags7&0x0C != 0 {
+			add(true, "byte 7 has unknown format bits set (0x%02x)", h.flags7)
+		}
+		if junk := data[7:16]; bytes.Contains(junk, []byte("DiskDude!")) {
+			add(true, "bytes 7-15 contain %q written by an old ROM tool", "DiskDude!")
+		} else if !bytes.Equal(data[11:16], make([]byte, 5)) {
+			add(true, "unused bytes 11-15 aren't zero (% x)", data[11:16])
+		}
+	}
+
+	if h.prgSize == 0 && !h.isNES2Format() {
+		add(false, "header claims there is no PRG ROM")
+	}
+
+	switch want := h.expectedSize(); {
+	case h.hasPlayChoice() && len(data) >= want-PC_INST_SIZE-PC_PROM_SIZE && len(data) < want:
+		add(false, "PlayChoice data is incomplete (%d of %d bytes); it's ignored", len(data)-(want-PC_INST_SIZE-PC_PROM_SIZE), PC_INST_SIZE+PC_PROM_SIZE)
+	case len(data) < want:
+		add(false, "file is truncated: header describes %d bytes, file has %d", want, len(data))
+	case len(data) > want:
+		add(false, "file has %d bytes after the ROM data", len(data)-want)
+	}
+
+	return issues
+}
+
+// RepairHeader returns a copy of the ROM file in data with the
+// fixable issues found by CheckHeader corrected. Junk in the unused
+// header bytes, including the upper mapper nibble it corrupts, is
+// cleared.
+func RepairHeader(data []byte) ([]byte, error) {
+	if len(data) < 16 || !parseHeader(data).isINesFormat() {
+		return nil, fmt.Errorf("not an iNES ROM")
+	}
+
+	out := bytes.Clone(data)
+	h := parseHeader(out)
+	if h.isNES2Format() {
+		return out, nil
+	}
+
+	switch {
+	case bytes.Contains(out[7:16], []byte("DiskDude!")) || h.flags7&0x0C != 0:
+		h.flags7, h.flags8, h.flags9, h.flags10 = 0, 0, 0, 0
+		fallthrough
+	case !bytes.Equal(out[11:16], make([]byte, 5)):
+		h.flags11, h.flags12, h.flags13, h.flags14, h.flags15 = 0, 0, 0, 0, 0
+	}
+	copy(out, h.bytes())
+
+	return out, nil
+}
@@ -0,0 +1,47 @@
+package nesrom
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestCheckAndRepairHeader(t *testing.T) {
+	good, err := os.ReadFile("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't read testdata file: %v", err)
+	}
+
+	if issues := CheckHeader(good); len(issues) != 0 {
+		t.Errorf("CheckHeader() found issues with a good ROM: %v", issues)
+	}
+
+	dude := bytes.Clone(good)
+	copy(dude[7:], "DiskDude!")
+	issues := CheckHeader(dude)
+	if len(issues) != 2 || !issues[0].Fixable || !issues[1].Fixable {
+		t.Errorf("CheckHeader() = %v, wanted two fixable issues", issues)
+	}
+
+	fixed, err := RepairHeader(dude)
+	if err != nil {
+		t.Fatalf("RepairHeader() = %v", err)
+	}
+	if !bytes.Equal(fixed, good) {
+		t.Errorf("RepairHeader() didn't restore the original header: % x", fixed[:16])
+	}
+
+	junk := bytes.Clone(good)
+	junk[13] = 0x42
+	if fixed, _ := RepairHeader(junk); !bytes.Equal(fixed, good) {
+		t.Errorf("RepairHeader() didn't clear junk in byte 13: % x", fixed[:16])
+	}
+
+	if issues := CheckHeader(good[:100]); len(issues) != 1 || issues[0].Fixable {
+		t.Errorf("CheckHeader() = %v, wanted a single unfixable issue for a truncated ROM", issues)
+	}
+
+	if _, err := RepairHeader([]byte("BOB")); err == nil {
+		t.Errorf("RepairHeader() accepted something that isn't a ROM")
+	}
+}
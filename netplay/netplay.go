@@ -0,0 +1,328 @@
+// Package netplay lets two players on different machines play the
+// same game. Each machine runs its own console, and only the
+// players' inputs cross the network, so the consoles must be started
+// from the same state; the host sends its state to the guest when the
+// session starts.
+//
+// Sessions run in lockstep by default: a frame only runs once both
+// players' inputs for it have arrived, and a few frames of input
+// delay hide the network's latency. With rollback, frames run
+// straight away using a guess at the other player's input, and when
+// a guess turns out wrong the console goes back to a save state
+// before it and replays the frames since with the real input, as
+// GGPO does.
+package netplay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bdwalton/gintendo/core"
+)
+
+// PORT is the port sessions use unless told otherwise.
+const PORT = 7845
+
+// MAX_ROLLBACK is how many frames a rollback session runs ahead of
+// the other player's confirmed input before waiting for it.
+const MAX_ROLLBACK = 8
+
+var magic = []byte("GNP1")
+
+// ErrTimeout is returned when the other player's input doesn't
+// arrive in time.
+var ErrTimeout = errors.New("netplay: timed out waiting for the other player")
+
+// Options control how a session keeps the consoles in step. The
+// host's options are used by both players.
+type Options struct {
+	Delay    int           // Frames between pressing a button and it taking effect
+	Rollback bool          // Guess the other player's input rather than waiting for it
+	Timeout  time.Duration // How long to wait for the other player; 0 means 10s
+}
+
+// input is a player's buttons for a frame.
+type input struct {
+	frame   int
+	buttons uint8
+}
+
+// Session is one end of a game between two consoles. The host is
+// player 1 and the guest player 2.
+type Session struct {
+	c      *core.Console
+	conn   io.ReadWriteCloser
+	player int
+	opts   Options
+
+	frame     int           // the next frame to run
+	confirmed int           // the other player's input is known for frames before this
+	last      uint8         // the other player's latest input, the guess for later frames
+	local     map[int]uint8 // our input, by frame
+	remote    map[int]uint8 // the other player's input, by frame
+	guessed   map[int]uint8 // guesses made for the other player's input
+	states    map[int][]byte
+	oldest    int // the first frame still in the maps
+	replayAt  int // the first frame a wrong guess was made for, or -1
+	rollbacks int // replays done, for testing
+
+	recv    chan input
+	readErr error // set before recv is closed
+}
+
+// Host starts a session as player 1 on conn, sending the state of c
+// to the guest. The caller should have loaded the game both players
+// will play.
+func Host(conn io.ReadWriteCloser, c *core.Console, opts Options) (*Session, error) {
+	var st bytes.Buffer
+	if err := c.SaveState(&st); err != nil {
+		return nil, err
+	}
+
+	var hello bytes.Buffer
+	hello.Write(magic)
+	hello.WriteByte(uint8(opts.Delay))
+	if opts.Rollback {
+		hello.WriteByte(1)
+	} else {
+		hello.WriteByte(0)
+	}
+	binary.Write(&hello, binary.LittleEndian, uint32(st.Len()))
+	hello.Write(st.Bytes())
+	if _, err := conn.Write(hello.Bytes()); err != nil {
+		return nil, fmt.Errorf("netplay: couldn't send state: %w", err)
+	}
+
+	return newSession(conn, c, 0, opts), nil
+}
+
+// Join starts a session as player 2 on conn, loading the host's
+// state into c. The host's options are used, except for Timeout.
+func Join(conn io.ReadWriteCloser, c *core.Console, timeout time.Duration) (*Session, error) {
+	hdr := make([]byte, len(magic)+6)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return nil, fmt.Errorf("netplay: couldn't read the host's hello: %w", err)
+	}
+	if !bytes.Equal(hdr[:len(magic)], magic) {
+		return nil, fmt.Errorf("netplay: host isn't speaking the netplay protocol")
+	}
+	opts := Options{
+		Delay:    int(hdr[len(magic)]),
+		Rollback: hdr[len(magic)+1] != 0,
+		Timeout:  timeout,
+	}
+
+	n := binary.LittleEndian.Uint32(hdr[len(magic)+2:])
+	if err := c.LoadState(io.LimitReader(conn, int64(n))); err != nil {
+		return nil, fmt.Errorf("netplay: couldn't load the host's state (is it the same game?): %w", err)
+	}
+
+	return newSession(conn, c, 1, opts), nil
+}
+
+func newSession(conn io.ReadWriteCloser, c *core.Console, player int, opts Options) *Session {
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	s := &Session{
+		c:         c,
+		conn:      conn,
+		player:    player,
+		opts:      opts,
+		confirmed: opts.Delay, // nobody has input for the first frames
+		local:     map[int]uint8{},
+		remote:    map[int]uint8{},
+		guessed:   map[int]uint8{},
+		states:    map[int][]byte{},
+		replayAt:  -1,
+		recv:      make(chan input, 256),
+	}
+	go s.read()
+
+	return s
+}
+
+// Player returns the player this end of the session controls, 0 for
+// the host and 1 for the guest.
+func (s *Session) Player() int {
+	return s.player
+}
+
+// Close ends the session.
+func (s *Session) Close() error {
+	return s.conn.Close()
+}
+
+// read passes the other player's inputs to recv until the connection
+// fails.
+func (s *Session) read() {
+	defer close(s.recv)
+
+	b := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(s.conn, b); err != nil {
+			s.readErr = fmt.Errorf("netplay: lost the other player: %w", err)
+			return
+		}
+		s.recv <- input{frame: int(binary.LittleEndian.Uint32(b)), buttons: b[4]}
+	}
+}
+
+// send tells the other player our input for a frame.
+func (s *Session) send(in input) error {
+	var b [5]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(in.frame))
+	b[4] = in.buttons
+	if _, err := s.conn.Write(b[:]); err != nil {
+		return fmt.Errorf("netplay: couldn't send input: %w", err)
+	}
+	return nil
+}
+
+// receive takes the other player's next input, waiting for it if
+// wait is set. It reports whether there was one.
+func (s *Session) receive(wait bool) (bool, error) {
+	var in input
+	var ok bool
+	if wait {
+		select {
+		case in, ok = <-s.recv:
+		case <-time.After(s.opts.Timeout):
+			return false, ErrTimeout
+		}
+	} else {
+		select {
+		case in, ok = <-s.recv:
+		default:
+			return false, nil
+		}
+	}
+	if !ok {
+		return false, s.readErr
+	}
+
+	s.remote[in.frame] = in.buttons
+	s.last = in.buttons
+	s.confirmed = in.frame + 1
+	if g, ok := s.guessed[in.frame]; ok && g != in.buttons && in.frame < s.frame {
+		if s.replayAt < 0 || in.frame < s.replayAt {
+			s.replayAt = in.frame
+		}
+	}
+	return true, nil
+}
+
+// RunFrame runs the next frame with in.Buttons[0] as our player's
+// buttons, in place of the console's own RunFrame. It returns the
+// picture and sound like core.Console.RunFrame does. Vs. System coin
+// and DIP inputs aren't shared, so they're ignored.
+func (s *Session) RunFrame(in core.Inputs) ([]byte, []int16, error) {
+	mine := input{frame: s.frame + s.opts.Delay, buttons: in.Buttons[0]}
+	s.local[mine.frame] = mine.buttons
+	if err := s.send(mine); err != nil {
+		return nil, nil, err
+	}
+
+	if s.opts.Rollback {
+		// Don't get too far ahead, then take whatever's come in.
+		for s.frame-s.confirmed >= MAX_ROLLBACK {
+			if _, err := s.receive(true); err != nil {
+				return nil, nil, err
+			}
+		}
+		for {
+			ok, err := s.receive(false)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				break
+			}
+		}
+		if err := s.replay(); err != nil {
+			return nil, nil, err
+		}
+		if err := s.save(s.frame); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		for s.confirmed <= s.frame {
+			if _, err := s.receive(true); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	video, audio := s.run(s.frame)
+	s.frame++
+	s.forget()
+
+	return video, audio, nil
+}
+
+// run runs frame f with both players' inputs, guessing the other
+// player's if it hasn't arrived.
+func (s *Session) run(f int) ([]byte, []int16) {
+	theirs, ok := s.remote[f]
+	if !ok && f >= s.confirmed {
+		theirs = s.last
+		s.guessed[f] = theirs
+	}
+
+	var in core.Inputs
+	in.Buttons[s.player] = s.local[f]
+	in.Buttons[1-s.player] = theirs
+	return s.c.RunFrame(in)
+}
+
+// save keeps the console's state before frame f, for replays.
+func (s *Session) save(f int) error {
+	var b bytes.Buffer
+	if err := s.c.SaveState(&b); err != nil {
+		return err
+	}
+	s.states[f] = b.Bytes()
+	return nil
+}
+
+// replay goes back to the first frame with a wrong guess and runs the
+// frames since again with the inputs now known.
+func (s *Session) replay() error {
+	if s.replayAt < 0 {
+		return nil
+	}
+	from := s.replayAt
+	s.replayAt = -1
+	s.rollbacks++
+
+	if err := s.c.LoadState(bytes.NewReader(s.states[from])); err != nil {
+		return err
+	}
+	for f := from; f < s.frame; f++ {
+		delete(s.guessed, f)
+		if err := s.save(f); err != nil {
+			return err
+		}
+		s.run(f)
+	}
+	return nil
+}
+
+// forget drops what's no longer needed: everything before the last
+// frame whose inputs are all known.
+func (s *Session) forget() {
+	end := s.confirmed
+	if s.frame < end {
+		end = s.frame
+	}
+	for ; s.oldest < end; s.oldest++ {
+		delete(s.local, s.oldest)
+		delete(s.remote, s.oldest)
+		delete(s.guessed, s.oldest)
+		delete(s.states, s.oldest)
+	}
+}
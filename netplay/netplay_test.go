@@ -0,0 +1,152 @@
+package netplay
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/mappers"
+)
+
+func testConsole(t *testing.T) *core.Console {
+	t.Helper()
+
+	m, err := mappers.Load("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+	return core.New(m)
+}
+
+func saveState(t *testing.T, c *core.Console) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	if err := c.SaveState(&b); err != nil {
+		t.Fatalf("SaveState() = %v", err)
+	}
+	return b.Bytes()
+}
+
+// buttons is what player p presses on frame f.
+func buttons(p, f int) uint8 {
+	return uint8((f / (3 + p)) * (p + 1))
+}
+
+// settle waits for all of the other player's input and fixes any
+// wrong guesses, so both ends have run the same frames.
+func settle(t *testing.T, s *Session) {
+	t.Helper()
+
+	for s.confirmed < s.frame {
+		if _, err := s.receive(true); err != nil {
+			t.Fatalf("receive() = %v", err)
+		}
+	}
+	if err := s.replay(); err != nil {
+		t.Fatalf("replay() = %v", err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	const frames = 60
+
+	cases := []Options{
+		{Delay: 2},
+		{Delay: 0, Rollback: true},
+		{Delay: 1, Rollback: true},
+	}
+
+	for i, tc := range cases {
+		a, b := net.Pipe()
+		hc, gc := testConsole(t), testConsole(t)
+		hc.RunFrame(core.Inputs{}) // the guest should pick this up
+
+		sessions := make(chan *Session)
+		errs := make(chan error, 2)
+		go func() {
+			s, err := Host(a, hc, tc)
+			if err != nil {
+				errs <- err
+			}
+			sessions <- s
+		}()
+		guest, err := Join(b, gc, 0)
+		if err != nil {
+			t.Fatalf("%d: Join() = %v", i, err)
+		}
+		host := <-sessions
+		if host == nil {
+			t.Fatalf("%d: Host() = %v", i, <-errs)
+		}
+		if guest.opts.Delay != tc.Delay || guest.opts.Rollback != tc.Rollback {
+			t.Errorf("%d: guest options = %+v, want the host's %+v", i, guest.opts, tc)
+		}
+
+		done := make(chan bool)
+		for _, s := range []*Session{host, guest} {
+			go func(s *Session) {
+				for f := 0; f < frames; f++ {
+					if _, _, err := s.RunFrame(core.Inputs{Buttons: [core.PLAYERS]uint8{buttons(s.Player(), f)}}); err != nil {
+						errs <- err
+						break
+					}
+				}
+				done <- true
+			}(s)
+		}
+		<-done
+		<-done
+		select {
+		case err := <-errs:
+			t.Fatalf("%d: RunFrame() = %v", i, err)
+		default:
+		}
+
+		settle(t, host)
+		settle(t, guest)
+		if hs, gs := saveState(t, hc), saveState(t, gc); !bytes.Equal(hs, gs) {
+			t.Errorf("%d: host and guest states differ after %d frames", i, frames)
+		}
+
+		host.Close()
+		guest.Close()
+	}
+}
+
+func TestReplay(t *testing.T) {
+	a, b := net.Pipe()
+	go io.Copy(io.Discard, b)
+	defer a.Close()
+
+	c := testConsole(t)
+	s := newSession(a, c, 0, Options{Rollback: true})
+
+	// Nothing has come from player 2, so they're guessed to be
+	// pressing nothing.
+	for f := 0; f < 5; f++ {
+		if _, _, err := s.RunFrame(core.Inputs{}); err != nil {
+			t.Fatalf("RunFrame() = %v", err)
+		}
+	}
+	// Then it turns out they pressed A from frame 1 on.
+	s.recv <- input{frame: 0, buttons: 0}
+	s.recv <- input{frame: 1, buttons: core.BUTTON_A}
+	if _, _, err := s.RunFrame(core.Inputs{}); err != nil {
+		t.Fatalf("RunFrame() = %v", err)
+	}
+	if s.rollbacks != 1 {
+		t.Errorf("rollbacks = %d, want 1", s.rollbacks)
+	}
+
+	want := testConsole(t)
+	want.RunFrame(core.Inputs{})
+	for f := 1; f < 6; f++ {
+		want.RunFrame(core.Inputs{Buttons: [core.PLAYERS]uint8{0, core.BUTTON_A}})
+	}
+	if !bytes.Equal(saveState(t, c), saveState(t, want)) {
+		t.Errorf("state after replay differs from running with the right inputs")
+	}
+}
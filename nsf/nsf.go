@@ -0,0 +1,229 @@
+// package nsf implements support for the NSF and NSFe music file
+// formats, which hold the sound code and data ripped from NES games.
+// https://www.nesdev.org/wiki/NSF, https://www.nesdev.org/wiki/NSFe
+package nsf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	HEADER_SIZE = 0x80
+
+	// Default play periods, in microseconds, for NSFe files
+	// without a RATE chunk.
+	NTSC_SPEED = 16639
+	PAL_SPEED  = 19997
+)
+
+// Expansion sound chips, as flagged in the header
+const (
+	CHIP_VRC6 = 1 << iota
+	CHIP_VRC7
+	CHIP_FDS
+	CHIP_MMC5
+	CHIP_N163
+	CHIP_S5B
+)
+
+var (
+	nsfMagic  = []byte{'N', 'E', 'S', 'M', 0x1A}
+	nsfeMagic = []byte{'N', 'S', 'F', 'E'}
+)
+
+// NSF is a parsed NSF or NSFe file.
+type NSF struct {
+	Songs     uint8 // Total number of songs
+	StartSong uint8 // The song to play first, counting from 1
+	LoadAddr  uint16
+	InitAddr  uint16
+	PlayAddr  uint16
+	Name      string
+	Artist    string
+	Copyright string
+	NTSCSpeed uint16   // Microseconds between PLAY calls
+	PALSpeed  uint16   // Microseconds between PLAY calls
+	Banks     [8]uint8 // Initial bank for each 4KB window at $8000, all 0 if unbanked
+	Region    uint8    // Bit 0: PAL, bit 1: dual region
+	Chips     uint8    // CHIP_XXX flags
+	Labels    []string // Per-song titles, NSFe only
+	Data      []uint8
+}
+
+// New loads the NSF or NSFe file at path.
+func New(path string) (*NSF, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open NSF %q: %w", path, err)
+	}
+	defer f.Close()
+
+	return Read(f)
+}
+
+// Read loads an NSF or NSFe file from r.
+func Read(r io.Reader) (*NSF, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read NSF: %w", err)
+	}
+
+	var n *NSF
+	switch {
+	case bytes.HasPrefix(data, nsfMagic):
+		n, err = parseNSF(data)
+	case bytes.HasPrefix(data, nsfeMagic):
+		n, err = parseNSFe(data[len(nsfeMagic):])
+	default:
+		return nil, fmt.Errorf("not an NSF or NSFe file")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Without songs there's nothing to select, and without a
+	// speed PLAY would be called continuously.
+	switch {
+	case n.Songs == 0:
+		return nil, fmt.Errorf("NSF has no songs")
+	case n.NTSCSpeed == 0:
+		return nil, fmt.Errorf("NSF has a play speed of 0")
+	}
+
+	return n, nil
+}
+
+// Banked reports whether the tune uses bank switching.
+func (n *NSF) Banked() bool {
+	for _, b := range n.Banks {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Title returns the label for song (counting from 0), falling back
+// to the song number.
+func (n *NSF) Title(song int) string {
+	if song < len(n.Labels) && n.Labels[song] != "" {
+		return n.Labels[song]
+	}
+	return fmt.Sprintf("Song %d", song+1)
+}
+
+// cString returns the NUL terminated string at the start of b.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func parseNSF(data []byte) (*NSF, error) {
+	if len(data) < HEADER_SIZE {
+		return nil, fmt.Errorf("NSF header is truncated")
+	}
+
+	le := binary.LittleEndian
+	n := &NSF{
+		Songs:     data[0x06],
+		StartSong: data[0x07],
+		LoadAddr:  le.Uint16(data[0x08:]),
+		InitAddr:  le.Uint16(data[0x0A:]),
+		PlayAddr:  le.Uint16(data[0x0C:]),
+		Name:      cString(data[0x0E:0x2E]),
+		Artist:    cString(data[0x2E:0x4E]),
+		Copyright: cString(data[0x4E:0x6E]),
+		NTSCSpeed: le.Uint16(data[0x6E:]),
+		PALSpeed:  le.Uint16(data[0x78:]),
+		Region:    data[0x7A],
+		Chips:     data[0x7B],
+	}
+	copy(n.Banks[:], data[0x70:0x78])
+
+	// NSF2 headers can give the length of the program data, which
+	// is followed by metadata we don't use.
+	end := len(data)
+	if l := int(data[0x7D]) | int(data[0x7E])<<8 | int(data[0x7F])<<16; data[0x05] >= 2 && l != 0 && HEADER_SIZE+l <= end {
+		end = HEADER_SIZE + l
+	}
+	n.Data = data[HEADER_SIZE:end]
+
+	return n, nil
+}
+
+// parseNSFe reads the chunks that follow the NSFE marker.
+func parseNSFe(data []byte) (*NSF, error) {
+	n := &NSF{NTSCSpeed: NTSC_SPEED, PALSpeed: PAL_SPEED, StartSong: 1}
+	le := binary.LittleEndian
+
+	var haveInfo, haveData bool
+	for {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("NSFe chunk header is truncated")
+		}
+		l := int(le.Uint32(data))
+		id := string(data[4:8])
+		data = data[8:]
+		if l > len(data) {
+			return nil, fmt.Errorf("NSFe %q chunk is truncated", id)
+		}
+		c := data[:l]
+		data = data[l:]
+
+		switch id {
+		case "INFO":
+			if len(c) < 9 {
+				return nil, fmt.Errorf("NSFe INFO chunk is too short")
+			}
+			n.LoadAddr = le.Uint16(c[0:])
+			n.InitAddr = le.Uint16(c[2:])
+			n.PlayAddr = le.Uint16(c[4:])
+			n.Region = c[6]
+			n.Chips = c[7]
+			n.Songs = c[8]
+			if len(c) > 9 {
+				n.StartSong = c[9] + 1 // NSFe counts from 0
+			}
+			haveInfo = true
+		case "DATA":
+			n.Data = c
+			haveData = true
+		case "BANK":
+			copy(n.Banks[:], c)
+		case "RATE":
+			if len(c) >= 2 {
+				n.NTSCSpeed = le.Uint16(c)
+			}
+			if len(c) >= 4 {
+				n.PALSpeed = le.Uint16(c[2:])
+			}
+		case "auth":
+			f := strings.Split(string(c), "\x00")
+			for i, s := range []*string{&n.Name, &n.Artist, &n.Copyright} {
+				if i < len(f) {
+					*s = f[i]
+				}
+			}
+		case "tlbl":
+			n.Labels = strings.Split(strings.TrimSuffix(string(c), "\x00"), "\x00")
+		case "NEND":
+			if !haveInfo || !haveData {
+				return nil, fmt.Errorf("NSFe is missing its INFO or DATA chunk")
+			}
+			return n, nil
+		default:
+			// Chunks with an upper case first letter must be
+			// understood to play the file correctly.
+			if id[0] >= 'A' && id[0] <= 'Z' {
+				return nil, fmt.Errorf("unsupported NSFe chunk %q", id)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package nsf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestReadNSF(t *testing.T) {
+	h := make([]byte, HEADER_SIZE)
+	copy(h, nsfMagic)
+	h[0x05] = 1
+	h[0x06] = 3
+	h[0x07] = 2
+	binary.LittleEndian.PutUint16(h[0x08:], 0x8000)
+	binary.LittleEndian.PutUint16(h[0x0A:], 0x8003)
+	binary.LittleEndian.PutUint16(h[0x0C:], 0x8006)
+	copy(h[0x0E:], "Tune")
+	copy(h[0x2E:], "Someone")
+	binary.LittleEndian.PutUint16(h[0x6E:], 16666)
+	h[0x75] = 5
+	h[0x7B] = CHIP_VRC6 | CHIP_FDS
+
+	n, err := Read(bytes.NewReader(append(h, 1, 2, 3)))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	if n.Songs != 3 || n.StartSong != 2 || n.LoadAddr != 0x8000 || n.InitAddr != 0x8003 || n.PlayAddr != 0x8006 {
+		t.Errorf("Got songs %d/%d, addrs 0x%04x/0x%04x/0x%04x", n.StartSong, n.Songs, n.LoadAddr, n.InitAddr, n.PlayAddr)
+	}
+	if n.Name != "Tune" || n.Artist != "Someone" || n.Copyright != "" {
+		t.Errorf("Got name %q, artist %q, copyright %q", n.Name, n.Artist, n.Copyright)
+	}
+	if n.NTSCSpeed != 16666 || !n.Banked() || n.Chips != CHIP_VRC6|CHIP_FDS {
+		t.Errorf("Got speed %d, banked %t, chips %02x", n.NTSCSpeed, n.Banked(), n.Chips)
+	}
+	if !bytes.Equal(n.Data, []uint8{1, 2, 3}) {
+		t.Errorf("Got data %v", n.Data)
+	}
+
+	h[0x06] = 0
+	if _, err := Read(bytes.NewReader(h)); err == nil {
+		t.Errorf("Read() accepted an NSF without songs")
+	}
+	h[0x06] = 3
+	binary.LittleEndian.PutUint16(h[0x6E:], 0)
+	if _, err := Read(bytes.NewReader(h)); err == nil {
+		t.Errorf("Read() accepted an NSF with a play speed of 0")
+	}
+}
+
+func TestReadNSFe(t *testing.T) {
+	var b bytes.Buffer
+	b.Write(nsfeMagic)
+	chunk := func(id string, data ...byte) {
+		binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+		b.WriteString(id)
+		b.Write(data)
+	}
+	chunk("INFO", 0x00, 0x80, 0x03, 0x80, 0x06, 0x80, 0, 0, 2, 1)
+	chunk("DATA", 9, 8, 7)
+	chunk("tlbl", []byte("One\x00Two\x00")...)
+	chunk("auth", []byte("Game\x00Artist\x00(c)\x00Ripper\x00")...)
+	chunk("text", []byte("ignored")...)
+	chunk("NEND")
+
+	n, err := Read(&b)
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	if n.Songs != 2 || n.StartSong != 2 || n.InitAddr != 0x8003 || n.NTSCSpeed != NTSC_SPEED {
+		t.Errorf("Got songs %d/%d, init 0x%04x, speed %d", n.StartSong, n.Songs, n.InitAddr, n.NTSCSpeed)
+	}
+	if n.Title(1) != "Two" || n.Title(2) != "Song 3" || n.Name != "Game" || n.Artist != "Artist" {
+		t.Errorf("Got titles %q, %q, name %q, artist %q", n.Title(1), n.Title(2), n.Name, n.Artist)
+	}
+
+	b.Reset()
+	b.Write(nsfeMagic)
+	chunk("INFO", 0x00, 0x80, 0x03, 0x80, 0x06, 0x80, 0, 0, 2)
+	chunk("DATA", 9)
+	chunk("WXYZ")
+	chunk("NEND")
+	if _, err := Read(&b); err == nil {
+		t.Errorf("Read() accepted an unknown mandatory chunk")
+	}
+
+	b.Reset()
+	b.Write(nsfeMagic)
+	chunk("INFO", 0x00, 0x80, 0x03, 0x80, 0x06, 0x80, 0, 0, 0)
+	chunk("DATA", 9)
+	chunk("NEND")
+	if _, err := Read(&b); err == nil {
+		t.Errorf("Read() accepted an NSFe without songs")
+	}
+
+	b.Reset()
+	b.Write(nsfeMagic)
+	chunk("INFO", 0x00, 0x80, 0x03, 0x80, 0x06, 0x80, 0, 0, 2)
+	chunk("RATE", 0, 0)
+	chunk("DATA", 9)
+	chunk("NEND")
+	if _, err := Read(&b); err == nil {
+		t.Errorf("Read() accepted an NSFe with a play speed of 0")
+	}
+}
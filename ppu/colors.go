@@ -6,6 +6,26 @@ import (
 
 var SYSTEM_PALETTE [64]color.RGBA
 
+// RGB_PALETTE is the palette of the RGB PPUs (2C03 and 2C05) used in
+// the Vs. System and PlayChoice-10, which put out 3 bits each of red,
+// green and blue rather than a composite signal.
+// https://www.nesdev.org/wiki/PPU_palettes#2C03_and_2C05
+var RGB_PALETTE [64]color.RGBA
+
+// emphasisDim is how much colour emphasis dims the channels it
+// doesn't emphasize.
+const emphasisDim = 0.816
+
+// emphasized is SYSTEM_PALETTE as tinted by each combination of the
+// emphasis bits in PPUMASK, shifted down to bits 0 (red), 1 (green)
+// and 2 (blue).
+var emphasized [8][64]color.RGBA
+
+// rgbEmphasized is RGB_PALETTE with each combination of the emphasis
+// bits. The RGB PPUs turn the emphasized channels fully on instead of
+// dimming the others.
+var rgbEmphasized [8][64]color.RGBA
+
 func init() {
 	colors := []int32{
 		0x808080, 0x003DA6, 0x0012B0, 0x440096, 0xA1005E,
@@ -31,4 +51,63 @@ func init() {
 			A: 0xFF,
 		}
 	}
+
+	// Each digit is a 3 bit red, green or blue level.
+	rgb := []uint16{
+		0333, 0014, 0006, 0326, 0403, 0503, 0510, 0420,
+		0320, 0120, 0031, 0040, 0022, 0000, 0000, 0000,
+		0555, 0036, 0027, 0407, 0507, 0704, 0700, 0630,
+		0430, 0140, 0040, 0053, 0044, 0000, 0000, 0000,
+		0777, 0357, 0447, 0637, 0707, 0737, 0740, 0750,
+		0660, 0360, 0070, 0276, 0077, 0000, 0000, 0000,
+		0777, 0567, 0657, 0757, 0747, 0755, 0764, 0772,
+		0773, 0572, 0473, 0276, 0467, 0000, 0000, 0000,
+	}
+
+	for i, c := range rgb {
+		RGB_PALETTE[i] = color.RGBA{
+			R: level(c >> 6),
+			G: level(c >> 3),
+			B: level(c),
+			A: 0xFF,
+		}
+	}
+
+	for e := range emphasized {
+		for i, c := range SYSTEM_PALETTE {
+			// The blacks in columns $E and $F aren't tinted.
+			if i&0x0F < 0x0E {
+				c.R = dim(c.R, e&0x06 != 0)
+				c.G = dim(c.G, e&0x05 != 0)
+				c.B = dim(c.B, e&0x03 != 0)
+			}
+			emphasized[e][i] = c
+		}
+		for i, c := range RGB_PALETTE {
+			if e&0x01 != 0 {
+				c.R = 0xFF
+			}
+			if e&0x02 != 0 {
+				c.G = 0xFF
+			}
+			if e&0x04 != 0 {
+				c.B = 0xFF
+			}
+			rgbEmphasized[e][i] = c
+		}
+	}
+}
+
+// level scales the 3 bit colour level in the low bits of v up to 8
+// bits.
+func level(v uint16) uint8 {
+	return uint8((v & 0x07) * 0xFF / 0x07)
+}
+
+// dim returns v dimmed by emphasisDim if on is set.
+func dim(v uint8, on bool) uint8 {
+	if !on {
+		return v
+	}
+	return uint8(float64(v) * emphasisDim)
 }
@@ -86,7 +86,7 @@ func (l *loopy) incrementFineY() {
 }
 
 func (l *loopy) setFineY(n uint16) {
-	*l = loopy(uint16(*l) & (0x0FFF | (uint16(n) << 12)))
+	*l = *l&0x0FFF | loopy(n&0x07)<<12
 }
 
 func (l *loopy) resetFineY() {
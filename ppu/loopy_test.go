@@ -287,6 +287,7 @@ func TestLoopySetFineY(t *testing.T) {
 		{0b0111_1011_1001_1000, 0b111, 0b101},
 		{0b0011_0111_1011_0111, 0b011, 0},
 		{0b0111_1111_1111_0111, 0b111, 0b010},
+		{0b0000_1111_1111_0111, 0b000, 0b101},
 	}
 
 	for i, tc := range cases {
@@ -6,6 +6,7 @@ import (
 	"image"
 	"image/color"
 	"math/bits"
+	"sync/atomic"
 )
 
 // Display constants
@@ -110,18 +111,36 @@ const (
 
 type Bus interface {
 	ChrRead(uint16) uint8
+	ChrWrite(uint16, uint8)
 	TriggerNMI()
 	MirrorMode() uint8
 }
 
+// NametableBus is optionally implemented by a Bus whose cartridge can
+// report MIRROR_MAPPER_CONTROLLED. In that mode, the PPU asks which
+// physical nametable (0 or 1) backs each logical nametable (0-3).
+type NametableBus interface {
+	NametablePage(nt uint8) uint8
+}
+
+// AddressBus is optionally implemented by a Bus whose cartridge
+// watches the PPU's address lines, as the MMC3 does to count
+// scanlines from the rises of A12. PPUAddress is called with the
+// address of each fetch made while rendering and of each PPUDATA
+// access. Filtering out unwanted edges is up to the cartridge.
+type AddressBus interface {
+	PPUAddress(addr uint16)
+}
+
 type PPU struct {
 	bus          Bus
-	pixels       *image.RGBA
+	pixels       *image.RGBA                // the frame being drawn
+	front        atomic.Pointer[image.RGBA] // the last finished frame; see GetPixels
 	paletteTable [32]uint8
 	oamData      [256]uint8
-	secondaryOAM []oam       // temp OAM store for sprites on next scanline
-	vram         [2048]uint8 // 2k of video ram
-	mirrorMode   uint8
+	vram         [4096]uint8 // 2k of video ram, then 2k more on four screen carts
+	ntBus        NametableBus
+	addrBus      AddressBus
 
 	// internal registers
 	v, t   loopy // current vram addr, temp vram addr
@@ -139,9 +158,25 @@ type PPU struct {
 	frame    uint64
 	oddFrame bool
 
+	// Region dependent timing; see SetRegion
+	lastLine    uint16 // the pre-render line
+	vblankStart uint16 // the line vblank starts on
+	skipOddDot  bool   // odd frames are a dot short when rendering
+	region      uint8
+	overclock   uint16 // idle lines added to vblank; see SetOverclock
+
 	// For reads from registers that are delayed due to cycle counts
 	bufferData uint8
 
+	hidden uint8 // LAYER_XXX bits left out of the picture; see HideLayers
+
+	onFrame func(*image.RGBA) // see OnFrame
+
+	// Vs. System RC2C05 behaviour; see SetRC2C05
+	rc2c05   bool
+	statusID uint8
+	rgb      bool // use RGB_PALETTE; see SetRGB
+
 	// rendering variables for the background
 	bgSPLo, bgSPHi               uint16 // next tile data for rendering
 	bgSALo, bgSAHi               uint16 // next tile attrib data for rendering
@@ -149,29 +184,86 @@ type PPU struct {
 	bgNextAttrib                 uint8  // next attribute data
 	bgNextTileLSB, bgNextTileMSB uint8  // LSB and MSB of next tile
 
-	// rendering variables for sprites
+	// rendering variables for sprites. Evaluation copies the
+	// sprites on the next line into secondary OAM, and the fetches
+	// at dots 257-320 load them into the output units.
+	secondaryOAM   [32]uint8 // OAM entries of up to 8 sprites on the next line
+	nextSprites    int       // how many entries of secondaryOAM are in use
+	nextZero       bool      // sprite 0 is the first entry in secondaryOAM
+	sprites        [8]oam    // sprites on this line; x counts down to their left edge
 	activeSprites  int
-	canZeroHit     bool     // true if we're going to include sprite 0 on next scanline
+	canZeroHit     bool     // true if sprites[0] is sprite 0
 	fgSPLo, fgSPHi [8]uint8 // 8 hi and low plane registers for the 8 oams
 }
 
 func New(b Bus) *PPU {
-	ps := NES_RES_WIDTH * NES_RES_HEIGHT
-	px := make([]color.RGBA, ps, ps)
-	for i := 0; i < ps; i++ {
-		px[i] = color.RGBA{0, 0, 0, 0xFF} // Black
-	}
-
 	ppu := &PPU{
-		bus:        b,
-		pixels:     image.NewRGBA(image.Rect(0, 0, NES_RES_WIDTH, NES_RES_HEIGHT)),
-		mirrorMode: b.MirrorMode(),
+		bus:    b,
+		pixels: blackImage(),
 	}
+	ppu.front.Store(blackImage())
+	ppu.ntBus, _ = b.(NametableBus)
+	ppu.addrBus, _ = b.(AddressBus)
+	ppu.SetRegion(NTSC)
 	ppu.Reset()
 
 	return ppu
 }
 
+// Layers of the picture, for HideLayers.
+const (
+	LAYER_BACKGROUND = 1 << iota
+	LAYER_SPRITES
+)
+
+// HideLayers leaves the LAYER_XXX layers in mask out of the picture,
+// showing those not in it, for debugging graphics and capturing
+// sprites without the background. Hidden layers still collide for
+// sprite 0 hits, so games run just the same.
+func (p *PPU) HideLayers(mask uint8) {
+	p.hidden = mask
+}
+
+// HiddenLayers returns the LAYER_XXX layers hidden by HideLayers.
+func (p *PPU) HiddenLayers() uint8 {
+	return p.hidden
+}
+
+// Regions, numbered as nesrom numbers them.
+const (
+	NTSC = iota
+	PAL
+	MULTI_REGION
+	DENDY
+)
+
+// SetRegion sets the frame timing for region. PAL and Dendy PPUs have
+// 312 scanlines per frame rather than 262 and never skip a dot on odd
+// frames. Dendy delays vblank until line 291 so that NTSC games get
+// the same amount of time between NMI and rendering.
+// https://www.nesdev.org/wiki/Cycle_reference_chart
+func (p *PPU) SetRegion(region uint8) {
+	p.region = region
+	switch region {
+	case PAL:
+		p.lastLine, p.vblankStart, p.skipOddDot = 311, 241, false
+	case DENDY:
+		p.lastLine, p.vblankStart, p.skipOddDot = 311, 291, false
+	default:
+		p.lastLine, p.vblankStart, p.skipOddDot = 261, 241, true
+	}
+	p.lastLine += p.overclock
+}
+
+// SetOverclock adds lines idle scanlines to the end of vblank, so the
+// CPU gets more time each frame without games noticing, which cuts
+// slowdown in busy scenes. Games that time things with the CPU while
+// rendering, like raster effects, aren't affected.
+func (p *PPU) SetOverclock(lines uint16) {
+	p.overclock = lines
+	p.SetRegion(p.region)
+}
+
 func (p *PPU) Reset() {
 	p.scandot = 0
 	p.scanline = 0
@@ -181,13 +273,10 @@ func (p *PPU) Reset() {
 	p.ctrl = 0
 	p.mask = 0
 	p.status = 0
-	p.secondaryOAM = make([]oam, 8, 8)
+	p.clearSecondaryOAM()
+	p.nextSprites, p.nextZero = 0, false
 	p.activeSprites = 0
 	p.canZeroHit = false
-	for i := range p.secondaryOAM {
-		// this is always below a scaline that will be drawn
-		p.secondaryOAM[i].y = 0xFF
-	}
 }
 
 func (p *PPU) String() string {
@@ -203,15 +292,122 @@ func (p *PPU) GetOAM() []oam {
 	return oams
 }
 
+// GetPixels returns the last finished frame. The PPU draws into a
+// second picture and swaps the two when it finishes a frame, so this
+// one is left alone until the next frame is done, and can be read
+// from another goroutine in the meantime.
 func (p *PPU) GetPixels() *image.RGBA {
-	return p.pixels
+	return p.front.Load()
+}
+
+// Pixels returns the last finished frame as raw RGBA bytes, four per
+// pixel, a row at a time, ready for ebiten's WritePixels and the
+// like. It's GetPixels' Pix, so it's good for as long as that is.
+func (p *PPU) Pixels() []byte {
+	return p.GetPixels().Pix
+}
+
+// blackImage returns a new picture, all black rather than transparent.
+func blackImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, NES_RES_WIDTH, NES_RES_HEIGHT))
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xFF
+	}
+	return img
+}
+
+// OnFrame sets f to be called once a frame, as soon as its last
+// visible line is drawn (at line 240, dot 0), with the finished
+// picture, which GetPixels returns until the next frame is done. A
+// nil f removes the hook.
+func (p *PPU) OnFrame(f func(*image.RGBA)) {
+	p.onFrame = f
 }
 
 func (p *PPU) GetResolution() (int, int) {
 	return NES_RES_WIDTH, NES_RES_HEIGHT
 }
 
+// Frame returns the number of frames drawn since the PPU was reset.
+func (p *PPU) Frame() uint64 {
+	return p.frame
+}
+
+// Position returns the scanline and dot the PPU is on.
+func (p *PPU) Position() (scanline, dot uint16) {
+	return p.scanline, p.scandot
+}
+
+// Drawn returns the pixel at x, y of the current frame, if the PPU
+// drew it less than lines scanlines ago. It's what a light gun aimed
+// there sees, as the glow of a CRT fades soon after the beam passes.
+func (p *PPU) Drawn(x, y, lines int) (color.RGBA, bool) {
+	if x < 0 || x >= NES_RES_WIDTH || y < 0 || y >= NES_RES_HEIGHT {
+		return color.RGBA{}, false
+	}
+	line := int(p.scanline)
+	if age := line - y; age < 0 || age >= lines || age == 0 && int(p.scandot) <= x {
+		return color.RGBA{}, false
+	}
+
+	// Finished frames move to front at the end of the picture.
+	img := p.pixels
+	if line >= NES_RES_HEIGHT {
+		img = p.front.Load()
+	}
+	return img.RGBAAt(x, y), true
+}
+
+// VRAM returns the 2KB of nametable RAM inside the console. It's the
+// PPU's own memory, not a copy.
+func (p *PPU) VRAM() []uint8 {
+	return p.vram[:0x0800]
+}
+
+// OAMData returns the 256 bytes of sprite memory.
+func (p *PPU) OAMData() []uint8 {
+	return p.oamData[:]
+}
+
+// PaletteTable returns the 32 bytes of palette RAM.
+func (p *PPU) PaletteTable() []uint8 {
+	return p.paletteTable[:]
+}
+
+// SetRC2C05 makes the PPU behave like one of the Vs. System's RC2C05
+// PPUs, which have PPUCTRL and PPUMASK swapped and return id in the
+// low bits of PPUSTATUS. Games check for the id as copy protection.
+func (p *PPU) SetRC2C05(id uint8) {
+	p.rc2c05 = true
+	p.statusID = id
+}
+
+// SetRGB makes the PPU put out the colours of the RGB PPUs (2C03 and
+// 2C05) found in the Vs. System, rather than those of the composite
+// NES PPU.
+func (p *PPU) SetRGB(on bool) {
+	p.rgb = on
+}
+
+// systemColor returns the colour of palette entry c, without any
+// PPUMASK effects.
+func (p *PPU) systemColor(c uint8) color.RGBA {
+	if p.rgb {
+		return RGB_PALETTE[c&0x3F]
+	}
+	return SYSTEM_PALETTE[c&0x3F]
+}
+
 func (p *PPU) WriteReg(r uint16, val uint8) {
+	if p.rc2c05 {
+		switch r {
+		case PPUCTRL:
+			r = PPUMASK
+		case PPUMASK:
+			r = PPUCTRL
+		}
+	}
+
 	switch r {
 	case PPUCTRL:
 		p.ctrl = val
@@ -246,6 +442,7 @@ func (p *PPU) WriteReg(r uint16, val uint8) {
 			p.wLatch = 0
 		}
 	case PPUDATA:
+		p.drive(uint16(p.v))
 		p.write(uint16(p.v), val)
 		p.vramIncrement()
 	}
@@ -259,19 +456,17 @@ func (p *PPU) ReadReg(r uint16) uint8 {
 		// From NESDev - we fill the status register with the
 		// bottom contents of the buffered data.
 		ret = (p.status & 0xE0) | (p.bufferData & 0x1F)
+		if p.rc2c05 {
+			ret = (p.status & 0xE0) | p.statusID
+		}
 		p.clearVBlank()
 		p.wLatch = 0
 	case OAMDATA:
-		// While clearing OAM, reads of $2004 return 0xFF
-		// https://www.nesdev.org/wiki/PPU_sprite_evaluation#Details
-		if p.visibleLine() && p.scandot <= 64 {
-			ret = 0xFF
-		} else {
-			ret = p.oamData[p.oamaddr]
-		}
+		// Unlike writes, reads don't increment OAMADDR.
+		ret = p.readOAM()
 	case PPUDATA:
 		ret = p.bufferData
-		p.bufferData = p.read(uint16(p.v))
+		p.bufferData = p.fetch(uint16(p.v))
 		// When reading from palette range, we don't suffer
 		// the cycle delay that we do when reading other data.
 		if p.v > 0x3F00 {
@@ -283,6 +478,61 @@ func (p *PPU) ReadReg(r uint16) uint8 {
 	return ret
 }
 
+// PeekReg returns what ReadReg would for register r, without the
+// side effects of reading PPUSTATUS and PPUDATA, for debuggers.
+func (p *PPU) PeekReg(r uint16) uint8 {
+	switch r {
+	case PPUSTATUS:
+		if p.rc2c05 {
+			return (p.status & 0xE0) | p.statusID
+		}
+		return (p.status & 0xE0) | (p.bufferData & 0x1F)
+	case OAMDATA:
+		return p.readOAM()
+	case PPUDATA:
+		if p.v > 0x3F00 {
+			return p.read(uint16(p.v))
+		}
+		return p.bufferData
+	}
+	return 0x00
+}
+
+// readOAM returns what a read of $2004 sees. Outside of rendering
+// that's the OAM byte at OAMADDR, but while rendering the PPU's OAM
+// data bus carries whatever sprite evaluation and fetching are
+// reading: $FF while secondary OAM is being cleared, and secondary
+// OAM itself during the sprite fetches. The three unused bits of
+// each attribute byte don't exist and always read as 0.
+// https://www.nesdev.org/wiki/PPU_sprite_evaluation#Details
+func (p *PPU) readOAM() uint8 {
+	i, oam := int(p.oamaddr), p.oamData[:]
+	if p.renderingEnabled() && p.renderLine() {
+		switch {
+		case p.scandot >= 1 && p.scandot <= 64:
+			if p.visibleLine() {
+				return 0xFF
+			}
+		case p.scandot >= 257 && p.scandot <= 320:
+			// Each output unit reads Y, tile, attributes
+			// and then X for the rest of its 8 dots.
+			d := int(p.scandot - 257)
+			b := d % 8
+			if b > 3 {
+				b = 3
+			}
+			i, oam = d/8*4+b, p.secondaryOAM[:]
+		case p.scandot > 320 || p.scandot == 0:
+			i, oam = 0, p.secondaryOAM[:]
+		}
+	}
+
+	if i&0x03 == 2 {
+		return oam[i] & 0xE3
+	}
+	return oam[i]
+}
+
 func (p *PPU) vramIncrement() {
 	switch (p.ctrl & CTRL_VRAM_ADD_INCREMENT) >> 2 {
 	case 0:
@@ -297,6 +547,9 @@ const (
 	MIRROR_HORIZONTAL = iota
 	MIRROR_VERTICAL
 	MIRROR_FOUR_SCREEN
+	MIRROR_SINGLE_LOWER
+	MIRROR_SINGLE_UPPER
+	MIRROR_MAPPER_CONTROLLED
 )
 
 const (
@@ -310,30 +563,34 @@ const (
 
 // tileMapAddr handles mirror mode mapping of addresses with the
 // 0x2000-0x2FFF. It takes the natural address and returns the mapped
-// address within the vram range (2k).
+// address within the vram range (2k, or 4k for four screen carts,
+// which bring the extra nametables with them). The mirroring mode is
+// asked of the bus on every access because mappers can change it at
+// any time.
 func (p *PPU) tileMapAddr(addr uint16) uint16 {
 	a := addr & 0x0FFF
+	nt := uint8(a >> 10) // logical nametable, 0-3
+
 	// https://www.nesdev.org/wiki/Mirroring#Nametable_Mirroring
-	switch p.mirrorMode {
+	var page uint16 // physical nametable, 0 or 1, or up to 3 for four screen
+	switch p.bus.MirrorMode() {
 	case MIRROR_FOUR_SCREEN:
-		panic("we don't have mapper support to leverage vram on catridge")
+		page = uint16(nt)
 	case MIRROR_VERTICAL:
-		switch {
-		case (a >= 0 && a <= 0x03FF) || (a >= 0x0800 && a <= 0x0BFF): // table 0
-			a &= 0x03FF
-		case (a >= 0x0400 && a <= 0x07FF) || (a >= 0x0C00 && a <= 0x0FFF): // table 1
-			a = (a & 0x03FF) + 0x400
-		}
+		page = uint16(nt & 0x01)
 	case MIRROR_HORIZONTAL:
-		switch {
-		case (a >= 0 && a <= 0x07FF): // table 0
-			a &= 0x03FF
-		case (a >= 0x0800 && a <= 0x0FFF): // table 1
-			a = (a & 0x03FF) + 0x400
+		page = uint16(nt >> 1)
+	case MIRROR_SINGLE_LOWER:
+		page = 0
+	case MIRROR_SINGLE_UPPER:
+		page = 1
+	case MIRROR_MAPPER_CONTROLLED:
+		if p.ntBus != nil {
+			page = uint16(p.ntBus.NametablePage(nt) & 0x01)
 		}
 	}
 
-	return a
+	return page<<10 | a&0x03FF
 }
 
 // Address range  Size   Description
@@ -347,6 +604,21 @@ func (p *PPU) tileMapAddr(addr uint16) uint16 {
 // $3F00-$3F1F	  $0020  Palette RAM indexes
 // $3F20-$3FFF	  $00E0  Mirrors of $3F00-$3F1F
 
+// fetch reads addr as the PPU does while rendering or for PPUDATA,
+// putting it on the address bus. Debug views use read directly so
+// that the cartridge doesn't see them.
+func (p *PPU) fetch(addr uint16) uint8 {
+	p.drive(addr)
+	return p.read(addr)
+}
+
+// drive tells an AddressBus that addr is on the PPU's address lines.
+func (p *PPU) drive(addr uint16) {
+	if p.addrBus != nil {
+		p.addrBus.PPUAddress(addr & 0x3FFF)
+	}
+}
+
 func (p *PPU) read(addr uint16) uint8 {
 	// 0x4000 - 0xFFFF is mirrored to 0x0000 - 0x3FFF
 	a := addr & 0x3FFF
@@ -390,8 +662,9 @@ func (p *PPU) write(addr uint16, val uint8) {
 
 	switch {
 	case a < BASE_NAMETABLE:
-		// Pattern Table 0 and 1 (upper: 0x0FFF, 0x1FFF)
-		// TODO(bdwalton): Add write support
+		// Pattern Table 0 and 1 (upper: 0x0FFF, 0x1FFF). Boards
+		// with CHR ROM ignore these; CHR RAM boards store them.
+		p.bus.ChrWrite(a, val)
 	case a <= NAMETABLE_MIRROR_END:
 		p.vram[p.tileMapAddr((a&0x0FFF)+BASE_NAMETABLE)] = val
 	case a >= PALETTE_RAM && a <= PALETTE_MIRROR_END: // Palette Table
@@ -450,7 +723,7 @@ func (p *PPU) visibleDot() bool {
 }
 
 func (p *PPU) prerenderLine() bool {
-	return p.scanline == 261
+	return p.scanline == p.lastLine
 }
 
 func (p *PPU) renderLine() bool {
@@ -470,7 +743,7 @@ func (p *PPU) fetchCycle() bool {
 }
 
 func (p *PPU) incrementScan() {
-	if p.renderingEnabled() && p.oddFrame && p.prerenderLine() && p.scandot == 339 {
+	if p.skipOddDot && p.renderingEnabled() && p.oddFrame && p.prerenderLine() && p.scandot == 339 {
 		p.scandot = 0
 		p.scanline = 0
 		p.frame++
@@ -482,7 +755,7 @@ func (p *PPU) incrementScan() {
 	if p.scandot >= 341 {
 		p.scandot = 0
 		p.scanline++
-		if p.scanline > 261 {
+		if p.scanline > p.lastLine {
 			p.scanline = 0
 			p.frame++
 			p.oddFrame = !p.oddFrame
@@ -505,7 +778,7 @@ func (p *PPU) updateBG() {
 		// that can be referenced in the ROM. (ROMs may
 		// support hardward mapping to swap out tiles
 		// transparently to the PPU)
-		p.bgNextTile = p.read(BASE_NAMETABLE | (uint16(p.v) & 0xFFF))
+		p.bgNextTile = p.fetch(BASE_NAMETABLE | (uint16(p.v) & 0xFFF))
 	case 3: // Attribute table lookup. Read from nametable space,
 		// but only in the offset to attribute table
 		// data. Recall that the nametable is 4096 bytes
@@ -517,7 +790,7 @@ func (p *PPU) updateBG() {
 		// blocks worth of palette indexing. This makes each
 		// block (2x2 tiles) use a single palette which
 		// restricts it to 4 colors.
-		p.bgNextAttrib = p.read(BASE_NAMETABLE |
+		p.bgNextAttrib = p.fetch(BASE_NAMETABLE |
 			ATTRIBUTE_OFFSET |
 			p.v.nametableY()<<11 |
 			p.v.nametableX()<<10 |
@@ -543,13 +816,13 @@ func (p *PPU) updateBG() {
 		addr := (uint16(p.backgroundTableID()) << 12) +
 			(uint16(p.bgNextTile) << 4) + // using tile id * 16 as index
 			p.v.fineY() // shifted fine Y bytes in to pull the right row of the tile
-		p.bgNextTileLSB = p.read(addr)
+		p.bgNextTileLSB = p.fetch(addr)
 	case 7: // Background CHR most significant byte
 		addr := (uint16(p.backgroundTableID()) << 12) +
 			uint16(p.bgNextTile)<<4 +
 			p.v.fineY() +
 			8 // next plane within the tile
-		p.bgNextTileMSB = p.read(addr)
+		p.bgNextTileMSB = p.fetch(addr)
 	case 0: // Shifters. These store the tile data (low and high
 		// plane, respectively) from CHR rom. Loading them
 		// means taking the LSB and MSB that we previously
@@ -565,8 +838,8 @@ func (p *PPU) updateBG() {
 func (p *PPU) updateFGShifters() {
 	if p.renderForeground() {
 		for i := 0; i < p.activeSprites; i++ {
-			if p.secondaryOAM[i].x > 0 {
-				p.secondaryOAM[i].x -= 1
+			if p.sprites[i].x > 0 {
+				p.sprites[i].x -= 1
 			} else {
 				p.fgSPLo[i] <<= 1
 				p.fgSPHi[i] <<= 1
@@ -641,57 +914,88 @@ func (p *PPU) renderPixel() {
 	var fgPrio, renderZero bool
 	if p.renderForeground() {
 		for i := 0; i < p.activeSprites; i++ {
-			o := p.secondaryOAM[i]
-			if o.x == 0 {
-				fgPix = ((p.fgSPHi[i] & 0x80) >> 6) | (p.fgSPLo[i]&0x80)>>7
-				fgPal = o.palette + 0x04 // sprite palettes are the latter 4
-				if o.renderP == FRONT {
-					fgPrio = true
-				}
-
-				// We render this one and skip others
-				// because later sprites are lower
-				// priority.
-				if fgPix != 0 {
-					if i == 0 {
-						renderZero = true
-					}
-					break
-				}
+			o := p.sprites[i]
+			if o.x != 0 {
+				continue
+			}
+			pix := ((p.fgSPHi[i] & 0x80) >> 6) | (p.fgSPLo[i]&0x80)>>7
+			if pix == 0 {
+				continue
 			}
+
+			// The first opaque sprite wins, and its
+			// priority decides whether it's drawn over
+			// the background, even if a later sprite
+			// would have been in front.
+			fgPix = pix
+			fgPal = o.palette + 0x04 // sprite palettes are the latter 4
+			fgPrio = o.renderP == FRONT
+			renderZero = i == 0
+			break
 		}
 	}
 
-	// Now select the foreground or background pixel based on priority
-	var pix, pal uint8 = bgPix, bgPal // default to background
-	switch {
-	case bgPix == 0 && fgPix > 0:
-		pix = fgPix
-		pal = fgPal
-	case bgPix > 0 && fgPix > 0:
-		if fgPrio {
-			pix = fgPix
-			pal = fgPal
-		}
-
-		if p.canZeroHit && renderZero {
-			if p.renderBackground() && p.renderForeground() {
-				if p.mask&(MASK_SHOW_LEFT_TILES|MASK_SHOW_LEFT_SPRITES) > 0 {
-					if p.scandot >= 1 && p.scandot < 258 {
-						p.status |= STATUS_SPRITE_0_HIT
-					}
-
-				} else {
-					if p.scandot >= 9 && p.scandot < 258 {
-						p.status |= STATUS_SPRITE_0_HIT
-					}
-				}
-			}
+	// The leftmost 8 pixels can be blanked for either layer, often
+	// to hide scrolling glitches. Blanked pixels are transparent,
+	// so they can't hit sprite 0 either.
+	if p.scandot <= 8 {
+		if p.mask&MASK_SHOW_LEFT_TILES == 0 {
+			bgPix, bgPal = 0, 0
+		}
+		if p.mask&MASK_SHOW_LEFT_SPRITES == 0 {
+			fgPix = 0
+		}
+	}
+
+	pix, pal := compose(bgPix, bgPal, fgPix, fgPal, fgPrio)
+	// There's never a hit on the last pixel of the line.
+	if bgPix > 0 && fgPix > 0 && p.canZeroHit && renderZero && p.scandot != 256 {
+		if p.renderBackground() && p.renderForeground() {
+			p.status |= STATUS_SPRITE_0_HIT
 		}
 	}
 
+	if p.hidden != 0 {
+		if p.hidden&LAYER_BACKGROUND > 0 {
+			bgPix, bgPal = 0, 0
+		}
+		if p.hidden&LAYER_SPRITES > 0 {
+			fgPix = 0
+		}
+		pix, pal = compose(bgPix, bgPal, fgPix, fgPal, fgPrio)
+	}
+
 	a := uint16(PALETTE_RAM) + (uint16(pal) << 2) + uint16(pix)
-	p.pixels.Set(int(p.scandot-1), int(p.scanline), SYSTEM_PALETTE[p.read(a)&0x3F])
+	c := p.color(p.read(a))
+	i := (int(p.scanline)*NES_RES_WIDTH + int(p.scandot-1)) * 4
+	px := p.pixels.Pix[i : i+4 : i+4]
+	px[0], px[1], px[2], px[3] = c.R, c.G, c.B, c.A
+}
+
+// color returns the colour the PPU puts out for palette entry c, in
+// greyscale or tinted as PPUMASK says. PAL and Dendy PPUs swap the
+// red and green emphasis bits.
+func (p *PPU) color(c uint8) color.RGBA {
+	if p.mask&MASK_GREYSCALE != 0 {
+		c &= 0x30
+	}
+	e := p.mask >> 5
+	if p.rgb {
+		return rgbEmphasized[e][c&0x3F]
+	}
+	if p.region == PAL || p.region == DENDY {
+		e = e&0x04 | e&0x01<<1 | e&0x02>>1
+	}
+	return emphasized[e][c&0x3F]
+}
+
+// compose selects the foreground or background pixel, and its
+// palette, based on priority.
+func compose(bgPix, bgPal, fgPix, fgPal uint8, fgPrio bool) (uint8, uint8) {
+	if fgPix > 0 && (bgPix == 0 || fgPrio) {
+		return fgPix, fgPal
+	}
+	return bgPix, bgPal // default to background
 }
 
 // Tick executes a PPU cycle. We call it tick instead of step because
@@ -701,6 +1005,13 @@ func (p *PPU) renderPixel() {
 func (p *PPU) Tick() {
 	p.incrementScan()
 
+	if p.scanline == NES_RES_HEIGHT && p.scandot == 0 {
+		p.pixels = p.front.Swap(p.pixels)
+		if p.onFrame != nil {
+			p.onFrame(p.GetPixels())
+		}
+	}
+
 	if p.prerenderLine() {
 		if p.scandot == 1 {
 			p.clearVBlank()
@@ -784,7 +1095,7 @@ func (p *PPU) Tick() {
 	}
 
 	if p.vblankLine() {
-		if p.scanline == 241 && p.scandot == 1 {
+		if p.scanline == p.vblankStart && p.scandot == 1 {
 			p.setVBlank()
 			if p.nmiEnabled() {
 				p.bus.TriggerNMI()
@@ -792,96 +1103,143 @@ func (p *PPU) Tick() {
 		}
 	}
 
-	// Foreground rendering setup - this is decoupled from
-	// background, so we can separate it out here. As long as we
-	// incorporate the foreground aspects into renderPixel(), this
-	// should be fine.  We're going to (for now) do this all in
-	// one shot instead of in a cycle accurate way.
-	if p.visibleLine() {
-		if p.scandot == 257 { // outside of visible pixels for the line
-			// Prime OAM counters for this scanline
-			p.activeSprites = 0
-			p.canZeroHit = false
-
-			for i := range p.secondaryOAM {
-				// this is always below a scaline that will be drawn
-				p.secondaryOAM[i].y = 0xFF
-			}
-
-			ss := p.spriteSize()
-			// Fill secondary oam. we don't implement the bug here
-			// (using tileid as y), but will add that later if it
-			// turns out to be needed by games.
-			for oim := 0; oim < 64; oim += 4 {
-				o := OAMFromBytes(p.oamData[oim : oim+4])
-				// oam visible on this line and we haven't overflowed
-				if d := int(p.scanline - uint16(o.y)); d >= 0 && d < ss {
-					if oim == 0 {
-						p.canZeroHit = true
-					}
-					if p.activeSprites < 8 {
-						p.secondaryOAM[p.activeSprites] = o
-						p.activeSprites++
-					} else {
-						p.status |= STATUS_SPRITE_OVERFLOW
-						break
-					}
-				}
-			}
+	// Sprites are worked out a line ahead: secondary OAM is
+	// cleared while the line is drawn, filled with the next line's
+	// sprites once it's done, and their patterns fetched during
+	// the horizontal blank. Nothing is evaluated on the pre-render
+	// line, so sprites never show on line 0.
+	if p.renderingEnabled() {
+		switch {
+		case p.visibleLine() && p.scandot == 64:
+			p.clearSecondaryOAM()
+		case p.visibleLine() && p.scandot == 256:
+			p.evaluateSprites()
+		case p.prerenderLine() && p.scandot == 256:
+			p.nextSprites, p.nextZero = 0, false
+		case p.renderLine() && p.scandot >= 257 && p.scandot <= 320:
+			p.fetchSprite()
 		}
+	}
+}
 
-		if p.scandot >= 320 {
-			for i, o := range p.secondaryOAM {
-				var addr uint16
-
-				// This looks like reading a background tile
-				// with the extra step of picking the line to
-				// read based on the y offset and handling
-				// vertical inversion.
+// clearSecondaryOAM fills secondary OAM with $FF, as the PPU does
+// during dots 1-64 of each visible line.
+func (p *PPU) clearSecondaryOAM() {
+	for i := range p.secondaryOAM {
+		p.secondaryOAM[i] = 0xFF
+	}
+}
 
-				// by default - 16 px sprites override both chrIdx and tile
-				var chrIdx uint16 = p.spriteTableID()
-				var tile uint16 = uint16(o.tileId)
+// evaluateSprites copies the first eight sprites in OAM that are on
+// the next line into secondary OAM, and then looks for a ninth to set
+// the overflow flag. The PPU spreads this over dots 65-256.
+//
+// The search for a ninth sprite is buggy: after each sprite that
+// isn't on the line, the PPU moves on to the next sprite's next byte,
+// rather than its Y, so it compares tile numbers, attributes and X
+// positions as though they were Y positions. That misses real
+// overflows and finds false ones, and games and test ROMs that use
+// the flag depend on it.
+// https://www.nesdev.org/wiki/PPU_sprite_evaluation#Sprite_overflow_bug
+func (p *PPU) evaluateSprites() {
+	p.nextSprites, p.nextZero = 0, false
+	ss := p.spriteSize()
+	onLine := func(y uint8) bool {
+		d := int(p.scanline) - int(y)
+		return d >= 0 && d < ss
+	}
 
-				// The &0x0007 is for 16px tiles, but doesn't
-				// hurt 8px tiles, so we generalize it.
-				var yoff uint16 = (p.scanline - uint16(o.y)) & 0x0007
-				if o.flipV {
-					yoff = 7 - yoff
-				}
+	n := 0
+	for ; n < 64 && p.nextSprites < 8; n++ {
+		e := p.oamData[n*4 : n*4+4]
+		if !onLine(e[0]) {
+			continue
+		}
+		if n == 0 {
+			p.nextZero = true
+		}
+		copy(p.secondaryOAM[p.nextSprites*4:], e)
+		p.nextSprites++
+	}
 
-				if p.spriteSize() == 16 {
-					// When sprites are 16 pixels tall, a
-					// few things change:
-					//
-					// 1 - we don't use the control
-					// register to select the CHR bank, we
-					// use tile id to help with that.
-					//
-					// 2 - we need to know if we're past
-					// the first 8 lines of the sprite so
-					// we index into CHR for the adjacent
-					// tile.
-					chrIdx = uint16(o.tileId & 0x01)
-					tile &= 0x00FE
-					// top half of upside down tile or bottom half of normal orientation
-					if (o.flipV && p.scanline-uint16(o.y) < 8) || (!o.flipV && p.scanline-uint16(o.y) >= 8) {
-						tile++ // adjacent tile
-					}
+	for m := 0; n < 64; n++ {
+		if onLine(p.oamData[n*4+m]) {
+			p.status |= STATUS_SPRITE_OVERFLOW
+			return
+		}
+		m = (m + 1) & 0x03 // the bug: m should stay 0
+	}
+}
 
-				}
+// fetchSprite does one dot of the sprite fetches at dots 257-320,
+// which take 8 dots for each of the 8 output units. Like the
+// background's, they read the nametable and attributes (here
+// ignored) and then the two planes of the sprite's row. Units
+// without a sprite fetch tile $FF and stay transparent, which
+// matters to mappers that watch the PPU's address lines.
+func (p *PPU) fetchSprite() {
+	i := int(p.scandot-257) / 8
+	switch (p.scandot - 257) % 8 {
+	case 0:
+		if i == 0 {
+			p.activeSprites, p.canZeroHit = p.nextSprites, p.nextZero
+		}
+		p.sprites[i] = OAMFromBytes(p.secondaryOAM[i*4 : i*4+4])
+	case 4:
+		p.fgSPLo[i] = p.spriteRow(i, 0)
+	case 6:
+		p.fgSPHi[i] = p.spriteRow(i, 8)
+	}
+}
 
-				addr = chrIdx<<12 | tile<<4 | yoff
-				p.fgSPLo[i] = p.read(addr)
-				// +8 gets us into the next plane of this CHR
-				// tile. Just like background rendering.
-				p.fgSPHi[i] = p.read(addr + 8)
+// spriteRow fetches the next line's row of output unit i's sprite
+// from the pattern plane at offset plane (0 or 8), flipped as the
+// sprite says.
+func (p *PPU) spriteRow(i int, plane uint16) uint8 {
+	o := p.sprites[i]
+
+	// This looks like reading a background tile with the extra
+	// step of picking the line to read based on the y offset and
+	// handling vertical inversion.
+
+	// by default - 16 px sprites override both chrIdx and tile
+	var chrIdx uint16 = p.spriteTableID()
+	var tile uint16 = uint16(o.tileId)
+	d := p.scanline - uint16(o.y)
+
+	// The &0x0007 is for 16px tiles, but doesn't hurt 8px tiles,
+	// so we generalize it.
+	var yoff uint16 = d & 0x0007
+	if o.flipV {
+		yoff = 7 - yoff
+	}
 
-				if o.flipH {
-					p.fgSPLo[i] = bits.Reverse8(p.fgSPLo[i])
-					p.fgSPHi[i] = bits.Reverse8(p.fgSPHi[i])
-				}
-			}
+	if p.spriteSize() == 16 {
+		// When sprites are 16 pixels tall, a few things
+		// change:
+		//
+		// 1 - we don't use the control register to select the
+		// CHR bank, we use tile id to help with that.
+		//
+		// 2 - we need to know if we're past the first 8 lines
+		// of the sprite so we index into CHR for the adjacent
+		// tile.
+		chrIdx = uint16(o.tileId & 0x01)
+		tile &= 0x00FE
+		// top half of upside down tile or bottom half of normal orientation
+		if (o.flipV && d < 8) || (!o.flipV && d >= 8) {
+			tile++ // adjacent tile
 		}
 	}
+
+	// +8 gets us into the next plane of this CHR tile. Just like
+	// background rendering.
+	row := p.fetch(chrIdx<<12 | tile<<4 | yoff + plane)
+	if i >= p.activeSprites {
+		return 0
+	}
+	if o.flipH {
+		row = bits.Reverse8(row)
+	}
+	return row
 }
@@ -1,7 +1,12 @@
 package ppu
 
 import (
+	"bytes"
+	"image"
+	"image/color"
 	"testing"
+
+	"github.com/bdwalton/gintendo/state"
 )
 
 type testBus struct {
@@ -17,6 +22,8 @@ func (tb *testBus) ChrRead(addr uint16) uint8 {
 	return 0
 }
 
+func (tb *testBus) ChrWrite(addr uint16, val uint8) {}
+
 func (tb *testBus) TriggerNMI() {
 	tb.nmiTriggered = true
 }
@@ -130,6 +137,19 @@ func TestTileMapAddr(t *testing.T) {
 		{0x2801, MIRROR_VERTICAL, 0x0001},
 		{0x2C00, MIRROR_VERTICAL, 0x0400},
 		{0x2C01, MIRROR_VERTICAL, 0x0401},
+		{0x2000, MIRROR_SINGLE_LOWER, 0x0000},
+		{0x2401, MIRROR_SINGLE_LOWER, 0x0001},
+		{0x2BFF, MIRROR_SINGLE_LOWER, 0x03FF},
+		{0x2C01, MIRROR_SINGLE_LOWER, 0x0001},
+		{0x2000, MIRROR_SINGLE_UPPER, 0x0400},
+		{0x2401, MIRROR_SINGLE_UPPER, 0x0401},
+		{0x2BFF, MIRROR_SINGLE_UPPER, 0x07FF},
+		{0x2C01, MIRROR_SINGLE_UPPER, 0x0401},
+		{0x2000, MIRROR_FOUR_SCREEN, 0x0000},
+		{0x2401, MIRROR_FOUR_SCREEN, 0x0401},
+		{0x2802, MIRROR_FOUR_SCREEN, 0x0802},
+		{0x2FFF, MIRROR_FOUR_SCREEN, 0x0FFF},
+		{0x3C00, MIRROR_FOUR_SCREEN, 0x0C00},
 	}
 
 	for i, tc := range cases {
@@ -212,11 +232,11 @@ func TestWriteRegPPUSCROLL(t *testing.T) {
 	}{
 		// These are cumulative
 		{0b11001100, 0b00000000_00011001, 0b00000100, 1},
-		{0b01010101, 0b00000001_01011001, 0b00000100, 0},
-		{0b11111111, 0b00000001_01011111, 0b00000111, 1},
+		{0b01010101, 0b01010001_01011001, 0b00000100, 0},
+		{0b11111111, 0b01010001_01011111, 0b00000111, 1},
 		{0b00000000, 0b00000000_00011111, 0b00000111, 0},
 		{0b01101010, 0b00000000_00001101, 0b00000010, 1},
-		{0b01101010, 0b00000001_10101101, 0b00000010, 0},
+		{0b01101010, 0b00100001_10101101, 0b00000010, 0},
 	}
 
 	p := New(&testBus{})
@@ -277,6 +297,46 @@ func TestWriteRegOAMDATA(t *testing.T) {
 	}
 }
 
+func TestReadRegOAMDATA(t *testing.T) {
+	cases := []struct {
+		mask     uint8
+		scanline uint16
+		scandot  uint16
+		oamaddr  uint8
+		want     uint8
+	}{
+		{0, 10, 30, 0x04, 0x10},               // rendering disabled: primary OAM
+		{0, 10, 30, 0x06, 0xE2},               // attribute bits 2-4 read as 0
+		{MASK_RENDER_BG, 10, 30, 0x04, 0xFF},  // clearing secondary OAM
+		{MASK_RENDER_BG, 10, 100, 0x04, 0x10}, // evaluating
+		{MASK_RENDER_BG, 10, 257, 0x04, 0x80}, // unit 0's Y
+		{MASK_RENDER_BG, 10, 267, 0x04, 0x82}, // unit 1's attributes
+		{MASK_RENDER_BG, 10, 271, 0x04, 0x83}, // unit 1's X, repeated
+		{MASK_RENDER_BG, 10, 330, 0x04, 0x80}, // secondary OAM's first byte
+		{MASK_RENDER_BG, 245, 30, 0x04, 0x10}, // vblank
+		{MASK_RENDER_FG, 261, 30, 0x06, 0xE2}, // prerender doesn't clear
+	}
+
+	for i, tc := range cases {
+		p := New(&testBus{})
+		for j := range p.oamData {
+			p.oamData[j] = uint8(j) * 4
+		}
+		p.oamData[6] = 0xFE
+		for j := range p.secondaryOAM {
+			p.secondaryOAM[j] = 0x80 + uint8(j)%4
+		}
+		p.mask, p.scanline, p.scandot, p.oamaddr = tc.mask, tc.scanline, tc.scandot, tc.oamaddr
+
+		if got := p.ReadReg(OAMDATA); got != tc.want {
+			t.Errorf("%d: ReadReg(OAMDATA) = 0x%02x, wanted 0x%02x", i, got, tc.want)
+		}
+		if p.oamaddr != tc.oamaddr {
+			t.Errorf("%d: OAMADDR = 0x%02x after read, wanted 0x%02x", i, p.oamaddr, tc.oamaddr)
+		}
+	}
+}
+
 func TestWriteRegPPUADDR(t *testing.T) {
 	cases := []struct {
 		val    uint8
@@ -302,3 +362,715 @@ func TestWriteRegPPUADDR(t *testing.T) {
 		}
 	}
 }
+
+type nametableTestBus struct {
+	testBus
+	pages [4]uint8
+}
+
+func (tb *nametableTestBus) NametablePage(nt uint8) uint8 {
+	return tb.pages[nt]
+}
+
+func TestTileMapAddrMapperControlled(t *testing.T) {
+	cases := []struct {
+		addr  uint16
+		pages [4]uint8
+		want  uint16
+	}{
+		{0x2000, [4]uint8{1, 0, 0, 0}, 0x0400},
+		{0x2401, [4]uint8{1, 0, 0, 0}, 0x0001},
+		{0x2802, [4]uint8{0, 0, 1, 0}, 0x0402},
+		{0x2C03, [4]uint8{0, 0, 1, 0}, 0x0003},
+		{0x2C03, [4]uint8{0, 0, 1, 1}, 0x0403},
+	}
+
+	for i, tc := range cases {
+		p := New(&nametableTestBus{testBus{mirrorMode: MIRROR_MAPPER_CONTROLLED}, tc.pages})
+		if got := p.tileMapAddr(tc.addr); got != tc.want {
+			t.Errorf("%d: Mapped 0x%04x and got 0x%04x, wanted 0x%04x", i, tc.addr, got, tc.want)
+		}
+	}
+}
+
+func TestRC2C05(t *testing.T) {
+	p := New(&testBus{})
+	p.SetRC2C05(0x3D)
+
+	p.WriteReg(PPUCTRL, MASK_RENDER_BG)
+	p.WriteReg(PPUMASK, CTRL_GENERATE_NMI)
+	if p.mask != MASK_RENDER_BG || p.ctrl != CTRL_GENERATE_NMI {
+		t.Errorf("Got ctrl=%08b, mask=%08b; registers weren't swapped", p.ctrl, p.mask)
+	}
+
+	p.setVBlank()
+	if got := p.ReadReg(PPUSTATUS); got != 0xBD {
+		t.Errorf("PPUSTATUS = 0x%02x, wanted 0xBD", got)
+	}
+}
+
+func TestRegionTiming(t *testing.T) {
+	cases := []struct {
+		region     uint8
+		overclock  uint16
+		wantTicks  int
+		wantVBlank uint16
+	}{
+		{NTSC, 0, 262 * 341, 241},
+		{PAL, 0, 312 * 341, 241},
+		{DENDY, 0, 312 * 341, 291},
+		{NTSC, 50, 312 * 341, 241},
+	}
+
+	for i, tc := range cases {
+		tb := &testBus{}
+		p := New(tb)
+		p.SetRegion(tc.region)
+		p.SetOverclock(tc.overclock)
+		p.WriteReg(PPUCTRL, CTRL_GENERATE_NMI)
+
+		var vblank uint16
+		ticks := 0
+		for p.frame == 0 {
+			p.Tick()
+			ticks++
+			if tb.nmiTriggered && vblank == 0 {
+				vblank = p.scanline
+			}
+		}
+
+		if ticks != tc.wantTicks || vblank != tc.wantVBlank {
+			t.Errorf("%d: Got %d ticks per frame, vblank at %d, wanted %d, %d", i, ticks, vblank, tc.wantTicks, tc.wantVBlank)
+		}
+	}
+}
+
+func TestFrameAllocs(t *testing.T) {
+	p := New(&testBus{})
+	p.WriteReg(PPUMASK, MASK_RENDER_BG|MASK_RENDER_FG)
+
+	frame := func() {
+		for f := p.frame; p.frame == f; {
+			p.Tick()
+		}
+	}
+	frame()
+
+	if n := testing.AllocsPerRun(5, frame); n != 0 {
+		t.Errorf("Rendering a frame made %.0f allocations, wanted 0", n)
+	}
+}
+
+func TestOnFrame(t *testing.T) {
+	p := New(&testBus{})
+	p.WriteReg(PPUMASK, MASK_RENDER_BG|MASK_RENDER_FG)
+
+	var frames int
+	p.OnFrame(func(img *image.RGBA) {
+		frames++
+		if line, dot := p.Position(); line != NES_RES_HEIGHT || dot != 0 || img != p.GetPixels() {
+			t.Errorf("OnFrame called at %d,%d with %p, wanted 240,0 with %p", line, dot, img, p.GetPixels())
+		}
+	})
+	for p.Frame() < 3 {
+		p.Tick()
+	}
+	if frames != 3 {
+		t.Errorf("OnFrame called %d times in 3 frames, wanted 3", frames)
+	}
+
+	p.OnFrame(nil)
+	for p.Frame() < 4 {
+		p.Tick()
+	}
+	if frames != 3 {
+		t.Errorf("OnFrame called after being removed")
+	}
+}
+
+func TestDoubleBuffer(t *testing.T) {
+	p := New(&testBus{})
+	p.paletteTable[0x00] = 0x01
+	for p.Frame() < 1 {
+		p.Tick()
+	}
+	front := p.GetPixels()
+	if front == p.pixels {
+		t.Fatalf("GetPixels() returned the picture being drawn")
+	}
+
+	// The next frame is drawn elsewhere until it's finished.
+	p.paletteTable[0x00] = 0x02
+	for line, _ := p.Position(); line < 100; line, _ = p.Position() {
+		p.Tick()
+	}
+	if got, want := p.GetPixels().RGBAAt(0, 0), SYSTEM_PALETTE[0x01]; p.GetPixels() != front || got != want {
+		t.Errorf("Mid-frame, GetPixels() top left = %v, want the last frame's %v", got, want)
+	}
+	for line, _ := p.Position(); line < NES_RES_HEIGHT; line, _ = p.Position() {
+		p.Tick()
+	}
+	if got, want := p.GetPixels().RGBAAt(0, 0), SYSTEM_PALETTE[0x02]; got != want {
+		t.Errorf("After the frame, GetPixels() top left = %v, want %v", got, want)
+	}
+
+	c := SYSTEM_PALETTE[0x02]
+	last := len(p.Pixels()) - 4
+	if got := p.Pixels()[last:]; len(p.Pixels()) != NES_RES_WIDTH*NES_RES_HEIGHT*4 || got[0] != c.R || got[1] != c.G || got[2] != c.B || got[3] != 0xFF {
+		t.Errorf("Pixels() has %d bytes ending % X, want %d ending in %v", len(p.Pixels()), got, NES_RES_WIDTH*NES_RES_HEIGHT*4, c)
+	}
+}
+
+func TestHideLayers(t *testing.T) {
+	const backdrop, bg, sprite = 0x0F, 0x01, 0x16
+
+	cases := []struct {
+		hidden uint8
+		want   uint8
+	}{
+		{0, sprite},
+		{LAYER_SPRITES, bg},
+		{LAYER_BACKGROUND, sprite},
+		{LAYER_BACKGROUND | LAYER_SPRITES, backdrop},
+	}
+
+	for _, tc := range cases {
+		p := New(&testBus{})
+		p.paletteTable[0x00] = backdrop
+		p.paletteTable[0x01] = bg
+		p.paletteTable[0x11] = sprite
+		p.mask = MASK_RENDER_BG | MASK_RENDER_FG | MASK_SHOW_LEFT_TILES | MASK_SHOW_LEFT_SPRITES
+		p.scanline, p.scandot = 10, 1
+
+		// Opaque background and sprite 0 pixels overlap.
+		p.bgSPLo = 0x8000
+		p.activeSprites, p.canZeroHit = 1, true
+		p.sprites[0] = oam{x: 0, renderP: FRONT}
+		p.fgSPLo[0] = 0x80
+
+		p.HideLayers(tc.hidden)
+		p.renderPixel()
+		if got, want := p.pixels.RGBAAt(0, 10), SYSTEM_PALETTE[tc.want]; got != want {
+			t.Errorf("hidden=%02b: pixel = %v, want %v", tc.hidden, got, want)
+		}
+		if p.status&STATUS_SPRITE_0_HIT == 0 {
+			t.Errorf("hidden=%02b: no sprite 0 hit", tc.hidden)
+		}
+	}
+}
+
+func TestLeftMask(t *testing.T) {
+	const backdrop, bg, sprite = 0x0F, 0x01, 0x16
+
+	cases := []struct {
+		mask uint8
+		dot  uint16
+		want uint8
+		hit  bool
+	}{
+		{MASK_SHOW_LEFT_TILES | MASK_SHOW_LEFT_SPRITES, 1, sprite, true},
+		{MASK_SHOW_LEFT_TILES, 1, bg, false},
+		{MASK_SHOW_LEFT_SPRITES, 1, sprite, false},
+		{0, 8, backdrop, false},
+		{0, 9, sprite, true},
+		{MASK_SHOW_LEFT_TILES | MASK_SHOW_LEFT_SPRITES, 256, sprite, false},
+	}
+
+	for _, tc := range cases {
+		p := New(&testBus{})
+		p.paletteTable[0x00] = backdrop
+		p.paletteTable[0x01] = bg
+		p.paletteTable[0x11] = sprite
+		p.mask = MASK_RENDER_BG | MASK_RENDER_FG | tc.mask
+		p.scanline, p.scandot = 10, tc.dot
+
+		// Opaque background and sprite 0 pixels overlap.
+		p.bgSPLo = 0x8000
+		p.activeSprites, p.canZeroHit = 1, true
+		p.sprites[0] = oam{x: 0, renderP: FRONT}
+		p.fgSPLo[0] = 0x80
+
+		p.renderPixel()
+		if got, want := p.pixels.RGBAAt(int(tc.dot)-1, 10), SYSTEM_PALETTE[tc.want]; got != want {
+			t.Errorf("mask=%08b, dot %d: pixel = %v, want %v", tc.mask, tc.dot, got, want)
+		}
+		if got := p.status&STATUS_SPRITE_0_HIT != 0; got != tc.hit {
+			t.Errorf("mask=%08b, dot %d: sprite 0 hit = %t, want %t", tc.mask, tc.dot, got, tc.hit)
+		}
+	}
+}
+
+func TestColorEffects(t *testing.T) {
+	const entry = 0x16 // a red
+	base := SYSTEM_PALETTE[entry]
+	dimmed := func(v uint8) uint8 { return uint8(float64(v) * emphasisDim) }
+
+	cases := []struct {
+		region uint8
+		mask   uint8
+		want   color.RGBA
+	}{
+		{NTSC, 0, base},
+		{NTSC, MASK_GREYSCALE, SYSTEM_PALETTE[0x10]},
+		{NTSC, MASK_EMPHASIZE_RED, color.RGBA{base.R, dimmed(base.G), dimmed(base.B), 0xFF}},
+		{NTSC, MASK_EMPHASIZE_BLUE, color.RGBA{dimmed(base.R), dimmed(base.G), base.B, 0xFF}},
+		{NTSC, MASK_EMPHASIZE_RED | MASK_EMPHASIZE_GREEN | MASK_EMPHASIZE_BLUE, color.RGBA{dimmed(base.R), dimmed(base.G), dimmed(base.B), 0xFF}},
+		// PAL's "red" bit emphasizes green.
+		{PAL, MASK_EMPHASIZE_RED, color.RGBA{dimmed(base.R), base.G, dimmed(base.B), 0xFF}},
+		{DENDY, MASK_EMPHASIZE_GREEN, color.RGBA{base.R, dimmed(base.G), dimmed(base.B), 0xFF}},
+	}
+
+	for _, tc := range cases {
+		p := New(&testBus{})
+		p.SetRegion(tc.region)
+		p.paletteTable[0x00] = entry
+		p.mask = MASK_SHOW_LEFT_TILES | tc.mask
+		p.scanline, p.scandot = 0, 1
+
+		p.renderPixel()
+		if got := p.pixels.RGBAAt(0, 0); got != tc.want {
+			t.Errorf("region %d, mask %08b: pixel = %v, want %v", tc.region, tc.mask, got, tc.want)
+		}
+	}
+
+	// The blacks aren't tinted.
+	if got := emphasized[7][0x0F]; got != SYSTEM_PALETTE[0x0F] {
+		t.Errorf("Emphasized black = %v, want %v", got, SYSTEM_PALETTE[0x0F])
+	}
+}
+
+func TestRGBPalette(t *testing.T) {
+	const entry = 0x16 // a red
+	cases := []struct {
+		mask uint8
+		want color.RGBA
+	}{
+		{0, color.RGBA{0xFF, 0x00, 0x00, 0xFF}},
+		{MASK_GREYSCALE, RGB_PALETTE[0x10]},
+		// The RGB PPUs turn emphasized channels fully on.
+		{MASK_EMPHASIZE_GREEN, color.RGBA{0xFF, 0xFF, 0x00, 0xFF}},
+		{MASK_EMPHASIZE_BLUE, color.RGBA{0xFF, 0x00, 0xFF, 0xFF}},
+	}
+
+	for _, tc := range cases {
+		p := New(&testBus{})
+		p.SetRGB(true)
+		p.paletteTable[0x00] = entry
+		p.mask = MASK_SHOW_LEFT_TILES | tc.mask
+		p.scanline, p.scandot = 0, 1
+
+		p.renderPixel()
+		if got := p.pixels.RGBAAt(0, 0); got != tc.want {
+			t.Errorf("mask %08b: pixel = %v, want %v", tc.mask, got, tc.want)
+		}
+	}
+
+	// The viewers use the same palette.
+	p := New(&testBus{})
+	p.SetRGB(true)
+	p.paletteTable[0x00] = entry
+	if got := p.Palettes(nil).RGBAAt(0, 0); got != RGB_PALETTE[entry] {
+		t.Errorf("Palettes() swatch = %v, want %v", got, RGB_PALETTE[entry])
+	}
+}
+
+// chrBus is a testBus with CHR memory.
+type chrBus struct {
+	testBus
+	chr [0x2000]uint8
+}
+
+func (cb *chrBus) ChrRead(addr uint16) uint8 {
+	return cb.chr[addr&0x1FFF]
+}
+
+func (cb *chrBus) ChrWrite(addr uint16, val uint8) {
+	cb.chr[addr&0x1FFF] = val
+}
+
+func TestChrWrite(t *testing.T) {
+	b := &chrBus{}
+	p := New(b)
+	p.WriteReg(PPUADDR, 0x12)
+	p.WriteReg(PPUADDR, 0x34)
+	p.WriteReg(PPUDATA, 0xAB)
+	p.WriteReg(PPUDATA, 0xCD)
+
+	if got := b.chr[0x1234]; got != 0xAB {
+		t.Errorf("chr[0x1234] = 0x%02x, want 0xab", got)
+	}
+	if got := b.chr[0x1235]; got != 0xCD {
+		t.Errorf("chr[0x1235] = 0x%02x, want 0xcd", got)
+	}
+
+	p.WriteReg(PPUADDR, 0x12)
+	p.WriteReg(PPUADDR, 0x34)
+	p.ReadReg(PPUDATA) // Prime the read buffer
+	if got := p.ReadReg(PPUDATA); got != 0xAB {
+		t.Errorf("PPUDATA read back 0x%02x, want 0xab", got)
+	}
+}
+
+// a12Bus is a chrBus that counts the rises of A12.
+type a12Bus struct {
+	chrBus
+	a12   bool
+	rises int
+}
+
+func (ab *a12Bus) PPUAddress(addr uint16) {
+	a12 := addr&0x1000 != 0
+	if a12 && !ab.a12 {
+		ab.rises++
+	}
+	ab.a12 = a12
+}
+
+func TestAddressBus(t *testing.T) {
+	b := &a12Bus{}
+	p := New(b)
+	p.WriteReg(PPUCTRL, CTRL_SPRITE_PATTERN_ADDR)
+	p.WriteReg(PPUMASK, MASK_RENDER_BG|MASK_RENDER_FG)
+	for p.Frame() < 2 {
+		p.Tick()
+	}
+
+	// With the background at $0000 and sprites at $1000, A12 rises
+	// once per rendered line, at the sprite fetches.
+	b.rises = 0
+	for f := p.Frame(); p.Frame() == f; {
+		p.Tick()
+	}
+	if b.rises != 241 {
+		t.Errorf("A12 rose %d times in a frame, wanted 241", b.rises)
+	}
+
+	// Debug views mustn't disturb the cartridge.
+	b.rises, b.a12 = 0, false
+	p.PatternTables([2]*image.RGBA{}, 0)
+	p.SpritePreview(nil)
+	if b.rises != 0 {
+		t.Errorf("Viewers drove the address bus")
+	}
+
+	p.WriteReg(PPUADDR, 0x10)
+	p.WriteReg(PPUADDR, 0x00)
+	p.WriteReg(PPUDATA, 0)
+	if b.rises != 1 {
+		t.Errorf("A PPUDATA write to $1000 didn't drive the address bus")
+	}
+}
+
+func TestViewers(t *testing.T) {
+	b := &chrBus{}
+	b.chr[1<<4] = 0x80   // Tile 1, top left pixel, low plane
+	b.chr[1<<4+8] = 0x80 // and high plane: colour 3
+	p := New(b)
+	p.paletteTable[0x00] = 0x0F
+	p.paletteTable[0x03] = 0x01
+	p.paletteTable[0x07] = 0x02
+	p.paletteTable[0x1F] = 0x03
+
+	pt := p.PatternTable(nil, 0, 7)
+	if got, want := pt.RGBAAt(8, 0), SYSTEM_PALETTE[0x03]; got != want {
+		t.Errorf("PatternTable() tile 1 = %v, want %v", got, want)
+	}
+	if got, want := pt.RGBAAt(9, 0), SYSTEM_PALETTE[0x0F]; got != want {
+		t.Errorf("PatternTable() background = %v, want %v", got, want)
+	}
+	if again := p.PatternTable(pt, 0, 7); again != pt {
+		t.Errorf("PatternTable() didn't reuse dst")
+	}
+	both := p.PatternTables([2]*image.RGBA{pt}, 7)
+	if both[0] != pt || both[1] == nil {
+		t.Errorf("PatternTables() = %v, wanted the reused table 0 and a new table 1", both)
+	}
+	if got, want := both[1].RGBAAt(8, 0), SYSTEM_PALETTE[0x0F]; got != want {
+		t.Errorf("PatternTables() table 1 tile 1 = %v, want %v", got, want)
+	}
+
+	// Tile 1 at the top right of the first nametable, in palette 1.
+	p.vram[31] = 1
+	p.vram[ATTRIBUTE_OFFSET+7] = 0x04
+	nt := p.Nametables(nil)
+	if got, want := nt.RGBAAt(31*8, 0), SYSTEM_PALETTE[0x02]; got != want {
+		t.Errorf("Nametables() tile = %v, want %v", got, want)
+	}
+	// Horizontal mirroring puts the same tile in the second.
+	if got, want := nt.RGBAAt(NES_RES_WIDTH+31*8, 0), SYSTEM_PALETTE[0x02]; got != want {
+		t.Errorf("Nametables() mirrored tile = %v, want %v", got, want)
+	}
+
+	// Sprite 2 is tile 1 in palette 7, flipped horizontally.
+	copy(p.oamData[8:], []uint8{0x40, 1, 0x43, 0x80})
+	if got, want := p.Sprites()[2], (Sprite{X: 0x80, Y: 0x40, Tile: 1, Palette: 7, FlipH: true}); got != want {
+		t.Errorf("Sprites()[2] = %+v, want %+v", got, want)
+	}
+	sp := p.SpritePreview(nil)
+	if got, want := sp.Rect.Dy(), SPRITES_WIDTH; got != want {
+		t.Errorf("SpritePreview() is %d high, want %d for 8x8 sprites", got, want)
+	}
+	if got, want := sp.RGBAAt(2*8+7, 0), SYSTEM_PALETTE[0x03]; got != want {
+		t.Errorf("SpritePreview() flipped pixel = %v, want %v", got, want)
+	}
+	if got, want := sp.RGBAAt(2*8, 0), SYSTEM_PALETTE[0x0F]; got != want {
+		t.Errorf("SpritePreview() backdrop = %v, want %v", got, want)
+	}
+	p.ctrl |= CTRL_SPRITE_SIZE
+	if got, want := p.SpritePreview(sp).Rect.Dy(), 2*SPRITES_WIDTH; got != want {
+		t.Errorf("SpritePreview() is %d high, want %d for 8x16 sprites", got, want)
+	}
+
+	pal := p.Palettes(nil)
+	if got, want := pal.RGBAAt(15*8+4, 12), SYSTEM_PALETTE[0x03]; got != want {
+		t.Errorf("Palettes() last entry = %v, want %v", got, want)
+	}
+}
+
+func TestNametableOverlays(t *testing.T) {
+	p := New(&testBus{})
+	p.paletteTable[0x00] = 0x0F
+	backdrop := SYSTEM_PALETTE[0x0F]
+
+	// Scrolled to x=300, y=250: 44,10 into the fourth nametable.
+	p.WriteReg(PPUCTRL, 0x03)
+	p.WriteReg(PPUSCROLL, 44)
+	p.WriteReg(PPUSCROLL, 10)
+
+	nt := p.NametableOverlays(p.Nametables(nil), OVERLAY_SCROLL)
+	cases := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{300, 250, scrollColor},                           // top left
+		{300 + 255 - NAMETABLES_WIDTH, 250, scrollColor},  // top right, wrapped
+		{300, 250 + 239 - NAMETABLES_HEIGHT, scrollColor}, // bottom left, wrapped
+		{301, 251, backdrop},                              // inside
+		{16, 16, backdrop},                                // no grid
+	}
+	for _, tc := range cases {
+		if got := nt.RGBAAt(tc.x, tc.y); got != tc.want {
+			t.Errorf("Scroll overlay at %d,%d = %v, want %v", tc.x, tc.y, got, tc.want)
+		}
+	}
+
+	nt = p.NametableOverlays(p.Nametables(nt), OVERLAY_ATTRIBUTES)
+	if got := nt.RGBAAt(16, 16); got != attributeGridColor {
+		t.Errorf("Attribute grid at 16,16 = %v, want %v", got, attributeGridColor)
+	}
+	if got := nt.RGBAAt(17, 16); got != backdrop {
+		t.Errorf("Attribute grid at 17,16 = %v, wanted it dotted", got)
+	}
+	if got := nt.RGBAAt(300, 250); got != backdrop {
+		t.Errorf("Scroll overlay drawn when not asked for")
+	}
+}
+
+func TestPeekReg(t *testing.T) {
+	p := New(&testBus{})
+	p.status |= STATUS_VERTICAL_BLANK
+	p.wLatch = 1
+
+	for i := 0; i < 2; i++ {
+		if got := p.PeekReg(PPUSTATUS); got&STATUS_VERTICAL_BLANK == 0 {
+			t.Errorf("PeekReg(PPUSTATUS) = 0x%02x, wanted vblank set", got)
+		}
+	}
+	if p.wLatch != 1 {
+		t.Errorf("PeekReg(PPUSTATUS) reset the write latch")
+	}
+
+	v := p.v
+	p.PeekReg(PPUDATA)
+	if p.v != v {
+		t.Errorf("PeekReg(PPUDATA) moved v from 0x%04x to 0x%04x", v, p.v)
+	}
+
+	if p.ReadReg(PPUSTATUS); p.PeekReg(PPUSTATUS)&STATUS_VERTICAL_BLANK != 0 {
+		t.Errorf("ReadReg(PPUSTATUS) didn't clear vblank")
+	}
+}
+
+func TestSprites(t *testing.T) {
+	const backdrop, bg, front, behind = 0x0F, 0x01, 0x16, 0x2A
+
+	// sprite returns OAM bytes for a sprite drawn from line y.
+	sprite := func(y, tile, attr, x uint8) []uint8 {
+		return []uint8{y - 1, tile, attr, x}
+	}
+	var nine [][]uint8
+	for i := 0; i < 9; i++ {
+		nine = append(nine, sprite(10, 1, 0x00, uint8(i*8)))
+	}
+
+	cases := []struct {
+		name    string
+		oam     [][]uint8
+		bgTile  uint8 // tile 0 is clear, 2 is opaque
+		x       int
+		want    uint8
+		overflw bool
+	}{
+		{"sprite", [][]uint8{sprite(10, 1, 0x00, 20)}, 0, 20, front, false},
+		{"left of sprite", [][]uint8{sprite(10, 1, 0x00, 20)}, 0, 19, backdrop, false},
+		{"right edge", [][]uint8{sprite(10, 1, 0x00, 20)}, 0, 27, front, false},
+		{"in front", [][]uint8{sprite(10, 1, 0x00, 20)}, 2, 20, front, false},
+		{"behind", [][]uint8{sprite(10, 1, 0x21, 20)}, 2, 20, bg, false},
+		{"behind, clear bg", [][]uint8{sprite(10, 1, 0x21, 20)}, 0, 20, behind, false},
+		// A lower numbered sprite behind the background still hides
+		// one in front of it.
+		{"priority", [][]uint8{sprite(10, 1, 0x21, 20), sprite(10, 1, 0x00, 20)}, 2, 20, bg, false},
+		// A clear lower numbered sprite doesn't.
+		{"clear first", [][]uint8{sprite(10, 0, 0x20, 20), sprite(10, 1, 0x00, 20)}, 2, 20, front, false},
+		{"no flip", [][]uint8{sprite(10, 3, 0x00, 20)}, 0, 27, front, false},
+		{"flip", [][]uint8{sprite(10, 3, 0x40, 20)}, 0, 27, backdrop, false},
+		{"flipped", [][]uint8{sprite(10, 3, 0x40, 20)}, 0, 20, front, false},
+		// OAM Y of 0 is line 1; nothing is ever drawn on line 0.
+		{"first line", [][]uint8{sprite(1, 1, 0x00, 20)}, 0, 20, backdrop, false},
+		// The ninth sprite on a line isn't drawn.
+		{"overflow", nine, 0, 64, backdrop, true},
+	}
+
+	for _, tc := range cases {
+		cb := &chrBus{}
+		for row := 0; row < 8; row++ {
+			cb.chr[1<<4|row] = 0xFF // tile 1: colour 1
+			cb.chr[2<<4|row] = 0xFF // tile 2: colour 1
+			cb.chr[3<<4|row] = 0x01 // tile 3: colour 1 on the right
+		}
+		p := New(cb)
+		p.paletteTable[0x00] = backdrop
+		p.paletteTable[0x01] = bg
+		p.paletteTable[0x11] = front
+		p.paletteTable[0x15] = behind
+		for i := range p.oamData {
+			p.oamData[i] = 0xFF
+		}
+		for i, o := range tc.oam {
+			copy(p.oamData[i*4:], o)
+		}
+		for i := 0; i < 960; i++ {
+			p.vram[i] = tc.bgTile
+		}
+		p.mask = MASK_RENDER_BG | MASK_RENDER_FG | MASK_SHOW_LEFT_TILES | MASK_SHOW_LEFT_SPRITES
+
+		for p.frame == 0 || p.scanline < 20 {
+			p.Tick()
+		}
+		y := 10
+		if tc.name == "first line" {
+			y = 0
+		}
+		if got, want := p.pixels.RGBAAt(tc.x, y), SYSTEM_PALETTE[tc.want]; got != want {
+			t.Errorf("%s: pixel = %v, want %v", tc.name, got, want)
+		}
+		if got := p.status&STATUS_SPRITE_OVERFLOW != 0; got != tc.overflw {
+			t.Errorf("%s: overflow = %t, want %t", tc.name, got, tc.overflw)
+		}
+	}
+}
+
+func TestSpriteOverflow(t *testing.T) {
+	const line = 50
+
+	// Sprites 0-7 are always on the line; these follow them.
+	cases := []struct {
+		name string
+		rest [][4]uint8
+		want bool
+	}{
+		{"eight", nil, false},
+		{"nine", [][4]uint8{{line, 0, 0, 0}}, true},
+		// Sprite 8 is off the line, so sprite 9 is checked by
+		// its tile number, which isn't 50, and sprite 10 by its
+		// attributes.
+		{"missed", [][4]uint8{{0xFF, 0, 0, 0}, {line, 0, 0, 0}, {line, 0, 0, 0}}, false},
+		// Sprite 11's X, checked after three misses, looks like
+		// it's on the line.
+		{"false", [][4]uint8{{0xFF, 0, 0, 0}, {0xFF, 0, 0, 0}, {0xFF, 0, 0, 0}, {0xFF, 0, 0, line}}, true},
+		// After four misses, the check is back on Y.
+		{"realigned", [][4]uint8{{0xFF, 0, 0, 0}, {0xFF, 0, 0, 0}, {0xFF, 0, 0, 0}, {0xFF, 0, 0, 0}, {line, 0xFF, 0xFF, 0xFF}}, true},
+	}
+
+	for _, tc := range cases {
+		p := New(&testBus{})
+		for i := range p.oamData {
+			p.oamData[i] = 0xFF
+		}
+		for i := 0; i < 8; i++ {
+			copy(p.oamData[i*4:], []uint8{line, 0, 0, 0})
+		}
+		for i, o := range tc.rest {
+			copy(p.oamData[(8+i)*4:], o[:])
+		}
+		p.scanline = line
+
+		p.evaluateSprites()
+		if got := p.status&STATUS_SPRITE_OVERFLOW != 0; got != tc.want || p.nextSprites != 8 {
+			t.Errorf("%s: overflow = %t with %d sprites, want %t with 8", tc.name, got, p.nextSprites, tc.want)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	b := &chrBus{}
+	for i := range b.chr {
+		b.chr[i] = uint8(i * 7)
+	}
+	p := New(b)
+	for i := range p.oamData {
+		p.oamData[i] = uint8(i * 3)
+	}
+	for i := range p.vram {
+		p.vram[i] = uint8(i)
+	}
+	for i := range p.paletteTable {
+		p.paletteTable[i] = uint8(i)
+	}
+	p.WriteReg(PPUSCROLL, 0x15)
+	p.WriteReg(PPUSCROLL, 0x2A)
+	p.WriteReg(PPUMASK, MASK_RENDER_BG|MASK_RENDER_FG)
+	// Stop early in a line, so the shifters and sprite units are busy.
+	for i := 0; i < 341*20+10; i++ {
+		p.Tick()
+	}
+
+	var buf bytes.Buffer
+	w := state.NewWriter(&buf)
+	p.State(w)
+	if err := w.Err(); err != nil {
+		t.Fatalf("Saving: %v", err)
+	}
+
+	p2 := New(b)
+	r := state.NewReader(&buf)
+	p2.State(r)
+	if err := r.Err(); err != nil {
+		t.Fatalf("Loading: %v", err)
+	}
+	if p2.Registers() != p.Registers() {
+		t.Errorf("Loaded registers %+v, wanted %+v", p2.Registers(), p.Registers())
+	}
+	if p2.scanline != p.scanline || p2.scandot != p.scandot || p2.oddFrame != p.oddFrame {
+		t.Errorf("Loaded position %d,%d (odd %t), wanted %d,%d (odd %t)", p2.scanline, p2.scandot, p2.oddFrame, p.scanline, p.scandot, p.oddFrame)
+	}
+
+	// Both must draw the rest of the frame the same way. The
+	// picture isn't saved, so start from the same partial one.
+	copy(p2.pixels.Pix, p.pixels.Pix)
+	for p.scanline != 241 {
+		p.Tick()
+		p2.Tick()
+	}
+	got, want := p2.GetPixels(), p.GetPixels()
+	if !bytes.Equal(got.Pix, want.Pix) {
+		t.Errorf("The loaded PPU drew a different frame")
+	}
+	blank := true
+	for _, v := range want.Pix {
+		blank = blank && v == want.Pix[0]
+	}
+	if blank {
+		t.Errorf("Nothing was drawn to compare")
+	}
+}
@@ -0,0 +1,99 @@
+package ppu
+
+import "github.com/bdwalton/gintendo/state"
+
+// State saves or loads the PPU's memory, registers and rendering
+// progress. Region timing, the RC2C05 mode and the RGB palette aren't
+// included, as they come from the cartridge and are set up when it's
+// inserted.
+// The picture isn't either; the next frame redraws it.
+func (p *PPU) State(s *state.Codec) {
+	s.Tag("PPU ")
+	s.Bytes(p.paletteTable[:])
+	s.Bytes(p.oamData[:])
+	s.Bytes(p.vram[:])
+
+	v, t := uint16(p.v), uint16(p.t)
+	s.Uint16(&v)
+	s.Uint16(&t)
+	p.v, p.t = loopy(v), loopy(t)
+	s.Uint8(&p.x)
+	s.Uint8(&p.wLatch)
+
+	s.Uint8(&p.ctrl)
+	s.Uint8(&p.status)
+	s.Uint8(&p.mask)
+	s.Uint8(&p.oamaddr)
+
+	s.Uint16(&p.scanline)
+	s.Uint16(&p.scandot)
+	s.Uint64(&p.frame)
+	s.Bool(&p.oddFrame)
+	s.Uint8(&p.bufferData)
+
+	s.Uint16(&p.bgSPLo)
+	s.Uint16(&p.bgSPHi)
+	s.Uint16(&p.bgSALo)
+	s.Uint16(&p.bgSAHi)
+	s.Uint8(&p.bgNextTile)
+	s.Uint8(&p.bgNextAttrib)
+	s.Uint8(&p.bgNextTileLSB)
+	s.Uint8(&p.bgNextTileMSB)
+
+	s.Bytes(p.secondaryOAM[:])
+	s.Int(&p.nextSprites)
+	s.Bool(&p.nextZero)
+	s.Int(&p.activeSprites)
+	s.Bool(&p.canZeroHit)
+	s.Bytes(p.fgSPLo[:])
+	s.Bytes(p.fgSPHi[:])
+	for i := range p.sprites {
+		p.sprites[i].state(s)
+	}
+}
+
+func (o *oam) state(s *state.Codec) {
+	r := uint8(o.renderP)
+	s.Uint8(&o.y)
+	s.Uint8(&o.tileId)
+	s.Uint8(&o.palette)
+	s.Uint8(&r)
+	s.Bool(&o.flipV)
+	s.Bool(&o.flipH)
+	s.Uint8(&o.x)
+	o.renderP = priority(r)
+}
+
+// Registers are the PPU's registers as the CPU sees them, along with
+// the internal ones behind PPUSCROLL and PPUADDR.
+type Registers struct {
+	Ctrl, Mask, Status, OAMAddr uint8
+	V, T                        uint16 // the current and temporary VRAM addresses
+	X                           uint8  // fine X scroll
+	W                           uint8  // the first or second write toggle
+	ReadBuffer                  uint8  // the PPUDATA read buffer
+}
+
+// Registers returns the PPU's registers.
+func (p *PPU) Registers() Registers {
+	return Registers{
+		Ctrl:       p.ctrl,
+		Mask:       p.mask,
+		Status:     p.status,
+		OAMAddr:    p.oamaddr,
+		V:          uint16(p.v),
+		T:          uint16(p.t),
+		X:          p.x,
+		W:          p.wLatch,
+		ReadBuffer: p.bufferData,
+	}
+}
+
+// SetRegisters sets the PPU's registers, such as when restoring
+// another emulator's save state.
+func (p *PPU) SetRegisters(r Registers) {
+	p.ctrl, p.mask, p.status, p.oamaddr = r.Ctrl, r.Mask, r.Status, r.OAMAddr
+	p.v.set(r.V & 0x7FFF)
+	p.t.set(r.T & 0x7FFF)
+	p.x, p.wLatch, p.bufferData = r.X&0x07, r.W&0x01, r.ReadBuffer
+}
@@ -0,0 +1,224 @@
+package ppu
+
+import (
+	"image"
+	"image/color"
+)
+
+// Sizes of the viewers' pictures, in pixels.
+const (
+	PATTERN_TABLE_SIZE = 128 // Pattern tables are 16x16 tiles, square
+	NAMETABLES_WIDTH   = 2 * NES_RES_WIDTH
+	NAMETABLES_HEIGHT  = 2 * NES_RES_HEIGHT
+	PALETTES_WIDTH     = 128 // 16 entries across, 8 pixels each
+	PALETTES_HEIGHT    = 16  // Background palettes above sprite palettes
+	SPRITES_WIDTH      = 64  // 8 sprites across, 8 rows of them
+)
+
+// The viewers draw into dst, or a new image if it's nil or the wrong
+// size, and return it. They read memory as rendering would, so they
+// show what the game would see at the moment they're called.
+
+// PatternTable draws pattern table table (0 or 1) in the colours of
+// palette pal (0-7, with 4-7 being the sprite palettes).
+func (p *PPU) PatternTable(dst *image.RGBA, table int, pal uint8) *image.RGBA {
+	dst = viewerImage(dst, PATTERN_TABLE_SIZE, PATTERN_TABLE_SIZE)
+	base := uint16(table&0x01) << 12
+	for tile := uint16(0); tile < 256; tile++ {
+		x, y := int(tile%16)*8, int(tile/16)*8
+		p.drawTile(dst, x, y, base+tile<<4, pal)
+	}
+	return dst
+}
+
+// PatternTables draws both pattern tables in palette pal, reusing the
+// images in dst as PatternTable does.
+func (p *PPU) PatternTables(dst [2]*image.RGBA, pal uint8) [2]*image.RGBA {
+	for t := range dst {
+		dst[t] = p.PatternTable(dst[t], t, pal)
+	}
+	return dst
+}
+
+// Nametables draws the four nametables as the PPU addresses them,
+// mirrors and all, with their attributes and the current background
+// pattern table.
+func (p *PPU) Nametables(dst *image.RGBA) *image.RGBA {
+	dst = viewerImage(dst, NAMETABLES_WIDTH, NAMETABLES_HEIGHT)
+	base := p.backgroundTableID() << 12
+	for nt := uint16(0); nt < 4; nt++ {
+		ntAddr := BASE_NAMETABLE + nt*0x400
+		for row := uint16(0); row < 30; row++ {
+			for col := uint16(0); col < 32; col++ {
+				tile := uint16(p.read(ntAddr + row*32 + col))
+				attr := p.read(ntAddr + ATTRIBUTE_OFFSET + (row>>2)<<3 + col>>2)
+				pal := attr >> ((row & 0x02 << 1) | (col & 0x02)) & 0x03
+
+				x := int(nt%2)*NES_RES_WIDTH + int(col)*8
+				y := int(nt/2)*NES_RES_HEIGHT + int(row)*8
+				p.drawTile(dst, x, y, base+tile<<4, pal)
+			}
+		}
+	}
+	return dst
+}
+
+// Overlays NametableOverlays can draw.
+const (
+	OVERLAY_ATTRIBUTES = 1 << iota // a grid of the 16x16 areas that share a palette
+	OVERLAY_SCROLL                 // the screen's outline at the scroll position
+)
+
+// Colours of the overlays.
+var (
+	attributeGridColor = color.RGBA{0x80, 0x80, 0x80, 0xFF}
+	scrollColor        = color.RGBA{0xFF, 0x20, 0x20, 0xFF}
+)
+
+// NametableOverlays draws the OVERLAY_XXX overlays in overlays on dst,
+// a picture from Nametables. The attribute grid is dotted, so the
+// tiles still show through. The scroll position is the one set for
+// the frame, through PPUSCROLL and PPUCTRL, so mid-frame splits
+// aren't shown; the outline wraps around the edges, as the scrolling
+// does.
+func (p *PPU) NametableOverlays(dst *image.RGBA, overlays uint8) *image.RGBA {
+	dst = viewerImage(dst, NAMETABLES_WIDTH, NAMETABLES_HEIGHT)
+	if overlays&OVERLAY_ATTRIBUTES != 0 {
+		for y := 0; y < NAMETABLES_HEIGHT; y += 2 {
+			for x := 0; x < NAMETABLES_WIDTH; x += 16 {
+				dst.SetRGBA(x, y, attributeGridColor)
+			}
+		}
+		for y := 0; y < NAMETABLES_HEIGHT; y += 16 {
+			for x := 0; x < NAMETABLES_WIDTH; x += 2 {
+				dst.SetRGBA(x, y, attributeGridColor)
+			}
+		}
+	}
+
+	if overlays&OVERLAY_SCROLL != 0 {
+		x0 := int(p.t.nametableX())*NES_RES_WIDTH + int(p.t.coarseX())*8 + int(p.x)
+		y0 := int(p.t.nametableY())*NES_RES_HEIGHT + int(p.t.coarseY())*8 + int(p.t.fineY())
+		plot := func(x, y int) {
+			dst.SetRGBA((x0+x)%NAMETABLES_WIDTH, (y0+y)%NAMETABLES_HEIGHT, scrollColor)
+		}
+		for x := 0; x < NES_RES_WIDTH; x++ {
+			plot(x, 0)
+			plot(x, NES_RES_HEIGHT-1)
+		}
+		for y := 0; y < NES_RES_HEIGHT; y++ {
+			plot(0, y)
+			plot(NES_RES_WIDTH-1, y)
+		}
+	}
+	return dst
+}
+
+// Sprite is an OAM entry, decoded for debuggers.
+type Sprite struct {
+	X, Y         uint8 // Y is the line above the sprite's top, as stored
+	Tile         uint8
+	Palette      uint8 // 4-7, as the viewers number them
+	Behind       bool  // drawn behind the background
+	FlipH, FlipV bool
+}
+
+// Sprites decodes the 64 entries in OAM.
+func (p *PPU) Sprites() [64]Sprite {
+	var sp [64]Sprite
+	for i := range sp {
+		o := OAMFromBytes(p.oamData[i*4 : i*4+4])
+		sp[i] = Sprite{
+			X:       o.x,
+			Y:       o.y,
+			Tile:    o.tileId,
+			Palette: o.palette + 4,
+			Behind:  o.renderP == BACK,
+			FlipH:   o.flipH,
+			FlipV:   o.flipV,
+		}
+	}
+	return sp
+}
+
+// SpritePreview draws the 64 sprites in OAM in order, 8 to a row, as
+// they'd look on screen, flips and all, with the backdrop colour
+// behind them. The picture is SPRITES_WIDTH wide, and as tall as
+// that for 8x8 sprites or twice as tall for 8x16.
+func (p *PPU) SpritePreview(dst *image.RGBA) *image.RGBA {
+	ss := p.spriteSize()
+	dst = viewerImage(dst, SPRITES_WIDTH, 8*ss)
+	for i, s := range p.Sprites() {
+		x, y := (i%8)*8, (i/8)*ss
+		for row := 0; row < ss; row++ {
+			sr := row
+			if s.FlipV {
+				sr = ss - 1 - row
+			}
+			addr := p.spriteTableID()<<12 | uint16(s.Tile)<<4
+			if ss == 16 {
+				addr = uint16(s.Tile&0x01)<<12 | uint16(s.Tile&0xFE)<<4
+				if sr >= 8 {
+					addr += 16 // the next tile
+				}
+			}
+			addr += uint16(sr & 0x07)
+
+			lo, hi := p.read(addr), p.read(addr+8)
+			for col := 0; col < 8; col++ {
+				bit := 7 - col
+				if s.FlipH {
+					bit = col
+				}
+				pix := (hi>>bit&0x01)<<1 | lo>>bit&0x01
+				a := uint16(PALETTE_RAM)
+				if pix != 0 {
+					a += uint16(s.Palette)<<2 + uint16(pix)
+				}
+				dst.SetRGBA(x+col, y+row, p.systemColor(p.read(a)))
+			}
+		}
+	}
+	return dst
+}
+
+// Palettes draws palette RAM as 8x8 swatches, the background palettes
+// on the top row and the sprite palettes below.
+func (p *PPU) Palettes(dst *image.RGBA) *image.RGBA {
+	dst = viewerImage(dst, PALETTES_WIDTH, PALETTES_HEIGHT)
+	for i := 0; i < 32; i++ {
+		c := p.systemColor(p.read(PALETTE_RAM + uint16(i)))
+		x, y := (i%16)*8, (i/16)*8
+		for py := y; py < y+8; py++ {
+			for px := x; px < x+8; px++ {
+				dst.SetRGBA(px, py, c)
+			}
+		}
+	}
+	return dst
+}
+
+// drawTile draws the tile at addr in the pattern tables with its top
+// left corner at x, y in palette pal.
+func (p *PPU) drawTile(dst *image.RGBA, x, y int, addr uint16, pal uint8) {
+	for row := uint16(0); row < 8; row++ {
+		lo, hi := p.read(addr+row), p.read(addr+row+8)
+		for col := 0; col < 8; col++ {
+			pix := (hi>>7)<<1 | lo>>7
+			lo, hi = lo<<1, hi<<1
+			a := uint16(PALETTE_RAM)
+			if pix != 0 { // 0 is transparent, showing the backdrop
+				a += uint16(pal)<<2 + uint16(pix)
+			}
+			c := p.systemColor(p.read(a))
+			dst.SetRGBA(x+col, y+int(row), c)
+		}
+	}
+}
+
+func viewerImage(dst *image.RGBA, w, h int) *image.RGBA {
+	if dst == nil || dst.Rect.Dx() != w || dst.Rect.Dy() != h {
+		return image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+	return dst
+}
@@ -0,0 +1,384 @@
+// Package remote serves an HTTP API for controlling a running
+// console, for test farms, "Twitch plays" setups and tools written in
+// other languages. Requests and responses are plain bytes or JSON:
+//
+//	POST /load?name=game.nes   body is the ROM file; the reply is the
+//	                           removed cartridge's battery RAM, if any
+//	POST /pause, /resume, /reset
+//	POST /input                {"player": 0, "buttons": ["a", "right"]}
+//	GET  /screenshot           the current picture as a PNG
+//	GET  /state                a save state (see core.Console.SaveState)
+//	POST /state                body is a save state to load
+//	GET  /memory?addr=0300&len=16
+//	POST /memory?addr=0300&data=a9ff
+//	GET  /hash/frame           {"crc32": "1a2b3c4d"}, see core.Console.FrameHash
+//	GET  /hash/ram?ranges=0000-07ff,6000-7fff
+//	GET  /layers               {"hidden": ["sprites"]}
+//	POST /layers               {"hidden": ["background"]}, or [] to show all
+//
+// Addresses and memory are in hex. Errors are reported with an HTTP
+// status and a one line message. Uploads larger than MAX_UPLOAD are
+// refused with 413 Request Entity Too Large.
+//
+// Only HTTP is served; there's no gRPC API.
+package remote
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/mappers"
+	"github.com/bdwalton/gintendo/ppu"
+)
+
+// MAX_UPLOAD is the largest ROM or save state the server accepts.
+const MAX_UPLOAD = 32 << 20
+
+// MAX_PEEK is the most memory a single request can read.
+const MAX_PEEK = 0x10000
+
+// Server is an http.Handler controlling a console.
+type Server struct {
+	c   *core.Console
+	mux *http.ServeMux
+
+	mu       sync.Mutex // guards saveFile
+	saveFile string     // where the cartridge's battery RAM is kept
+}
+
+// New returns a Server for c. It doesn't listen anywhere itself; pass
+// it to http.ListenAndServe or mount it in another server.
+func New(c *core.Console) *Server {
+	s := &Server{c: c, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/load", post(s.load))
+	s.mux.HandleFunc("/pause", post(s.pause))
+	s.mux.HandleFunc("/resume", post(s.resume))
+	s.mux.HandleFunc("/reset", post(s.reset))
+	s.mux.HandleFunc("/input", post(s.input))
+	s.mux.HandleFunc("/screenshot", s.screenshot)
+	s.mux.HandleFunc("/state", s.state)
+	s.mux.HandleFunc("/memory", s.memory)
+	s.mux.HandleFunc("/hash/frame", s.frameHash)
+	s.mux.HandleFunc("/hash/ram", s.ramHash)
+	s.mux.HandleFunc("/layers", s.layers)
+
+	return s
+}
+
+// SetSaveFile tells the server where the battery backed RAM of the
+// cartridge in the console is kept, so that /load can write it back
+// before swapping in another one.
+func (s *Server) SetSaveFile(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.saveFile = path
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// post restricts h to POST requests, as it changes the console.
+func post(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// readUpload reads the body of r, replying with an error and
+// returning false if it can't or if it's larger than MAX_UPLOAD.
+func readUpload(w http.ResponseWriter, r *http.Request, what string) ([]byte, bool) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_UPLOAD))
+	var tooBig *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooBig):
+		http.Error(w, fmt.Sprintf("%s is larger than %d bytes", what, MAX_UPLOAD), http.StatusRequestEntityTooLarge)
+		return nil, false
+	case err != nil:
+		http.Error(w, fmt.Sprintf("couldn't read %s: %v", what, err), http.StatusBadRequest)
+		return nil, false
+	}
+	return data, true
+}
+
+// load swaps in the ROM in the request body. The removed cartridge's
+// battery backed RAM is written to the save file, if there is one, and
+// sent back in the reply. The uploaded ROM has no save file of its
+// own.
+func (s *Server) load(w http.ResponseWriter, r *http.Request) {
+	data, ok := readUpload(w, r, "ROM")
+	if !ok {
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "remote.nes"
+	}
+
+	m, err := mappers.LoadBytes(data, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	old := s.c.LoadGame(m)
+	defer func() {
+		if c, ok := old.(io.Closer); ok {
+			c.Close()
+		}
+	}()
+
+	s.mu.Lock()
+	sav := s.saveFile
+	s.saveFile = ""
+	s.mu.Unlock()
+
+	if sav != "" {
+		if err := core.WriteSaveFile(old, sav); err != nil {
+			log.Printf("Couldn't write save RAM: %v", err)
+		}
+	}
+	if old.HasSaveRAM() {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := old.SavePrgRAM(w); err != nil {
+			log.Printf("Couldn't send save RAM: %v", err)
+		}
+	}
+}
+
+func (s *Server) pause(w http.ResponseWriter, r *http.Request) {
+	s.c.Pause()
+}
+
+func (s *Server) resume(w http.ResponseWriter, r *http.Request) {
+	s.c.Resume()
+}
+
+func (s *Server) reset(w http.ResponseWriter, r *http.Request) {
+	s.c.Reset()
+}
+
+// inputRequest is the body of /input.
+type inputRequest struct {
+	Player  int      `json:"player"`
+	Buttons []string `json:"buttons"`
+}
+
+// input holds the listed buttons until the next /input for the same
+// player, so an empty list releases them all.
+func (s *Server) input(w http.ResponseWriter, r *http.Request) {
+	var req inputRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad input request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Player < 0 || req.Player >= core.PLAYERS {
+		http.Error(w, fmt.Sprintf("no player %d; use 0 to %d", req.Player, core.PLAYERS-1), http.StatusBadRequest)
+		return
+	}
+
+	var buttons uint8
+	for _, n := range req.Buttons {
+		b, ok := core.ButtonNames[strings.ToLower(n)]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown button %q", n), http.StatusBadRequest)
+			return
+		}
+		buttons |= b
+	}
+
+	s.c.InjectButtons(req.Player, buttons)
+}
+
+func (s *Server) screenshot(w http.ResponseWriter, r *http.Request) {
+	wd, ht := s.c.Resolution()
+	img := &image.RGBA{
+		Pix:    s.c.VideoFrame(nil),
+		Stride: wd * 4,
+		Rect:   image.Rect(0, 0, wd, ht),
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf.Bytes())
+}
+
+// state saves a state with GET and loads one with POST.
+func (s *Server) state(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var buf bytes.Buffer
+		if err := s.c.SaveState(&buf); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(buf.Bytes())
+	case http.MethodPost:
+		data, ok := readUpload(w, r, "state")
+		if !ok {
+			return
+		}
+		if err := s.c.LoadState(bytes.NewReader(data)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	default:
+		http.Error(w, "use GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+// memoryResponse is the result of reading /memory.
+type memoryResponse struct {
+	Addr string `json:"addr"`
+	Data string `json:"data"`
+}
+
+// memory peeks with GET and pokes with POST, in the CPU's address
+// space.
+func (s *Server) memory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	addr, err := parseHex(q.Get("addr"), 16)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad addr: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		n := 1
+		if l := q.Get("len"); l != "" {
+			v, err := parseHex(l, 32)
+			if err != nil || v == 0 || v > MAX_PEEK {
+				http.Error(w, fmt.Sprintf("bad len %q", l), http.StatusBadRequest)
+				return
+			}
+			n = int(v)
+		}
+
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = s.c.Peek(uint16(int(addr) + i))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(memoryResponse{Addr: fmt.Sprintf("%04x", addr), Data: hex.EncodeToString(data)})
+	case http.MethodPost:
+		data, err := hex.DecodeString(q.Get("data"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad data: %v", err), http.StatusBadRequest)
+			return
+		}
+		for i, v := range data {
+			s.c.Poke(uint16(int(addr)+i), v)
+		}
+	default:
+		http.Error(w, "use GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+type hashResponse struct {
+	CRC32 string `json:"crc32"`
+}
+
+func writeHash(w http.ResponseWriter, h uint32) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hashResponse{CRC32: fmt.Sprintf("%08x", h)})
+}
+
+func (s *Server) frameHash(w http.ResponseWriter, r *http.Request) {
+	writeHash(w, s.c.FrameHash())
+}
+
+// ramHash hashes the comma separated start-end ranges in ranges, or
+// system RAM if there are none.
+func (s *Server) ramHash(w http.ResponseWriter, r *http.Request) {
+	var ranges []core.Range
+	if rs := r.URL.Query().Get("ranges"); rs != "" {
+		for _, rng := range strings.Split(rs, ",") {
+			start, end, _ := strings.Cut(rng, "-")
+			a, err := parseHex(start, 16)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("bad range %q: %v", rng, err), http.StatusBadRequest)
+				return
+			}
+			b, err := parseHex(end, 16)
+			if err != nil || b < a {
+				http.Error(w, fmt.Sprintf("bad range %q", rng), http.StatusBadRequest)
+				return
+			}
+			ranges = append(ranges, core.Range{Start: uint16(a), End: uint16(b)})
+		}
+	}
+
+	writeHash(w, s.c.RAMHash(ranges...))
+}
+
+// layerNames are the names of the picture's layers in /layers.
+var layerNames = map[string]uint8{
+	"background": ppu.LAYER_BACKGROUND,
+	"sprites":    ppu.LAYER_SPRITES,
+}
+
+// layersRequest is the body of /layers, both ways.
+type layersRequest struct {
+	Hidden []string `json:"hidden"`
+}
+
+// layers reports the hidden layers with GET and sets them with POST.
+func (s *Server) layers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		hidden := s.c.HiddenLayers()
+		req := layersRequest{Hidden: []string{}}
+		for _, n := range []string{"background", "sprites"} {
+			if hidden&layerNames[n] != 0 {
+				req.Hidden = append(req.Hidden, n)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(req)
+	case http.MethodPost:
+		var req layersRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("bad layers request: %v", err), http.StatusBadRequest)
+			return
+		}
+		var mask uint8
+		for _, n := range req.Hidden {
+			l, ok := layerNames[strings.ToLower(n)]
+			if !ok {
+				http.Error(w, fmt.Sprintf("unknown layer %q; use background or sprites", n), http.StatusBadRequest)
+				return
+			}
+			mask |= l
+		}
+		s.c.HideLayers(mask)
+	default:
+		http.Error(w, "use GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+// parseHex parses a hex number, with or without a leading 0x or $.
+func parseHex(s string, bits int) (uint64, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "0x"), "$")
+	return strconv.ParseUint(s, 16, bits)
+}
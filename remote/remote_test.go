@@ -0,0 +1,225 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/mappers"
+	"github.com/bdwalton/gintendo/nesrom"
+	"github.com/bdwalton/gintendo/ppu"
+)
+
+func testServer(t *testing.T) (*core.Console, *httptest.Server) {
+	t.Helper()
+
+	m, err := mappers.Load("../testdata/ram_after_reset.nes")
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+	c := core.New(m)
+	ts := httptest.NewServer(New(c))
+	t.Cleanup(ts.Close)
+
+	return c, ts
+}
+
+func do(t *testing.T, method, url, body string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func TestMemory(t *testing.T) {
+	c, ts := testServer(t)
+
+	if resp := do(t, "POST", ts.URL+"/memory?addr=0x0300&data=a9ff", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Poking memory: %s", resp.Status)
+	}
+	if got := c.Peek(0x0301); got != 0xFF {
+		t.Errorf("$0301 = 0x%02x, wanted 0xff", got)
+	}
+
+	resp := do(t, "GET", ts.URL+"/memory?addr=0300&len=2", "")
+	var mr memoryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&mr); err != nil {
+		t.Fatalf("Decoding memory response: %v", err)
+	}
+	if mr.Addr != "0300" || mr.Data != "a9ff" {
+		t.Errorf("Got %+v, wanted addr 0300 and data a9ff", mr)
+	}
+
+	if resp := do(t, "GET", ts.URL+"/memory?addr=zz", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Bad address gave %s, wanted 400", resp.Status)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	// An NROM cartridge with battery backed RAM.
+	rom := append(nesrom.HeaderBytes(1, 1, nesrom.CartInfo{Battery: true}), make([]byte, 0x6000)...)
+	m, err := mappers.LoadBytes(rom, "battery.nes")
+	if err != nil {
+		t.Fatalf("LoadBytes() = %v", err)
+	}
+	c := core.New(m)
+	srv := New(c)
+	sav := filepath.Join(t.TempDir(), "battery.sav")
+	srv.SetSaveFile(sav)
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	c.Poke(0x6000, 0x42)
+	resp := do(t, "POST", ts.URL+"/load?name=next.nes", string(rom))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /load gave %s", resp.Status)
+	}
+	if got, err := io.ReadAll(resp.Body); err != nil || !bytes.HasPrefix(got, []byte{0x42}) {
+		t.Errorf("/load replied with %d bytes (%v), wanted save RAM starting with 0x42", len(got), err)
+	}
+	if got, err := os.ReadFile(sav); err != nil || !bytes.HasPrefix(got, []byte{0x42}) {
+		t.Errorf("Save file has %d bytes (%v), wanted save RAM starting with 0x42", len(got), err)
+	}
+	if c.Mapper() == m {
+		t.Errorf("The cartridge wasn't swapped")
+	}
+
+	// Oversized uploads are refused rather than cut short.
+	big := strings.Repeat("x", MAX_UPLOAD+1)
+	for _, path := range []string{"/load", "/state"} {
+		if resp := do(t, "POST", ts.URL+path, big); resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("Oversized POST %s gave %s, wanted 413", path, resp.Status)
+		}
+	}
+}
+
+func TestControl(t *testing.T) {
+	c, ts := testServer(t)
+
+	do(t, "POST", ts.URL+"/pause", "")
+	if !c.Paused() {
+		t.Errorf("Console isn't paused after /pause")
+	}
+	do(t, "POST", ts.URL+"/resume", "")
+	if c.Paused() {
+		t.Errorf("Console is paused after /resume")
+	}
+	if resp := do(t, "GET", ts.URL+"/pause", ""); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /pause gave %s, wanted 405", resp.Status)
+	}
+
+	do(t, "POST", ts.URL+"/input", `{"player": 1, "buttons": ["A", "right"]}`)
+	c.Poke(core.CONT1, 1)
+	c.Poke(core.CONT1, 0)
+	var got uint8
+	for i := 0; i < 8; i++ {
+		got |= c.Peek(core.CONT2) & 1 << i
+	}
+	if want := uint8(core.BUTTON_A | core.BUTTON_RIGHT); got != want {
+		t.Errorf("Player 2 holds %08b, wanted %08b", got, want)
+	}
+	if resp := do(t, "POST", ts.URL+"/input", `{"buttons": ["turbo"]}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Unknown button gave %s, wanted 400", resp.Status)
+	}
+}
+
+func TestStateAndScreenshot(t *testing.T) {
+	c, ts := testServer(t)
+	c.RunFrame(core.Inputs{})
+
+	resp := do(t, "GET", ts.URL+"/state", "")
+	saved, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Getting state: %s, %v", resp.Status, err)
+	}
+
+	before := c.Peek(0x0010)
+	c.Poke(0x0010, ^before)
+	if resp := do(t, "POST", ts.URL+"/state", string(saved)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Loading state: %s", resp.Status)
+	}
+	if got := c.Peek(0x0010); got != before {
+		t.Errorf("$0010 = 0x%02x after loading the state, wanted 0x%02x", got, before)
+	}
+
+	img, err := png.Decode(do(t, "GET", ts.URL+"/screenshot", "").Body)
+	if err != nil {
+		t.Fatalf("Decoding screenshot: %v", err)
+	}
+	if w, h := c.Resolution(); img.Bounds().Dx() != w || img.Bounds().Dy() != h {
+		t.Errorf("Screenshot is %v, wanted %dx%d", img.Bounds(), w, h)
+	}
+}
+
+func TestHash(t *testing.T) {
+	c, ts := testServer(t)
+	c.RunFrame(core.Inputs{})
+
+	cases := []struct {
+		path string
+		want uint32
+	}{
+		{"/hash/frame", c.FrameHash()},
+		{"/hash/ram", c.RAMHash()},
+		{"/hash/ram?ranges=0000-07ff", c.RAMHash()},
+		{"/hash/ram?ranges=0010-001f,6000-60ff", c.RAMHash(core.Range{Start: 0x10, End: 0x1f}, core.Range{Start: 0x6000, End: 0x60ff})},
+	}
+	for _, tc := range cases {
+		var hr hashResponse
+		if err := json.NewDecoder(do(t, "GET", ts.URL+tc.path, "").Body).Decode(&hr); err != nil {
+			t.Fatalf("%s: decoding response: %v", tc.path, err)
+		}
+		if want := fmt.Sprintf("%08x", tc.want); hr.CRC32 != want {
+			t.Errorf("%s = %s, want %s", tc.path, hr.CRC32, want)
+		}
+	}
+
+	for _, bad := range []string{"ranges=0010", "ranges=0020-0010", "ranges=zz-0010"} {
+		if resp := do(t, "GET", ts.URL+"/hash/ram?"+bad, ""); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("/hash/ram?%s: %s, want %d", bad, resp.Status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestLayers(t *testing.T) {
+	c, ts := testServer(t)
+
+	do(t, "POST", ts.URL+"/layers", `{"hidden": ["Sprites"]}`)
+	if got := c.HiddenLayers(); got != ppu.LAYER_SPRITES {
+		t.Errorf("Hidden layers are %02b, wanted sprites", got)
+	}
+
+	var lr layersRequest
+	if err := json.NewDecoder(do(t, "GET", ts.URL+"/layers", "").Body).Decode(&lr); err != nil {
+		t.Fatalf("Decoding layers response: %v", err)
+	}
+	if len(lr.Hidden) != 1 || lr.Hidden[0] != "sprites" {
+		t.Errorf("Got %+v, wanted sprites hidden", lr)
+	}
+
+	do(t, "POST", ts.URL+"/layers", `{"hidden": []}`)
+	if got := c.HiddenLayers(); got != 0 {
+		t.Errorf("Hidden layers are %02b, wanted none", got)
+	}
+	if resp := do(t, "POST", ts.URL+"/layers", `{"hidden": ["window"]}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Unknown layer gave %s, wanted 400", resp.Status)
+	}
+}
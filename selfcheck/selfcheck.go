@@ -0,0 +1,137 @@
+// Package selfcheck runs a game on two consoles side by side with the
+// same inputs, comparing them as they go. Save states, rewind and
+// netplay all depend on emulation being deterministic, and consoles
+// that drift apart point at state that isn't saved or that depends on
+// something outside the console, like the wall clock or map order.
+package selfcheck
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/bdwalton/gintendo/core"
+)
+
+// Options control a check.
+type Options struct {
+	Frames    int          // How many frames to run
+	Every     int          // How often to compare the consoles, in frames; every frame if 0
+	Script    *core.Script // The inputs for both consoles, or nil for none
+	RoundTrip bool         // Move the second console's state to a new console after each comparison
+}
+
+// Divergence is where the consoles were first found to differ.
+type Divergence struct {
+	Frame int    // The frame after which they differed, counting from 0
+	What  string // "picture" or "RAM"
+	A, B  uint32 // The hashes from each console
+}
+
+func (d *Divergence) Error() string {
+	return fmt.Sprintf("consoles diverged after frame %d: %s hashes %08x and %08x", d.Frame, d.What, d.A, d.B)
+}
+
+// checkpoint is a console's hashes after a frame.
+type checkpoint struct {
+	frame      int
+	video, ram uint32
+	err        error
+}
+
+// Run runs two consoles made by newConsole in parallel and returns the
+// first Divergence between them, or nil if they stayed the same.
+func Run(newConsole func() (*core.Console, error), opts Options) (*Divergence, error) {
+	if opts.Every < 1 {
+		opts.Every = 1
+	}
+
+	var chans [2]chan checkpoint
+	stop := make(chan struct{})
+	defer close(stop)
+	for i := range chans {
+		c, err := newConsole()
+		if err != nil {
+			return nil, err
+		}
+		chans[i] = make(chan checkpoint, 16)
+		var fresh func() (*core.Console, error)
+		if i == 1 && opts.RoundTrip {
+			fresh = newConsole
+		}
+		go run(c, opts, fresh, chans[i], stop)
+	}
+
+	for {
+		a, ok := <-chans[0]
+		b := <-chans[1]
+		if !ok {
+			return nil, nil
+		}
+		for _, cp := range []checkpoint{a, b} {
+			if cp.err != nil {
+				return nil, fmt.Errorf("frame %d: %w", cp.frame, cp.err)
+			}
+		}
+
+		switch {
+		case a.video != b.video:
+			return &Divergence{Frame: a.frame, What: "picture", A: a.video, B: b.video}, nil
+		case a.ram != b.ram:
+			return &Divergence{Frame: a.frame, What: "RAM", A: a.ram, B: b.ram}, nil
+		}
+	}
+}
+
+// run runs c, sending its hashes to out every opts.Every frames and
+// after the last, until it's done or stop is closed. If fresh isn't
+// nil, c's state is loaded into a new console from fresh after each
+// checkpoint, and that one carries on. Anything the save state misses
+// is left as the new console powered on with, rather than as c had it,
+// so the next checkpoint catches it.
+func run(c *core.Console, opts Options, fresh func() (*core.Console, error), out chan<- checkpoint, stop <-chan struct{}) {
+	defer close(out)
+
+	var st bytes.Buffer
+	for f := 0; f < opts.Frames; f++ {
+		var in core.Inputs
+		if opts.Script != nil {
+			in = opts.Script.Inputs(f)
+		}
+		c.RunFrame(in)
+		if (f+1)%opts.Every != 0 && f != opts.Frames-1 {
+			continue
+		}
+
+		cp := checkpoint{frame: f, video: c.FrameHash(), ram: c.RAMHash()}
+		if fresh != nil {
+			cp.err = roundTrip(&c, fresh, &st)
+		}
+
+		select {
+		case out <- cp:
+		case <-stop:
+			return
+		}
+		if cp.err != nil {
+			return
+		}
+	}
+}
+
+// roundTrip saves *c's state and loads it into a new console from
+// fresh, which replaces *c.
+func roundTrip(c **core.Console, fresh func() (*core.Console, error), st *bytes.Buffer) error {
+	st.Reset()
+	if err := (*c).SaveState(st); err != nil {
+		return err
+	}
+	n, err := fresh()
+	if err != nil {
+		return err
+	}
+	if err := n.LoadState(st); err != nil {
+		return err
+	}
+	*c = n
+	return nil
+}
@@ -0,0 +1,102 @@
+package selfcheck
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/mappers"
+	"github.com/bdwalton/gintendo/state"
+)
+
+func newConsole() (*core.Console, error) {
+	m, err := mappers.Load("../testdata/ram_after_reset.nes")
+	if err != nil {
+		return nil, err
+	}
+	return core.New(m), nil
+}
+
+func TestRun(t *testing.T) {
+	script, err := core.ReadScript(strings.NewReader("5 0 start\n9 0 -\n"))
+	if err != nil {
+		t.Fatalf("ReadScript() = %v", err)
+	}
+
+	for _, rt := range []bool{false, true} {
+		d, err := Run(newConsole, Options{Frames: 30, Every: 7, Script: script, RoundTrip: rt})
+		if err != nil || d != nil {
+			t.Errorf("Run(RoundTrip: %t) = %v, %v, want nil, nil", rt, d, err)
+		}
+	}
+}
+
+func TestDivergence(t *testing.T) {
+	// The second console starts with a different byte at $0221,
+	// which the test ROM doesn't clear.
+	n := 0
+	skewed := func() (*core.Console, error) {
+		c, err := newConsole()
+		if err == nil && n > 0 {
+			c.Poke(0x221, c.Peek(0x221)^0xFF)
+		}
+		n++
+		return c, err
+	}
+
+	d, err := Run(skewed, Options{Frames: 30, Every: 4})
+	if err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if d == nil || d.Frame != 3 || d.A == d.B {
+		t.Errorf("Run() = %+v, want a divergence after frame 3", d)
+	}
+}
+
+// unsaved wraps a mapper with a count of CPU cycles that its save
+// state leaves out. Once the count passes 10 frames, it inverts
+// everything read from CHR.
+type unsaved struct {
+	mappers.Mapper
+	cycles int
+}
+
+func (m *unsaved) ClockCPU() {
+	m.cycles++
+}
+
+func (m *unsaved) ChrRead(addr uint16) uint8 {
+	v := m.Mapper.ChrRead(addr)
+	if m.cycles >= 10*29781 {
+		return ^v
+	}
+	return v
+}
+
+func (m *unsaved) State(s *state.Codec) {
+	m.Mapper.(mappers.Stateful).State(s)
+}
+
+func TestRoundTrip(t *testing.T) {
+	newUnsaved := func() (*core.Console, error) {
+		m, err := mappers.Load("../testdata/ram_after_reset.nes")
+		if err != nil {
+			return nil, err
+		}
+		return core.New(&unsaved{Mapper: m}), nil
+	}
+
+	if d, err := Run(newUnsaved, Options{Frames: 30, Every: 7}); err != nil || d != nil {
+		t.Fatalf("Run() = %v, %v, want nil, nil", d, err)
+	}
+
+	// The second console is replaced after frame 6, so its count
+	// starts again and it inverts CHR 7 frames later than the first.
+	d, err := Run(newUnsaved, Options{Frames: 30, Every: 7, RoundTrip: true})
+	if err != nil {
+		t.Fatalf("Run(RoundTrip: true) = %v", err)
+	}
+	if d == nil || d.Frame != 13 || d.What != "picture" {
+		t.Errorf("Run(RoundTrip: true) = %+v, want the pictures to diverge after frame 13", d)
+	}
+}
@@ -0,0 +1,141 @@
+// Package state reads and writes save states. Each component
+// describes its state once, as a series of calls on a Codec, and the
+// same calls either write the fields out or read them back in
+// depending on how the Codec was made:
+//
+//	func (c *counter) State(s *state.Codec) {
+//		s.Tag("CNTR")
+//		s.Uint16(&c.count)
+//		s.Bool(&c.enabled)
+//	}
+//
+// Values are little endian. The first error sticks, and later calls
+// do nothing, so components don't need to check as they go.
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrMismatch is returned when a save state doesn't match what's
+// loading it, such as a state from a different game or version.
+var ErrMismatch = errors.New("save state doesn't match")
+
+type Codec struct {
+	r   io.Reader
+	w   io.Writer
+	buf [8]byte
+	err error
+}
+
+// NewWriter returns a Codec that writes state to w.
+func NewWriter(w io.Writer) *Codec {
+	return &Codec{w: w}
+}
+
+// NewReader returns a Codec that reads state from r into the fields
+// it's given.
+func NewReader(r io.Reader) *Codec {
+	return &Codec{r: r}
+}
+
+// Loading reports whether s is reading state, for components that
+// need to do some work after loading.
+func (s *Codec) Loading() bool {
+	return s.r != nil
+}
+
+// Err returns the first error s ran into.
+func (s *Codec) Err() error {
+	return s.err
+}
+
+// Fail records err, if there isn't an error already, so components
+// can report problems of their own.
+func (s *Codec) Fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// raw moves len(b) bytes between b and the stream.
+func (s *Codec) raw(b []byte) {
+	if s.err != nil {
+		return
+	}
+	if s.r != nil {
+		_, s.err = io.ReadFull(s.r, b)
+	} else {
+		_, s.err = s.w.Write(b)
+	}
+}
+
+func (s *Codec) Uint8(p *uint8) {
+	b := s.buf[:1]
+	b[0] = *p
+	s.raw(b)
+	*p = b[0]
+}
+
+func (s *Codec) Uint16(p *uint16) {
+	b := s.buf[:2]
+	binary.LittleEndian.PutUint16(b, *p)
+	s.raw(b)
+	*p = binary.LittleEndian.Uint16(b)
+}
+
+func (s *Codec) Uint32(p *uint32) {
+	b := s.buf[:4]
+	binary.LittleEndian.PutUint32(b, *p)
+	s.raw(b)
+	*p = binary.LittleEndian.Uint32(b)
+}
+
+func (s *Codec) Uint64(p *uint64) {
+	b := s.buf[:8]
+	binary.LittleEndian.PutUint64(b, *p)
+	s.raw(b)
+	*p = binary.LittleEndian.Uint64(b)
+}
+
+// Int stores an int as 64 bits, whatever its size on this machine.
+func (s *Codec) Int(p *int) {
+	v := uint64(*p)
+	s.Uint64(&v)
+	*p = int(v)
+}
+
+func (s *Codec) Bool(p *bool) {
+	var v uint8
+	if *p {
+		v = 1
+	}
+	s.Uint8(&v)
+	*p = v != 0
+}
+
+// Bytes stores the contents of b, which must be the same length when
+// loading as it was when saving; memory isn't resized by a load.
+func (s *Codec) Bytes(b []byte) {
+	n := uint32(len(b))
+	s.Uint32(&n)
+	if s.err == nil && int(n) != len(b) {
+		s.err = fmt.Errorf("%w: %d bytes where %d were expected", ErrMismatch, n, len(b))
+		return
+	}
+	s.raw(b)
+}
+
+// Tag stores a marker, such as a component name, that must be found
+// in the same place when loading. Tags catch states from other
+// games or versions before they cause confusion.
+func (s *Codec) Tag(tag string) {
+	b := []byte(tag)
+	s.raw(b)
+	if s.err == nil && s.r != nil && string(b) != tag {
+		s.err = fmt.Errorf("%w: found %q where %q was expected", ErrMismatch, b, tag)
+	}
+}
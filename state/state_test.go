@@ -0,0 +1,76 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type testState struct {
+	a   uint8
+	b   uint16
+	c   uint32
+	d   uint64
+	e   int
+	f   bool
+	mem [5]byte
+}
+
+func (t *testState) State(s *Codec) {
+	s.Tag("TEST")
+	s.Uint8(&t.a)
+	s.Uint16(&t.b)
+	s.Uint32(&t.c)
+	s.Uint64(&t.d)
+	s.Int(&t.e)
+	s.Bool(&t.f)
+	s.Bytes(t.mem[:])
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := testState{0x12, 0x3456, 0x789ABCDE, 0x1122334455667788, -42, true, [5]byte{1, 2, 3, 4, 5}}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	want.State(w)
+	if err := w.Err(); err != nil {
+		t.Fatalf("Saving: %v", err)
+	}
+
+	var got testState
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	got.State(r)
+	if err := r.Err(); err != nil {
+		t.Fatalf("Loading: %v", err)
+	}
+	if got != want {
+		t.Errorf("Got %+v, wanted %+v", got, want)
+	}
+
+	// Truncated states fail rather than half loading silently.
+	r = NewReader(bytes.NewReader(buf.Bytes()[:10]))
+	got.State(r)
+	if r.Err() == nil {
+		t.Errorf("Loading a truncated state succeeded")
+	}
+}
+
+func TestMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Tag("ABCD")
+	w.Bytes(make([]byte, 4))
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	r.Tag("WXYZ")
+	if !errors.Is(r.Err(), ErrMismatch) {
+		t.Errorf("Tag() = %v, wanted ErrMismatch", r.Err())
+	}
+
+	r = NewReader(bytes.NewReader(buf.Bytes()))
+	r.Tag("ABCD")
+	r.Bytes(make([]byte, 8))
+	if !errors.Is(r.Err(), ErrMismatch) {
+		t.Errorf("Bytes() = %v, wanted ErrMismatch", r.Err())
+	}
+}
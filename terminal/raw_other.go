@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package terminal
+
+import "errors"
+
+var errUnsupported = errors.New("terminal mode isn't supported on this system")
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errUnsupported
+}
+
+func size(fd int) (int, int, error) {
+	return 0, 0, errUnsupported
+}
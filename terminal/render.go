@@ -0,0 +1,144 @@
+package terminal
+
+import "strconv"
+
+// halfBlock appends the RGBA picture pix, w pixels wide and h high,
+// to dst as rows of "▀" characters, each showing two pixels: the top
+// one in the foreground colour and the bottom one in the background.
+// The picture is shrunk to fit in cols by rows characters. Colours
+// are only sent when they change, which saves a lot of output.
+// Nothing is drawn if there's no room at all.
+func halfBlock(dst, pix []byte, w, h, cols, rows int) []byte {
+	if cols < 1 || rows < 1 {
+		return dst
+	}
+
+	step := 1
+	for w/step > cols || h/step > rows*2 {
+		step++
+	}
+
+	dst = append(dst, "\x1b[H"...)
+	fg, bg := -1, -1
+	for y := 0; y+step < h; y += 2 * step {
+		for x := 0; x < w; x += step {
+			top := rgb(pix, (y*w+x)*4)
+			bottom := rgb(pix, ((y+step)*w+x)*4)
+			if top != fg {
+				dst = appendColour(dst, "\x1b[38;2;", top)
+				fg = top
+			}
+			if bottom != bg {
+				dst = appendColour(dst, "\x1b[48;2;", bottom)
+				bg = bottom
+			}
+			dst = append(dst, "▀"...)
+		}
+		dst = append(dst, "\x1b[0m\r\n"...)
+		fg, bg = -1, -1
+	}
+
+	return dst
+}
+
+// rgb packs the pixel at pix[i:i+3] into an int.
+func rgb(pix []byte, i int) int {
+	return int(pix[i])<<16 | int(pix[i+1])<<8 | int(pix[i+2])
+}
+
+func appendColour(dst []byte, prefix string, c int) []byte {
+	dst = append(dst, prefix...)
+	dst = strconv.AppendInt(dst, int64(c>>16), 10)
+	dst = append(dst, ';')
+	dst = strconv.AppendInt(dst, int64(c>>8&0xFF), 10)
+	dst = append(dst, ';')
+	dst = strconv.AppendInt(dst, int64(c&0xFF), 10)
+	return append(dst, 'm')
+}
+
+// sixel appends the RGBA picture pix, w pixels wide and h high, to
+// dst as a sixel image. Each colour in the picture gets a palette
+// register; NES pictures only have a few dozen, well within the 256
+// terminals provide.
+// https://vt100.net/docs/vt3xx-gp/chapter14.html
+func sixel(dst, pix []byte, w, h int) []byte {
+	dst = append(dst, "\x1b[H\x1bPq"...)
+
+	regs := map[int]int{}
+	idx := make([]int, w*h)
+	for i := range idx {
+		c := rgb(pix, i*4)
+		r, ok := regs[c]
+		if !ok {
+			r = len(regs)
+			regs[c] = r
+			// Sixel colours are percentages.
+			dst = append(dst, '#')
+			dst = strconv.AppendInt(dst, int64(r), 10)
+			dst = append(dst, ";2;"...)
+			dst = strconv.AppendInt(dst, int64((c>>16)*100/255), 10)
+			dst = append(dst, ';')
+			dst = strconv.AppendInt(dst, int64((c>>8&0xFF)*100/255), 10)
+			dst = append(dst, ';')
+			dst = strconv.AppendInt(dst, int64((c&0xFF)*100/255), 10)
+		}
+		idx[i] = r
+	}
+
+	// Each band of six rows is drawn once per colour in it, with
+	// each character setting the pixels of that colour in a column.
+	bits := make([]byte, w)
+	for y := 0; y < h; y += 6 {
+		used := map[int]bool{}
+		for i := y * w; i < (y+6)*w && i < len(idx); i++ {
+			used[idx[i]] = true
+		}
+
+		first := true
+		for r := 0; r < len(regs); r++ {
+			if !used[r] {
+				continue
+			}
+			for x := range bits {
+				bits[x] = 0
+				for dy := 0; dy < 6 && y+dy < h; dy++ {
+					if idx[(y+dy)*w+x] == r {
+						bits[x] |= 1 << dy
+					}
+				}
+			}
+
+			if !first {
+				dst = append(dst, '$') // back to the start of the band
+			}
+			first = false
+			dst = append(dst, '#')
+			dst = strconv.AppendInt(dst, int64(r), 10)
+			dst = appendSixels(dst, bits)
+		}
+		dst = append(dst, '-')
+	}
+
+	return append(dst, "\x1b\\"...)
+}
+
+// appendSixels appends a row of sixels, run length encoding repeats.
+func appendSixels(dst, bits []byte) []byte {
+	for i := 0; i < len(bits); {
+		n := 1
+		for i+n < len(bits) && bits[i+n] == bits[i] {
+			n++
+		}
+		if n > 3 {
+			dst = append(dst, '!')
+			dst = strconv.AppendInt(dst, int64(n), 10)
+			dst = append(dst, 63+bits[i])
+		} else {
+			for j := 0; j < n; j++ {
+				dst = append(dst, 63+bits[i])
+			}
+		}
+		i += n
+	}
+	return dst
+}
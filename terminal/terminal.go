@@ -0,0 +1,213 @@
+// Package terminal plays a console in a text terminal, drawing the
+// picture with ANSI half block characters or sixel graphics and
+// reading the keyboard, so gintendo can run over SSH or anywhere else
+// without a window system.
+package terminal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/frontend"
+	"github.com/bdwalton/gintendo/metrics"
+)
+
+// Options control how the console is run and drawn.
+type Options struct {
+	Sixel     bool             // Draw with sixel graphics rather than half blocks
+	FrameSkip int              // Frames to emulate between those drawn, to save bandwidth
+	Runner    frontend.Runner  // Runs the frames; the console itself when nil
+	Messages  <-chan string    // Shown in place of the help for a while as they arrive
+	Sync      *metrics.Sync    // Told about every frame drawn
+	Profile   *metrics.Profile // Told how long each frame took to draw
+}
+
+// Frontend plays a console in a terminal as a frontend.Frontend.
+type Frontend struct {
+	In, Out *os.File // Normally os.Stdin and os.Stdout
+	Options
+}
+
+func (f *Frontend) Run(ctx context.Context, c *core.Console) error {
+	return Run(ctx, c, f.In, f.Out, f.Options)
+}
+
+// holdFrames is how long a key press holds its button. Terminals only
+// report presses, so holding a key relies on its auto repeat to keep
+// the button down.
+const holdFrames = 8
+
+// frameTime is how often a frame is emulated.
+const frameTime = time.Second / 60
+
+// messageFrames is how long a message is shown for.
+const messageFrames = 5 * 60
+
+// help is shown below the picture.
+const help = "a/b: A/B  space: select  enter: start  arrows: d-pad  q: quit"
+
+// quit is sent by readKeys when the player wants to stop.
+const quit = 0xFF
+
+// Run plays c in the terminal on in and out, normally os.Stdin and
+// os.Stdout, until the player presses q or Ctrl-C or ctx is
+// cancelled. The keys are those of the console package's window.
+func Run(ctx context.Context, c *core.Console, in, out *os.File, opts Options) error {
+	restore, err := makeRaw(int(in.Fd()))
+	if err != nil {
+		return fmt.Errorf("couldn't set up the terminal: %w", err)
+	}
+	defer restore()
+
+	// Hide the cursor and clear the screen, then undo it all.
+	out.WriteString("\x1b[?25l\x1b[2J")
+	defer out.WriteString("\x1b[0m\x1b[2J\x1b[H\x1b[?25h")
+
+	// The reader is left blocked on in when we return. It's only
+	// used by short lived command line programs, so that's fine.
+	keys := make(chan uint8, 16)
+	go readKeys(in, keys)
+
+	t := time.NewTicker(frameTime)
+	defer t.Stop()
+
+	r := opts.Runner
+	if r == nil {
+		r = frontend.Local(c)
+	}
+
+	w, h := c.Resolution()
+	var held [8]int // frames left for each button
+	var buf []byte
+	msg, msgFrames := help, 0
+	for frame := 0; ; frame++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		case k, ok := <-keys:
+			if !ok || k == quit {
+				return nil
+			}
+			for i := range held {
+				if k&(1<<i) != 0 {
+					held[i] = holdFrames
+				}
+			}
+			frame-- // no frame was run
+			continue
+		case m := <-opts.Messages:
+			msg, msgFrames = m, messageFrames
+			frame--
+			continue
+		case <-t.C:
+			if c.Paused() {
+				continue
+			}
+		}
+
+		var buttons uint8
+		for i := range held {
+			if held[i] > 0 {
+				buttons |= 1 << i
+				held[i]--
+			}
+		}
+		video, _, err := r.RunFrame(core.Inputs{Buttons: [core.PLAYERS]uint8{buttons}})
+		if err != nil {
+			return err
+		}
+		if msgFrames > 0 {
+			if msgFrames--; msgFrames == 0 {
+				msg = help
+			}
+		}
+
+		if frame%(opts.FrameSkip+1) != 0 {
+			continue
+		}
+		drawStart := time.Now()
+		// Some terminals, like bare ptys, report a size of 0.
+		cols, rows, err := size(int(out.Fd()))
+		if err != nil || cols < 1 || rows < 2 {
+			cols, rows = 80, 24
+		}
+		if opts.Sixel {
+			buf = sixel(buf[:0], video, w, h)
+		} else {
+			buf = halfBlock(buf[:0], video, w, h, cols, rows-1)
+		}
+		buf = append(buf, "\x1b[0m\r\n"...)
+		buf = append(buf, "\x1b[K"...)
+		buf = append(buf, msg...)
+		if _, err := out.Write(buf); err != nil {
+			return err
+		}
+		if opts.Sync != nil {
+			opts.Sync.ObserveFrame(time.Now())
+		}
+		if opts.Profile != nil {
+			opts.Profile.ObserveFrame(time.Since(drawStart))
+		}
+	}
+}
+
+// keyButtons are the buttons for single byte keys.
+var keyButtons = map[byte]uint8{
+	'a':  core.BUTTON_A,
+	'A':  core.BUTTON_A,
+	'b':  core.BUTTON_B,
+	'B':  core.BUTTON_B,
+	' ':  core.BUTTON_SELECT,
+	'\r': core.BUTTON_START,
+	'\n': core.BUTTON_START,
+}
+
+// arrowButtons are the buttons for the final byte of the arrow keys'
+// escape sequences, ESC [ A and so on.
+var arrowButtons = map[byte]uint8{
+	'A': core.BUTTON_UP,
+	'B': core.BUTTON_DOWN,
+	'C': core.BUTTON_RIGHT,
+	'D': core.BUTTON_LEFT,
+}
+
+// readKeys sends the buttons for each key read from in to keys, or
+// quit, and closes keys when in runs out.
+func readKeys(in *os.File, keys chan<- uint8) {
+	defer close(keys)
+
+	b := make([]byte, 64)
+	for {
+		n, err := in.Read(b)
+		if err != nil {
+			return
+		}
+		for _, k := range parseKeys(b[:n]) {
+			keys <- k
+		}
+	}
+}
+
+// parseKeys returns the buttons pressed by the keys in b.
+func parseKeys(b []byte) []uint8 {
+	var ret []uint8
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == 'q' || b[i] == 'Q' || b[i] == 0x03: // Ctrl-C
+			return append(ret, quit)
+		case b[i] == 0x1b && i+2 < len(b) && (b[i+1] == '[' || b[i+1] == 'O'):
+			if btn, ok := arrowButtons[b[i+2]]; ok {
+				ret = append(ret, btn)
+			}
+			i += 2
+		default:
+			if btn, ok := keyButtons[b[i]]; ok {
+				ret = append(ret, btn)
+			}
+		}
+	}
+	return ret
+}
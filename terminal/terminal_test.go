@@ -0,0 +1,75 @@
+package terminal
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bdwalton/gintendo/core"
+)
+
+func TestParseKeys(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []uint8
+	}{
+		{"a", []uint8{core.BUTTON_A}},
+		{"b \r", []uint8{core.BUTTON_B, core.BUTTON_SELECT, core.BUTTON_START}},
+		{"\x1b[A\x1b[D", []uint8{core.BUTTON_UP, core.BUTTON_LEFT}},
+		{"\x1bOC", []uint8{core.BUTTON_RIGHT}},
+		{"xq", []uint8{quit}},
+		{"a\x03b", []uint8{core.BUTTON_A, quit}},
+	}
+
+	for i, tc := range cases {
+		if got := parseKeys([]byte(tc.in)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d: parseKeys(%q) = %v, wanted %v", i, tc.in, got, tc.want)
+		}
+	}
+}
+
+// testPicture returns a w by h RGBA picture with a red top half and a
+// blue bottom half.
+func testPicture(w, h int) []byte {
+	pix := make([]byte, w*h*4)
+	for i := 0; i < w*h; i++ {
+		if i < w*h/2 {
+			pix[i*4] = 0xFF
+		} else {
+			pix[i*4+2] = 0xFF
+		}
+		pix[i*4+3] = 0xFF
+	}
+	return pix
+}
+
+func TestHalfBlock(t *testing.T) {
+	got := string(halfBlock(nil, testPicture(4, 4), 4, 4, 80, 24))
+	want := "\x1b[H" +
+		"\x1b[38;2;255;0;0m\x1b[48;2;255;0;0m▀▀▀▀\x1b[0m\r\n" +
+		"\x1b[38;2;0;0;255m\x1b[48;2;0;0;255m▀▀▀▀\x1b[0m\r\n"
+	if got != want {
+		t.Errorf("halfBlock() = %q, wanted %q", got, want)
+	}
+
+	// Pictures are shrunk to fit.
+	got = string(halfBlock(nil, testPicture(8, 8), 8, 8, 4, 2))
+	if n := strings.Count(got, "▀"); n != 8 {
+		t.Errorf("halfBlock() drew %d blocks in a 4x2 terminal, wanted 8", n)
+	}
+
+	if got := halfBlock(nil, testPicture(8, 8), 8, 8, 0, 0); len(got) != 0 {
+		t.Errorf("halfBlock() drew %q in a 0x0 terminal, wanted nothing", got)
+	}
+}
+
+func TestSixel(t *testing.T) {
+	got := sixel(nil, testPicture(5, 6), 5, 6)
+	// Red fills the top three rows (bits 0-2) of every column and
+	// blue the bottom three, run length encoded.
+	want := "\x1b[H\x1bPq#0;2;100;0;0#1;2;0;0;100#0!5F$#1!5w-\x1b\\"
+	if !bytes.Equal(got, []byte(want)) {
+		t.Errorf("sixel() = %q, wanted %q", got, want)
+	}
+}
@@ -0,0 +1,141 @@
+; cputrace.s runs through most of the official 6502 instructions and
+; addressing modes, including the page crossing and branch timing
+; cases, for TestTraceLog. It's assembled for $C000 into cputrace.bin
+; with mos6502/asm, and cputrace.log is its trace in nestest.log's
+; format.
+
+start:	SEI
+	CLD
+	LDX #$FF
+	TXS
+	LDX #$05
+	LDY #$80
+	LDA #$00
+	STA $10
+	LDA #$02
+	STA $11		; ($10) points at $0200
+	LDA #$7F
+	STA ($10),Y	; $0280
+	LDA #$00
+	LDA $0280
+
+; Arithmetic and flags
+	CLC
+	ADC #$01	; $80, overflow
+	BVS over
+	JMP fail
+over:	CLV
+	SEC
+	SBC #$01	; $7F, overflow again
+	BVS arith
+	JMP fail
+arith:	SEC
+	LDA #$50
+	SBC #$F0	; borrows
+	ADC #$0F
+	CMP #$70
+	CPX #$05
+	CPY #$81
+	BIT $0280
+	AND #$0F
+	ORA #$30
+	EOR #$FF
+
+; Shifts and rotates, on A and memory
+	LDA #$81
+	ASL A
+	ROL A
+	LSR A
+	ROR A
+	STA $20
+	ASL $20
+	ROL $20,X	; $25
+	LSR $0280
+	ROR $027B,X	; $0280
+	INC $20
+	DEC $0280
+	INX
+	DEY
+	DEX
+	INY
+
+; Indexed loads, crossing pages
+	LDX #$FF
+	LDY #$FF
+	LDA $0181,X	; $0280, crosses
+	LDA $0201,X	; $0300, crosses
+	LDA $0200,Y	; $02FF, doesn't
+	LDA ($10),Y	; $02FF, doesn't
+	LDA #$90
+	STA $10
+	LDA #$FF
+	STA $11
+	LDY #$81
+	LDA ($10),Y	; $FF90, wrapping to $0011
+	LDX #$02
+	LDA ($0E,X)	; ($10)
+	STX $0300
+	LDX $0300
+	LDY $0300,X	; $0302
+	STY $30,X	; $32
+	LDA $30,X
+	STX $40,Y
+	LDX $40,Y
+
+; Transfers and the stack
+	TAX
+	TAY
+	TSX
+	TXA
+	TYA
+	PHA
+	PHP
+	PLA
+	PLP
+	LDA #$00
+	TXS
+
+; Branches: not taken, taken, and taken across a page
+	LDA #$01
+	BEQ fail
+	BNE near
+	JMP fail
+near:	BPL far
+	JMP fail
+
+; Subroutines and jumps
+back:	JSR sub
+	LDA #<vector
+	STA $50
+	LDA #>vector
+	STA $51
+	JMP ($0050)
+fail:	JMP fail
+sub:	LDA #$42
+	RTS
+	.byte 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0
+	.byte 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0
+	.byte 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0
+	.byte 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0
+	.byte 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0
+	.byte 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0
+far:	CLC
+	BCC back
+vector:	SED
+	CLD
+	SEC
+	CLC
+	CLI
+	SEI
+	NOP
+	PHP
+	LDA #$C0
+	PHA
+	LDA #>done
+	PHA
+	LDA #<done
+	PHA
+	LDA #$00
+	PHA
+	RTI		; to done, with P from the stack
+done:	JMP done
@@ -0,0 +1,82 @@
+package testroms
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// WriteJSON writes r as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// The JUnit XML schema, as much of it as CI systems read.
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Skipped   *junitMessage `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes r as a JUnit XML test suite, a test case per ROM.
+func (r *Report) WriteJUnit(w io.Writer) error {
+	s := junitSuite{
+		Name:     r.Dir,
+		Tests:    len(r.Results),
+		Failures: r.Failed,
+		Errors:   r.Errors,
+		Skipped:  r.Skipped,
+	}
+	var total float64
+	for _, res := range r.Results {
+		total += res.Seconds
+		c := junitCase{Name: res.ROM, ClassName: r.Dir, Time: seconds(res.Seconds)}
+		msg := &junitMessage{Message: res.Message}
+		switch res.Status {
+		case STATUS_FAIL:
+			c.Failure = msg
+		case STATUS_ERROR:
+			c.Error = msg
+		case STATUS_SKIP:
+			c.Skipped = msg
+		}
+		s.Cases = append(s.Cases, c)
+	}
+	s.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
@@ -0,0 +1,235 @@
+// Package testroms runs a directory of test ROMs headlessly and
+// reports how each did, for tracking compatibility outside go test.
+//
+// ROMs that report through cartridge RAM, as blargg's do, are judged
+// by what they report (see package blargg). Others need an expected
+// picture: a file named hashes.txt in the directory lists them, a ROM
+// a line,
+//
+//	# rom frames crc32
+//	palette.nes 120 1a2b3c4d
+//
+// and the ROM passes if the picture's hash (see
+// core.Console.FrameHash) matches after running that many frames.
+package testroms
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bdwalton/gintendo/blargg"
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/mappers"
+)
+
+// HASH_FILE is the name of the file of expected hashes in a directory
+// of ROMs.
+const HASH_FILE = "hashes.txt"
+
+// The outcomes of a ROM.
+const (
+	STATUS_PASS  = "pass"
+	STATUS_FAIL  = "fail"
+	STATUS_ERROR = "error" // It couldn't be run or never reported a result
+	STATUS_SKIP  = "skip"  // It needs a mapper gintendo doesn't have
+)
+
+// Result is how one ROM did.
+type Result struct {
+	ROM     string  `json:"rom"`
+	Status  string  `json:"status"`
+	Method  string  `json:"method,omitempty"` // "blargg" or "hash"
+	Message string  `json:"message,omitempty"`
+	Seconds float64 `json:"seconds"`
+}
+
+// Report is the results for a directory, sorted by ROM.
+type Report struct {
+	Dir     string   `json:"dir"`
+	Passed  int      `json:"passed"`
+	Failed  int      `json:"failed"`
+	Errors  int      `json:"errors"`
+	Skipped int      `json:"skipped"`
+	Results []Result `json:"results"`
+}
+
+// OK reports whether no ROM failed or had an error.
+func (r *Report) OK() bool {
+	return r.Failed == 0 && r.Errors == 0
+}
+
+// expectation is a line of a hash file.
+type expectation struct {
+	frames int
+	crc32  uint32
+}
+
+// Run runs every .nes ROM in dir, jobs at a time, or one per CPU if
+// jobs is 0.
+func Run(dir string, jobs int) (*Report, error) {
+	roms, err := filepath.Glob(filepath.Join(dir, "*.nes"))
+	if err != nil {
+		return nil, err
+	}
+	if len(roms) == 0 {
+		return nil, fmt.Errorf("no .nes files in %s", dir)
+	}
+	sort.Strings(roms)
+
+	hashes, err := readHashes(filepath.Join(dir, HASH_FILE))
+	if err != nil {
+		return nil, err
+	}
+
+	if jobs < 1 {
+		jobs = runtime.NumCPU()
+	}
+	rep := &Report{Dir: dir, Results: make([]Result, len(roms))}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for j := 0; j < jobs; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				exp, ok := hashes[filepath.Base(roms[i])]
+				rep.Results[i] = runROM(roms[i], exp, ok)
+			}
+		}()
+	}
+	for i := range roms {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for _, r := range rep.Results {
+		switch r.Status {
+		case STATUS_PASS:
+			rep.Passed++
+		case STATUS_FAIL:
+			rep.Failed++
+		case STATUS_ERROR:
+			rep.Errors++
+		case STATUS_SKIP:
+			rep.Skipped++
+		}
+	}
+
+	return rep, nil
+}
+
+// runROM runs rom, checking its picture against exp if hashed is set
+// and its blargg result otherwise.
+func runROM(rom string, exp expectation, hashed bool) Result {
+	start := time.Now()
+	res := Result{ROM: filepath.Base(rom), Method: "blargg"}
+	var err error
+	if hashed {
+		res.Method = "hash"
+		err = checkHash(rom, exp)
+	} else {
+		var br blargg.Result
+		if br, err = blargg.Run(rom); err == nil {
+			res.Message = strings.TrimSpace(br.Text)
+			if !br.Passed() {
+				err = fmt.Errorf("code %d", br.Status)
+			}
+		}
+	}
+	res.Seconds = time.Since(start).Seconds()
+
+	var mismatch *hashMismatch
+	switch {
+	case err == nil:
+		res.Status = STATUS_PASS
+	case errors.Is(err, mappers.ErrUnknownMapper):
+		res.Status = STATUS_SKIP
+		res.Message = err.Error()
+	case errors.As(err, &mismatch):
+		res.Status = STATUS_FAIL
+		res.Message = err.Error()
+	case res.Message != "":
+		// The ROM reported a failure.
+		res.Status = STATUS_FAIL
+		res.Message = fmt.Sprintf("%v: %s", err, res.Message)
+	default:
+		res.Status = STATUS_ERROR
+		res.Message = err.Error()
+	}
+
+	return res
+}
+
+type hashMismatch struct {
+	exp expectation
+	got uint32
+}
+
+func (h *hashMismatch) Error() string {
+	return fmt.Sprintf("frame %d hash is %08x, want %08x", h.exp.frames, h.got, h.exp.crc32)
+}
+
+func checkHash(rom string, exp expectation) error {
+	m, err := mappers.Load(rom)
+	if err != nil {
+		return err
+	}
+	c := core.New(m)
+	for f := 0; f < exp.frames; f++ {
+		c.RunFrame(core.Inputs{})
+	}
+	if got := c.FrameHash(); got != exp.crc32 {
+		return &hashMismatch{exp, got}
+	}
+	return nil
+}
+
+// readHashes reads a hash file, keyed by ROM name. A missing file has
+// no hashes.
+func readHashes(path string) (map[string]expectation, error) {
+	hashes := make(map[string]expectation)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return hashes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: want rom, frames and crc32", path, n)
+		}
+		frames, err := strconv.Atoi(fields[1])
+		if err != nil || frames < 1 {
+			return nil, fmt.Errorf("%s:%d: bad frame count %q", path, n, fields[1])
+		}
+		crc, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: bad crc32 %q", path, n, fields[2])
+		}
+		hashes[fields[0]] = expectation{frames, uint32(crc)}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,110 @@
+package testroms
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bdwalton/gintendo/blargg"
+	"github.com/bdwalton/gintendo/core"
+	"github.com/bdwalton/gintendo/mappers"
+)
+
+const testROM = "../testdata/ram_after_reset.nes"
+
+// frameHash is the hash of testROM's picture after frames.
+func frameHash(t *testing.T, frames int) uint32 {
+	t.Helper()
+
+	m, err := mappers.Load(testROM)
+	if err != nil {
+		t.Fatalf("couldn't load testdata ROM: %v", err)
+	}
+	c := core.New(m)
+	for f := 0; f < frames; f++ {
+		c.RunFrame(core.Inputs{})
+	}
+	return c.FrameHash()
+}
+
+func TestRun(t *testing.T) {
+	defer func(n int) { blargg.MaxFrames = n }(blargg.MaxFrames)
+	blargg.MaxFrames = 10
+
+	rom, err := os.ReadFile(testROM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"a_good.nes":    rom,
+		"b_bad.nes":     rom,
+		"c_blargg.nes":  rom, // Doesn't report a result
+		"d_garbage.nes": []byte("not a ROM"),
+		HASH_FILE:       []byte(fmt.Sprintf("# rom frames crc32\na_good.nes 5 %08x\nb_bad.nes 5 %08x\n", frameHash(t, 5), ^frameHash(t, 5))),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rep, err := Run(dir, 2)
+	if err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	want := []struct{ rom, status, method string }{
+		{"a_good.nes", STATUS_PASS, "hash"},
+		{"b_bad.nes", STATUS_FAIL, "hash"},
+		{"c_blargg.nes", STATUS_ERROR, "blargg"},
+		{"d_garbage.nes", STATUS_ERROR, "blargg"},
+	}
+	if len(rep.Results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(rep.Results), len(want))
+	}
+	for i, w := range want {
+		if r := rep.Results[i]; r.ROM != w.rom || r.Status != w.status || r.Method != w.method {
+			t.Errorf("Results[%d] = %+v, want %s %s by %s", i, r, w.rom, w.status, w.method)
+		}
+	}
+	if rep.Passed != 1 || rep.Failed != 1 || rep.Errors != 2 || rep.OK() {
+		t.Errorf("got %d passed, %d failed, %d errors, OK() = %t", rep.Passed, rep.Failed, rep.Errors, rep.OK())
+	}
+
+	var js bytes.Buffer
+	if err := rep.WriteJSON(&js); err != nil {
+		t.Fatalf("WriteJSON() = %v", err)
+	}
+	var back Report
+	if err := json.Unmarshal(js.Bytes(), &back); err != nil || len(back.Results) != 4 {
+		t.Errorf("WriteJSON() wrote %s, which reads as %+v, %v", js.String(), back, err)
+	}
+
+	var ju bytes.Buffer
+	if err := rep.WriteJUnit(&ju); err != nil {
+		t.Fatalf("WriteJUnit() = %v", err)
+	}
+	var suite junitSuite
+	if err := xml.Unmarshal(ju.Bytes(), &suite); err != nil {
+		t.Fatalf("WriteJUnit() wrote bad XML: %v", err)
+	}
+	if suite.Tests != 4 || suite.Failures != 1 || suite.Errors != 2 || suite.Cases[1].Failure == nil {
+		t.Errorf("WriteJUnit() wrote %s", ju.String())
+	}
+}
+
+func TestReadHashesErrors(t *testing.T) {
+	for _, bad := range []string{"a.nes 5", "a.nes x 1234", "a.nes 0 1234", "a.nes 5 xyz"} {
+		path := filepath.Join(t.TempDir(), HASH_FILE)
+		os.WriteFile(path, []byte(bad), 0644)
+		if _, err := readHashes(path); err == nil || !strings.Contains(err.Error(), ":1:") {
+			t.Errorf("readHashes(%q) = %v, want an error on line 1", bad, err)
+		}
+	}
+}
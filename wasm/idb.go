@@ -0,0 +1,104 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall/js"
+)
+
+const (
+	dbName    = "gintendo"
+	storeName = "saves"
+)
+
+// saveStore keeps battery backed RAM in an IndexedDB object store,
+// keyed by ROM name.
+type saveStore struct {
+	db js.Value
+}
+
+func openSaveStore() (*saveStore, error) {
+	idb := js.Global().Get("indexedDB")
+	if !idb.Truthy() {
+		return nil, errors.New("IndexedDB isn't available")
+	}
+
+	db, err := await(idb.Call("open", dbName, 1), func(db js.Value) {
+		db.Call("createObjectStore", storeName)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open IndexedDB: %w", err)
+	}
+
+	return &saveStore{db: db}, nil
+}
+
+func (s *saveStore) store(mode string) js.Value {
+	return s.db.Call("transaction", storeName, mode).Call("objectStore", storeName)
+}
+
+// get returns the save stored for name, or nil if there isn't one.
+func (s *saveStore) get(name string) ([]byte, error) {
+	v, err := await(s.store("readonly").Call("get", name), nil)
+	if err != nil {
+		return nil, err
+	}
+	if v.IsUndefined() {
+		return nil, nil
+	}
+
+	b := make([]byte, v.Get("length").Int())
+	js.CopyBytesToGo(b, v)
+	return b, nil
+}
+
+// put stores sav as the save for name.
+func (s *saveStore) put(name string, sav []byte) error {
+	a := js.Global().Get("Uint8Array").New(len(sav))
+	js.CopyBytesToJS(a, sav)
+	_, err := await(s.store("readwrite").Call("put", a, name), nil)
+	return err
+}
+
+// await waits for the IndexedDB request req to finish and returns its
+// result. onupgrade, if set, handles onupgradeneeded by being passed
+// the database.
+func await(req js.Value, onupgrade func(js.Value)) (js.Value, error) {
+	type result struct {
+		v   js.Value
+		err error
+	}
+	ch := make(chan result, 1)
+
+	success := js.FuncOf(func(this js.Value, args []js.Value) any {
+		ch <- result{v: req.Get("result")}
+		return nil
+	})
+	defer success.Release()
+	req.Set("onsuccess", success)
+
+	failure := js.FuncOf(func(this js.Value, args []js.Value) any {
+		msg := "unknown error"
+		if e := req.Get("error"); e.Truthy() {
+			msg = e.Get("message").String()
+		}
+		ch <- result{err: errors.New(msg)}
+		return nil
+	})
+	defer failure.Release()
+	req.Set("onerror", failure)
+
+	if onupgrade != nil {
+		upgrade := js.FuncOf(func(this js.Value, args []js.Value) any {
+			onupgrade(req.Get("result"))
+			return nil
+		})
+		defer upgrade.Release()
+		req.Set("onupgradeneeded", upgrade)
+	}
+
+	r := <-ch
+	return r.v, r.err
+}
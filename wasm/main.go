@@ -0,0 +1,172 @@
+//go:build js && wasm
+
+// Command wasm runs gintendo in a web browser. Build it and copy Go's
+// JavaScript support file next to index.html:
+//
+//	GOOS=js GOARCH=wasm go build -o wasm/gintendo.wasm ./wasm
+//	cp "$(go env GOROOT)/misc/wasm/wasm_exec.js" wasm/
+//
+// (Newer Go releases keep wasm_exec.js in lib/wasm instead.) Then
+// serve the wasm directory over HTTP. A ROM is chosen with the page's
+// file input, or fetched from the URL in a rom query parameter:
+// index.html?rom=games/homebrew.nes. Battery backed RAM is kept in
+// the browser's IndexedDB, keyed by the ROM's name.
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"syscall/js"
+	"time"
+
+	"github.com/bdwalton/gintendo/console"
+	"github.com/bdwalton/gintendo/mappers"
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// saveInterval is how often battery backed RAM is checked for changes
+// and stored. There's no reliable chance to save when the page closes.
+const saveInterval = 5 * time.Second
+
+func main() {
+	data, name, err := romFromPage()
+	if err != nil {
+		showError(err)
+		return
+	}
+
+	m, err := mappers.LoadBytes(data, name)
+	if err != nil {
+		showError(fmt.Errorf("couldn't load %s: %w", name, err))
+		return
+	}
+
+	gintendo := console.New(m)
+
+	saves, err := openSaveStore()
+	if err != nil {
+		log.Printf("Saves are disabled: %v", err)
+	} else {
+		if sav, err := saves.get(name); err != nil {
+			log.Printf("Couldn't load save RAM: %v", err)
+		} else if sav != nil {
+			if err := gintendo.ReadSRAM(bytes.NewReader(sav)); err != nil {
+				log.Printf("Couldn't load save RAM: %v", err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go gintendo.Run(ctx)
+	if saves != nil {
+		go autosave(ctx, gintendo, saves, name)
+	}
+
+	if err := ebiten.RunGame(gintendo); err != nil {
+		showError(err)
+	}
+}
+
+// romFromPage returns the ROM named by the page's rom query
+// parameter or, without one, waits for one to be picked with the file
+// input. index.html passes it to the gintendoLoadROM function.
+func romFromPage() ([]byte, string, error) {
+	q, _ := url.ParseQuery(js.Global().Get("location").Get("search").String())
+	if u := q.Get("rom"); u != "" {
+		return fetchROM(u)
+	}
+
+	type rom struct {
+		data []byte
+		name string
+	}
+	ch := make(chan rom, 1)
+
+	load := js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) < 2 {
+			return nil
+		}
+		b := make([]byte, args[0].Get("length").Int())
+		js.CopyBytesToGo(b, args[0])
+		select {
+		case ch <- rom{b, args[1].String()}:
+		default: // Only the first ROM is used
+		}
+		return nil
+	})
+	defer load.Release()
+	js.Global().Set("gintendoLoadROM", load)
+
+	r := <-ch
+	return r.data, r.name, nil
+}
+
+// fetchROM downloads the ROM at u, which may be relative to the page.
+func fetchROM(u string) ([]byte, string, error) {
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't fetch ROM: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("couldn't fetch ROM %s: %s", u, resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't fetch ROM: %w", err)
+	}
+
+	name := u
+	if pu, err := url.Parse(u); err == nil {
+		name = path.Base(pu.Path)
+	}
+	return b, name, nil
+}
+
+// autosave stores battery backed RAM every saveInterval if it has
+// changed, until ctx is cancelled.
+func autosave(ctx context.Context, b *console.Bus, s *saveStore, name string) {
+	var last []byte
+	t := time.NewTicker(saveInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		var buf bytes.Buffer
+		if err := b.WriteSRAM(&buf); err != nil {
+			log.Printf("Couldn't read save RAM: %v", err)
+			continue
+		}
+		if buf.Len() == 0 || bytes.Equal(buf.Bytes(), last) {
+			continue
+		}
+
+		if err := s.put(name, buf.Bytes()); err != nil {
+			log.Printf("Couldn't store save RAM: %v", err)
+			continue
+		}
+		last = buf.Bytes()
+	}
+}
+
+// showError logs err and shows it on the page.
+func showError(err error) {
+	log.Print(err)
+	if el := js.Global().Get("document").Call("getElementById", "status"); el.Truthy() {
+		el.Set("textContent", err.Error())
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !noebiten
+
+package main
+
+import (
+	"github.com/bdwalton/gintendo/console"
+	"github.com/bdwalton/gintendo/frontend"
+)
+
+func init() {
+	frontends["window"] = func(cfg frontendConfig) frontend.Frontend {
+		return &console.Window{
+			SaveFile: cfg.saveFile,
+			WatchROM: cfg.watchROM,
+			ROM:      cfg.rom,
+			Patch:    cfg.patch,
+			Fallback: cfg.fallback,
+			Message:  cfg.message,
+			Metrics:  cfg.metrics,
+			Sync:     cfg.sync,
+			Profile:  cfg.profile,
+			Runner:   cfg.runner,
+			Messages: cfg.messages,
+		}
+	}
+	defaultFrontend = "window"
+}